@@ -0,0 +1,23 @@
+"""Writes labeled incidents with their affected entities' metrics to a CSV,
+one row per entity, metric and 10s bucket, for training detectors.
+
+    ORCHESTRATOR_URL=http://localhost:8081 API_KEY=... python examples/export_labels.py labels.csv
+"""
+
+import csv
+import os
+import sys
+
+from microcloud_client import Client
+
+client = Client(os.environ.get("ORCHESTRATOR_URL", "http://localhost:8081"), api_key=os.environ.get("API_KEY"))
+
+with open(sys.argv[1] if len(sys.argv) > 1 else "labels.csv", "w", newline="") as f:
+    out = csv.writer(f)
+    out.writerow(["incident_id", "rule_name", "label", "entity_id", "metric", "t", "avg", "max"])
+    for record in client.iter_labeled_incidents(window="15m", bucket="10s"):
+        incident = record["incident"]
+        for entity_id, series in record["series"].items():
+            for metric, points in series.items():
+                for p in points:
+                    out.writerow([incident["id"]["value"], incident.get("rule_name", ""), record["label"], entity_id, metric, p["t"], p["avg"], p["max"]])
@@ -0,0 +1,20 @@
+"""Prints incidents as they open and resolve, after replaying the last 15
+minutes, and lists the actions waiting for approval.
+
+    ORCHESTRATOR_URL=http://localhost:8081 API_KEY=... python examples/watch_incidents.py
+"""
+
+import os
+
+from microcloud_client import Client, subscribe
+
+client = Client(os.environ.get("ORCHESTRATOR_URL", "http://localhost:8081"), api_key=os.environ.get("API_KEY"))
+
+for action in client.list_pending_actions(limit=20):
+    print("pending action", action["id"]["value"], action.get("reason", ""))
+
+for event in subscribe(client, history="15m"):
+    if event.type != "incident":
+        continue
+    incident = event.payload
+    print(incident["id"]["value"], "resolved" if incident.get("resolved") else "open", incident.get("title", ""))
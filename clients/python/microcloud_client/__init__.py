@@ -0,0 +1,10 @@
+"""Minimal client for the orchestrator API.
+
+Uses only the standard library: RPCs are Connect JSON over HTTP POST and the
+live stream is Server-Sent Events, so no generated code is needed.
+"""
+
+from .client import Client, ClientError
+from .stream import StreamEvent, poll, subscribe
+
+__all__ = ["Client", "ClientError", "StreamEvent", "poll", "subscribe"]
@@ -0,0 +1,178 @@
+"""Connect JSON calls and exports against the orchestrator."""
+
+import json
+import urllib.error
+import urllib.parse
+import urllib.request
+from datetime import datetime
+from typing import Any, Dict, Iterator, List, Optional
+
+# Incident labels accepted by label_incident, as proto enum names
+LABELS = {
+    "true_positive": "INCIDENT_LABEL_TRUE_POSITIVE",
+    "false_positive": "INCIDENT_LABEL_FALSE_POSITIVE",
+    "duplicate": "INCIDENT_LABEL_DUPLICATE",
+    "expected_maintenance": "INCIDENT_LABEL_EXPECTED_MAINTENANCE",
+}
+
+
+class ClientError(Exception):
+    """A failed call. code is the Connect error code, or the HTTP status
+    for plain endpoints."""
+
+    def __init__(self, code: str, message: str):
+        super().__init__(f"{code}: {message}")
+        self.code = code
+        self.message = message
+
+
+class Client:
+    """Client of the orchestrator at base_url, e.g. http://localhost:8081.
+
+    api_key is sent as X-API-Key; environment and api_version select another
+    environment of the installation and a pinned API version.
+    """
+
+    def __init__(
+        self,
+        base_url: str,
+        api_key: Optional[str] = None,
+        environment: Optional[str] = None,
+        api_version: Optional[str] = None,
+        timeout: float = 30.0,
+    ):
+        self.base_url = base_url.rstrip("/")
+        self.api_key = api_key
+        self.environment = environment
+        self.api_version = api_version
+        self.timeout = timeout
+
+    def headers(self) -> Dict[str, str]:
+        h = {}
+        if self.api_key:
+            h["X-API-Key"] = self.api_key
+        if self.environment:
+            h["X-Environment"] = self.environment
+        if self.api_version:
+            h["Api-Version"] = self.api_version
+        return h
+
+    def call(self, service: str, method: str, request: Optional[Dict[str, Any]] = None) -> Dict[str, Any]:
+        """Calls an RPC, e.g. call("ops.v1.ActionService", "ListPendingActions").
+
+        Requests and responses are the proto messages in their JSON form;
+        fields may be sent in camelCase or as written in the .proto files.
+        """
+        body = json.dumps(request or {}).encode()
+        req = urllib.request.Request(
+            f"{self.base_url}/{service}/{method}",
+            data=body,
+            method="POST",
+            headers={
+                **self.headers(),
+                "Content-Type": "application/json",
+                "Connect-Protocol-Version": "1",
+            },
+        )
+        try:
+            with urllib.request.urlopen(req, timeout=self.timeout) as resp:
+                return json.load(resp)
+        except urllib.error.HTTPError as e:
+            raise _connect_error(e) from None
+
+    # Actions
+
+    def list_pending_actions(self, limit: int = 0) -> List[Dict[str, Any]]:
+        resp = self.call("ops.v1.ActionService", "ListPendingActions", {"limit": limit})
+        return resp.get("actions", [])
+
+    def approve_action(self, action_id: str) -> Dict[str, Any]:
+        return self.call("ops.v1.ActionService", "ApproveAction", {"actionId": {"value": action_id}})
+
+    def reject_action(self, action_id: str, reason: str = "") -> Dict[str, Any]:
+        return self.call(
+            "ops.v1.ActionService", "RejectAction", {"actionId": {"value": action_id}, "reason": reason}
+        )
+
+    # Incidents
+
+    def label_incident(self, incident_id: str, label: str, operator: str = "", note: str = "") -> Dict[str, Any]:
+        """Records a verdict on an incident; label is a key of LABELS."""
+        if label not in LABELS:
+            raise ValueError(f"invalid label {label!r}")
+        return self.call(
+            "ops.v1.IncidentService",
+            "LabelIncident",
+            {
+                "incidentId": {"value": incident_id},
+                "label": LABELS[label],
+                "operator": operator,
+                "note": note,
+            },
+        )
+
+    def iter_labeled_incidents(
+        self,
+        label: Optional[str] = None,
+        since: Optional[datetime] = None,
+        limit: Optional[int] = None,
+        window: Optional[str] = None,
+        bucket: Optional[str] = None,
+        metrics: Optional[List[str]] = None,
+    ) -> Iterator[Dict[str, Any]]:
+        """Yields labeled incidents with the metrics around them, oldest
+        label first, from /api/incidents/labels/export.
+
+        since must be timezone-aware; window and bucket are Go durations
+        such as "15m" and "10s".
+        """
+        params: List = []
+        if label:
+            params.append(("label", label))
+        if since:
+            params.append(("since", since.isoformat(timespec="seconds")))
+        if limit:
+            params.append(("limit", str(limit)))
+        if window:
+            params.append(("window", window))
+        if bucket:
+            params.append(("bucket", bucket))
+        for m in metrics or []:
+            params.append(("metric", m))
+        with self.get("/api/incidents/labels/export", params) as resp:
+            for line in resp:
+                if line.strip():
+                    yield json.loads(line)
+
+    # Rules
+
+    def list_rules(self, enabled_only: bool = False) -> List[Dict[str, Any]]:
+        resp = self.call("ops.v1.RuleService", "ListRules", {"enabledOnly": enabled_only})
+        return resp.get("rules", [])
+
+    # Streaming
+
+    def create_stream_token(self) -> str:
+        """Mints a short-lived token for the stream endpoints, which take
+        tokens rather than API keys."""
+        return self.call("ops.v1.AuthService", "CreateStreamToken")["token"]
+
+    def get(self, path: str, params: Optional[List] = None, headers: Optional[Dict[str, str]] = None, timeout: Optional[float] = None):
+        """Opens a GET on a plain HTTP endpoint; the caller closes it."""
+        url = self.base_url + path
+        if params:
+            url += "?" + urllib.parse.urlencode(params)
+        req = urllib.request.Request(url, headers={**self.headers(), **(headers or {})})
+        try:
+            return urllib.request.urlopen(req, timeout=self.timeout if timeout is None else timeout)
+        except urllib.error.HTTPError as e:
+            raise ClientError(str(e.code), e.read().decode(errors="replace").strip()) from None
+
+
+def _connect_error(e: urllib.error.HTTPError) -> ClientError:
+    body = e.read()
+    try:
+        err = json.loads(body)
+        return ClientError(err.get("code", str(e.code)), err.get("message", ""))
+    except ValueError:
+        return ClientError(str(e.code), body.decode(errors="replace").strip())
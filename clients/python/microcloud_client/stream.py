@@ -0,0 +1,95 @@
+"""Readers of the orchestrator's live stream: SSE, and long polling for
+networks that break SSE."""
+
+import json
+import logging
+import time
+from typing import Any, Iterator, NamedTuple, Optional
+
+from .client import Client, ClientError
+
+log = logging.getLogger(__name__)
+
+MAX_RETRY = 30.0
+
+# The stream sends a keepalive every 15s, so a read this long means the
+# connection is gone
+READ_TIMEOUT = 60.0
+
+
+class StreamEvent(NamedTuple):
+    """One stream message: type is "metrics", "incident", "action",
+    "action_result" or "event"; payload is the message's JSON form."""
+
+    type: str
+    payload: Any
+
+
+def subscribe(client: Client, history: Optional[str] = None, retry: float = 1.0) -> Iterator[StreamEvent]:
+    """Yields messages from /api/stream forever, reconnecting after drops
+    with backoff up to 30s.
+
+    history (e.g. "15m") replays incidents and actions from before the
+    connect; replayed items are yielded as ordinary messages, oldest first.
+    """
+    wait = retry
+    while True:
+        try:
+            for event in _read_stream(client, history):
+                wait = retry
+                yield event
+        except (OSError, ClientError) as e:
+            log.warning("stream disconnected: %s", e)
+        time.sleep(wait)
+        wait = min(wait * 2, MAX_RETRY)
+
+
+def _read_stream(client: Client, history: Optional[str]) -> Iterator[StreamEvent]:
+    params = [("history", history)] if history else None
+    with client.get("/api/stream", params, {"Accept": "text/event-stream"}, READ_TIMEOUT) as resp:
+        data = []
+        for raw in resp:
+            line = raw.decode().rstrip("\r\n")
+            if line.startswith("data:"):
+                data.append(line[5:].lstrip())
+                continue
+            # Messages end with a blank line; comments (": keepalive") and
+            # other fields are skipped
+            if line or not data:
+                continue
+            message = json.loads("\n".join(data))
+            data = []
+            yield from _unpack(message)
+
+
+def _unpack(message: dict) -> Iterator[StreamEvent]:
+    if message["type"] != "history":
+        yield StreamEvent(message["type"], message.get("payload"))
+        return
+    page = message["payload"]
+    for item in page.get("items", []):
+        yield StreamEvent(item["type"], item.get("payload"))
+    if page.get("error"):
+        log.warning("stream history cut short: %s", page["error"])
+
+
+def poll(client: Client, timeout: int = 25) -> Iterator[StreamEvent]:
+    """Yields messages from /api/poll forever, for networks that break SSE.
+
+    Each poll waits up to timeout seconds (at most 55) for new messages.
+    After a restart or a long gap the orchestrator resets the cursor and
+    starts over from the initial state, as on an SSE connect.
+    """
+    cursor = ""
+    while True:
+        try:
+            params = [("cursor", cursor), ("timeout", f"{timeout}s")]
+            with client.get("/api/poll", params, timeout=timeout + 10) as resp:
+                body = json.load(resp)
+        except (OSError, ClientError) as e:
+            log.warning("poll failed: %s", e)
+            time.sleep(1)
+            continue
+        cursor = body["cursor"]
+        for message in body.get("events") or []:
+            yield from _unpack(message)
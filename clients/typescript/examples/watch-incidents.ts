@@ -0,0 +1,27 @@
+// Prints incidents as they open and resolve, after replaying the last 15
+// minutes, and lists the actions waiting for approval.
+//
+//   ORCHESTRATOR_URL=http://localhost:8081 API_KEY=... npx tsx examples/watch-incidents.ts
+import { createOrchestratorClient, decodeIncident, subscribe } from '../src/index'
+
+const options = {
+  baseUrl: process.env.ORCHESTRATOR_URL ?? 'http://localhost:8081',
+  apiKey: process.env.API_KEY,
+}
+
+const client = createOrchestratorClient(options)
+const { actions } = await client.actions.listPendingActions({ limit: 20 })
+for (const action of actions) {
+  console.log('pending action', action.id?.value, action.reason)
+}
+
+const abort = new AbortController()
+process.on('SIGINT', () => abort.abort())
+
+await subscribe({ ...options, history: '15m', signal: abort.signal }, (event) => {
+  if (event.type !== 'incident') {
+    return
+  }
+  const incident = decodeIncident(event.payload)
+  console.log(incident.id?.value, incident.resolved ? 'resolved' : 'open', incident.title)
+})
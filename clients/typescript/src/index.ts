@@ -0,0 +1,51 @@
+import { createClient, type Client, type Transport } from '@connectrpc/connect'
+
+import {
+  ActionService,
+  AdminService,
+  AuthService,
+  IncidentService,
+  RuleService,
+  SimulationService,
+  SuppressionService,
+} from './gen/ops/v1/service_pb'
+import { SimulationControl } from './gen/sim/v1/control_pb'
+import { createTransport, type ClientOptions } from './options'
+
+export * from './options'
+export * from './stream'
+export * from './gen/common/v1/enums_pb'
+export * from './gen/common/v1/types_pb'
+export * from './gen/ops/v1/actions_pb'
+export * from './gen/ops/v1/incidents_pb'
+export * from './gen/ops/v1/service_pb'
+
+// Clients of every service the orchestrator serves or proxies
+export interface OrchestratorClient {
+  transport: Transport
+  actions: Client<typeof ActionService>
+  incidents: Client<typeof IncidentService>
+  rules: Client<typeof RuleService>
+  suppressions: Client<typeof SuppressionService>
+  admin: Client<typeof AdminService>
+  auth: Client<typeof AuthService>
+  simulation: Client<typeof SimulationService>
+  simulationControl: Client<typeof SimulationControl>
+}
+
+// createOrchestratorClient returns clients of every orchestrator service
+// sharing one transport
+export function createOrchestratorClient(options: ClientOptions): OrchestratorClient {
+  const transport = createTransport(options)
+  return {
+    transport,
+    actions: createClient(ActionService, transport),
+    incidents: createClient(IncidentService, transport),
+    rules: createClient(RuleService, transport),
+    suppressions: createClient(SuppressionService, transport),
+    admin: createClient(AdminService, transport),
+    auth: createClient(AuthService, transport),
+    simulation: createClient(SimulationService, transport),
+    simulationControl: createClient(SimulationControl, transport),
+  }
+}
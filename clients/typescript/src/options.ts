@@ -0,0 +1,45 @@
+import type { Interceptor, Transport } from '@connectrpc/connect'
+import { createConnectTransport } from '@connectrpc/connect-web'
+
+export interface ClientOptions {
+  // Orchestrator URL, e.g. http://localhost:8081
+  baseUrl: string
+  // Sent as X-API-Key; leave unset when the orchestrator has no API_KEYS
+  apiKey?: string
+  // Sent as X-Environment to reach another environment of the installation
+  environment?: string
+  // Sent as Api-Version; unset uses the latest
+  apiVersion?: string
+  interceptors?: Interceptor[]
+}
+
+// headers returns the headers options asks every request to carry
+export function headers(options: ClientOptions): Record<string, string> {
+  const h: Record<string, string> = {}
+  if (options.apiKey) {
+    h['X-API-Key'] = options.apiKey
+  }
+  if (options.environment) {
+    h['X-Environment'] = options.environment
+  }
+  if (options.apiVersion) {
+    h['Api-Version'] = options.apiVersion
+  }
+  return h
+}
+
+// createTransport returns a Connect transport speaking JSON to the
+// orchestrator, which works from browsers and Node 18+ alike
+export function createTransport(options: ClientOptions): Transport {
+  const withHeaders: Interceptor = (next) => async (req) => {
+    for (const [name, value] of Object.entries(headers(options))) {
+      req.header.set(name, value)
+    }
+    return next(req)
+  }
+  return createConnectTransport({
+    baseUrl: options.baseUrl,
+    useBinaryFormat: false,
+    interceptors: [withHeaders, ...(options.interceptors ?? [])],
+  })
+}
@@ -0,0 +1,133 @@
+import { fromJson, type JsonValue } from '@bufbuild/protobuf'
+
+import { ActionSchema, ActionResultSchema, type Action, type ActionResult } from './gen/ops/v1/actions_pb'
+import { IncidentSchema, type Incident } from './gen/ops/v1/incidents_pb'
+import { headers, type ClientOptions } from './options'
+
+// Message types sent on the SSE stream and returned by long polls. Every
+// message is {"type": ..., "payload": ...}.
+export type StreamEventType = 'metrics' | 'incident' | 'action' | 'action_result' | 'event' | 'history'
+
+export interface StreamEvent {
+  type: StreamEventType
+  payload: JsonValue
+}
+
+// HistoryPage is the payload of a "history" message: incidents and actions
+// from before the stream connected, oldest first. The stream is live once
+// the last page arrives.
+export interface HistoryPage {
+  page: number
+  last: boolean
+  items: StreamEvent[]
+  error?: string
+}
+
+export interface StreamOptions extends ClientOptions {
+  // Replay incidents and actions from this long ago (e.g. "15m"); the
+  // orchestrator caps it at STREAM_MAX_HISTORY
+  history?: string
+  // Wait before reconnecting after the stream drops; default 1s, doubling
+  // up to 30s while connects keep failing
+  retryMs?: number
+  signal?: AbortSignal
+}
+
+const MAX_RETRY_MS = 30_000
+
+// subscribe reads the orchestrator's SSE stream, calling onEvent for each
+// message and reconnecting until options.signal aborts. History pages are
+// unpacked, so onEvent sees their items as ordinary incident and action
+// messages. The stream is read with fetch, so the API key goes in a header;
+// browsers using EventSource instead need a token from
+// auth.createStreamToken.
+export async function subscribe(options: StreamOptions, onEvent: (event: StreamEvent) => void): Promise<void> {
+  const retryMs = options.retryMs ?? 1000
+  let wait = retryMs
+  while (!options.signal?.aborted) {
+    try {
+      await readStream(options, onEvent, () => {
+        wait = retryMs
+      })
+    } catch (e) {
+      if (options.signal?.aborted) {
+        return
+      }
+      console.warn('stream disconnected:', e)
+    }
+    await sleep(wait, options.signal)
+    wait = Math.min(wait * 2, MAX_RETRY_MS)
+  }
+}
+
+async function readStream(options: StreamOptions, onEvent: (event: StreamEvent) => void, onOpen: () => void): Promise<void> {
+  const url = new URL('/api/stream', options.baseUrl)
+  if (options.history) {
+    url.searchParams.set('history', options.history)
+  }
+  const response = await fetch(url, {
+    headers: { ...headers(options), Accept: 'text/event-stream' },
+    signal: options.signal,
+  })
+  if (!response.ok || !response.body) {
+    throw new Error(`stream: ${response.status} ${response.statusText}`)
+  }
+  onOpen()
+
+  const reader = response.body.pipeThrough(new TextDecoderStream()).getReader()
+  let buffer = ''
+  for (;;) {
+    const { value, done } = await reader.read()
+    if (done) {
+      return
+    }
+    buffer += value
+    // Messages end with a blank line; comments (": keepalive") are skipped
+    let end: number
+    while ((end = buffer.indexOf('\n\n')) >= 0) {
+      const message = buffer.slice(0, end)
+      buffer = buffer.slice(end + 2)
+      const data = message
+        .split('\n')
+        .filter((line) => line.startsWith('data:'))
+        .map((line) => line.slice(5).trimStart())
+        .join('\n')
+      if (data) {
+        dispatch(JSON.parse(data) as StreamEvent, onEvent)
+      }
+    }
+  }
+}
+
+function dispatch(event: StreamEvent, onEvent: (event: StreamEvent) => void) {
+  if (event.type !== 'history') {
+    onEvent(event)
+    return
+  }
+  const page = event.payload as unknown as HistoryPage
+  page.items.forEach((item) => onEvent(item))
+  if (page.error) {
+    console.warn('stream history cut short:', page.error)
+  }
+}
+
+function sleep(ms: number, signal?: AbortSignal): Promise<void> {
+  return new Promise((resolve) => {
+    const timer = setTimeout(resolve, ms)
+    signal?.addEventListener('abort', () => {
+      clearTimeout(timer)
+      resolve()
+    })
+  })
+}
+
+// Decoders for stream payloads. They accept both proto and JSON field
+// names and ignore fields newer than the generated code.
+export const decodeIncident = (payload: JsonValue): Incident =>
+  fromJson(IncidentSchema, payload, { ignoreUnknownFields: true })
+
+export const decodeAction = (payload: JsonValue): Action =>
+  fromJson(ActionSchema, payload, { ignoreUnknownFields: true })
+
+export const decodeActionResult = (payload: JsonValue): ActionResult =>
+  fromJson(ActionResultSchema, payload, { ignoreUnknownFields: true })
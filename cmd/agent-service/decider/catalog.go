@@ -0,0 +1,103 @@
+package decider
+
+import (
+	"sync"
+
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+)
+
+// Catalog keeps the latest metrics of every node and service so policies
+// can look up the incident target by ID
+type Catalog struct {
+	mu       sync.RWMutex
+	entities map[string]map[string]float64
+	nodes    map[string]string // service ID -> node ID
+	zones    map[string]string // node ID -> availability zone
+}
+
+// NewCatalog creates an empty catalog
+func NewCatalog() *Catalog {
+	return &Catalog{entities: make(map[string]map[string]float64)}
+}
+
+// Update replaces the catalog contents with a snapshot
+func (c *Catalog) Update(snapshot *simv1.MetricSnapshot) {
+	entities := make(map[string]map[string]float64, len(snapshot.Nodes)+len(snapshot.Services))
+	nodes := make(map[string]string, len(snapshot.Services))
+	zones := make(map[string]string, len(snapshot.Nodes))
+	for _, n := range snapshot.Nodes {
+		zones[n.Id.GetValue()] = n.AvailabilityZone
+		entities[n.Id.GetValue()] = map[string]float64{
+			"cpu_usage_percent":        n.CpuUsagePercent,
+			"memory_usage_percent":     n.MemoryUsagePercent,
+			"disk_usage_percent":       n.DiskUsagePercent,
+			"running_services":         float64(n.RunningServices),
+			"cpu_capacity_millicores":  float64(n.CpuCapacityMillicores),
+			"memory_capacity_mb":       float64(n.MemoryCapacityMb),
+			"cpu_requested_percent":    n.CpuRequestedPercent,
+			"memory_requested_percent": n.MemoryRequestedPercent,
+		}
+	}
+	for _, s := range snapshot.Services {
+		nodes[s.Id.GetValue()] = s.NodeId.GetValue()
+		entities[s.Id.GetValue()] = map[string]float64{
+			"requests_per_second":           s.RequestsPerSecond,
+			"error_rate_percent":            s.ErrorRatePercent,
+			"latency_p50_ms":                s.LatencyP50Ms,
+			"latency_p99_ms":                s.LatencyP99Ms,
+			"replica_count":                 float64(s.ReplicaCount),
+			"desired_replicas":              float64(s.DesiredReplicas),
+			"cpu_request_millicores":        float64(s.CpuRequestMillicores),
+			"memory_request_mb":             float64(s.MemoryRequestMb),
+			"memory_usage_percent":          s.MemoryUsagePercent,
+			"queue_depth":                   s.QueueDepth,
+			"consumer_lag_ms":               s.ConsumerLagMs,
+			"dependency_error_rate_percent": s.DependencyErrorRatePercent,
+			"dependency_latency_p99_ms":     s.DependencyLatencyP99Ms,
+		}
+		for name, value := range s.CustomMetrics {
+			if _, builtin := entities[s.Id.GetValue()][name]; !builtin {
+				entities[s.Id.GetValue()][name] = value
+			}
+		}
+	}
+
+	c.mu.Lock()
+	c.entities = entities
+	c.nodes = nodes
+	c.zones = zones
+	c.mu.Unlock()
+}
+
+// Lookup returns the latest metrics of an entity
+func (c *Catalog) Lookup(id string) (map[string]float64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	m, ok := c.entities[id]
+	return m, ok
+}
+
+// IsNode reports whether id is a node in the latest snapshot
+func (c *Catalog) IsNode(id string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.zones[id]
+	return ok
+}
+
+// Placement returns the node an entity runs on (the entity itself for a
+// node) and that node's availability zone. Unknown entities return empty
+// strings.
+func (c *Catalog) Placement(id string) (node, zone string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	node = id
+	if n, ok := c.nodes[id]; ok {
+		node = n
+	}
+	zone, ok := c.zones[node]
+	if !ok {
+		return "", ""
+	}
+	return node, zone
+}
@@ -10,42 +10,117 @@ import (
 	"github.com/microcloud/bus"
 	commonv1 "github.com/microcloud/gen/go/common/v1"
 	opsv1 "github.com/microcloud/gen/go/ops/v1"
+	"github.com/microcloud/id"
+	"github.com/microcloud/messages"
 	"github.com/microcloud/storage"
 )
 
+// dependencyErrorThreshold is the dependency error rate above which error
+// incidents are treated as dependency-induced
+const dependencyErrorThreshold = 5.0
+
+// nodePoolFullPercent is the share of a node's memory reserved by replicas
+// above which a memory incident means the pool is short of nodes, not that
+// the node needs a reboot
+const nodePoolFullPercent = 85.0
+
 // Decider processes incidents and proposes actions
 type Decider struct {
 	publisher    *bus.Publisher
+	db           *storage.DB
 	actionsRepo  *storage.ActionsRepository
 	incidentsRepo *storage.IncidentsRepository
 	log          *slog.Logger
+	catalog      *Catalog
+	templates    map[string]ParamTemplate
 
 	mu               sync.Mutex
 	recentActions    map[string]time.Time
 	cooldownDuration time.Duration
+	proposals        map[string]proposal // by action ID, until a result arrives
+
+	storm       StormPolicy
+	statusStore *bus.Store
+	stormSince  time.Time // zero outside storm mode
+	stormUntil  time.Time
+	stormReason string
+	unresolved  int64
+	deferred    []*opsv1.Incident // below-critical incidents held until the storm ends
+	batch       []batchedAction   // proposals held until the next storm batch
+}
+
+// Option configures the Decider
+type Option func(*Decider)
+
+// WithParamTemplates replaces the templated policies, keyed by rule name.
+// Rules without a template use the fixed policies in decideAction.
+func WithParamTemplates(templates map[string]ParamTemplate) Option {
+	return func(d *Decider) {
+		d.templates = templates
+	}
 }
 
 // New creates a new decider
-func New(publisher *bus.Publisher, actionsRepo *storage.ActionsRepository, incidentsRepo *storage.IncidentsRepository, log *slog.Logger) *Decider {
-	return &Decider{
+func New(publisher *bus.Publisher, db *storage.DB, catalog *Catalog, log *slog.Logger, opts ...Option) *Decider {
+	d := &Decider{
 		publisher:        publisher,
-		actionsRepo:      actionsRepo,
-		incidentsRepo:    incidentsRepo,
+		db:               db,
+		actionsRepo:      storage.NewActionsRepository(db),
+		incidentsRepo:    storage.NewIncidentsRepository(db),
 		log:              log,
+		catalog:          catalog,
+		templates:        DefaultParamTemplates(),
 		recentActions:    make(map[string]time.Time),
+		proposals:        make(map[string]proposal),
 		cooldownDuration: 30 * time.Second,
+		storm:            DefaultStormPolicy(),
 	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
 }
 
 // ProcessIncident processes an incident and proposes actions
 func (d *Decider) ProcessIncident(ctx context.Context, incident *opsv1.Incident) error {
+	// There is nothing left to act on, but the stored incident is marked
+	// resolved here too so the unresolved count storm mode watches cannot
+	// drift if the signal-service's own update was lost
+	if incident.Resolved {
+		return d.markResolved(ctx, incident)
+	}
+
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	if err := d.storeIncident(ctx, incident); err != nil {
-		d.log.Error("failed to store incident", "error", err)
+	// Escalations republish a stored incident, which the signal-service
+	// updated itself
+	if incident.EscalatedAt == nil {
+		if err := d.storeIncident(ctx, incident); err != nil {
+			d.log.Error("failed to store incident", "error", err)
+		}
 	}
 
+	// A correlated child is acted on through its parent, which names the
+	// likely cause
+	if incident.ParentId != nil {
+		return nil
+	}
+	if incident.RuleName == stormRuleName {
+		d.enterStorm(ctx, fmt.Sprintf("incident storm detected (%s)", incident.Id.GetValue()))
+		return nil
+	}
+	if d.inStorm() && incident.Severity < d.storm.MinSeverity {
+		d.deferIncident(incident)
+		return nil
+	}
+
+	return d.propose(ctx, incident)
+}
+
+// propose decides on an action for an incident and publishes it, or queues
+// it for the next batch in storm mode. Caller must hold mu.
+func (d *Decider) propose(ctx context.Context, incident *opsv1.Incident) error {
 	actionKey := fmt.Sprintf("%s:%s", incident.RuleName, incident.AffectedIds[0])
 	if lastAction, ok := d.recentActions[actionKey]; ok {
 		if time.Since(lastAction) < d.cooldownDuration {
@@ -59,7 +134,14 @@ func (d *Decider) ProcessIncident(ctx context.Context, incident *opsv1.Incident)
 		return nil
 	}
 
-	if err := d.storeAction(ctx, action); err != nil {
+	if d.inStorm() {
+		d.queueBatch(action, incident.Severity)
+		d.recentActions[actionKey] = time.Now()
+		d.trackProposal(action, actionKey)
+		return nil
+	}
+
+	if err := storeAction(ctx, d.actionsRepo, action); err != nil {
 		d.log.Error("failed to store action", "error", err)
 	}
 
@@ -68,6 +150,7 @@ func (d *Decider) ProcessIncident(ctx context.Context, incident *opsv1.Incident)
 	}
 
 	d.recentActions[actionKey] = time.Now()
+	d.trackProposal(action, actionKey)
 	d.log.Info("action proposed",
 		"action_type", action.ActionType,
 		"target", action.TargetId,
@@ -84,10 +167,12 @@ func (d *Decider) decideAction(incident *opsv1.Incident) *opsv1.Action {
 
 	targetID := incident.AffectedIds[0]
 	tickID := incident.DetectedAt.TickId
-	now := time.Now()
+	// The bus clock has advanced past the incident's receipt, so the action
+	// orders after it even if the signal-service clock runs ahead
+	now := d.publisher.Clock().Now().Time()
 
 	action := &opsv1.Action{
-		Id:             &commonv1.UUID{Value: randomUUID()},
+		Id:             &commonv1.UUID{Value: id.NewV7()},
 		IncidentId:     incident.Id,
 		ProposedAtTick: tickID,
 		TargetId:       targetID,
@@ -99,32 +184,91 @@ func (d *Decider) decideAction(incident *opsv1.Incident) *opsv1.Action {
 		},
 	}
 
+	if t, ok := d.templates[incident.RuleName]; ok {
+		params, err := t.evalParams(d.templateVars(incident, targetID))
+		if err == nil {
+			action.ActionType = t.ActionType
+			action.Parameters = params
+			setReason(action, messages.New(messages.ActionTemplate,
+				"action_type", t.ActionType.String(),
+				"rule", incident.RuleName,
+				"params", formatParams(params),
+			))
+			return action
+		}
+		// Missing catalog data must not block remediation; fall back to the fixed policy
+		d.log.Warn("param template failed, using fixed policy", "rule", incident.RuleName, "error", err)
+	}
+
 	switch incident.RuleName {
 	case "high_error_rate", "critical_error_rate":
+		if depErr, ok := d.dependencyInduced(targetID); ok {
+			// Restarting a healthy caller does not help; stop the failing dependency dragging it down
+			action.ActionType = commonv1.ActionType_ACTION_TYPE_ENABLE_CIRCUIT_BREAKER
+			action.Parameters["shed_percent"] = "50"
+			setReason(action, messages.New(messages.ActionCircuitBreaker,
+				"rule", incident.RuleName,
+				"error_rate", fmt.Sprintf("%.2f", incident.Metrics["error_rate_percent"]),
+				"dependency_error_rate", fmt.Sprintf("%.2f", depErr),
+			))
+			break
+		}
 		action.ActionType = commonv1.ActionType_ACTION_TYPE_RESTART_SERVICE
-		action.Reason = fmt.Sprintf("Auto-restart due to %s (error rate: %.2f%%)",
-			incident.RuleName, incident.Metrics["error_rate_percent"])
+		setReason(action, messages.New(messages.ActionRestartErrors,
+			"rule", incident.RuleName,
+			"error_rate", fmt.Sprintf("%.2f", incident.Metrics["error_rate_percent"]),
+		))
 
 	case "high_cpu_usage", "critical_cpu_usage":
 		if incident.Severity == commonv1.IncidentSeverity_INCIDENT_SEVERITY_CRITICAL {
 			action.ActionType = commonv1.ActionType_ACTION_TYPE_SCALE_UP
-			action.Reason = fmt.Sprintf("Scale up due to critical CPU (%.2f%%)",
-				incident.Metrics["cpu_usage_percent"])
+			setReason(action, messages.New(messages.ActionScaleUpCPU,
+				"cpu", fmt.Sprintf("%.2f", incident.Metrics["cpu_usage_percent"]),
+			))
 		} else {
 			action.ActionType = commonv1.ActionType_ACTION_TYPE_REBALANCE_TRAFFIC
-			action.Reason = fmt.Sprintf("Rebalance traffic due to high CPU (%.2f%%)",
-				incident.Metrics["cpu_usage_percent"])
+			setReason(action, messages.New(messages.ActionRebalanceCPU,
+				"cpu", fmt.Sprintf("%.2f", incident.Metrics["cpu_usage_percent"]),
+			))
 		}
 
 	case "high_memory_usage":
+		if node, ok := d.nodeMetrics(targetID); ok {
+			if requested := node["memory_requested_percent"]; requested > nodePoolFullPercent {
+				action.ActionType = commonv1.ActionType_ACTION_TYPE_SCALE_NODE_POOL
+				action.Parameters["count"] = "1"
+				setReason(action, messages.New(messages.ActionScaleNodePool,
+					"memory", fmt.Sprintf("%.2f", incident.Metrics["memory_usage_percent"]),
+					"requested", fmt.Sprintf("%.2f", requested),
+				))
+				break
+			}
+			action.ActionType = commonv1.ActionType_ACTION_TYPE_REBOOT_NODE
+			setReason(action, messages.New(messages.ActionRebootMemory,
+				"memory", fmt.Sprintf("%.2f", incident.Metrics["memory_usage_percent"]),
+			))
+			break
+		}
 		action.ActionType = commonv1.ActionType_ACTION_TYPE_RESTART_SERVICE
-		action.Reason = fmt.Sprintf("Restart due to high memory usage (%.2f%%)",
-			incident.Metrics["memory_usage_percent"])
+		setReason(action, messages.New(messages.ActionRestartMemory,
+			"memory", fmt.Sprintf("%.2f", incident.Metrics["memory_usage_percent"]),
+		))
+
+	case "high_disk_usage":
+		if _, ok := d.nodeMetrics(targetID); !ok {
+			return nil
+		}
+		// Keep new replicas off a filling disk; what runs there stays put
+		action.ActionType = commonv1.ActionType_ACTION_TYPE_CORDON_NODE
+		setReason(action, messages.New(messages.ActionCordonDisk,
+			"disk", fmt.Sprintf("%.2f", incident.Metrics["disk_usage_percent"]),
+		))
 
 	case "high_latency":
 		action.ActionType = commonv1.ActionType_ACTION_TYPE_SCALE_UP
-		action.Reason = fmt.Sprintf("Scale up due to high latency (%.2fms)",
-			incident.Metrics["latency_p99_ms"])
+		setReason(action, messages.New(messages.ActionScaleUpLatency,
+			"latency", fmt.Sprintf("%.2f", incident.Metrics["latency_p99_ms"]),
+		))
 
 	default:
 		d.log.Debug("no action rule for incident", "rule", incident.RuleName)
@@ -134,6 +278,31 @@ func (d *Decider) decideAction(incident *opsv1.Incident) *opsv1.Action {
 	return action
 }
 
+// setReason sets the action's reason text and its catalog form
+func setReason(action *opsv1.Action, m messages.Message) {
+	action.Reason = m.String()
+	action.ReasonMessage = &commonv1.LocalizedMessage{Key: m.Key, Args: m.Args}
+}
+
+// dependencyInduced reports whether a service's errors most likely come from
+// a dependency: one of them is failing harder than the service itself
+func (d *Decider) dependencyInduced(serviceID string) (float64, bool) {
+	target, ok := d.catalog.Lookup(serviceID)
+	if !ok {
+		return 0, false
+	}
+	depErr := target["dependency_error_rate_percent"]
+	return depErr, depErr > dependencyErrorThreshold && depErr > target["error_rate_percent"]
+}
+
+// nodeMetrics returns the catalog metrics of id if it is a node
+func (d *Decider) nodeMetrics(id string) (map[string]float64, bool) {
+	if !d.catalog.IsNode(id) {
+		return nil, false
+	}
+	return d.catalog.Lookup(id)
+}
+
 func (d *Decider) storeIncident(ctx context.Context, incident *opsv1.Incident) error {
 	row := storage.IncidentRow{
 		ID:            incident.Id.Value,
@@ -147,11 +316,36 @@ func (d *Decider) storeIncident(ctx context.Context, incident *opsv1.Incident) e
 		RuleName:      incident.RuleName,
 		Metrics:       incident.Metrics,
 		Resolved:      incident.Resolved,
+		Fingerprint:   incident.Fingerprint,
+		Occurrences:   int(incident.Occurrences),
+	}
+	if incident.ParentId != nil {
+		row.ParentID = &incident.ParentId.Value
+	}
+	if m := incident.TitleMessage; m != nil {
+		row.TitleKey, row.TitleArgs = m.Key, m.Args
+	}
+	if m := incident.DescriptionMessage; m != nil {
+		row.DescriptionKey, row.DescriptionArgs = m.Key, m.Args
 	}
 	return d.incidentsRepo.Create(ctx, row)
 }
 
-func (d *Decider) storeAction(ctx context.Context, action *opsv1.Action) error {
+// markResolved marks a resolved incident's stored row resolved. An incident
+// that was never stored has nothing to update.
+func (d *Decider) markResolved(ctx context.Context, incident *opsv1.Incident) error {
+	resolvedAt := time.Now()
+	if incident.ResolvedAt != nil {
+		resolvedAt = time.UnixMilli(incident.ResolvedAt.WallTimeUnixMs)
+	}
+	err := d.incidentsRepo.MarkResolved(ctx, incident.Id.GetValue(), resolvedAt)
+	if err != nil && !storage.IsNotFound(err) {
+		return fmt.Errorf("mark incident resolved: %w", err)
+	}
+	return nil
+}
+
+func storeAction(ctx context.Context, repo *storage.ActionsRepository, action *opsv1.Action) error {
 	row := storage.ActionRow{
 		ID:             action.Id.Value,
 		IncidentID:     action.IncidentId.Value,
@@ -163,13 +357,8 @@ func (d *Decider) storeAction(ctx context.Context, action *opsv1.Action) error {
 		Parameters:     action.Parameters,
 		CreatedAt:      time.UnixMilli(action.CreatedAt.WallTimeUnixMs),
 	}
-	return d.actionsRepo.Create(ctx, row)
-}
-
-func randomUUID() string {
-	b := make([]byte, 16)
-	for i := range b {
-		b[i] = byte(time.Now().UnixNano() >> (i * 4))
+	if m := action.ReasonMessage; m != nil {
+		row.ReasonKey, row.ReasonArgs = m.Key, m.Args
 	}
-	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+	return repo.Create(ctx, row)
 }
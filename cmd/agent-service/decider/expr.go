@@ -0,0 +1,257 @@
+package decider
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Eval evaluates an arithmetic expression against vars.
+//
+// Supported syntax: numbers, variables (letters, digits, '_' and '.', e.g.
+// target.requests_per_second), + - * /, unary minus, parentheses and the
+// functions ceil, floor, round, abs, min, max and clamp(v, lo, hi).
+func Eval(expr string, vars map[string]float64) (float64, error) {
+	p := &exprParser{src: expr, vars: vars}
+	p.next()
+	v, err := p.parseSum()
+	if err != nil {
+		return 0, err
+	}
+	if p.tok.kind != tokEOF {
+		return 0, p.errorf("unexpected %q", p.tok.text)
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("expression %q is not a finite number", expr)
+	}
+	return v, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokIdent
+	tokOp // one of + - * / ( ) ,
+	tokInvalid
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type exprParser struct {
+	src  string
+	pos  int
+	tok  token
+	vars map[string]float64
+}
+
+// next advances to the following token
+func (p *exprParser) next() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return
+	}
+
+	c := rune(p.src[p.pos])
+	switch {
+	case unicode.IsDigit(c) || c == '.':
+		for p.pos < len(p.src) && (unicode.IsDigit(rune(p.src[p.pos])) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		p.tok = token{kind: tokNumber, text: p.src[start:p.pos], pos: start}
+	case unicode.IsLetter(c) || c == '_':
+		for p.pos < len(p.src) && isIdentChar(rune(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok = token{kind: tokIdent, text: p.src[start:p.pos], pos: start}
+	case strings.ContainsRune("+-*/(),", c):
+		p.pos++
+		p.tok = token{kind: tokOp, text: string(c), pos: start}
+	default:
+		p.pos++
+		p.tok = token{kind: tokInvalid, text: string(c), pos: start}
+	}
+}
+
+func isIdentChar(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '.'
+}
+
+func (p *exprParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("expression %q at %d: %s", p.src, p.tok.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *exprParser) isOp(op string) bool {
+	return p.tok.kind == tokOp && p.tok.text == op
+}
+
+// parseSum handles + and -
+func (p *exprParser) parseSum() (float64, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return 0, err
+	}
+	for p.isOp("+") || p.isOp("-") {
+		op := p.tok.text
+		p.next()
+		right, err := p.parseProduct()
+		if err != nil {
+			return 0, err
+		}
+		if op == "+" {
+			left += right
+		} else {
+			left -= right
+		}
+	}
+	return left, nil
+}
+
+// parseProduct handles * and /
+func (p *exprParser) parseProduct() (float64, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+	for p.isOp("*") || p.isOp("/") {
+		op := p.tok.text
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		if op == "*" {
+			left *= right
+			continue
+		}
+		if right == 0 {
+			return 0, p.errorf("division by zero")
+		}
+		left /= right
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (float64, error) {
+	if p.isOp("-") {
+		p.next()
+		v, err := p.parseUnary()
+		return -v, err
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (float64, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokNumber:
+		p.next()
+		v, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return 0, fmt.Errorf("expression %q at %d: invalid number %q", p.src, tok.pos, tok.text)
+		}
+		return v, nil
+
+	case tokIdent:
+		p.next()
+		if p.isOp("(") {
+			return p.parseCall(tok)
+		}
+		v, ok := p.vars[tok.text]
+		if !ok {
+			return 0, fmt.Errorf("expression %q at %d: unknown variable %q", p.src, tok.pos, tok.text)
+		}
+		return v, nil
+
+	case tokOp:
+		if tok.text == "(" {
+			p.next()
+			v, err := p.parseSum()
+			if err != nil {
+				return 0, err
+			}
+			if !p.isOp(")") {
+				return 0, p.errorf("expected )")
+			}
+			p.next()
+			return v, nil
+		}
+	case tokEOF:
+		return 0, p.errorf("unexpected end of expression")
+	}
+	return 0, p.errorf("unexpected %q", tok.text)
+}
+
+// parseCall evaluates fn(args...); the current token is the opening paren
+func (p *exprParser) parseCall(fn token) (float64, error) {
+	p.next()
+	var args []float64
+	if !p.isOp(")") {
+		for {
+			v, err := p.parseSum()
+			if err != nil {
+				return 0, err
+			}
+			args = append(args, v)
+			if !p.isOp(",") {
+				break
+			}
+			p.next()
+		}
+	}
+	if !p.isOp(")") {
+		return 0, p.errorf("expected ) after arguments to %s", fn.text)
+	}
+	p.next()
+
+	arity := func(n int) error {
+		if len(args) != n {
+			return fmt.Errorf("expression %q at %d: %s takes %d argument(s), got %d", p.src, fn.pos, fn.text, n, len(args))
+		}
+		return nil
+	}
+
+	switch fn.text {
+	case "ceil", "floor", "round", "abs":
+		if err := arity(1); err != nil {
+			return 0, err
+		}
+		return map[string]func(float64) float64{
+			"ceil":  math.Ceil,
+			"floor": math.Floor,
+			"round": math.Round,
+			"abs":   math.Abs,
+		}[fn.text](args[0]), nil
+	case "min", "max":
+		if len(args) == 0 {
+			return 0, fmt.Errorf("expression %q at %d: %s needs at least one argument", p.src, fn.pos, fn.text)
+		}
+		v := args[0]
+		for _, a := range args[1:] {
+			if fn.text == "min" {
+				v = math.Min(v, a)
+			} else {
+				v = math.Max(v, a)
+			}
+		}
+		return v, nil
+	case "clamp":
+		if err := arity(3); err != nil {
+			return 0, err
+		}
+		return math.Min(math.Max(args[0], args[1]), args[2]), nil
+	default:
+		return 0, fmt.Errorf("expression %q at %d: unknown function %q", p.src, fn.pos, fn.text)
+	}
+}
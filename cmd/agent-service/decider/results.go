@@ -0,0 +1,65 @@
+package decider
+
+import (
+	"context"
+	"time"
+
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+)
+
+// proposalTTL is how long a proposal waits for its result. Rejected and
+// never-approved actions get none and are forgotten after it.
+const proposalTTL = time.Hour
+
+// proposal is a published action awaiting its execution result
+type proposal struct {
+	cooldownKey string
+	at          time.Time
+}
+
+// trackProposal remembers an action until its result arrives, dropping
+// proposals older than proposalTTL. Caller must hold mu.
+func (d *Decider) trackProposal(action *opsv1.Action, cooldownKey string) {
+	now := time.Now()
+	for id, p := range d.proposals {
+		if now.Sub(p.at) > proposalTTL {
+			delete(d.proposals, id)
+		}
+	}
+	d.proposals[action.Id.GetValue()] = proposal{cooldownKey: cooldownKey, at: now}
+}
+
+// TrackedCount returns the sizes of the cooldown and proposal maps
+func (d *Decider) TrackedCount() (cooldowns, proposals int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.recentActions), len(d.proposals)
+}
+
+// HandleActionResult tracks the outcome of an executed action. A failed
+// action clears its cooldown so the next incident for the same rule and
+// target gets a fresh proposal instead of waiting out a fix that never
+// happened.
+func (d *Decider) HandleActionResult(ctx context.Context, result *opsv1.ActionResult) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	actionID := result.ActionId.GetValue()
+	p, ok := d.proposals[actionID]
+	if !ok {
+		return nil
+	}
+	delete(d.proposals, actionID)
+
+	if result.Success {
+		d.log.Debug("action applied", "action_id", actionID, "tick", result.AppliedAtTick)
+		return nil
+	}
+	delete(d.recentActions, p.cooldownKey)
+	d.log.Warn("action failed, cooldown cleared",
+		"action_id", actionID,
+		"key", p.cooldownKey,
+		"message", result.Message,
+	)
+	return nil
+}
@@ -0,0 +1,294 @@
+package decider
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/microcloud/bus"
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+	"github.com/microcloud/storage"
+)
+
+// stormRuleName is the rule name of incidents raised by signal-service's
+// storm detector
+const stormRuleName = "incident_storm"
+
+// Storm mode events published on the simulation event stream
+const (
+	EventStormEntered = "storm_mode_entered"
+	EventStormExited  = "storm_mode_exited"
+)
+
+// StormPolicy controls storm mode, entered when an incident_storm incident
+// arrives or too many incidents are unresolved. In storm mode incidents
+// below MinSeverity are deferred until the storm ends and the remaining
+// proposals are batched, most severe first and grouped by zone and node.
+type StormPolicy struct {
+	Hold                time.Duration             // minimum time in storm mode after the last trigger
+	MinSeverity         commonv1.IncidentSeverity // incidents below this are deferred
+	BatchInterval       time.Duration             // how often batches are published and triggers re-checked
+	UnresolvedThreshold int64                     // unresolved incidents that trigger storm mode; 0 disables
+}
+
+// DefaultStormPolicy returns the default storm mode policy
+func DefaultStormPolicy() StormPolicy {
+	return StormPolicy{
+		Hold:                5 * time.Minute,
+		MinSeverity:         commonv1.IncidentSeverity_INCIDENT_SEVERITY_CRITICAL,
+		BatchInterval:       10 * time.Second,
+		UnresolvedThreshold: 50,
+	}
+}
+
+// Config returns the policy as settings for the configuration dump
+func (p StormPolicy) Config() map[string]string {
+	return map[string]string{
+		"hold":                 p.Hold.String(),
+		"min_severity":         p.MinSeverity.String(),
+		"batch_interval":       p.BatchInterval.String(),
+		"unresolved_threshold": strconv.FormatInt(p.UnresolvedThreshold, 10),
+	}
+}
+
+// WithStormPolicy overrides the storm mode policy
+func WithStormPolicy(p StormPolicy) Option {
+	return func(d *Decider) {
+		d.storm = p
+	}
+}
+
+// WithStormStatusStore saves the storm status to store on every change so
+// the orchestrator admin API can serve it
+func WithStormStatusStore(store *bus.Store) Option {
+	return func(d *Decider) {
+		d.statusStore = store
+	}
+}
+
+// batchedAction is a storm-mode proposal waiting for the next batch
+type batchedAction struct {
+	action   *opsv1.Action
+	severity commonv1.IncidentSeverity
+	zone     string
+	node     string
+}
+
+// InStorm reports whether storm mode is active
+func (d *Decider) InStorm() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.inStorm()
+}
+
+// inStorm reports whether storm mode is active. Caller must hold mu.
+func (d *Decider) inStorm() bool {
+	return !d.stormSince.IsZero()
+}
+
+// enterStorm starts storm mode or extends it by Hold. Caller must hold mu.
+func (d *Decider) enterStorm(ctx context.Context, reason string) {
+	d.stormUntil = time.Now().Add(d.storm.Hold)
+	if d.inStorm() {
+		return
+	}
+
+	d.stormSince = time.Now()
+	d.stormReason = reason
+	d.log.Warn("entering storm mode", "reason", reason, "hold", d.storm.Hold)
+	d.publishStormEvent(ctx, EventStormEntered, fmt.Sprintf("Decider entered storm mode: %s", reason))
+	d.saveStormStatus(ctx)
+}
+
+// exitStorm leaves storm mode and returns the deferred incidents. Caller
+// must hold mu.
+func (d *Decider) exitStorm(ctx context.Context) []*opsv1.Incident {
+	deferred := d.deferred
+	d.log.Info("leaving storm mode", "duration", time.Since(d.stormSince), "deferred", len(deferred))
+	d.publishStormEvent(ctx, EventStormExited,
+		fmt.Sprintf("Decider left storm mode after %s, replaying %d deferred incidents", time.Since(d.stormSince).Round(time.Second), len(deferred)))
+
+	d.stormSince = time.Time{}
+	d.stormUntil = time.Time{}
+	d.stormReason = ""
+	d.deferred = nil
+	return deferred
+}
+
+// deferIncident holds a below-critical incident until storm mode ends.
+// Caller must hold mu.
+func (d *Decider) deferIncident(incident *opsv1.Incident) {
+	for _, held := range d.deferred {
+		if held.Id.GetValue() == incident.Id.GetValue() {
+			return
+		}
+	}
+	d.deferred = append(d.deferred, incident)
+	d.log.Debug("storm mode: incident deferred", "incident_id", incident.Id.GetValue(), "severity", incident.Severity)
+}
+
+// queueBatch holds a proposal for the next batch, replacing an earlier one
+// for the same target and action type. Caller must hold mu.
+func (d *Decider) queueBatch(action *opsv1.Action, severity commonv1.IncidentSeverity) {
+	node, zone := d.catalog.Placement(action.TargetId)
+	b := batchedAction{action: action, severity: severity, zone: zone, node: node}
+	for i, queued := range d.batch {
+		if queued.action.TargetId == action.TargetId && queued.action.ActionType == action.ActionType {
+			if queued.severity > severity {
+				b.severity = queued.severity
+			}
+			d.batch[i] = b
+			return
+		}
+	}
+	d.batch = append(d.batch, b)
+}
+
+// RunBatches publishes batched storm-mode proposals every BatchInterval and
+// enters or leaves storm mode based on the unresolved incident count, until
+// ctx is done
+func (d *Decider) RunBatches(ctx context.Context) error {
+	ticker := time.NewTicker(d.storm.BatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			d.flushBatch(ctx)
+			d.checkStorm(ctx)
+		}
+	}
+}
+
+// checkStorm enters storm mode when too many incidents are unresolved and
+// leaves it once the hold expired and the count dropped, replaying the
+// incidents deferred during the storm
+func (d *Decider) checkStorm(ctx context.Context) {
+	var unresolved int64
+	if d.storm.UnresolvedThreshold > 0 {
+		n, err := d.incidentsRepo.CountUnresolved(ctx)
+		if err != nil {
+			d.log.Error("failed to count unresolved incidents", "error", err)
+			return
+		}
+		unresolved = n
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.unresolved = unresolved
+	overThreshold := d.storm.UnresolvedThreshold > 0 && unresolved >= d.storm.UnresolvedThreshold
+	switch {
+	case overThreshold:
+		d.enterStorm(ctx, fmt.Sprintf("%d unresolved incidents", unresolved))
+	case d.inStorm() && time.Now().After(d.stormUntil):
+		for _, incident := range d.exitStorm(ctx) {
+			row, err := d.incidentsRepo.GetByID(ctx, incident.Id.GetValue())
+			if storage.IsNotFound(err) || (err == nil && row.Resolved) {
+				continue
+			}
+			if err := d.propose(ctx, incident); err != nil {
+				d.log.Error("failed to propose deferred incident", "incident_id", incident.Id.GetValue(), "error", err)
+			}
+		}
+	}
+	d.saveStormStatus(ctx)
+}
+
+// flushBatch publishes the batched proposals, most severe first and grouped
+// by zone and node so operators review related actions together
+func (d *Decider) flushBatch(ctx context.Context) {
+	d.mu.Lock()
+	batch := d.batch
+	d.batch = nil
+	d.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+	sort.SliceStable(batch, func(i, j int) bool {
+		a, b := batch[i], batch[j]
+		if a.severity != b.severity {
+			return a.severity > b.severity
+		}
+		if a.zone != b.zone {
+			return a.zone < b.zone
+		}
+		return a.node < b.node
+	})
+
+	// Store the batch as a unit so operators never see part of it
+	err := d.db.WithTx(ctx, func(q storage.Queries) error {
+		for _, b := range batch {
+			if err := storeAction(ctx, q.Actions, b.action); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		d.log.Error("failed to store batched actions", "actions", len(batch), "error", err)
+	}
+
+	groups := make(map[string]int)
+	for _, b := range batch {
+		if err := d.publisher.PublishAction(ctx, b.action); err != nil {
+			d.log.Error("failed to publish batched action", "action_id", b.action.Id.GetValue(), "error", err)
+			continue
+		}
+		groups[b.zone+"/"+b.node]++
+	}
+	d.log.Info("storm batch proposed", "actions", len(batch), "groups", groups)
+}
+
+// publishStormEvent announces a storm mode change. Caller must hold mu.
+func (d *Decider) publishStormEvent(ctx context.Context, eventType, description string) {
+	event := &simv1.SimulationEvent{
+		Timestamp:   &commonv1.SimulationTimestamp{WallTimeUnixMs: time.Now().UnixMilli()},
+		EventType:   eventType,
+		TargetId:    "agent-service",
+		Description: description,
+		Metadata: map[string]string{
+			"reason":   d.stormReason,
+			"deferred": fmt.Sprintf("%d", len(d.deferred)),
+		},
+	}
+	if err := d.publisher.PublishSimulationEvent(ctx, event); err != nil {
+		d.log.Error("failed to publish storm event", "event_type", eventType, "error", err)
+	}
+}
+
+// stormStatus returns the current storm status. Caller must hold mu.
+func (d *Decider) stormStatus() *opsv1.StormStatus {
+	status := &opsv1.StormStatus{
+		Active:              d.inStorm(),
+		Reason:              d.stormReason,
+		UnresolvedIncidents: int32(d.unresolved),
+		DeferredIncidents:   int32(len(d.deferred)),
+		BatchedActions:      int32(len(d.batch)),
+		UpdatedAt:           &commonv1.SimulationTimestamp{WallTimeUnixMs: time.Now().UnixMilli()},
+	}
+	if status.Active {
+		status.EnteredAt = &commonv1.SimulationTimestamp{WallTimeUnixMs: d.stormSince.UnixMilli()}
+		status.Until = &commonv1.SimulationTimestamp{WallTimeUnixMs: d.stormUntil.UnixMilli()}
+	}
+	return status
+}
+
+// saveStormStatus writes the storm status to the status store, if
+// configured. Caller must hold mu.
+func (d *Decider) saveStormStatus(ctx context.Context) {
+	if d.statusStore == nil {
+		return
+	}
+	if err := d.statusStore.Put(ctx, bus.KeyStormStatus, d.stormStatus()); err != nil {
+		d.log.Error("failed to save storm status", "error", err)
+	}
+}
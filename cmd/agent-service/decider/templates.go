@@ -0,0 +1,82 @@
+package decider
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+)
+
+// ParamTemplate is a policy whose parameters are computed from live data.
+// Each entry in Params maps a parameter name to an expression (see Eval)
+// over these variables:
+//
+//	metrics.<name>   incident metrics, e.g. metrics.latency_p99_ms
+//	target.<name>    latest catalog values of the affected entity, e.g.
+//	                 target.requests_per_second or target.replica_count
+//	severity         incident severity as a number
+type ParamTemplate struct {
+	ActionType commonv1.ActionType
+	Params     map[string]string
+}
+
+// perReplicaRPS is the request rate one replica is sized for
+const perReplicaRPS = 150
+
+// DefaultParamTemplates returns the built-in templated policies, keyed by rule name
+func DefaultParamTemplates() map[string]ParamTemplate {
+	return map[string]ParamTemplate{
+		"high_latency": {
+			ActionType: commonv1.ActionType_ACTION_TYPE_SCALE_TO_N,
+			Params: map[string]string{
+				"count": fmt.Sprintf("clamp(ceil(target.requests_per_second / %d), target.replica_count + 1, 50)", perReplicaRPS),
+			},
+		},
+	}
+}
+
+// templateVars builds the expression variables for an incident
+func (d *Decider) templateVars(incident *opsv1.Incident, targetID string) map[string]float64 {
+	vars := map[string]float64{
+		"severity": float64(incident.Severity),
+	}
+	for k, v := range incident.Metrics {
+		vars["metrics."+k] = v
+	}
+	if target, ok := d.catalog.Lookup(targetID); ok {
+		for k, v := range target {
+			vars["target."+k] = v
+		}
+	}
+	return vars
+}
+
+// evalParams evaluates every parameter expression of a template
+func (t ParamTemplate) evalParams(vars map[string]float64) (map[string]string, error) {
+	params := make(map[string]string, len(t.Params))
+	for name, expr := range t.Params {
+		v, err := Eval(expr, vars)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %w", name, err)
+		}
+		params[name] = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return params, nil
+}
+
+// formatParams renders parameters as "k=v" pairs in key order
+func formatParams(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+params[k])
+	}
+	return strings.Join(pairs, ", ")
+}
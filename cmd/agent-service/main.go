@@ -2,84 +2,114 @@ package main
 
 import (
 	"context"
-	"log/slog"
 	"os"
-	"os/signal"
-	"syscall"
-
-	"golang.org/x/sync/errgroup"
+	"strconv"
+	"time"
 
 	"github.com/microcloud/agent-service/decider"
+	"github.com/microcloud/app"
 	"github.com/microcloud/bus"
 	opsv1 "github.com/microcloud/gen/go/ops/v1"
-	"github.com/microcloud/logger"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
 	"github.com/microcloud/storage"
 )
 
 func main() {
-	log := logger.NewFromEnv("agent-service")
-
-	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer cancel()
+	app.Main("agent-service", build)
+}
 
-	if err := run(ctx, log); err != nil && err != context.Canceled {
-		log.Error("fatal error", "error", err)
-		os.Exit(1)
-	}
+// configEnv are the environment variables the service reports in its
+// configuration, besides the shared ones. Credentials are left out.
+var configEnv = []string{
+	"STORM_HOLD", "STORM_UNRESOLVED_THRESHOLD",
 }
 
-func run(ctx context.Context, log *slog.Logger) error {
+func build(ctx context.Context, a *app.App) error {
+	log := a.Log()
+	a.ConfigEnv(configEnv...)
+
 	dbCfg := storage.ConfigFromEnv()
 	db, err := storage.New(ctx, dbCfg)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
+	a.OnStop("database", func(context.Context) error {
+		db.Close()
+		return nil
+	})
+	a.HealthCheck("database", db.Ping)
 
 	log.Info("connected to database", "host", dbCfg.Host)
 
-	busCfg := bus.DefaultConfig()
-	if url := os.Getenv("NATS_URL"); url != "" {
-		busCfg.URL = url
-	}
-
+	busCfg := bus.ConfigFromEnv()
+	info := app.Build()
 	eventBus, err := bus.New(ctx, busCfg,
-		bus.WithDisconnectHandler(func(err error) {
-			log.Warn("NATS disconnected", "error", err)
-		}),
-		bus.WithReconnectHandler(func() {
-			log.Info("NATS reconnected")
-		}),
+		bus.WithLogger(log),
+		bus.WithIdentity(a.Name(), info.Version, info.Commit),
+		bus.WithConfig(a.Config),
 	)
 	if err != nil {
 		return err
 	}
-	defer eventBus.Close()
+	a.OnStop("nats", func(context.Context) error {
+		return eventBus.Close()
+	})
+	a.HealthCheck("nats", eventBus.Check)
 
 	log.Info("connected to NATS", "url", busCfg.URL)
 
 	publisher := bus.NewPublisher(eventBus)
 	subscriber := bus.NewSubscriber(eventBus)
-	actionsRepo := storage.NewActionsRepository(db)
-	incidentsRepo := storage.NewIncidentsRepository(db)
 
-	dec := decider.New(publisher, actionsRepo, incidentsRepo, log)
+	storm := decider.DefaultStormPolicy()
+	if v := os.Getenv("STORM_HOLD"); v != "" {
+		if parsed, err := time.ParseDuration(v); err == nil && parsed > 0 {
+			storm.Hold = parsed
+		}
+	}
+	if v := os.Getenv("STORM_UNRESOLVED_THRESHOLD"); v != "" {
+		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil && parsed >= 0 {
+			storm.UnresolvedThreshold = parsed
+		}
+	}
+	stormStore, err := eventBus.NewStore(ctx, bus.BucketStormStatus)
+	if err != nil {
+		return err
+	}
+	log.Info("storm mode policy", "hold", storm.Hold, "unresolved_threshold", storm.UnresolvedThreshold)
+	a.AddConfig("policy.storm", storm.Config)
 
-	g, ctx := errgroup.WithContext(ctx)
+	catalog := decider.NewCatalog()
+	dec := decider.New(publisher, db, catalog, log,
+		decider.WithStormPolicy(storm),
+		decider.WithStormStatusStore(stormStore),
+	)
 
-	g.Go(func() error {
-		log.Info("subscribing to incidents")
-		cc, err := subscriber.SubscribeIncidents(ctx, "agent-service", func(ctx context.Context, incident *opsv1.Incident) error {
+	a.Consume("agent-service", func(ctx context.Context) (app.Stopper, error) {
+		return subscriber.SubscribeIncidents(ctx, "agent-service", func(ctx context.Context, incident *opsv1.Incident) error {
 			return dec.ProcessIncident(ctx, incident)
 		})
-		if err != nil {
-			return err
-		}
-		defer cc.Stop()
-
-		<-ctx.Done()
-		return ctx.Err()
+	})
+	a.Go("batches", dec.RunBatches)
+	a.Consume("agent-service-results", func(ctx context.Context) (app.Stopper, error) {
+		return subscriber.SubscribeActionResults(ctx, "agent-service-results", dec.HandleActionResult)
+	})
+	a.Consume("agent-service-catalog", func(ctx context.Context) (app.Stopper, error) {
+		return subscriber.SubscribeMetrics(ctx, "agent-service-catalog", func(ctx context.Context, snapshot *simv1.MetricSnapshot) error {
+			catalog.Update(snapshot)
+			return nil
+		})
 	})
 
-	return g.Wait()
+	// Health only; the agent has no API
+	a.Serve(getEnv("ADDR", ":8083"), nil)
+	a.Go("heartbeat", publisher.RunHeartbeats)
+	return nil
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
 }
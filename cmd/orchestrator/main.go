@@ -2,117 +2,360 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"connectrpc.com/connect"
-	"golang.org/x/net/http2"
-	"golang.org/x/net/http2/h2c"
-	"golang.org/x/sync/errgroup"
 
+	"github.com/microcloud/app"
 	"github.com/microcloud/bus"
+	"github.com/microcloud/environment"
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
 	"github.com/microcloud/gen/go/ops/v1/opsv1connect"
-	"github.com/microcloud/logger"
+	"github.com/microcloud/gen/go/sim/v1/simv1connect"
 	"github.com/microcloud/orchestrator/server"
 	"github.com/microcloud/storage"
 )
 
 func main() {
-	log := logger.NewFromEnv("orchestrator")
-
-	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer cancel()
+	// /debug/vars shows the command line and internal counters, so it is
+	// kept to admins
+	app.Main("orchestrator", build, app.WithAdminVars())
+}
 
-	if err := run(ctx, log); err != nil && err != context.Canceled {
-		log.Error("fatal error", "error", err)
-		os.Exit(1)
-	}
+// configEnv are the environment variables the service reports in its
+// configuration, besides the shared ones. Credentials are left out.
+var configEnv = []string{
+	"API_DEPRECATIONS", "CONSISTENCY_AUTO_REPAIR",
+	"CONSISTENCY_CHECK_INTERVAL", "CORS_ALLOWED_ORIGINS", "DEMO_MODE",
+	"DESIRED_CONFIG_FILE", "DRIFT_CHECK_INTERVAL", "ENVIRONMENT_ROUTES",
+	"POLICY_BUNDLE", "POLICY_REFRESH_INTERVAL", "SIGNAL_SERVICE_URL",
+	"SIM_ENGINE_URL", "STREAM_MAX_HISTORY", "STREAM_RECORDING_DIR",
+	"STREAM_TOKEN_TTL", "USAGE_QUOTAS", "USAGE_WINDOW",
 }
 
-func run(ctx context.Context, log *slog.Logger) error {
+func build(ctx context.Context, a *app.App) error {
+	log := a.Log()
+	a.ConfigEnv(configEnv...)
+
 	dbCfg := storage.ConfigFromEnv()
 	db, err := storage.New(ctx, dbCfg)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
+	a.OnStop("database", func(context.Context) error {
+		db.Close()
+		return nil
+	})
+	a.HealthCheck("database", db.Ping)
 
 	log.Info("connected to database", "host", dbCfg.Host)
 
-	busCfg := bus.DefaultConfig()
-	if url := os.Getenv("NATS_URL"); url != "" {
-		busCfg.URL = url
-	}
-
+	busCfg := bus.ConfigFromEnv()
+	info := app.Build()
 	eventBus, err := bus.New(ctx, busCfg,
-		bus.WithDisconnectHandler(func(err error) {
-			log.Warn("NATS disconnected", "error", err)
-		}),
-		bus.WithReconnectHandler(func() {
-			log.Info("NATS reconnected")
-		}),
+		bus.WithLogger(log),
+		bus.WithIdentity(a.Name(), info.Version, info.Commit),
+		bus.WithConfig(a.Config),
 	)
 	if err != nil {
 		return err
 	}
-	defer eventBus.Close()
+	a.OnStop("nats", func(context.Context) error {
+		return eventBus.Close()
+	})
+	a.HealthCheck("nats", eventBus.Check)
 
-	log.Info("connected to NATS", "url", busCfg.URL)
+	log.Info("connected to NATS", "url", busCfg.URL, "environment", busCfg.Environment)
 
 	publisher := bus.NewPublisher(eventBus)
 	subscriber := bus.NewSubscriber(eventBus)
 	actionsRepo := storage.NewActionsRepository(db)
+	incidentsRepo := storage.NewIncidentsRepository(db)
+	metricsRepo := storage.NewMetricsRepository(db)
+	stormStore, err := eventBus.NewStore(ctx, bus.BucketStormStatus)
+	if err != nil {
+		return err
+	}
 
-	actionServer := server.NewActionServer(actionsRepo, publisher, log)
-	streamHub := server.NewStreamHub(subscriber, log)
+	// Rego policies gate approvals and approve actions on their own; a
+	// bundle loaded at runtime is shared through the bucket and outranks
+	// POLICY_BUNDLE
+	policyStore, err := eventBus.NewStore(ctx, bus.BucketPolicyBundle)
+	if err != nil {
+		return err
+	}
+	policies := server.NewPolicyEngine(policyStore, storage.NewPolicyDecisionsRepository(db), incidentsRepo, actionsRepo, log)
+	if path := os.Getenv("POLICY_BUNDLE"); path != "" {
+		if err := policies.LoadFile(ctx, path); err != nil {
+			return err
+		}
+		log.Info("policy bundle loaded", "path", path)
+	}
+	if err := policies.Refresh(ctx); err != nil {
+		log.Warn("failed to load shared policy bundle", "error", err)
+	}
+	policyRefresh := server.DefaultPolicyRefreshInterval
+	if raw := os.Getenv("POLICY_REFRESH_INTERVAL"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed <= 0 {
+			return fmt.Errorf("invalid POLICY_REFRESH_INTERVAL %q", raw)
+		}
+		policyRefresh = parsed
+	}
+	a.AddConfig("policy", policies.Config)
 
-	mux := http.NewServeMux()
+	actionServer := server.NewActionServer(db, publisher, policies, log)
+	simulationServer := server.NewSimulationServer(publisher, log)
+	usage, err := usageTrackerFromEnv(log)
+	if err != nil {
+		return err
+	}
+	components := server.NewComponentRegistry(eventBus.Identity(), log)
+	var desired server.DesiredConfig
+	if path := os.Getenv("DESIRED_CONFIG_FILE"); path != "" {
+		if desired, err = server.LoadDesiredConfig(path); err != nil {
+			return err
+		}
+		log.Info("desired configuration loaded", "path", path, "services", len(desired))
+	}
+	drift := server.NewDriftChecker(desired, components, a.Config, incidentsRepo, publisher, log)
+	adminServer := server.NewAdminServer(db, eventBus, stormStore, usage, components, drift, policies, log)
+
+	streamOpts := []server.StreamOption{server.WithClientActivity(publisher)}
+	if dir := os.Getenv("STREAM_RECORDING_DIR"); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("create recording dir: %w", err)
+		}
+		streamOpts = append(streamOpts, server.WithRecordingDir(dir))
+		log.Info("stream recording enabled", "dir", dir)
+	}
+	if raw := os.Getenv("STREAM_MAX_HISTORY"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid STREAM_MAX_HISTORY %q", raw)
+		}
+		streamOpts = append(streamOpts, server.WithMaxHistory(d))
+	}
+	streamHub := server.NewStreamHub(subscriber, log, streamOpts...)
+	primeCtx, cancelPrime := context.WithTimeout(ctx, 10*time.Second)
+	if err := streamHub.Prime(primeCtx, metricsRepo, incidentsRepo, actionsRepo); err != nil {
+		log.Warn("failed to prime stream hub, clients wait for live state", "error", err)
+	}
+	cancelPrime()
+	incidentServer := server.NewIncidentServer(incidentsRepo, actionsRepo, metricsRepo, streamHub, log)
+
+	// Every handler is registered on the v1 mux; v2 handlers go on their own
+	// mux once the first v2 protos land
+	api := server.NewVersionedMux("v1")
+	mux := api.Version("v1")
+	demoMode := os.Getenv("DEMO_MODE") == "true"
+	interceptorList := []connect.Interceptor{
+		loggingInterceptor(log),
+		server.DeadlineInterceptor(server.DefaultRPCTimeout, server.DeadlineMargin),
+		server.ETagInterceptor(
+			server.Cache[opsv1.ListPendingActionsResponse](opsv1connect.ActionServiceListPendingActionsProcedure, actionsRepo.Version),
+			server.Cache[opsv1.GetActionHistoryResponse](opsv1connect.ActionServiceGetActionHistoryProcedure, actionsRepo.Version),
+			server.Cache[opsv1.ListIncidentsResponse](opsv1connect.IncidentServiceListIncidentsProcedure, incidentsRepo.Version),
+		),
+	}
+	if raw := os.Getenv("API_DEPRECATIONS"); raw != "" {
+		deprecations, err := parseDeprecations(raw)
+		if err != nil {
+			return err
+		}
+		log.Info("API deprecations configured", "count", len(deprecations))
+		interceptorList = append(interceptorList, server.DeprecationInterceptor(deprecations, log))
+	}
+	// Minting a stream token changes nothing the demo protects, so the auth
+	// service skips the read-only check
+	authInterceptorList := slices.Clone(interceptorList)
+	if demoMode {
+		log.Info("read-only demo mode enabled")
+		interceptorList = append(interceptorList, app.ReadOnlyInterceptor())
+	}
+	interceptors := connect.WithInterceptors(interceptorList...)
+	interceptorNames := app.InterceptorNames(interceptorList...)
 
 	// Connect-RPC handlers
 	path, handler := opsv1connect.NewActionServiceHandler(actionServer,
-		connect.WithInterceptors(loggingInterceptor(log)),
+		interceptors,
+	)
+	a.Handle(mux, path, handler, interceptorNames...)
+
+	path, handler = opsv1connect.NewSimulationServiceHandler(simulationServer,
+		interceptors,
 	)
-	mux.Handle(path, handler)
+	a.Handle(mux, path, handler, interceptorNames...)
 
-	// SSE streaming endpoint
-	mux.Handle("/api/stream", streamHub)
+	path, handler = opsv1connect.NewIncidentServiceHandler(incidentServer,
+		interceptors,
+	)
+	a.Handle(mux, path, handler, interceptorNames...)
 
-	// Health check
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("ok"))
-	})
+	path, handler = opsv1connect.NewAdminServiceHandler(adminServer,
+		interceptors,
+	)
+	a.Handle(mux, path, handler, interceptorNames...)
+
+	// Stream tokens let the browser authenticate the SSE endpoints, which
+	// EventSource cannot send auth headers to
+	streamTokens, err := streamTokensFromEnv(log)
+	if err != nil {
+		return err
+	}
+	path, handler = opsv1connect.NewAuthServiceHandler(server.NewAuthServer(streamTokens),
+		connect.WithInterceptors(authInterceptorList...),
+	)
+	a.Handle(mux, path, handler, app.InterceptorNames(authInterceptorList...)...)
 
-	// CORS middleware
-	corsHandler := corsMiddleware(mux)
+	// SimulationControl RPCs proxied to the sim-engine
+	simEngineURL := getEnv("SIM_ENGINE_URL", "http://localhost:8080")
+	simGateway, err := server.NewSimulationGateway(simEngineURL, log)
+	if err != nil {
+		return err
+	}
+	if demoMode {
+		simGateway = readOnlyGateway(simGateway,
+			simv1connect.SimulationControlGetStateProcedure,
+			simv1connect.SimulationControlGetTopologyProcedure,
+			simv1connect.SimulationControlGetSnapshotProcedure,
+			simv1connect.SimulationControlListScenariosProcedure,
+			simv1connect.SimulationControlGetEngineStatsProcedure,
+			simv1connect.SimulationControlEvaluateActionProcedure,
+		)
+	}
+	a.Handle(mux, server.SimulationGatewayPath, simGateway)
 
-	addr := getEnv("ADDR", ":8081")
-	httpServer := &http.Server{
-		Addr:    addr,
-		Handler: h2c.NewHandler(corsHandler, &http2.Server{}),
+	// RuleService and SuppressionService RPCs proxied to the signal-service
+	signalServiceURL := getEnv("SIGNAL_SERVICE_URL", "http://localhost:8082")
+	ruleGateway, err := server.NewRuleGateway(signalServiceURL, log)
+	if err != nil {
+		return err
+	}
+	if demoMode {
+		ruleGateway = readOnlyGateway(ruleGateway, opsv1connect.RuleServiceListRulesProcedure, opsv1connect.RuleServiceGetRuleStatsProcedure)
 	}
+	a.Handle(mux, server.RuleGatewayPath, ruleGateway)
+	suppressionGateway, err := server.NewSuppressionGateway(signalServiceURL, log)
+	if err != nil {
+		return err
+	}
+	if demoMode {
+		suppressionGateway = readOnlyGateway(suppressionGateway, opsv1connect.SuppressionServiceListSuppressionWindowsProcedure)
+	}
+	a.Handle(mux, server.SuppressionGatewayPath, suppressionGateway)
 
-	g, ctx := errgroup.WithContext(ctx)
+	// Diagram export of the live topology
+	simClient := simv1connect.NewSimulationControlClient(http.DefaultClient, simEngineURL)
+	a.Handle(mux, server.TopologyExportPath, usage.MeterExport(server.NewTopologyExporter(simClient, log)))
 
-	g.Go(func() error {
-		return streamHub.Start(ctx)
-	})
+	// Operator-labeled incidents with their metrics, as training data
+	a.Handle(mux, server.LabelExportPath, usage.MeterExport(server.NewLabelExporter(incidentsRepo, metricsRepo, log)))
 
-	g.Go(func() error {
-		log.Info("orchestrator API started", "addr", addr)
-		return httpServer.ListenAndServe()
-	})
+	// SSE streaming endpoint, and long polling for networks that break SSE
+	a.Handle(mux, server.StreamPath, usage.MeterStream(streamHub))
+	a.Handle(mux, server.PollPath, usage.MeterStream(http.HandlerFunc(streamHub.ServePoll)))
+	if streamHub.RecordingEnabled() {
+		var recordings http.Handler = http.HandlerFunc(streamHub.ServeRecordings)
+		if demoMode {
+			recordings = readOnlyMethods(recordings)
+		}
+		a.Handle(mux, server.StreamRecordingsPath, recordings)
+		a.Handle(mux, server.StreamReplayPath, usage.MeterStream(http.HandlerFunc(streamHub.ServeReplay)))
+	}
 
-	g.Go(func() error {
-		<-ctx.Done()
-		log.Info("shutting down...")
-		return httpServer.Close()
+	// Health check and storage counters (enum coercions) via expvar, the
+	// latter behind the admin token
+	a.RegisterHandlers(mux)
+
+	// Auth, usage and CORS middleware
+	apiKeys := parseAPIKeys(os.Getenv("API_KEYS"))
+	if len(apiKeys) == 0 {
+		log.Warn("API_KEYS not set, authentication disabled")
+	}
+	allowedOrigins := parseOrigins(os.Getenv("CORS_ALLOWED_ORIGINS"))
+	if len(allowedOrigins) > 0 {
+		log.Info("CORS restricted to allowed origins, credentials enabled", "origins", len(allowedOrigins))
+	}
+
+	// Other environments of the installation are reached through this
+	// orchestrator with the X-Environment header, and authenticated by their
+	// own orchestrators
+	routes, err := parseEnvironmentRoutes(os.Getenv("ENVIRONMENT_ROUTES"))
+	if err != nil {
+		return err
+	}
+	environments, err := server.NewEnvironmentRouter(busCfg.Environment,
+		authMiddleware(apiKeys, streamTokens, usage.Middleware(api)), routes, log)
+	if err != nil {
+		return err
+	}
+	if len(routes) > 0 {
+		log.Info("environment routing enabled", "environments", environments.Environments())
+	}
+	a.Handle(mux, server.EnvironmentsPath, http.HandlerFunc(environments.ServeEnvironments))
+
+	corsHandler := corsMiddleware(allowedOrigins, environments)
+
+	a.Serve(getEnv("ADDR", ":8081"), corsHandler)
+
+	a.Go("stream-hub", streamHub.Start)
+	a.Go("stream-activity", streamHub.RunActivity)
+	a.Consume("orchestrator-results", func(ctx context.Context) (app.Stopper, error) {
+		return subscriber.SubscribeActionResults(ctx, "orchestrator-results", actionServer.HandleActionResult)
+	})
+	a.Consume(bus.SubjectHeartbeat, func(ctx context.Context) (app.Stopper, error) {
+		return subscriber.SubscribeHeartbeats(ctx, components.HandleHeartbeat)
+	})
+	a.Consume("orchestrator-policy", func(ctx context.Context) (app.Stopper, error) {
+		return subscriber.SubscribeActions(ctx, "orchestrator-policy", actionServer.HandleProposedAction)
+	})
+	a.Go("policy-refresh", func(ctx context.Context) error {
+		return policies.Run(ctx, policyRefresh)
 	})
 
-	return g.Wait()
+	// Periodic consistency check; repairs only with CONSISTENCY_AUTO_REPAIR
+	if raw := os.Getenv("CONSISTENCY_CHECK_INTERVAL"); raw != "" {
+		interval, err := time.ParseDuration(raw)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("invalid CONSISTENCY_CHECK_INTERVAL %q", raw)
+		}
+		repair := os.Getenv("CONSISTENCY_AUTO_REPAIR") == "true"
+		log.Info("consistency checks enabled", "interval", interval, "repair", repair)
+		a.Go("consistency-checks", func(ctx context.Context) error {
+			return adminServer.RunConsistencyChecks(ctx, interval, repair)
+		})
+	}
+
+	// Drift from the desired configuration raises config_drift incidents
+	if desired != nil {
+		interval := time.Minute
+		if raw := os.Getenv("DRIFT_CHECK_INTERVAL"); raw != "" {
+			parsed, err := time.ParseDuration(raw)
+			if err != nil || parsed <= 0 {
+				return fmt.Errorf("invalid DRIFT_CHECK_INTERVAL %q", raw)
+			}
+			interval = parsed
+		}
+		log.Info("configuration drift checks enabled", "interval", interval)
+		a.Go("drift-checks", func(ctx context.Context) error {
+			return drift.Run(ctx, interval)
+		})
+	}
+
+	a.Go("heartbeat", publisher.RunHeartbeats)
+	return nil
 }
 
 func getEnv(key, fallback string) string {
@@ -135,11 +378,57 @@ func loggingInterceptor(log *slog.Logger) connect.UnaryInterceptorFunc {
 	}
 }
 
-func corsMiddleware(next http.Handler) http.Handler {
+// readOnlyGateway only forwards the given read procedures upstream.
+// Rejections use the Connect error body so clients decode them like RPC errors.
+func readOnlyGateway(next http.Handler, procedures ...string) http.Handler {
+	reads := make(map[string]bool, len(procedures))
+	for _, p := range procedures {
+		reads[p] = true
+	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Connect-Protocol-Version")
+		if !reads[r.URL.Path] {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, `{"code":"permission_denied","message":%q}`, app.DemoNotice)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readOnlyMethods rejects anything but GET on plain HTTP endpoints in demo mode
+func readOnlyMethods(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodOptions {
+			http.Error(w, app.DemoNotice, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsMiddleware allows any origin without credentials. With allowedOrigins
+// set, only those origins are allowed, with credentials, so the UI can send
+// the stream token cookie. The streaming endpoints only allow GET.
+func corsMiddleware(allowedOrigins map[string]bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(allowedOrigins) == 0 {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Add("Vary", "Origin")
+			if origin := r.Header.Get("Origin"); allowedOrigins[origin] {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		if server.IsStreamPath(r.URL.Path) {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Api-Version, Last-Event-ID, If-None-Match, X-Environment")
+		} else {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Connect-Protocol-Version, Authorization, X-API-Key, If-None-Match, Api-Version, X-Environment")
+		}
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, Not-Modified, Api-Version, Api-Supported-Versions, Deprecation, Sunset, Link, X-Environment")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -149,3 +438,214 @@ func corsMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// parseAPIKeys parses API_KEYS, a comma-separated list of keys, each
+// optionally named as name:key, into names by key. Usage is reported and
+// quotas are set by name; unnamed keys are named key-<first 8 hex digits of
+// the key's SHA-256>.
+func parseAPIKeys(raw string) map[string]string {
+	keys := make(map[string]string)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, key, ok := strings.Cut(entry, ":")
+		if !ok {
+			key = entry
+			sum := sha256.Sum256([]byte(key))
+			name = "key-" + hex.EncodeToString(sum[:4])
+		}
+		keys[strings.TrimSpace(key)] = strings.TrimSpace(name)
+	}
+	return keys
+}
+
+// usageTrackerFromEnv configures usage tracking from USAGE_WINDOW and
+// USAGE_QUOTAS, a comma-separated list of name.metric=limit entries such as
+// team-a.requests=10000,*.stream_minutes=600. Metrics are requests,
+// stream_minutes and export_bytes; the name * sets the default for keys
+// without their own.
+func usageTrackerFromEnv(log *slog.Logger) (*server.UsageTracker, error) {
+	window := server.DefaultUsageWindow
+	if raw := os.Getenv("USAGE_WINDOW"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid USAGE_WINDOW %q", raw)
+		}
+		window = parsed
+	}
+
+	quotas := make(map[string]server.Quota)
+	for _, entry := range strings.Split(os.Getenv("USAGE_QUOTAS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		target, limit, _ := strings.Cut(entry, "=")
+		dot := strings.LastIndex(target, ".")
+		if dot <= 0 {
+			return nil, fmt.Errorf("invalid USAGE_QUOTAS entry %q (want name.metric=limit)", entry)
+		}
+		name, metric := target[:dot], target[dot+1:]
+		n, err := strconv.ParseFloat(strings.TrimSpace(limit), 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid USAGE_QUOTAS limit %q", limit)
+		}
+
+		q := quotas[name]
+		switch metric {
+		case server.UsageRequests:
+			q.Requests = int64(n)
+		case server.UsageStreamMinutes:
+			q.StreamMinutes = n
+		case server.UsageExportBytes:
+			q.ExportBytes = int64(n)
+		default:
+			return nil, fmt.Errorf("unknown USAGE_QUOTAS metric %q", metric)
+		}
+		quotas[name] = q
+	}
+	if len(quotas) > 0 {
+		log.Info("usage quotas enabled", "window", window, "keys", len(quotas))
+	}
+	return server.NewUsageTracker(window, quotas), nil
+}
+
+// parseOrigins parses CORS_ALLOWED_ORIGINS, a comma-separated list of
+// origins such as https://parallax.example.com
+func parseOrigins(raw string) map[string]bool {
+	origins := make(map[string]bool)
+	for _, o := range strings.Split(raw, ",") {
+		if o = strings.TrimSuffix(strings.TrimSpace(o), "/"); o != "" {
+			origins[o] = true
+		}
+	}
+	return origins
+}
+
+// parseEnvironmentRoutes parses ENVIRONMENT_ROUTES, a comma-separated list
+// of name=url entries giving the orchestrator of each other environment,
+// such as staging=http://orchestrator-staging:8081
+func parseEnvironmentRoutes(raw string) (map[string]string, error) {
+	routes := make(map[string]string)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, target, ok := strings.Cut(entry, "=")
+		name, target = strings.TrimSpace(name), strings.TrimSpace(target)
+		if !ok || target == "" {
+			return nil, fmt.Errorf("invalid ENVIRONMENT_ROUTES entry %q (want name=url)", entry)
+		}
+		if name == "" {
+			return nil, fmt.Errorf("invalid ENVIRONMENT_ROUTES entry %q: the default environment cannot be routed to", entry)
+		}
+		if err := environment.Check(name); err != nil {
+			return nil, fmt.Errorf("invalid ENVIRONMENT_ROUTES entry %q: %w", entry, err)
+		}
+		routes[name] = target
+	}
+	return routes, nil
+}
+
+// streamTokensFromEnv configures stream tokens from STREAM_TOKEN_SECRET,
+// which replicas behind one load balancer must share, and STREAM_TOKEN_TTL.
+// Without a secret a random one is generated, valid on this replica only.
+func streamTokensFromEnv(log *slog.Logger) (*server.StreamTokens, error) {
+	ttl := server.DefaultStreamTokenTTL
+	if raw := os.Getenv("STREAM_TOKEN_TTL"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid STREAM_TOKEN_TTL %q", raw)
+		}
+		ttl = parsed
+	}
+
+	secret := []byte(os.Getenv("STREAM_TOKEN_SECRET"))
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("generate stream token secret: %w", err)
+		}
+		log.Warn("STREAM_TOKEN_SECRET not set, stream tokens only valid on this replica")
+	}
+	return server.NewStreamTokens(secret, ttl), nil
+}
+
+// parseDeprecations parses API_DEPRECATIONS, a comma-separated list of
+// prefix=sunset[;successor] entries such as
+// ops.v1.SimulationService=2027-06-30;ops.v2.SimulationService. The prefix
+// is a package, service or procedure; the sunset date may be empty.
+func parseDeprecations(raw string) (map[string]server.Deprecation, error) {
+	deprecations := make(map[string]server.Deprecation)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, rest, _ := strings.Cut(entry, "=")
+		sunset, successor, _ := strings.Cut(rest, ";")
+
+		var dep server.Deprecation
+		if sunset = strings.TrimSpace(sunset); sunset != "" {
+			t, err := time.Parse(time.DateOnly, sunset)
+			if err != nil {
+				return nil, fmt.Errorf("invalid API_DEPRECATIONS sunset %q: %w", sunset, err)
+			}
+			dep.Sunset = t
+		}
+		dep.Successor = strings.TrimSpace(successor)
+		deprecations["/"+strings.TrimPrefix(strings.TrimSpace(prefix), "/")] = dep
+	}
+	return deprecations, nil
+}
+
+// authMiddleware requires a valid API key (X-API-Key or Authorization: Bearer)
+// on every request except health checks, passing the key's name on in the
+// request context. GETs of the SSE endpoints may present a stream token
+// instead. With no keys configured it is a no-op.
+func authMiddleware(keys map[string]string, streamTokens *server.StreamTokens, next http.Handler) http.Handler {
+	if len(keys) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method == http.MethodGet && server.IsStreamPath(r.URL.Path) {
+			if name, ok := streamTokens.Authorized(r); ok {
+				next.ServeHTTP(w, r.WithContext(server.WithKeyName(r.Context(), name)))
+				return
+			}
+		}
+
+		key := r.Header.Get("X-API-Key")
+		if key == "" {
+			key = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		name, ok := matchKey(keys, key)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(server.WithKeyName(r.Context(), name)))
+	})
+}
+
+// matchKey returns the name of the configured key equal to key. Every key
+// is compared in constant time, so response timing does not reveal how
+// much of a guess matched.
+func matchKey(keys map[string]string, key string) (string, bool) {
+	var name string
+	var ok bool
+	for k, n := range keys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			name, ok = n, true
+		}
+	}
+	return name, ok && key != ""
+}
@@ -2,6 +2,8 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 
 	"connectrpc.com/connect"
@@ -15,18 +17,29 @@ import (
 
 // ActionServer implements the ActionService
 type ActionServer struct {
+	db          *storage.DB
 	actionsRepo *storage.ActionsRepository
 	publisher   *bus.Publisher
+	policies    *PolicyEngine
 	log         *slog.Logger
 }
 
 var _ opsv1connect.ActionServiceHandler = (*ActionServer)(nil)
 
-// NewActionServer creates a new action server
-func NewActionServer(actionsRepo *storage.ActionsRepository, publisher *bus.Publisher, log *slog.Logger) *ActionServer {
+// errApprovalSkipped stops an automatic approval that no longer applies
+var errApprovalSkipped = errors.New("approval skipped")
+
+// errNotPending rejects approving an action that was already decided
+var errNotPending = errors.New("action is not pending")
+
+// NewActionServer creates a new action server. Approvals are checked
+// against policies, which may be nil to approve only on operator request.
+func NewActionServer(db *storage.DB, publisher *bus.Publisher, policies *PolicyEngine, log *slog.Logger) *ActionServer {
 	return &ActionServer{
-		actionsRepo: actionsRepo,
+		db:          db,
+		actionsRepo: storage.NewActionsRepository(db),
 		publisher:   publisher,
+		policies:    policies,
 		log:         log,
 	}
 }
@@ -38,9 +51,12 @@ func (s *ActionServer) ListPendingActions(ctx context.Context, req *connect.Requ
 		limit = 50
 	}
 
-	rows, err := s.actionsRepo.ListPending(ctx, limit)
+	dbCtx, cancel := downstreamContext(ctx)
+	defer cancel()
+
+	rows, err := s.actionsRepo.ListPending(dbCtx, limit)
 	if err != nil {
-		return nil, connect.NewError(connect.CodeInternal, err)
+		return nil, listError(err, len(rows), lastActionID(rows))
 	}
 
 	actions := make([]*opsv1.Action, 0, len(rows))
@@ -53,20 +69,97 @@ func (s *ActionServer) ListPendingActions(ctx context.Context, req *connect.Requ
 	}), nil
 }
 
-// ApproveAction approves a pending action
+// ApproveAction approves a pending action, unless the action policies deny
+// it
 func (s *ActionServer) ApproveAction(ctx context.Context, req *connect.Request[opsv1.ApproveActionRequest]) (*connect.Response[opsv1.ApproveActionResponse], error) {
 	actionID := req.Msg.ActionId.Value
+	actor := KeyName(ctx)
 
-	action, err := s.actionsRepo.GetByID(ctx, actionID)
+	err := s.approve(ctx, actionID, func(ctx context.Context, action *storage.ActionRow) error {
+		if decision := s.policies.Evaluate(ctx, storage.PolicyApprove, *action, actor); !decision.Allowed {
+			return &policyDeniedError{reasons: decision.Reasons}
+		}
+		return nil
+	})
+	var denied *policyDeniedError
+	if errors.As(err, &denied) {
+		s.log.Info("action approval denied by policy", "action_id", actionID, "key", actor, "reasons", denied.reasons)
+		return nil, connect.NewError(connect.CodePermissionDenied, denied)
+	}
 	if err != nil {
-		return nil, connect.NewError(connect.CodeInternal, err)
+		return nil, err
 	}
-	if action == nil {
-		return nil, connect.NewError(connect.CodeNotFound, nil)
+
+	s.log.Info("action approved", "action_id", actionID)
+
+	return connect.NewResponse(&opsv1.ApproveActionResponse{
+		Success: true,
+		Message: "Action approved and command published",
+	}), nil
+}
+
+// HandleProposedAction approves an action proposed on ops.actions when the
+// action policies allow it without an operator. Actions already decided,
+// or missing because the agent-service failed to store them, are left.
+func (s *ActionServer) HandleProposedAction(ctx context.Context, proposed *opsv1.Action) error {
+	if !s.policies.Enabled() || proposed.Status != commonv1.ActionStatus_ACTION_STATUS_PENDING {
+		return nil
 	}
+	actionID := proposed.Id.GetValue()
 
-	if err := s.actionsRepo.Approve(ctx, actionID); err != nil {
-		return nil, connect.NewError(connect.CodeInternal, err)
+	err := s.approve(ctx, actionID, func(ctx context.Context, action *storage.ActionRow) error {
+		if decision := s.policies.Evaluate(ctx, storage.PolicyAutoApprove, *action, ""); !decision.Allowed {
+			return errApprovalSkipped
+		}
+		return nil
+	})
+	if errors.Is(err, errApprovalSkipped) || errors.Is(err, errNotPending) {
+		return nil
+	}
+	var connectErr *connect.Error
+	if errors.As(err, &connectErr) && connectErr.Code() == connect.CodeNotFound {
+		s.log.Warn("proposed action not stored, leaving it to operators", "action_id", actionID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.log.Info("action approved by policy", "action_id", actionID)
+	return nil
+}
+
+// approve marks a pending action approved and publishes its command. It
+// returns FailedPrecondition if the action was already decided. check runs
+// with the action locked, so a concurrent result or rejection cannot slip
+// in between; an error from it leaves the action as it was.
+func (s *ActionServer) approve(ctx context.Context, actionID string, check func(context.Context, *storage.ActionRow) error) error {
+	dbCtx, cancel := downstreamContext(ctx)
+	defer cancel()
+
+	var action *storage.ActionRow
+	err := s.db.WithTx(dbCtx, func(q storage.Queries) error {
+		var err error
+		if action, err = q.Actions.GetByIDForUpdate(dbCtx, actionID); err != nil {
+			return err
+		}
+		if status := commonv1.ActionStatus(action.Status); status != commonv1.ActionStatus_ACTION_STATUS_PENDING {
+			return fmt.Errorf("%w: %s is %s", errNotPending, actionID, status)
+		}
+		if err := check(dbCtx, action); err != nil {
+			return err
+		}
+		return q.Actions.Approve(dbCtx, actionID)
+	})
+	var denied *policyDeniedError
+	if errors.Is(err, errApprovalSkipped) || errors.As(err, &denied) {
+		return err
+	}
+	if errors.Is(err, errNotPending) {
+		return connect.NewError(connect.CodeFailedPrecondition, err)
+	}
+	if err != nil {
+		return repoError(err)
 	}
 
 	cmd := &opsv1.ApplyActionCommand{
@@ -77,17 +170,28 @@ func (s *ActionServer) ApproveAction(ctx context.Context, req *connect.Request[o
 		Parameters:   action.Parameters,
 	}
 
-	if err := s.publisher.PublishCommand(ctx, cmd); err != nil {
+	busCtx, busCancel := downstreamContext(ctx)
+	defer busCancel()
+
+	if err := s.publisher.PublishCommand(busCtx, cmd); err != nil {
 		s.log.Error("failed to publish command", "error", err)
-		return nil, connect.NewError(connect.CodeInternal, err)
+		return connect.NewError(connect.CodeInternal, err)
 	}
+	action.Status = int(commonv1.ActionStatus_ACTION_STATUS_APPROVED)
+	s.publishDecided(ctx, *action)
+	return nil
+}
 
-	s.log.Info("action approved", "action_id", actionID)
+// publishDecided republishes a decided action on ops.actions, so stream
+// clients and every orchestrator replica stop listing it as pending. The
+// decision is already stored, so a failure is only logged.
+func (s *ActionServer) publishDecided(ctx context.Context, action storage.ActionRow) {
+	busCtx, cancel := downstreamContext(ctx)
+	defer cancel()
 
-	return connect.NewResponse(&opsv1.ApproveActionResponse{
-		Success: true,
-		Message: "Action approved and command published",
-	}), nil
+	if err := s.publisher.PublishAction(busCtx, rowToAction(action)); err != nil {
+		s.log.Warn("failed to publish action decision", "action_id", action.ID, "error", err)
+	}
 }
 
 // RejectAction rejects a pending action
@@ -95,8 +199,16 @@ func (s *ActionServer) RejectAction(ctx context.Context, req *connect.Request[op
 	actionID := req.Msg.ActionId.Value
 	reason := req.Msg.Reason
 
-	if err := s.actionsRepo.Reject(ctx, actionID, reason); err != nil {
-		return nil, connect.NewError(connect.CodeInternal, err)
+	dbCtx, cancel := downstreamContext(ctx)
+	defer cancel()
+
+	if err := s.actionsRepo.Reject(dbCtx, actionID, reason); err != nil {
+		return nil, repoError(err)
+	}
+	if action, err := s.actionsRepo.GetByID(dbCtx, actionID); err != nil {
+		s.log.Warn("failed to load rejected action", "action_id", actionID, "error", err)
+	} else {
+		s.publishDecided(ctx, *action)
 	}
 
 	s.log.Info("action rejected", "action_id", actionID, "reason", reason)
@@ -113,9 +225,12 @@ func (s *ActionServer) GetActionHistory(ctx context.Context, req *connect.Reques
 		limit = 100
 	}
 
-	rows, err := s.actionsRepo.ListRecent(ctx, limit)
+	dbCtx, cancel := downstreamContext(ctx)
+	defer cancel()
+
+	rows, err := s.actionsRepo.ListRecent(dbCtx, limit)
 	if err != nil {
-		return nil, connect.NewError(connect.CodeInternal, err)
+		return nil, listError(err, len(rows), lastActionID(rows))
 	}
 
 	actions := make([]*opsv1.Action, 0, len(rows))
@@ -129,6 +244,40 @@ func (s *ActionServer) GetActionHistory(ctx context.Context, req *connect.Reques
 	}), nil
 }
 
+// HandleActionResult records the outcome of an approved action received on
+// ops.results. Results for unknown actions are logged and dropped.
+func (s *ActionServer) HandleActionResult(ctx context.Context, result *opsv1.ActionResult) error {
+	actionID := result.ActionId.GetValue()
+
+	var err error
+	if result.Success {
+		err = s.actionsRepo.MarkCompleted(ctx, actionID, result.Message)
+	} else {
+		err = s.actionsRepo.MarkFailed(ctx, actionID, result.Message)
+	}
+	if storage.IsNotFound(err) {
+		s.log.Warn("result for unknown action", "action_id", actionID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.log.Info("action result recorded",
+		"action_id", actionID,
+		"success", result.Success,
+		"tick", result.AppliedAtTick,
+	)
+	return nil
+}
+
+func lastActionID(rows []storage.ActionRow) string {
+	if len(rows) == 0 {
+		return ""
+	}
+	return rows[len(rows)-1].ID
+}
+
 func rowToAction(row storage.ActionRow) *opsv1.Action {
 	action := &opsv1.Action{
 		Id:             &commonv1.UUID{Value: row.ID},
@@ -150,6 +299,23 @@ func rowToAction(row storage.ActionRow) *opsv1.Action {
 			WallTimeUnixMs: row.ExecutedAt.UnixMilli(),
 		}
 	}
+	if row.ReasonKey != "" {
+		action.ReasonMessage = &commonv1.LocalizedMessage{Key: row.ReasonKey, Args: row.ReasonArgs}
+	}
 
 	return action
 }
+
+// repoError maps a repository error to the matching connect error code
+func repoError(err error) error {
+	switch {
+	case storage.IsNotFound(err):
+		return connect.NewError(connect.CodeNotFound, err)
+	case storage.IsInvalidEnum(err):
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	case storage.IsConflict(err):
+		return connect.NewError(connect.CodeFailedPrecondition, err)
+	default:
+		return connect.NewError(connect.CodeInternal, err)
+	}
+}
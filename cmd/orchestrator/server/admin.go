@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"connectrpc.com/connect"
+
+	"github.com/microcloud/bus"
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+	"github.com/microcloud/gen/go/ops/v1/opsv1connect"
+	"github.com/microcloud/storage"
+)
+
+// AdminServer implements the AdminService
+type AdminServer struct {
+	db         *storage.DB
+	bus        *bus.Bus
+	stormStore *bus.Store
+	usage      *UsageTracker
+	components *ComponentRegistry
+	drift      *DriftChecker
+	policies   *PolicyEngine
+	log        *slog.Logger
+}
+
+var _ opsv1connect.AdminServiceHandler = (*AdminServer)(nil)
+
+// NewAdminServer creates a new admin server
+func NewAdminServer(db *storage.DB, eventBus *bus.Bus, stormStore *bus.Store, usage *UsageTracker, components *ComponentRegistry, drift *DriftChecker, policies *PolicyEngine, log *slog.Logger) *AdminServer {
+	return &AdminServer{
+		db:         db,
+		bus:        eventBus,
+		stormStore: stormStore,
+		usage:      usage,
+		components: components,
+		drift:      drift,
+		policies:   policies,
+		log:        log,
+	}
+}
+
+// CheckConsistency reports references to deleted incidents, repairing them
+// when requested
+func (s *AdminServer) CheckConsistency(ctx context.Context, req *connect.Request[opsv1.CheckConsistencyRequest]) (*connect.Response[opsv1.CheckConsistencyResponse], error) {
+	dbCtx, cancel := downstreamContext(ctx)
+	defer cancel()
+
+	report, err := s.db.CheckConsistency(dbCtx, req.Msg.Repair)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	if req.Msg.Repair {
+		s.log.Info("consistency repaired", "dangling", len(report.Dangling), "repaired", report.Repaired)
+	}
+
+	resp := &opsv1.CheckConsistencyResponse{
+		Dangling: make([]*opsv1.DanglingReference, 0, len(report.Dangling)),
+		Repaired: report.Repaired,
+		DryRun:   !req.Msg.Repair,
+	}
+	for _, ref := range report.Dangling {
+		resp.Dangling = append(resp.Dangling, &opsv1.DanglingReference{
+			Table:    ref.Table,
+			Column:   ref.Column,
+			RowId:    ref.RowID,
+			TargetId: ref.TargetID,
+		})
+	}
+	return connect.NewResponse(resp), nil
+}
+
+// GetStormStatus returns the agent-service decider's storm mode status. A
+// decider that never saved one is reported as not in storm mode.
+func (s *AdminServer) GetStormStatus(ctx context.Context, req *connect.Request[opsv1.GetStormStatusRequest]) (*connect.Response[opsv1.GetStormStatusResponse], error) {
+	status := &opsv1.StormStatus{}
+	if _, err := s.stormStore.Get(ctx, bus.KeyStormStatus, status); err != nil {
+		return nil, connect.NewError(connect.CodeUnavailable, err)
+	}
+	return connect.NewResponse(&opsv1.GetStormStatusResponse{Status: status}), nil
+}
+
+// GetUsage returns the caller's API key usage in the current quota window,
+// or every key's with all_keys
+func (s *AdminServer) GetUsage(ctx context.Context, req *connect.Request[opsv1.GetUsageRequest]) (*connect.Response[opsv1.GetUsageResponse], error) {
+	var usage []KeyUsage
+	if req.Msg.AllKeys {
+		usage = s.usage.All()
+	} else {
+		usage = []KeyUsage{s.usage.Usage(KeyName(ctx))}
+	}
+
+	start, end := s.usage.Window()
+	resp := &opsv1.GetUsageResponse{
+		Usage:             make([]*opsv1.KeyUsage, 0, len(usage)),
+		WindowStartUnixMs: start.UnixMilli(),
+		WindowEndUnixMs:   end.UnixMilli(),
+	}
+	for _, u := range usage {
+		resp.Usage = append(resp.Usage, keyUsageProto(u))
+	}
+	return connect.NewResponse(resp), nil
+}
+
+// MigrateConsumer rebuilds a durable consumer as its next version. The
+// services consuming it drain the old version and cut over on their own.
+func (s *AdminServer) MigrateConsumer(ctx context.Context, req *connect.Request[opsv1.MigrateConsumerRequest]) (*connect.Response[opsv1.MigrateConsumerResponse], error) {
+	if req.Msg.Consumer == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("consumer is required"))
+	}
+	m, err := s.bus.MigrateConsumer(ctx, req.Msg.Consumer, nil)
+	if errors.Is(err, bus.ErrConsumerNotFound) {
+		return nil, connect.NewError(connect.CodeNotFound, err)
+	}
+	if err != nil {
+		return nil, connect.NewError(connect.CodeUnavailable, err)
+	}
+	s.log.Info("consumer migration requested", "key", KeyName(ctx), "from", m.From, "to", m.To)
+	return connect.NewResponse(&opsv1.MigrateConsumerResponse{
+		From:          m.From,
+		To:            m.To,
+		StartSequence: m.StartSeq,
+	}), nil
+}
+
+// RunConsistencyChecks checks the database every interval until ctx is
+// done, logging dangling references and repairing them if repair is set
+func (s *AdminServer) RunConsistencyChecks(ctx context.Context, interval time.Duration, repair bool) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		report, err := s.db.CheckConsistency(ctx, repair)
+		if err != nil {
+			s.log.Error("consistency check failed", "error", err)
+			continue
+		}
+		for _, ref := range report.Dangling {
+			s.log.Warn("dangling reference",
+				"table", ref.Table,
+				"column", ref.Column,
+				"row_id", ref.RowID,
+				"target_id", ref.TargetID,
+			)
+		}
+		if len(report.Dangling) > 0 {
+			s.log.Info("consistency check finished", "dangling", len(report.Dangling), "repaired", report.Repaired)
+		}
+	}
+}
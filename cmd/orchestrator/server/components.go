@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+
+	"github.com/microcloud/bus"
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+)
+
+// componentTTL is how long a component is listed after its last heartbeat
+const componentTTL = 3 * bus.DefaultHeartbeatInterval
+
+// ComponentRegistry tracks the services heard on services.heartbeat and
+// warns when one runs a version incompatible with the orchestrator's
+type ComponentRegistry struct {
+	self bus.Identity
+	log  *slog.Logger
+
+	mu         sync.Mutex
+	components map[string]*component // by instance
+}
+
+type component struct {
+	*opsv1.Component
+	config map[string]string // from the heartbeat, kept out of listings
+	seen   time.Time         // by the orchestrator's clock, which senders may not share
+}
+
+// NewComponentRegistry creates a registry judging versions against self
+func NewComponentRegistry(self bus.Identity, log *slog.Logger) *ComponentRegistry {
+	return &ComponentRegistry{
+		self:       self,
+		log:        log,
+		components: make(map[string]*component),
+	}
+}
+
+// HandleHeartbeat records a heartbeat, warning the first time an instance
+// is heard at an incompatible version
+func (r *ComponentRegistry) HandleHeartbeat(ctx context.Context, hb *opsv1.ServiceHeartbeat) error {
+	if hb.Instance == "" || hb.Instance == r.self.Instance {
+		return nil
+	}
+	compatible, reason := bus.Compatible(r.self.Version, hb.Version)
+	config := hb.Config
+	hb.Config = nil
+
+	r.mu.Lock()
+	prev, known := r.components[hb.Instance]
+	r.components[hb.Instance] = &component{
+		Component: &opsv1.Component{Heartbeat: hb, Compatible: compatible, Reason: reason},
+		config:    config,
+		seen:      time.Now(),
+	}
+	r.mu.Unlock()
+
+	if !known || prev.Heartbeat.Version != hb.Version {
+		if compatible {
+			r.log.Info("component connected", "service", hb.Service, "version", hb.Version, "commit", hb.Commit)
+		} else {
+			r.log.Warn("component runs incompatible version",
+				"service", hb.Service,
+				"instance", hb.Instance,
+				"version", hb.Version,
+				"commit", hb.Commit,
+				"orchestrator_version", r.self.Version,
+				"reason", reason,
+			)
+		}
+	}
+	return nil
+}
+
+// List returns the components heard within componentTTL, by service and
+// instance, dropping the rest
+func (r *ComponentRegistry) List() []*opsv1.Component {
+	cutoff := time.Now().Add(-componentTTL)
+
+	r.mu.Lock()
+	list := make([]*opsv1.Component, 0, len(r.components))
+	for instance, c := range r.components {
+		if c.seen.Before(cutoff) {
+			delete(r.components, instance)
+			continue
+		}
+		list = append(list, c.Component)
+	}
+	r.mu.Unlock()
+
+	slices.SortFunc(list, func(a, b *opsv1.Component) int {
+		if c := strings.Compare(a.Heartbeat.Service, b.Heartbeat.Service); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Heartbeat.Instance, b.Heartbeat.Instance)
+	})
+	return list
+}
+
+// Configs returns the configuration last reported by each component heard
+// within componentTTL, by service and instance
+func (r *ComponentRegistry) Configs() []*opsv1.ServiceConfig {
+	cutoff := time.Now().Add(-componentTTL)
+
+	r.mu.Lock()
+	configs := make([]*opsv1.ServiceConfig, 0, len(r.components))
+	for _, c := range r.components {
+		if c.seen.Before(cutoff) || c.config == nil {
+			continue
+		}
+		configs = append(configs, &opsv1.ServiceConfig{
+			Service:          c.Heartbeat.Service,
+			Instance:         c.Heartbeat.Instance,
+			Config:           c.config,
+			ReportedAtUnixMs: c.seen.UnixMilli(),
+		})
+	}
+	r.mu.Unlock()
+
+	slices.SortFunc(configs, func(a, b *opsv1.ServiceConfig) int {
+		if c := strings.Compare(a.Service, b.Service); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Instance, b.Instance)
+	})
+	return configs
+}
+
+// ListComponents returns the services heard on the bus and whether their
+// versions are compatible with the orchestrator's
+func (s *AdminServer) ListComponents(ctx context.Context, req *connect.Request[opsv1.ListComponentsRequest]) (*connect.Response[opsv1.ListComponentsResponse], error) {
+	return connect.NewResponse(&opsv1.ListComponentsResponse{
+		Orchestrator: s.components.self.Heartbeat(),
+		Components:   s.components.List(),
+	}), nil
+}
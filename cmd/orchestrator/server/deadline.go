@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"connectrpc.com/connect"
+)
+
+const (
+	// DefaultRPCTimeout bounds requests whose client sent no timeout
+	DefaultRPCTimeout = 10 * time.Second
+
+	// DeadlineMargin is reserved from every deadline for writing the response
+	DeadlineMargin = 50 * time.Millisecond
+
+	// downstreamShare is the fraction of the remaining budget a single DB or
+	// bus operation may use, leaving room to report partial results
+	downstreamShare = 0.8
+)
+
+// Response headers describing results collected before a deadline hit
+const (
+	HeaderPartialCount  = "Partial-Result-Count"
+	HeaderPartialLastID = "Partial-Result-Last-Id"
+)
+
+// DeadlineInterceptor bounds every unary RPC by the client's timeout (as
+// propagated by Connect) or defaultTimeout when none was sent, minus margin
+// so handlers can still respond before the client gives up.
+func DeadlineInterceptor(defaultTimeout, margin time.Duration) connect.UnaryInterceptorFunc {
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				deadline = time.Now().Add(defaultTimeout)
+			}
+			ctx, cancel := context.WithDeadline(ctx, deadline.Add(-margin))
+			defer cancel()
+			return next(ctx, req)
+		}
+	}
+}
+
+// downstreamContext derives a context for one DB or bus call that uses only
+// part of the request's remaining time budget
+func downstreamContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	remaining := time.Until(deadline)
+	return context.WithTimeout(ctx, time.Duration(float64(remaining)*downstreamShare))
+}
+
+// listError converts a list query failure into a Connect error. When the
+// query ran out of time after collecting some rows, the error is
+// DeadlineExceeded and carries how far it got so clients can resume.
+func listError(err error, partialCount int, lastID string) error {
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return connect.NewError(connect.CodeInternal, err)
+	}
+
+	cerr := connect.NewError(connect.CodeDeadlineExceeded, err)
+	cerr.Meta().Set(HeaderPartialCount, strconv.Itoa(partialCount))
+	if lastID != "" {
+		cerr.Meta().Set(HeaderPartialLastID, lastID)
+	}
+	return cerr
+}
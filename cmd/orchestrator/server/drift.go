@@ -0,0 +1,258 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/microcloud/app"
+	"github.com/microcloud/bus"
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+	"github.com/microcloud/id"
+	"github.com/microcloud/messages"
+	"github.com/microcloud/storage"
+)
+
+// DriftRuleName is the rule name of configuration drift incidents
+const DriftRuleName = "config_drift"
+
+// DesiredConfig is the declared configuration of each service, by service
+// name and then setting key as services report them, e.g.
+//
+//	{"signal-service": {"env.ANOMALY_DETECTION": "true", "rule.high_cpu.threshold": "90"}}
+//
+// Settings left out may take any value.
+type DesiredConfig map[string]map[string]string
+
+// LoadDesiredConfig reads a desired configuration file
+func LoadDesiredConfig(path string) (DesiredConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read desired config: %w", err)
+	}
+	var desired DesiredConfig
+	if err := json.Unmarshal(data, &desired); err != nil {
+		return nil, fmt.Errorf("parse desired config %s: %w", path, err)
+	}
+	return desired, nil
+}
+
+// diffConfig returns the settings of actual that differ from desired, by
+// key. Redacted settings cannot be compared and are skipped.
+func diffConfig(desired, actual map[string]string) []*opsv1.ConfigDrift {
+	var drift []*opsv1.ConfigDrift
+	for _, key := range slices.Sorted(maps.Keys(desired)) {
+		want := desired[key]
+		got, ok := actual[key]
+		switch {
+		case !ok:
+			drift = append(drift, &opsv1.ConfigDrift{Key: key, Desired: want, Missing: true})
+		case got == app.Redacted:
+		case got != want:
+			drift = append(drift, &opsv1.ConfigDrift{Key: key, Desired: want, Actual: got})
+		}
+	}
+	return drift
+}
+
+// DriftChecker compares the configuration services report in their
+// heartbeats against the desired state, raising a config_drift incident
+// for each instance that differs and resolving it once the instance
+// matches again or is gone. Incidents already recorded for an instance,
+// such as before a restart or by another replica, are taken over rather
+// than raised again.
+type DriftChecker struct {
+	desired    DesiredConfig // nil when there is no desired state
+	components *ComponentRegistry
+	self       func() map[string]string // the orchestrator's own configuration
+	incidents  *storage.IncidentsRepository
+	publisher  *bus.Publisher
+	log        *slog.Logger
+
+	mu     sync.Mutex
+	active map[string]*opsv1.Incident // by instance
+}
+
+// NewDriftChecker creates a drift checker. A nil desired state still
+// serves configurations, without drift.
+func NewDriftChecker(desired DesiredConfig, components *ComponentRegistry, self func() map[string]string, incidents *storage.IncidentsRepository, publisher *bus.Publisher, log *slog.Logger) *DriftChecker {
+	return &DriftChecker{
+		desired:    desired,
+		components: components,
+		self:       self,
+		incidents:  incidents,
+		publisher:  publisher,
+		log:        log,
+		active:     make(map[string]*opsv1.Incident),
+	}
+}
+
+// Configs returns the configuration of every instance heard from, the
+// orchestrator's first, with its drift from the desired state. A non-empty
+// service limits them to that service's instances.
+func (c *DriftChecker) Configs(service string) []*opsv1.ServiceConfig {
+	self := c.components.self
+	configs := []*opsv1.ServiceConfig{{
+		Service:          self.Service,
+		Instance:         self.Instance,
+		Config:           c.self(),
+		ReportedAtUnixMs: time.Now().UnixMilli(),
+	}}
+	configs = append(configs, c.components.Configs()...)
+
+	kept := configs[:0]
+	for _, cfg := range configs {
+		if service != "" && cfg.Service != service {
+			continue
+		}
+		if desired, ok := c.desired[cfg.Service]; ok {
+			cfg.Drift = diffConfig(desired, cfg.Config)
+		}
+		kept = append(kept, cfg)
+	}
+	return kept
+}
+
+// Check raises an incident for each instance newly drifted from the
+// desired state and resolves those of instances that no longer are
+func (c *DriftChecker) Check(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	drifted := make(map[string]bool)
+	for _, cfg := range c.Configs("") {
+		if c.active[cfg.Instance] == nil {
+			if recorded := c.recorded(ctx, cfg); recorded != nil {
+				c.active[cfg.Instance] = recorded
+			}
+		}
+		if len(cfg.Drift) == 0 {
+			continue
+		}
+		drifted[cfg.Instance] = true
+		if c.active[cfg.Instance] != nil {
+			continue
+		}
+		incident := driftIncident(cfg)
+		if err := c.publisher.PublishIncident(ctx, incident); err != nil {
+			c.log.Error("failed to publish drift incident", "service", cfg.Service, "error", err)
+			continue
+		}
+		c.active[cfg.Instance] = incident
+		c.log.Warn("configuration drift", "service", cfg.Service, "instance", cfg.Instance, "settings", len(cfg.Drift))
+	}
+
+	for instance, incident := range c.active {
+		if drifted[instance] {
+			continue
+		}
+		now := time.Now()
+		resolved := proto.Clone(incident).(*opsv1.Incident)
+		resolved.Resolved = true
+		resolved.ResolvedAt = &commonv1.SimulationTimestamp{WallTimeUnixMs: now.UnixMilli()}
+		if err := c.publisher.PublishIncident(ctx, resolved); err != nil {
+			c.log.Error("failed to publish drift resolution", "instance", instance, "error", err)
+			continue
+		}
+		// The agent-service stores incidents as they arrive, so the
+		// resolution is recorded here too in case it beats the insert
+		if err := c.incidents.MarkResolved(ctx, incident.Id.GetValue(), now); err != nil && !storage.IsNotFound(err) {
+			c.log.Error("failed to mark drift incident resolved", "incident_id", incident.Id.GetValue(), "error", err)
+		}
+		delete(c.active, instance)
+		c.log.Info("configuration drift resolved", "instance", instance)
+	}
+}
+
+// recorded returns the unresolved drift incident of cfg's instance in the
+// database, if there is one
+func (c *DriftChecker) recorded(ctx context.Context, cfg *opsv1.ServiceConfig) *opsv1.Incident {
+	row, err := c.incidents.GetActiveByFingerprint(ctx, driftFingerprint(cfg))
+	if err != nil {
+		if !storage.IsNotFound(err) {
+			c.log.Error("failed to look up drift incident", "instance", cfg.Instance, "error", err)
+		}
+		return nil
+	}
+	return rowToIncident(*row)
+}
+
+// Run checks for drift every interval until ctx is done
+func (c *DriftChecker) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		c.Check(ctx)
+	}
+}
+
+// driftIncident builds the incident for an instance's drift
+func driftIncident(cfg *opsv1.ServiceConfig) *opsv1.Incident {
+	keys := make([]string, len(cfg.Drift))
+	for i, d := range cfg.Drift {
+		keys[i] = d.Key
+	}
+	count := fmt.Sprintf("%d", len(cfg.Drift))
+	title := messages.New(messages.IncidentConfigDriftTitle,
+		"service", cfg.Service,
+		"instance", shortInstance(cfg.Instance),
+	)
+	description := messages.New(messages.IncidentConfigDriftDescription,
+		"count", count,
+		"keys", strings.Join(keys, ", "),
+	)
+	return &opsv1.Incident{
+		Id:                 &commonv1.UUID{Value: id.NewV7()},
+		DetectedAt:         &commonv1.SimulationTimestamp{WallTimeUnixMs: time.Now().UnixMilli()},
+		Severity:           commonv1.IncidentSeverity_INCIDENT_SEVERITY_WARNING,
+		Title:              title.String(),
+		Description:        description.String(),
+		SourceService:      "orchestrator",
+		AffectedIds:        []string{cfg.Instance},
+		RuleName:           DriftRuleName,
+		Metrics:            map[string]float64{"drifted_settings": float64(len(cfg.Drift))},
+		TitleMessage:       &commonv1.LocalizedMessage{Key: title.Key, Args: title.Args},
+		DescriptionMessage: &commonv1.LocalizedMessage{Key: description.Key, Args: description.Args},
+		Fingerprint:        driftFingerprint(cfg),
+		Occurrences:        1,
+	}
+}
+
+// driftFingerprint identifies the drift incidents of an instance
+func driftFingerprint(cfg *opsv1.ServiceConfig) string {
+	return DriftRuleName + "/" + cfg.Service + "/" + cfg.Instance
+}
+
+// shortInstance truncates instance IDs for titles
+func shortInstance(instance string) string {
+	if len(instance) > 8 {
+		return instance[:8]
+	}
+	return instance
+}
+
+// GetServiceConfigs returns the effective configuration of the services
+// heard on the bus, with their drift from the desired state
+func (s *AdminServer) GetServiceConfigs(ctx context.Context, req *connect.Request[opsv1.GetServiceConfigsRequest]) (*connect.Response[opsv1.GetServiceConfigsResponse], error) {
+	return connect.NewResponse(&opsv1.GetServiceConfigsResponse{
+		Configs:      s.drift.Configs(req.Msg.Service),
+		DesiredState: s.drift.desired != nil,
+	}), nil
+}
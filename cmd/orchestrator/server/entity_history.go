@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"connectrpc.com/connect"
+
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+)
+
+// Entity history limits
+const (
+	DefaultHistoryWindow = time.Hour
+	MaxHistoryWindow     = 7 * 24 * time.Hour
+	DefaultHistoryPoints = 60
+	MaxHistoryPoints     = 500
+	DefaultHistoryLimit  = 100
+)
+
+// entityKeyMetrics are the series returned when a request names none: the
+// built-in node and service metrics. An entity only has the ones of its kind.
+var entityKeyMetrics = []string{
+	"cpu_usage_percent",
+	"memory_usage_percent",
+	"disk_usage_percent",
+	"requests_per_second",
+	"error_rate_percent",
+	"latency_p99_ms",
+}
+
+// GetEntityHealthHistory returns a node or service's incidents, actions and
+// key metrics downsampled to sparklines over the requested window
+func (s *IncidentServer) GetEntityHealthHistory(ctx context.Context, req *connect.Request[opsv1.GetEntityHealthHistoryRequest]) (*connect.Response[opsv1.GetEntityHealthHistoryResponse], error) {
+	entityID := req.Msg.EntityId
+	if entityID == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("entity_id is required"))
+	}
+	window := DefaultHistoryWindow
+	if req.Msg.WindowSeconds > 0 {
+		window = min(time.Duration(req.Msg.WindowSeconds)*time.Second, MaxHistoryWindow)
+	}
+	points := DefaultHistoryPoints
+	if req.Msg.Points > 0 {
+		points = min(int(req.Msg.Points), MaxHistoryPoints)
+	}
+	limit := DefaultHistoryLimit
+	if req.Msg.Limit > 0 {
+		limit = int(req.Msg.Limit)
+	}
+	metricNames := req.Msg.MetricNames
+	if len(metricNames) == 0 {
+		metricNames = entityKeyMetrics
+	}
+
+	end := time.Now()
+	start := end.Add(-window)
+	bucket := max(window/time.Duration(points), time.Second)
+
+	dbCtx, cancel := downstreamContext(ctx)
+	defer cancel()
+
+	incidents, err := s.incidentsRepo.ListByEntity(dbCtx, entityID, start, limit)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	actions, err := s.actionsRepo.ListByTarget(dbCtx, entityID, start, limit)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	buckets, err := s.metricsRepo.EntitySeries(dbCtx, entityID, metricNames, start, end, bucket)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	resp := &opsv1.GetEntityHealthHistoryResponse{
+		Incidents:   make([]*opsv1.Incident, 0, len(incidents)),
+		Actions:     make([]*opsv1.Action, 0, len(actions)),
+		StartUnixMs: start.UnixMilli(),
+		EndUnixMs:   end.UnixMilli(),
+	}
+	for _, row := range incidents {
+		resp.Incidents = append(resp.Incidents, rowToIncident(row))
+	}
+	for _, row := range actions {
+		resp.Actions = append(resp.Actions, rowToAction(row))
+	}
+	// Buckets come ordered by metric, so each series is one run
+	var series *opsv1.MetricSeries
+	for _, b := range buckets {
+		if series == nil || series.MetricName != b.MetricName {
+			series = &opsv1.MetricSeries{MetricName: b.MetricName}
+			resp.Series = append(resp.Series, series)
+		}
+		series.Points = append(series.Points, &opsv1.MetricPoint{
+			TimeUnixMs: b.Bucket.UnixMilli(),
+			Avg:        b.AvgValue,
+			Max:        b.MaxValue,
+		})
+	}
+	return connect.NewResponse(resp), nil
+}
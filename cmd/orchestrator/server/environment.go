@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+)
+
+// HeaderEnvironment names the environment a request is for, and on
+// responses the one that served it. EventSource cannot send headers, so the
+// environment query parameter does the same for the streaming endpoints.
+const (
+	HeaderEnvironment = "X-Environment"
+	environmentParam  = "environment"
+)
+
+// EnvironmentsPath lists the environments a client can switch between
+const EnvironmentsPath = "/api/environments"
+
+// EnvironmentRouter lets one orchestrator front every environment of an
+// installation. Requests for its own environment, or naming none, are served
+// locally; requests for another are proxied to that environment's
+// orchestrator, which keeps its own streams and schema.
+type EnvironmentRouter struct {
+	self   string
+	local  http.Handler
+	remote map[string]http.Handler
+}
+
+// NewEnvironmentRouter routes requests between local, serving environment
+// self, and the orchestrators at targets, by environment name
+func NewEnvironmentRouter(self string, local http.Handler, targets map[string]string, log *slog.Logger) (*EnvironmentRouter, error) {
+	r := &EnvironmentRouter{
+		self:   self,
+		local:  local,
+		remote: make(map[string]http.Handler, len(targets)),
+	}
+	for env, target := range targets {
+		if env == self {
+			continue
+		}
+		proxy, err := newGateway("orchestrator-"+env, target, log)
+		if err != nil {
+			return nil, err
+		}
+		r.remote[env] = proxy
+	}
+	return r, nil
+}
+
+// Environments returns the environment names requests can be routed to,
+// sorted, starting with the router's own
+func (e *EnvironmentRouter) Environments() []string {
+	names := make([]string, 0, len(e.remote))
+	for env := range e.remote {
+		names = append(names, env)
+	}
+	slices.Sort(names)
+	return append([]string{e.self}, names...)
+}
+
+func (e *EnvironmentRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	env := r.Header.Get(HeaderEnvironment)
+	if env == "" {
+		env = r.URL.Query().Get(environmentParam)
+	}
+	if env == "" || env == e.self {
+		if e.self != "" {
+			w.Header().Set(HeaderEnvironment, e.self)
+		}
+		e.local.ServeHTTP(w, r)
+		return
+	}
+
+	proxy, ok := e.remote[env]
+	if !ok {
+		// Connect error body, so clients decode it like an RPC error
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"code":"not_found","message":%q}`, fmt.Sprintf("unknown environment %q", env))
+		return
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// ServeEnvironments lists the environments for an environment switcher
+func (e *EnvironmentRouter) ServeEnvironments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Current      string   `json:"current"`
+		Environments []string `json:"environments"`
+	}{e.self, e.Environments()})
+}
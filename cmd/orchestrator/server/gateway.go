@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"golang.org/x/net/http2"
+
+	"github.com/microcloud/gen/go/ops/v1/opsv1connect"
+	"github.com/microcloud/gen/go/sim/v1/simv1connect"
+)
+
+// Mux patterns for proxied RPCs
+const (
+	SimulationGatewayPath  = "/" + simv1connect.SimulationControlName + "/"
+	RuleGatewayPath        = "/" + opsv1connect.RuleServiceName + "/"
+	SuppressionGatewayPath = "/" + opsv1connect.SuppressionServiceName + "/"
+)
+
+// NewSimulationGateway returns a reverse proxy that forwards SimulationControl
+// RPCs to the sim-engine, so clients only need the orchestrator's origin.
+// The sim-engine serves h2c, so the proxy speaks cleartext HTTP/2 upstream;
+// this carries Connect, gRPC and gRPC-Web requests alike.
+func NewSimulationGateway(target string, log *slog.Logger) (http.Handler, error) {
+	return newGateway("sim-engine", target, log)
+}
+
+// NewRuleGateway returns a reverse proxy that forwards RuleService RPCs to
+// the signal-service, which owns the detection rules
+func NewRuleGateway(target string, log *slog.Logger) (http.Handler, error) {
+	return newGateway("signal-service", target, log)
+}
+
+// NewSuppressionGateway returns a reverse proxy that forwards
+// SuppressionService RPCs to the signal-service, which consults the
+// suppression windows before publishing incidents
+func NewSuppressionGateway(target string, log *slog.Logger) (http.Handler, error) {
+	return newGateway("signal-service", target, log)
+}
+
+// newGateway returns an h2c reverse proxy to the named upstream service
+func newGateway(service, target string, log *slog.Logger) (http.Handler, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s url: %w", service, err)
+	}
+	if u.Scheme != "http" {
+		return nil, fmt.Errorf("%s url must use http, got %q", service, u.Scheme)
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	proxy.Transport = &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		log.Error("proxy error", "service", service, "path", r.URL.Path, "error", err)
+		http.Error(w, service+" unavailable", http.StatusBadGateway)
+	}
+
+	return proxy, nil
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"connectrpc.com/connect"
+
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+	"github.com/microcloud/storage"
+)
+
+// LabelExportPath serves labeled incidents with the metrics around them as
+// a training dataset, one JSON object per line
+const LabelExportPath = "/api/incidents/labels/export"
+
+// Label export limits
+const (
+	DefaultLabelExportLimit  = 1000
+	MaxLabelExportLimit      = 10000
+	DefaultLabelExportWindow = 15 * time.Minute // before detection and after resolution
+	MaxLabelExportWindow     = 6 * time.Hour
+	DefaultLabelExportBucket = 10 * time.Second
+
+	// maxLabelExportEntities bounds the affected entities whose metrics are
+	// exported per incident, since correlated parents can span hundreds
+	maxLabelExportEntities = 20
+)
+
+// incidentLabels maps proto labels to the stored ones
+var incidentLabels = map[opsv1.IncidentLabel]string{
+	opsv1.IncidentLabel_INCIDENT_LABEL_TRUE_POSITIVE:        storage.LabelTruePositive,
+	opsv1.IncidentLabel_INCIDENT_LABEL_FALSE_POSITIVE:       storage.LabelFalsePositive,
+	opsv1.IncidentLabel_INCIDENT_LABEL_DUPLICATE:            storage.LabelDuplicate,
+	opsv1.IncidentLabel_INCIDENT_LABEL_EXPECTED_MAINTENANCE: storage.LabelExpectedMaintenance,
+}
+
+// LabelIncident records an operator's verdict on an incident
+func (s *IncidentServer) LabelIncident(ctx context.Context, req *connect.Request[opsv1.LabelIncidentRequest]) (*connect.Response[opsv1.LabelIncidentResponse], error) {
+	incidentID := req.Msg.IncidentId.GetValue()
+	if incidentID == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("incident_id is required"))
+	}
+	label, ok := incidentLabels[req.Msg.Label]
+	if !ok {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid label %s", req.Msg.Label))
+	}
+
+	dbCtx, cancel := downstreamContext(ctx)
+	defer cancel()
+
+	row := storage.IncidentLabelRow{
+		IncidentID: incidentID,
+		Label:      label,
+		Operator:   req.Msg.Operator,
+		Note:       req.Msg.Note,
+		LabeledAt:  time.Now(),
+	}
+	incident, err := s.incidentsRepo.SetLabel(dbCtx, row)
+	if err != nil {
+		return nil, repoError(err)
+	}
+
+	s.log.Info("incident labeled", "incident_id", incidentID, "label", label, "operator", req.Msg.Operator)
+
+	return connect.NewResponse(&opsv1.LabelIncidentResponse{
+		Incident:        rowToIncident(*incident),
+		Label:           req.Msg.Label,
+		LabeledAtUnixMs: row.LabeledAt.UnixMilli(),
+	}), nil
+}
+
+// LabelExporter serves LabelExportPath
+type LabelExporter struct {
+	incidentsRepo *storage.IncidentsRepository
+	metricsRepo   *storage.MetricsRepository
+	log           *slog.Logger
+}
+
+// NewLabelExporter creates a new label exporter
+func NewLabelExporter(incidentsRepo *storage.IncidentsRepository, metricsRepo *storage.MetricsRepository, log *slog.Logger) *LabelExporter {
+	return &LabelExporter{
+		incidentsRepo: incidentsRepo,
+		metricsRepo:   metricsRepo,
+		log:           log,
+	}
+}
+
+// labelExportQuery is what a client asks LabelExportPath for
+type labelExportQuery struct {
+	label   string    // stored label; empty for every label
+	since   time.Time // labeled at or after
+	limit   int
+	window  time.Duration
+	bucket  time.Duration
+	metrics []string // empty for the key metrics and the incident's own
+}
+
+// labeledRecord is one line of the export. Series are keyed by affected
+// entity, then metric.
+type labeledRecord struct {
+	Incident    *opsv1.Incident                          `json:"incident"`
+	Label       string                                   `json:"label"`
+	Operator    string                                   `json:"operator,omitempty"`
+	Note        string                                   `json:"note,omitempty"`
+	LabeledAt   time.Time                                `json:"labeled_at"`
+	WindowStart time.Time                                `json:"window_start"`
+	WindowEnd   time.Time                                `json:"window_end"`
+	Series      map[string]map[string][]labeledDataPoint `json:"series"`
+}
+
+type labeledDataPoint struct {
+	Time time.Time `json:"t"` // start of the bucket
+	Avg  float64   `json:"avg"`
+	Max  float64   `json:"max"`
+}
+
+// parseLabelExport reads ?label=, ?since= (RFC 3339), ?limit=, ?window=,
+// ?bucket= and repeated ?metric=
+func parseLabelExport(r *http.Request) (labelExportQuery, error) {
+	q := r.URL.Query()
+	query := labelExportQuery{
+		label:   q.Get("label"),
+		limit:   DefaultLabelExportLimit,
+		window:  DefaultLabelExportWindow,
+		bucket:  DefaultLabelExportBucket,
+		metrics: q["metric"],
+	}
+	if v := q.Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return query, fmt.Errorf("invalid since %q", v)
+		}
+		query.since = since
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return query, fmt.Errorf("invalid limit %q", v)
+		}
+		query.limit = min(limit, MaxLabelExportLimit)
+	}
+	if v := q.Get("window"); v != "" {
+		window, err := time.ParseDuration(v)
+		if err != nil || window < 0 {
+			return query, fmt.Errorf("invalid window %q", v)
+		}
+		query.window = min(window, MaxLabelExportWindow)
+	}
+	if v := q.Get("bucket"); v != "" {
+		bucket, err := time.ParseDuration(v)
+		if err != nil || bucket < time.Second {
+			return query, fmt.Errorf("invalid bucket %q", v)
+		}
+		query.bucket = bucket
+	}
+	return query, nil
+}
+
+// ServeHTTP writes labeled incidents in the order they were labeled, each
+// with its affected entities' metrics from window before detection to
+// window after resolution. Clients page by passing the last labeled_at as
+// since, dropping the incidents repeated at that instant.
+func (e *LabelExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query, err := parseLabelExport(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	labeled, err := e.incidentsRepo.ListLabeled(r.Context(), query.label, query.since, query.limit)
+	if storage.IsInvalidEnum(err) {
+		http.Error(w, fmt.Sprintf("invalid label %q", query.label), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		e.log.Error("failed to list labeled incidents", "error", err)
+		http.Error(w, "failed to list labeled incidents", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	enc := json.NewEncoder(w)
+	for _, l := range labeled {
+		record, err := e.record(r.Context(), l, query)
+		if err != nil {
+			// Headers are sent; a truncated body is all that can be said
+			e.log.Error("failed to export labeled incident", "incident_id", l.Incident.ID, "error", err)
+			return
+		}
+		if err := enc.Encode(record); err != nil {
+			return
+		}
+	}
+}
+
+// record builds the export line of one labeled incident
+func (e *LabelExporter) record(ctx context.Context, l storage.LabeledIncident, query labelExportQuery) (labeledRecord, error) {
+	end := l.Incident.DetectedAt
+	if l.Incident.ResolvedAt != nil {
+		end = *l.Incident.ResolvedAt
+	}
+	record := labeledRecord{
+		Incident:    rowToIncident(l.Incident),
+		Label:       l.Label.Label,
+		Operator:    l.Label.Operator,
+		Note:        l.Label.Note,
+		LabeledAt:   l.Label.LabeledAt,
+		WindowStart: l.Incident.DetectedAt.Add(-query.window),
+		WindowEnd:   end.Add(query.window),
+		Series:      make(map[string]map[string][]labeledDataPoint),
+	}
+
+	metricNames := query.metrics
+	if len(metricNames) == 0 {
+		metricNames = slices.Clone(entityKeyMetrics)
+		for name := range l.Incident.Metrics {
+			if !slices.Contains(metricNames, name) {
+				metricNames = append(metricNames, name)
+			}
+		}
+	}
+	entities := l.Incident.AffectedIDs[:min(len(l.Incident.AffectedIDs), maxLabelExportEntities)]
+	for _, entityID := range entities {
+		buckets, err := e.metricsRepo.EntitySeries(ctx, entityID, metricNames, record.WindowStart, record.WindowEnd, query.bucket)
+		if err != nil {
+			return record, err
+		}
+		if len(buckets) == 0 {
+			continue
+		}
+		series := make(map[string][]labeledDataPoint)
+		for _, b := range buckets {
+			series[b.MetricName] = append(series[b.MetricName], labeledDataPoint{Time: b.Bucket, Avg: b.AvgValue, Max: b.MaxValue})
+		}
+		record.Series[entityID] = series
+	}
+	return record, nil
+}
@@ -0,0 +1,293 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"connectrpc.com/connect"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+	"github.com/microcloud/gen/go/ops/v1/opsv1connect"
+	"github.com/microcloud/id"
+	"github.com/microcloud/messages"
+	"github.com/microcloud/storage"
+)
+
+// IncidentServer implements the IncidentService
+type IncidentServer struct {
+	incidentsRepo *storage.IncidentsRepository
+	actionsRepo   *storage.ActionsRepository
+	metricsRepo   *storage.MetricsRepository
+	hub           *StreamHub // nil without a stream
+	log           *slog.Logger
+}
+
+var _ opsv1connect.IncidentServiceHandler = (*IncidentServer)(nil)
+
+// NewIncidentServer creates a new incident server. Merges and splits are
+// passed to hub, which may be nil, since they are not republished on the bus.
+func NewIncidentServer(incidentsRepo *storage.IncidentsRepository, actionsRepo *storage.ActionsRepository, metricsRepo *storage.MetricsRepository, hub *StreamHub, log *slog.Logger) *IncidentServer {
+	return &IncidentServer{
+		incidentsRepo: incidentsRepo,
+		actionsRepo:   actionsRepo,
+		metricsRepo:   metricsRepo,
+		hub:           hub,
+		log:           log,
+	}
+}
+
+// ListIncidents returns unresolved incidents, most severe first, or every
+// incident newest first when IncludeResolved is set
+func (s *IncidentServer) ListIncidents(ctx context.Context, req *connect.Request[opsv1.ListIncidentsRequest]) (*connect.Response[opsv1.ListIncidentsResponse], error) {
+	limit := int(req.Msg.Limit)
+	if limit <= 0 {
+		limit = 50
+	}
+
+	dbCtx, cancel := downstreamContext(ctx)
+	defer cancel()
+
+	list := s.incidentsRepo.ListUnresolved
+	if req.Msg.IncludeResolved {
+		list = s.incidentsRepo.ListRecent
+	}
+	rows, err := list(dbCtx, limit)
+	if err != nil {
+		return nil, listError(err, len(rows), lastIncidentID(rows))
+	}
+
+	incidents := make([]*opsv1.Incident, 0, len(rows))
+	for _, row := range rows {
+		incidents = append(incidents, rowToIncident(row))
+	}
+
+	return connect.NewResponse(&opsv1.ListIncidentsResponse{
+		Incidents: incidents,
+	}), nil
+}
+
+// MergeIncidents folds several incidents into a new one
+func (s *IncidentServer) MergeIncidents(ctx context.Context, req *connect.Request[opsv1.MergeIncidentsRequest]) (*connect.Response[opsv1.MergeIncidentsResponse], error) {
+	ids := make([]string, 0, len(req.Msg.IncidentIds))
+	seen := make(map[string]bool, len(req.Msg.IncidentIds))
+	for _, id := range req.Msg.IncidentIds {
+		if !seen[id.GetValue()] {
+			seen[id.GetValue()] = true
+			ids = append(ids, id.GetValue())
+		}
+	}
+	if len(ids) < 2 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("at least two distinct incidents are required"))
+	}
+
+	dbCtx, cancel := downstreamContext(ctx)
+	defer cancel()
+
+	sources := make([]storage.IncidentRow, 0, len(ids))
+	sourceIDs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		row, err := s.incidentsRepo.GetByID(dbCtx, id)
+		if err != nil {
+			return nil, repoError(err)
+		}
+		if row.MergedInto != nil {
+			return nil, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("incident %s already merged", row.ID))
+		}
+		sources = append(sources, *row)
+		sourceIDs = append(sourceIDs, row.ID)
+	}
+
+	merged := mergeIncidentRows(sources, req.Msg.Title)
+	if err := s.incidentsRepo.Merge(dbCtx, merged, sourceIDs, req.Msg.Operator, req.Msg.Reason); err != nil {
+		return nil, repoError(err)
+	}
+
+	s.log.Info("incidents merged", "incident_id", merged.ID, "sources", sourceIDs, "operator", req.Msg.Operator)
+
+	if s.hub != nil {
+		changed := []*opsv1.Incident{rowToIncident(merged)}
+		for _, source := range sources {
+			source.Resolved, source.MergedInto = true, &merged.ID
+			changed = append(changed, rowToIncident(source))
+		}
+		s.hub.TrackIncidents(changed...)
+	}
+
+	return connect.NewResponse(&opsv1.MergeIncidentsResponse{
+		Incident: rowToIncident(merged),
+	}), nil
+}
+
+// SplitIncident carves an incident into several new ones by affected IDs
+func (s *IncidentServer) SplitIncident(ctx context.Context, req *connect.Request[opsv1.SplitIncidentRequest]) (*connect.Response[opsv1.SplitIncidentResponse], error) {
+	if len(req.Msg.Parts) < 2 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("at least two parts are required"))
+	}
+
+	dbCtx, cancel := downstreamContext(ctx)
+	defer cancel()
+
+	originalID := req.Msg.IncidentId.GetValue()
+	original, err := s.incidentsRepo.GetByID(dbCtx, originalID)
+	if err != nil {
+		return nil, repoError(err)
+	}
+	if original.MergedInto != nil {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("incident %s already merged", originalID))
+	}
+	// Splitting resolves the original, so this also covers one already split
+	if original.Resolved {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("incident %s is resolved", originalID))
+	}
+
+	affected := make(map[string]bool, len(original.AffectedIDs))
+	for _, id := range original.AffectedIDs {
+		affected[id] = true
+	}
+
+	parts := make([]storage.IncidentRow, 0, len(req.Msg.Parts))
+	for i, p := range req.Msg.Parts {
+		if len(p.AffectedIds) == 0 {
+			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("part %d has no affected IDs", i))
+		}
+		for _, id := range p.AffectedIds {
+			if !affected[id] {
+				return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("part %d: %s is not affected by incident %s", i, id, originalID))
+			}
+		}
+
+		part := *original
+		part.ID = id.NewV7()
+		part.AffectedIDs = p.AffectedIds
+		part.Resolved = false
+		part.ResolvedAt = nil
+		if p.Title != "" {
+			// Operator-written titles have no catalog entry
+			part.Title = p.Title
+			part.TitleKey, part.TitleArgs = "", nil
+		}
+		parts = append(parts, part)
+	}
+
+	if err := s.incidentsRepo.Split(dbCtx, originalID, parts, req.Msg.Operator, req.Msg.Reason); err != nil {
+		return nil, repoError(err)
+	}
+
+	incidents := make([]*opsv1.Incident, 0, len(parts))
+	for _, part := range parts {
+		part.SplitFrom = &originalID
+		incidents = append(incidents, rowToIncident(part))
+	}
+
+	s.log.Info("incident split", "incident_id", originalID, "parts", len(parts), "operator", req.Msg.Operator)
+
+	if s.hub != nil {
+		original.Resolved = true
+		s.hub.TrackIncidents(append([]*opsv1.Incident{rowToIncident(*original)}, incidents...)...)
+	}
+
+	return connect.NewResponse(&opsv1.SplitIncidentResponse{
+		Incidents: incidents,
+	}), nil
+}
+
+// mergeIncidentRows combines source incidents: earliest detection, highest
+// severity, union of affected IDs and the worst value seen for each metric
+func mergeIncidentRows(sources []storage.IncidentRow, title string) storage.IncidentRow {
+	merged := storage.IncidentRow{
+		ID:            id.NewV7(),
+		SourceService: "orchestrator",
+		Metrics:       make(map[string]float64),
+	}
+
+	seen := make(map[string]bool)
+	var mostSevere storage.IncidentRow
+	for i, src := range sources {
+		if i == 0 || src.DetectedAt.Before(merged.DetectedAt) {
+			merged.DetectedAt = src.DetectedAt
+			merged.TickID = src.TickID
+		}
+		if i == 0 || src.Severity > mostSevere.Severity {
+			mostSevere = src
+		}
+		for _, id := range src.AffectedIDs {
+			if !seen[id] {
+				seen[id] = true
+				merged.AffectedIDs = append(merged.AffectedIDs, id)
+			}
+		}
+		for k, v := range src.Metrics {
+			if cur, ok := merged.Metrics[k]; !ok || v > cur {
+				merged.Metrics[k] = v
+			}
+		}
+	}
+
+	merged.Severity = mostSevere.Severity
+	merged.RuleName = mostSevere.RuleName
+	merged.Title = title
+	if merged.Title == "" {
+		merged.Title = mostSevere.Title
+		merged.TitleKey, merged.TitleArgs = mostSevere.TitleKey, mostSevere.TitleArgs
+	}
+	description := messages.New(messages.IncidentMergedDescription, "count", fmt.Sprintf("%d", len(sources)))
+	merged.Description = description.String()
+	merged.DescriptionKey, merged.DescriptionArgs = description.Key, description.Args
+
+	return merged
+}
+
+func lastIncidentID(rows []storage.IncidentRow) string {
+	if len(rows) == 0 {
+		return ""
+	}
+	return rows[len(rows)-1].ID
+}
+
+func rowToIncident(row storage.IncidentRow) *opsv1.Incident {
+	incident := &opsv1.Incident{
+		Id: &commonv1.UUID{Value: row.ID},
+		DetectedAt: &commonv1.SimulationTimestamp{
+			TickId:         row.TickID,
+			WallTimeUnixMs: row.DetectedAt.UnixMilli(),
+		},
+		Severity:      commonv1.IncidentSeverity(row.Severity),
+		Title:         row.Title,
+		Description:   row.Description,
+		SourceService: row.SourceService,
+		AffectedIds:   row.AffectedIDs,
+		RuleName:      row.RuleName,
+		Metrics:       row.Metrics,
+		Resolved:      row.Resolved,
+		Fingerprint:   row.Fingerprint,
+		Occurrences:   int32(row.Occurrences),
+	}
+
+	if row.ResolvedAt != nil {
+		incident.ResolvedAt = &commonv1.SimulationTimestamp{
+			WallTimeUnixMs: row.ResolvedAt.UnixMilli(),
+		}
+	}
+	if row.MergedInto != nil {
+		incident.MergedIntoId = &commonv1.UUID{Value: *row.MergedInto}
+	}
+	if row.SplitFrom != nil {
+		incident.SplitFromId = &commonv1.UUID{Value: *row.SplitFrom}
+	}
+	if row.ParentID != nil {
+		incident.ParentId = &commonv1.UUID{Value: *row.ParentID}
+	}
+	if row.EscalatedAt != nil {
+		incident.EscalatedAt = &commonv1.SimulationTimestamp{WallTimeUnixMs: row.EscalatedAt.UnixMilli()}
+	}
+	if row.TitleKey != "" {
+		incident.TitleMessage = &commonv1.LocalizedMessage{Key: row.TitleKey, Args: row.TitleArgs}
+	}
+	if row.DescriptionKey != "" {
+		incident.DescriptionMessage = &commonv1.LocalizedMessage{Key: row.DescriptionKey, Args: row.DescriptionArgs}
+	}
+
+	return incident
+}
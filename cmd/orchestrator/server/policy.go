@@ -0,0 +1,529 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/open-policy-agent/opa/v1/bundle"
+	"github.com/open-policy-agent/opa/v1/loader"
+	"github.com/open-policy-agent/opa/v1/rego"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/microcloud/bus"
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+	"github.com/microcloud/id"
+	"github.com/microcloud/storage"
+)
+
+// PolicyPackage is the Rego package the action policies are written in.
+// Its rules, both optional:
+//
+//	deny contains msg if { ... }  # refuses approval, by operators or policy
+//	auto_approve if { ... }        # approves a proposed action without an operator
+//
+// The input is {"kind", "actor", "action", "incident", "recent_actions"}:
+// kind is "approve" or "auto_approve", actor the approver's API key name,
+// and the rest protobuf JSON with proto field names and enum value names.
+// recent_actions are the other actions on the same target in the last
+// hour, newest first.
+const PolicyPackage = "data.parallax.actions"
+
+// Policy sources and limits
+const (
+	PolicySourceFile = "file" // POLICY_BUNDLE
+	PolicySourceAPI  = "api"  // LoadPolicyBundle
+
+	// MaxPolicyBundleBytes keeps bundles within a NATS message
+	MaxPolicyBundleBytes = 512 << 10
+
+	// DefaultPolicyRefreshInterval is how often replicas pick up bundles
+	// loaded through another replica
+	DefaultPolicyRefreshInterval = 30 * time.Second
+
+	policyHistory      = time.Hour
+	policyHistoryLimit = 50
+)
+
+// ErrInvalidPolicyBundle is returned by Load for bundles that cannot be read
+// or compiled
+var ErrInvalidPolicyBundle = errors.New("invalid policy bundle")
+
+// policyKinds maps stored decision kinds to proto ones
+var policyKinds = map[string]opsv1.PolicyDecisionKind{
+	storage.PolicyApprove:     opsv1.PolicyDecisionKind_POLICY_DECISION_KIND_APPROVE,
+	storage.PolicyAutoApprove: opsv1.PolicyDecisionKind_POLICY_DECISION_KIND_AUTO_APPROVE,
+}
+
+// PolicyDecision is the outcome of evaluating the action policies
+type PolicyDecision struct {
+	Allowed bool
+	Reasons []string
+}
+
+// policySet is a compiled policy bundle
+type policySet struct {
+	query    rego.PreparedEvalQuery
+	source   string
+	revision string
+	modules  []string
+	loadedBy string
+	loadedAt time.Time
+}
+
+// PolicyEngine evaluates Rego action policies with embedded OPA and logs
+// every decision to the database. A bundle loaded through LoadPolicyBundle
+// is kept in a key-value bucket, so every replica enforces it and it
+// survives restarts; it takes precedence over POLICY_BUNDLE. Without any
+// policies operators approve every action and nothing is approved for them;
+// a nil engine behaves the same, for callers that never load policies.
+type PolicyEngine struct {
+	store     *bus.Store
+	decisions *storage.PolicyDecisionsRepository
+	incidents *storage.IncidentsRepository
+	actions   *storage.ActionsRepository
+	log       *slog.Logger
+
+	mu      sync.RWMutex
+	file    *policySet
+	api     *policySet
+	shared  *opsv1.PolicyBundle // last bundle read from the store, compiled or not
+	loadErr string              // why shared failed to compile
+}
+
+// NewPolicyEngine creates a policy engine with no policies
+func NewPolicyEngine(store *bus.Store, decisions *storage.PolicyDecisionsRepository, incidents *storage.IncidentsRepository, actions *storage.ActionsRepository, log *slog.Logger) *PolicyEngine {
+	return &PolicyEngine{
+		store:     store,
+		decisions: decisions,
+		incidents: incidents,
+		actions:   actions,
+		log:       log,
+	}
+}
+
+// LoadFile loads the fallback policies from a bundle directory or tarball
+func (e *PolicyEngine) LoadFile(ctx context.Context, path string) error {
+	b, err := loader.NewFileLoader().AsBundle(path)
+	if err != nil {
+		return fmt.Errorf("load policy bundle %s: %w", path, err)
+	}
+	set, err := compilePolicies(ctx, b)
+	if err != nil {
+		return fmt.Errorf("compile policy bundle %s: %w", path, err)
+	}
+	set.source = PolicySourceFile
+	set.revision = b.Manifest.Revision
+	set.loadedAt = time.Now()
+
+	e.mu.Lock()
+	e.file = set
+	e.mu.Unlock()
+	return nil
+}
+
+// Load compiles a gzipped bundle and shares it with every replica. An empty
+// bundle removes the shared one, reverting to the file policies.
+func (e *PolicyEngine) Load(ctx context.Context, data []byte, loadedBy string) (*opsv1.PolicyStatus, error) {
+	if len(data) > MaxPolicyBundleBytes {
+		return nil, fmt.Errorf("%w: %d bytes, over the %d byte limit", ErrInvalidPolicyBundle, len(data), MaxPolicyBundleBytes)
+	}
+	shared := &opsv1.PolicyBundle{
+		Bundle:         data,
+		LoadedBy:       loadedBy,
+		LoadedAtUnixMs: time.Now().UnixMilli(),
+	}
+	set, err := compileShared(ctx, shared)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPolicyBundle, err)
+	}
+	if err := e.store.Put(ctx, bus.KeyPolicyBundle, shared); err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	e.api, e.shared, e.loadErr = set, shared, ""
+	e.mu.Unlock()
+	return e.Status(), nil
+}
+
+// Refresh picks up a bundle another replica shared. A bundle that fails to
+// compile here leaves the current policies in force and is reported by
+// Status.
+func (e *PolicyEngine) Refresh(ctx context.Context) error {
+	shared := &opsv1.PolicyBundle{}
+	ok, err := e.store.Get(ctx, bus.KeyPolicyBundle, shared)
+	if err != nil || !ok {
+		return err
+	}
+
+	e.mu.RLock()
+	seen := e.shared != nil && e.shared.LoadedAtUnixMs == shared.LoadedAtUnixMs && e.shared.Revision == shared.Revision
+	e.mu.RUnlock()
+	if seen {
+		return nil
+	}
+
+	set, err := compileShared(ctx, shared)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.shared = shared
+	if err != nil {
+		e.loadErr = err.Error()
+		return err
+	}
+	e.api, e.loadErr = set, ""
+	e.log.Info("policy bundle loaded", "revision", shared.Revision, "loaded_by", shared.LoadedBy)
+	return nil
+}
+
+// Run refreshes the shared bundle every interval until ctx is done
+func (e *PolicyEngine) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if err := e.Refresh(ctx); err != nil {
+			e.log.Warn("failed to refresh policy bundle", "error", err)
+		}
+	}
+}
+
+// Enabled reports whether any policies are in force
+func (e *PolicyEngine) Enabled() bool {
+	return e.active() != nil
+}
+
+func (e *PolicyEngine) active() *policySet {
+	if e == nil {
+		return nil
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.api != nil {
+		return e.api
+	}
+	return e.file
+}
+
+// Status describes the policies in force
+func (e *PolicyEngine) Status() *opsv1.PolicyStatus {
+	if e == nil {
+		return &opsv1.PolicyStatus{}
+	}
+	set := e.active()
+	e.mu.RLock()
+	status := &opsv1.PolicyStatus{Error: e.loadErr}
+	e.mu.RUnlock()
+	if set == nil {
+		return status
+	}
+	status.Enabled = true
+	status.Source = set.source
+	status.Revision = set.revision
+	status.Modules = set.modules
+	status.LoadedBy = set.loadedBy
+	status.LoadedAtUnixMs = set.loadedAt.UnixMilli()
+	return status
+}
+
+// Config reports the policies in force for the service configuration
+func (e *PolicyEngine) Config() map[string]string {
+	status := e.Status()
+	return map[string]string{
+		"enabled":  fmt.Sprint(status.Enabled),
+		"source":   status.Source,
+		"revision": status.Revision,
+	}
+}
+
+// Evaluate decides whether action may be approved, for an operator (kind
+// storage.PolicyApprove) or without one (storage.PolicyAutoApprove), and
+// logs the decision. Evaluation failures deny, with the failure as reason.
+func (e *PolicyEngine) Evaluate(ctx context.Context, kind string, action storage.ActionRow, actor string) PolicyDecision {
+	set := e.active()
+	if set == nil {
+		return PolicyDecision{Allowed: kind == storage.PolicyApprove}
+	}
+
+	var (
+		decision PolicyDecision
+		result   any
+	)
+	input, err := e.input(ctx, kind, action, actor)
+	if err == nil {
+		result, err = evalPolicies(ctx, set, input)
+	}
+	if err == nil {
+		decision, err = decide(kind, result)
+	}
+	if err != nil {
+		e.log.Error("policy evaluation failed", "action_id", action.ID, "kind", kind, "error", err)
+		decision = PolicyDecision{Reasons: []string{"policy evaluation failed: " + err.Error()}}
+	}
+
+	inputJSON, _ := json.Marshal(input)
+	var resultJSON []byte
+	if result != nil {
+		resultJSON, _ = json.Marshal(result)
+	}
+	err = e.decisions.Create(ctx, storage.PolicyDecisionRow{
+		ID:             id.NewV7(),
+		ActionID:       action.ID,
+		Kind:           kind,
+		Allowed:        decision.Allowed,
+		Reasons:        decision.Reasons,
+		Actor:          actor,
+		BundleRevision: set.revision,
+		Input:          inputJSON,
+		Result:         resultJSON,
+	})
+	if err != nil {
+		e.log.Error("failed to log policy decision", "action_id", action.ID, "error", err)
+	}
+	return decision
+}
+
+// Decisions returns up to limit logged decisions made before before, newest
+// first, optionally only those on one action
+func (e *PolicyEngine) Decisions(ctx context.Context, actionID string, before time.Time, limit int) ([]storage.PolicyDecisionRow, error) {
+	return e.decisions.List(ctx, actionID, before, limit)
+}
+
+// input builds the input document for an action
+func (e *PolicyEngine) input(ctx context.Context, kind string, action storage.ActionRow, actor string) (map[string]any, error) {
+	doc := map[string]any{
+		"kind":  kind,
+		"actor": actor,
+	}
+	var err error
+	if doc["action"], err = protoDoc(rowToAction(action)); err != nil {
+		return doc, err
+	}
+
+	if action.IncidentID != "" {
+		incident, err := e.incidents.GetByID(ctx, action.IncidentID)
+		switch {
+		case err == nil:
+			if doc["incident"], err = protoDoc(rowToIncident(*incident)); err != nil {
+				return doc, err
+			}
+		case !storage.IsNotFound(err):
+			return doc, err
+		}
+	}
+
+	rows, err := e.actions.ListByTarget(ctx, action.TargetID, time.Now().Add(-policyHistory), policyHistoryLimit)
+	if err != nil {
+		return doc, err
+	}
+	recent := make([]any, 0, len(rows))
+	for _, row := range rows {
+		if row.ID == action.ID {
+			continue
+		}
+		d, err := protoDoc(rowToAction(row))
+		if err != nil {
+			return doc, err
+		}
+		recent = append(recent, d)
+	}
+	doc["recent_actions"] = recent
+	return doc, nil
+}
+
+// protoDoc converts a message to a Rego value
+func protoDoc(m proto.Message) (any, error) {
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// evalPolicies returns the value of PolicyPackage for input, or nil if the
+// package defines no rules that apply
+func evalPolicies(ctx context.Context, set *policySet, input map[string]any) (any, error) {
+	rs, err := set.query.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return nil, err
+	}
+	if len(rs) == 0 || len(rs[0].Expressions) == 0 {
+		return nil, nil
+	}
+	return rs[0].Expressions[0].Value, nil
+}
+
+// decide reads a decision from the value of PolicyPackage
+func decide(kind string, result any) (PolicyDecision, error) {
+	doc, _ := result.(map[string]any)
+	var decision PolicyDecision
+	if deny, ok := doc["deny"]; ok {
+		msgs, ok := deny.([]any)
+		if !ok {
+			return decision, fmt.Errorf("deny must be a set of messages, got %T", deny)
+		}
+		for _, msg := range msgs {
+			decision.Reasons = append(decision.Reasons, fmt.Sprint(msg))
+		}
+		slices.Sort(decision.Reasons)
+	}
+	if len(decision.Reasons) > 0 {
+		return decision, nil
+	}
+
+	switch kind {
+	case storage.PolicyApprove:
+		decision.Allowed = true
+	case storage.PolicyAutoApprove:
+		auto, ok := doc["auto_approve"]
+		if !ok {
+			return decision, nil
+		}
+		if decision.Allowed, ok = auto.(bool); !ok {
+			return decision, fmt.Errorf("auto_approve must be a boolean, got %T", auto)
+		}
+	}
+	return decision, nil
+}
+
+// compileShared compiles a bundle loaded through LoadPolicyBundle, filling
+// in its revision. An empty bundle compiles to no policies.
+func compileShared(ctx context.Context, shared *opsv1.PolicyBundle) (*policySet, error) {
+	if len(shared.Bundle) == 0 {
+		return nil, nil
+	}
+	b, err := bundle.NewReader(bytes.NewReader(shared.Bundle)).Read()
+	if err != nil {
+		return nil, fmt.Errorf("read policy bundle: %w", err)
+	}
+	if shared.Revision == "" {
+		shared.Revision = b.Manifest.Revision
+	}
+	if shared.Revision == "" {
+		sum := sha256.Sum256(shared.Bundle)
+		shared.Revision = "sha256:" + hex.EncodeToString(sum[:6])
+	}
+	set, err := compilePolicies(ctx, &b)
+	if err != nil {
+		return nil, err
+	}
+	set.source = PolicySourceAPI
+	set.revision = shared.Revision
+	set.loadedBy = shared.LoadedBy
+	set.loadedAt = time.UnixMilli(shared.LoadedAtUnixMs)
+	return set, nil
+}
+
+// compilePolicies prepares PolicyPackage from a bundle, which must define it
+func compilePolicies(ctx context.Context, b *bundle.Bundle) (*policySet, error) {
+	set := &policySet{}
+	found := false
+	for _, m := range b.Modules {
+		set.modules = append(set.modules, m.Path)
+		if m.Parsed != nil && m.Parsed.Package.Path.String() == PolicyPackage {
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("bundle has no module in package %s", strings.TrimPrefix(PolicyPackage, "data."))
+	}
+	slices.Sort(set.modules)
+
+	query, err := rego.New(
+		rego.Query(PolicyPackage),
+		rego.ParsedBundle("actions", b),
+	).PrepareForEval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	set.query = query
+	return set, nil
+}
+
+// LoadPolicyBundle replaces the action policies on every replica
+func (s *AdminServer) LoadPolicyBundle(ctx context.Context, req *connect.Request[opsv1.LoadPolicyBundleRequest]) (*connect.Response[opsv1.LoadPolicyBundleResponse], error) {
+	status, err := s.policies.Load(ctx, req.Msg.Bundle, KeyName(ctx))
+	if errors.Is(err, ErrInvalidPolicyBundle) {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if err != nil {
+		return nil, connect.NewError(connect.CodeUnavailable, err)
+	}
+	s.log.Info("policy bundle loaded", "key", KeyName(ctx), "revision", status.Revision, "modules", len(status.Modules))
+	return connect.NewResponse(&opsv1.LoadPolicyBundleResponse{Status: status}), nil
+}
+
+// GetPolicyStatus describes the policies in force on this replica
+func (s *AdminServer) GetPolicyStatus(ctx context.Context, req *connect.Request[opsv1.GetPolicyStatusRequest]) (*connect.Response[opsv1.GetPolicyStatusResponse], error) {
+	return connect.NewResponse(&opsv1.GetPolicyStatusResponse{Status: s.policies.Status()}), nil
+}
+
+// ListPolicyDecisions returns the policy decision log, newest first
+func (s *AdminServer) ListPolicyDecisions(ctx context.Context, req *connect.Request[opsv1.ListPolicyDecisionsRequest]) (*connect.Response[opsv1.ListPolicyDecisionsResponse], error) {
+	limit := int(req.Msg.Limit)
+	if limit <= 0 {
+		limit = 50
+	}
+	limit = min(limit, 500)
+	var before time.Time
+	if req.Msg.BeforeUnixMs > 0 {
+		before = time.UnixMilli(req.Msg.BeforeUnixMs)
+	}
+
+	dbCtx, cancel := downstreamContext(ctx)
+	defer cancel()
+
+	rows, err := s.policies.Decisions(dbCtx, req.Msg.ActionId.GetValue(), before, limit)
+	if err != nil {
+		return nil, repoError(err)
+	}
+
+	decisions := make([]*opsv1.PolicyDecision, 0, len(rows))
+	for _, row := range rows {
+		decisions = append(decisions, &opsv1.PolicyDecision{
+			Id:              &commonv1.UUID{Value: row.ID},
+			ActionId:        &commonv1.UUID{Value: row.ActionID},
+			Kind:            policyKinds[row.Kind],
+			Allowed:         row.Allowed,
+			Reasons:         row.Reasons,
+			Actor:           row.Actor,
+			BundleRevision:  row.BundleRevision,
+			InputJson:       string(row.Input),
+			ResultJson:      string(row.Result),
+			DecidedAtUnixMs: row.DecidedAt.UnixMilli(),
+		})
+	}
+	return connect.NewResponse(&opsv1.ListPolicyDecisionsResponse{Decisions: decisions}), nil
+}
+
+// policyDeniedError is returned when the policies refuse an approval
+type policyDeniedError struct {
+	reasons []string
+}
+
+func (e *policyDeniedError) Error() string {
+	return "denied by policy: " + strings.Join(e.reasons, "; ")
+}
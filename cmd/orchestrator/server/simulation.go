@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"connectrpc.com/connect"
+
+	"github.com/microcloud/bus"
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+	"github.com/microcloud/gen/go/ops/v1/opsv1connect"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+	"github.com/microcloud/id"
+)
+
+// SimulationServer implements the SimulationService by publishing control
+// commands to sim.control for the sim-engine to apply
+type SimulationServer struct {
+	publisher *bus.Publisher
+	log       *slog.Logger
+}
+
+var _ opsv1connect.SimulationServiceHandler = (*SimulationServer)(nil)
+
+// NewSimulationServer creates a new simulation server
+func NewSimulationServer(publisher *bus.Publisher, log *slog.Logger) *SimulationServer {
+	return &SimulationServer{
+		publisher: publisher,
+		log:       log,
+	}
+}
+
+// SetSimulationState publishes a set-state command (play/pause/stop)
+func (s *SimulationServer) SetSimulationState(ctx context.Context, req *connect.Request[opsv1.SetSimulationStateRequest]) (*connect.Response[opsv1.SetSimulationStateResponse], error) {
+	if req.Msg.State == commonv1.SimulationState_SIMULATION_STATE_UNSPECIFIED {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("state is required"))
+	}
+
+	commandID, err := s.send(ctx, &simv1.ControlCommand{
+		Command: &simv1.ControlCommand_SetState{
+			SetState: &simv1.SetStateRequest{State: req.Msg.State},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return connect.NewResponse(&opsv1.SetSimulationStateResponse{
+		CommandId: commandID,
+	}), nil
+}
+
+// SetSimulationSpeed publishes a set-speed command
+func (s *SimulationServer) SetSimulationSpeed(ctx context.Context, req *connect.Request[opsv1.SetSimulationSpeedRequest]) (*connect.Response[opsv1.SetSimulationSpeedResponse], error) {
+	if req.Msg.SpeedMultiplier <= 0 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("speed_multiplier must be positive"))
+	}
+
+	commandID, err := s.send(ctx, &simv1.ControlCommand{
+		Command: &simv1.ControlCommand_SetSpeed{
+			SetSpeed: &simv1.SetSpeedRequest{SpeedMultiplier: req.Msg.SpeedMultiplier},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return connect.NewResponse(&opsv1.SetSimulationSpeedResponse{
+		CommandId: commandID,
+	}), nil
+}
+
+// LoadSimulationScenario publishes a load-scenario command
+func (s *SimulationServer) LoadSimulationScenario(ctx context.Context, req *connect.Request[opsv1.LoadSimulationScenarioRequest]) (*connect.Response[opsv1.LoadSimulationScenarioResponse], error) {
+	if req.Msg.ScenarioName == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("scenario_name is required"))
+	}
+
+	commandID, err := s.send(ctx, &simv1.ControlCommand{
+		Command: &simv1.ControlCommand_LoadScenario{
+			LoadScenario: &simv1.LoadScenarioRequest{
+				ScenarioName: req.Msg.ScenarioName,
+				Parameters:   req.Msg.Parameters,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return connect.NewResponse(&opsv1.LoadSimulationScenarioResponse{
+		CommandId: commandID,
+	}), nil
+}
+
+// InjectSimulationFault publishes an inject-fault command
+func (s *SimulationServer) InjectSimulationFault(ctx context.Context, req *connect.Request[opsv1.InjectSimulationFaultRequest]) (*connect.Response[opsv1.InjectSimulationFaultResponse], error) {
+	msg := req.Msg
+	if msg.TargetId == "" || msg.FaultType == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("target_id and fault_type are required"))
+	}
+
+	commandID, err := s.send(ctx, &simv1.ControlCommand{
+		Command: &simv1.ControlCommand_InjectFault{
+			InjectFault: &simv1.InjectFaultRequest{
+				TargetId:        msg.TargetId,
+				FaultType:       msg.FaultType,
+				Magnitude:       msg.Magnitude,
+				DurationSeconds: msg.DurationSeconds,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return connect.NewResponse(&opsv1.InjectSimulationFaultResponse{
+		CommandId: commandID,
+	}), nil
+}
+
+func (s *SimulationServer) send(ctx context.Context, cmd *simv1.ControlCommand) (*commonv1.UUID, error) {
+	cmd.CommandId = &commonv1.UUID{Value: id.New()}
+
+	busCtx, cancel := downstreamContext(ctx)
+	defer cancel()
+
+	if err := s.publisher.PublishControlCommand(busCtx, cmd); err != nil {
+		s.log.Error("failed to publish control command", "error", err)
+		return nil, connect.NewError(connect.CodeUnavailable, err)
+	}
+
+	s.log.Info("control command published", "command_id", cmd.CommandId.Value)
+	return cmd.CommandId, nil
+}
@@ -25,15 +25,60 @@ type StreamHub struct {
 	latestSnapshot *simv1.MetricSnapshot
 	latestIncident *opsv1.Incident
 	latestAction   *opsv1.Action
+
+	// Initial state for new clients, primed from the database and kept
+	// current by the subscriptions
+	openIncidents  []*opsv1.Incident
+	pendingActions []*opsv1.Action
+
+	recordingDir string
+	recMu        sync.Mutex
+	recording    *streamRecording
+
+	pollHistory int
+	polls       *pollLog
+
+	maxHistory time.Duration // how far back ?history= may reach
+
+	activity *bus.Publisher // nil unless WithClientActivity
+}
+
+// StreamOption configures the StreamHub
+type StreamOption func(*StreamHub)
+
+// WithRecordingDir enables recording the broadcast stream to files in dir
+func WithRecordingDir(dir string) StreamOption {
+	return func(h *StreamHub) {
+		h.recordingDir = dir
+	}
 }
 
 // NewStreamHub creates a new stream hub
-func NewStreamHub(subscriber *bus.Subscriber, log *slog.Logger) *StreamHub {
-	return &StreamHub{
-		subscriber: subscriber,
-		log:        log,
-		clients:    make(map[chan []byte]struct{}),
+func NewStreamHub(subscriber *bus.Subscriber, log *slog.Logger, opts ...StreamOption) *StreamHub {
+	h := &StreamHub{
+		subscriber:  subscriber,
+		log:         log,
+		clients:     make(map[chan []byte]struct{}),
+		pollHistory: DefaultPollHistory,
+		maxHistory:  DefaultMaxStreamHistory,
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.polls = newPollLog(h.pollHistory)
+	return h
+}
+
+// RecordingEnabled reports whether a recording directory is configured
+func (h *StreamHub) RecordingEnabled() bool {
+	return h.recordingDir != ""
+}
+
+// ClientCount returns the number of connected SSE clients
+func (h *StreamHub) ClientCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
 }
 
 // Start begins listening to NATS subjects and broadcasting to clients
@@ -48,7 +93,7 @@ func (h *StreamHub) Start(ctx context.Context) error {
 			"type":    "metrics",
 			"payload": snapshot,
 		})
-		h.broadcast(data)
+		h.broadcastMetrics(data)
 		return nil
 	})
 	if err != nil {
@@ -59,6 +104,7 @@ func (h *StreamHub) Start(ctx context.Context) error {
 	incidentsCC, err := h.subscriber.SubscribeIncidents(ctx, "orchestrator-incidents", func(ctx context.Context, incident *opsv1.Incident) error {
 		h.mu.Lock()
 		h.latestIncident = incident
+		h.trackIncident(incident)
 		h.mu.Unlock()
 
 		data, _ := json.Marshal(map[string]any{
@@ -77,6 +123,7 @@ func (h *StreamHub) Start(ctx context.Context) error {
 	actionsCC, err := h.subscriber.SubscribeActions(ctx, "orchestrator-actions", func(ctx context.Context, action *opsv1.Action) error {
 		h.mu.Lock()
 		h.latestAction = action
+		h.trackAction(action)
 		h.mu.Unlock()
 
 		data, _ := json.Marshal(map[string]any{
@@ -92,17 +139,71 @@ func (h *StreamHub) Start(ctx context.Context) error {
 		return fmt.Errorf("subscribe actions: %w", err)
 	}
 
+	// Subscribe to simulation events (action effects and operator changes)
+	eventsCC, err := h.subscriber.SubscribeSimEvents(ctx, "orchestrator-sim-events", func(ctx context.Context, event *simv1.SimulationEvent) error {
+		data, _ := json.Marshal(map[string]any{
+			"type":    "event",
+			"payload": event,
+		})
+		h.broadcast(data)
+		return nil
+	})
+	if err != nil {
+		metricsCC.Stop()
+		incidentsCC.Stop()
+		actionsCC.Stop()
+		return fmt.Errorf("subscribe sim events: %w", err)
+	}
+
+	// Subscribe to action results (execution outcomes of approved actions)
+	resultsCC, err := h.subscriber.SubscribeActionResults(ctx, "orchestrator-stream-results", func(ctx context.Context, result *opsv1.ActionResult) error {
+		h.mu.Lock()
+		h.untrackAction(result.ActionId.GetValue())
+		h.mu.Unlock()
+
+		data, _ := json.Marshal(map[string]any{
+			"type":    "action_result",
+			"payload": result,
+		})
+		h.broadcast(data)
+		return nil
+	})
+	if err != nil {
+		metricsCC.Stop()
+		incidentsCC.Stop()
+		actionsCC.Stop()
+		eventsCC.Stop()
+		return fmt.Errorf("subscribe action results: %w", err)
+	}
+
 	h.log.Info("stream hub started")
 
 	<-ctx.Done()
+	h.StopRecording()
 	metricsCC.Stop()
 	incidentsCC.Stop()
 	actionsCC.Stop()
+	eventsCC.Stop()
+	resultsCC.Stop()
 
 	return ctx.Err()
 }
 
 func (h *StreamHub) broadcast(data []byte) {
+	h.polls.add(data)
+	h.fanOut(data)
+}
+
+// broadcastMetrics is broadcast for snapshots, of which polling clients
+// only get the latest
+func (h *StreamHub) broadcastMetrics(data []byte) {
+	h.polls.setMetrics(data)
+	h.fanOut(data)
+}
+
+func (h *StreamHub) fanOut(data []byte) {
+	h.record(data)
+
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
@@ -128,13 +229,20 @@ func (h *StreamHub) removeClient(ch chan []byte) {
 	h.mu.Unlock()
 }
 
-// ServeHTTP handles SSE connections
+// ServeHTTP handles SSE connections. With ?history=<duration> the initial
+// state is followed by the incidents and actions of that long ago onwards,
+// replayed from JetStream in "history" pages, before live messages.
 func (h *StreamHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "SSE not supported", http.StatusInternalServerError)
 		return
 	}
+	history, err := h.parseHistory(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
@@ -144,20 +252,23 @@ func (h *StreamHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ch := make(chan []byte, 100)
 	h.addClient(ch)
 	defer h.removeClient(ch)
+	h.announceActivity(r.Context())
 
 	h.log.Debug("SSE client connected")
 
 	// Send initial state
 	h.mu.RLock()
-	if h.latestSnapshot != nil {
-		data, _ := json.Marshal(map[string]any{
-			"type":    "metrics",
-			"payload": h.latestSnapshot,
-		})
+	initial := h.initialState()
+	h.mu.RUnlock()
+	for _, data := range initial {
 		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+	if len(initial) > 0 {
 		flusher.Flush()
 	}
-	h.mu.RUnlock()
+	if history > 0 {
+		h.writeHistory(r.Context(), w, flusher, history)
+	}
 
 	// Keep-alive ticker
 	ticker := time.NewTicker(15 * time.Second)
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/microcloud/bus"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+)
+
+// ActivityInterval is how often the hub announces connected stream clients
+// on sim.activity. It must stay well below the sim-engine's idle pause.
+const ActivityInterval = 30 * time.Second
+
+// WithClientActivity announces stream clients on sim.activity through
+// publisher, on connect, on every poll and every ActivityInterval while SSE
+// clients stay connected, so an idle-paused sim-engine resumes
+func WithClientActivity(publisher *bus.Publisher) StreamOption {
+	return func(h *StreamHub) {
+		h.activity = publisher
+	}
+}
+
+// announceActivity publishes a client activity notice, if enabled
+func (h *StreamHub) announceActivity(ctx context.Context) {
+	if h.activity == nil {
+		return
+	}
+	err := h.activity.PublishClientActivity(ctx, &simv1.ClientActivity{
+		Source:        "orchestrator",
+		StreamClients: int32(h.ClientCount()),
+	})
+	if err != nil {
+		h.log.Warn("failed to announce client activity", "error", err)
+	}
+}
+
+// RunActivity announces connected SSE clients every ActivityInterval until
+// ctx is done
+func (h *StreamHub) RunActivity(ctx context.Context) error {
+	ticker := time.NewTicker(ActivityInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		if h.ClientCount() > 0 {
+			h.announceActivity(ctx)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"connectrpc.com/connect"
+
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+	"github.com/microcloud/gen/go/ops/v1/opsv1connect"
+)
+
+// StreamPath is the live SSE endpoint
+const StreamPath = "/api/stream"
+
+// Stream token transport and lifetime
+const (
+	// StreamTokenParam is the query parameter carrying a stream token
+	StreamTokenParam = "token"
+
+	// StreamTokenCookie is the cookie CreateStreamToken sets, sent by
+	// EventSource when opened with credentials
+	StreamTokenCookie = "parallax_stream_token"
+
+	// DefaultStreamTokenTTL is how long a minted token can open a stream
+	DefaultStreamTokenTTL = time.Minute
+)
+
+// StreamTokens mints and checks short-lived tokens for the SSE endpoints.
+// A token is its expiry and the minting API key's name signed with a shared
+// secret, so any orchestrator replica with the same secret accepts it,
+// usage is charged to the right key, and nothing is stored.
+type StreamTokens struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewStreamTokens returns a token issuer for the given secret and lifetime
+func NewStreamTokens(secret []byte, ttl time.Duration) *StreamTokens {
+	return &StreamTokens{secret: secret, ttl: ttl}
+}
+
+// Mint returns a new token for the named API key and its expiry
+func (t *StreamTokens) Mint(key string) (string, time.Time) {
+	expires := time.Now().Add(t.ttl).Truncate(time.Second)
+	payload := strconv.FormatInt(expires.Unix(), 10) + "." + base64.RawURLEncoding.EncodeToString([]byte(key))
+	return payload + "." + t.sign(payload), expires
+}
+
+// Parse returns the API key name a token was minted for, if it was minted
+// with this secret and has not expired
+func (t *StreamTokens) Parse(token string) (string, bool) {
+	payload, sig, ok := cutLast(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(t.sign(payload))) {
+		return "", false
+	}
+	exp, encoded, ok := strings.Cut(payload, ".")
+	if !ok {
+		return "", false
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() >= unix {
+		return "", false
+	}
+	key, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+	return string(key), true
+}
+
+// Authorized returns the API key name of a valid token on a request to a
+// stream endpoint, in the query string or the cookie
+func (t *StreamTokens) Authorized(r *http.Request) (string, bool) {
+	if token := r.URL.Query().Get(StreamTokenParam); token != "" {
+		return t.Parse(token)
+	}
+	c, err := r.Cookie(StreamTokenCookie)
+	if err != nil {
+		return "", false
+	}
+	return t.Parse(c.Value)
+}
+
+func (t *StreamTokens) sign(payload string) string {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte("stream:" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// IsStreamPath reports whether path, with or without a version prefix, is
+// one of the GET-only streaming endpoints that accept stream tokens: the
+// SSE endpoints and their long-poll fallback
+func IsStreamPath(path string) bool {
+	_, path = splitVersionPrefix(path)
+	return path == StreamPath || path == StreamReplayPath || path == PollPath
+}
+
+// AuthServer implements the AuthService
+type AuthServer struct {
+	tokens *StreamTokens
+}
+
+var _ opsv1connect.AuthServiceHandler = (*AuthServer)(nil)
+
+// NewAuthServer creates a new auth server
+func NewAuthServer(tokens *StreamTokens) *AuthServer {
+	return &AuthServer{tokens: tokens}
+}
+
+// CreateStreamToken mints a stream token for the caller's API key, also
+// setting it as a cookie. The cookie goes with every request but only
+// authorizes the stream endpoints.
+func (s *AuthServer) CreateStreamToken(ctx context.Context, req *connect.Request[opsv1.CreateStreamTokenRequest]) (*connect.Response[opsv1.CreateStreamTokenResponse], error) {
+	token, expires := s.tokens.Mint(KeyName(ctx))
+
+	resp := connect.NewResponse(&opsv1.CreateStreamTokenResponse{
+		Token:           token,
+		ExpiresAtUnixMs: expires.UnixMilli(),
+	})
+
+	// Cross-site cookies must be Secure with SameSite=None. TLS ends at the
+	// proxy; over plain HTTP the cookie only works same-site, which covers a
+	// local UI on another port.
+	secure := req.Header().Get("X-Forwarded-Proto") == "https"
+	cookie := &http.Cookie{
+		Name:     StreamTokenCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if secure {
+		cookie.SameSite = http.SameSiteNoneMode
+	}
+	resp.Header().Add("Set-Cookie", cookie.String())
+	return resp, nil
+}
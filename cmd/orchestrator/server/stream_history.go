@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/microcloud/bus"
+)
+
+const (
+	// DefaultMaxStreamHistory bounds how far back an SSE client can ask
+	// for history with ?history=
+	DefaultMaxStreamHistory = time.Hour
+
+	// streamHistoryPageSize is how many incidents and actions go in one
+	// history message
+	streamHistoryPageSize = 100
+)
+
+// WithMaxHistory sets how far back SSE clients can ask for incidents and
+// actions to be replayed from JetStream. Longer requests are cut to it.
+// Zero disables history.
+func WithMaxHistory(d time.Duration) StreamOption {
+	return func(h *StreamHub) {
+		if d >= 0 {
+			h.maxHistory = d
+		}
+	}
+}
+
+// historyPage is the payload of a "history" message. Items are the
+// replayed incidents and actions, oldest first, typed as their live
+// messages are; the client is live once it sees last.
+type historyPage struct {
+	Page  int               `json:"page"`
+	Last  bool              `json:"last"`
+	Items []json.RawMessage `json:"items"`
+	Error string            `json:"error,omitempty"` // set on a last page cut short
+}
+
+// parseHistory reads ?history=, the duration of history a client wants
+// replayed, cut to the hub's limit
+func (h *StreamHub) parseHistory(r *http.Request) (time.Duration, error) {
+	v := r.URL.Query().Get("history")
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid history %q", v)
+	}
+	return min(d, h.maxHistory), nil
+}
+
+// writeHistory replays the incidents and actions of the last d from
+// JetStream to an SSE client, one page per message. Live messages wait in
+// the client's channel meanwhile, so a few may repeat the last page.
+func (h *StreamHub) writeHistory(ctx context.Context, w http.ResponseWriter, flusher http.Flusher, d time.Duration) {
+	var sent int
+	err := h.subscriber.ReplayHistory(ctx, time.Now().Add(-d), streamHistoryPageSize, func(ctx context.Context, page bus.HistoryPage) error {
+		msg := historyPage{Page: page.Number, Last: page.Last, Items: make([]json.RawMessage, 0, len(page.Items))}
+		for _, item := range page.Items {
+			var data []byte
+			if item.Incident != nil {
+				data, _ = json.Marshal(map[string]any{"type": "incident", "payload": item.Incident})
+			} else {
+				data, _ = json.Marshal(map[string]any{"type": "action", "payload": item.Action})
+			}
+			msg.Items = append(msg.Items, data)
+		}
+		sent = page.Number
+		return writeHistoryPage(w, flusher, msg)
+	})
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+
+	// Tell the client history ended early, so it does not wait for a
+	// last page before treating itself as live
+	h.log.Warn("stream history replay failed", "history", d, "error", err)
+	writeHistoryPage(w, flusher, historyPage{Page: sent + 1, Last: true, Items: []json.RawMessage{}, Error: "history unavailable"})
+}
+
+func writeHistoryPage(w http.ResponseWriter, flusher http.Flusher, page historyPage) error {
+	data, err := json.Marshal(map[string]any{
+		"type":    "history",
+		"payload": page,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	flusher.Flush()
+	return nil
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// PollPath is the long-poll fallback for clients whose network breaks
+	// SSE (?cursor=&timeout=)
+	PollPath = "/api/poll"
+
+	// DefaultPollTimeout is how long a poll waits for events when the
+	// request omits timeout
+	DefaultPollTimeout = 25 * time.Second
+
+	// MaxPollTimeout stays under the idle timeouts of common proxies
+	MaxPollTimeout = 55 * time.Second
+
+	// DefaultPollHistory is how many events are kept for polling clients
+	DefaultPollHistory = 500
+)
+
+// WithPollHistory sets how many broadcast events are kept for polling
+// clients. Clients that fall further behind start over from initial state.
+func WithPollHistory(n int) StreamOption {
+	return func(h *StreamHub) {
+		if n > 0 {
+			h.pollHistory = n
+		}
+	}
+}
+
+// pollResponse is the body of a poll. Reset means the cursor was unknown
+// or too old and Events start with the initial state, as on an SSE connect.
+type pollResponse struct {
+	Cursor string            `json:"cursor"`
+	Reset  bool              `json:"reset,omitempty"`
+	Events []json.RawMessage `json:"events"`
+}
+
+type pollEvent struct {
+	seq  uint64
+	data []byte
+}
+
+// pollLog keeps the most recent broadcast events for polling clients.
+// Snapshots replace each other rather than filling the ring, since a
+// client only needs the latest one.
+type pollLog struct {
+	epoch string // changes on restart, so old cursors reset
+
+	mu      sync.Mutex
+	ring    []pollEvent
+	start   int    // index of the oldest event in ring
+	floor   uint64 // lowest seq still complete; older cursors missed events
+	next    uint64
+	metrics pollEvent
+	wake    chan struct{} // closed and replaced on every event
+}
+
+func newPollLog(size int) *pollLog {
+	return &pollLog{
+		epoch: strconv.FormatInt(time.Now().UnixNano(), 36),
+		ring:  make([]pollEvent, 0, size),
+		wake:  make(chan struct{}),
+	}
+}
+
+// add appends an event, evicting the oldest once the ring is full
+func (p *pollLog) add(data []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e := pollEvent{seq: p.next, data: data}
+	if len(p.ring) < cap(p.ring) {
+		p.ring = append(p.ring, e)
+	} else {
+		p.floor = p.ring[p.start].seq + 1
+		p.ring[p.start] = e
+		p.start = (p.start + 1) % len(p.ring)
+	}
+	p.advance()
+}
+
+// setMetrics replaces the latest snapshot
+func (p *pollLog) setMetrics(data []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.metrics = pollEvent{seq: p.next, data: data}
+	p.advance()
+}
+
+// advance moves to the next seq and wakes waiting polls. Caller must hold
+// mu.
+func (p *pollLog) advance() {
+	p.next++
+	close(p.wake)
+	p.wake = make(chan struct{})
+}
+
+// since returns the events from seq on, oldest first, with the snapshot
+// in its place, and the cursor to poll next. ok is false if seq is ahead
+// of the log or older than the events kept. wake is closed on the next
+// event.
+func (p *pollLog) since(seq uint64) (events [][]byte, next uint64, ok bool, wake <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if seq > p.next || seq < p.floor {
+		return nil, p.next, false, p.wake
+	}
+	metricsPending := p.metrics.data != nil && p.metrics.seq >= seq
+	for i := range p.ring {
+		e := p.ring[(p.start+i)%len(p.ring)]
+		if e.seq < seq {
+			continue
+		}
+		if metricsPending && p.metrics.seq < e.seq {
+			events = append(events, p.metrics.data)
+			metricsPending = false
+		}
+		events = append(events, e.data)
+	}
+	if metricsPending {
+		events = append(events, p.metrics.data)
+	}
+	return events, p.next, true, p.wake
+}
+
+// cursor formats seq as a cursor of this log
+func (p *pollLog) cursor(seq uint64) string {
+	return p.epoch + "-" + strconv.FormatUint(seq, 10)
+}
+
+// parseCursor returns the seq of a cursor of this log
+func (p *pollLog) parseCursor(cursor string) (uint64, bool) {
+	epoch, seq, ok := strings.Cut(cursor, "-")
+	if !ok || epoch != p.epoch {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	return n, err == nil
+}
+
+// ServePoll handles GET ?cursor=&timeout=, the long-poll fallback to the
+// SSE stream. It returns the events since cursor, waiting up to timeout for
+// the first. Without a usable cursor it returns the initial state. The
+// ETag is the next cursor, and If-None-Match stands in for a missing
+// cursor, so a poll that times out with nothing new is 304 Not Modified.
+func (h *StreamHub) ServePoll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	timeout := DefaultPollTimeout
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed < 0 {
+			http.Error(w, "invalid timeout", http.StatusBadRequest)
+			return
+		}
+		timeout = min(parsed, MaxPollTimeout)
+	}
+
+	h.announceActivity(r.Context())
+
+	ifNoneMatch := r.Header.Get(HeaderIfNoneMatch)
+	cursor := r.URL.Query().Get("cursor")
+	if cursor == "" {
+		cursor = strings.Trim(ifNoneMatch, `"`)
+	}
+	seq, ok := h.polls.parseCursor(cursor)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		var events [][]byte
+		var next uint64
+		var wake <-chan struct{}
+		if ok {
+			events, next, ok, wake = h.polls.since(seq)
+		}
+		if !ok {
+			h.writePollReset(w)
+			return
+		}
+		if len(events) > 0 {
+			writePoll(w, pollResponse{Cursor: h.polls.cursor(next)}, events)
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-wake:
+			continue
+		case <-timer.C:
+		}
+
+		etag := `"` + h.polls.cursor(next) + `"`
+		if ifNoneMatch == etag {
+			w.Header().Set(HeaderETag, etag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writePoll(w, pollResponse{Cursor: h.polls.cursor(next)}, nil)
+		return
+	}
+}
+
+// writePollReset answers a poll without a usable cursor with the initial
+// state and the current cursor
+func (h *StreamHub) writePollReset(w http.ResponseWriter) {
+	// The cursor is taken first, so events broadcast while the state is
+	// read are delivered again rather than lost
+	_, next, _, _ := h.polls.since(0)
+	h.mu.RLock()
+	initial := h.initialState()
+	h.mu.RUnlock()
+	writePoll(w, pollResponse{Cursor: h.polls.cursor(next), Reset: true}, initial)
+}
+
+func writePoll(w http.ResponseWriter, resp pollResponse, events [][]byte) {
+	resp.Events = make([]json.RawMessage, len(events))
+	for i, e := range events {
+		resp.Events[i] = e
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set(HeaderETag, `"`+resp.Cursor+`"`)
+	json.NewEncoder(w).Encode(resp)
+}
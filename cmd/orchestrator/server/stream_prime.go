@@ -0,0 +1,259 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+	"github.com/microcloud/storage"
+)
+
+const (
+	// primeWindow is how far back Prime looks for entity metrics. Entities
+	// silent for longer are assumed gone.
+	primeWindow = 5 * time.Minute
+
+	// initialStateLimit caps the open incidents and pending actions sent to
+	// newly connected clients
+	initialStateLimit = 50
+)
+
+// Prime fills the hub's initial state from the database so clients that
+// connect before the first live messages still get a snapshot, the open
+// incidents and the pending actions
+func (h *StreamHub) Prime(ctx context.Context, metrics *storage.MetricsRepository, incidents *storage.IncidentsRepository, actions *storage.ActionsRepository) error {
+	metricRows, err := metrics.LatestPerEntity(ctx, time.Now().Add(-primeWindow))
+	if err != nil {
+		return fmt.Errorf("load latest metrics: %w", err)
+	}
+	incidentRows, err := incidents.ListUnresolved(ctx, initialStateLimit)
+	if err != nil {
+		return fmt.Errorf("load open incidents: %w", err)
+	}
+	actionRows, err := actions.ListPending(ctx, initialStateLimit)
+	if err != nil {
+		return fmt.Errorf("load pending actions: %w", err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Live messages that arrived while loading are newer than the database
+	if h.latestSnapshot == nil && len(metricRows) > 0 {
+		h.latestSnapshot = snapshotFromMetrics(metricRows)
+	}
+	// Anything tracked already came in live and is newer than the database,
+	// so primed incidents go after it and primed actions before it
+	for _, row := range incidentRows {
+		if row.MergedInto == nil && !h.hasIncident(row.ID) && len(h.openIncidents) < initialStateLimit {
+			h.openIncidents = append(h.openIncidents, rowToIncident(row))
+		}
+	}
+	var primed []*opsv1.Action
+	for _, row := range actionRows {
+		if !h.hasAction(row.ID) {
+			primed = append(primed, rowToAction(row))
+		}
+	}
+	h.pendingActions = append(primed, h.pendingActions...)
+	if n := len(h.pendingActions); n > initialStateLimit {
+		h.pendingActions = h.pendingActions[n-initialStateLimit:]
+	}
+
+	h.log.Info("stream hub primed",
+		"entities", len(metricRows),
+		"open_incidents", len(h.openIncidents),
+		"pending_actions", len(h.pendingActions),
+	)
+	return nil
+}
+
+// snapshotFromMetrics rebuilds a keyframe snapshot from the latest stored
+// metric of each entity. Only the metrics signal-service stores are set.
+func snapshotFromMetrics(rows []storage.MetricRow) *simv1.MetricSnapshot {
+	snapshot := &simv1.MetricSnapshot{Timestamp: &commonv1.SimulationTimestamp{}}
+	nodes := make(map[string]*simv1.Node)
+	services := make(map[string]*simv1.Service)
+
+	for _, m := range rows {
+		if ms := m.Time.UnixMilli(); ms > snapshot.Timestamp.WallTimeUnixMs {
+			snapshot.Timestamp.WallTimeUnixMs = ms
+			snapshot.Timestamp.TickId = m.TickID
+		}
+
+		switch {
+		case m.NodeID != nil:
+			node, ok := nodes[*m.NodeID]
+			if !ok {
+				node = &simv1.Node{Id: &commonv1.UUID{Value: *m.NodeID}}
+				nodes[*m.NodeID] = node
+				snapshot.Nodes = append(snapshot.Nodes, node)
+			}
+			switch m.MetricName {
+			case "cpu_usage_percent":
+				node.CpuUsagePercent = m.MetricValue
+			case "memory_usage_percent":
+				node.MemoryUsagePercent = m.MetricValue
+			case "disk_usage_percent":
+				node.DiskUsagePercent = m.MetricValue
+			}
+
+		case m.ServiceID != nil:
+			svc, ok := services[*m.ServiceID]
+			if !ok {
+				svc = &simv1.Service{Id: &commonv1.UUID{Value: *m.ServiceID}}
+				services[*m.ServiceID] = svc
+				snapshot.Services = append(snapshot.Services, svc)
+			}
+			switch m.MetricName {
+			case "requests_per_second":
+				svc.RequestsPerSecond = m.MetricValue
+			case "error_rate_percent":
+				svc.ErrorRatePercent = m.MetricValue
+			case "latency_p50_ms":
+				svc.LatencyP50Ms = m.MetricValue
+			case "latency_p99_ms":
+				svc.LatencyP99Ms = m.MetricValue
+			case "memory_usage_percent":
+				svc.MemoryUsagePercent = m.MetricValue
+			case "queue_depth":
+				svc.QueueDepth = m.MetricValue
+			case "consumer_lag_ms":
+				svc.ConsumerLagMs = m.MetricValue
+			default:
+				// Scenario-registered custom metric
+				if svc.CustomMetrics == nil {
+					svc.CustomMetrics = make(map[string]float64)
+				}
+				svc.CustomMetrics[m.MetricName] = m.MetricValue
+			}
+		}
+	}
+	return snapshot
+}
+
+// TrackIncidents updates the open incident list with incidents the
+// orchestrator changed itself, such as merges and splits, which are not
+// republished on ops.incidents, and broadcasts them to clients
+func (h *StreamHub) TrackIncidents(incidents ...*opsv1.Incident) {
+	h.mu.Lock()
+	for _, incident := range incidents {
+		h.trackIncident(incident)
+	}
+	h.mu.Unlock()
+
+	for _, incident := range incidents {
+		data, _ := json.Marshal(map[string]any{
+			"type":    "incident",
+			"payload": incident,
+		})
+		h.broadcast(data)
+	}
+}
+
+// trackIncident keeps the open incident list current, newest first. Caller
+// must hold mu.
+func (h *StreamHub) trackIncident(incident *opsv1.Incident) {
+	h.untrackIncident(incident.Id.GetValue())
+	if incident.Resolved || incident.MergedIntoId != nil {
+		return
+	}
+	open := append([]*opsv1.Incident{incident}, h.openIncidents...)
+	if len(open) > initialStateLimit {
+		open = open[:initialStateLimit]
+	}
+	h.openIncidents = open
+}
+
+// untrackIncident drops an incident from the open incident list. Caller
+// must hold mu.
+func (h *StreamHub) untrackIncident(id string) {
+	open := h.openIncidents[:0]
+	for _, cur := range h.openIncidents {
+		if cur.Id.GetValue() != id {
+			open = append(open, cur)
+		}
+	}
+	h.openIncidents = open
+}
+
+// trackAction keeps the pending action list current, oldest first. Caller
+// must hold mu.
+func (h *StreamHub) trackAction(action *opsv1.Action) {
+	h.untrackAction(action.Id.GetValue())
+	if action.Status != commonv1.ActionStatus_ACTION_STATUS_PENDING {
+		return
+	}
+	pending := append(h.pendingActions, action)
+	if len(pending) > initialStateLimit {
+		pending = pending[len(pending)-initialStateLimit:]
+	}
+	h.pendingActions = pending
+}
+
+// untrackAction drops an action from the pending action list, once it is
+// decided or has a result. Caller must hold mu.
+func (h *StreamHub) untrackAction(id string) {
+	pending := h.pendingActions[:0]
+	for _, cur := range h.pendingActions {
+		if cur.Id.GetValue() != id {
+			pending = append(pending, cur)
+		}
+	}
+	h.pendingActions = pending
+}
+
+// hasIncident reports whether an incident is already tracked. Caller must
+// hold mu.
+func (h *StreamHub) hasIncident(id string) bool {
+	for _, cur := range h.openIncidents {
+		if cur.Id.GetValue() == id {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAction reports whether an action is already tracked. Caller must hold
+// mu.
+func (h *StreamHub) hasAction(id string) bool {
+	for _, cur := range h.pendingActions {
+		if cur.Id.GetValue() == id {
+			return true
+		}
+	}
+	return false
+}
+
+// initialState returns the messages sent to a newly connected client: the
+// latest snapshot, then open incidents and pending actions. Caller must
+// hold mu.
+func (h *StreamHub) initialState() [][]byte {
+	var msgs [][]byte
+	if h.latestSnapshot != nil {
+		data, _ := json.Marshal(map[string]any{
+			"type":    "metrics",
+			"payload": h.latestSnapshot,
+		})
+		msgs = append(msgs, data)
+	}
+	for _, incident := range h.openIncidents {
+		data, _ := json.Marshal(map[string]any{
+			"type":    "incident",
+			"payload": incident,
+		})
+		msgs = append(msgs, data)
+	}
+	for _, action := range h.pendingActions {
+		data, _ := json.Marshal(map[string]any{
+			"type":    "action",
+			"payload": action,
+		})
+		msgs = append(msgs, data)
+	}
+	return msgs
+}
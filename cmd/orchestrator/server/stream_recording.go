@@ -0,0 +1,319 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stream recording endpoints
+const (
+	// StreamRecordingsPath lists recordings (GET), starts one (POST with
+	// ?name=&duration=) or stops the active one (DELETE)
+	StreamRecordingsPath = "/api/stream/recordings"
+
+	// StreamReplayPath replays a recording as SSE (?name=&speed=)
+	StreamReplayPath = "/api/stream/replay"
+
+	// DefaultRecordingDuration applies when POST omits duration
+	DefaultRecordingDuration = 5 * time.Minute
+
+	// MaxRecordingDuration bounds a single recording
+	MaxRecordingDuration = time.Hour
+
+	recordingExt = ".jsonl"
+
+	// maxFrameBytes bounds a single recorded line when replaying
+	maxFrameBytes = 16 << 20
+)
+
+// streamFrame is one recorded SSE data payload, stored as a JSON line
+type streamFrame struct {
+	OffsetMs int64           `json:"offset_ms"` // since the recording started
+	Data     json.RawMessage `json:"data"`
+}
+
+// streamRecording is an in-progress recording
+type streamRecording struct {
+	name    string
+	file    *os.File
+	w       *bufio.Writer
+	enc     *json.Encoder
+	started time.Time
+	timer   *time.Timer
+	frames  int
+}
+
+// RecordingInfo describes a stored recording
+type RecordingInfo struct {
+	Name      string    `json:"name"`
+	SizeBytes int64     `json:"size_bytes"`
+	Modified  time.Time `json:"modified"`
+	Active    bool      `json:"active"`
+}
+
+// StartRecording records every broadcast payload to name in the recording
+// directory until duration elapses or StopRecording is called. An empty name
+// is replaced by a timestamped one, which is returned.
+func (h *StreamHub) StartRecording(name string, duration time.Duration) (string, error) {
+	if h.recordingDir == "" {
+		return "", errors.New("stream recording is not enabled")
+	}
+	if name == "" {
+		name = "session-" + time.Now().UTC().Format("20060102-150405")
+	}
+	if !validRecordingName(name) {
+		return "", fmt.Errorf("invalid recording name: %q", name)
+	}
+	if duration <= 0 || duration > MaxRecordingDuration {
+		return "", fmt.Errorf("duration must be between 0 and %s", MaxRecordingDuration)
+	}
+
+	h.recMu.Lock()
+	defer h.recMu.Unlock()
+	if h.recording != nil {
+		return "", fmt.Errorf("recording %s already in progress", h.recording.name)
+	}
+
+	f, err := os.OpenFile(h.recordingPath(name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return "", fmt.Errorf("create recording: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	rec := &streamRecording{
+		name:    name,
+		file:    f,
+		w:       w,
+		enc:     json.NewEncoder(w),
+		started: time.Now(),
+	}
+	rec.timer = time.AfterFunc(duration, func() { h.StopRecording() })
+	h.recording = rec
+
+	h.log.Info("stream recording started", "name", name, "duration", duration)
+	return name, nil
+}
+
+// StopRecording ends the active recording, if any, and returns its name
+func (h *StreamHub) StopRecording() (string, bool) {
+	h.recMu.Lock()
+	defer h.recMu.Unlock()
+
+	rec := h.recording
+	if rec == nil {
+		return "", false
+	}
+	h.recording = nil
+	rec.timer.Stop()
+
+	if err := rec.w.Flush(); err != nil {
+		h.log.Error("failed to flush recording", "name", rec.name, "error", err)
+	}
+	if err := rec.file.Close(); err != nil {
+		h.log.Error("failed to close recording", "name", rec.name, "error", err)
+	}
+
+	h.log.Info("stream recording stopped", "name", rec.name, "frames", rec.frames)
+	return rec.name, true
+}
+
+// record appends a broadcast payload to the active recording
+func (h *StreamHub) record(data []byte) {
+	h.recMu.Lock()
+	defer h.recMu.Unlock()
+
+	rec := h.recording
+	if rec == nil {
+		return
+	}
+	frame := streamFrame{
+		OffsetMs: time.Since(rec.started).Milliseconds(),
+		Data:     data,
+	}
+	if err := rec.enc.Encode(frame); err != nil {
+		h.log.Error("failed to record stream frame", "name", rec.name, "error", err)
+		return
+	}
+	rec.frames++
+}
+
+// Recordings lists stored recordings, newest first
+func (h *StreamHub) Recordings() ([]RecordingInfo, error) {
+	entries, err := os.ReadDir(h.recordingDir)
+	if err != nil {
+		return nil, fmt.Errorf("list recordings: %w", err)
+	}
+
+	h.recMu.Lock()
+	active := ""
+	if h.recording != nil {
+		active = h.recording.name
+	}
+	h.recMu.Unlock()
+
+	var out []RecordingInfo
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), recordingExt) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), recordingExt)
+		out = append(out, RecordingInfo{
+			Name:      name,
+			SizeBytes: info.Size(),
+			Modified:  info.ModTime(),
+			Active:    name == active,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Modified.After(out[j].Modified) })
+	return out, nil
+}
+
+// ServeRecordings handles listing, starting and stopping recordings
+func (h *StreamHub) ServeRecordings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		recordings, err := h.Recordings()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"recordings": recordings})
+
+	case http.MethodPost:
+		duration := DefaultRecordingDuration
+		if v := r.URL.Query().Get("duration"); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil {
+				http.Error(w, "invalid duration", http.StatusBadRequest)
+				return
+			}
+			duration = parsed
+		}
+		name, err := h.StartRecording(r.URL.Query().Get("name"), duration)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]any{"name": name, "duration": duration.String()})
+
+	case http.MethodDelete:
+		name, ok := h.StopRecording()
+		if !ok {
+			http.Error(w, "no recording in progress", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"name": name})
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ServeReplay streams a recording as SSE, spacing frames by their original
+// offsets divided by speed
+func (h *StreamHub) ServeReplay(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "SSE not supported", http.StatusInternalServerError)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if !validRecordingName(name) {
+		http.Error(w, "invalid recording name", http.StatusBadRequest)
+		return
+	}
+	speed := 1.0
+	if v := r.URL.Query().Get("speed"); v != "" {
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "invalid speed", http.StatusBadRequest)
+			return
+		}
+		speed = parsed
+	}
+
+	f, err := os.Open(h.recordingPath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "recording not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	h.log.Debug("SSE replay started", "name", name, "speed", speed)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxFrameBytes)
+	var prevOffset int64
+	for scanner.Scan() {
+		var frame streamFrame
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			h.log.Warn("skipping corrupt recording frame", "name", name, "error", err)
+			continue
+		}
+
+		if gap := frame.OffsetMs - prevOffset; gap > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(time.Duration(float64(time.Duration(gap)*time.Millisecond) / speed)):
+			}
+		}
+		prevOffset = frame.OffsetMs
+
+		fmt.Fprintf(w, "data: %s\n\n", frame.Data)
+		flusher.Flush()
+	}
+	if err := scanner.Err(); err != nil {
+		h.log.Error("failed to read recording", "name", name, "error", err)
+	}
+
+	fmt.Fprintf(w, "event: end\ndata: {}\n\n")
+	flusher.Flush()
+}
+
+func (h *StreamHub) recordingPath(name string) string {
+	return filepath.Join(h.recordingDir, name+recordingExt)
+}
+
+// validRecordingName rejects names that could escape the recording directory
+func validRecordingName(name string) bool {
+	if name == "" || len(name) > 128 {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
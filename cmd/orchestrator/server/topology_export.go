@@ -0,0 +1,250 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+
+	"connectrpc.com/connect"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+	"github.com/microcloud/gen/go/sim/v1/simv1connect"
+)
+
+// TopologyExportPath renders the live topology (?format=dot|mermaid)
+const TopologyExportPath = "/api/topology/export"
+
+// Export formats
+const (
+	FormatDOT     = "dot"
+	FormatMermaid = "mermaid"
+)
+
+// Fill colors by health, shared by both formats
+const (
+	colorHealthy  = "#c8e6c9"
+	colorDegraded = "#fff3b0"
+	colorCritical = "#ffcdd2"
+	colorOffline  = "#e0e0e0"
+	colorUnknown  = "#ffffff"
+)
+
+// TopologyExporter renders the sim-engine topology as diagram text
+type TopologyExporter struct {
+	client simv1connect.SimulationControlClient
+	log    *slog.Logger
+}
+
+// NewTopologyExporter creates a new topology exporter
+func NewTopologyExporter(client simv1connect.SimulationControlClient, log *slog.Logger) *TopologyExporter {
+	return &TopologyExporter{
+		client: client,
+		log:    log,
+	}
+}
+
+// ServeHTTP fetches the current topology and writes it in the requested format
+func (e *TopologyExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = FormatDOT
+	}
+	if format != FormatDOT && format != FormatMermaid {
+		http.Error(w, fmt.Sprintf("unknown format %q (want %s or %s)", format, FormatDOT, FormatMermaid), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := downstreamContext(r.Context())
+	defer cancel()
+
+	resp, err := e.client.GetTopology(ctx, connect.NewRequest(&simv1.GetTopologyRequest{}))
+	if err != nil {
+		e.log.Error("failed to fetch topology", "error", err)
+		http.Error(w, "failed to fetch topology", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	switch format {
+	case FormatMermaid:
+		fmt.Fprint(w, RenderMermaid(resp.Msg))
+	default:
+		fmt.Fprint(w, RenderDOT(resp.Msg))
+	}
+}
+
+// RenderDOT renders the topology as a Graphviz digraph. Services are grouped
+// into one cluster per node and connected by dependency edges.
+func RenderDOT(topo *simv1.GetTopologyResponse) string {
+	g := newTopologyGraph(topo)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "// tick %d\n", topo.TickId)
+	b.WriteString("digraph topology {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=\"rounded,filled\", fontname=\"Helvetica\"];\n")
+
+	for i, node := range g.nodes {
+		fmt.Fprintf(&b, "  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(&b, "    label=%q;\n", fmt.Sprintf("%s (%s)", node.Name, node.AvailabilityZone))
+		fmt.Fprintf(&b, "    style=filled;\n    fillcolor=%q;\n", nodeColor(node.Status))
+		for _, svc := range g.servicesOn[node.Id.GetValue()] {
+			fmt.Fprintf(&b, "    %q [label=%q, fillcolor=%q];\n", svc.Id.GetValue(), svc.Name, serviceColor(svc.Health))
+		}
+		b.WriteString("  }\n")
+	}
+	for _, svc := range g.unplaced {
+		fmt.Fprintf(&b, "  %q [label=%q, fillcolor=%q];\n", svc.Id.GetValue(), svc.Name, serviceColor(svc.Health))
+	}
+
+	for _, edge := range g.deps {
+		fmt.Fprintf(&b, "  %q -> %q;\n", edge.FromId, edge.ToId)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// RenderMermaid renders the topology as a Mermaid flowchart with the same
+// grouping as RenderDOT
+func RenderMermaid(topo *simv1.GetTopologyResponse) string {
+	g := newTopologyGraph(topo)
+
+	// Mermaid IDs must be plain identifiers, so UUIDs are mapped to s0, s1, ...
+	ids := make(map[string]string, len(topo.Services))
+	mermaidID := func(id string) string {
+		if v, ok := ids[id]; ok {
+			return v
+		}
+		v := fmt.Sprintf("s%d", len(ids))
+		ids[id] = v
+		return v
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%%%% tick %d\n", topo.TickId)
+	b.WriteString("flowchart LR\n")
+	fmt.Fprintf(&b, "  classDef healthy fill:%s\n", colorHealthy)
+	fmt.Fprintf(&b, "  classDef degraded fill:%s\n", colorDegraded)
+	fmt.Fprintf(&b, "  classDef critical fill:%s\n", colorCritical)
+	fmt.Fprintf(&b, "  classDef offline fill:%s\n", colorOffline)
+	fmt.Fprintf(&b, "  classDef unknown fill:%s\n", colorUnknown)
+
+	writeService := func(indent string, svc *simv1.Service) {
+		fmt.Fprintf(&b, "%s%s[\"%s\"]:::%s\n", indent, mermaidID(svc.Id.GetValue()), mermaidLabel(svc.Name), serviceClass(svc.Health))
+	}
+
+	for i, node := range g.nodes {
+		fmt.Fprintf(&b, "  subgraph n%d[\"%s (%s)\"]\n", i, mermaidLabel(node.Name), mermaidLabel(node.AvailabilityZone))
+		for _, svc := range g.servicesOn[node.Id.GetValue()] {
+			writeService("    ", svc)
+		}
+		b.WriteString("  end\n")
+		fmt.Fprintf(&b, "  style n%d fill:%s\n", i, nodeColor(node.Status))
+	}
+	for _, svc := range g.unplaced {
+		writeService("  ", svc)
+	}
+
+	for _, edge := range g.deps {
+		fmt.Fprintf(&b, "  %s --> %s\n", mermaidID(edge.FromId), mermaidID(edge.ToId))
+	}
+	return b.String()
+}
+
+// topologyGraph is the topology sorted for stable output
+type topologyGraph struct {
+	nodes      []*simv1.Node
+	servicesOn map[string][]*simv1.Service // node ID -> services
+	unplaced   []*simv1.Service            // services whose node is unknown
+	deps       []*simv1.TopologyEdge
+}
+
+func newTopologyGraph(topo *simv1.GetTopologyResponse) topologyGraph {
+	g := topologyGraph{
+		nodes:      append([]*simv1.Node(nil), topo.Nodes...),
+		servicesOn: make(map[string][]*simv1.Service),
+	}
+	sort.Slice(g.nodes, func(i, j int) bool { return g.nodes[i].Name < g.nodes[j].Name })
+
+	known := make(map[string]bool, len(topo.Nodes))
+	for _, node := range topo.Nodes {
+		known[node.Id.GetValue()] = true
+	}
+
+	services := append([]*simv1.Service(nil), topo.Services...)
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Name != services[j].Name {
+			return services[i].Name < services[j].Name
+		}
+		return services[i].Id.GetValue() < services[j].Id.GetValue()
+	})
+	for _, svc := range services {
+		nodeID := svc.NodeId.GetValue()
+		if known[nodeID] {
+			g.servicesOn[nodeID] = append(g.servicesOn[nodeID], svc)
+		} else {
+			g.unplaced = append(g.unplaced, svc)
+		}
+	}
+
+	for _, edge := range topo.Edges {
+		if edge.Kind == "dependency" {
+			g.deps = append(g.deps, edge)
+		}
+	}
+	sort.Slice(g.deps, func(i, j int) bool {
+		if g.deps[i].FromId != g.deps[j].FromId {
+			return g.deps[i].FromId < g.deps[j].FromId
+		}
+		return g.deps[i].ToId < g.deps[j].ToId
+	})
+	return g
+}
+
+func serviceClass(health commonv1.ServiceHealth) string {
+	switch health {
+	case commonv1.ServiceHealth_SERVICE_HEALTH_HEALTHY:
+		return "healthy"
+	case commonv1.ServiceHealth_SERVICE_HEALTH_DEGRADED:
+		return "degraded"
+	case commonv1.ServiceHealth_SERVICE_HEALTH_CRITICAL:
+		return "critical"
+	default:
+		return "unknown"
+	}
+}
+
+func serviceColor(health commonv1.ServiceHealth) string {
+	switch serviceClass(health) {
+	case "healthy":
+		return colorHealthy
+	case "degraded":
+		return colorDegraded
+	case "critical":
+		return colorCritical
+	default:
+		return colorUnknown
+	}
+}
+
+func nodeColor(status commonv1.NodeStatus) string {
+	switch status {
+	case commonv1.NodeStatus_NODE_STATUS_HEALTHY:
+		return colorHealthy
+	case commonv1.NodeStatus_NODE_STATUS_DEGRADED:
+		return colorDegraded
+	case commonv1.NodeStatus_NODE_STATUS_OFFLINE:
+		return colorOffline
+	default:
+		return colorUnknown
+	}
+}
+
+// mermaidLabel strips characters that would end a quoted Mermaid label
+func mermaidLabel(s string) string {
+	return strings.NewReplacer(`"`, "'", "\n", " ").Replace(s)
+}
@@ -0,0 +1,347 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+)
+
+const (
+	// AnonymousKey is the key name usage is charged to when authentication
+	// is disabled
+	AnonymousKey = "anonymous"
+
+	// DefaultUsageWindow is the period usage is counted and quotas apply over
+	DefaultUsageWindow = 24 * time.Hour
+
+	// streamMeterInterval is how often open streams are charged, and cut off
+	// once over quota
+	streamMeterInterval = 10 * time.Second
+)
+
+// Usage metrics, as named in quota errors and USAGE_QUOTAS
+const (
+	UsageRequests      = "requests"
+	UsageStreamMinutes = "stream_minutes"
+	UsageExportBytes   = "export_bytes"
+)
+
+type keyNameCtxKey struct{}
+
+// WithKeyName returns ctx carrying the name of the caller's API key
+func WithKeyName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, keyNameCtxKey{}, name)
+}
+
+// KeyName returns the name of the caller's API key, or AnonymousKey
+func KeyName(ctx context.Context) string {
+	if name, ok := ctx.Value(keyNameCtxKey{}).(string); ok && name != "" {
+		return name
+	}
+	return AnonymousKey
+}
+
+// Quota limits a key's usage per window. Zero fields are unlimited.
+type Quota struct {
+	Requests      int64
+	StreamMinutes float64
+	ExportBytes   int64
+}
+
+// KeyUsage is a key's usage in the current window
+type KeyUsage struct {
+	Key           string
+	Requests      int64
+	StreamMinutes float64
+	ExportBytes   int64
+	Quota         Quota
+}
+
+// QuotaError reports a key over one of its quotas
+type QuotaError struct {
+	Key    string
+	Metric string
+	Used   float64
+	Limit  float64
+	Reset  time.Time
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("quota exceeded for API key %q: %s %s of %s used, resets at %s",
+		e.Key, e.Metric, formatUsage(e.Used), formatUsage(e.Limit), e.Reset.UTC().Format(time.RFC3339))
+}
+
+func formatUsage(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// UsageTracker counts requests, stream minutes and export bytes per API key
+// over a fixed window and enforces quotas on them. Counts live in memory on
+// each orchestrator replica and reset when the window rolls over, so behind
+// a load balancer a key's effective quota is the per-replica quota times the
+// replicas it reaches.
+type UsageTracker struct {
+	window       time.Duration
+	quotas       map[string]Quota // by key name
+	defaultQuota Quota            // keys without their own
+
+	mu    sync.Mutex
+	start time.Time
+	usage map[string]*KeyUsage
+}
+
+// NewUsageTracker creates a tracker with the given window and quotas by key
+// name. The quota named "*" applies to keys without their own.
+func NewUsageTracker(window time.Duration, quotas map[string]Quota) *UsageTracker {
+	t := &UsageTracker{
+		window: window,
+		quotas: make(map[string]Quota, len(quotas)),
+		start:  time.Now().Truncate(window),
+		usage:  make(map[string]*KeyUsage),
+	}
+	for name, q := range quotas {
+		if name == "*" {
+			t.defaultQuota = q
+			continue
+		}
+		t.quotas[name] = q
+	}
+	return t
+}
+
+// roll starts a new window if the current one has ended. Caller must hold
+// mu.
+func (t *UsageTracker) roll() {
+	if now := time.Now(); !now.Before(t.start.Add(t.window)) {
+		t.start = now.Truncate(t.window)
+		t.usage = make(map[string]*KeyUsage)
+	}
+}
+
+// entry returns a key's usage in the current window. Caller must hold mu.
+func (t *UsageTracker) entry(key string) *KeyUsage {
+	t.roll()
+	u, ok := t.usage[key]
+	if !ok {
+		q, ok := t.quotas[key]
+		if !ok {
+			q = t.defaultQuota
+		}
+		u = &KeyUsage{Key: key, Quota: q}
+		t.usage[key] = u
+	}
+	return u
+}
+
+// exceeded returns the first quota a key has used up, if any. Caller must
+// hold mu.
+func (t *UsageTracker) exceeded(u *KeyUsage, metric string) error {
+	var used, limit float64
+	switch metric {
+	case UsageRequests:
+		used, limit = float64(u.Requests), float64(u.Quota.Requests)
+	case UsageStreamMinutes:
+		used, limit = u.StreamMinutes, u.Quota.StreamMinutes
+	case UsageExportBytes:
+		used, limit = float64(u.ExportBytes), float64(u.Quota.ExportBytes)
+	}
+	if limit == 0 || used < limit {
+		return nil
+	}
+	return &QuotaError{Key: u.Key, Metric: metric, Used: used, Limit: limit, Reset: t.start.Add(t.window)}
+}
+
+// Request charges a request to key, failing once the request quota is used
+// up. Rejected requests are not charged.
+func (t *UsageTracker) Request(key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u := t.entry(key)
+	if err := t.exceeded(u, UsageRequests); err != nil {
+		return err
+	}
+	u.Requests++
+	return nil
+}
+
+// Check fails if key has used up its quota for metric
+func (t *UsageTracker) Check(key, metric string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.exceeded(t.entry(key), metric)
+}
+
+// AddStream charges d of open stream to key, returning a QuotaError once
+// the stream minutes quota is used up
+func (t *UsageTracker) AddStream(key string, d time.Duration) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u := t.entry(key)
+	u.StreamMinutes += d.Minutes()
+	return t.exceeded(u, UsageStreamMinutes)
+}
+
+// AddExport charges n exported bytes to key
+func (t *UsageTracker) AddExport(key string, n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entry(key).ExportBytes += n
+}
+
+// Usage returns key's usage in the current window
+func (t *UsageTracker) Usage(key string) KeyUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return *t.entry(key)
+}
+
+// All returns the usage of every key seen in the current window, by name
+func (t *UsageTracker) All() []KeyUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.roll()
+
+	out := make([]KeyUsage, 0, len(t.usage))
+	for _, u := range t.usage {
+		out = append(out, *u)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// Window returns the start and end of the current window
+func (t *UsageTracker) Window() (time.Time, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.roll()
+	return t.start, t.start.Add(t.window)
+}
+
+// Middleware charges every request but health checks to the caller's key,
+// rejecting it once the request quota is used up. Connect procedures get a
+// resource_exhausted error, other endpoints a plain 429.
+func (t *UsageTracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := t.Request(KeyName(r.Context())); err != nil {
+			writeQuotaError(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// MeterStream charges the time an SSE stream stays open to the caller's
+// key. Streams are refused once the stream minutes quota is used up, and
+// open ones are closed when it runs out; the client's reconnect then gets
+// the 429.
+func (t *UsageTracker) MeterStream(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := KeyName(r.Context())
+		if err := t.Check(key, UsageStreamMinutes); err != nil {
+			writeQuotaError(w, r, err)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		done := make(chan struct{})
+		metered := make(chan struct{})
+		go func() {
+			defer close(metered)
+			ticker := time.NewTicker(streamMeterInterval)
+			defer ticker.Stop()
+			last := time.Now()
+			for {
+				select {
+				case <-done:
+					t.AddStream(key, time.Since(last))
+					return
+				case now := <-ticker.C:
+					err := t.AddStream(key, now.Sub(last))
+					last = now
+					if err != nil {
+						cancel()
+					}
+				}
+			}
+		}()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+		close(done)
+		<-metered
+	})
+}
+
+// MeterExport charges the bytes an export endpoint writes to the caller's
+// key, refusing exports once the export quota is used up. The export in
+// flight completes even if it crosses the quota.
+func (t *UsageTracker) MeterExport(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := KeyName(r.Context())
+		if err := t.Check(key, UsageExportBytes); err != nil {
+			writeQuotaError(w, r, err)
+			return
+		}
+		cw := &countingWriter{ResponseWriter: w}
+		next.ServeHTTP(cw, r)
+		t.AddExport(key, cw.n)
+	})
+}
+
+// countingWriter counts the body bytes written through it
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// writeQuotaError writes a 429 with Retry-After set to the window reset,
+// as a Connect error body for procedures and plain text otherwise
+func writeQuotaError(w http.ResponseWriter, r *http.Request, err error) {
+	if qe, ok := err.(*QuotaError); ok {
+		retry := max(int(time.Until(qe.Reset).Seconds()), 1)
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+	}
+	if _, path := splitVersionPrefix(r.URL.Path); procedureVersion(path) == "" {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	body, _ := json.Marshal(map[string]string{
+		"code":    connect.CodeResourceExhausted.String(),
+		"message": err.Error(),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write(body)
+}
+
+// keyUsageProto converts a key's usage to its proto form
+func keyUsageProto(u KeyUsage) *opsv1.KeyUsage {
+	return &opsv1.KeyUsage{
+		KeyName:            u.Key,
+		Requests:           u.Requests,
+		StreamMinutes:      u.StreamMinutes,
+		ExportBytes:        u.ExportBytes,
+		RequestQuota:       u.Quota.Requests,
+		StreamMinutesQuota: u.Quota.StreamMinutes,
+		ExportBytesQuota:   u.Quota.ExportBytes,
+	}
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+)
+
+// Headers used for API version negotiation and deprecation notices
+const (
+	HeaderAPIVersion        = "Api-Version"
+	HeaderSupportedVersions = "Api-Supported-Versions"
+	HeaderDeprecation       = "Deprecation"
+	HeaderSunset            = "Sunset"
+	HeaderLink              = "Link"
+)
+
+// VersionedMux routes requests to one mux per API version. Connect RPCs
+// carry their version in the proto package (/ops.v2.ActionService/...) and
+// are routed on it. Plain HTTP endpoints are reached under a version prefix
+// (/v1/api/stream) or, unprefixed, at the version requested in the
+// Api-Version header, defaulting to the latest. Every response names the
+// version that served it.
+type VersionedMux struct {
+	versions []string
+	muxes    map[string]*http.ServeMux
+}
+
+// NewVersionedMux returns a mux serving the given versions, oldest first.
+// The last one is the default for requests that ask for none.
+func NewVersionedMux(versions ...string) *VersionedMux {
+	m := &VersionedMux{
+		versions: versions,
+		muxes:    make(map[string]*http.ServeMux, len(versions)),
+	}
+	for _, v := range versions {
+		m.muxes[v] = http.NewServeMux()
+	}
+	return m
+}
+
+// Version returns the mux handlers of version v are registered on. It
+// panics for versions not passed to NewVersionedMux.
+func (m *VersionedMux) Version(v string) *http.ServeMux {
+	mux, ok := m.muxes[v]
+	if !ok {
+		panic(fmt.Sprintf("server: unknown API version %q", v))
+	}
+	return mux
+}
+
+// Latest returns the default API version
+func (m *VersionedMux) Latest() string {
+	return m.versions[len(m.versions)-1]
+}
+
+func (m *VersionedMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	version, path := splitVersionPrefix(r.URL.Path)
+	switch {
+	case version != "":
+		r = r.Clone(r.Context())
+		r.URL.Path = path
+		r.URL.RawPath = ""
+	case procedureVersion(r.URL.Path) != "":
+		version = procedureVersion(r.URL.Path)
+	case r.Header.Get(HeaderAPIVersion) != "":
+		version = r.Header.Get(HeaderAPIVersion)
+	default:
+		version = m.Latest()
+	}
+
+	mux, ok := m.muxes[version]
+	if !ok {
+		w.Header().Set(HeaderSupportedVersions, strings.Join(m.versions, ", "))
+		http.Error(w, fmt.Sprintf("unsupported API version %q", version), http.StatusNotFound)
+		return
+	}
+	w.Header().Set(HeaderAPIVersion, version)
+	mux.ServeHTTP(w, r)
+}
+
+// splitVersionPrefix splits /v1/api/stream into v1 and /api/stream. It
+// returns an empty version for paths without a version prefix.
+func splitVersionPrefix(path string) (version, rest string) {
+	first, rest, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !isVersion(first) {
+		return "", path
+	}
+	return first, "/" + rest
+}
+
+// procedureVersion returns the version in a Connect procedure path such as
+// /ops.v1.ActionService/ListPendingActions, or "" for other paths
+func procedureVersion(path string) string {
+	service, _, ok := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !ok {
+		return ""
+	}
+	parts := strings.Split(service, ".")
+	for i := len(parts) - 2; i >= 0; i-- {
+		if isVersion(parts[i]) {
+			return parts[i]
+		}
+	}
+	return ""
+}
+
+// isVersion reports whether s looks like v1, v2, ...
+func isVersion(s string) bool {
+	if len(s) < 2 || s[0] != 'v' {
+		return false
+	}
+	n, err := strconv.Atoi(s[1:])
+	return err == nil && n > 0
+}
+
+// Deprecation schedules the removal of a procedure, service or package
+type Deprecation struct {
+	Since     time.Time // when it was deprecated; zero if unspecified
+	Sunset    time.Time // when it stops being served; zero if not yet scheduled
+	Successor string    // URI or procedure replacing it, optional
+}
+
+// DeprecationInterceptor sets Deprecation (RFC 9745), Sunset (RFC 8594) and
+// successor Link headers on responses and errors of deprecated procedures,
+// so clients see the sunset date before it arrives. deprecations is keyed
+// by procedure (/ops.v1.ActionService/ApproveAction), service
+// (/ops.v1.ActionService/) or package (/ops.v1.) prefix; the longest
+// matching key wins. The first call of each deprecated procedure is logged.
+func DeprecationInterceptor(deprecations map[string]Deprecation, log *slog.Logger) connect.UnaryInterceptorFunc {
+	var logged sync.Map
+
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			procedure := req.Spec().Procedure
+			dep, ok := matchDeprecation(deprecations, procedure)
+			if !ok {
+				return next(ctx, req)
+			}
+			if _, seen := logged.LoadOrStore(procedure, true); !seen {
+				log.Warn("deprecated procedure called", "procedure", procedure, "sunset", dep.Sunset, "successor", dep.Successor)
+			}
+
+			resp, err := next(ctx, req)
+			if err != nil {
+				if cerr, ok := err.(*connect.Error); ok {
+					dep.setHeaders(cerr.Meta())
+				}
+				return resp, err
+			}
+			dep.setHeaders(resp.Header())
+			return resp, nil
+		}
+	}
+}
+
+// matchDeprecation returns the deprecation with the longest key prefixing
+// procedure
+func matchDeprecation(deprecations map[string]Deprecation, procedure string) (Deprecation, bool) {
+	var best Deprecation
+	bestLen := -1
+	for prefix, dep := range deprecations {
+		if strings.HasPrefix(procedure, prefix) && len(prefix) > bestLen {
+			best, bestLen = dep, len(prefix)
+		}
+	}
+	return best, bestLen >= 0
+}
+
+func (d Deprecation) setHeaders(h http.Header) {
+	if d.Since.IsZero() {
+		h.Set(HeaderDeprecation, "true")
+	} else {
+		h.Set(HeaderDeprecation, "@"+strconv.FormatInt(d.Since.Unix(), 10))
+	}
+	if !d.Sunset.IsZero() {
+		h.Set(HeaderSunset, d.Sunset.UTC().Format(http.TimeFormat))
+	}
+	if d.Successor != "" {
+		h.Add(HeaderLink, fmt.Sprintf("<%s>; rel=\"successor-version\"", d.Successor))
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/microcloud/bus"
+	"github.com/microcloud/storage"
+)
+
+func runRestore(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	dir := fs.String("dir", "", "backup directory written by parallaxctl backup")
+	yes := fs.Bool("yes", false, "confirm that the current database and stream contents will be replaced")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return errors.New("-dir is required")
+	}
+
+	m, err := readManifest(filepath.Join(*dir, manifestFile))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("backup from %s: last tick %d, stream %s seq %d-%d (%d messages)\n",
+		m.CreatedAt.Format("2006-01-02 15:04:05 MST"), m.LastTickID,
+		m.Stream.Stream, m.Stream.FirstSeq, m.Stream.LastSeq, m.Stream.Messages)
+	if !*yes {
+		return errors.New("restore replaces all current data, including every environment's database; stop the services and rerun with -yes")
+	}
+
+	dbCfg := storage.ConfigFromEnv()
+	db, err := storage.New(ctx, dbCfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	eventBus, err := bus.New(ctx, bus.ConfigFromEnv())
+	if err != nil {
+		return err
+	}
+	defer eventBus.Close()
+	if eventBus.StreamName() != m.Stream.Stream {
+		return fmt.Errorf("backup is of stream %s, but this environment uses %s", m.Stream.Stream, eventBus.StreamName())
+	}
+
+	if err := db.Restore(ctx, dbCfg, filepath.Join(*dir, m.DatabaseFile)); err != nil {
+		return err
+	}
+	lastTick, err := db.LastTickID(ctx)
+	if err != nil {
+		return err
+	}
+	if lastTick < m.LastTickID {
+		return fmt.Errorf("restored database ends at tick %d, before the backup marker %d", lastTick, m.LastTickID)
+	}
+	fmt.Printf("restored database %s (last tick %d)\n", dbCfg.Database, lastTick)
+
+	f, err := os.Open(filepath.Join(*dir, m.StreamFile))
+	if err != nil {
+		return fmt.Errorf("open stream export: %w", err)
+	}
+	defer f.Close()
+	imported, err := eventBus.ImportStream(ctx, f)
+	if err != nil {
+		return fmt.Errorf("import stream after %d messages: %w", imported, err)
+	}
+	if imported != m.Stream.Messages {
+		return fmt.Errorf("imported %d stream messages, backup recorded %d", imported, m.Stream.Messages)
+	}
+	fmt.Printf("restored %d messages to stream %s\n", imported, m.Stream.Stream)
+	return nil
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	"github.com/microcloud/id"
+	"github.com/microcloud/messages"
+	"github.com/microcloud/storage"
+)
+
+// config sizes the generated history
+type config struct {
+	Days            int
+	End             time.Time
+	Interval        time.Duration
+	Nodes           int
+	Services        int
+	IncidentsPerDay float64
+	ActionRate      float64
+	Seed            int64
+	Batch           int
+}
+
+func defaultConfig() config {
+	return config{
+		Days:            30,
+		Interval:        time.Minute,
+		Nodes:           20,
+		Services:        60,
+		IncidentsPerDay: 12,
+		ActionRate:      0.8,
+		Batch:           50000,
+	}
+}
+
+func (c config) validate() error {
+	switch {
+	case c.Days <= 0:
+		return errors.New("-days must be positive")
+	case c.Interval < time.Second:
+		return errors.New("-interval must be at least 1s")
+	case c.Nodes <= 0:
+		return errors.New("-nodes must be positive")
+	case c.Services < 0:
+		return errors.New("-services must not be negative")
+	case c.IncidentsPerDay < 0:
+		return errors.New("-incidents-per-day must not be negative")
+	case c.ActionRate < 0 || c.ActionRate > 1:
+		return errors.New("-action-rate must be between 0 and 1")
+	case c.Batch <= 0:
+		return errors.New("-batch must be positive")
+	}
+	return nil
+}
+
+// regions the fleet is spread over, with the UTC hour their load peaks at
+var regions = []struct {
+	name     string
+	peakHour float64
+}{
+	{"us-east-1", 18},
+	{"us-west-2", 21},
+	{"eu-west-1", 13},
+}
+
+// metricShape is how one metric moves over a day: base at the daily trough,
+// base+swing at the peak, plus noise, within [0, max]
+type metricShape struct {
+	name  string
+	base  float64
+	swing float64
+	noise float64
+	max   float64 // 0 for unbounded
+}
+
+var nodeMetrics = []metricShape{
+	{name: "cpu_usage_percent", base: 20, swing: 40, noise: 4, max: 100},
+	{name: "memory_usage_percent", base: 45, swing: 15, noise: 2, max: 100},
+	{name: "disk_usage_percent", base: 40, swing: 2, noise: 0.5, max: 100},
+}
+
+var serviceMetrics = []metricShape{
+	{name: "requests_per_second", base: 150, swing: 600, noise: 30},
+	{name: "error_rate_percent", base: 0.2, swing: 0.5, noise: 0.15, max: 100},
+	{name: "latency_p50_ms", base: 18, swing: 14, noise: 2},
+	{name: "latency_p99_ms", base: 80, swing: 90, noise: 12},
+}
+
+// incidentKind is a detection rule the history has incidents for, with the
+// action taken on them. Rules match the signal-service defaults.
+type incidentKind struct {
+	rule      string
+	kind      string // "node" or "service"
+	metric    string
+	threshold float64
+	peak      float64 // value the metric reaches during the incident
+	severity  commonv1.IncidentSeverity
+	action    commonv1.ActionType
+	reason    string // message key for the action's reason
+	reasonArg string // its argument carrying the metric value
+	weight    int    // relative frequency
+}
+
+var incidentKinds = []incidentKind{
+	{"high_error_rate", "service", "error_rate_percent", 5, 8,
+		commonv1.IncidentSeverity_INCIDENT_SEVERITY_WARNING, commonv1.ActionType_ACTION_TYPE_RESTART_SERVICE, messages.ActionRestartErrors, "error_rate", 6},
+	{"critical_error_rate", "service", "error_rate_percent", 10, 18,
+		commonv1.IncidentSeverity_INCIDENT_SEVERITY_CRITICAL, commonv1.ActionType_ACTION_TYPE_RESTART_SERVICE, messages.ActionRestartErrors, "error_rate", 2},
+	{"high_latency", "service", "latency_p99_ms", 500, 820,
+		commonv1.IncidentSeverity_INCIDENT_SEVERITY_WARNING, commonv1.ActionType_ACTION_TYPE_SCALE_UP, messages.ActionScaleUpLatency, "latency", 5},
+	{"high_cpu_usage", "node", "cpu_usage_percent", 85, 91,
+		commonv1.IncidentSeverity_INCIDENT_SEVERITY_WARNING, commonv1.ActionType_ACTION_TYPE_REBALANCE_TRAFFIC, messages.ActionRebalanceCPU, "cpu", 5},
+	{"critical_cpu_usage", "node", "cpu_usage_percent", 95, 98,
+		commonv1.IncidentSeverity_INCIDENT_SEVERITY_CRITICAL, commonv1.ActionType_ACTION_TYPE_SCALE_UP, messages.ActionScaleUpCPU, "cpu", 2},
+	{"high_memory_usage", "node", "memory_usage_percent", 90, 95,
+		commonv1.IncidentSeverity_INCIDENT_SEVERITY_WARNING, commonv1.ActionType_ACTION_TYPE_REBOOT_NODE, messages.ActionRebootMemory, "memory", 3},
+	{"high_disk_usage", "node", "disk_usage_percent", 90, 94,
+		commonv1.IncidentSeverity_INCIDENT_SEVERITY_WARNING, commonv1.ActionType_ACTION_TYPE_CORDON_NODE, messages.ActionCordonDisk, "disk", 1},
+}
+
+// entity is a node or service the history is generated for
+type entity struct {
+	id       string
+	kind     string
+	region   string
+	peakHour float64
+	load     float64 // scales the daily swing
+}
+
+// excursion is a stretch where an incident holds a metric at its peak
+type excursion struct {
+	start, end time.Time
+	peak       float64
+}
+
+type generator struct {
+	cfg      config
+	rng      *rand.Rand
+	start    time.Time
+	nodes    []*entity
+	services []*entity
+
+	excursions map[string][]excursion // by entity ID and metric
+}
+
+func newGenerator(cfg config) *generator {
+	g := &generator{
+		cfg:        cfg,
+		rng:        rand.New(rand.NewSource(cfg.Seed)),
+		start:      cfg.End.Add(-time.Duration(cfg.Days) * 24 * time.Hour).Truncate(cfg.Interval),
+		excursions: make(map[string][]excursion),
+	}
+	for i := range cfg.Nodes {
+		g.nodes = append(g.nodes, g.newEntity("node", i))
+	}
+	for i := range cfg.Services {
+		g.services = append(g.services, g.newEntity("service", i))
+	}
+	return g
+}
+
+func (g *generator) newEntity(kind string, i int) *entity {
+	var b [16]byte
+	g.rng.Read(b[:])
+	r := regions[i%len(regions)]
+	return &entity{
+		id:       id.FromBytes(b),
+		kind:     kind,
+		region:   r.name,
+		peakHour: r.peakHour + g.rng.Float64()*3 - 1.5,
+		load:     0.6 + g.rng.Float64()*0.6,
+	}
+}
+
+// tick returns the tick a seeded time falls in, counting from 1 at the start
+func (g *generator) tick(t time.Time) int64 {
+	return int64(t.Sub(g.start)/g.cfg.Interval) + 1
+}
+
+// planIncidents draws the incidents and actions of the history and records
+// the metric excursions they cause. Incidents still open at the end are left
+// unresolved.
+func (g *generator) planIncidents() ([]storage.IncidentRow, []storage.ActionRow) {
+	totalWeight := 0
+	for _, k := range incidentKinds {
+		totalWeight += k.weight
+	}
+
+	var incidents []storage.IncidentRow
+	var actions []storage.ActionRow
+	for day := range g.cfg.Days {
+		dayStart := g.start.Add(time.Duration(day) * 24 * time.Hour)
+		for range g.poisson(g.cfg.IncidentsPerDay) {
+			k := pickKind(g.rng.Intn(totalWeight))
+			entities := g.nodes
+			if k.kind == "service" {
+				entities = g.services
+			}
+			if len(entities) == 0 {
+				continue
+			}
+			e := entities[g.rng.Intn(len(entities))]
+
+			detected := dayStart.Add(time.Duration(g.rng.Int63n(int64(24 * time.Hour))))
+			if detected.After(g.cfg.End) {
+				continue
+			}
+			duration := 5*time.Minute + time.Duration(g.rng.Int63n(int64(55*time.Minute)))
+			resolved := detected.Add(duration)
+			key := e.id + "/" + k.metric
+			g.excursions[key] = append(g.excursions[key], excursion{start: detected, end: resolved, peak: k.peak})
+
+			incident := g.incident(k, e, detected, resolved)
+			incidents = append(incidents, incident)
+			if g.rng.Float64() < g.cfg.ActionRate {
+				actions = append(actions, g.action(k, e, incident.ID, detected))
+			}
+		}
+	}
+
+	sort.Slice(incidents, func(i, j int) bool { return incidents[i].DetectedAt.Before(incidents[j].DetectedAt) })
+	for _, list := range g.excursions {
+		sort.Slice(list, func(i, j int) bool { return list[i].start.Before(list[j].start) })
+	}
+	return incidents, actions
+}
+
+func (g *generator) incident(k incidentKind, e *entity, detected, resolved time.Time) storage.IncidentRow {
+	value := fmt.Sprintf("%.2f", k.peak)
+	title := messages.New(messages.IncidentThresholdTitle,
+		"rule", k.rule,
+		"metric", k.metric,
+		"entity_type", e.kind,
+		"entity", e.id[:8],
+	)
+	description := messages.New(messages.IncidentThresholdDescription,
+		"metric", k.metric,
+		"threshold", fmt.Sprintf("%.2f", k.threshold),
+		"value", value,
+		"window", "60",
+		"region", e.region,
+	)
+	row := storage.IncidentRow{
+		ID:              id.NewV7At(detected),
+		DetectedAt:      detected,
+		TickID:          g.tick(detected),
+		Severity:        int(k.severity),
+		Title:           title.String(),
+		Description:     description.String(),
+		SourceService:   "signal-service",
+		AffectedIDs:     []string{e.id},
+		RuleName:        k.rule,
+		Metrics:         map[string]float64{k.metric: k.peak},
+		TitleKey:        title.Key,
+		TitleArgs:       title.Args,
+		DescriptionKey:  description.Key,
+		DescriptionArgs: description.Args,
+		Fingerprint:     k.rule + "/" + e.id + "/" + k.metric,
+		Occurrences:     1,
+	}
+	if !resolved.After(g.cfg.End) {
+		row.Resolved = true
+		row.ResolvedAt = &resolved
+	}
+	return row
+}
+
+// action is the decider's response to an incident: mostly completed, some
+// failed or rejected by an operator
+func (g *generator) action(k incidentKind, e *entity, incidentID string, detected time.Time) storage.ActionRow {
+	created := detected.Add(time.Duration(30+g.rng.Intn(150)) * time.Second)
+	reason := messages.New(k.reason,
+		"rule", k.rule,
+		k.reasonArg, fmt.Sprintf("%.1f", k.peak),
+	)
+	row := storage.ActionRow{
+		ID:             id.NewV7At(created),
+		IncidentID:     incidentID,
+		ProposedAtTick: g.tick(created),
+		ActionType:     int(k.action),
+		TargetID:       e.id,
+		Reason:         reason.String(),
+		Parameters:     map[string]string{},
+		CreatedAt:      created,
+		ReasonKey:      reason.Key,
+		ReasonArgs:     reason.Args,
+	}
+
+	switch p := g.rng.Float64(); {
+	case p < 0.05:
+		row.Status = int(commonv1.ActionStatus_ACTION_STATUS_REJECTED)
+		row.ResultMessage = "rejected by operator"
+		return row
+	case p < 0.15:
+		row.Status = int(commonv1.ActionStatus_ACTION_STATUS_FAILED)
+		row.ResultMessage = "target did not recover"
+	default:
+		row.Status = int(commonv1.ActionStatus_ACTION_STATUS_COMPLETED)
+		row.ResultMessage = "completed"
+	}
+	executed := created.Add(time.Duration(5+g.rng.Intn(55)) * time.Second)
+	row.ExecutedAt = &executed
+	return row
+}
+
+// metrics generates every metric sample of the history in time order,
+// handing them to flush in batches of cfg.Batch rows
+func (g *generator) metrics(flush func([]storage.MetricRow) error) error {
+	labels := map[string]string{"source": "seed"}
+	rows := make([]storage.MetricRow, 0, g.cfg.Batch)
+	for t := g.start; !t.After(g.cfg.End); t = t.Add(g.cfg.Interval) {
+		tick := g.tick(t)
+		for _, e := range g.nodes {
+			for _, shape := range nodeMetrics {
+				rows = append(rows, storage.MetricRow{
+					Time: t, TickID: tick, NodeID: &e.id,
+					MetricName: shape.name, MetricValue: g.value(e, shape, t), Labels: labels,
+				})
+			}
+		}
+		for _, e := range g.services {
+			for _, shape := range serviceMetrics {
+				rows = append(rows, storage.MetricRow{
+					Time: t, TickID: tick, ServiceID: &e.id,
+					MetricName: shape.name, MetricValue: g.value(e, shape, t), Labels: labels,
+				})
+			}
+		}
+		if len(rows) >= g.cfg.Batch {
+			if err := flush(rows); err != nil {
+				return err
+			}
+			rows = rows[:0]
+		}
+	}
+	if len(rows) > 0 {
+		return flush(rows)
+	}
+	return nil
+}
+
+// value is an entity's metric at t: its daily cycle, quieter at weekends,
+// with noise, or the incident's peak during an excursion
+func (g *generator) value(e *entity, shape metricShape, t time.Time) float64 {
+	for _, x := range g.excursions[e.id+"/"+shape.name] {
+		if x.start.After(t) {
+			break
+		}
+		if t.Before(x.end) {
+			return x.peak * (0.98 + g.rng.Float64()*0.04)
+		}
+	}
+
+	hour := float64(t.UTC().Hour()) + float64(t.UTC().Minute())/60
+	daily := 0.5 + 0.5*math.Cos(2*math.Pi*(hour-e.peakHour)/24)
+	if wd := t.UTC().Weekday(); wd == time.Saturday || wd == time.Sunday {
+		daily *= 0.7
+	}
+	v := shape.base + shape.swing*e.load*daily + g.rng.NormFloat64()*shape.noise
+	v = math.Max(v, 0)
+	if shape.max > 0 {
+		v = math.Min(v, shape.max)
+	}
+	return v
+}
+
+// poisson draws an incident count for a day averaging mean
+func (g *generator) poisson(mean float64) int {
+	if mean > 30 {
+		// Knuth's method underflows; the normal approximation is close
+		return max(int(math.Round(mean+math.Sqrt(mean)*g.rng.NormFloat64())), 0)
+	}
+	limit, n, p := math.Exp(-mean), 0, g.rng.Float64()
+	for p > limit {
+		n++
+		p *= g.rng.Float64()
+	}
+	return n
+}
+
+// pickKind returns the incident kind a weighted draw in [0, total weight)
+// lands on
+func pickKind(draw int) incidentKind {
+	for _, k := range incidentKinds {
+		if draw < k.weight {
+			return k
+		}
+		draw -= k.weight
+	}
+	return incidentKinds[len(incidentKinds)-1]
+}
@@ -0,0 +1,30 @@
+module github.com/microcloud/seed
+
+go 1.23
+
+require (
+	github.com/microcloud/gen/go v0.0.0
+	github.com/microcloud/id v0.0.0
+	github.com/microcloud/messages v0.0.0
+	github.com/microcloud/storage v0.0.0
+)
+
+require (
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
+	github.com/jackc/pgx/v5 v5.7.2 // indirect
+	github.com/jackc/puddle/v2 v2.2.2 // indirect
+	github.com/microcloud/environment v0.0.0 // indirect
+	golang.org/x/crypto v0.31.0 // indirect
+	golang.org/x/sync v0.10.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+	google.golang.org/protobuf v1.36.5 // indirect
+)
+
+replace (
+	github.com/microcloud/environment => ../../pkg/environment
+	github.com/microcloud/gen/go => ../../gen/go
+	github.com/microcloud/id => ../../pkg/id
+	github.com/microcloud/messages => ../../pkg/messages
+	github.com/microcloud/storage => ../../pkg/storage
+)
@@ -0,0 +1,208 @@
+package detector
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+	"github.com/microcloud/id"
+	"github.com/microcloud/messages"
+)
+
+// AnomalyRuleName is the rule name of incidents raised by anomaly detection
+const AnomalyRuleName = "metric_anomaly"
+
+// AnomalyConfig controls rolling z-score anomaly detection. Each entity
+// metric keeps the mean and standard deviation of its last Window values;
+// a value more than Sigma deviations from the mean raises an incident,
+// which resolves once values are back within Sigma/2.
+type AnomalyConfig struct {
+	Enabled    bool
+	Window     int     // values the rolling mean and deviation cover
+	MinSamples int     // values needed before a series is judged
+	Sigma      float64 // deviation, in standard deviations, that fires
+	MinStddev  float64 // floor on the deviation, so flat series do not fire on noise
+	Severity   commonv1.IncidentSeverity
+	Metrics    []string // metrics to watch; empty watches all
+}
+
+// DefaultAnomalyConfig returns anomaly detection disabled with the default
+// thresholds
+func DefaultAnomalyConfig() AnomalyConfig {
+	return AnomalyConfig{
+		Window:     120,
+		MinSamples: 30,
+		Sigma:      3,
+		MinStddev:  1,
+		Severity:   commonv1.IncidentSeverity_INCIDENT_SEVERITY_WARNING,
+	}
+}
+
+// WithAnomaly enables anomaly detection alongside the rules
+func WithAnomaly(cfg AnomalyConfig) Option {
+	return func(d *Detector) {
+		if !cfg.Enabled {
+			d.anomaly = nil
+			return
+		}
+		d.anomaly = newAnomalyTracker(cfg)
+	}
+}
+
+// rollingStats is a fixed-size ring of values with their running sum and
+// sum of squares
+type rollingStats struct {
+	values []float64
+	next   int
+	full   bool
+	sum    float64
+	sumSq  float64
+}
+
+func (s *rollingStats) count() int {
+	if s.full {
+		return len(s.values)
+	}
+	return s.next
+}
+
+// add pushes v, evicting the oldest value once the ring is full
+func (s *rollingStats) add(v float64) {
+	if s.full {
+		old := s.values[s.next]
+		s.sum -= old
+		s.sumSq -= old * old
+	}
+	s.values[s.next] = v
+	s.sum += v
+	s.sumSq += v * v
+	s.next++
+	if s.next == len(s.values) {
+		s.next, s.full = 0, true
+	}
+}
+
+// meanStddev returns the population mean and standard deviation
+func (s *rollingStats) meanStddev() (float64, float64) {
+	n := float64(s.count())
+	if n == 0 {
+		return 0, 0
+	}
+	mean := s.sum / n
+	// Rounding in the running sums can push the variance slightly negative
+	variance := math.Max(s.sumSq/n-mean*mean, 0)
+	return mean, math.Sqrt(variance)
+}
+
+// anomalyTracker holds the rolling stats and active anomalies per entity
+// metric. It is not safe for concurrent use; the detector guards it with mu.
+type anomalyTracker struct {
+	cfg     AnomalyConfig
+	metrics map[string]bool
+	series  map[string]*rollingStats
+	active  map[string]*opsv1.Incident
+}
+
+func newAnomalyTracker(cfg AnomalyConfig) *anomalyTracker {
+	t := &anomalyTracker{
+		cfg:    cfg,
+		series: make(map[string]*rollingStats),
+		active: make(map[string]*opsv1.Incident),
+	}
+	if len(cfg.Metrics) > 0 {
+		t.metrics = make(map[string]bool, len(cfg.Metrics))
+		for _, m := range cfg.Metrics {
+			t.metrics[m] = true
+		}
+	}
+	return t
+}
+
+// observe scores value against the series' rolling stats taken before it,
+// then adds it. It returns the z-score, the stats it was scored against and
+// whether the series just became anomalous or just returned to normal. The
+// caller records a fired anomaly's incident in active and removes a
+// cleared one.
+func (t *anomalyTracker) observe(key string, value float64) (z, mean, stddev float64, fired, cleared bool) {
+	s, ok := t.series[key]
+	if !ok {
+		s = &rollingStats{values: make([]float64, t.cfg.Window)}
+		t.series[key] = s
+	}
+	defer s.add(value)
+
+	if s.count() < t.cfg.MinSamples {
+		return 0, 0, 0, false, false
+	}
+	mean, stddev = s.meanStddev()
+	z = (value - mean) / math.Max(stddev, t.cfg.MinStddev)
+
+	switch {
+	case math.Abs(z) > t.cfg.Sigma && t.active[key] == nil:
+		return z, mean, stddev, true, false
+	case math.Abs(z) < t.cfg.Sigma/2 && t.active[key] != nil:
+		return z, mean, stddev, false, true
+	}
+	return z, mean, stddev, false, false
+}
+
+// checkAnomalies scores an entity's metrics and raises an incident for
+// each that just became anomalous. Caller must hold mu.
+func (d *Detector) checkAnomalies(ctx context.Context, entityType, entityID, region string, metrics map[string]float64, tickID int64) {
+	t := d.anomaly
+	now := time.Now()
+
+	for metric, value := range metrics {
+		if t.metrics != nil && !t.metrics[metric] {
+			continue
+		}
+		key := fmt.Sprintf("%s:%s:%s", entityType, entityID, metric)
+		z, mean, stddev, fired, cleared := t.observe(key, value)
+		if cleared {
+			d.resolveIncident(ctx, t.active[key], tickID, now)
+			delete(t.active, key)
+		}
+		if !fired || d.suppressed(AnomalyRuleName, entityID, now) {
+			continue
+		}
+
+		title := messages.New(messages.IncidentAnomalyTitle,
+			"metric", metric,
+			"entity_type", entityType,
+			"entity", shortID(entityID),
+		)
+		description := messages.New(messages.IncidentAnomalyDescription,
+			"metric", metric,
+			"value", fmt.Sprintf("%.2f", value),
+			"sigma", fmt.Sprintf("%.1f", z),
+			"mean", fmt.Sprintf("%.2f", mean),
+			"stddev", fmt.Sprintf("%.2f", stddev),
+			"region", region,
+		)
+		incident := &opsv1.Incident{
+			Id:                 &commonv1.UUID{Value: id.NewV7()},
+			DetectedAt:         d.stamp(tickID),
+			Severity:           t.cfg.Severity,
+			Title:              title.String(),
+			Description:        description.String(),
+			SourceService:      "signal-service",
+			AffectedIds:        []string{entityID},
+			RuleName:           AnomalyRuleName,
+			Metrics:            map[string]float64{metric: value, "z_score": z},
+			TitleMessage:       &commonv1.LocalizedMessage{Key: title.Key, Args: title.Args},
+			DescriptionMessage: &commonv1.LocalizedMessage{Key: description.Key, Args: description.Args},
+			Fingerprint:        fingerprint(AnomalyRuleName, entityID, metric),
+			Occurrences:        1,
+		}
+		t.active[key] = incident
+		if d.recordRepeat(ctx, incident) {
+			continue
+		}
+
+		d.countFired(AnomalyRuleName)
+		d.raise(ctx, incident, entityType, "anomaly detected", "metric", metric, "entity", shortID(entityID), "region", region, "z", fmt.Sprintf("%.1f", z))
+	}
+}
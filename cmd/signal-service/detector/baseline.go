@@ -0,0 +1,199 @@
+package detector
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// BaselineConfig controls the seasonal baseline of baseline rules. Each
+// node and service metric keeps a mean and variance per slot of the day,
+// learned from stored history and then followed as an EWMA, so a rule can
+// alert on how far a value is from what is normal at that time of day.
+type BaselineConfig struct {
+	Slot       time.Duration // length of the slots a day is split into
+	History    time.Duration // stored history the profiles are learned from
+	Alpha      float64       // weight of a new value in its slot's EWMA
+	MinSamples int64         // values a slot needs before it is scored
+	MinStddev  float64       // floor on the deviation, so flat slots do not fire on noise
+}
+
+// DefaultBaselineConfig returns the default baseline config
+func DefaultBaselineConfig() BaselineConfig {
+	return BaselineConfig{
+		Slot:       15 * time.Minute,
+		History:    7 * 24 * time.Hour,
+		Alpha:      0.05,
+		MinSamples: 10,
+		MinStddev:  1,
+	}
+}
+
+// WithBaseline overrides the seasonal baseline config
+func WithBaseline(cfg BaselineConfig) Option {
+	return func(d *Detector) {
+		d.baseline = newBaselineModel(cfg)
+	}
+}
+
+// slotStats is the running mean and population variance of one slot
+type slotStats struct {
+	mean     float64
+	variance float64
+	n        int64
+}
+
+// baselineModel holds a daily profile per entity metric. It is not safe
+// for concurrent use; the detector guards it with mu.
+type baselineModel struct {
+	cfg      BaselineConfig
+	slots    int
+	profiles map[string][]slotStats
+	learned  map[string]bool // metrics whose history was loaded
+}
+
+func newBaselineModel(cfg BaselineConfig) *baselineModel {
+	return &baselineModel{
+		cfg:      cfg,
+		slots:    int((24*time.Hour + cfg.Slot - 1) / cfg.Slot),
+		profiles: make(map[string][]slotStats),
+		learned:  make(map[string]bool),
+	}
+}
+
+// slotOf returns the slot of the UTC day t falls in
+func (m *baselineModel) slotOf(t time.Time) int {
+	t = t.UTC()
+	return int(t.Sub(t.Truncate(24*time.Hour)) / m.cfg.Slot)
+}
+
+func (m *baselineModel) profile(key string) []slotStats {
+	p, ok := m.profiles[key]
+	if !ok {
+		p = make([]slotStats, m.slots)
+		m.profiles[key] = p
+	}
+	return p
+}
+
+// observe scores value against its slot's baseline and then folds it in.
+// It returns the deviation in standard deviations and the expected value;
+// ok is false while the slot has too few values to judge.
+func (m *baselineModel) observe(key string, at time.Time, value float64) (deviation, expected float64, ok bool) {
+	st := &m.profile(key)[m.slotOf(at)]
+	if st.n >= m.cfg.MinSamples {
+		expected = st.mean
+		deviation = (value - st.mean) / math.Max(math.Sqrt(st.variance), m.cfg.MinStddev)
+		ok = true
+	}
+
+	st.n++
+	diff := value - st.mean
+	if st.n <= m.cfg.MinSamples {
+		// Plain running mean and variance until the EWMA has a footing
+		st.mean += diff / float64(st.n)
+		st.variance += (diff*(value-st.mean) - st.variance) / float64(st.n)
+	} else {
+		incr := m.cfg.Alpha * diff
+		st.mean += incr
+		st.variance = (1 - m.cfg.Alpha) * (st.variance + diff*incr)
+	}
+	return deviation, expected, ok
+}
+
+// baselineScore is a value's place relative to its baseline
+type baselineScore struct {
+	deviation float64
+	expected  float64
+}
+
+// scoreBaselines scores each metric that a baseline rule watches once, so
+// rules sharing a metric do not fold the same value in twice. Metrics whose
+// slot is still learning are left out. Caller must hold mu.
+func (d *Detector) scoreBaselines(rules []Rule, entityType, entityID string, metrics map[string]float64, at time.Time) map[string]baselineScore {
+	var scores map[string]baselineScore
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		if rule.Type != RuleTypeBaseline || seen[rule.MetricName] {
+			continue
+		}
+		seen[rule.MetricName] = true
+		value, ok := metrics[rule.MetricName]
+		if !ok {
+			continue
+		}
+		key := fmt.Sprintf("%s:%s:%s", entityType, entityID, rule.MetricName)
+		deviation, expected, ok := d.baseline.observe(key, at, value)
+		if !ok {
+			continue
+		}
+		if scores == nil {
+			scores = make(map[string]baselineScore)
+		}
+		scores[rule.MetricName] = baselineScore{deviation: deviation, expected: expected}
+	}
+	return scores
+}
+
+// LearnBaselines loads the daily profiles of metrics that baseline rules
+// watch from stored history, for metrics not loaded yet. It returns how
+// many metrics were loaded.
+func (d *Detector) LearnBaselines(ctx context.Context) (int, error) {
+	return d.learnBaselines(ctx, false)
+}
+
+// RelearnBaselines reloads the daily profiles of every metric that baseline
+// rules watch from stored history, replacing what was followed since
+func (d *Detector) RelearnBaselines(ctx context.Context) (int, error) {
+	return d.learnBaselines(ctx, true)
+}
+
+func (d *Detector) learnBaselines(ctx context.Context, all bool) (int, error) {
+	d.mu.Lock()
+	cfg := d.baseline.cfg
+	var metrics []string
+	for _, rule := range *d.rules.Load() {
+		if rule.Type == RuleTypeBaseline && (all || !d.baseline.learned[rule.MetricName]) {
+			metrics = append(metrics, rule.MetricName)
+			d.baseline.learned[rule.MetricName] = true
+		}
+	}
+	d.mu.Unlock()
+
+	since := time.Now().Add(-cfg.History)
+	learned := 0
+	for _, metric := range metrics {
+		buckets, err := d.metricsRepo.SeasonalProfile(ctx, metric, cfg.Slot, since)
+		if err != nil {
+			d.mu.Lock()
+			delete(d.baseline.learned, metric)
+			d.mu.Unlock()
+			return learned, fmt.Errorf("learn baseline of %s: %w", metric, err)
+		}
+
+		d.mu.Lock()
+		for _, b := range buckets {
+			var key string
+			switch {
+			case b.NodeID != nil:
+				key = fmt.Sprintf("node:%s:%s", *b.NodeID, metric)
+			case b.ServiceID != nil:
+				key = fmt.Sprintf("service:%s:%s", *b.ServiceID, metric)
+			default:
+				continue
+			}
+			if b.Slot < 0 || b.Slot >= d.baseline.slots {
+				continue
+			}
+			d.baseline.profile(key)[b.Slot] = slotStats{
+				mean:     b.Mean,
+				variance: b.Stddev * b.Stddev,
+				n:        b.SampleCount,
+			}
+		}
+		d.mu.Unlock()
+		learned++
+	}
+	return learned, nil
+}
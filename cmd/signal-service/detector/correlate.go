@@ -0,0 +1,391 @@
+package detector
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"time"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+	"github.com/microcloud/id"
+	"github.com/microcloud/messages"
+	"github.com/microcloud/storage"
+)
+
+// CorrelationConfig controls grouping the incidents of one tick under a
+// parent incident
+type CorrelationConfig struct {
+	Enabled bool
+	// MinEntities is how many entities must breach together, on one node or
+	// along one dependency chain, before their incidents get a parent
+	MinEntities int
+}
+
+// DefaultCorrelationConfig returns the default correlation config
+func DefaultCorrelationConfig() CorrelationConfig {
+	return CorrelationConfig{
+		Enabled:     true,
+		MinEntities: 3,
+	}
+}
+
+// WithCorrelation overrides the correlation config. Pass a config with
+// Enabled false to publish every incident on its own.
+func WithCorrelation(cfg CorrelationConfig) Option {
+	return func(d *Detector) {
+		d.correlation = cfg
+	}
+}
+
+// pendingIncident is an incident raised during a snapshot, published once
+// the whole snapshot has been checked so related ones can be grouped
+type pendingIncident struct {
+	incident   *opsv1.Incident
+	entityType string
+	msg        string // logged when published
+	args       []any
+}
+
+// parentIncident is a published correlated parent and what it still groups
+type parentIncident struct {
+	incident *opsv1.Incident
+	open     map[string]bool // unresolved child IDs
+	scope    map[string]bool // correlation keys of its children
+}
+
+// SetDependencies replaces the service dependency graph used to correlate
+// incidents, as service ID to the IDs of the services it calls
+func (d *Detector) SetDependencies(deps map[string][]string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dependencies = deps
+}
+
+// raise publishes a new incident, or holds it for correlation at the end of
+// the snapshot. Caller must hold mu.
+func (d *Detector) raise(ctx context.Context, incident *opsv1.Incident, entityType, msg string, args ...any) {
+	if !d.correlation.Enabled {
+		d.publish(ctx, incident, msg, args...)
+		return
+	}
+	d.pending = append(d.pending, pendingIncident{incident: incident, entityType: entityType, msg: msg, args: args})
+}
+
+// publish publishes incident and logs msg. Caller must hold mu.
+func (d *Detector) publish(ctx context.Context, incident *opsv1.Incident, msg string, args ...any) {
+	if err := d.publisher.PublishIncident(ctx, incident); err != nil {
+		d.log.Error("failed to publish incident", "error", err)
+		return
+	}
+	if incident.ParentId != nil {
+		args = append(args, "parent_id", incident.ParentId.Value)
+	}
+	d.log.Warn(msg, args...)
+}
+
+// trackPlacement records which node each service of snapshot runs on.
+// Caller must hold mu.
+func (d *Detector) trackPlacement(snapshot *simv1.MetricSnapshot) {
+	if !snapshot.Delta {
+		clear(d.placement)
+	}
+	for _, id := range snapshot.RemovedIds {
+		delete(d.placement, id)
+	}
+	for _, svc := range snapshot.Services {
+		if node := svc.NodeId.GetValue(); node != "" {
+			d.placement[svc.Id.Value] = node
+		}
+	}
+}
+
+// correlationKeys returns the keys that tie an incident to others: its
+// entity, and the node the entity is or runs on. Caller must hold mu.
+func (d *Detector) correlationKeys(p pendingIncident) []string {
+	entity := p.incident.AffectedIds[0]
+	switch p.entityType {
+	case "node":
+		return []string{"node:" + entity}
+	case "service":
+		keys := []string{"service:" + entity}
+		if node, ok := d.placement[entity]; ok {
+			keys = append(keys, "node:"+node)
+		}
+		return keys
+	}
+	// Region incidents span too much to pin on one node or chain
+	return nil
+}
+
+// flushPending groups the incidents raised during a snapshot and publishes
+// them. Incidents sharing a node or a direct dependency are grouped; a group
+// spanning MinEntities entities is published under a new parent, and one
+// touching an active parent's scope joins that parent. Caller must hold mu.
+func (d *Detector) flushPending(ctx context.Context, tickID int64, now time.Time) {
+	pending := d.pending
+	d.pending = nil
+	if len(pending) == 0 {
+		return
+	}
+
+	// Union-find over the pending incidents, joined by shared keys
+	root := make([]int, len(pending))
+	for i := range root {
+		root[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if root[i] != i {
+			root[i] = find(root[i])
+		}
+		return root[i]
+	}
+	union := func(a, b int) { root[find(a)] = find(b) }
+
+	keys := make([][]string, len(pending))
+	byKey := make(map[string]int)
+	for i, p := range pending {
+		keys[i] = d.correlationKeys(p)
+		for _, k := range keys[i] {
+			if j, ok := byKey[k]; ok {
+				union(i, j)
+			} else {
+				byKey[k] = i
+			}
+		}
+	}
+	for i, p := range pending {
+		if p.entityType != "service" {
+			continue
+		}
+		for _, dep := range d.dependencies[p.incident.AffectedIds[0]] {
+			if j, ok := byKey["service:"+dep]; ok {
+				union(i, j)
+			}
+		}
+	}
+
+	groups := make(map[int][]int)
+	var order []int
+	for i := range pending {
+		r := find(i)
+		if _, ok := groups[r]; !ok {
+			order = append(order, r)
+		}
+		groups[r] = append(groups[r], i)
+	}
+
+	for _, r := range order {
+		members := groups[r]
+		var groupKeys []string
+		entities := make(map[string]bool)
+		for _, i := range members {
+			groupKeys = append(groupKeys, keys[i]...)
+			entities[pending[i].incident.AffectedIds[0]] = true
+		}
+
+		parent := d.parentFor(groupKeys)
+		if parent == nil && len(entities) >= d.correlation.MinEntities {
+			parent = d.newParent(pending, members, tickID)
+			d.publish(ctx, parent.incident, "correlated incidents", "rule", parent.incident.RuleName,
+				"entity", shortID(parent.incident.AffectedIds[0]), "children", len(members), "entities", len(entities))
+		}
+		for _, i := range members {
+			p := pending[i]
+			if parent != nil {
+				p.incident.ParentId = &commonv1.UUID{Value: parent.incident.Id.Value}
+				parent.open[p.incident.Id.Value] = true
+				for _, k := range keys[i] {
+					parent.scope[k] = true
+				}
+			}
+			d.publish(ctx, p.incident, p.msg, p.args...)
+		}
+	}
+}
+
+// parentFor returns the active parent whose scope covers any of keys.
+// Caller must hold mu.
+func (d *Detector) parentFor(keys []string) *parentIncident {
+	for _, parent := range d.parents {
+		for _, k := range keys {
+			if parent.scope[k] {
+				return parent
+			}
+		}
+	}
+	return nil
+}
+
+// newParent builds the parent incident for a group of pending incidents and
+// starts tracking it. The parent takes the rule, entity and metrics of the
+// group's likely cause, so the decider acts on that, and the highest
+// severity in the group. Caller must hold mu.
+func (d *Detector) newParent(pending []pendingIncident, members []int, tickID int64) *parentIncident {
+	cause := pending[members[0]]
+	causeScore := -1
+	for _, i := range members {
+		p := pending[i]
+		score := 0
+		switch p.entityType {
+		case "node":
+			// Everything on a breaching node is likely its fallout
+			score = len(members) + 1
+		case "service":
+			// Otherwise the service most of the group calls
+			for _, j := range members {
+				for _, dep := range d.dependencies[pending[j].incident.AffectedIds[0]] {
+					if dep == p.incident.AffectedIds[0] {
+						score++
+					}
+				}
+			}
+		}
+		if score > causeScore || score == causeScore && p.incident.Severity > cause.incident.Severity {
+			cause, causeScore = p, score
+		}
+	}
+
+	severity := cause.incident.Severity
+	affected := []string{cause.incident.AffectedIds[0]}
+	seen := map[string]bool{affected[0]: true}
+	childIDs := make([]string, 0, len(members))
+	for _, i := range members {
+		child := pending[i].incident
+		severity = max(severity, child.Severity)
+		childIDs = append(childIDs, child.Id.Value)
+		for _, id := range child.AffectedIds {
+			if !seen[id] {
+				seen[id] = true
+				affected = append(affected, id)
+			}
+		}
+	}
+
+	count := fmt.Sprintf("%d", len(members))
+	title := messages.New(messages.IncidentCorrelatedTitle,
+		"count", count,
+		"entity_type", cause.entityType,
+		"entity", shortID(affected[0]),
+	)
+	description := messages.New(messages.IncidentCorrelatedDescription,
+		"count", count,
+		"entities", fmt.Sprintf("%d", len(affected)),
+		"entity_type", cause.entityType,
+		"entity", shortID(affected[0]),
+		"rule", cause.incident.RuleName,
+	)
+	metrics := maps.Clone(cause.incident.Metrics)
+	if metrics == nil {
+		metrics = make(map[string]float64)
+	}
+	metrics["child_count"] = float64(len(members))
+
+	parent := &parentIncident{
+		incident: &opsv1.Incident{
+			Id:                 &commonv1.UUID{Value: id.NewV7()},
+			DetectedAt:         d.stamp(tickID),
+			Severity:           severity,
+			Title:              title.String(),
+			Description:        description.String(),
+			SourceService:      "signal-service",
+			AffectedIds:        affected,
+			RuleName:           cause.incident.RuleName,
+			Metrics:            metrics,
+			TitleMessage:       &commonv1.LocalizedMessage{Key: title.Key, Args: title.Args},
+			DescriptionMessage: &commonv1.LocalizedMessage{Key: description.Key, Args: description.Args},
+			Fingerprint:        "correlated/" + cause.incident.Fingerprint,
+			Occurrences:        1,
+			ChildIds:           childIDs,
+		},
+		open:  make(map[string]bool, len(members)),
+		scope: make(map[string]bool),
+	}
+	d.parents[parent.incident.Id.Value] = parent
+	return parent
+}
+
+// trackParent starts tracking a correlated parent known only to the
+// database, such as one raised before a restart or by another replica, so
+// it resolves with the last of its open children. Children that later
+// breach do not join it. Caller must hold mu.
+func (d *Detector) trackParent(ctx context.Context, parentID string) {
+	if _, ok := d.parents[parentID]; ok || d.incidentsRepo == nil {
+		return
+	}
+	row, err := d.incidentsRepo.GetByID(ctx, parentID)
+	if err != nil {
+		if !storage.IsNotFound(err) {
+			d.log.Error("failed to look up parent incident", "incident_id", parentID, "error", err)
+		}
+		return
+	}
+	if row.Resolved {
+		return
+	}
+	children, err := d.incidentsRepo.ListChildren(ctx, parentID)
+	if err != nil {
+		d.log.Error("failed to list child incidents", "incident_id", parentID, "error", err)
+		return
+	}
+
+	parent := &parentIncident{
+		incident: parentFromRow(*row),
+		open:     make(map[string]bool, len(children)),
+		scope:    make(map[string]bool),
+	}
+	for _, child := range children {
+		parent.incident.ChildIds = append(parent.incident.ChildIds, child.ID)
+		if !child.Resolved {
+			parent.open[child.ID] = true
+		}
+	}
+	d.parents[parentID] = parent
+}
+
+// parentFromRow rebuilds a parent incident as published from its database
+// row
+func parentFromRow(row storage.IncidentRow) *opsv1.Incident {
+	incident := &opsv1.Incident{
+		Id:            &commonv1.UUID{Value: row.ID},
+		DetectedAt:    &commonv1.SimulationTimestamp{TickId: row.TickID, WallTimeUnixMs: row.DetectedAt.UnixMilli()},
+		Severity:      commonv1.IncidentSeverity(row.Severity),
+		Title:         row.Title,
+		Description:   row.Description,
+		SourceService: row.SourceService,
+		AffectedIds:   row.AffectedIDs,
+		RuleName:      row.RuleName,
+		Metrics:       row.Metrics,
+		Fingerprint:   row.Fingerprint,
+		Occurrences:   int32(row.Occurrences),
+	}
+	if row.EscalatedAt != nil {
+		incident.EscalatedAt = &commonv1.SimulationTimestamp{WallTimeUnixMs: row.EscalatedAt.UnixMilli()}
+	}
+	if row.TitleKey != "" {
+		incident.TitleMessage = &commonv1.LocalizedMessage{Key: row.TitleKey, Args: row.TitleArgs}
+	}
+	if row.DescriptionKey != "" {
+		incident.DescriptionMessage = &commonv1.LocalizedMessage{Key: row.DescriptionKey, Args: row.DescriptionArgs}
+	}
+	return incident
+}
+
+// detachChild stops tracking child under its parent and returns the parent
+// if child was the last one open, for the caller to resolve or drop.
+// Caller must hold mu.
+func (d *Detector) detachChild(child *opsv1.Incident) *opsv1.Incident {
+	parentID := child.ParentId.GetValue()
+	parent, ok := d.parents[parentID]
+	if !ok {
+		return nil
+	}
+	delete(parent.open, child.Id.GetValue())
+	if len(parent.open) > 0 {
+		return nil
+	}
+	delete(d.parents, parentID)
+	return parent.incident
+}
@@ -4,54 +4,226 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"google.golang.org/protobuf/proto"
+
 	"github.com/microcloud/bus"
 	commonv1 "github.com/microcloud/gen/go/common/v1"
 	opsv1 "github.com/microcloud/gen/go/ops/v1"
 	simv1 "github.com/microcloud/gen/go/sim/v1"
+	"github.com/microcloud/id"
+	"github.com/microcloud/messages"
 	"github.com/microcloud/storage"
 )
 
 // Detector monitors metrics and detects incidents
 type Detector struct {
-	publisher   *bus.Publisher
-	metricsRepo *storage.MetricsRepository
-	log         *slog.Logger
-	rules       []Rule
+	publisher     *bus.Publisher
+	metricsRepo   *storage.MetricsRepository
+	incidentsRepo *storage.IncidentsRepository // nil leaves resolutions to subscribers
+	log           *slog.Logger
+	sampler       *sampler
+
+	rules        atomic.Pointer[[]Rule] // swapped whole, so a snapshot sees one set
+	suppressions atomic.Pointer[[]Suppression]
 
 	mu             sync.Mutex
 	windows        map[string]*metricWindow
-	activeIncidents map[string]bool
+	activeIncidents map[string]*opsv1.Incident // as published, by window key
+	clearing       map[string]time.Time // active incidents below their clear ratio, since when
+	anomaly        *anomalyTracker // nil when anomaly detection is off
+	labels         map[string]map[string]string // node and service labels by ID, matched by rule selectors
+	baseline       *baselineModel
+
+	correlation  CorrelationConfig
+	pending      []pendingIncident          // raised this snapshot, awaiting correlation
+	parents      map[string]*parentIncident // active correlated parents, by ID
+	lastTick     int64                      // tick of the last snapshot processed
+	placement    map[string]string          // service ID to node ID
+	dependencies map[string][]string        // service ID to the service IDs it calls
+
+	escalation EscalationConfig
+
+	noData NoDataConfig
+	seen   map[string]*lastSeen // nodes and services by ID
+
+	stats map[string]RuleStats // since last taken, by rule name
+
+	stateStore *bus.Store // nil keeps state in memory only
+	stateKey   string
+
+	shard Shard // zero value owns every entity
 }
 
 type metricWindow struct {
 	values    []float64
 	timestamps []time.Time
+	evaluated  time.Time // last check, for rules with an evaluation interval
+}
+
+// ratePerMinute returns the least-squares slope of the window's values, in
+// units per minute, or 0 if they all share a timestamp
+func (w *metricWindow) ratePerMinute() float64 {
+	n := float64(len(w.values))
+	if n < 2 {
+		return 0
+	}
+	start := w.timestamps[0]
+	var sumT, sumV float64
+	for i, v := range w.values {
+		sumT += w.timestamps[i].Sub(start).Minutes()
+		sumV += v
+	}
+	meanT, meanV := sumT/n, sumV/n
+	var cov, varT float64
+	for i, v := range w.values {
+		dt := w.timestamps[i].Sub(start).Minutes() - meanT
+		cov += dt * (v - meanV)
+		varT += dt * dt
+	}
+	if varT == 0 {
+		return 0
+	}
+	return cov / varT
+}
+
+// Option configures the Detector
+type Option func(*Detector)
+
+// WithSampling overrides the metric persistence sampling config.
+// Pass a config with Enabled false to store every value.
+func WithSampling(cfg SamplingConfig) Option {
+	return func(d *Detector) {
+		d.sampler = newSampler(cfg)
+	}
+}
+
+// WithIncidents marks cleared incidents resolved in repo as well as
+// publishing the resolution
+func WithIncidents(repo *storage.IncidentsRepository) Option {
+	return func(d *Detector) {
+		d.incidentsRepo = repo
+	}
 }
 
 // New creates a new detector
-func New(publisher *bus.Publisher, metricsRepo *storage.MetricsRepository, log *slog.Logger) *Detector {
-	return &Detector{
+func New(publisher *bus.Publisher, metricsRepo *storage.MetricsRepository, log *slog.Logger, opts ...Option) *Detector {
+	d := &Detector{
 		publisher:       publisher,
 		metricsRepo:     metricsRepo,
 		log:             log,
-		rules:           DefaultRules(),
+		sampler:         newSampler(DefaultSamplingConfig()),
 		windows:         make(map[string]*metricWindow),
-		activeIncidents: make(map[string]bool),
+		activeIncidents: make(map[string]*opsv1.Incident),
+		clearing:        make(map[string]time.Time),
+		baseline:        newBaselineModel(DefaultBaselineConfig()),
+		correlation:     DefaultCorrelationConfig(),
+		escalation:      DefaultEscalationConfig(),
+		noData:          DefaultNoDataConfig(),
+		parents:         make(map[string]*parentIncident),
+		placement:       make(map[string]string),
+		labels:          make(map[string]map[string]string),
+		seen:            make(map[string]*lastSeen),
+		stats:           make(map[string]RuleStats),
+	}
+	d.SetRules(context.Background(), DefaultRules())
+	for _, opt := range opts {
+		opt(d)
 	}
+	return d
+}
+
+// Rules returns the rules being evaluated
+func (d *Detector) Rules() []Rule {
+	return append([]Rule(nil), *d.rules.Load()...)
+}
+
+// SetRules swaps in a new rule set while the detector runs. Snapshots
+// being processed finish on the old set. Windows and active incidents carry
+// over for rules that keep their name, metric and type, so a changed
+// threshold or window applies to the values already collected; those of
+// rules that were removed, now watch another metric or changed type are
+// dropped, and a correlated parent left without open children is resolved.
+func (d *Detector) SetRules(ctx context.Context, rules []Rule) {
+	next := append([]Rule(nil), rules...)
+	byName := make(map[string]Rule, len(next))
+	for _, r := range next {
+		byName[r.Name] = r
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	prev := d.rules.Swap(&next)
+	if prev == nil {
+		return
+	}
+	for _, r := range *prev {
+		if cur, ok := byName[r.Name]; ok && cur.MetricName == r.MetricName && cur.kind() == r.kind() {
+			continue
+		}
+		suffix := ":" + r.Name
+		for key := range d.windows {
+			if strings.HasSuffix(key, suffix) {
+				delete(d.windows, key)
+			}
+		}
+		for key, incident := range d.activeIncidents {
+			if strings.HasSuffix(key, suffix) {
+				if parent := d.detachChild(incident); parent != nil {
+					d.resolveIncident(ctx, parent, d.lastTick, time.Now())
+				}
+				delete(d.activeIncidents, key)
+				delete(d.clearing, key)
+			}
+		}
+	}
+}
+
+// WindowCount returns the number of metric windows held, one per entity
+// and metric seen, including the anomaly series and baseline profiles
+func (d *Detector) WindowCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := len(d.windows) + len(d.baseline.profiles)
+	if d.anomaly != nil {
+		n += len(d.anomaly.series)
+	}
+	return n
+}
+
+// stamp returns the timestamp for an incident event at tickID, read from the
+// bus clock so the incident orders after the snapshot that raised it
+// whatever the sim-engine's host clock says. Rule windows and timeouts are
+// measured on the local clock instead.
+func (d *Detector) stamp(tickID int64) *commonv1.SimulationTimestamp {
+	return &commonv1.SimulationTimestamp{TickId: tickID, WallTimeUnixMs: d.publisher.Clock().Now().WallMs}
 }
 
 // ProcessSnapshot processes a metric snapshot
 func (d *Detector) ProcessSnapshot(ctx context.Context, snapshot *simv1.MetricSnapshot) error {
 	now := time.Now()
+	rules := *d.rules.Load()
 	tickID := snapshot.Timestamp.TickId
 
 	var metricsToStore []storage.MetricRow
 
+	d.mu.Lock()
+	d.lastTick = tickID
+	d.trackPlacement(snapshot)
+	d.trackLabels(snapshot)
+	d.trackSeen(ctx, snapshot, now)
+	d.mu.Unlock()
+
 	for _, node := range snapshot.Nodes {
 		nodeID := node.Id.Value
+		if !d.shard.Owns(nodeID) {
+			continue
+		}
 
 		metricsToStore = append(metricsToStore,
 			storage.MetricRow{
@@ -77,7 +249,7 @@ func (d *Detector) ProcessSnapshot(ctx context.Context, snapshot *simv1.MetricSn
 			},
 		)
 
-		d.checkRulesForEntity(ctx, "node", nodeID, map[string]float64{
+		d.checkRulesForEntity(ctx, rules, "node", nodeID, node.Region, map[string]float64{
 			"cpu_usage_percent":    node.CpuUsagePercent,
 			"memory_usage_percent": node.MemoryUsagePercent,
 			"disk_usage_percent":   node.DiskUsagePercent,
@@ -86,6 +258,9 @@ func (d *Detector) ProcessSnapshot(ctx context.Context, snapshot *simv1.MetricSn
 
 	for _, svc := range snapshot.Services {
 		svcID := svc.Id.Value
+		if !d.shard.Owns(svcID) {
+			continue
+		}
 
 		metricsToStore = append(metricsToStore,
 			storage.MetricRow{
@@ -116,15 +291,79 @@ func (d *Detector) ProcessSnapshot(ctx context.Context, snapshot *simv1.MetricSn
 				MetricName:  "latency_p99_ms",
 				MetricValue: svc.LatencyP99Ms,
 			},
+			storage.MetricRow{
+				Time:        now,
+				TickID:      tickID,
+				ServiceID:   &svcID,
+				MetricName:  "dependency_latency_p99_ms",
+				MetricValue: svc.DependencyLatencyP99Ms,
+			},
+			storage.MetricRow{
+				Time:        now,
+				TickID:      tickID,
+				ServiceID:   &svcID,
+				MetricName:  "memory_usage_percent",
+				MetricValue: svc.MemoryUsagePercent,
+			},
+			storage.MetricRow{
+				Time:        now,
+				TickID:      tickID,
+				ServiceID:   &svcID,
+				MetricName:  "queue_depth",
+				MetricValue: svc.QueueDepth,
+			},
+			storage.MetricRow{
+				Time:        now,
+				TickID:      tickID,
+				ServiceID:   &svcID,
+				MetricName:  "consumer_lag_ms",
+				MetricValue: svc.ConsumerLagMs,
+			},
 		)
 
-		d.checkRulesForEntity(ctx, "service", svcID, map[string]float64{
-			"error_rate_percent": svc.ErrorRatePercent,
-			"latency_p50_ms":     svc.LatencyP50Ms,
-			"latency_p99_ms":     svc.LatencyP99Ms,
+		svcMetrics := map[string]float64{
+			"error_rate_percent":        svc.ErrorRatePercent,
+			"latency_p50_ms":            svc.LatencyP50Ms,
+			"latency_p99_ms":            svc.LatencyP99Ms,
+			"dependency_latency_p99_ms": svc.DependencyLatencyP99Ms,
+			"memory_usage_percent":      svc.MemoryUsagePercent,
+			"queue_depth":               svc.QueueDepth,
+			"consumer_lag_ms":           svc.ConsumerLagMs,
+		}
+		// Custom channels never shadow the built-in metrics
+		for name, value := range svc.CustomMetrics {
+			if _, builtin := svcMetrics[name]; builtin || name == "requests_per_second" {
+				continue
+			}
+			svcMetrics[name] = value
+			metricsToStore = append(metricsToStore, storage.MetricRow{
+				Time:        now,
+				TickID:      tickID,
+				ServiceID:   &svcID,
+				MetricName:  name,
+				MetricValue: value,
+			})
+		}
+
+		d.checkRulesForEntity(ctx, rules, "service", svcID, svc.Region, svcMetrics, tickID)
+	}
+
+	for _, region := range snapshot.Regions {
+		if !d.shard.Owns(region.Name) {
+			continue
+		}
+		d.checkRulesForEntity(ctx, rules, "region", region.Name, region.Name, map[string]float64{
+			"replication_lag_ms": region.ReplicationLagMs,
 		}, tickID)
 	}
 
+	d.mu.Lock()
+	d.checkNoData(ctx, tickID, now)
+	d.flushPending(ctx, tickID, now)
+	d.escalateLasting(ctx, tickID, now)
+	metricsToStore = d.sampler.filter(metricsToStore)
+	d.mu.Unlock()
+
 	if err := d.metricsRepo.BatchInsert(ctx, metricsToStore); err != nil {
 		d.log.Error("failed to store metrics", "error", err)
 	}
@@ -132,17 +371,56 @@ func (d *Detector) ProcessSnapshot(ctx context.Context, snapshot *simv1.MetricSn
 	return nil
 }
 
-func (d *Detector) checkRulesForEntity(ctx context.Context, entityType, entityID string, metrics map[string]float64, tickID int64) {
+// trackLabels records the labels of each node and service of snapshot.
+// Snapshots at reduced detail leave labels out, so only full ones update
+// them. Caller must hold mu.
+func (d *Detector) trackLabels(snapshot *simv1.MetricSnapshot) {
+	for _, id := range snapshot.RemovedIds {
+		delete(d.labels, id)
+	}
+	if snapshot.DetailLevel > 0 {
+		return
+	}
+	if !snapshot.Delta {
+		clear(d.labels)
+	}
+	for _, node := range snapshot.Nodes {
+		d.labels[node.Id.Value] = node.Labels
+	}
+	for _, svc := range snapshot.Services {
+		d.labels[svc.Id.Value] = svc.Labels
+	}
+}
+
+func (d *Detector) checkRulesForEntity(ctx context.Context, rules []Rule, entityType, entityID, region string, metrics map[string]float64, tickID int64) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
 	now := time.Now()
 
-	for _, rule := range d.rules {
+	if d.anomaly != nil {
+		d.checkAnomalies(ctx, entityType, entityID, region, metrics, tickID)
+	}
+	scores := d.scoreBaselines(rules, entityType, entityID, metrics, now)
+	labels := d.labels[entityID]
+
+	for _, rule := range rules {
+		if !rule.AppliesTo(region) || !rule.Selects(labels) {
+			continue
+		}
+		rule = rule.ForLabels(labels)
 		value, ok := metrics[rule.MetricName]
 		if !ok {
 			continue
 		}
+		// Baseline rules window and compare the deviation from the baseline
+		var score baselineScore
+		if rule.Type == RuleTypeBaseline {
+			if score, ok = scores[rule.MetricName]; !ok {
+				continue
+			}
+			value = score.deviation
+		}
 
 		windowKey := fmt.Sprintf("%s:%s:%s", entityType, entityID, rule.Name)
 		window, exists := d.windows[windowKey]
@@ -154,6 +432,13 @@ func (d *Detector) checkRulesForEntity(ctx context.Context, entityType, entityID
 			d.windows[windowKey] = window
 		}
 
+		if rule.EvaluateEverySeconds > 0 {
+			if now.Sub(window.evaluated) < time.Duration(rule.EvaluateEverySeconds)*time.Second {
+				continue
+			}
+			window.evaluated = now
+		}
+
 		window.values = append(window.values, value)
 		window.timestamps = append(window.timestamps, now)
 
@@ -168,52 +453,208 @@ func (d *Detector) checkRulesForEntity(ctx context.Context, entityType, entityID
 		window.values = window.values[startIdx:]
 		window.timestamps = window.timestamps[startIdx:]
 
-		if len(window.values) < 3 {
+		if len(window.values) < rule.minSamples() {
 			continue
 		}
 
-		breachCount := 0
-		for _, v := range window.values {
-			if rule.Evaluate(v) {
-				breachCount++
+		// Rate rules judge the slope over the whole window, which already
+		// smooths single values, so it either breaches or does not
+		var breachRatio, rate float64
+		if rule.Type == RuleTypeRate {
+			rate = window.ratePerMinute()
+			if rule.Evaluate(rate) {
+				breachRatio = 1
 			}
+			d.countEvaluation(rule.Name, breachRatio == 1)
+		} else {
+			d.countEvaluation(rule.Name, rule.Evaluate(value))
+			breachCount := 0
+			for _, v := range window.values {
+				if rule.Evaluate(v) {
+					breachCount++
+				}
+			}
+			breachRatio = float64(breachCount) / float64(len(window.values))
 		}
-
-		breachRatio := float64(breachCount) / float64(len(window.values))
 		incidentKey := fmt.Sprintf("%s:%s:%s", entityType, entityID, rule.Name)
+		triggerRatio, clearRatio := rule.ratios()
 
-		if breachRatio > 0.7 && !d.activeIncidents[incidentKey] {
-			d.activeIncidents[incidentKey] = true
-
+		if breachRatio > triggerRatio && d.activeIncidents[incidentKey] == nil {
+			if d.suppressed(rule.Name, entityID, now) {
+				continue
+			}
+			title := messages.New(messages.IncidentThresholdTitle,
+				"rule", rule.Name,
+				"metric", rule.MetricName,
+				"entity_type", entityType,
+				"entity", shortID(entityID),
+			)
+			description := messages.New(messages.IncidentThresholdDescription,
+				"metric", rule.MetricName,
+				"threshold", fmt.Sprintf("%.2f", rule.Threshold),
+				"value", fmt.Sprintf("%.2f", value),
+				"window", fmt.Sprintf("%d", rule.WindowSeconds),
+				"region", region,
+			)
+			incidentMetrics := map[string]float64{rule.MetricName: value}
+			switch rule.Type {
+			case RuleTypeRate:
+				description = messages.New(messages.IncidentRateDescription,
+					"metric", rule.MetricName,
+					"rate", fmt.Sprintf("%+.2f", rate),
+					"value", fmt.Sprintf("%.2f", value),
+					"threshold", fmt.Sprintf("%.2f", rule.Threshold),
+					"window", fmt.Sprintf("%d", rule.WindowSeconds),
+					"region", region,
+				)
+				incidentMetrics = map[string]float64{
+					rule.MetricName:   value,
+					"rate_per_minute": rate,
+				}
+			case RuleTypeBaseline:
+				raw := metrics[rule.MetricName]
+				description = messages.New(messages.IncidentBaselineDescription,
+					"metric", rule.MetricName,
+					"value", fmt.Sprintf("%.2f", raw),
+					"expected", fmt.Sprintf("%.2f", score.expected),
+					"deviation", fmt.Sprintf("%.1f", score.deviation),
+					"threshold", fmt.Sprintf("%.1f", rule.Threshold),
+					"window", fmt.Sprintf("%d", rule.WindowSeconds),
+					"region", region,
+				)
+				incidentMetrics = map[string]float64{
+					rule.MetricName: raw,
+					"baseline":      score.expected,
+					"deviation":     score.deviation,
+				}
+			}
 			incident := &opsv1.Incident{
-				Id:            &commonv1.UUID{Value: randomUUID()},
-				DetectedAt:    &commonv1.SimulationTimestamp{TickId: tickID, WallTimeUnixMs: now.UnixMilli()},
-				Severity:      rule.Severity,
-				Title:         fmt.Sprintf("%s: %s on %s %s", rule.Name, rule.MetricName, entityType, entityID[:8]),
-				Description:   fmt.Sprintf("%s breached threshold %.2f (current: %.2f) for %d seconds", rule.MetricName, rule.Threshold, value, rule.WindowSeconds),
-				SourceService: "signal-service",
-				AffectedIds:   []string{entityID},
-				RuleName:      rule.Name,
-				Metrics:       map[string]float64{rule.MetricName: value},
-				Resolved:      false,
+				Id:                 &commonv1.UUID{Value: id.NewV7()},
+				DetectedAt:         d.stamp(tickID),
+				Severity:           rule.Severity,
+				Title:              title.String(),
+				Description:        description.String(),
+				SourceService:      "signal-service",
+				AffectedIds:        []string{entityID},
+				RuleName:           rule.Name,
+				Metrics:            incidentMetrics,
+				Resolved:           false,
+				TitleMessage:       &commonv1.LocalizedMessage{Key: title.Key, Args: title.Args},
+				DescriptionMessage: &commonv1.LocalizedMessage{Key: description.Key, Args: description.Args},
+				Fingerprint:        fingerprint(rule.Name, entityID, rule.MetricName),
+				Occurrences:        1,
 			}
 
-			if err := d.publisher.PublishIncident(ctx, incident); err != nil {
-				d.log.Error("failed to publish incident", "error", err)
-			} else {
-				d.log.Warn("incident detected", "rule", rule.Name, "entity", entityID[:8], "severity", rule.Severity)
+			d.activeIncidents[incidentKey] = incident
+			if d.recordRepeat(ctx, incident) {
+				continue
 			}
-		} else if breachRatio < 0.3 && d.activeIncidents[incidentKey] {
-			delete(d.activeIncidents, incidentKey)
-			d.log.Info("incident resolved", "rule", rule.Name, "entity", entityID[:8])
+
+			d.countFired(rule.Name)
+			d.raise(ctx, incident, entityType, "incident detected", "rule", rule.Name, "entity", shortID(entityID), "region", region, "severity", rule.Severity)
+		} else if d.activeIncidents[incidentKey] != nil {
+			// Clears only once the ratio stays low for ClearSeconds, so a
+			// flapping metric does not reopen the incident every few ticks
+			if breachRatio >= clearRatio {
+				delete(d.clearing, incidentKey)
+				continue
+			}
+			since, ok := d.clearing[incidentKey]
+			if !ok {
+				since = now
+				d.clearing[incidentKey] = now
+			}
+			if now.Sub(since) >= time.Duration(rule.ClearSeconds)*time.Second {
+				d.resolveIncident(ctx, d.activeIncidents[incidentKey], tickID, now)
+				delete(d.activeIncidents, incidentKey)
+				delete(d.clearing, incidentKey)
+			}
+		}
+	}
+}
+
+// fingerprint identifies repeats of one incident: the rule that fired, the
+// entity and the metric it fired on
+func fingerprint(ruleName, entityID, dimension string) string {
+	return ruleName + "/" + entityID + "/" + dimension
+}
+
+// recordRepeat looks for an unresolved incident with incident's fingerprint,
+// such as one raised before a restart or by another replica. If there is
+// one it counts another occurrence of it, points incident at it, keeping
+// an escalation and its correlated parent, and returns true, and the
+// caller must not publish incident. Caller must hold mu.
+func (d *Detector) recordRepeat(ctx context.Context, incident *opsv1.Incident) bool {
+	if d.incidentsRepo == nil || incident.Fingerprint == "" {
+		return false
+	}
+	existing, err := d.incidentsRepo.GetActiveByFingerprint(ctx, incident.Fingerprint)
+	if storage.IsNotFound(err) {
+		return false
+	}
+	if err != nil {
+		d.log.Error("failed to look up active incident", "fingerprint", incident.Fingerprint, "error", err)
+		return false
+	}
+
+	occurrences, err := d.incidentsRepo.RecordOccurrence(ctx, existing.ID)
+	if storage.IsNotFound(err) {
+		return false
+	}
+	if err != nil {
+		d.log.Error("failed to record incident occurrence", "incident_id", existing.ID, "error", err)
+		occurrences = existing.Occurrences
+	}
+	incident.Id = &commonv1.UUID{Value: existing.ID}
+	incident.DetectedAt = &commonv1.SimulationTimestamp{TickId: existing.TickID, WallTimeUnixMs: existing.DetectedAt.UnixMilli()}
+	incident.Occurrences = int32(occurrences)
+	if existing.EscalatedAt != nil {
+		incident.Severity = max(incident.Severity, commonv1.IncidentSeverity(existing.Severity))
+		incident.EscalatedAt = &commonv1.SimulationTimestamp{WallTimeUnixMs: existing.EscalatedAt.UnixMilli()}
+	}
+	if existing.ParentID != nil {
+		incident.ParentId = &commonv1.UUID{Value: *existing.ParentID}
+		d.trackParent(ctx, *existing.ParentID)
+	}
+	d.log.Info("incident already active", "incident_id", existing.ID, "fingerprint", incident.Fingerprint, "occurrences", occurrences)
+	return true
+}
+
+// resolveIncident publishes incident again with Resolved set, so the
+// orchestrator and agent-service see it clear, and marks it resolved in the
+// database when the detector has the incidents repository. Resolving the
+// last open child of a correlated parent resolves the parent too. Caller
+// must hold mu.
+func (d *Detector) resolveIncident(ctx context.Context, incident *opsv1.Incident, tickID int64, now time.Time) {
+	resolved := proto.Clone(incident).(*opsv1.Incident)
+	resolved.Resolved = true
+	resolved.ResolvedAt = d.stamp(tickID)
+
+	if err := d.publisher.PublishIncident(ctx, resolved); err != nil {
+		d.log.Error("failed to publish incident resolution", "incident_id", resolved.Id.GetValue(), "error", err)
+	}
+	if d.incidentsRepo != nil {
+		// The agent-service stores incidents as they arrive, so a quick
+		// resolution can beat the insert; the published copy still says so
+		if err := d.incidentsRepo.MarkResolved(ctx, resolved.Id.GetValue(), now); err != nil && !storage.IsNotFound(err) {
+			d.log.Error("failed to mark incident resolved", "incident_id", resolved.Id.GetValue(), "error", err)
+		}
+	}
+	d.log.Info("incident resolved", "rule", resolved.RuleName, "entity", shortID(resolved.AffectedIds[0]))
+
+	// A correlated parent resolves with the last of its children
+	if resolved.ParentId != nil {
+		if parent := d.detachChild(resolved); parent != nil {
+			d.resolveIncident(ctx, parent, tickID, now)
 		}
 	}
 }
 
-func randomUUID() string {
-	b := make([]byte, 16)
-	for i := range b {
-		b[i] = byte(time.Now().UnixNano() >> (i * 4))
+// shortID truncates UUIDs for titles and logs; shorter IDs such as region
+// names are returned unchanged
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
 	}
-	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+	return id
 }
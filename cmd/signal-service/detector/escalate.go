@@ -0,0 +1,86 @@
+package detector
+
+import (
+	"context"
+	"time"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+	"github.com/microcloud/storage"
+)
+
+// EscalationConfig controls raising warnings that stay active to critical,
+// so problems nobody fixed do not sit unnoticed at a low severity
+type EscalationConfig struct {
+	After time.Duration // how long a warning stays active before it escalates; 0 never escalates
+}
+
+// DefaultEscalationConfig returns the default escalation config
+func DefaultEscalationConfig() EscalationConfig {
+	return EscalationConfig{
+		After: 15 * time.Minute,
+	}
+}
+
+// WithEscalation overrides the escalation config
+func WithEscalation(cfg EscalationConfig) Option {
+	return func(d *Detector) {
+		d.escalation = cfg
+	}
+}
+
+// escalateLasting escalates the active warnings detected at least After
+// ago. Incidents already clearing are left to resolve. Caller must hold mu.
+func (d *Detector) escalateLasting(ctx context.Context, tickID int64, now time.Time) {
+	if d.escalation.After <= 0 {
+		return
+	}
+	for key, incident := range d.activeIncidents {
+		if _, clearing := d.clearing[key]; clearing {
+			continue
+		}
+		d.escalateIfDue(ctx, incident, tickID, now)
+	}
+	if d.anomaly != nil {
+		for _, incident := range d.anomaly.active {
+			d.escalateIfDue(ctx, incident, tickID, now)
+		}
+	}
+}
+
+// escalateIfDue escalates incident if it is a warning detected at least
+// After ago, and its correlated parent with it. Caller must hold mu.
+func (d *Detector) escalateIfDue(ctx context.Context, incident *opsv1.Incident, tickID int64, now time.Time) {
+	if incident.Severity != commonv1.IncidentSeverity_INCIDENT_SEVERITY_WARNING || incident.EscalatedAt != nil {
+		return
+	}
+	detected := time.UnixMilli(incident.DetectedAt.GetWallTimeUnixMs())
+	if now.Sub(detected) < d.escalation.After {
+		return
+	}
+	d.escalate(ctx, incident, tickID, now)
+
+	if parent, ok := d.parents[incident.ParentId.GetValue()]; ok && parent.incident.Severity < incident.Severity {
+		d.escalate(ctx, parent.incident, tickID, now)
+	}
+}
+
+// escalate raises incident to critical in place, records it in the database
+// when the detector has the incidents repository and publishes it again.
+// Caller must hold mu.
+func (d *Detector) escalate(ctx context.Context, incident *opsv1.Incident, tickID int64, now time.Time) {
+	incident.Severity = commonv1.IncidentSeverity_INCIDENT_SEVERITY_CRITICAL
+	incident.EscalatedAt = d.stamp(tickID)
+
+	if d.incidentsRepo != nil {
+		if err := d.incidentsRepo.Escalate(ctx, incident.Id.GetValue(), int(incident.Severity), now); err != nil && !storage.IsNotFound(err) {
+			d.log.Error("failed to escalate incident", "incident_id", incident.Id.GetValue(), "error", err)
+		}
+	}
+	if err := d.publisher.PublishIncident(ctx, incident); err != nil {
+		d.log.Error("failed to publish incident escalation", "incident_id", incident.Id.GetValue(), "error", err)
+		return
+	}
+	active := now.Sub(time.UnixMilli(incident.DetectedAt.GetWallTimeUnixMs())).Round(time.Second)
+	d.log.Warn("incident escalated", "rule", incident.RuleName, "entity", shortID(incident.AffectedIds[0]), "active", active)
+}
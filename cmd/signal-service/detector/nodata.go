@@ -0,0 +1,149 @@
+package detector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+	"github.com/microcloud/id"
+	"github.com/microcloud/messages"
+)
+
+// NoDataRuleName is the rule name of incidents raised for entities that
+// stopped appearing in snapshots
+const NoDataRuleName = "no_data"
+
+// aggregatedDetail is the snapshot detail level at which services are
+// reported one per name, so their instances' absence means nothing
+const aggregatedDetail = 3
+
+// NoDataConfig controls missing-data detection. A node or service missing
+// from snapshots for After raises an incident, which resolves when it
+// appears again. Entities reported removed are forgotten instead. With
+// delta snapshots a missing entity is only noticed at keyframes, so After
+// should span a keyframe interval.
+type NoDataConfig struct {
+	After    time.Duration // 0 disables missing-data detection
+	Severity commonv1.IncidentSeverity
+}
+
+// DefaultNoDataConfig returns the default missing-data config
+func DefaultNoDataConfig() NoDataConfig {
+	return NoDataConfig{
+		After:    time.Minute,
+		Severity: commonv1.IncidentSeverity_INCIDENT_SEVERITY_WARNING,
+	}
+}
+
+// WithNoData overrides the missing-data config
+func WithNoData(cfg NoDataConfig) Option {
+	return func(d *Detector) {
+		d.noData = cfg
+	}
+}
+
+// lastSeen is when an entity last appeared in a snapshot
+type lastSeen struct {
+	entityType string
+	region     string
+	at         time.Time
+}
+
+// trackSeen records the nodes and services of snapshot as seen at now and
+// resolves the missing-data incidents of those that are back. Caller must
+// hold mu.
+func (d *Detector) trackSeen(ctx context.Context, snapshot *simv1.MetricSnapshot, now time.Time) {
+	tickID := snapshot.Timestamp.GetTickId()
+	for _, id := range snapshot.RemovedIds {
+		if seen, ok := d.seen[id]; ok {
+			d.clearNoData(ctx, seen.entityType, id, tickID, now)
+			delete(d.seen, id)
+		}
+	}
+	if snapshot.DetailLevel >= aggregatedDetail {
+		// Instances are folded together, so none can be told missing
+		for _, seen := range d.seen {
+			if seen.entityType == "service" {
+				seen.at = now
+			}
+		}
+	}
+
+	see := func(entityType, entityID, region string) {
+		if !d.shard.Owns(entityID) {
+			return
+		}
+		d.clearNoData(ctx, entityType, entityID, tickID, now)
+		d.seen[entityID] = &lastSeen{entityType: entityType, region: region, at: now}
+	}
+	for _, node := range snapshot.Nodes {
+		see("node", node.Id.Value, node.Region)
+	}
+	if snapshot.DetailLevel < aggregatedDetail {
+		for _, svc := range snapshot.Services {
+			see("service", svc.Id.Value, svc.Region)
+		}
+	}
+}
+
+// clearNoData resolves the missing-data incident of an entity, if it has
+// one. Caller must hold mu.
+func (d *Detector) clearNoData(ctx context.Context, entityType, entityID string, tickID int64, now time.Time) {
+	key := fmt.Sprintf("%s:%s:%s", entityType, entityID, NoDataRuleName)
+	if incident := d.activeIncidents[key]; incident != nil {
+		d.resolveIncident(ctx, incident, tickID, now)
+		delete(d.activeIncidents, key)
+	}
+}
+
+// checkNoData raises an incident for each entity missing from snapshots
+// for at least After that does not have one yet. Caller must hold mu.
+func (d *Detector) checkNoData(ctx context.Context, tickID int64, now time.Time) {
+	if d.noData.After <= 0 {
+		return
+	}
+	for entityID, seen := range d.seen {
+		missing := now.Sub(seen.at)
+		key := fmt.Sprintf("%s:%s:%s", seen.entityType, entityID, NoDataRuleName)
+		if missing < d.noData.After || d.activeIncidents[key] != nil || d.suppressed(NoDataRuleName, entityID, now) {
+			continue
+		}
+
+		title := messages.New(messages.IncidentNoDataTitle,
+			"entity_type", seen.entityType,
+			"entity", shortID(entityID),
+		)
+		description := messages.New(messages.IncidentNoDataDescription,
+			"entity_type", seen.entityType,
+			"entity", shortID(entityID),
+			"seconds", fmt.Sprintf("%.0f", missing.Seconds()),
+			"last_seen", seen.at.UTC().Format(time.RFC3339),
+			"region", seen.region,
+		)
+		incident := &opsv1.Incident{
+			Id:                 &commonv1.UUID{Value: id.NewV7()},
+			DetectedAt:         d.stamp(tickID),
+			Severity:           d.noData.Severity,
+			Title:              title.String(),
+			Description:        description.String(),
+			SourceService:      "signal-service",
+			AffectedIds:        []string{entityID},
+			RuleName:           NoDataRuleName,
+			Metrics:            map[string]float64{"seconds_since_seen": missing.Seconds()},
+			TitleMessage:       &commonv1.LocalizedMessage{Key: title.Key, Args: title.Args},
+			DescriptionMessage: &commonv1.LocalizedMessage{Key: description.Key, Args: description.Args},
+			Fingerprint:        fingerprint(NoDataRuleName, entityID, "seen"),
+			Occurrences:        1,
+		}
+		d.activeIncidents[key] = incident
+		if d.recordRepeat(ctx, incident) {
+			continue
+		}
+
+		d.countFired(NoDataRuleName)
+		d.raise(ctx, incident, seen.entityType, "entity stopped reporting", "entity", shortID(entityID), "type", seen.entityType, "region", seen.region, "missing", missing.Round(time.Second))
+	}
+}
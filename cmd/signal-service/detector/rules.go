@@ -1,10 +1,35 @@
 package detector
 
 import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
 	commonv1 "github.com/microcloud/gen/go/common/v1"
 	opsv1 "github.com/microcloud/gen/go/ops/v1"
 )
 
+// Rule types
+const (
+	// RuleTypeThreshold compares values to the threshold, the default
+	RuleTypeThreshold = "threshold"
+
+	// RuleTypeBaseline compares how many standard deviations a value is
+	// from its learned baseline for the time of day to the threshold, so
+	// "gt 3" fires on values well above what is normal at that hour
+	RuleTypeBaseline = "baseline"
+
+	// RuleTypeRate compares the slope of the metric over the window, in
+	// units per minute, to the threshold, so an error_rate_percent rule
+	// "gt 3" fires when the error rate climbs by more than 3% a minute
+	RuleTypeRate = "rate"
+)
+
+// RuleTypes are the types a rule can have; empty means threshold
+var RuleTypes = []string{RuleTypeThreshold, RuleTypeBaseline, RuleTypeRate}
+
 // Rule defines a detection rule
 type Rule struct {
 	Name          string
@@ -13,8 +38,47 @@ type Rule struct {
 	Threshold     float64
 	WindowSeconds int
 	Severity      commonv1.IncidentSeverity
+	Region        string // limits the rule to one region; empty matches all
+	Type          string // one of RuleTypes; empty is threshold
+
+	// Hysteresis: an incident opens once more than TriggerRatio of the
+	// window breaches and clears once less than ClearRatio has for
+	// ClearSeconds. Zero ratios use DefaultTriggerRatio and DefaultClearRatio.
+	TriggerRatio float64
+	ClearRatio   float64
+	ClearSeconds int
+
+	// Selector limits the rule to entities whose labels include all of
+	// these; empty matches every entity
+	Selector map[string]string
+	// Overrides replace the threshold for the entities they select, so one
+	// service can tolerate more than the rest. The first match wins.
+	Overrides []RuleOverride
+
+	// MinSamples is how many values the window needs before it is checked;
+	// 0 uses DefaultMinSamples. EvaluateEverySeconds checks the rule at most
+	// that often per entity, leaving the values in between out of the
+	// window; 0 checks it on every snapshot.
+	MinSamples           int
+	EvaluateEverySeconds int
 }
 
+// RuleOverride replaces a rule's threshold for entities whose labels
+// include all of Selector
+type RuleOverride struct {
+	Selector  map[string]string
+	Threshold float64
+}
+
+// Default share of a window's values that opens and clears an incident
+const (
+	DefaultTriggerRatio = 0.7
+	DefaultClearRatio   = 0.3
+)
+
+// DefaultMinSamples is how many values a window needs before it is checked
+const DefaultMinSamples = 3
+
 // DefaultRules returns the default detection rules
 func DefaultRules() []Rule {
 	return []Rule{
@@ -58,6 +122,14 @@ func DefaultRules() []Rule {
 			WindowSeconds: 60,
 			Severity:      commonv1.IncidentSeverity_INCIDENT_SEVERITY_WARNING,
 		},
+		{
+			Name:          "high_disk_usage",
+			MetricName:    "disk_usage_percent",
+			Operator:      "gt",
+			Threshold:     90.0,
+			WindowSeconds: 60,
+			Severity:      commonv1.IncidentSeverity_INCIDENT_SEVERITY_WARNING,
+		},
 		{
 			Name:          "high_latency",
 			MetricName:    "latency_p99_ms",
@@ -66,21 +138,152 @@ func DefaultRules() []Rule {
 			WindowSeconds: 30,
 			Severity:      commonv1.IncidentSeverity_INCIDENT_SEVERITY_WARNING,
 		},
+		{
+			// Custom metric registered by scenarios such as gc_pressure
+			Name:          "high_gc_pause",
+			MetricName:    "gc_pause_ms",
+			Operator:      "gt",
+			Threshold:     200.0,
+			WindowSeconds: 30,
+			Severity:      commonv1.IncidentSeverity_INCIDENT_SEVERITY_WARNING,
+		},
+		{
+			Name:          "high_replication_lag",
+			MetricName:    "replication_lag_ms",
+			Operator:      "gt",
+			Threshold:     5000.0,
+			WindowSeconds: 30,
+			Severity:      commonv1.IncidentSeverity_INCIDENT_SEVERITY_WARNING,
+		},
 	}
 }
 
 // ToProto converts a Rule to proto format
 func (r Rule) ToProto() *opsv1.DetectionRule {
 	return &opsv1.DetectionRule{
-		Name:          r.Name,
-		MetricName:    r.MetricName,
-		Operator:      r.Operator,
-		Threshold:     r.Threshold,
-		WindowSeconds: int32(r.WindowSeconds),
-		Severity:      r.Severity,
+		Name:                 r.Name,
+		MetricName:           r.MetricName,
+		Operator:             r.Operator,
+		Threshold:            r.Threshold,
+		WindowSeconds:        int32(r.WindowSeconds),
+		Severity:             r.Severity,
+		Region:               r.Region,
+		Type:                 r.Type,
+		TriggerRatio:         r.TriggerRatio,
+		ClearRatio:           r.ClearRatio,
+		ClearSeconds:         int32(r.ClearSeconds),
+		Selector:             r.Selector,
+		Overrides:            overridesToProto(r.Overrides),
+		MinSamples:           int32(r.MinSamples),
+		EvaluateEverySeconds: int32(r.EvaluateEverySeconds),
+	}
+}
+
+// ratios returns the rule's trigger and clear ratios, defaults filled in
+func (r Rule) ratios() (triggerRatio, clearRatio float64) {
+	triggerRatio, clearRatio = r.TriggerRatio, r.ClearRatio
+	if triggerRatio == 0 {
+		triggerRatio = DefaultTriggerRatio
+	}
+	if clearRatio == 0 {
+		clearRatio = DefaultClearRatio
+	}
+	return triggerRatio, clearRatio
+}
+
+// minSamples returns the rule's minimum sample count, default filled in
+func (r Rule) minSamples() int {
+	if r.MinSamples == 0 {
+		return DefaultMinSamples
 	}
+	return r.MinSamples
 }
 
+// kind returns the rule's type, with empty as threshold
+func (r Rule) kind() string {
+	if r.Type == "" {
+		return RuleTypeThreshold
+	}
+	return r.Type
+}
+
+// RuleConfig flattens rules into settings for the configuration dump,
+// keyed <rule>.<setting>, with defaults filled in
+func RuleConfig(rules []Rule) map[string]string {
+	config := make(map[string]string, len(rules)*10)
+	for _, r := range rules {
+		triggerRatio, clearRatio := r.ratios()
+		for key, value := range map[string]string{
+			"metric":                 r.MetricName,
+			"operator":               r.Operator,
+			"threshold":              strconv.FormatFloat(r.Threshold, 'g', -1, 64),
+			"window_seconds":         strconv.Itoa(r.WindowSeconds),
+			"severity":               r.Severity.String(),
+			"region":                 r.Region,
+			"type":                   r.kind(),
+			"trigger_ratio":          strconv.FormatFloat(triggerRatio, 'g', -1, 64),
+			"clear_ratio":            strconv.FormatFloat(clearRatio, 'g', -1, 64),
+			"clear_seconds":          strconv.Itoa(r.ClearSeconds),
+			"min_samples":            strconv.Itoa(r.minSamples()),
+			"evaluate_every_seconds": strconv.Itoa(r.EvaluateEverySeconds),
+		} {
+			config[r.Name+"."+key] = value
+		}
+		if len(r.Selector) > 0 {
+			config[r.Name+".selector"] = formatSelector(r.Selector)
+		}
+		for _, o := range r.Overrides {
+			config[r.Name+".override."+formatSelector(o.Selector)] = strconv.FormatFloat(o.Threshold, 'g', -1, 64)
+		}
+	}
+	return config
+}
+
+// AppliesTo reports whether the rule covers entities in the given region
+func (r Rule) AppliesTo(region string) bool {
+	return r.Region == "" || r.Region == region
+}
+
+// Selects reports whether the rule covers an entity with the given labels
+func (r Rule) Selects(labels map[string]string) bool {
+	return matchLabels(r.Selector, labels)
+}
+
+// ForLabels returns the rule as it applies to an entity with the given
+// labels: with the threshold of the first override selecting it, if any
+func (r Rule) ForLabels(labels map[string]string) Rule {
+	for _, o := range r.Overrides {
+		if matchLabels(o.Selector, labels) {
+			r.Threshold = o.Threshold
+			break
+		}
+	}
+	return r
+}
+
+// matchLabels reports whether labels include every pair of selector
+func matchLabels(selector, labels map[string]string) bool {
+	for k, v := range selector {
+		if got, ok := labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// formatSelector renders a selector as sorted k=v pairs joined by commas
+func formatSelector(selector map[string]string) string {
+	pairs := make([]string, 0, len(selector))
+	for k, v := range selector {
+		pairs = append(pairs, k+"="+v)
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Operators are the comparisons a rule can use
+var Operators = []string{"gt", "gte", "lt", "lte", "eq"}
+
 // Evaluate checks if a value breaches the rule threshold
 func (r Rule) Evaluate(value float64) bool {
 	switch r.Operator {
@@ -98,3 +301,91 @@ func (r Rule) Evaluate(value float64) bool {
 		return false
 	}
 }
+
+// Validate reports the first problem that would keep the rule from firing
+func (r Rule) Validate() error {
+	triggerRatio, clearRatio := r.ratios()
+	switch {
+	case r.Name == "":
+		return errors.New("rule name is required")
+	case strings.Contains(r.Name, ":"):
+		return fmt.Errorf("rule name %q must not contain ':'", r.Name)
+	case r.MetricName == "":
+		return errors.New("metric name is required")
+	case r.Type != "" && !slices.Contains(RuleTypes, r.Type):
+		return fmt.Errorf("type must be one of %s, got %q", strings.Join(RuleTypes, ", "), r.Type)
+	case !slices.Contains(Operators, r.Operator):
+		return fmt.Errorf("operator must be one of %s, got %q", strings.Join(Operators, ", "), r.Operator)
+	case r.WindowSeconds <= 0:
+		return fmt.Errorf("window must be positive, got %ds", r.WindowSeconds)
+	case r.TriggerRatio < 0 || r.TriggerRatio >= 1 || r.ClearRatio < 0 || r.ClearRatio >= 1:
+		return fmt.Errorf("trigger and clear ratios must be in [0, 1), got %g and %g", r.TriggerRatio, r.ClearRatio)
+	case clearRatio > triggerRatio:
+		return fmt.Errorf("clear ratio %g must not be above trigger ratio %g", clearRatio, triggerRatio)
+	case r.ClearSeconds < 0:
+		return fmt.Errorf("clear duration must not be negative, got %ds", r.ClearSeconds)
+	case r.MinSamples < 0:
+		return fmt.Errorf("minimum samples must not be negative, got %d", r.MinSamples)
+	case r.EvaluateEverySeconds < 0:
+		return fmt.Errorf("evaluation interval must not be negative, got %ds", r.EvaluateEverySeconds)
+	case r.EvaluateEverySeconds > 0 && r.WindowSeconds < r.minSamples()*r.EvaluateEverySeconds:
+		return fmt.Errorf("window of %ds cannot hold %d samples taken every %ds", r.WindowSeconds, r.minSamples(), r.EvaluateEverySeconds)
+	case r.Severity <= commonv1.IncidentSeverity_INCIDENT_SEVERITY_UNSPECIFIED || r.Severity > commonv1.IncidentSeverity_INCIDENT_SEVERITY_FATAL:
+		return fmt.Errorf("severity must be set to a known level, got %s", r.Severity)
+	}
+	if _, ok := r.Selector[""]; ok {
+		return errors.New("selector label names must not be empty")
+	}
+	for i, o := range r.Overrides {
+		if len(o.Selector) == 0 {
+			return fmt.Errorf("override %d needs a selector; change the rule's threshold instead", i)
+		}
+		if _, ok := o.Selector[""]; ok {
+			return fmt.Errorf("override %d: selector label names must not be empty", i)
+		}
+	}
+	return nil
+}
+
+// RuleFromProto converts a proto DetectionRule to a Rule
+func RuleFromProto(p *opsv1.DetectionRule) Rule {
+	return Rule{
+		Name:                 p.GetName(),
+		MetricName:           p.GetMetricName(),
+		Operator:             p.GetOperator(),
+		Threshold:            p.GetThreshold(),
+		WindowSeconds:        int(p.GetWindowSeconds()),
+		Severity:             p.GetSeverity(),
+		Region:               p.GetRegion(),
+		Type:                 p.GetType(),
+		TriggerRatio:         p.GetTriggerRatio(),
+		ClearRatio:           p.GetClearRatio(),
+		ClearSeconds:         int(p.GetClearSeconds()),
+		Selector:             p.GetSelector(),
+		Overrides:            overridesFromProto(p.GetOverrides()),
+		MinSamples:           int(p.GetMinSamples()),
+		EvaluateEverySeconds: int(p.GetEvaluateEverySeconds()),
+	}
+}
+
+func overridesToProto(overrides []RuleOverride) []*opsv1.RuleOverride {
+	if len(overrides) == 0 {
+		return nil
+	}
+	out := make([]*opsv1.RuleOverride, 0, len(overrides))
+	for _, o := range overrides {
+		out = append(out, &opsv1.RuleOverride{Selector: o.Selector, Threshold: o.Threshold})
+	}
+	return out
+}
+
+func overridesFromProto(overrides []*opsv1.RuleOverride) []RuleOverride {
+	if len(overrides) == 0 {
+		return nil
+	}
+	out := make([]RuleOverride, 0, len(overrides))
+	for _, o := range overrides {
+		out = append(out, RuleOverride{Selector: o.GetSelector(), Threshold: o.GetThreshold()})
+	}
+	return out
+}
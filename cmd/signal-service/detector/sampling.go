@@ -0,0 +1,81 @@
+package detector
+
+import (
+	"math"
+
+	"github.com/microcloud/storage"
+)
+
+// Sampling defaults
+const (
+	DefaultDeadband    = 0.5
+	DefaultMaxGapTicks = 30
+)
+
+// SamplingConfig controls which metric rows are persisted. A row is stored
+// when its value moved more than Deadband since the last stored value for
+// that series, or when MaxGapTicks have passed without storing one.
+// Rule evaluation always sees every value; only persistence is sampled.
+type SamplingConfig struct {
+	Enabled     bool
+	Deadband    float64
+	MaxGapTicks int64
+}
+
+// DefaultSamplingConfig returns sampling enabled with the default thresholds
+func DefaultSamplingConfig() SamplingConfig {
+	return SamplingConfig{
+		Enabled:     true,
+		Deadband:    DefaultDeadband,
+		MaxGapTicks: DefaultMaxGapTicks,
+	}
+}
+
+// sample is the last stored point of a series
+type sample struct {
+	value  float64
+	tickID int64
+}
+
+// sampler filters metric rows by deadband and maximum gap
+type sampler struct {
+	cfg  SamplingConfig
+	last map[string]sample
+}
+
+func newSampler(cfg SamplingConfig) *sampler {
+	return &sampler{cfg: cfg, last: make(map[string]sample)}
+}
+
+// filter returns the rows worth storing and remembers them. It is not safe
+// for concurrent use.
+func (s *sampler) filter(rows []storage.MetricRow) []storage.MetricRow {
+	if !s.cfg.Enabled {
+		return rows
+	}
+
+	kept := rows[:0]
+	for _, row := range rows {
+		key := seriesKey(row)
+		prev, seen := s.last[key]
+		if seen &&
+			math.Abs(row.MetricValue-prev.value) <= s.cfg.Deadband &&
+			row.TickID-prev.tickID < s.cfg.MaxGapTicks {
+			continue
+		}
+		s.last[key] = sample{value: row.MetricValue, tickID: row.TickID}
+		kept = append(kept, row)
+	}
+	return kept
+}
+
+func seriesKey(row storage.MetricRow) string {
+	switch {
+	case row.NodeID != nil:
+		return "node:" + *row.NodeID + ":" + row.MetricName
+	case row.ServiceID != nil:
+		return "service:" + *row.ServiceID + ":" + row.MetricName
+	default:
+		return row.MetricName
+	}
+}
@@ -0,0 +1,111 @@
+package detector
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+)
+
+// shardVirtualNodes is how many points each shard has on the hash ring.
+// More points spread entities more evenly between shards.
+const shardVirtualNodes = 64
+
+// Shard is the part of the topology one signal-service replica detects on.
+// Entities are assigned to shards by consistent hashing of their IDs, so
+// changing the shard count moves about 1/Count of them. Every shard still
+// reads every snapshot and tracks placement and labels for all entities.
+type Shard struct {
+	Index int // from 0
+	Count int // 1 detects on every entity
+
+	ring []ringPoint // sorted by hash
+}
+
+type ringPoint struct {
+	hash  uint64
+	shard int
+}
+
+// NewShard returns shard index of count. It fails unless 0 <= index < count.
+func NewShard(index, count int) (Shard, error) {
+	if count < 1 || index < 0 || index >= count {
+		return Shard{}, fmt.Errorf("shard %d of %d: index must be in [0, count)", index, count)
+	}
+	s := Shard{Index: index, Count: count}
+	if count == 1 {
+		return s, nil
+	}
+	s.ring = make([]ringPoint, 0, count*shardVirtualNodes)
+	for shard := range count {
+		for v := range shardVirtualNodes {
+			s.ring = append(s.ring, ringPoint{hash: hashKey("shard-" + strconv.Itoa(shard) + "-" + strconv.Itoa(v)), shard: shard})
+		}
+	}
+	slices.SortFunc(s.ring, func(a, b ringPoint) int {
+		switch {
+		case a.hash < b.hash:
+			return -1
+		case a.hash > b.hash:
+			return 1
+		}
+		return a.shard - b.shard
+	})
+	return s, nil
+}
+
+// ShardOf returns the shard an entity belongs to
+func (s Shard) ShardOf(entityID string) int {
+	if len(s.ring) == 0 {
+		return 0
+	}
+	h := hashKey(entityID)
+	i, _ := slices.BinarySearchFunc(s.ring, h, func(p ringPoint, h uint64) int {
+		switch {
+		case p.hash < h:
+			return -1
+		case p.hash > h:
+			return 1
+		}
+		return 0
+	})
+	if i == len(s.ring) {
+		i = 0
+	}
+	return s.ring[i].shard
+}
+
+// Owns reports whether an entity belongs to this shard
+func (s Shard) Owns(entityID string) bool {
+	return s.ShardOf(entityID) == s.Index
+}
+
+// Config returns the shard settings for heartbeats
+func (s Shard) Config() map[string]string {
+	return map[string]string{
+		"index": strconv.Itoa(s.Index),
+		"count": strconv.Itoa(s.Count),
+	}
+}
+
+// hashKey hashes key with FNV-1a, then mixes the bits with the SplitMix64
+// finalizer since FNV alone clusters keys that differ only at the end
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// WithShard detects only on the nodes, services and regions of shard.
+// Incidents can only be correlated within a shard.
+func WithShard(shard Shard) Option {
+	return func(d *Detector) {
+		d.shard = shard
+	}
+}
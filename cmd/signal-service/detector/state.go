@@ -0,0 +1,166 @@
+package detector
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/microcloud/bus"
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+)
+
+// WithStateStore saves the detector's windows and active incidents to
+// store under key with SaveState, and LoadState restores them, so a restart
+// neither forgets breaches in progress nor raises active incidents again
+func WithStateStore(store *bus.Store, key string) Option {
+	return func(d *Detector) {
+		d.stateStore = store
+		d.stateKey = key
+	}
+}
+
+// State returns a copy of the rule windows, active incidents and their
+// correlated parents
+func (d *Detector) State() *opsv1.DetectorState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state := &opsv1.DetectorState{
+		Windows: make([]*opsv1.DetectorWindow, 0, len(d.windows)),
+		Active:  make([]*opsv1.DetectorActiveIncident, 0, len(d.activeIncidents)),
+	}
+	for key, w := range d.windows {
+		window := &opsv1.DetectorWindow{
+			Key:              key,
+			Values:           append([]float64(nil), w.values...),
+			TimestampsUnixMs: make([]int64, 0, len(w.timestamps)),
+		}
+		for _, ts := range w.timestamps {
+			window.TimestampsUnixMs = append(window.TimestampsUnixMs, ts.UnixMilli())
+		}
+		state.Windows = append(state.Windows, window)
+	}
+	for key, incident := range d.activeIncidents {
+		active := &opsv1.DetectorActiveIncident{
+			Key:      key,
+			Incident: proto.Clone(incident).(*opsv1.Incident),
+		}
+		if since, ok := d.clearing[key]; ok {
+			active.ClearingSinceUnixMs = since.UnixMilli()
+		}
+		state.Active = append(state.Active, active)
+	}
+	for _, parent := range d.parents {
+		state.Parents = append(state.Parents, proto.Clone(parent.incident).(*opsv1.Incident))
+	}
+	return state
+}
+
+// RestoreState loads windows and active incidents saved by State, for
+// entities the detector holds none for. Windows of rules that no longer
+// exist are skipped; values past a rule's window age out with the next
+// snapshot. Parents are restored with the children that were restored.
+func (d *Detector) RestoreState(state *opsv1.DetectorState) {
+	rules := make(map[string]bool)
+	for _, r := range *d.rules.Load() {
+		rules[r.Name] = true
+	}
+	ruleOf := func(key string) string {
+		return key[strings.LastIndex(key, ":")+1:]
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, w := range state.Windows {
+		if _, ok := d.windows[w.Key]; ok || !rules[ruleOf(w.Key)] || len(w.Values) != len(w.TimestampsUnixMs) {
+			continue
+		}
+		window := &metricWindow{
+			values:     append(make([]float64, 0, 100), w.Values...),
+			timestamps: make([]time.Time, 0, 100),
+		}
+		for _, ms := range w.TimestampsUnixMs {
+			window.timestamps = append(window.timestamps, time.UnixMilli(ms))
+		}
+		d.windows[w.Key] = window
+	}
+	parents := make(map[string]*opsv1.Incident, len(state.Parents))
+	for _, p := range state.Parents {
+		parents[p.Id.GetValue()] = p
+	}
+	for _, a := range state.Active {
+		name := ruleOf(a.Key)
+		if _, ok := d.activeIncidents[a.Key]; ok || a.Incident == nil || (!rules[name] && name != NoDataRuleName) {
+			continue
+		}
+		d.activeIncidents[a.Key] = a.Incident
+		if a.ClearingSinceUnixMs > 0 {
+			d.clearing[a.Key] = time.UnixMilli(a.ClearingSinceUnixMs)
+		}
+
+		incident, ok := parents[a.Incident.ParentId.GetValue()]
+		if !ok {
+			continue
+		}
+		parent, ok := d.parents[incident.Id.Value]
+		if !ok {
+			parent = &parentIncident{incident: incident, open: make(map[string]bool), scope: make(map[string]bool)}
+			d.parents[incident.Id.Value] = parent
+		}
+		parent.open[a.Incident.Id.GetValue()] = true
+		entityType, _, _ := strings.Cut(a.Key, ":")
+		for _, key := range d.correlationKeys(pendingIncident{incident: a.Incident, entityType: entityType}) {
+			parent.scope[key] = true
+		}
+	}
+}
+
+// SaveState saves State to the state store, if configured
+func (d *Detector) SaveState(ctx context.Context) error {
+	if d.stateStore == nil {
+		return nil
+	}
+	state := d.State()
+	state.SavedAtUnixMs = time.Now().UnixMilli()
+	return d.stateStore.Put(ctx, d.stateKey, state)
+}
+
+// LoadState restores the state last saved to the state store, if
+// configured, and returns how many windows and active incidents it held
+func (d *Detector) LoadState(ctx context.Context) (windows, active int, err error) {
+	if d.stateStore == nil {
+		return 0, 0, nil
+	}
+	state := &opsv1.DetectorState{}
+	found, err := d.stateStore.Get(ctx, d.stateKey, state)
+	if err != nil || !found {
+		return 0, 0, err
+	}
+	d.RestoreState(state)
+	return len(state.Windows), len(state.Active), nil
+}
+
+// RunStateSaves saves the state every interval until ctx is done, and once
+// more on the way out so a clean shutdown loses nothing
+func (d *Detector) RunStateSaves(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+			defer cancel()
+			if err := d.SaveState(saveCtx); err != nil {
+				d.log.Warn("failed to save detector state", "error", err)
+			}
+			return nil
+		case <-ticker.C:
+			if err := d.SaveState(ctx); err != nil {
+				d.log.Warn("failed to save detector state", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package detector
+
+// RuleStats counts how a rule fared since the counts were last taken
+type RuleStats struct {
+	Evaluations int64 // values checked against the rule
+	Breaches    int64 // values that breached it
+	Fired       int64 // incidents raised, not counting repeats of active ones
+}
+
+// TakeStats returns the counts of every rule since the last call and
+// starts counting afresh. Anomaly and missing-data incidents are counted
+// under AnomalyRuleName and NoDataRuleName, as fired only.
+func (d *Detector) TakeStats() map[string]RuleStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	taken := d.stats
+	d.stats = make(map[string]RuleStats)
+	return taken
+}
+
+// RestoreStats adds counts taken earlier back, for when they could not be
+// stored
+func (d *Detector) RestoreStats(stats map[string]RuleStats) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for name, s := range stats {
+		cur := d.stats[name]
+		cur.Evaluations += s.Evaluations
+		cur.Breaches += s.Breaches
+		cur.Fired += s.Fired
+		d.stats[name] = cur
+	}
+}
+
+// countEvaluation counts a value checked against a rule. Caller must hold
+// mu.
+func (d *Detector) countEvaluation(ruleName string, breached bool) {
+	s := d.stats[ruleName]
+	s.Evaluations++
+	if breached {
+		s.Breaches++
+	}
+	d.stats[ruleName] = s
+}
+
+// countFired counts an incident raised for a rule. Caller must hold mu.
+func (d *Detector) countFired(ruleName string) {
+	s := d.stats[ruleName]
+	s.Fired++
+	d.stats[ruleName] = s
+}
@@ -0,0 +1,137 @@
+package detector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/microcloud/bus"
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+	"github.com/microcloud/id"
+	"github.com/microcloud/messages"
+)
+
+// StormRuleName is the rule name of incidents raised by the storm detector
+const StormRuleName = "incident_storm"
+
+// StormConfig controls the incident storm meta-detector
+type StormConfig struct {
+	Window    time.Duration // sliding window incidents are counted over
+	Threshold int           // incidents within Window that make a storm
+	Cooldown  time.Duration // minimum time between storm incidents
+}
+
+// DefaultStormConfig returns the default storm detection config
+func DefaultStormConfig() StormConfig {
+	return StormConfig{
+		Window:    time.Minute,
+		Threshold: 20,
+		Cooldown:  5 * time.Minute,
+	}
+}
+
+// StormDetector watches the incident stream and raises an incident_storm
+// incident when incidents are created faster than the configured rate
+type StormDetector struct {
+	publisher *bus.Publisher
+	cfg       StormConfig
+	log       *slog.Logger
+
+	mu         sync.Mutex
+	recent     []stormSample
+	lastRaised time.Time
+	now        func() time.Time
+}
+
+type stormSample struct {
+	at       time.Time
+	affected []string
+}
+
+// NewStormDetector creates a storm detector
+func NewStormDetector(publisher *bus.Publisher, cfg StormConfig, log *slog.Logger) *StormDetector {
+	return &StormDetector{
+		publisher: publisher,
+		cfg:       cfg,
+		log:       log,
+		now:       time.Now,
+	}
+}
+
+// ProcessIncident counts an incident and raises a storm incident if the
+// rate crossed the threshold. Storm incidents themselves are not counted,
+// nor correlated children, which their parent stands for, nor escalations
+// of incidents already counted.
+func (s *StormDetector) ProcessIncident(ctx context.Context, incident *opsv1.Incident) error {
+	if incident.RuleName == StormRuleName || incident.Resolved || incident.ParentId != nil || incident.EscalatedAt != nil {
+		return nil
+	}
+
+	storm := s.observe(incident.AffectedIds)
+	if storm == nil {
+		return nil
+	}
+	if err := s.publisher.PublishIncident(ctx, storm); err != nil {
+		return fmt.Errorf("publish storm incident: %w", err)
+	}
+	s.log.Warn("incident storm detected", "incidents", storm.Metrics["incident_count"], "entities", len(storm.AffectedIds), "window", s.cfg.Window)
+	return nil
+}
+
+// observe records an incident and returns the storm incident to raise, if any
+func (s *StormDetector) observe(affected []string) *opsv1.Incident {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	cutoff := now.Add(-s.cfg.Window)
+	kept := s.recent[:0]
+	for _, r := range s.recent {
+		if r.at.After(cutoff) {
+			kept = append(kept, r)
+		}
+	}
+	s.recent = append(kept, stormSample{at: now, affected: affected})
+
+	if len(s.recent) < s.cfg.Threshold || now.Sub(s.lastRaised) < s.cfg.Cooldown {
+		return nil
+	}
+	s.lastRaised = now
+
+	seen := make(map[string]bool)
+	var entities []string
+	for _, r := range s.recent {
+		for _, id := range r.affected {
+			if !seen[id] {
+				seen[id] = true
+				entities = append(entities, id)
+			}
+		}
+	}
+
+	count := fmt.Sprintf("%d", len(s.recent))
+	window := s.cfg.Window.String()
+	title := messages.New(messages.IncidentStormTitle, "count", count, "window", window)
+	description := messages.New(messages.IncidentStormDescription,
+		"count", count,
+		"window", window,
+		"threshold", fmt.Sprintf("%d", s.cfg.Threshold),
+		"entities", fmt.Sprintf("%d", len(entities)),
+	)
+	return &opsv1.Incident{
+		Id:                 &commonv1.UUID{Value: id.NewV7()},
+		DetectedAt:         &commonv1.SimulationTimestamp{WallTimeUnixMs: now.UnixMilli()},
+		Severity:           commonv1.IncidentSeverity_INCIDENT_SEVERITY_CRITICAL,
+		Title:              title.String(),
+		Description:        description.String(),
+		SourceService:      "signal-service",
+		AffectedIds:        entities,
+		RuleName:           StormRuleName,
+		Metrics:            map[string]float64{"incident_count": float64(len(s.recent))},
+		TitleMessage:       &commonv1.LocalizedMessage{Key: title.Key, Args: title.Args},
+		DescriptionMessage: &commonv1.LocalizedMessage{Key: description.Key, Args: description.Args},
+	}
+}
@@ -0,0 +1,58 @@
+package detector
+
+import (
+	"time"
+)
+
+// Suppression is a maintenance window during which incidents matching its
+// entity and rule are not published, so planned chaos experiments do not
+// page the decider
+type Suppression struct {
+	ID       string
+	EntityID string // empty matches every entity
+	RuleName string // empty matches every rule, including anomaly detection
+	Start    time.Time
+	End      time.Time
+	Reason   string
+}
+
+// Matches reports whether the window covers an incident of ruleName on
+// entityID at the given time
+func (s Suppression) Matches(ruleName, entityID string, at time.Time) bool {
+	if at.Before(s.Start) || !at.Before(s.End) {
+		return false
+	}
+	return (s.EntityID == "" || s.EntityID == entityID) && (s.RuleName == "" || s.RuleName == ruleName)
+}
+
+// Suppressions returns the suppression windows being honoured
+func (d *Detector) Suppressions() []Suppression {
+	if s := d.suppressions.Load(); s != nil {
+		return append([]Suppression(nil), *s...)
+	}
+	return nil
+}
+
+// SetSuppressions replaces the suppression windows while the detector runs.
+// Incidents already published stay active and clear as usual.
+func (d *Detector) SetSuppressions(windows []Suppression) {
+	next := append([]Suppression(nil), windows...)
+	d.suppressions.Store(&next)
+}
+
+// suppressed reports whether an incident of ruleName on entityID raised now
+// falls in a suppression window. Such incidents are not tracked as active
+// either, so a breach that outlasts the window is raised once it ends.
+func (d *Detector) suppressed(ruleName, entityID string, now time.Time) bool {
+	windows := d.suppressions.Load()
+	if windows == nil {
+		return false
+	}
+	for _, s := range *windows {
+		if s.Matches(ruleName, entityID, now) {
+			d.log.Debug("incident suppressed", "rule", ruleName, "entity", shortID(entityID), "window", s.ID, "reason", s.Reason)
+			return true
+		}
+	}
+	return false
+}
@@ -2,39 +2,67 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
+	"strconv"
+	"strings"
+	"time"
 
-	"golang.org/x/sync/errgroup"
+	"connectrpc.com/connect"
 
+	"github.com/microcloud/app"
 	"github.com/microcloud/bus"
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+	"github.com/microcloud/gen/go/ops/v1/opsv1connect"
 	simv1 "github.com/microcloud/gen/go/sim/v1"
-	"github.com/microcloud/logger"
+	"github.com/microcloud/gen/go/sim/v1/simv1connect"
 	"github.com/microcloud/signal-service/detector"
+	"github.com/microcloud/signal-service/server"
 	"github.com/microcloud/storage"
 )
 
-func main() {
-	log := logger.NewFromEnv("signal-service")
+// The shard lease bucket holds one lease per metrics consumer. A replica
+// that dies without releasing its lease blocks its shard for shardLeaseTTL.
+const (
+	shardLeaseBucket = "signal-shards"
+	shardLeaseTTL    = 10 * time.Second
+)
 
-	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer cancel()
+func main() {
+	app.Main("signal-service", build)
+}
 
-	if err := run(ctx, log); err != nil && err != context.Canceled {
-		log.Error("fatal error", "error", err)
-		os.Exit(1)
-	}
+// configEnv are the environment variables the service reports in its
+// configuration, besides the shared ones. Credentials are left out.
+var configEnv = []string{
+	"ANOMALY_DETECTION", "ANOMALY_METRICS", "ANOMALY_MIN_SAMPLES",
+	"ANOMALY_SIGMA", "ANOMALY_WINDOW", "BASELINE_ALPHA", "BASELINE_HISTORY",
+	"BASELINE_RELEARN_INTERVAL", "BASELINE_SLOT",
+	"CORRELATION_MIN_ENTITIES", "DETECTOR_STATE_INTERVAL", "ESCALATE_AFTER",
+	"INCIDENT_CORRELATION", "INSTANCE_ID", "METRIC_DEADBAND",
+	"METRIC_MAX_GAP_TICKS", "METRIC_SAMPLING", "NO_DATA_AFTER",
+	"RULES_RELOAD_INTERVAL", "RULE_STATS_FLUSH_INTERVAL", "SHARD_COUNT",
+	"SHARD_INDEX", "SIM_ENGINE_URL", "STORM_COOLDOWN", "STORM_THRESHOLD",
+	"STORM_WINDOW", "TOPOLOGY_REFRESH_INTERVAL",
 }
 
-func run(ctx context.Context, log *slog.Logger) error {
+func build(ctx context.Context, a *app.App) error {
+	log := a.Log()
+	a.ConfigEnv(configEnv...)
+
 	dbCfg := storage.ConfigFromEnv()
 	db, err := storage.New(ctx, dbCfg)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
+	a.OnStop("database", func(context.Context) error {
+		db.Close()
+		return nil
+	})
+	a.HealthCheck("database", db.Ping)
 
 	log.Info("connected to database", "host", dbCfg.Host)
 
@@ -42,23 +70,20 @@ func run(ctx context.Context, log *slog.Logger) error {
 		log.Warn("migration error (may be expected if tables exist)", "error", err)
 	}
 
-	busCfg := bus.DefaultConfig()
-	if url := os.Getenv("NATS_URL"); url != "" {
-		busCfg.URL = url
-	}
-
+	busCfg := bus.ConfigFromEnv()
+	info := app.Build()
 	eventBus, err := bus.New(ctx, busCfg,
-		bus.WithDisconnectHandler(func(err error) {
-			log.Warn("NATS disconnected", "error", err)
-		}),
-		bus.WithReconnectHandler(func() {
-			log.Info("NATS reconnected")
-		}),
+		bus.WithLogger(log),
+		bus.WithIdentity(a.Name(), info.Version, info.Commit),
+		bus.WithConfig(a.Config),
 	)
 	if err != nil {
 		return err
 	}
-	defer eventBus.Close()
+	a.OnStop("nats", func(context.Context) error {
+		return eventBus.Close()
+	})
+	a.HealthCheck("nats", eventBus.Check)
 
 	log.Info("connected to NATS", "url", busCfg.URL)
 
@@ -66,23 +91,384 @@ func run(ctx context.Context, log *slog.Logger) error {
 	subscriber := bus.NewSubscriber(eventBus)
 	metricsRepo := storage.NewMetricsRepository(db)
 
-	det := detector.New(publisher, metricsRepo, log)
+	sampling := detector.DefaultSamplingConfig()
+	if os.Getenv("METRIC_SAMPLING") == "false" {
+		sampling.Enabled = false
+	}
+	if v := os.Getenv("METRIC_DEADBAND"); v != "" {
+		if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+			sampling.Deadband = parsed
+		}
+	}
+	if v := os.Getenv("METRIC_MAX_GAP_TICKS"); v != "" {
+		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil {
+			sampling.MaxGapTicks = parsed
+		}
+	}
+	log.Info("metric sampling", "enabled", sampling.Enabled, "deadband", sampling.Deadband, "max_gap_ticks", sampling.MaxGapTicks)
 
-	g, ctx := errgroup.WithContext(ctx)
+	anomaly := detector.DefaultAnomalyConfig()
+	if os.Getenv("ANOMALY_DETECTION") == "true" {
+		anomaly.Enabled = true
+	}
+	if v := os.Getenv("ANOMALY_SIGMA"); v != "" {
+		if parsed, err := strconv.ParseFloat(v, 64); err == nil && parsed > 0 {
+			anomaly.Sigma = parsed
+		}
+	}
+	if v := os.Getenv("ANOMALY_WINDOW"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 1 {
+			anomaly.Window = parsed
+		}
+	}
+	if v := os.Getenv("ANOMALY_MIN_SAMPLES"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 1 {
+			anomaly.MinSamples = parsed
+		}
+	}
+	if v := os.Getenv("ANOMALY_METRICS"); v != "" {
+		anomaly.Metrics = strings.Split(v, ",")
+	}
+	// The window must hold enough values to judge a series at all
+	anomaly.MinSamples = min(anomaly.MinSamples, anomaly.Window)
+	log.Info("anomaly detection", "enabled", anomaly.Enabled, "sigma", anomaly.Sigma, "window", anomaly.Window, "min_samples", anomaly.MinSamples, "metrics", anomaly.Metrics)
 
-	g.Go(func() error {
-		log.Info("subscribing to metrics")
-		cc, err := subscriber.SubscribeMetrics(ctx, "signal-service", func(ctx context.Context, snapshot *simv1.MetricSnapshot) error {
+	baseline := detector.DefaultBaselineConfig()
+	if v := os.Getenv("BASELINE_SLOT"); v != "" {
+		if parsed, err := time.ParseDuration(v); err == nil && parsed > 0 && parsed <= 24*time.Hour {
+			baseline.Slot = parsed
+		}
+	}
+	if v := os.Getenv("BASELINE_HISTORY"); v != "" {
+		if parsed, err := time.ParseDuration(v); err == nil && parsed > 0 {
+			baseline.History = parsed
+		}
+	}
+	if v := os.Getenv("BASELINE_ALPHA"); v != "" {
+		if parsed, err := strconv.ParseFloat(v, 64); err == nil && parsed > 0 && parsed <= 1 {
+			baseline.Alpha = parsed
+		}
+	}
+	log.Info("seasonal baselines", "slot", baseline.Slot, "history", baseline.History, "alpha", baseline.Alpha)
+
+	correlation := detector.DefaultCorrelationConfig()
+	if os.Getenv("INCIDENT_CORRELATION") == "false" {
+		correlation.Enabled = false
+	}
+	if v := os.Getenv("CORRELATION_MIN_ENTITIES"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 1 {
+			correlation.MinEntities = parsed
+		}
+	}
+	log.Info("incident correlation", "enabled", correlation.Enabled, "min_entities", correlation.MinEntities)
+
+	escalation := detector.DefaultEscalationConfig()
+	if v := os.Getenv("ESCALATE_AFTER"); v != "" {
+		if parsed, err := time.ParseDuration(v); err == nil && parsed >= 0 {
+			escalation.After = parsed
+		}
+	}
+	log.Info("severity escalation", "after", escalation.After)
+
+	noData := detector.DefaultNoDataConfig()
+	if v := os.Getenv("NO_DATA_AFTER"); v != "" {
+		if parsed, err := time.ParseDuration(v); err == nil && parsed >= 0 {
+			noData.After = parsed
+		}
+	}
+	log.Info("missing data detection", "after", noData.After)
+
+	// Replicas split nodes, services and regions between SHARD_COUNT shards
+	// by consistent hashing, so an entity is only ever detected on by one
+	// of them. Each shard has its own durable consumer and runs as a single
+	// replica: replicas sharing the consumer would split its snapshots
+	// between them, leaving gaps in every window and delta chain.
+	shardCount, shardIndex := 1, 0
+	if v := os.Getenv("SHARD_COUNT"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			shardCount = parsed
+		}
+	}
+	if v := os.Getenv("SHARD_INDEX"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			shardIndex = parsed
+		}
+	}
+	shard, err := detector.NewShard(shardIndex, shardCount)
+	if err != nil {
+		return fmt.Errorf("configure shard: %w", err)
+	}
+	metricsConsumer, stateKey := "signal-service", bus.KeyDetectorState
+	if shard.Count > 1 {
+		metricsConsumer = fmt.Sprintf("signal-service-shard-%d", shard.Index)
+		stateKey = fmt.Sprintf("%s-shard-%d", bus.KeyDetectorState, shard.Index)
+	}
+	log.Info("detection shard", "index", shard.Index, "count", shard.Count, "consumer", metricsConsumer)
+	a.AddConfig("shard", shard.Config)
+
+	// A lease on the shard's consumer keeps a second replica of the shard
+	// from starting; it is held until shutdown
+	instanceID := os.Getenv("INSTANCE_ID")
+	if instanceID == "" {
+		instanceID, _ = os.Hostname()
+	}
+	shardLease, err := eventBus.NewLease(ctx, shardLeaseBucket, metricsConsumer, instanceID, shardLeaseTTL)
+	if err != nil {
+		return fmt.Errorf("open shard lease: %w", err)
+	}
+	acquired, err := shardLease.TryAcquire(ctx)
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		holder, _ := shardLease.Holder(ctx)
+		return fmt.Errorf("shard %d is already run by %q; each shard takes a single replica", shard.Index, holder)
+	}
+	a.OnStop("shard lease", shardLease.Release)
+	a.Go("shard lease", func(ctx context.Context) error {
+		ticker := time.NewTicker(shardLeaseTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+				if err := shardLease.Renew(ctx); err != nil {
+					return fmt.Errorf("shard %d: %w", shard.Index, err)
+				}
+			}
+		}
+	})
+
+	// Windows and active incidents are saved to NATS KV so a restart picks
+	// up breaches in progress
+	stateStore, err := eventBus.NewStore(ctx, bus.BucketDetectorState)
+	if err != nil {
+		return fmt.Errorf("open detector state store: %w", err)
+	}
+	stateSaves := 15 * time.Second
+	if v := os.Getenv("DETECTOR_STATE_INTERVAL"); v != "" {
+		if parsed, err := time.ParseDuration(v); err == nil && parsed > 0 {
+			stateSaves = parsed
+		}
+	}
+
+	incidentsRepo := storage.NewIncidentsRepository(db)
+	det := detector.New(publisher, metricsRepo, log,
+		detector.WithSampling(sampling),
+		detector.WithAnomaly(anomaly),
+		detector.WithBaseline(baseline),
+		detector.WithIncidents(incidentsRepo),
+		detector.WithCorrelation(correlation),
+		detector.WithEscalation(escalation),
+		detector.WithNoData(noData),
+		detector.WithStateStore(stateStore, stateKey),
+		detector.WithShard(shard),
+	)
+
+	// Rules live in the database so they can be changed at runtime; the
+	// defaults are stored on first start and the detector keeps the
+	// enabled ones cached
+	ruleServer := server.NewRuleServer(storage.NewRulesRepository(db), incidentsRepo, det, publisher, log)
+	if err := ruleServer.Seed(ctx, detector.DefaultRules()); err != nil {
+		return fmt.Errorf("seed detection rules: %w", err)
+	}
+	if err := ruleServer.Reload(ctx); err != nil {
+		return fmt.Errorf("load detection rules: %w", err)
+	}
+	log.Info("detection rules loaded", "count", len(det.Rules()))
+	// After the rules, so state of rules removed meanwhile is dropped
+	if windows, active, err := det.LoadState(ctx); err != nil {
+		log.Warn("failed to load detector state", "error", err)
+	} else {
+		log.Info("detector state restored", "windows", windows, "active_incidents", active)
+	}
+	a.AddConfig("rule", func() map[string]string {
+		return detector.RuleConfig(det.Rules())
+	})
+
+	// Suppression windows keep planned experiments from paging the decider
+	suppressionServer := server.NewSuppressionServer(storage.NewSuppressionsRepository(db), det, publisher, log)
+	if err := suppressionServer.Reload(ctx); err != nil {
+		return fmt.Errorf("load suppression windows: %w", err)
+	}
+	log.Info("suppression windows loaded", "count", len(det.Suppressions()))
+
+	// Changes reach the other replicas on rules.updated; the periodic reload
+	// catches notices missed while disconnected
+	var rulesReload time.Duration
+	if v := os.Getenv("RULES_RELOAD_INTERVAL"); v != "" {
+		if parsed, err := time.ParseDuration(v); err == nil && parsed > 0 {
+			rulesReload = parsed
+		}
+	}
+
+	storm := detector.DefaultStormConfig()
+	if v := os.Getenv("STORM_WINDOW"); v != "" {
+		if parsed, err := time.ParseDuration(v); err == nil && parsed > 0 {
+			storm.Window = parsed
+		}
+	}
+	if v := os.Getenv("STORM_THRESHOLD"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			storm.Threshold = parsed
+		}
+	}
+	if v := os.Getenv("STORM_COOLDOWN"); v != "" {
+		if parsed, err := time.ParseDuration(v); err == nil {
+			storm.Cooldown = parsed
+		}
+	}
+	log.Info("incident storm detection", "window", storm.Window, "threshold", storm.Threshold, "cooldown", storm.Cooldown)
+	stormDet := detector.NewStormDetector(publisher, storm, log)
+
+	logging := loggingInterceptor(log)
+	path, handler := opsv1connect.NewRuleServiceHandler(ruleServer,
+		connect.WithInterceptors(logging),
+	)
+	a.Handle(a.Mux(), path, handler, app.InterceptorNames(logging)...)
+	path, handler = opsv1connect.NewSuppressionServiceHandler(suppressionServer,
+		connect.WithInterceptors(logging),
+	)
+	a.Handle(a.Mux(), path, handler, app.InterceptorNames(logging)...)
+	a.Serve(getEnv("ADDR", ":8082"), nil)
+
+	a.Consume(metricsConsumer, func(ctx context.Context) (app.Stopper, error) {
+		return subscriber.SubscribeMetrics(ctx, metricsConsumer, func(ctx context.Context, snapshot *simv1.MetricSnapshot) error {
 			return det.ProcessSnapshot(ctx, snapshot)
 		})
-		if err != nil {
-			return err
+	})
+	// Storms are counted over the incidents of every shard, so only the
+	// first shard watches for them
+	if shard.Index == 0 {
+		a.Consume("signal-service-storm", func(ctx context.Context) (app.Stopper, error) {
+			return subscriber.SubscribeIncidents(ctx, "signal-service-storm", func(ctx context.Context, incident *opsv1.Incident) error {
+				return stormDet.ProcessIncident(ctx, incident)
+			})
+		})
+	}
+
+	a.Consume(bus.SubjectRulesUpdated, func(ctx context.Context) (app.Stopper, error) {
+		return subscriber.SubscribeRulesUpdated(ctx, func(ctx context.Context, msg *opsv1.RulesUpdated) error {
+			return errors.Join(ruleServer.HandleRulesUpdated(ctx, msg), suppressionServer.HandleRulesUpdated(ctx, msg))
+		})
+	})
+	// Baselines follow live values between relearns, which pick up history
+	// written by the other replicas
+	if v := os.Getenv("BASELINE_RELEARN_INTERVAL"); v != "" {
+		if interval, err := time.ParseDuration(v); err == nil && interval > 0 {
+			a.Go("baseline-relearn", func(ctx context.Context) error {
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case <-ticker.C:
+						if _, err := det.RelearnBaselines(ctx); err != nil {
+							log.Warn("failed to relearn baselines", "error", err)
+						}
+					}
+				}
+			})
+		}
+	}
+	// Correlation follows dependency chains from the sim-engine's topology;
+	// placement comes with every snapshot
+	if correlation.Enabled {
+		sim := simv1connect.NewSimulationControlClient(http.DefaultClient, getEnv("SIM_ENGINE_URL", "http://localhost:8080"))
+		topologyRefresh := time.Minute
+		if v := os.Getenv("TOPOLOGY_REFRESH_INTERVAL"); v != "" {
+			if parsed, err := time.ParseDuration(v); err == nil && parsed > 0 {
+				topologyRefresh = parsed
+			}
+		}
+		a.Go("topology-refresh", func(ctx context.Context) error {
+			ticker := time.NewTicker(topologyRefresh)
+			defer ticker.Stop()
+			for {
+				if err := refreshDependencies(ctx, sim, det); err != nil {
+					log.Warn("failed to refresh service dependencies", "error", err)
+				}
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-ticker.C:
+				}
+			}
+		})
+	}
+	if rulesReload > 0 {
+		a.Go("rules-reload", func(ctx context.Context) error {
+			ticker := time.NewTicker(rulesReload)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-ticker.C:
+					if err := ruleServer.Reload(ctx); err != nil {
+						log.Warn("failed to reload detection rules", "error", err)
+					}
+					if err := suppressionServer.Reload(ctx); err != nil {
+						log.Warn("failed to reload suppression windows", "error", err)
+					}
+				}
+			}
+		})
+	}
+
+	// Rule counters are kept in memory and added to the stored totals
+	// periodically, so replicas do not write on every snapshot
+	statsFlush := 30 * time.Second
+	if v := os.Getenv("RULE_STATS_FLUSH_INTERVAL"); v != "" {
+		if parsed, err := time.ParseDuration(v); err == nil && parsed > 0 {
+			statsFlush = parsed
 		}
-		defer cc.Stop()
+	}
+	a.Go("rule-stats-flush", func(ctx context.Context) error {
+		return ruleServer.RunStatsFlush(ctx, statsFlush)
+	})
 
-		<-ctx.Done()
-		return ctx.Err()
+	a.Go("detector-state", func(ctx context.Context) error {
+		return det.RunStateSaves(ctx, stateSaves)
 	})
+	a.Go("heartbeat", publisher.RunHeartbeats)
+	return nil
+}
+
+// refreshDependencies loads the service dependency graph into det
+func refreshDependencies(ctx context.Context, sim simv1connect.SimulationControlClient, det *detector.Detector) error {
+	resp, err := sim.GetTopology(ctx, connect.NewRequest(&simv1.GetTopologyRequest{}))
+	if err != nil {
+		return fmt.Errorf("get topology: %w", err)
+	}
+	deps := make(map[string][]string)
+	for _, e := range resp.Msg.Edges {
+		if e.Kind == "dependency" {
+			deps[e.FromId] = append(deps[e.FromId], e.ToId)
+		}
+	}
+	det.SetDependencies(deps)
+	return nil
+}
 
-	return g.Wait()
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func loggingInterceptor(log *slog.Logger) connect.UnaryInterceptorFunc {
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			log.Debug("rpc call", "procedure", req.Spec().Procedure)
+			resp, err := next(ctx, req)
+			if err != nil {
+				log.Error("rpc error", "procedure", req.Spec().Procedure, "error", err)
+			}
+			return resp, err
+		}
+	}
 }
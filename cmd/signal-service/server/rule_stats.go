@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"connectrpc.com/connect"
+
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+	"github.com/microcloud/storage"
+)
+
+// GetRuleStats returns the stored counters of the requested rules, or of
+// every rule counted
+func (s *RuleServer) GetRuleStats(ctx context.Context, req *connect.Request[opsv1.GetRuleStatsRequest]) (*connect.Response[opsv1.GetRuleStatsResponse], error) {
+	rows, err := s.rulesRepo.ListStats(ctx)
+	if err != nil {
+		return nil, repoError(err)
+	}
+	resp := &opsv1.GetRuleStatsResponse{}
+	for _, row := range rows {
+		if len(req.Msg.RuleNames) > 0 && !slices.Contains(req.Msg.RuleNames, row.RuleName) {
+			continue
+		}
+		resp.Stats = append(resp.Stats, ruleStatsToProto(row))
+	}
+	return connect.NewResponse(resp), nil
+}
+
+// ReportFalsePositive records an operator's report that an incident should
+// not have fired
+func (s *RuleServer) ReportFalsePositive(ctx context.Context, req *connect.Request[opsv1.ReportFalsePositiveRequest]) (*connect.Response[opsv1.ReportFalsePositiveResponse], error) {
+	incidentID := req.Msg.IncidentId.GetValue()
+	if incidentID == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("incident_id is required"))
+	}
+	incident, err := s.incidentsRepo.MarkFalsePositive(ctx, incidentID, req.Msg.Operator, req.Msg.Reason)
+	if err != nil {
+		return nil, repoError(err)
+	}
+	s.log.Info("incident reported as false positive", "incident_id", incidentID, "rule", incident.RuleName,
+		"operator", req.Msg.Operator, "reason", req.Msg.Reason)
+
+	resp := &opsv1.ReportFalsePositiveResponse{}
+	if incident.RuleName == "" {
+		return connect.NewResponse(resp), nil
+	}
+	rows, err := s.rulesRepo.ListStats(ctx)
+	if err != nil {
+		return nil, repoError(err)
+	}
+	for _, row := range rows {
+		if row.RuleName == incident.RuleName {
+			resp.Stats = ruleStatsToProto(row)
+		}
+	}
+	return connect.NewResponse(resp), nil
+}
+
+// FlushStats stores the detector's counts since the last flush. Counts
+// that cannot be stored are kept for the next one.
+func (s *RuleServer) FlushStats(ctx context.Context) error {
+	taken := s.det.TakeStats()
+	if len(taken) == 0 {
+		return nil
+	}
+	rows := make([]storage.RuleStatsRow, 0, len(taken))
+	for name, st := range taken {
+		rows = append(rows, storage.RuleStatsRow{
+			RuleName:       name,
+			Evaluations:    st.Evaluations,
+			Breaches:       st.Breaches,
+			IncidentsFired: st.Fired,
+		})
+	}
+	if err := s.rulesRepo.AddStats(ctx, rows); err != nil {
+		s.det.RestoreStats(taken)
+		return err
+	}
+	return nil
+}
+
+// RunStatsFlush flushes the detector's counts every interval until ctx is
+// done, and once more on the way out
+func (s *RuleServer) RunStatsFlush(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+			defer cancel()
+			if err := s.FlushStats(flushCtx); err != nil {
+				s.log.Warn("failed to store rule stats", "error", err)
+			}
+			return nil
+		case <-ticker.C:
+			if err := s.FlushStats(ctx); err != nil {
+				s.log.Warn("failed to store rule stats", "error", err)
+			}
+		}
+	}
+}
+
+func ruleStatsToProto(row storage.RuleStatsRow) *opsv1.RuleStats {
+	st := &opsv1.RuleStats{
+		RuleName:        row.RuleName,
+		Evaluations:     row.Evaluations,
+		Breaches:        row.Breaches,
+		IncidentsFired:  row.IncidentsFired,
+		FalsePositives:  row.FalsePositives,
+		UpdatedAtUnixMs: row.UpdatedAt.UnixMilli(),
+	}
+	if row.IncidentsFired > 0 {
+		st.FalsePositiveRatio = float64(row.FalsePositives) / float64(row.IncidentsFired)
+	}
+	return st
+}
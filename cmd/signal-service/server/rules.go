@@ -0,0 +1,283 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"connectrpc.com/connect"
+
+	"github.com/microcloud/bus"
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+	"github.com/microcloud/gen/go/ops/v1/opsv1connect"
+	"github.com/microcloud/id"
+	"github.com/microcloud/signal-service/detector"
+	"github.com/microcloud/storage"
+)
+
+// RuleServer implements the RuleService. Changes are written to the
+// database and then loaded into the detector, so they apply from the next
+// snapshot, and announced on rules.updated for the other replicas.
+type RuleServer struct {
+	rulesRepo     *storage.RulesRepository
+	incidentsRepo *storage.IncidentsRepository // for false positive reports
+	det           *detector.Detector
+	publisher     *bus.Publisher
+	instance      string // source of the notices this replica sends
+	log           *slog.Logger
+}
+
+var _ opsv1connect.RuleServiceHandler = (*RuleServer)(nil)
+
+// NewRuleServer creates a new rule server
+func NewRuleServer(rulesRepo *storage.RulesRepository, incidentsRepo *storage.IncidentsRepository, det *detector.Detector, publisher *bus.Publisher, log *slog.Logger) *RuleServer {
+	return &RuleServer{
+		rulesRepo:     rulesRepo,
+		incidentsRepo: incidentsRepo,
+		det:           det,
+		publisher:     publisher,
+		instance:      id.NewV7(),
+		log:           log,
+	}
+}
+
+// Seed stores the given rules, enabled, unless a rule of the same name is
+// already stored
+func (s *RuleServer) Seed(ctx context.Context, rules []detector.Rule) error {
+	rows := make([]storage.RuleRow, 0, len(rules))
+	for _, r := range rules {
+		row := ruleToRow(r)
+		row.Enabled = true
+		rows = append(rows, row)
+	}
+	n, err := s.rulesRepo.Seed(ctx, rows)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		s.log.Info("seeded detection rules", "count", n)
+	}
+	return nil
+}
+
+// Reload loads the enabled rules from the database into the detector.
+// Stored rules that no longer validate are skipped. Baselines of metrics
+// that baseline rules newly watch are learned from history; failing that,
+// they are learned from live values.
+func (s *RuleServer) Reload(ctx context.Context) error {
+	rows, err := s.rulesRepo.List(ctx)
+	if err != nil {
+		return err
+	}
+	rules := make([]detector.Rule, 0, len(rows))
+	for _, row := range rows {
+		if !row.Enabled {
+			continue
+		}
+		r := rowToRule(row)
+		if err := r.Validate(); err != nil {
+			s.log.Warn("skipping invalid detection rule", "rule", row.Name, "error", err)
+			continue
+		}
+		rules = append(rules, r)
+	}
+	s.det.SetRules(ctx, rules)
+	if n, err := s.det.LearnBaselines(ctx); err != nil {
+		s.log.Warn("failed to learn baselines from history", "error", err)
+	} else if n > 0 {
+		s.log.Info("baselines learned from history", "metrics", n)
+	}
+	return nil
+}
+
+// HandleRulesUpdated reloads the rules when another replica, or a tool
+// writing to the database, announces a change to them
+func (s *RuleServer) HandleRulesUpdated(ctx context.Context, msg *opsv1.RulesUpdated) error {
+	if msg.Source == s.instance || len(msg.RuleNames) == 0 {
+		return nil
+	}
+	if err := s.Reload(ctx); err != nil {
+		s.log.Warn("failed to reload detection rules", "error", err)
+		return err
+	}
+	s.log.Info("detection rules reloaded", "changed", msg.RuleNames, "source", msg.Source)
+	return nil
+}
+
+// ListRules lists the stored rules, including disabled ones unless asked not to
+func (s *RuleServer) ListRules(ctx context.Context, req *connect.Request[opsv1.ListRulesRequest]) (*connect.Response[opsv1.ListRulesResponse], error) {
+	rows, err := s.rulesRepo.List(ctx)
+	if err != nil {
+		return nil, repoError(err)
+	}
+	resp := &opsv1.ListRulesResponse{}
+	for _, row := range rows {
+		if req.Msg.EnabledOnly && !row.Enabled {
+			continue
+		}
+		resp.Rules = append(resp.Rules, rowToProto(row))
+	}
+	return connect.NewResponse(resp), nil
+}
+
+// CreateRule stores a new rule and starts evaluating it unless it is
+// created disabled
+func (s *RuleServer) CreateRule(ctx context.Context, req *connect.Request[opsv1.CreateRuleRequest]) (*connect.Response[opsv1.CreateRuleResponse], error) {
+	r := detector.RuleFromProto(req.Msg.Rule)
+	if err := r.Validate(); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	row := ruleToRow(r)
+	row.Enabled = !req.Msg.Disabled
+	if err := s.rulesRepo.Create(ctx, row); err != nil {
+		return nil, repoError(err)
+	}
+	stored, err := s.apply(ctx, r.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Info("detection rule created", "rule", r.Name, "enabled", row.Enabled)
+
+	return connect.NewResponse(&opsv1.CreateRuleResponse{Rule: stored}), nil
+}
+
+// UpdateRule replaces a rule's condition and severity
+func (s *RuleServer) UpdateRule(ctx context.Context, req *connect.Request[opsv1.UpdateRuleRequest]) (*connect.Response[opsv1.UpdateRuleResponse], error) {
+	r := detector.RuleFromProto(req.Msg.Rule)
+	if err := r.Validate(); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if err := s.rulesRepo.Update(ctx, ruleToRow(r)); err != nil {
+		return nil, repoError(err)
+	}
+	stored, err := s.apply(ctx, r.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Info("detection rule updated", "rule", r.Name)
+
+	return connect.NewResponse(&opsv1.UpdateRuleResponse{Rule: stored}), nil
+}
+
+// SetRuleEnabled enables or disables a rule
+func (s *RuleServer) SetRuleEnabled(ctx context.Context, req *connect.Request[opsv1.SetRuleEnabledRequest]) (*connect.Response[opsv1.SetRuleEnabledResponse], error) {
+	if req.Msg.Name == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("rule name is required"))
+	}
+	if err := s.rulesRepo.SetEnabled(ctx, req.Msg.Name, req.Msg.Enabled); err != nil {
+		return nil, repoError(err)
+	}
+	stored, err := s.apply(ctx, req.Msg.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Info("detection rule toggled", "rule", req.Msg.Name, "enabled", req.Msg.Enabled)
+
+	return connect.NewResponse(&opsv1.SetRuleEnabledResponse{Rule: stored}), nil
+}
+
+// apply reloads the detector after a change, tells the other replicas and
+// returns the changed rule as stored. The change is already saved, so a
+// failed notice is only logged; those replicas pick it up on their next
+// periodic reload.
+func (s *RuleServer) apply(ctx context.Context, name string) (*opsv1.DetectionRule, error) {
+	if err := s.Reload(ctx); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("reload rules: %w", err))
+	}
+	notice := &opsv1.RulesUpdated{RuleNames: []string{name}, Source: s.instance}
+	if err := s.publisher.PublishRulesUpdated(ctx, notice); err != nil {
+		s.log.Warn("failed to announce rule change", "rule", name, "error", err)
+	}
+	row, err := s.rulesRepo.Get(ctx, name)
+	if err != nil {
+		return nil, repoError(err)
+	}
+	return rowToProto(*row), nil
+}
+
+func ruleToRow(r detector.Rule) storage.RuleRow {
+	return storage.RuleRow{
+		Name:                 r.Name,
+		MetricName:           r.MetricName,
+		Operator:             r.Operator,
+		Threshold:            r.Threshold,
+		WindowSeconds:        r.WindowSeconds,
+		Severity:             int(r.Severity),
+		Region:               r.Region,
+		Type:                 r.Type,
+		TriggerRatio:         r.TriggerRatio,
+		ClearRatio:           r.ClearRatio,
+		ClearSeconds:         r.ClearSeconds,
+		Selector:             r.Selector,
+		Overrides:            overridesToRows(r.Overrides),
+		MinSamples:           r.MinSamples,
+		EvaluateEverySeconds: r.EvaluateEverySeconds,
+	}
+}
+
+func rowToRule(row storage.RuleRow) detector.Rule {
+	return detector.Rule{
+		Name:                 row.Name,
+		MetricName:           row.MetricName,
+		Operator:             row.Operator,
+		Threshold:            row.Threshold,
+		WindowSeconds:        row.WindowSeconds,
+		Severity:             commonv1.IncidentSeverity(row.Severity),
+		Region:               row.Region,
+		Type:                 row.Type,
+		TriggerRatio:         row.TriggerRatio,
+		ClearRatio:           row.ClearRatio,
+		ClearSeconds:         row.ClearSeconds,
+		Selector:             row.Selector,
+		Overrides:            rowsToOverrides(row.Overrides),
+		MinSamples:           row.MinSamples,
+		EvaluateEverySeconds: row.EvaluateEverySeconds,
+	}
+}
+
+func overridesToRows(overrides []detector.RuleOverride) []storage.RuleOverrideRow {
+	if len(overrides) == 0 {
+		return nil
+	}
+	rows := make([]storage.RuleOverrideRow, 0, len(overrides))
+	for _, o := range overrides {
+		rows = append(rows, storage.RuleOverrideRow{Selector: o.Selector, Threshold: o.Threshold})
+	}
+	return rows
+}
+
+func rowsToOverrides(rows []storage.RuleOverrideRow) []detector.RuleOverride {
+	if len(rows) == 0 {
+		return nil
+	}
+	overrides := make([]detector.RuleOverride, 0, len(rows))
+	for _, row := range rows {
+		overrides = append(overrides, detector.RuleOverride{Selector: row.Selector, Threshold: row.Threshold})
+	}
+	return overrides
+}
+
+func rowToProto(row storage.RuleRow) *opsv1.DetectionRule {
+	p := rowToRule(row).ToProto()
+	p.Enabled = row.Enabled
+	return p
+}
+
+// repoError maps a repository error to the matching connect error code
+func repoError(err error) error {
+	switch {
+	case storage.IsNotFound(err):
+		return connect.NewError(connect.CodeNotFound, err)
+	case storage.IsExists(err):
+		return connect.NewError(connect.CodeAlreadyExists, err)
+	case storage.IsInvalidEnum(err):
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	default:
+		return connect.NewError(connect.CodeInternal, err)
+	}
+}
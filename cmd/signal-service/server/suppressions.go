@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"connectrpc.com/connect"
+
+	"github.com/microcloud/bus"
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+	"github.com/microcloud/gen/go/ops/v1/opsv1connect"
+	"github.com/microcloud/id"
+	"github.com/microcloud/signal-service/detector"
+	"github.com/microcloud/storage"
+)
+
+// SuppressionServer implements the SuppressionService. Like rules, windows
+// are written to the database, loaded into the detector and announced on
+// rules.updated for the other replicas.
+type SuppressionServer struct {
+	repo      *storage.SuppressionsRepository
+	det       *detector.Detector
+	publisher *bus.Publisher
+	instance  string // source of the notices this replica sends
+	log       *slog.Logger
+}
+
+var _ opsv1connect.SuppressionServiceHandler = (*SuppressionServer)(nil)
+
+// NewSuppressionServer creates a new suppression server
+func NewSuppressionServer(repo *storage.SuppressionsRepository, det *detector.Detector, publisher *bus.Publisher, log *slog.Logger) *SuppressionServer {
+	return &SuppressionServer{
+		repo:      repo,
+		det:       det,
+		publisher: publisher,
+		instance:  id.NewV7(),
+		log:       log,
+	}
+}
+
+// Reload loads the windows that have not ended into the detector
+func (s *SuppressionServer) Reload(ctx context.Context) error {
+	rows, err := s.repo.List(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	windows := make([]detector.Suppression, 0, len(rows))
+	for _, row := range rows {
+		windows = append(windows, detector.Suppression{
+			ID:       row.ID,
+			EntityID: row.EntityID,
+			RuleName: row.RuleName,
+			Start:    row.StartsAt,
+			End:      row.EndsAt,
+			Reason:   row.Reason,
+		})
+	}
+	s.det.SetSuppressions(windows)
+	return nil
+}
+
+// HandleRulesUpdated reloads the windows when another replica announces
+// a change to them
+func (s *SuppressionServer) HandleRulesUpdated(ctx context.Context, msg *opsv1.RulesUpdated) error {
+	if msg.Source == s.instance || len(msg.SuppressionIds) == 0 {
+		return nil
+	}
+	if err := s.Reload(ctx); err != nil {
+		s.log.Warn("failed to reload suppression windows", "error", err)
+		return err
+	}
+	s.log.Info("suppression windows reloaded", "changed", msg.SuppressionIds, "source", msg.Source)
+	return nil
+}
+
+// ListSuppressionWindows lists the windows that have not ended, or every
+// window if asked
+func (s *SuppressionServer) ListSuppressionWindows(ctx context.Context, req *connect.Request[opsv1.ListSuppressionWindowsRequest]) (*connect.Response[opsv1.ListSuppressionWindowsResponse], error) {
+	var endsAfter time.Time
+	if !req.Msg.IncludeExpired {
+		endsAfter = time.Now()
+	}
+	rows, err := s.repo.List(ctx, endsAfter)
+	if err != nil {
+		return nil, repoError(err)
+	}
+	resp := &opsv1.ListSuppressionWindowsResponse{}
+	for _, row := range rows {
+		resp.Windows = append(resp.Windows, suppressionToProto(row))
+	}
+	return connect.NewResponse(resp), nil
+}
+
+// CreateSuppressionWindow stores a new window and starts honouring it
+func (s *SuppressionServer) CreateSuppressionWindow(ctx context.Context, req *connect.Request[opsv1.CreateSuppressionWindowRequest]) (*connect.Response[opsv1.CreateSuppressionWindowResponse], error) {
+	w := req.Msg.Window
+	if w == nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("window is required"))
+	}
+	row := storage.SuppressionRow{
+		ID:        id.NewV7(),
+		EntityID:  w.EntityId,
+		RuleName:  w.RuleName,
+		StartsAt:  time.UnixMilli(w.StartsAtUnixMs),
+		EndsAt:    time.UnixMilli(w.EndsAtUnixMs),
+		Reason:    w.Reason,
+		CreatedBy: w.CreatedBy,
+	}
+	if w.StartsAtUnixMs == 0 {
+		row.StartsAt = time.Now()
+	}
+	if !row.EndsAt.After(row.StartsAt) {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("window must end after it starts"))
+	}
+	if !row.EndsAt.After(time.Now()) {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("window has already ended"))
+	}
+	if err := s.repo.Create(ctx, row); err != nil {
+		return nil, repoError(err)
+	}
+	if err := s.apply(ctx, row.ID); err != nil {
+		return nil, err
+	}
+	stored, err := s.repo.Get(ctx, row.ID)
+	if err != nil {
+		return nil, repoError(err)
+	}
+
+	s.log.Info("suppression window created", "window", row.ID, "entity", row.EntityID, "rule", row.RuleName,
+		"starts_at", row.StartsAt, "ends_at", row.EndsAt, "reason", row.Reason)
+
+	return connect.NewResponse(&opsv1.CreateSuppressionWindowResponse{Window: suppressionToProto(*stored)}), nil
+}
+
+// DeleteSuppressionWindow removes a window, ending it early
+func (s *SuppressionServer) DeleteSuppressionWindow(ctx context.Context, req *connect.Request[opsv1.DeleteSuppressionWindowRequest]) (*connect.Response[opsv1.DeleteSuppressionWindowResponse], error) {
+	windowID := req.Msg.Id.GetValue()
+	if windowID == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("window id is required"))
+	}
+	if err := s.repo.Delete(ctx, windowID); err != nil {
+		return nil, repoError(err)
+	}
+	if err := s.apply(ctx, windowID); err != nil {
+		return nil, err
+	}
+
+	s.log.Info("suppression window deleted", "window", windowID)
+
+	return connect.NewResponse(&opsv1.DeleteSuppressionWindowResponse{}), nil
+}
+
+// apply reloads the detector after a change and tells the other replicas.
+// A failed notice is only logged; those replicas pick the change up on
+// their next periodic reload.
+func (s *SuppressionServer) apply(ctx context.Context, windowID string) error {
+	if err := s.Reload(ctx); err != nil {
+		return connect.NewError(connect.CodeInternal, fmt.Errorf("reload suppression windows: %w", err))
+	}
+	notice := &opsv1.RulesUpdated{SuppressionIds: []string{windowID}, Source: s.instance}
+	if err := s.publisher.PublishRulesUpdated(ctx, notice); err != nil {
+		s.log.Warn("failed to announce suppression window change", "window", windowID, "error", err)
+	}
+	return nil
+}
+
+func suppressionToProto(row storage.SuppressionRow) *opsv1.SuppressionWindow {
+	return &opsv1.SuppressionWindow{
+		Id:              &commonv1.UUID{Value: row.ID},
+		EntityId:        row.EntityID,
+		RuleName:        row.RuleName,
+		StartsAtUnixMs:  row.StartsAt.UnixMilli(),
+		EndsAtUnixMs:    row.EndsAt.UnixMilli(),
+		Reason:          row.Reason,
+		CreatedBy:       row.CreatedBy,
+		CreatedAtUnixMs: row.CreatedAt.UnixMilli(),
+	}
+}
@@ -0,0 +1,203 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+)
+
+// Action parameters accepted by ApplyCommand:
+//
+//	SCALE_DOWN            target: service  params: none
+//	DRAIN_NODE            target: node     params: none
+//	CORDON_NODE           target: node     params: none
+//	UNCORDON_NODE         target: node     params: none
+//	REBOOT_NODE           target: node     params: none
+//	SCALE_NODE_POOL       target: node     params: "count" (optional, 1-MaxPoolScaleOut, default 1);
+//	                                       new nodes copy the target's zone, capacity and labels
+//	FAILOVER_SERVICE      target: service  params: "target_node_id" (optional; defaults to the
+//	                                       least-loaded schedulable node, preferring another zone)
+//	SCALE_TO_N            target: service  params: "count" (required, 1-MaxReplicas)
+//	CLEAR_CACHE           target: service  params: none
+//	DISABLE_FEATURE_FLAG  target: service  params: "flag" (optional; recorded in the event)
+//	WARM_CACHE            target: service  params: "ticks" (optional, 1-MaxCacheWarmTicks,
+//	                                       default DefaultCacheWarmTicks)
+const (
+	ParamTargetNodeID = "target_node_id"
+	ParamCount        = "count"
+	ParamFlag         = "flag"
+	ParamTicks        = "ticks"
+
+	// LabelCordoned marks nodes that must not receive new services
+	LabelCordoned = "cordoned"
+
+	// LabelApp carries a service's name, for detection rule selectors
+	LabelApp = "app"
+
+	// MaxReplicas bounds SCALE_TO_N
+	MaxReplicas = 50
+)
+
+// isSchedulable reports whether services may be placed on the node
+func isSchedulable(node *simv1.Node) bool {
+	return node.Status != commonv1.NodeStatus_NODE_STATUS_OFFLINE &&
+		node.Labels[LabelCordoned] != "true" && node.Labels[LabelDrained] != "true"
+}
+
+// pickFailoverNode returns the least-loaded schedulable node other than
+// exclude, preferring nodes outside excludeZone. Caller must hold mu.
+func (s *State) pickFailoverNode(exclude, excludeZone string) *simv1.Node {
+	var best *simv1.Node
+	bestOtherZone := false
+	for id, node := range s.nodes {
+		if id == exclude || !isSchedulable(node) {
+			continue
+		}
+		otherZone := node.AvailabilityZone != excludeZone
+		switch {
+		case best == nil,
+			otherZone && !bestOtherZone,
+			otherZone == bestOtherZone && node.CpuUsagePercent < best.CpuUsagePercent:
+			best = node
+			bestOtherZone = otherZone
+		}
+	}
+	return best
+}
+
+// moveService re-homes all of a service's replicas onto another node,
+// whether or not they fit. Caller must hold mu.
+func (s *State) moveService(svc *simv1.Service, to *simv1.Node) {
+	if s.placements == nil {
+		s.initPlacements()
+	}
+	s.setPlacement(svc, placement{to.Id.Value: svc.ReplicaCount})
+	svc.NodeId = &commonv1.UUID{Value: to.Id.Value}
+	svc.Region = to.Region
+}
+
+// failoverService moves a service to a healthy node. Caller must hold mu.
+func (s *State) failoverService(svc *simv1.Service, params map[string]string) (*simv1.Node, error) {
+	current := s.nodes[svc.NodeId.GetValue()]
+
+	var to *simv1.Node
+	if id := params[ParamTargetNodeID]; id != "" {
+		node, ok := s.nodes[id]
+		if !ok {
+			return nil, fmt.Errorf("unknown node: %s", id)
+		}
+		if !isSchedulable(node) {
+			return nil, fmt.Errorf("node %s is not schedulable", id)
+		}
+		to = node
+	} else {
+		zone := ""
+		if current != nil {
+			zone = current.AvailabilityZone
+		}
+		to = s.pickFailoverNode(svc.NodeId.GetValue(), zone)
+		if to == nil {
+			return nil, fmt.Errorf("no schedulable node available for failover")
+		}
+	}
+
+	s.moveService(svc, to)
+	s.clearMemory(svc.Id.GetValue())
+	svc.ErrorRatePercent = clamp(svc.ErrorRatePercent*0.3, 0, 100)
+	svc.Health = commonv1.ServiceHealth_SERVICE_HEALTH_HEALTHY
+	return to, nil
+}
+
+// parseCount validates the SCALE_TO_N "count" parameter
+func parseCount(params map[string]string) (int32, error) {
+	raw, ok := params[ParamCount]
+	if !ok {
+		return 0, fmt.Errorf("missing %q parameter", ParamCount)
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %q parameter: %w", ParamCount, err)
+	}
+	if n < 1 || n > MaxReplicas {
+		return 0, fmt.Errorf("%q must be between 1 and %d, got %d", ParamCount, MaxReplicas, n)
+	}
+	return int32(n), nil
+}
+
+// commandResultHistory is how many action results are kept to answer
+// redelivered commands
+const commandResultHistory = 1000
+
+// commandResults keeps the results of the latest executed commands by
+// action ID, oldest dropped first
+type commandResults struct {
+	mu      sync.Mutex // held while a command executes
+	results map[string]*opsv1.ActionResult
+	order   []string
+}
+
+// add records the result of a command. Caller must hold mu.
+func (c *commandResults) add(actionID string, result *opsv1.ActionResult) {
+	if c.results == nil {
+		c.results = make(map[string]*opsv1.ActionResult)
+	}
+	c.results[actionID] = result
+	c.order = append(c.order, actionID)
+	if len(c.order) > commandResultHistory {
+		delete(c.results, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// ExecuteCommand applies an approved action command and publishes its
+// outcome on ops.results, so the orchestrator and decider learn whether it
+// took effect. A command redelivered for an action already executed is not
+// applied again; its stored result is republished and returned.
+func (e *Engine) ExecuteCommand(ctx context.Context, cmd *opsv1.ApplyActionCommand) *opsv1.ActionResult {
+	actionID := cmd.ActionId.GetValue()
+	e.executed.mu.Lock()
+	defer e.executed.mu.Unlock()
+
+	result, repeated := e.executed.results[actionID]
+	if repeated {
+		e.log.Info("command already executed, republishing its result", "action_id", actionID)
+	} else {
+		result = e.executeCommand(ctx, cmd)
+		if actionID != "" {
+			e.executed.add(actionID, result)
+		}
+	}
+
+	if err := e.publisher.PublishActionResult(ctx, result); err != nil {
+		e.log.Error("failed to publish action result", "action_id", actionID, "error", err)
+	}
+	return result
+}
+
+// executeCommand applies cmd and returns its result. Caller must hold
+// executed.mu.
+func (e *Engine) executeCommand(ctx context.Context, cmd *opsv1.ApplyActionCommand) *opsv1.ActionResult {
+	result := &opsv1.ActionResult{
+		ActionId:      cmd.ActionId,
+		AppliedAtTick: e.state.GetTickID(),
+	}
+
+	event, err := e.ApplyCommand(ctx, cmd.ActionType, cmd.TargetId, cmd.Parameters)
+	switch {
+	case err != nil:
+		result.Message = err.Error()
+	case event.EventType == "":
+		// ApplyCommand ignores actions whose target does not fit
+		result.Message = fmt.Sprintf("%s does not apply to %s", cmd.ActionType, cmd.TargetId)
+	default:
+		result.Success = true
+		result.Message = event.Description
+		result.AppliedAtTick = event.Timestamp.TickId
+	}
+	return result
+}
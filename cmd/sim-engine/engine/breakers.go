@@ -0,0 +1,166 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ENABLE_CIRCUIT_BREAKER parameters
+//
+//	target: calling service
+//	"dependency_id"   dependency to guard (optional; defaults to the one
+//	                  with the highest error rate)
+//	"shed_percent"    share of calls to the dependency that fail fast
+//	                  (optional, 1-100, default DefaultShedPercent)
+//	"duration_ticks"  ticks before the breaker closes again (optional,
+//	                  default DefaultBreakerTicks)
+const (
+	ParamDependencyID  = "dependency_id"
+	ParamShedPercent   = "shed_percent"
+	ParamDurationTicks = "duration_ticks"
+
+	DefaultShedPercent  = 50
+	DefaultBreakerTicks = 300
+)
+
+// errorPropagation is the share of a dependency's error rate that surfaces
+// in its callers
+const errorPropagation = 0.3
+
+// propagationPull is how far each tick moves a caller's error rate toward
+// what its dependencies push onto it
+const propagationPull = 0.3
+
+// breaker is an open circuit breaker on a caller -> dependency edge
+type breaker struct {
+	from, to    string
+	shed        float64 // 0-1
+	expiresTick int64
+}
+
+// openBreaker installs a breaker for the target service. Caller must hold mu.
+func (s *State) openBreaker(serviceID string, params map[string]string) (*breaker, error) {
+	deps := s.deps[serviceID]
+	if len(deps) == 0 {
+		return nil, fmt.Errorf("service %s has no dependencies", serviceID)
+	}
+
+	to := params[ParamDependencyID]
+	if to == "" {
+		to = s.worstDependency(serviceID)
+	} else if !contains(deps, to) {
+		return nil, fmt.Errorf("%s is not a dependency of %s", to, serviceID)
+	}
+
+	shed, err := intParam(params, ParamShedPercent, DefaultShedPercent, 1, 100)
+	if err != nil {
+		return nil, err
+	}
+	ticks, err := intParam(params, ParamDurationTicks, DefaultBreakerTicks, 1, 100000)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &breaker{
+		from:        serviceID,
+		to:          to,
+		shed:        float64(shed) / 100,
+		expiresTick: s.tickID + int64(ticks),
+	}
+	if s.breakers == nil {
+		s.breakers = make(map[string]*breaker)
+	}
+	s.breakers[serviceID+">"+to] = b
+	return b, nil
+}
+
+// worstDependency returns the dependency with the highest error rate.
+// Caller must hold mu.
+func (s *State) worstDependency(serviceID string) string {
+	var worst string
+	worstRate := -1.0
+	for _, id := range s.deps[serviceID] {
+		if dep, ok := s.services[id]; ok && dep.ErrorRatePercent > worstRate {
+			worst, worstRate = id, dep.ErrorRatePercent
+		}
+	}
+	return worst
+}
+
+// shedFraction returns how much of the edge's traffic a breaker sheds.
+// Caller must hold mu.
+func (s *State) shedFraction(from, to string) float64 {
+	if b, ok := s.breakers[from+">"+to]; ok {
+		return b.shed
+	}
+	return 0
+}
+
+// propagateDependencies pushes dependency errors onto callers, reduced by
+// any open breaker, and takes shed calls off the dependency's traffic.
+// Expired breakers close first. Caller must hold mu.
+func (s *State) propagateDependencies() {
+	for key, b := range s.breakers {
+		if s.tickID >= b.expiresTick {
+			delete(s.breakers, key)
+		}
+	}
+
+	callers := make(map[string]int)
+	for _, deps := range s.deps {
+		for _, dep := range deps {
+			callers[dep]++
+		}
+	}
+
+	for id, svc := range s.services {
+		var induced float64
+		var worst float64
+		for _, depID := range s.deps[id] {
+			dep, ok := s.services[depID]
+			if !ok {
+				continue
+			}
+			worst = max(worst, dep.ErrorRatePercent)
+			induced = max(induced, dep.ErrorRatePercent*errorPropagation*(1-s.shedFraction(id, depID)))
+		}
+		svc.DependencyErrorRatePercent = worst
+		if induced > svc.ErrorRatePercent {
+			svc.ErrorRatePercent = clamp(lerp(svc.ErrorRatePercent, induced, propagationPull), 0, 100)
+		}
+	}
+
+	for _, b := range s.breakers {
+		dep, ok := s.services[b.to]
+		if !ok || callers[b.to] == 0 {
+			continue
+		}
+		// Assume callers contribute equally to the dependency's traffic
+		dep.RequestsPerSecond = clamp(dep.RequestsPerSecond*(1-b.shed/float64(callers[b.to])), 0, 10000)
+	}
+}
+
+// intParam parses an optional integer parameter within [lo, hi]
+func intParam(params map[string]string, key string, fallback, lo, hi int) (int, error) {
+	raw, ok := params[key]
+	if !ok || raw == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %q parameter: %w", key, err)
+	}
+	if n < lo || n > hi {
+		return 0, fmt.Errorf("%q must be between %d and %d, got %d", key, lo, hi, n)
+	}
+	return n, nil
+}
+
+func contains(ids []string, id string) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package engine
+
+import (
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+)
+
+// Node capacity defaults
+const (
+	DefaultNodeCPUMillicores = 8000
+	DefaultNodeMemoryMB      = 16384
+)
+
+// resourceRequest is the per-replica reservation of a service
+type resourceRequest struct {
+	cpuMillicores int32
+	memoryMB      int32
+}
+
+// defaultRequest applies to services missing from serviceRequests
+var defaultRequest = resourceRequest{cpuMillicores: 250, memoryMB: 512}
+
+// serviceRequests lists per-replica requests by service name
+var serviceRequests = map[string]resourceRequest{
+	"api-gateway":          {cpuMillicores: 500, memoryMB: 512},
+	"user-service":         {cpuMillicores: 250, memoryMB: 512},
+	"order-service":        {cpuMillicores: 500, memoryMB: 1024},
+	"payment-service":      {cpuMillicores: 500, memoryMB: 768},
+	"inventory-service":    {cpuMillicores: 250, memoryMB: 1024},
+	"notification-service": {cpuMillicores: 100, memoryMB: 256},
+	"analytics-service":    {cpuMillicores: 1000, memoryMB: 2048},
+	"search-service":       {cpuMillicores: 750, memoryMB: 2048},
+}
+
+// How strongly reservations and overcommit feed into node and service metrics
+const (
+	// requestedUsageShare is the fraction of requested CPU/memory actually
+	// in use, which puts a floor under node utilization
+	requestedUsageShare = 0.7
+
+	// overcommitPull is how far each tick moves service metrics toward
+	// their contended values on an overcommitted node
+	overcommitPull = 0.2
+)
+
+// initCapacity assigns node capacity and service requests. Caller must hold mu.
+func (s *State) initCapacity() {
+	for _, node := range s.nodes {
+		if node.CpuCapacityMillicores == 0 {
+			node.CpuCapacityMillicores = DefaultNodeCPUMillicores
+		}
+		if node.MemoryCapacityMb == 0 {
+			node.MemoryCapacityMb = DefaultNodeMemoryMB
+		}
+	}
+	for _, svc := range s.services {
+		req, ok := serviceRequests[svc.Name]
+		if !ok {
+			req = defaultRequest
+		}
+		if svc.CpuRequestMillicores == 0 {
+			svc.CpuRequestMillicores = req.cpuMillicores
+		}
+		if svc.MemoryRequestMb == 0 {
+			svc.MemoryRequestMb = req.memoryMB
+		}
+	}
+}
+
+// applyCapacity recomputes node reservations from replica counts, raises
+// node utilization accordingly and degrades services on overcommitted
+// nodes. Caller must hold mu.
+func (s *State) applyCapacity() {
+	reserved := s.reservations()
+	for id, node := range s.nodes {
+		node.CpuRequestedPercent = percentOf(reserved[id].cpu, float64(node.CpuCapacityMillicores))
+		node.MemoryRequestedPercent = percentOf(reserved[id].mem, float64(node.MemoryCapacityMb))
+		if node.Status == commonv1.NodeStatus_NODE_STATUS_OFFLINE {
+			continue
+		}
+
+		node.CpuUsagePercent = clamp(max(node.CpuUsagePercent, node.CpuRequestedPercent*requestedUsageShare), 0, 100)
+		node.MemoryUsagePercent = clamp(max(node.MemoryUsagePercent, node.MemoryRequestedPercent*requestedUsageShare), 0, 100)
+		if node.CpuRequestedPercent > 100 || node.MemoryRequestedPercent > 100 {
+			node.Status = commonv1.NodeStatus_NODE_STATUS_DEGRADED
+		}
+	}
+
+	for _, svc := range s.services {
+		node, ok := s.nodes[svc.NodeId.GetValue()]
+		if !ok {
+			continue
+		}
+		cpuOver := node.CpuRequestedPercent / 100
+		memOver := node.MemoryRequestedPercent / 100
+		if cpuOver <= 1 && memOver <= 1 {
+			continue
+		}
+
+		// CPU contention slows requests; memory pressure causes failures
+		if cpuOver > 1 {
+			svc.LatencyP50Ms = clamp(lerp(svc.LatencyP50Ms, 10*cpuOver*cpuOver, overcommitPull), 1, 1000)
+			svc.LatencyP99Ms = clamp(lerp(svc.LatencyP99Ms, 50*cpuOver*cpuOver, overcommitPull), svc.LatencyP50Ms, 5000)
+		}
+		target := (cpuOver-1)*5 + (memOver-1)*20
+		if target > svc.ErrorRatePercent {
+			svc.ErrorRatePercent = clamp(lerp(svc.ErrorRatePercent, target, overcommitPull), 0, 100)
+		}
+		if svc.Health == commonv1.ServiceHealth_SERVICE_HEALTH_HEALTHY {
+			svc.Health = commonv1.ServiceHealth_SERVICE_HEALTH_DEGRADED
+		}
+	}
+}
+
+// nodeCPURequestedPercent returns the CPU reservation of a service's node
+// if the service were running replicas instead of its current count, with
+// the difference landing on that node. The scheduler may spread them, so
+// this is the node's worst case. Caller must hold mu.
+func (s *State) nodeCPURequestedPercent(serviceID string, replicas int32) float64 {
+	target, ok := s.services[serviceID]
+	if !ok {
+		return 0
+	}
+	node, ok := s.nodes[target.NodeId.GetValue()]
+	if !ok {
+		return 0
+	}
+
+	total := s.reservations()[node.Id.GetValue()].cpu
+	total += float64(replicas-s.placements[serviceID].total()) * float64(target.CpuRequestMillicores)
+	return percentOf(max(total, 0), float64(node.CpuCapacityMillicores))
+}
+
+func percentOf(v, capacity float64) float64 {
+	if capacity <= 0 {
+		return 0
+	}
+	return v / capacity * 100
+}
@@ -0,0 +1,194 @@
+package engine
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+)
+
+// Chaos parameters accepted by LoadScenario for scenarios with chaos.
+// Probabilities are per entity per tick; spikes add percentage points of
+// error rate and milliseconds of p99 latency.
+const (
+	ParamNodeCrashProbability    = "node_crash_probability"
+	ParamNodeCrashTicks          = "node_crash_ticks"
+	ParamErrorSpikeProbability   = "error_spike_probability"
+	ParamErrorSpikeMin           = "error_spike_min"
+	ParamErrorSpikeMax           = "error_spike_max"
+	ParamLatencySpikeProbability = "latency_spike_probability"
+	ParamLatencySpikeMinMs       = "latency_spike_min_ms"
+	ParamLatencySpikeMaxMs       = "latency_spike_max_ms"
+)
+
+// ChaosConfig sets how often and how hard random failures strike
+type ChaosConfig struct {
+	NodeCrashProbability    float64
+	NodeCrashTicks          int64 // how long a crashed node stays offline
+	ErrorSpikeProbability   float64
+	ErrorSpikeMin           float64
+	ErrorSpikeMax           float64
+	LatencySpikeProbability float64
+	LatencySpikeMinMs       float64
+	LatencySpikeMaxMs       float64
+}
+
+// DefaultChaosConfig returns the rates used by random_chaos when no
+// parameters are given
+func DefaultChaosConfig() ChaosConfig {
+	return ChaosConfig{
+		NodeCrashProbability:    0.0005,
+		NodeCrashTicks:          100,
+		ErrorSpikeProbability:   0.01,
+		ErrorSpikeMin:           10,
+		ErrorSpikeMax:           40,
+		LatencySpikeProbability: 0.01,
+		LatencySpikeMinMs:       100,
+		LatencySpikeMaxMs:       1000,
+	}
+}
+
+// ChaosParam describes a LoadScenario parameter of scenarios with chaos
+type ChaosParam struct {
+	Name        string
+	Description string
+	Default     string // value used when the parameter is not given
+}
+
+// Params describes the parameters accepted by WithParams, with c's values
+// as defaults
+func (c ChaosConfig) Params() []ChaosParam {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	return []ChaosParam{
+		{ParamNodeCrashProbability, "Chance per node per tick of crashing (0-1)", f(c.NodeCrashProbability)},
+		{ParamNodeCrashTicks, "Ticks a crashed node stays offline", strconv.FormatInt(c.NodeCrashTicks, 10)},
+		{ParamErrorSpikeProbability, "Chance per service per tick of an error-rate spike (0-1)", f(c.ErrorSpikeProbability)},
+		{ParamErrorSpikeMin, "Smallest error-rate spike, in percentage points", f(c.ErrorSpikeMin)},
+		{ParamErrorSpikeMax, "Largest error-rate spike, in percentage points", f(c.ErrorSpikeMax)},
+		{ParamLatencySpikeProbability, "Chance per service per tick of a p99 latency spike (0-1)", f(c.LatencySpikeProbability)},
+		{ParamLatencySpikeMinMs, "Smallest p99 latency spike, in milliseconds", f(c.LatencySpikeMinMs)},
+		{ParamLatencySpikeMaxMs, "Largest p99 latency spike, in milliseconds", f(c.LatencySpikeMaxMs)},
+	}
+}
+
+// WithParams returns a copy of c with the given parameters applied
+func (c ChaosConfig) WithParams(params map[string]string) (ChaosConfig, error) {
+	floats := map[string]*float64{
+		ParamNodeCrashProbability:    &c.NodeCrashProbability,
+		ParamErrorSpikeProbability:   &c.ErrorSpikeProbability,
+		ParamErrorSpikeMin:           &c.ErrorSpikeMin,
+		ParamErrorSpikeMax:           &c.ErrorSpikeMax,
+		ParamLatencySpikeProbability: &c.LatencySpikeProbability,
+		ParamLatencySpikeMinMs:       &c.LatencySpikeMinMs,
+		ParamLatencySpikeMaxMs:       &c.LatencySpikeMaxMs,
+	}
+	for key, raw := range params {
+		if key == ParamNodeCrashTicks {
+			n, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || n < 1 {
+				return c, fmt.Errorf("invalid %q parameter: must be a positive integer", key)
+			}
+			c.NodeCrashTicks = n
+			continue
+		}
+		dst, ok := floats[key]
+		if !ok {
+			return c, fmt.Errorf("unknown chaos parameter %q", key)
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || v < 0 {
+			return c, fmt.Errorf("invalid %q parameter: must be a non-negative number", key)
+		}
+		*dst = v
+	}
+	return c, c.validate()
+}
+
+func (c ChaosConfig) validate() error {
+	for name, p := range map[string]float64{
+		ParamNodeCrashProbability:    c.NodeCrashProbability,
+		ParamErrorSpikeProbability:   c.ErrorSpikeProbability,
+		ParamLatencySpikeProbability: c.LatencySpikeProbability,
+	} {
+		if p > 1 {
+			return fmt.Errorf("%q must be between 0 and 1, got %g", name, p)
+		}
+	}
+	if c.ErrorSpikeMin > c.ErrorSpikeMax {
+		return fmt.Errorf("%q must not exceed %q", ParamErrorSpikeMin, ParamErrorSpikeMax)
+	}
+	if c.LatencySpikeMinMs > c.LatencySpikeMaxMs {
+		return fmt.Errorf("%q must not exceed %q", ParamLatencySpikeMinMs, ParamLatencySpikeMaxMs)
+	}
+	return nil
+}
+
+// injectChaos rolls the dice for every node and service. Caller must hold mu.
+func (s *State) injectChaos() {
+	c := s.chaos
+	if c == nil {
+		return
+	}
+
+	for _, id := range sortedIDs(s.nodes) {
+		if _, down := s.crashes[id]; !down && s.rng.Float64() < c.NodeCrashProbability {
+			if s.crashes == nil {
+				s.crashes = make(map[string]int64)
+			}
+			s.crashes[id] = s.tickID + c.NodeCrashTicks
+		}
+	}
+
+	for _, id := range sortedIDs(s.services) {
+		svc := s.services[id]
+		if s.rng.Float64() < c.ErrorSpikeProbability {
+			svc.ErrorRatePercent = clamp(svc.ErrorRatePercent+uniform(s.rng, c.ErrorSpikeMin, c.ErrorSpikeMax), 0, 100)
+		}
+		if s.rng.Float64() < c.LatencySpikeProbability {
+			svc.LatencyP99Ms = clamp(svc.LatencyP99Ms+uniform(s.rng, c.LatencySpikeMinMs, c.LatencySpikeMaxMs), svc.LatencyP50Ms, 5000)
+		}
+	}
+}
+
+// enforceCrashes keeps crashed nodes offline with their services down until
+// the crash expires. Caller must hold mu.
+func (s *State) enforceCrashes() {
+	// Recovery draws random numbers, so crashes expire in a fixed order
+	for _, id := range sortedIDs(s.crashes) {
+		if s.tickID >= s.crashes[id] {
+			delete(s.crashes, id)
+			s.recoverCrashedServices(id)
+			continue
+		}
+		if node, ok := s.nodes[id]; ok {
+			node.Status = commonv1.NodeStatus_NODE_STATUS_OFFLINE
+		}
+	}
+	if len(s.crashes) == 0 {
+		return
+	}
+	for _, svc := range s.services {
+		if _, down := s.crashes[svc.NodeId.GetValue()]; down {
+			svc.RequestsPerSecond = 0
+			svc.ErrorRatePercent = 100
+			svc.Health = commonv1.ServiceHealth_SERVICE_HEALTH_DOWN
+		}
+	}
+}
+
+// recoverCrashedServices brings the services of a rebooted node back with
+// a clean error rate. Caller must hold mu.
+func (s *State) recoverCrashedServices(nodeID string) {
+	for _, id := range sortedIDs(s.services) {
+		svc := s.services[id]
+		if svc.NodeId.GetValue() == nodeID {
+			svc.ErrorRatePercent = s.rng.Float64() * 0.5
+			svc.Health = commonv1.ServiceHealth_SERVICE_HEALTH_HEALTHY
+		}
+	}
+}
+
+func uniform(r *rand.Rand, lo, hi float64) float64 {
+	return lo + r.Float64()*(hi-lo)
+}
@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+)
+
+// Checkpoint captures the state a standby needs to continue the tick loop
+// where this instance left off
+func (s *State) Checkpoint(instanceID string) *simv1.StateCheckpoint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	cp := &simv1.StateCheckpoint{
+		InstanceId:      instanceID,
+		TickId:          s.tickID,
+		SimTimeUnixMs:   max(s.simTimeUnixMs, s.simNow(now)),
+		WrittenUnixMs:   now.UnixMilli(),
+		SimState:        s.simState,
+		SpeedMultiplier: s.speedMult,
+		Scenario:        s.scenario,
+		TopologySource:  s.topologySource,
+		Nodes:           make([]*simv1.Node, 0, len(s.nodes)),
+		Services:        make([]*simv1.Service, 0, len(s.services)),
+		BaseRps:         make(map[string]float64, len(s.baseRPS)),
+		DrainedNodes:    make(map[string]int64, len(s.drains)),
+		MemoryLeaks:     make(map[string]float64, len(s.leaks)),
+		LatencyBudgets:  make(map[string]float64, len(s.latencyBudgets)),
+	}
+
+	for _, n := range s.nodes {
+		cp.Nodes = append(cp.Nodes, proto.Clone(n).(*simv1.Node))
+	}
+	for id, svc := range s.services {
+		cp.Services = append(cp.Services, proto.Clone(svc).(*simv1.Service))
+		for _, depID := range s.deps[id] {
+			cp.Dependencies = append(cp.Dependencies, &simv1.TopologyEdge{
+				FromId: id,
+				ToId:   depID,
+				Kind:   EdgeDependency,
+			})
+		}
+	}
+	for id, rps := range s.baseRPS {
+		cp.BaseRps[id] = rps
+	}
+	for name, r := range s.regions {
+		if r.failed {
+			cp.FailedRegions = append(cp.FailedRegions, name)
+		}
+	}
+	sort.Strings(cp.FailedRegions)
+	for id, tick := range s.drains {
+		cp.DrainedNodes[id] = tick
+	}
+	for id, rate := range s.leaks {
+		cp.MemoryLeaks[id] = rate
+	}
+	// Budgets come from each service's first p99, so they cannot be derived
+	// again from a restored, possibly degraded, one
+	for id, budget := range s.latencyBudgets {
+		cp.LatencyBudgets[id] = budget
+	}
+	for id := range s.oomKilled {
+		cp.OomKilled = append(cp.OomKilled, id)
+	}
+	sort.Strings(cp.OomKilled)
+	return cp
+}
+
+// Restore replaces the state with a checkpoint taken by another instance.
+// Tick IDs and sim time continue from the checkpoint; faults, action
+// effects, breakers, chaos crashes and scripts start out empty, chaos
+// rates revert to the scenario defaults and replicas are placed afresh.
+func (s *State) Restore(cp *simv1.StateCheckpoint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nodes = make(map[string]*simv1.Node, len(cp.Nodes))
+	for _, n := range cp.Nodes {
+		s.nodes[n.Id.GetValue()] = proto.Clone(n).(*simv1.Node)
+	}
+	s.services = make(map[string]*simv1.Service, len(cp.Services))
+	s.baseRPS = make(map[string]float64, len(cp.Services))
+	for _, svc := range cp.Services {
+		id := svc.Id.GetValue()
+		s.services[id] = proto.Clone(svc).(*simv1.Service)
+		s.baseRPS[id] = svc.RequestsPerSecond
+	}
+	for id, rps := range cp.BaseRps {
+		if _, ok := s.services[id]; ok {
+			s.baseRPS[id] = rps
+		}
+	}
+
+	s.deps = make(map[string][]string, len(s.services))
+	for _, e := range cp.Dependencies {
+		s.deps[e.FromId] = append(s.deps[e.FromId], e.ToId)
+	}
+	for id := range s.deps {
+		sort.Strings(s.deps[id])
+	}
+
+	s.initRegions()
+	for _, name := range cp.FailedRegions {
+		if r, ok := s.regions[name]; ok {
+			r.failed = true
+		}
+	}
+
+	s.drains = nil
+	if len(cp.DrainedNodes) > 0 {
+		s.drains = make(map[string]int64, len(cp.DrainedNodes))
+		for id, tick := range cp.DrainedNodes {
+			s.drains[id] = tick
+		}
+	}
+	s.leaks = make(map[string]float64, len(cp.MemoryLeaks))
+	for id, rate := range cp.MemoryLeaks {
+		s.leaks[id] = rate
+	}
+	s.oomKilled = make(map[string]bool, len(cp.OomKilled))
+	for _, id := range cp.OomKilled {
+		s.oomKilled[id] = true
+	}
+
+	s.faults = nil
+	s.effects = nil
+	s.breakers = nil
+	s.crashes = nil
+	s.placements = nil
+	s.crashedSince = nil
+	s.latencyBudgets = nil
+	if len(cp.LatencyBudgets) > 0 {
+		s.latencyBudgets = make(map[string]float64, len(cp.LatencyBudgets))
+		for id, budget := range cp.LatencyBudgets {
+			s.latencyBudgets[id] = budget
+		}
+	}
+	s.script = nil
+	s.dependencyNames = nil
+	s.topologySource = cp.TopologySource
+
+	now := time.Now()
+	s.tickID = cp.TickId
+	s.simTimeUnixMs = cp.SimTimeUnixMs
+	s.simBaseMs = cp.SimTimeUnixMs
+	s.startWallTime = now
+	s.pausedSince = time.Time{}
+	s.speedMult = cp.SpeedMultiplier
+	if s.speedMult <= 0 {
+		s.speedMult = 1.0
+	}
+	s.simState = cp.SimState
+	if s.simState != commonv1.SimulationState_SIMULATION_STATE_RUNNING {
+		s.pausedSince = now
+	}
+	s.scenario = cp.Scenario
+	s.chaos = nil
+	s.customMetrics = nil
+	if sc, ok := LookupScenario(s.scenario); ok {
+		if sc.Chaos != nil {
+			c := *sc.Chaos
+			s.chaos = &c
+		}
+		// Services carry their custom metric values; only the channels are restored
+		s.customMetrics = sc.CustomMetrics
+	}
+	s.traffic = make(map[string]trafficAssignment)
+	s.applyTrafficProfiles()
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"time"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+)
+
+// Sim time advances only while running, at speedMult times wall-clock speed.
+// Every pause or speed change folds the elapsed segment into simBaseMs and
+// starts a new segment at startWallTime, so sim time never jumps.
+
+// simNow returns the sim time at wall time now. Caller must hold mu.
+func (s *State) simNow(now time.Time) int64 {
+	if s.simState != commonv1.SimulationState_SIMULATION_STATE_RUNNING {
+		return s.simBaseMs
+	}
+	elapsed := now.Sub(s.startWallTime)
+	return s.simBaseMs + int64(float64(elapsed.Milliseconds())*s.speedMult)
+}
+
+// foldSegment closes the current running segment at now. Caller must hold mu.
+func (s *State) foldSegment(now time.Time) {
+	s.simBaseMs = s.simNow(now)
+	s.startWallTime = now
+}
+
+// transition updates the clock for a change of simulation state. Caller must hold mu.
+func (s *State) transition(to commonv1.SimulationState, now time.Time) {
+	running := commonv1.SimulationState_SIMULATION_STATE_RUNNING
+	switch {
+	case s.simState == running && to != running:
+		s.foldSegment(now)
+		s.pausedSince = now
+	case s.simState != running && to == running:
+		if !s.pausedSince.IsZero() {
+			s.pausedTotal += now.Sub(s.pausedSince)
+			s.pausedSince = time.Time{}
+		}
+		s.startWallTime = now
+	}
+	s.simState = to
+}
+
+// advanceClock moves the tick's sim time forward, never backward. Caller must hold mu.
+func (s *State) advanceClock(now time.Time) {
+	s.simTimeUnixMs = max(s.simTimeUnixMs, s.simNow(now))
+}
+
+// SimTimeUnixMs returns the current simulated time
+func (s *State) SimTimeUnixMs() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.deterministic {
+		return s.simTimeUnixMs
+	}
+	return max(s.simTimeUnixMs, s.simNow(time.Now()))
+}
+
+// PausedDuration returns the total wall time spent paused or stopped after
+// having run, including the current pause
+func (s *State) PausedDuration() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	total := s.pausedTotal
+	if !s.pausedSince.IsZero() {
+		total += time.Since(s.pausedSince)
+	}
+	return total
+}
@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+)
+
+// Event types for operator-driven changes
+const (
+	EventSimulationStarted = "simulation_started"
+	EventSimulationPaused  = "simulation_paused"
+	EventSimulationStopped = "simulation_stopped"
+	EventSpeedChanged      = "speed_changed"
+	EventScenarioLoaded    = "scenario_loaded"
+)
+
+// SetSimState switches the simulation state and publishes a
+// simulation_started, _paused or _stopped event if it changed. It returns
+// the previous state.
+func (e *Engine) SetSimState(ctx context.Context, state commonv1.SimulationState) commonv1.SimulationState {
+	old := e.state.GetSimState()
+	e.state.SetSimState(state)
+	if old == state {
+		return old
+	}
+
+	var eventType string
+	switch state {
+	case commonv1.SimulationState_SIMULATION_STATE_RUNNING:
+		eventType = EventSimulationStarted
+	case commonv1.SimulationState_SIMULATION_STATE_PAUSED:
+		eventType = EventSimulationPaused
+	case commonv1.SimulationState_SIMULATION_STATE_STOPPED:
+		eventType = EventSimulationStopped
+	default:
+		return old
+	}
+
+	e.publishEvent(ctx, &simv1.SimulationEvent{
+		EventType:   eventType,
+		Description: fmt.Sprintf("Simulation %s", strings.TrimPrefix(eventType, "simulation_")),
+		Metadata: map[string]string{
+			"from": old.String(),
+			"to":   state.String(),
+		},
+	})
+	return old
+}
+
+// SetSpeed changes the speed multiplier and publishes a speed_changed
+// event. It returns the multiplier in effect, after clamping.
+func (e *Engine) SetSpeed(ctx context.Context, mult float64) float64 {
+	old := e.state.GetSpeedMultiplier()
+	e.state.SetSpeedMultiplier(mult)
+	current := e.state.GetSpeedMultiplier()
+	if current == old {
+		return current
+	}
+
+	e.publishEvent(ctx, &simv1.SimulationEvent{
+		EventType:   EventSpeedChanged,
+		Description: fmt.Sprintf("Simulation speed changed from %.1fx to %.1fx", old, current),
+		Metadata: map[string]string{
+			"from": fmt.Sprintf("%g", old),
+			"to":   fmt.Sprintf("%g", current),
+		},
+	})
+	return current
+}
+
+// LoadScenario activates a scenario and publishes a scenario_loaded event.
+// Parameters are recorded in the event metadata. topology.* parameters
+// first regenerate the cluster at the requested size.
+func (e *Engine) LoadScenario(ctx context.Context, name string, params map[string]string) error {
+	e.cmdMu.Lock()
+	defer e.cmdMu.Unlock()
+
+	topology, scenarioParams := splitTopologyParams(params)
+	if len(topology) > 0 {
+		size, err := e.state.TopologySize().WithParams(topology)
+		if err != nil {
+			return err
+		}
+		// Check the scenario's own params so a bad one leaves the cluster alone
+		if _, err := scenarioChaos(name, scenarioParams); err != nil {
+			return err
+		}
+		if err := e.state.GenerateTopology(size); err != nil {
+			return err
+		}
+		nodes, services := e.state.EntityCounts()
+		e.log.Info("regenerated topology", "nodes", nodes, "services", services, "zones", len(size.Zones))
+	}
+
+	old := e.state.GetScenario()
+	if err := e.state.SetScenario(name, scenarioParams); err != nil {
+		return err
+	}
+	e.logRun(RunLogEntry{
+		Kind:     RunLogScenario,
+		TickID:   e.state.GetTickID(),
+		Scenario: name,
+		Params:   params,
+	})
+
+	metadata := map[string]string{"from": old}
+	for k, v := range params {
+		metadata["param."+k] = v
+	}
+	e.publishEvent(ctx, &simv1.SimulationEvent{
+		EventType:   EventScenarioLoaded,
+		TargetId:    name,
+		Description: fmt.Sprintf("Scenario changed from %s to %s", old, name),
+		Metadata:    metadata,
+	})
+	return nil
+}
+
+// publishEvent stamps event with the current tick and publishes it.
+// Failures are logged; the change itself has already been applied.
+func (e *Engine) publishEvent(ctx context.Context, event *simv1.SimulationEvent) {
+	event.Timestamp = &commonv1.SimulationTimestamp{
+		TickId:         e.state.GetTickID(),
+		WallTimeUnixMs: time.Now().UnixMilli(),
+		SimTimeUnixMs:  e.state.SimTimeUnixMs(),
+	}
+	if err := e.publisher.PublishSimulationEvent(ctx, event); err != nil {
+		e.log.Error("failed to publish event", "event_type", event.EventType, "error", err)
+	}
+}
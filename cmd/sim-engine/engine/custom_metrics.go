@@ -0,0 +1,79 @@
+package engine
+
+// CustomMetric is a named metric channel a scenario registers on services,
+// carried in Service.custom_metrics alongside the built-in metrics. Values
+// start at Baseline and random-walk like the built-in metrics; script steps
+// may set or add to them by Name, and recover resets them to Baseline.
+type CustomMetric struct {
+	Name       string
+	Services   []string // service names or IDs; empty matches every service
+	Baseline   float64
+	Volatility float64 // largest random change per tick
+	Min        float64
+	Max        float64
+}
+
+// appliesTo reports whether the channel is registered on a service
+func (m CustomMetric) appliesTo(id, name string) bool {
+	if len(m.Services) == 0 {
+		return true
+	}
+	for _, target := range m.Services {
+		if target == id || target == name {
+			return true
+		}
+	}
+	return false
+}
+
+// resetCustomMetrics replaces every service's custom channels with the
+// active scenario's, starting at their baselines. Caller must hold mu.
+func (s *State) resetCustomMetrics(channels []CustomMetric) {
+	s.customMetrics = channels
+	for id, svc := range s.services {
+		svc.CustomMetrics = nil
+		for _, m := range channels {
+			if !m.appliesTo(id, svc.Name) {
+				continue
+			}
+			if svc.CustomMetrics == nil {
+				svc.CustomMetrics = make(map[string]float64, len(channels))
+			}
+			svc.CustomMetrics[m.Name] = m.Baseline
+		}
+	}
+}
+
+// updateCustomMetrics advances every custom channel by one tick. Caller
+// must hold mu.
+func (s *State) updateCustomMetrics() {
+	for _, m := range s.customMetrics {
+		for _, id := range sortedIDs(s.services) {
+			svc := s.services[id]
+			if !m.appliesTo(id, svc.Name) {
+				continue
+			}
+			if svc.CustomMetrics == nil {
+				// Services added after the scenario loaded start at baseline
+				svc.CustomMetrics = make(map[string]float64, len(s.customMetrics))
+				svc.CustomMetrics[m.Name] = m.Baseline
+			}
+			v, ok := svc.CustomMetrics[m.Name]
+			if !ok {
+				v = m.Baseline
+			}
+			svc.CustomMetrics[m.Name] = clamp(v+randDelta(s.rng, m.Volatility), m.Min, m.Max)
+		}
+	}
+}
+
+// customMetric returns the registered channel with the given name. Caller
+// must hold mu.
+func (s *State) customMetric(name string) (CustomMetric, bool) {
+	for _, m := range s.customMetrics {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return CustomMetric{}, false
+}
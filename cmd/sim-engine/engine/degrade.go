@@ -0,0 +1,242 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+)
+
+// Snapshot detail levels. The engine lowers detail one level at a time
+// while wall ticks overrun the tick budget, and restores it once ticks fit
+// comfortably again. Each level keeps the reductions of the ones before.
+const (
+	DetailFull       = 0
+	DetailReduced    = 1 // core metrics only: no labels, capacity, memory, queue or custom metrics
+	DetailHalfRate   = 2 // every other snapshot is skipped
+	DetailAggregated = 3 // one service per name, merged across instances
+)
+
+// Event types for detail changes
+const (
+	EventSnapshotDegraded = "snapshot_degraded"
+	EventSnapshotRestored = "snapshot_restored"
+)
+
+const (
+	// overrunTicks consecutive wall ticks over budget lower detail a level
+	overrunTicks = 5
+	// recoverTicks consecutive wall ticks under recoverShare of the budget
+	// raise it a level. The gap keeps the level from flapping.
+	recoverTicks = 50
+	recoverShare = 0.5
+)
+
+// degrader tracks the cost of each wall tick (steps, snapshot and publish)
+// against the tick budget. It is only used from the Run goroutine.
+type degrader struct {
+	level int
+	over  int
+	under int
+	frame uint64
+}
+
+// observe records a wall tick's cost and reports whether the level changed
+func (d *degrader) observe(cost, budget time.Duration) bool {
+	switch {
+	case cost > budget:
+		d.over++
+		d.under = 0
+		if d.over >= overrunTicks && d.level < DetailAggregated {
+			d.level++
+			d.over = 0
+			return true
+		}
+	case cost < time.Duration(float64(budget)*recoverShare):
+		d.under++
+		d.over = 0
+		if d.under >= recoverTicks && d.level > DetailFull {
+			d.level--
+			d.under = 0
+			return true
+		}
+	default:
+		d.over, d.under = 0, 0
+	}
+	return false
+}
+
+// skip reports whether to skip publishing this wall tick's snapshot
+func (d *degrader) skip() bool {
+	if d.level < DetailHalfRate {
+		return false
+	}
+	d.frame++
+	return d.frame%2 == 0
+}
+
+// degradeSnapshot returns snap reduced to level. The entities in snap are
+// shared with the state, so reduced ones are built fresh rather than edited.
+func degradeSnapshot(snap *simv1.MetricSnapshot, level int) *simv1.MetricSnapshot {
+	if level == DetailFull {
+		return snap
+	}
+	out := &simv1.MetricSnapshot{
+		Timestamp:   snap.Timestamp,
+		Traffic:     snap.Traffic,
+		Regions:     snap.Regions,
+		Sequence:    snap.Sequence,
+		DetailLevel: uint32(level),
+		Nodes:       make([]*simv1.Node, 0, len(snap.Nodes)),
+	}
+	for _, n := range snap.Nodes {
+		out.Nodes = append(out.Nodes, &simv1.Node{
+			Id:                 n.Id,
+			Name:               n.Name,
+			Status:             n.Status,
+			CpuUsagePercent:    n.CpuUsagePercent,
+			MemoryUsagePercent: n.MemoryUsagePercent,
+			DiskUsagePercent:   n.DiskUsagePercent,
+			RunningServices:    n.RunningServices,
+			AvailabilityZone:   n.AvailabilityZone,
+			Region:             n.Region,
+		})
+	}
+	if level >= DetailAggregated {
+		out.Services = aggregateServices(snap.Services)
+		return out
+	}
+	out.Services = make([]*simv1.Service, 0, len(snap.Services))
+	for _, svc := range snap.Services {
+		out.Services = append(out.Services, reducedService(svc))
+	}
+	return out
+}
+
+// reducedService keeps a service's identity and core health metrics
+func reducedService(svc *simv1.Service) *simv1.Service {
+	return &simv1.Service{
+		Id:                svc.Id,
+		Name:              svc.Name,
+		NodeId:            svc.NodeId,
+		Health:            svc.Health,
+		RequestsPerSecond: svc.RequestsPerSecond,
+		ErrorRatePercent:  svc.ErrorRatePercent,
+		LatencyP50Ms:      svc.LatencyP50Ms,
+		LatencyP99Ms:      svc.LatencyP99Ms,
+		ReplicaCount:      svc.ReplicaCount,
+		DesiredReplicas:   svc.DesiredReplicas,
+		Region:            svc.Region,
+	}
+}
+
+// aggregateServices merges the instances of each service name into one,
+// keyed by the lowest instance ID so it stays stable across snapshots.
+// Traffic and replicas add up, error rate and p50 are RPS-weighted, p99 and
+// health take the worst instance. Node and region are the key instance's.
+func aggregateServices(services []*simv1.Service) []*simv1.Service {
+	byName := make(map[string][]*simv1.Service)
+	for _, svc := range services {
+		byName[svc.Name] = append(byName[svc.Name], svc)
+	}
+
+	out := make([]*simv1.Service, 0, len(byName))
+	for _, instances := range byName {
+		sort.Slice(instances, func(i, j int) bool {
+			return instances[i].Id.GetValue() < instances[j].Id.GetValue()
+		})
+		agg := reducedService(instances[0])
+		if len(instances) == 1 {
+			out = append(out, agg)
+			continue
+		}
+
+		var rps, errWeighted, p50Weighted, p50Sum, errSum float64
+		agg.ReplicaCount, agg.DesiredReplicas = 0, 0
+		for _, svc := range instances {
+			rps += svc.RequestsPerSecond
+			errWeighted += svc.ErrorRatePercent * svc.RequestsPerSecond
+			p50Weighted += svc.LatencyP50Ms * svc.RequestsPerSecond
+			errSum += svc.ErrorRatePercent
+			p50Sum += svc.LatencyP50Ms
+			agg.LatencyP99Ms = max(agg.LatencyP99Ms, svc.LatencyP99Ms)
+			agg.ReplicaCount += svc.ReplicaCount
+			agg.DesiredReplicas += svc.DesiredReplicas
+			agg.Health = worseHealth(agg.Health, svc.Health)
+		}
+		agg.RequestsPerSecond = rps
+		if rps > 0 {
+			agg.ErrorRatePercent = errWeighted / rps
+			agg.LatencyP50Ms = p50Weighted / rps
+		} else {
+			agg.ErrorRatePercent = errSum / float64(len(instances))
+			agg.LatencyP50Ms = p50Sum / float64(len(instances))
+		}
+		out = append(out, agg)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// serviceHealthRank orders health from best to worst
+var serviceHealthRank = map[commonv1.ServiceHealth]int{
+	commonv1.ServiceHealth_SERVICE_HEALTH_HEALTHY:  0,
+	commonv1.ServiceHealth_SERVICE_HEALTH_DEGRADED: 1,
+	commonv1.ServiceHealth_SERVICE_HEALTH_CRITICAL: 2,
+	commonv1.ServiceHealth_SERVICE_HEALTH_DOWN:     3,
+}
+
+func worseHealth(a, b commonv1.ServiceHealth) commonv1.ServiceHealth {
+	if serviceHealthRank[b] > serviceHealthRank[a] {
+		return b
+	}
+	return a
+}
+
+// checkBudget moves snapshot detail up or down for a wall tick's cost and
+// publishes a degradation event when it changes
+func (e *Engine) checkBudget(ctx context.Context, cost time.Duration) {
+	if e.degrader == nil {
+		return
+	}
+	from := e.degrader.level
+	if !e.degrader.observe(cost, e.tickInterval) {
+		return
+	}
+	to := e.degrader.level
+	e.detailLevel.Store(int32(to))
+	if to > from {
+		e.log.Warn("ticks over budget, lowering snapshot detail", "level", to, "tick_cost", cost, "budget", e.tickInterval)
+	} else {
+		e.log.Info("ticks within budget, raising snapshot detail", "level", to)
+	}
+	e.publishEvent(ctx, degradationEvent(from, to, cost, e.tickInterval))
+}
+
+// DetailLevel returns the current snapshot detail level
+func (e *Engine) DetailLevel() int {
+	return int(e.detailLevel.Load())
+}
+
+// degradationEvent describes a detail level change
+func degradationEvent(from, to int, cost, budget time.Duration) *simv1.SimulationEvent {
+	event := &simv1.SimulationEvent{
+		EventType: EventSnapshotDegraded,
+		Description: fmt.Sprintf("Tick took %s of its %s budget; snapshot detail lowered to level %d",
+			cost.Round(time.Microsecond), budget, to),
+		Metadata: map[string]string{
+			"from_level": fmt.Sprintf("%d", from),
+			"to_level":   fmt.Sprintf("%d", to),
+			"tick_ms":    fmt.Sprintf("%.2f", float64(cost)/float64(time.Millisecond)),
+			"budget_ms":  fmt.Sprintf("%.2f", float64(budget)/float64(time.Millisecond)),
+		},
+	}
+	if to < from {
+		event.EventType = EventSnapshotRestored
+		event.Description = fmt.Sprintf("Ticks back within budget; snapshot detail raised to level %d", to)
+	}
+	return event
+}
@@ -0,0 +1,195 @@
+package engine
+
+import (
+	"math"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+)
+
+// deltaDeadbands is how far a metric may move from its last published value
+// before a delta carries the entity again, so random-walk noise does not
+// resend every entity every tick. Keyframes resend everything, bounding how
+// long a small drift goes unpublished.
+var deltaDeadbands = map[protoreflect.Name]float64{
+	"cpu_usage_percent":    1,
+	"memory_usage_percent": 1,
+	"disk_usage_percent":   1,
+	"error_rate_percent":   0.1,
+	"latency_p50_ms":       1,
+	"latency_p99_ms":       5,
+	"requests_per_second":  5,
+}
+
+// defaultDeltaDeadband applies to float metrics without their own,
+// including custom metrics
+const defaultDeltaDeadband = 0.5
+
+// deltaEncoder turns full snapshots into deltas that carry only the nodes
+// and services changed since their last published copy (a metric by more
+// than its deadband, any other field at all), with a full keyframe every
+// keyframeEvery snapshots and whenever the detail level changes, since
+// entities at different levels do not diff. It is only used from the Run
+// goroutine.
+type deltaEncoder struct {
+	keyframeEvery uint64
+	level         uint32 // detail level of the stored entities
+	nodes         map[string]*simv1.Node
+	services      map[string]*simv1.Service
+}
+
+func newDeltaEncoder(keyframeEvery int) *deltaEncoder {
+	return &deltaEncoder{keyframeEvery: uint64(keyframeEvery)}
+}
+
+// encode returns the snapshot to publish for a full snapshot with its
+// sequence already set
+func (d *deltaEncoder) encode(full *simv1.MetricSnapshot) *simv1.MetricSnapshot {
+	if d.nodes == nil || full.Sequence%d.keyframeEvery == 0 || full.DetailLevel != d.level {
+		d.level = full.DetailLevel
+		d.nodes = make(map[string]*simv1.Node, len(full.Nodes))
+		for _, n := range full.Nodes {
+			d.nodes[n.Id.GetValue()] = proto.Clone(n).(*simv1.Node)
+		}
+		d.services = make(map[string]*simv1.Service, len(full.Services))
+		for _, svc := range full.Services {
+			d.services[svc.Id.GetValue()] = proto.Clone(svc).(*simv1.Service)
+		}
+		return full
+	}
+
+	delta := &simv1.MetricSnapshot{
+		Timestamp:   full.Timestamp,
+		Traffic:     full.Traffic,
+		Regions:     full.Regions,
+		Sequence:    full.Sequence,
+		Delta:       true,
+		DetailLevel: full.DetailLevel,
+	}
+
+	seen := make(map[string]bool, len(full.Nodes)+len(full.Services))
+	for _, n := range full.Nodes {
+		id := n.Id.GetValue()
+		seen[id] = true
+		if prev, ok := d.nodes[id]; !ok || changed(prev, n) {
+			delta.Nodes = append(delta.Nodes, n)
+			d.nodes[id] = proto.Clone(n).(*simv1.Node)
+		}
+	}
+	for _, svc := range full.Services {
+		id := svc.Id.GetValue()
+		seen[id] = true
+		if prev, ok := d.services[id]; !ok || changed(prev, svc) {
+			delta.Services = append(delta.Services, svc)
+			d.services[id] = proto.Clone(svc).(*simv1.Service)
+		}
+	}
+
+	for id := range d.nodes {
+		if !seen[id] {
+			delta.RemovedIds = append(delta.RemovedIds, id)
+			delete(d.nodes, id)
+		}
+	}
+	for id := range d.services {
+		if !seen[id] {
+			delta.RemovedIds = append(delta.RemovedIds, id)
+			delete(d.services, id)
+		}
+	}
+	return delta
+}
+
+// changed reports whether cur differs from the last published prev: a float
+// metric by more than its deadband, any other field at all
+func changed(prev, cur proto.Message) bool {
+	p, c := prev.ProtoReflect(), cur.ProtoReflect()
+	fields := c.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		pv, cv := p.Get(fd), c.Get(fd)
+		switch {
+		case fd.Kind() == protoreflect.DoubleKind && fd.Cardinality() != protoreflect.Repeated:
+			if math.Abs(pv.Float()-cv.Float()) > deadband(fd.Name()) {
+				return true
+			}
+		case fd.IsMap() && fd.MapValue().Kind() == protoreflect.DoubleKind:
+			if floatMapChanged(pv.Map(), cv.Map()) {
+				return true
+			}
+		default:
+			if !pv.Equal(cv) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// floatMapChanged compares channels like custom metrics, each against the
+// default deadband
+func floatMapChanged(prev, cur protoreflect.Map) bool {
+	if prev.Len() != cur.Len() {
+		return true
+	}
+	moved := false
+	cur.Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+		pv := prev.Get(k)
+		moved = !pv.IsValid() || math.Abs(pv.Float()-v.Float()) > defaultDeltaDeadband
+		return !moved
+	})
+	return moved
+}
+
+func deadband(name protoreflect.Name) float64 {
+	if band, ok := deltaDeadbands[name]; ok {
+		return band
+	}
+	return defaultDeltaDeadband
+}
+
+// removalTracker remembers the entity IDs of the last published snapshot, so
+// keyframes and full snapshots can list the entities removed since, as
+// deltas do. Subscribers use them to tell a removed entity from one that
+// stopped reporting.
+type removalTracker struct {
+	ids map[string]bool
+}
+
+// update records the entities of snapshot, which must carry them all, and
+// returns the IDs of those in the previous snapshot that it lacks
+func (t *removalTracker) update(snapshot *simv1.MetricSnapshot) []string {
+	ids := make(map[string]bool, len(snapshot.Nodes)+len(snapshot.Services))
+	for _, n := range snapshot.Nodes {
+		ids[n.Id.GetValue()] = true
+	}
+	for _, svc := range snapshot.Services {
+		ids[svc.Id.GetValue()] = true
+	}
+
+	var removed []string
+	for id := range t.ids {
+		if !ids[id] {
+			removed = append(removed, id)
+		}
+	}
+	t.ids = ids
+	return removed
+}
+
+// withRemovedIDs returns a copy of snapshot listing removed. The snapshot
+// may be shared with the recorder, so it is not edited.
+func withRemovedIDs(snapshot *simv1.MetricSnapshot, removed []string) *simv1.MetricSnapshot {
+	return &simv1.MetricSnapshot{
+		Timestamp:   snapshot.Timestamp,
+		Nodes:       snapshot.Nodes,
+		Services:    snapshot.Services,
+		Traffic:     snapshot.Traffic,
+		Regions:     snapshot.Regions,
+		Sequence:    snapshot.Sequence,
+		RemovedIds:  removed,
+		DetailLevel: snapshot.DetailLevel,
+	}
+}
@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+)
+
+func TestDeltaSkipsNoiseUnderDeadband(t *testing.T) {
+	d := newDeltaEncoder(10)
+	node := &simv1.Node{Id: &commonv1.UUID{Value: "n1"}, Name: "node-alpha", CpuUsagePercent: 40}
+	svc := &simv1.Service{
+		Id:               &commonv1.UUID{Value: "s1"},
+		Name:             "api-gateway",
+		ErrorRatePercent: 1,
+		LatencyP99Ms:     50,
+		CustomMetrics:    map[string]float64{"gc_pause_ms": 10},
+	}
+	encode := func(seq uint64, n *simv1.Node, s *simv1.Service) *simv1.MetricSnapshot {
+		return d.encode(&simv1.MetricSnapshot{Sequence: seq, Nodes: []*simv1.Node{n}, Services: []*simv1.Service{s}})
+	}
+	if keyframe := encode(0, node, svc); keyframe.Delta {
+		t.Fatal("first snapshot should be a keyframe")
+	}
+
+	node = proto.Clone(node).(*simv1.Node)
+	node.CpuUsagePercent += 0.4
+	svc = proto.Clone(svc).(*simv1.Service)
+	svc.ErrorRatePercent += 0.05
+	svc.LatencyP99Ms -= 2
+	svc.CustomMetrics["gc_pause_ms"] += 0.2
+	delta := encode(1, node, svc)
+	if !delta.Delta || len(delta.Nodes) != 0 || len(delta.Services) != 0 {
+		t.Fatalf("noise under the deadband: %d nodes, %d services in delta, want none", len(delta.Nodes), len(delta.Services))
+	}
+
+	// Drift adds up against the last published value
+	node = proto.Clone(node).(*simv1.Node)
+	node.CpuUsagePercent += 0.8
+	if delta := encode(2, node, svc); len(delta.Nodes) != 1 || len(delta.Services) != 0 {
+		t.Errorf("drift past the deadband: %d nodes, %d services in delta, want 1 node", len(delta.Nodes), len(delta.Services))
+	}
+
+	// Fields other than metrics have no deadband
+	svc = proto.Clone(svc).(*simv1.Service)
+	svc.Health = commonv1.ServiceHealth_SERVICE_HEALTH_DEGRADED
+	if delta := encode(3, node, svc); len(delta.Nodes) != 0 || len(delta.Services) != 1 {
+		t.Errorf("health change: %d nodes, %d services in delta, want 1 service", len(delta.Nodes), len(delta.Services))
+	}
+}
@@ -0,0 +1,159 @@
+package engine
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+)
+
+// Dynamics drives the per-tick drift of node and service metrics. The state
+// derives health, applies traffic profiles, scenarios, faults and action
+// effects around it, so implementations only model how raw metrics evolve.
+// Methods are called with the state lock held and must not block. On large
+// clusters UpdateNode and UpdateService run concurrently for different
+// entities, so any state shared between entities needs its own locking.
+type Dynamics interface {
+	Name() string
+
+	// UpdateNode advances a node's utilization by one tick
+	UpdateNode(t TickInfo, node *simv1.Node)
+
+	// UpdateService advances a service's error rate and latency by one tick
+	// and returns its next base request rate, before traffic profiles apply
+	UpdateService(t TickInfo, svc *simv1.Service, baseRPS float64) float64
+
+	// OnAction is called after an action has been applied to targetID
+	OnAction(t TickInfo, actionType commonv1.ActionType, targetID string)
+}
+
+// TickInfo describes the tick being computed
+type TickInfo struct {
+	TickID   int64
+	Scenario string
+
+	rng *rand.Rand // the source for this shard of entities
+}
+
+// Built-in dynamics names
+const (
+	DynamicsRandomWalk    = "random_walk"
+	DynamicsMeanReverting = "mean_reverting"
+	DynamicsStatic        = "static"
+)
+
+var dynamicsRegistry = map[string]func() Dynamics{
+	DynamicsRandomWalk:    func() Dynamics { return RandomWalkDynamics{} },
+	DynamicsMeanReverting: func() Dynamics { return NewMeanRevertingDynamics() },
+	DynamicsStatic:        func() Dynamics { return StaticDynamics{} },
+}
+
+// RegisterDynamics adds or replaces a dynamics implementation.
+// It is not safe for concurrent use and should be called before the engine starts.
+func RegisterDynamics(name string, factory func() Dynamics) {
+	dynamicsRegistry[name] = factory
+}
+
+// NewDynamics returns a fresh instance of the named dynamics
+func NewDynamics(name string) (Dynamics, error) {
+	factory, ok := dynamicsRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown dynamics: %s", name)
+	}
+	return factory(), nil
+}
+
+// DynamicsNames lists the registered dynamics in sorted order
+func DynamicsNames() []string {
+	names := make([]string, 0, len(dynamicsRegistry))
+	for name := range dynamicsRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RandomWalkDynamics applies bounded uniform noise every tick. It is the
+// original engine behavior and the default.
+type RandomWalkDynamics struct{}
+
+func (RandomWalkDynamics) Name() string { return DynamicsRandomWalk }
+
+func (RandomWalkDynamics) UpdateNode(t TickInfo, node *simv1.Node) {
+	node.CpuUsagePercent = clamp(node.CpuUsagePercent+randDelta(t.rng, 5), 0, 100)
+	node.MemoryUsagePercent = clamp(node.MemoryUsagePercent+randDelta(t.rng, 2), 0, 100)
+	node.DiskUsagePercent = clamp(node.DiskUsagePercent+randDelta(t.rng, 0.5), 0, 100)
+}
+
+func (RandomWalkDynamics) UpdateService(t TickInfo, svc *simv1.Service, baseRPS float64) float64 {
+	svc.ErrorRatePercent = clamp(svc.ErrorRatePercent+randDelta(t.rng, 0.5), 0, 100)
+	svc.LatencyP50Ms = clamp(svc.LatencyP50Ms+randDelta(t.rng, 2), 1, 1000)
+	svc.LatencyP99Ms = clamp(svc.LatencyP99Ms+randDelta(t.rng, 10), svc.LatencyP50Ms, 5000)
+	return clamp(baseRPS+randDelta(t.rng, 50), 0, 10000)
+}
+
+func (RandomWalkDynamics) OnAction(TickInfo, commonv1.ActionType, string) {}
+
+// MeanRevertingDynamics adds noise but pulls every metric back toward the
+// value it had when first seen, so incidents heal on their own over time
+// instead of drifting indefinitely
+type MeanRevertingDynamics struct {
+	// Reversion is the fraction of the gap to the anchor closed per tick
+	Reversion float64
+
+	mu      sync.Mutex
+	anchors map[string]float64 // "<entity id>:<metric>" -> long-run mean
+}
+
+// NewMeanRevertingDynamics creates mean-reverting dynamics with default strength
+func NewMeanRevertingDynamics() *MeanRevertingDynamics {
+	return &MeanRevertingDynamics{Reversion: 0.02, anchors: make(map[string]float64)}
+}
+
+func (d *MeanRevertingDynamics) Name() string { return DynamicsMeanReverting }
+
+// revert moves v toward the anchor recorded for key and adds noise
+func (d *MeanRevertingDynamics) revert(r *rand.Rand, key string, v, noise float64) float64 {
+	d.mu.Lock()
+	anchor, ok := d.anchors[key]
+	if !ok {
+		anchor = v
+		d.anchors[key] = v
+	}
+	d.mu.Unlock()
+	return v + (anchor-v)*d.Reversion + randDelta(r, noise)
+}
+
+func (d *MeanRevertingDynamics) UpdateNode(t TickInfo, node *simv1.Node) {
+	id := node.Id.GetValue()
+	node.CpuUsagePercent = clamp(d.revert(t.rng, id+":cpu", node.CpuUsagePercent, 5), 0, 100)
+	node.MemoryUsagePercent = clamp(d.revert(t.rng, id+":memory", node.MemoryUsagePercent, 2), 0, 100)
+	node.DiskUsagePercent = clamp(d.revert(t.rng, id+":disk", node.DiskUsagePercent, 0.5), 0, 100)
+}
+
+func (d *MeanRevertingDynamics) UpdateService(t TickInfo, svc *simv1.Service, baseRPS float64) float64 {
+	id := svc.Id.GetValue()
+	svc.ErrorRatePercent = clamp(d.revert(t.rng, id+":errors", svc.ErrorRatePercent, 0.5), 0, 100)
+	svc.LatencyP50Ms = clamp(d.revert(t.rng, id+":p50", svc.LatencyP50Ms, 2), 1, 1000)
+	svc.LatencyP99Ms = clamp(d.revert(t.rng, id+":p99", svc.LatencyP99Ms, 10), svc.LatencyP50Ms, 5000)
+	return clamp(d.revert(t.rng, id+":rps", baseRPS, 50), 0, 10000)
+}
+
+func (d *MeanRevertingDynamics) OnAction(TickInfo, commonv1.ActionType, string) {}
+
+// StaticDynamics leaves metrics untouched so only scenarios, faults and
+// actions change them. Useful for deterministic demos.
+type StaticDynamics struct{}
+
+func (StaticDynamics) Name() string { return DynamicsStatic }
+
+func (StaticDynamics) UpdateNode(TickInfo, *simv1.Node) {}
+
+func (StaticDynamics) UpdateService(_ TickInfo, _ *simv1.Service, baseRPS float64) float64 {
+	return baseRPS
+}
+
+func (StaticDynamics) OnAction(TickInfo, commonv1.ActionType, string) {}
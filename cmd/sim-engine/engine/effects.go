@@ -0,0 +1,168 @@
+package engine
+
+import (
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+)
+
+// Durations of gradual action effects, in ticks
+const (
+	RestartRecoveryTicks  = 20
+	RestartDowntimeTicks  = 3
+	ScaleUpWarmupTicks    = 10
+	FlagRolloutTicks      = 30
+	DefaultCacheWarmTicks = 20
+	MaxCacheWarmTicks     = 1000
+
+	// flagResidualErrors is the share of errors left once a flag is off
+	flagResidualErrors = 0.2
+
+	// warmCacheLatency is the latency factor of a fully warmed cache
+	warmCacheLatency = 0.6
+)
+
+// effect is a mutation spread over several ticks. step is called once per
+// tick with progress in (0, 1] and must tolerate the target having vanished.
+type effect struct {
+	targetID      string
+	startTick     int64
+	durationTicks int64
+	step          func(s *State, progress float64)
+}
+
+// scheduleEffect queues an effect starting at the current tick. Caller must hold mu.
+func (s *State) scheduleEffect(targetID string, durationTicks int64, step func(s *State, progress float64)) {
+	s.effects = append(s.effects, &effect{
+		targetID:      targetID,
+		startTick:     s.tickID,
+		durationTicks: durationTicks,
+		step:          step,
+	})
+}
+
+// runEffects advances all pending effects. Caller must hold mu.
+func (s *State) runEffects() {
+	pending := s.effects[:0]
+	for _, e := range s.effects {
+		elapsed := s.tickID - e.startTick
+		progress := 1.0
+		if e.durationTicks > 0 && elapsed < e.durationTicks {
+			progress = float64(elapsed) / float64(e.durationTicks)
+		}
+		e.step(s, progress)
+		if progress < 1 {
+			pending = append(pending, e)
+		}
+	}
+	s.effects = pending
+}
+
+// hasPendingEffect reports whether an effect is still running on the target.
+// Caller must hold mu.
+func (s *State) hasPendingEffect(targetID string) bool {
+	for _, e := range s.effects {
+		if e.targetID == targetID {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduleRestart takes the service down for RestartDowntimeTicks, serving
+// no traffic, then eases error rate and latency back to a healthy baseline
+// over the rest of the recovery. Caller must hold mu.
+func (s *State) scheduleRestart(serviceID string) {
+	svc, ok := s.services[serviceID]
+	if !ok {
+		return
+	}
+	errStart, p50Start, p99Start := svc.ErrorRatePercent, svc.LatencyP50Ms, svc.LatencyP99Ms
+	s.clearMemory(serviceID)
+
+	s.scheduleEffect(serviceID, RestartRecoveryTicks, func(s *State, p float64) {
+		svc, ok := s.services[serviceID]
+		if !ok {
+			return
+		}
+		downtime := float64(RestartDowntimeTicks) / RestartRecoveryTicks
+		if p <= downtime {
+			svc.RequestsPerSecond = 0
+			svc.Health = commonv1.ServiceHealth_SERVICE_HEALTH_DOWN
+			return
+		}
+		recovery := (p - downtime) / (1 - downtime)
+		svc.ErrorRatePercent = lerp(errStart, 0.1, recovery)
+		svc.LatencyP50Ms = lerp(p50Start, 5, recovery)
+		svc.LatencyP99Ms = lerp(p99Start, 20, recovery)
+		if p >= 1 {
+			svc.Health = commonv1.ServiceHealth_SERVICE_HEALTH_HEALTHY
+		} else {
+			svc.Health = commonv1.ServiceHealth_SERVICE_HEALTH_DEGRADED
+		}
+	})
+}
+
+// scheduleScaleUp adds a replica that warms up over several ticks, easing
+// latency down in proportion to the added capacity. Caller must hold mu.
+func (s *State) scheduleScaleUp(serviceID string) {
+	svc, ok := s.services[serviceID]
+	if !ok {
+		return
+	}
+	svc.DesiredReplicas = svc.ReplicaCount + 1
+	ratio := float64(svc.ReplicaCount) / float64(svc.DesiredReplicas)
+	p50Start, p99Start := svc.LatencyP50Ms, svc.LatencyP99Ms
+
+	s.scheduleEffect(serviceID, ScaleUpWarmupTicks, func(s *State, p float64) {
+		svc, ok := s.services[serviceID]
+		if !ok {
+			return
+		}
+		svc.LatencyP50Ms = clamp(lerp(p50Start, p50Start*ratio, p), 1, 1000)
+		svc.LatencyP99Ms = clamp(lerp(p99Start, p99Start*ratio, p), svc.LatencyP50Ms, 5000)
+		if p >= 1 {
+			svc.ReplicaCount = svc.DesiredReplicas
+		}
+	})
+}
+
+// scheduleFlagDisable rolls a feature flag off across the fleet, easing the
+// error rate it caused down to flagResidualErrors of its current value.
+// Caller must hold mu.
+func (s *State) scheduleFlagDisable(serviceID string) {
+	svc, ok := s.services[serviceID]
+	if !ok {
+		return
+	}
+	errStart := svc.ErrorRatePercent
+
+	s.scheduleEffect(serviceID, FlagRolloutTicks, func(s *State, p float64) {
+		svc, ok := s.services[serviceID]
+		if !ok {
+			return
+		}
+		svc.ErrorRatePercent = clamp(lerp(errStart, errStart*flagResidualErrors, p), 0, 100)
+	})
+}
+
+// scheduleCacheWarm preloads a service cache over the given number of ticks,
+// easing latency down as the hit rate climbs. Caller must hold mu.
+func (s *State) scheduleCacheWarm(serviceID string, ticks int64) {
+	svc, ok := s.services[serviceID]
+	if !ok {
+		return
+	}
+	p50Start, p99Start := svc.LatencyP50Ms, svc.LatencyP99Ms
+
+	s.scheduleEffect(serviceID, ticks, func(s *State, p float64) {
+		svc, ok := s.services[serviceID]
+		if !ok {
+			return
+		}
+		svc.LatencyP50Ms = clamp(lerp(p50Start, p50Start*warmCacheLatency, p), 1, 1000)
+		svc.LatencyP99Ms = clamp(lerp(p99Start, p99Start*warmCacheLatency, p), svc.LatencyP50Ms, 5000)
+	})
+}
+
+func lerp(from, to, p float64) float64 {
+	return from + (to-from)*p
+}
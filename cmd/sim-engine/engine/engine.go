@@ -2,7 +2,10 @@ package engine
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/microcloud/bus"
@@ -21,16 +24,148 @@ type Engine struct {
 	log       *slog.Logger
 
 	tickInterval time.Duration
+	recorder     *Recorder
+	standby      atomic.Bool // true while another instance owns the tick loop
+	preroll      atomic.Int64
+	deltas       *deltaEncoder
+	removals     removalTracker // Run only
+	sequence     uint64
+	metrics      *engineMetrics
+	stepCredit   float64 // fractional steps carried between wall ticks; Run only
+	profile      *tickProfile
+	degrader     *degrader    // nil with WithFixedDetail; Run only
+	detailLevel  atomic.Int32 // degrader level, for metrics and stats
+
+	// Run log (see WithRunLog). cmdMu keeps commands between tick steps so
+	// each is logged at the tick it took effect.
+	cmdMu        sync.Mutex
+	runLogDir    string
+	runSeed      int64
+	runID        string
+	simStartMs   int64
+	topology     *TopologySpec
+	topologySize *TopologySize
+	runLogOnce   sync.Once
+	runLog       *runLog
+	replay       *runReplay // set by ReplayRun
+
+	executed commandResults // by ExecuteCommand, to dedupe redeliveries
+}
+
+// Option configures the Engine
+type Option func(*Engine)
+
+// WithRecorder records every published snapshot for later replay
+func WithRecorder(r *Recorder) Option {
+	return func(e *Engine) {
+		e.recorder = r
+	}
+}
+
+// WithDynamics replaces the default random-walk metric dynamics
+func WithDynamics(d Dynamics) Option {
+	return func(e *Engine) {
+		e.state.SetDynamics(d)
+	}
+}
+
+// WithTopology replaces the generated cluster with one loaded from a topology file
+func WithTopology(spec *TopologySpec) Option {
+	return func(e *Engine) {
+		e.state.LoadTopology(spec)
+		e.topology = spec
+	}
+}
+
+// WithTopologySize replaces the generated cluster with one of the given size.
+// Invalid sizes are ignored; check them with TopologySize.Validate first.
+func WithTopologySize(size TopologySize) Option {
+	return func(e *Engine) {
+		if err := e.state.GenerateTopology(size); err != nil {
+			return
+		}
+		e.topologySize = &size
+	}
+}
+
+// WithTickWorkers sets how many goroutines update nodes and services in
+// parallel on large clusters (default GOMAXPROCS)
+func WithTickWorkers(n int) Option {
+	return func(e *Engine) {
+		e.state.SetTickWorkers(n)
+	}
+}
+
+// WithStandby starts the engine as a warm standby that does not tick until
+// SetActive(true) is called
+func WithStandby() Option {
+	return func(e *Engine) {
+		e.standby.Store(true)
+	}
+}
+
+// WithPreroll runs n ticks without publishing before the simulation first
+// starts, so detectors see settled metrics instead of the initial random values
+func WithPreroll(n int) Option {
+	return func(e *Engine) {
+		e.preroll.Store(int64(n))
+	}
+}
+
+// WithDeltaSnapshots publishes only changed nodes and services, with a full
+// keyframe every keyframeEvery snapshots. Recordings keep full snapshots.
+func WithDeltaSnapshots(keyframeEvery int) Option {
+	return func(e *Engine) {
+		if keyframeEvery > 1 {
+			e.deltas = newDeltaEncoder(keyframeEvery)
+		}
+	}
+}
+
+// WithRunLog seeds the simulation and appends every scenario change, fault
+// and action command to dir/<run id>.jsonl, so ReplayRun can re-derive the
+// run. It replaces the state, so it must come before the other options.
+func WithRunLog(dir string, seed int64) Option {
+	return func(e *Engine) {
+		e.state = newSeededState(seed)
+		e.runLogDir = dir
+		e.runSeed = seed
+		e.runID = fmt.Sprintf("%s-%d", time.Now().UTC().Format("20060102T150405Z"), seed)
+		e.simStartMs = e.state.SimTimeUnixMs()
+	}
+}
+
+// WithTickProfiling times each phase of the tick loop (node and service
+// updates, effects, snapshot build, publish) for /metrics and Stats
+func WithTickProfiling() Option {
+	return func(e *Engine) {
+		e.profile = newTickProfile()
+		e.state.profile = e.profile
+	}
+}
+
+// WithFixedDetail always publishes full snapshots, even when ticks overrun
+// the tick budget
+func WithFixedDetail() Option {
+	return func(e *Engine) {
+		e.degrader = nil
+	}
 }
 
 // New creates a new simulation engine
-func New(publisher *bus.Publisher, log *slog.Logger) *Engine {
-	return &Engine{
+func New(publisher *bus.Publisher, log *slog.Logger, opts ...Option) *Engine {
+	e := &Engine{
 		state:        NewState(),
 		publisher:    publisher,
 		log:          log,
 		tickInterval: DefaultTickInterval,
+		metrics:      newEngineMetrics(),
+		degrader:     &degrader{},
+	}
+	for _, opt := range opts {
+		opt(e)
 	}
+	return e
 }
 
 // State returns the simulation state for the control server
@@ -38,6 +173,29 @@ func (e *Engine) State() *State {
 	return e.state
 }
 
+// Preroll runs the pending warm-up ticks, if any, and returns how many ran.
+// Only the first call does any work. Call it before switching to RUNNING.
+func (e *Engine) Preroll() int64 {
+	n := e.preroll.Swap(0)
+	e.cmdMu.Lock()
+	defer e.cmdMu.Unlock()
+	for i := int64(0); i < n; i++ {
+		e.state.Tick(e.tickInterval)
+	}
+	return n
+}
+
+// SetActive starts or stops ticking without stopping Run, for handing the
+// tick loop between a primary and a standby
+func (e *Engine) SetActive(active bool) {
+	e.standby.Store(!active)
+}
+
+// Active reports whether this instance is running the tick loop
+func (e *Engine) Active() bool {
+	return !e.standby.Load()
+}
+
 // Run starts the simulation loop (blocking)
 func (e *Engine) Run(ctx context.Context) error {
 	ticker := time.NewTicker(e.tickInterval)
@@ -48,19 +206,69 @@ func (e *Engine) Run(ctx context.Context) error {
 	for {
 		select {
 		case <-ctx.Done():
+			if e.recorder != nil {
+				if err := e.recorder.Flush(); err != nil {
+					e.log.Error("failed to flush recording", "error", err)
+				}
+			}
 			e.log.Info("simulation engine stopped")
 			return ctx.Err()
 		case <-ticker.C:
-			if e.state.GetSimState() != commonv1.SimulationState_SIMULATION_STATE_RUNNING {
+			if !e.Active() || e.state.GetSimState() != commonv1.SimulationState_SIMULATION_STATE_RUNNING {
+				continue
+			}
+
+			steps := e.steps()
+			if steps == 0 {
+				continue
+			}
+			tickStart := time.Now()
+			for i := 0; i < steps; i++ {
+				e.step(ctx)
+			}
+			e.metrics.observeTick(time.Since(tickStart))
+			if e.degrader != nil && e.degrader.skip() {
+				if e.profile != nil {
+					e.profile.endTick()
+				}
+				e.checkBudget(ctx, time.Since(tickStart))
 				continue
 			}
 
-			e.state.Tick(e.tickInterval)
+			start := time.Now()
 			snapshot := e.state.Snapshot()
+			e.sequence++
+			snapshot.Sequence = e.sequence
 
-			if err := e.publisher.PublishMetricSnapshot(ctx, snapshot); err != nil {
+			published := snapshot
+			if e.degrader != nil {
+				published = degradeSnapshot(snapshot, e.degrader.level)
+			}
+			removed := e.removals.update(published)
+			if e.deltas != nil {
+				published = e.deltas.encode(published)
+			}
+			if !published.Delta && len(removed) > 0 {
+				published = withRemovedIDs(published, removed)
+			}
+			e.profilePhase(phaseSnapshot, start)
+			start = time.Now()
+			if err := e.publisher.PublishMetricSnapshot(ctx, published); err != nil {
+				e.metrics.publishFailed()
 				e.log.Error("failed to publish metrics", "error", err)
 			}
+			e.profilePhase(phasePublish, start)
+			if e.profile != nil {
+				e.profile.endTick()
+			}
+			e.checkBudget(ctx, time.Since(tickStart))
+
+			if e.recorder != nil {
+				if err := e.recorder.Record(snapshot); err != nil {
+					e.metrics.recordFailed()
+					e.log.Error("failed to record snapshot", "error", err)
+				}
+			}
 
 			if e.state.GetTickID()%100 == 0 {
 				e.log.Debug("tick", "tick_id", snapshot.Timestamp.TickId, "nodes", len(snapshot.Nodes), "services", len(snapshot.Services))
@@ -69,67 +277,355 @@ func (e *Engine) Run(ctx context.Context) error {
 	}
 }
 
+// steps returns how many simulation steps to run this wall tick: the speed
+// multiplier, with the fraction carried over so 0.5x steps every other tick.
+// Snapshots stay at the wall tick rate while dynamics run speed times faster.
+func (e *Engine) steps() int {
+	e.stepCredit += e.state.GetSpeedMultiplier()
+	n := int(e.stepCredit + 1e-9)
+	e.stepCredit -= float64(n)
+	return n
+}
+
+// InjectFault injects a fault into the simulation and publishes a fault_injected event
+func (e *Engine) InjectFault(ctx context.Context, targetID, faultType string, magnitude float64, duration time.Duration) (Fault, error) {
+	e.cmdMu.Lock()
+	defer e.cmdMu.Unlock()
+
+	tick := e.state.GetTickID()
+	fault, err := e.state.InjectFault(targetID, faultType, magnitude, duration)
+	if err != nil {
+		return Fault{}, err
+	}
+	e.logRun(RunLogEntry{
+		Kind:       RunLogFault,
+		TickID:     tick,
+		TargetID:   targetID,
+		FaultType:  faultType,
+		Magnitude:  magnitude,
+		DurationMs: duration.Milliseconds(),
+	})
+
+	event := &simv1.SimulationEvent{
+		Timestamp: &commonv1.SimulationTimestamp{
+			TickId:         e.state.GetTickID(),
+			WallTimeUnixMs: time.Now().UnixMilli(),
+		},
+		EventType:   "fault_injected",
+		TargetId:    targetID,
+		Description: fmt.Sprintf("Injected %s fault (magnitude %.2f) for %s", faultType, magnitude, duration),
+		Metadata: map[string]string{
+			"fault_id":   fault.ID,
+			"fault_type": faultType,
+		},
+	}
+	if err := e.publisher.PublishSimulationEvent(ctx, event); err != nil {
+		e.log.Error("failed to publish event", "error", err)
+	}
+
+	return fault, nil
+}
+
 // ApplyCommand applies an action command to the simulation
 func (e *Engine) ApplyCommand(ctx context.Context, actionType commonv1.ActionType, targetID string, params map[string]string) (*simv1.SimulationEvent, error) {
+	e.cmdMu.Lock()
+	defer e.cmdMu.Unlock()
+
+	tick := e.state.GetTickID()
+	event, err := e.applyCommand(ctx, actionType, targetID, params)
+	if err != nil {
+		return nil, err
+	}
+	e.logRun(RunLogEntry{
+		Kind:       RunLogAction,
+		TickID:     tick,
+		ActionType: actionType.String(),
+		TargetID:   targetID,
+		Params:     params,
+	})
+	return event, nil
+}
+
+// applyCommand applies an action command. Caller must hold cmdMu.
+func (e *Engine) applyCommand(ctx context.Context, actionType commonv1.ActionType, targetID string, params map[string]string) (*simv1.SimulationEvent, error) {
 	e.state.mu.Lock()
-	defer e.state.mu.Unlock()
+	event, followUps, err := e.state.applyAction(actionType, targetID, params)
+	e.state.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := e.publisher.PublishSimulationEvent(ctx, event); err != nil {
+		e.log.Error("failed to publish event", "error", err)
+	}
+	for _, followUp := range followUps {
+		if err := e.publisher.PublishSimulationEvent(ctx, followUp); err != nil {
+			e.log.Error("failed to publish event", "error", err)
+		}
+	}
 
+	return event, nil
+}
+
+// applyAction applies an action to the state and returns its event, with
+// an empty type if the action does not fit the target, and any follow-up
+// events. Caller must hold mu.
+func (s *State) applyAction(actionType commonv1.ActionType, targetID string, params map[string]string) (*simv1.SimulationEvent, []*simv1.SimulationEvent, error) {
 	event := &simv1.SimulationEvent{
 		Timestamp: &commonv1.SimulationTimestamp{
-			TickId:        e.state.tickID,
+			TickId:         s.tickID,
 			WallTimeUnixMs: time.Now().UnixMilli(),
-			SimTimeUnixMs:  e.state.simTimeUnixMs,
+			SimTimeUnixMs:  s.simTimeUnixMs,
 		},
 		TargetId: targetID,
 		Metadata: params,
 	}
+	var followUps []*simv1.SimulationEvent // published after event, e.g. per-service migrations
 
 	switch actionType {
 	case commonv1.ActionType_ACTION_TYPE_RESTART_SERVICE:
-		if svc, ok := e.state.services[targetID]; ok {
-			svc.Health = commonv1.ServiceHealth_SERVICE_HEALTH_HEALTHY
-			svc.ErrorRatePercent = 0.1
-			svc.LatencyP50Ms = 5
-			svc.LatencyP99Ms = 20
+		if _, ok := s.services[targetID]; ok {
+			if s.hasPendingEffect(targetID) {
+				return nil, nil, fmt.Errorf("service %s has an action in progress", targetID)
+			}
+			s.scheduleRestart(targetID)
 			event.EventType = "service_restarted"
-			event.Description = "Service restarted successfully"
+			event.Description = fmt.Sprintf("Service restarting; down for %d ticks, recovered within %d ticks",
+				RestartDowntimeTicks, RestartRecoveryTicks)
+			event.Metadata = mergeMetadata(params, map[string]string{
+				"downtime_ticks": fmt.Sprintf("%d", RestartDowntimeTicks),
+			})
 		}
 
 	case commonv1.ActionType_ACTION_TYPE_SCALE_UP:
-		if svc, ok := e.state.services[targetID]; ok {
-			svc.ReplicaCount++
-			svc.DesiredReplicas = svc.ReplicaCount
+		if _, ok := s.services[targetID]; ok {
+			if s.hasPendingEffect(targetID) {
+				return nil, nil, fmt.Errorf("service %s has an action in progress", targetID)
+			}
+			requested := s.nodeCPURequestedPercent(targetID, s.services[targetID].ReplicaCount+1)
+			s.scheduleScaleUp(targetID)
 			event.EventType = "service_scaled_up"
-			event.Description = "Service scaled up"
+			event.Description = fmt.Sprintf("Service scaling up; new replica ready in %d ticks", ScaleUpWarmupTicks)
+			event.Metadata = mergeMetadata(params, map[string]string{
+				"node_cpu_requested_percent": fmt.Sprintf("%.1f", requested),
+			})
 		}
 
 	case commonv1.ActionType_ACTION_TYPE_SCALE_DOWN:
-		if svc, ok := e.state.services[targetID]; ok && svc.ReplicaCount > 1 {
-			svc.ReplicaCount--
-			svc.DesiredReplicas = svc.ReplicaCount
+		if svc, ok := s.services[targetID]; ok && svc.ReplicaCount > 1 {
+			if s.hasPendingEffect(targetID) {
+				return nil, nil, fmt.Errorf("service %s has an action in progress", targetID)
+			}
+			perReplica := s.scheduleScaleDown(targetID)
 			event.EventType = "service_scaled_down"
-			event.Description = "Service scaled down"
+			event.Description = fmt.Sprintf("Service scaled down; %d replicas absorbing the load over %d ticks", svc.ReplicaCount, RebalanceTicks)
+			event.Metadata = mergeMetadata(params, map[string]string{
+				"per_replica_rps": fmt.Sprintf("%.1f", perReplica),
+			})
 		}
 
 	case commonv1.ActionType_ACTION_TYPE_DRAIN_NODE:
-		if node, ok := e.state.nodes[targetID]; ok {
-			node.Status = commonv1.NodeStatus_NODE_STATUS_OFFLINE
-			node.RunningServices = 0
+		if _, ok := s.nodes[targetID]; ok {
+			moved, stranded := s.drainNode(targetID)
 			event.EventType = "node_drained"
-			event.Description = "Node drained and offline"
+			event.Description = fmt.Sprintf("Node drained and offline; %d services rescheduled onto other nodes", len(moved))
+			if stranded > 0 {
+				event.Description += fmt.Sprintf(", %.0f RPS moving to other instances over %d ticks", stranded, RebalanceTicks)
+			}
+			event.Metadata = mergeMetadata(params, map[string]string{
+				"migrated_services": fmt.Sprintf("%d", len(moved)),
+				"stranded_rps":      fmt.Sprintf("%.1f", stranded),
+			})
+			for _, m := range moved {
+				followUps = append(followUps, &simv1.SimulationEvent{
+					Timestamp:   event.Timestamp,
+					EventType:   "service_migrated",
+					TargetId:    m.service.Id.Value,
+					Description: fmt.Sprintf("Service %s migrated to %s after node drain", m.service.Name, m.to.Name),
+					Metadata: map[string]string{
+						"from_node_id": m.from,
+						"to_node_id":   m.to.Id.Value,
+					},
+				})
+			}
 		}
 
 	case commonv1.ActionType_ACTION_TYPE_REBALANCE_TRAFFIC:
-		for _, svc := range e.state.services {
+		for _, svc := range s.services {
 			svc.RequestsPerSecond = svc.RequestsPerSecond * 0.9
 		}
 		event.EventType = "traffic_rebalanced"
 		event.Description = "Traffic rebalanced across services"
-	}
 
-	if err := e.publisher.PublishSimulationEvent(ctx, event); err != nil {
-		e.log.Error("failed to publish event", "error", err)
+	case commonv1.ActionType_ACTION_TYPE_CORDON_NODE:
+		node, ok := s.nodes[targetID]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown node: %s", targetID)
+		}
+		if node.Labels == nil {
+			node.Labels = make(map[string]string)
+		}
+		node.Labels[LabelCordoned] = "true"
+		event.EventType = "node_cordoned"
+		event.Description = "Node cordoned; no new services will be placed on it"
+
+	case commonv1.ActionType_ACTION_TYPE_UNCORDON_NODE:
+		node, ok := s.nodes[targetID]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown node: %s", targetID)
+		}
+		delete(node.Labels, LabelCordoned)
+		event.EventType = "node_uncordoned"
+		event.Description = "Node uncordoned and schedulable again"
+
+	case commonv1.ActionType_ACTION_TYPE_REBOOT_NODE:
+		if err := s.rebootNode(targetID); err != nil {
+			return nil, nil, err
+		}
+		event.EventType = "node_rebooting"
+		event.Description = fmt.Sprintf("Node rebooting; offline for %d ticks, memory cleared", RebootDowntimeTicks)
+		event.Metadata = mergeMetadata(params, map[string]string{
+			"downtime_ticks": fmt.Sprintf("%d", RebootDowntimeTicks),
+		})
+
+	case commonv1.ActionType_ACTION_TYPE_SCALE_NODE_POOL:
+		count, err := intParam(params, ParamCount, 1, 1, MaxPoolScaleOut)
+		if err != nil {
+			return nil, nil, err
+		}
+		added, err := s.scaleNodePool(targetID, count)
+		if err != nil {
+			return nil, nil, err
+		}
+		event.EventType = "node_pool_scaled"
+		event.Description = fmt.Sprintf("Node pool in %s scaled out by %d nodes", s.nodes[targetID].AvailabilityZone, len(added))
+		event.Metadata = mergeMetadata(params, map[string]string{
+			"added_nodes": fmt.Sprintf("%d", len(added)),
+			"total_nodes": fmt.Sprintf("%d", len(s.nodes)),
+		})
+		for _, node := range added {
+			followUps = append(followUps, &simv1.SimulationEvent{
+				Timestamp:   event.Timestamp,
+				EventType:   "node_added",
+				TargetId:    node.Id.Value,
+				Description: fmt.Sprintf("Node %s added to the pool in %s", node.Name, node.AvailabilityZone),
+			})
+		}
+
+	case commonv1.ActionType_ACTION_TYPE_FAILOVER_SERVICE:
+		svc, ok := s.services[targetID]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown service: %s", targetID)
+		}
+		from := svc.NodeId.GetValue()
+		to, err := s.failoverService(svc, params)
+		if err != nil {
+			return nil, nil, err
+		}
+		event.EventType = "service_failed_over"
+		event.Description = fmt.Sprintf("Service failed over to %s", to.Name)
+		event.Metadata = mergeMetadata(params, map[string]string{
+			"from_node_id": from,
+			"to_node_id":   to.Id.Value,
+		})
+
+	case commonv1.ActionType_ACTION_TYPE_SCALE_TO_N:
+		svc, ok := s.services[targetID]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown service: %s", targetID)
+		}
+		count, err := parseCount(params)
+		if err != nil {
+			return nil, nil, err
+		}
+		previous := svc.ReplicaCount
+		requested := s.nodeCPURequestedPercent(targetID, count)
+		svc.ReplicaCount = count
+		svc.DesiredReplicas = count
+		s.schedule()
+		pending := s.pendingReplicas(targetID)
+		event.EventType = "service_scaled"
+		event.Description = fmt.Sprintf("Service scaled from %d to %d replicas", previous, count)
+		event.Metadata = mergeMetadata(params, map[string]string{
+			"node_cpu_requested_percent": fmt.Sprintf("%.1f", requested),
+			"placed_nodes":               fmt.Sprintf("%d", len(s.placements[targetID])),
+			"pending_replicas":           fmt.Sprintf("%d", pending),
+		})
+		if pending > 0 {
+			event.Description += fmt.Sprintf("; %d replicas pending, no node has room", pending)
+		}
+
+	case commonv1.ActionType_ACTION_TYPE_ENABLE_CIRCUIT_BREAKER:
+		if _, ok := s.services[targetID]; !ok {
+			return nil, nil, fmt.Errorf("unknown service: %s", targetID)
+		}
+		b, err := s.openBreaker(targetID, params)
+		if err != nil {
+			return nil, nil, err
+		}
+		event.EventType = "circuit_breaker_enabled"
+		event.Description = fmt.Sprintf("Circuit breaker shedding %.0f%% of calls to %s for %d ticks",
+			b.shed*100, s.services[b.to].GetName(), b.expiresTick-s.tickID)
+		event.Metadata = mergeMetadata(params, map[string]string{
+			ParamDependencyID: b.to,
+		})
+
+	case commonv1.ActionType_ACTION_TYPE_DISABLE_FEATURE_FLAG:
+		if _, ok := s.services[targetID]; !ok {
+			return nil, nil, fmt.Errorf("unknown service: %s", targetID)
+		}
+		if s.hasPendingEffect(targetID) {
+			return nil, nil, fmt.Errorf("service %s has an action in progress", targetID)
+		}
+		s.scheduleFlagDisable(targetID)
+		flag := params[ParamFlag]
+		if flag == "" {
+			flag = "unnamed"
+		}
+		event.EventType = "feature_flag_disabled"
+		event.Description = fmt.Sprintf("Feature flag %s disabled; errors easing over %d ticks", flag, FlagRolloutTicks)
+
+	case commonv1.ActionType_ACTION_TYPE_WARM_CACHE:
+		if _, ok := s.services[targetID]; !ok {
+			return nil, nil, fmt.Errorf("unknown service: %s", targetID)
+		}
+		if s.hasPendingEffect(targetID) {
+			return nil, nil, fmt.Errorf("service %s has an action in progress", targetID)
+		}
+		ticks, err := intParam(params, ParamTicks, DefaultCacheWarmTicks, 1, MaxCacheWarmTicks)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.scheduleCacheWarm(targetID, int64(ticks))
+		event.EventType = "cache_warming"
+		event.Description = fmt.Sprintf("Cache warming over %d ticks", ticks)
+
+	case commonv1.ActionType_ACTION_TYPE_CLEAR_CACHE:
+		svc, ok := s.services[targetID]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown service: %s", targetID)
+		}
+		// Stale entries are gone, but the cold cache costs latency until it refills
+		svc.ErrorRatePercent = clamp(svc.ErrorRatePercent*0.5, 0, 100)
+		svc.LatencyP50Ms = clamp(svc.LatencyP50Ms*1.3, 1, 1000)
+		svc.LatencyP99Ms = clamp(svc.LatencyP99Ms*1.3, svc.LatencyP50Ms, 5000)
+		event.EventType = "cache_cleared"
+		event.Description = "Service cache cleared"
 	}
 
-	return event, nil
+	s.dynamics.OnAction(s.tickInfo(), actionType, targetID)
+
+	return event, followUps, nil
+}
+
+// mergeMetadata returns a copy of params with extra entries added
+func mergeMetadata(params, extra map[string]string) map[string]string {
+	out := make(map[string]string, len(params)+len(extra))
+	for k, v := range params {
+		out[k] = v
+	}
+	for k, v := range extra {
+		out[k] = v
+	}
+	return out
 }
@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+)
+
+// Fault types accepted by InjectFault
+const (
+	FaultErrorRate = "error_rate"
+	FaultLatency   = "latency"
+	FaultCPU       = "cpu"
+
+	// FaultMemoryLeak grows service memory by Magnitude percent per tick.
+	// Expiry stops the growth but does not free memory; only a restart does.
+	FaultMemoryLeak = "memory_leak"
+)
+
+// Fault is a temporary metric offset applied to a node or service
+type Fault struct {
+	ID             string
+	TargetID       string
+	Type           string
+	Magnitude      float64
+	ExpiresAtSimMs int64
+
+	applied faultOffset // reverted on expiry
+}
+
+// faultOffset is the change a fault made to its target's metrics. Clamping
+// can make it smaller than the fault's magnitude, and reverting it rather
+// than the magnitude leaves the metrics where they would have been.
+type faultOffset struct {
+	value float64 // error rate, p50 latency, CPU or leak rate
+	p99   float64 // p99 latency, for latency faults
+}
+
+// InjectFault applies a fault to the target immediately and schedules it to
+// clear once the given amount of simulated time has passed.
+func (s *State) InjectFault(targetID, faultType string, magnitude float64, duration time.Duration) (Fault, error) {
+	if magnitude <= 0 {
+		return Fault{}, fmt.Errorf("magnitude must be positive")
+	}
+	if duration <= 0 {
+		return Fault{}, fmt.Errorf("duration must be positive")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	applied, err := s.applyFault(targetID, faultType, faultOffset{value: magnitude, p99: magnitude})
+	if err != nil {
+		return Fault{}, err
+	}
+
+	f := Fault{
+		ID:             s.newID(),
+		TargetID:       targetID,
+		Type:           faultType,
+		Magnitude:      magnitude,
+		ExpiresAtSimMs: s.simTimeUnixMs + duration.Milliseconds(),
+		applied:        applied,
+	}
+	s.faults = append(s.faults, f)
+	return f, nil
+}
+
+// ActiveFaults returns a copy of the faults that have not yet expired
+func (s *State) ActiveFaults() []Fault {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Fault(nil), s.faults...)
+}
+
+// applyFault adds (or with a negative offset, removes) a fault offset and
+// returns the offset actually applied after clamping. Caller must hold mu.
+func (s *State) applyFault(targetID, faultType string, offset faultOffset) (faultOffset, error) {
+	var applied faultOffset
+	switch faultType {
+	case FaultErrorRate:
+		svc, ok := s.services[targetID]
+		if !ok {
+			return applied, fmt.Errorf("unknown service: %s", targetID)
+		}
+		prev := svc.ErrorRatePercent
+		svc.ErrorRatePercent = clamp(prev+offset.value, 0, 100)
+		applied.value = svc.ErrorRatePercent - prev
+
+	case FaultLatency:
+		svc, ok := s.services[targetID]
+		if !ok {
+			return applied, fmt.Errorf("unknown service: %s", targetID)
+		}
+		p50, p99 := svc.LatencyP50Ms, svc.LatencyP99Ms
+		svc.LatencyP50Ms = clamp(p50+offset.value, 1, 1000)
+		svc.LatencyP99Ms = clamp(p99+offset.p99, svc.LatencyP50Ms, 5000)
+		applied.value, applied.p99 = svc.LatencyP50Ms-p50, svc.LatencyP99Ms-p99
+
+	case FaultCPU:
+		node, ok := s.nodes[targetID]
+		if !ok {
+			return applied, fmt.Errorf("unknown node: %s", targetID)
+		}
+		prev := node.CpuUsagePercent
+		node.CpuUsagePercent = clamp(prev+offset.value, 0, 100)
+		applied.value = node.CpuUsagePercent - prev
+
+	case FaultMemoryLeak:
+		if _, ok := s.services[targetID]; !ok {
+			return applied, fmt.Errorf("unknown service: %s", targetID)
+		}
+		s.setLeak(targetID, offset.value)
+		applied.value = offset.value
+
+	default:
+		return applied, fmt.Errorf("unknown fault type: %s", faultType)
+	}
+	return applied, nil
+}
+
+// expireFaults reverts faults whose duration has elapsed. Caller must hold mu.
+func (s *State) expireFaults() {
+	active := s.faults[:0]
+	for _, f := range s.faults {
+		if s.simTimeUnixMs < f.ExpiresAtSimMs {
+			active = append(active, f)
+			continue
+		}
+		// The target may have been removed since injection; nothing to revert then.
+		_, _ = s.applyFault(f.TargetID, f.Type, faultOffset{value: -f.applied.value, p99: -f.applied.p99})
+	}
+	s.faults = active
+}
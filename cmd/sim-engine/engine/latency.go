@@ -0,0 +1,57 @@
+package engine
+
+// Latency propagation along the dependency graph. A caller's p99 is its own
+// latency plus the time its tail requests spend waiting on slow
+// dependencies, reported separately as dependency_latency_p99_ms so the
+// detector can tell symptoms from the root cause.
+const (
+	// latencyBudgetHeadroom scales a service's starting p99 into its latency
+	// budget; only time over budget reaches its callers, so ordinary noise
+	// stays local
+	latencyBudgetHeadroom = 1.5
+
+	// latencyPropagation is the share of a dependency's p99 over budget that
+	// surfaces in its callers' p99. Tail requests mostly wait on the slowest
+	// call, so most of it does.
+	latencyPropagation = 0.8
+
+	// latencyPull is how far each tick moves a caller's dependency latency
+	// toward what its dependencies push onto it, so a slowdown climbs the
+	// call graph a hop at a time
+	latencyPull = 0.3
+)
+
+// propagateLatency adds the p99 its dependencies run over budget to each
+// caller's, reduced by any open breaker on the edge. Targets are computed
+// from every service's latency before any is changed, so the result does
+// not depend on iteration order. Caller must hold mu.
+func (s *State) propagateLatency() {
+	if s.latencyBudgets == nil {
+		s.latencyBudgets = make(map[string]float64, len(s.services))
+	}
+	for id, svc := range s.services {
+		if _, ok := s.latencyBudgets[id]; !ok {
+			s.latencyBudgets[id] = (svc.LatencyP99Ms - svc.DependencyLatencyP99Ms) * latencyBudgetHeadroom
+		}
+	}
+
+	targets := make(map[string]float64, len(s.services))
+	for id := range s.services {
+		var target float64
+		for _, depID := range s.deps[id] {
+			dep, ok := s.services[depID]
+			if !ok {
+				continue
+			}
+			over := max(dep.LatencyP99Ms-s.latencyBudgets[depID], 0)
+			target = max(target, over*latencyPropagation*(1-s.shedFraction(id, depID)))
+		}
+		targets[id] = target
+	}
+
+	for id, svc := range s.services {
+		own := svc.LatencyP99Ms - svc.DependencyLatencyP99Ms
+		svc.DependencyLatencyP99Ms = lerp(svc.DependencyLatencyP99Ms, targets[id], latencyPull)
+		svc.LatencyP99Ms = clamp(own+svc.DependencyLatencyP99Ms, svc.LatencyP50Ms, 5000)
+	}
+}
@@ -0,0 +1,81 @@
+package engine
+
+import commonv1 "github.com/microcloud/gen/go/common/v1"
+
+// Service memory model, as a percentage of the per-replica memory request
+const (
+	// DefaultLeakRate is the growth per tick used by scenarios. At 10 ticks
+	// a second it leaves roughly 100s between high_memory_usage (90%) and
+	// an OOM kill, enough for the detector window and a restart.
+	DefaultLeakRate = 0.01
+
+	oomThresholdPercent = 100
+	memoryBaselineMin   = 30
+	memoryBaselineRange = 20
+)
+
+// initMemory gives every service a baseline memory footprint. Caller must hold mu.
+func (s *State) initMemory() {
+	s.leaks = make(map[string]float64)
+	s.oomKilled = make(map[string]bool)
+	for _, id := range sortedIDs(s.services) {
+		s.services[id].MemoryUsagePercent = memoryBaselineMin + s.rng.Float64()*memoryBaselineRange
+	}
+}
+
+// setLeak adds rate to the memory growth of a service; a negative rate
+// reduces it and the leak stops once nothing is left. Caller must hold mu.
+func (s *State) setLeak(serviceID string, rate float64) {
+	s.leaks[serviceID] += rate
+	if s.leaks[serviceID] <= 0 {
+		delete(s.leaks, serviceID)
+	}
+}
+
+// clearMemory ends any leak, revives an OOM-killed service and returns its
+// memory to baseline, as a restart would. Caller must hold mu.
+func (s *State) clearMemory(serviceID string) {
+	delete(s.leaks, serviceID)
+	delete(s.oomKilled, serviceID)
+	if svc, ok := s.services[serviceID]; ok {
+		svc.MemoryUsagePercent = memoryBaselineMin + s.rng.Float64()*memoryBaselineRange
+	}
+}
+
+// updateMemory grows leaking services, OOM-kills those that run out and
+// keeps killed services down until they are restarted. Node memory usage
+// is raised to cover what its services actually use. Caller must hold mu.
+func (s *State) updateMemory() {
+	used := make(map[string]float64) // node ID -> MB in use
+	for _, id := range sortedIDs(s.services) {
+		svc := s.services[id]
+		if rate, ok := s.leaks[id]; ok && !s.oomKilled[id] {
+			svc.MemoryUsagePercent += rate
+		} else if !s.oomKilled[id] {
+			svc.MemoryUsagePercent = clamp(svc.MemoryUsagePercent+randDelta(s.rng, 0.5), memoryBaselineMin/2, oomThresholdPercent-1)
+		}
+
+		if svc.MemoryUsagePercent >= oomThresholdPercent {
+			s.oomKilled[id] = true
+			delete(s.leaks, id)
+		}
+		if s.oomKilled[id] {
+			svc.MemoryUsagePercent = 0
+			svc.RequestsPerSecond = 0
+			svc.ErrorRatePercent = 100
+			svc.Health = commonv1.ServiceHealth_SERVICE_HEALTH_CRITICAL
+			continue
+		}
+
+		for nodeID, n := range s.placements[id] {
+			used[nodeID] += float64(n) * float64(svc.MemoryRequestMb) * svc.MemoryUsagePercent / 100
+		}
+	}
+
+	for id, node := range s.nodes {
+		if node.Status == commonv1.NodeStatus_NODE_STATUS_OFFLINE {
+			continue
+		}
+		node.MemoryUsagePercent = clamp(max(node.MemoryUsagePercent, percentOf(used[id], float64(node.MemoryCapacityMb))), 0, 100)
+	}
+}
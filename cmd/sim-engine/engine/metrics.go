@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+)
+
+// tickBuckets are the upper bounds, in seconds, of the tick duration
+// histogram. The tick budget is DefaultTickInterval.
+var tickBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// engineMetrics collects tick loop counters for the /metrics endpoint
+type engineMetrics struct {
+	mu            sync.Mutex
+	tickCounts    []uint64 // per bucket in tickBuckets, not cumulative
+	tickCount     uint64
+	tickSum       float64
+	publishErrors uint64
+	recordErrors  uint64
+}
+
+func newEngineMetrics() *engineMetrics {
+	return &engineMetrics{tickCounts: make([]uint64, len(tickBuckets))}
+}
+
+func (m *engineMetrics) observeTick(d time.Duration) {
+	secs := d.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tickCount++
+	m.tickSum += secs
+	for i, le := range tickBuckets {
+		if secs <= le {
+			m.tickCounts[i]++
+			break
+		}
+	}
+}
+
+func (m *engineMetrics) publishFailed() {
+	m.mu.Lock()
+	m.publishErrors++
+	m.mu.Unlock()
+}
+
+func (m *engineMetrics) recordFailed() {
+	m.mu.Lock()
+	m.recordErrors++
+	m.mu.Unlock()
+}
+
+// EntityCounts returns the number of nodes and services
+func (s *State) EntityCounts() (nodes, services int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.nodes), len(s.services)
+}
+
+// MetricsHandler serves engine metrics in the Prometheus text format
+func (e *Engine) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		e.writeMetrics(w)
+	})
+}
+
+func (e *Engine) writeMetrics(w io.Writer) {
+	m := e.metrics
+	m.mu.Lock()
+	counts := append([]uint64(nil), m.tickCounts...)
+	count, sum := m.tickCount, m.tickSum
+	publishErrors, recordErrors := m.publishErrors, m.recordErrors
+	m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP sim_tick_duration_seconds Time spent computing one simulation tick.")
+	fmt.Fprintln(w, "# TYPE sim_tick_duration_seconds histogram")
+	var cumulative uint64
+	for i, le := range tickBuckets {
+		cumulative += counts[i]
+		fmt.Fprintf(w, "sim_tick_duration_seconds_bucket{le=\"%g\"} %d\n", le, cumulative)
+	}
+	fmt.Fprintf(w, "sim_tick_duration_seconds_bucket{le=\"+Inf\"} %d\n", count)
+	fmt.Fprintf(w, "sim_tick_duration_seconds_sum %g\n", sum)
+	fmt.Fprintf(w, "sim_tick_duration_seconds_count %d\n", count)
+
+	if e.profile != nil {
+		e.profile.writeMetrics(w)
+	}
+
+	fmt.Fprintln(w, "# HELP sim_publish_errors_total Metric snapshots that failed to publish.")
+	fmt.Fprintln(w, "# TYPE sim_publish_errors_total counter")
+	fmt.Fprintf(w, "sim_publish_errors_total %d\n", publishErrors)
+
+	fmt.Fprintln(w, "# HELP sim_record_errors_total Snapshots that failed to write to the recording.")
+	fmt.Fprintln(w, "# TYPE sim_record_errors_total counter")
+	fmt.Fprintf(w, "sim_record_errors_total %d\n", recordErrors)
+
+	nodes, services := e.state.EntityCounts()
+	fmt.Fprintln(w, "# HELP sim_entities Simulated nodes and services.")
+	fmt.Fprintln(w, "# TYPE sim_entities gauge")
+	fmt.Fprintf(w, "sim_entities{kind=\"node\"} %d\n", nodes)
+	fmt.Fprintf(w, "sim_entities{kind=\"service\"} %d\n", services)
+
+	fmt.Fprintln(w, "# HELP sim_snapshot_detail_level Snapshot detail level, raised while ticks overrun their budget.")
+	fmt.Fprintln(w, "# TYPE sim_snapshot_detail_level gauge")
+	fmt.Fprintf(w, "sim_snapshot_detail_level %d\n", e.DetailLevel())
+
+	fmt.Fprintln(w, "# HELP sim_tick_id Current simulation tick.")
+	fmt.Fprintln(w, "# TYPE sim_tick_id gauge")
+	fmt.Fprintf(w, "sim_tick_id %d\n", e.state.GetTickID())
+
+	fmt.Fprintln(w, "# HELP sim_running Whether the simulation is running on this instance.")
+	fmt.Fprintln(w, "# TYPE sim_running gauge")
+	running := 0
+	if e.Active() && e.state.GetSimState() == commonv1.SimulationState_SIMULATION_STATE_RUNNING {
+		running = 1
+	}
+	fmt.Fprintf(w, "sim_running %d\n", running)
+}
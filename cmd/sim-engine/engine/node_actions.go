@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"fmt"
+	"maps"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+)
+
+const (
+	// RebootDowntimeTicks is how long a rebooting node stays offline. It is
+	// shorter than CrashEvictionTicks, so its replicas wait for it.
+	RebootDowntimeTicks = 3
+
+	// MaxPoolScaleOut bounds the nodes one SCALE_NODE_POOL adds
+	MaxPoolScaleOut = 5
+
+	// rebootMemoryPercent is the node memory in use right after a reboot,
+	// before its services load again
+	rebootMemoryPercent = 10
+)
+
+// rebootNode takes a node offline for RebootDowntimeTicks, its services
+// down with it, and brings it back with its memory cleared: leaks end and
+// OOM-killed services on it start again. Caller must hold mu.
+func (s *State) rebootNode(nodeID string) error {
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	if _, down := s.crashes[nodeID]; down || node.Status == commonv1.NodeStatus_NODE_STATUS_OFFLINE {
+		return fmt.Errorf("node %s is already offline", nodeID)
+	}
+
+	if s.crashes == nil {
+		s.crashes = make(map[string]int64)
+	}
+	s.crashes[nodeID] = s.tickID + RebootDowntimeTicks
+	node.Status = commonv1.NodeStatus_NODE_STATUS_OFFLINE
+	node.MemoryUsagePercent = rebootMemoryPercent
+
+	for _, id := range sortedIDs(s.services) {
+		svc := s.services[id]
+		if _, placed := s.placements[id][nodeID]; !placed && svc.NodeId.GetValue() != nodeID {
+			continue
+		}
+		s.clearMemory(id)
+		if svc.NodeId.GetValue() == nodeID {
+			svc.RequestsPerSecond = 0
+			svc.Health = commonv1.ServiceHealth_SERVICE_HEALTH_DOWN
+		}
+	}
+	return nil
+}
+
+// scaleNodePool adds count nodes to the pool of template: nodes in its zone
+// with its capacity and labels, less cordon and drain marks. Pending
+// replicas are placed on them at once. Caller must hold mu.
+func (s *State) scaleNodePool(templateID string, count int) ([]*simv1.Node, error) {
+	template, ok := s.nodes[templateID]
+	if !ok {
+		return nil, fmt.Errorf("unknown node: %s", templateID)
+	}
+
+	labels := maps.Clone(template.Labels)
+	delete(labels, LabelCordoned)
+	delete(labels, LabelDrained)
+
+	added := make([]*simv1.Node, 0, count)
+	for i := 0; i < count; i++ {
+		nodeID := s.newID()
+		node := &simv1.Node{
+			Id:                    &commonv1.UUID{Value: nodeID},
+			Name:                  nodeName(len(s.nodes)),
+			Status:                commonv1.NodeStatus_NODE_STATUS_HEALTHY,
+			CpuUsagePercent:       s.rng.Float64() * 10,
+			MemoryUsagePercent:    rebootMemoryPercent,
+			DiskUsagePercent:      s.rng.Float64() * 10,
+			AvailabilityZone:      template.AvailabilityZone,
+			Region:                template.Region,
+			Labels:                maps.Clone(labels),
+			CpuCapacityMillicores: template.CpuCapacityMillicores,
+			MemoryCapacityMb:      template.MemoryCapacityMb,
+		}
+		s.nodes[nodeID] = node
+		added = append(added, node)
+	}
+	s.schedule()
+	return added, nil
+}
@@ -0,0 +1,50 @@
+package engine
+
+import (
+	"math/rand"
+	"runtime"
+	"sync"
+)
+
+// parallelThreshold is the entity count below which per-entity updates run
+// on the tick goroutine; smaller clusters finish faster without the fan-out
+const parallelThreshold = 512
+
+// SetTickWorkers sets how many goroutines share the per-entity node and
+// service updates each tick. n <= 0 uses GOMAXPROCS.
+func (s *State) SetTickWorkers(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.workers = n
+}
+
+// forEachShard splits [0, n) into contiguous shards and calls fn for each,
+// in parallel once n reaches parallelThreshold. fn must only touch the
+// entities in its own shard and draw only from r, which is the state's
+// source when run serially and otherwise a source of the shard's own,
+// seeded from the state's so a seeded run stays reproducible for a given
+// worker count. Caller must hold mu.
+func (s *State) forEachShard(n int, fn func(lo, hi int, r *rand.Rand)) {
+	workers := s.workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	// Deterministic runs draw random numbers in entity order, so never fan out
+	if n < parallelThreshold || workers == 1 || s.deterministic {
+		fn(0, n, s.rng)
+		return
+	}
+
+	size := (n + workers - 1) / workers
+	var wg sync.WaitGroup
+	for lo := 0; lo < n; lo += size {
+		hi := min(lo+size, n)
+		r := rand.New(rand.NewSource(s.rng.Int63()))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(lo, hi, r)
+		}()
+	}
+	wg.Wait()
+}
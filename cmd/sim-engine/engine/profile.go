@@ -0,0 +1,185 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// tickPhase is a part of the tick loop timed by WithTickProfiling. The
+// update and effects phases run once per simulation step, so their time per
+// wall tick grows with the speed multiplier.
+type tickPhase int
+
+const (
+	phaseUpdateNodes tickPhase = iota
+	phaseUpdateServices
+	phaseEffects // faults, chaos, actions, capacity, queues, regions
+	phaseSnapshot
+	phasePublish
+	numTickPhases
+)
+
+// tickPhaseNames are the phase names reported in metrics and GetEngineStats
+var tickPhaseNames = [numTickPhases]string{
+	"update_nodes",
+	"update_services",
+	"effects",
+	"snapshot",
+	"publish",
+}
+
+// PhaseStats summarizes the time a phase takes per wall tick
+type PhaseStats struct {
+	Phase string
+	Ticks uint64
+	Mean  time.Duration
+	Max   time.Duration
+	Last  time.Duration
+}
+
+// phaseTotals accumulates one phase's time per wall tick
+type phaseTotals struct {
+	counts []uint64 // per bucket in tickBuckets, not cumulative
+	count  uint64
+	sum    time.Duration
+	max    time.Duration
+	last   time.Duration
+}
+
+// tickProfile times tick phases. Phase times add up over the current wall
+// tick until endTick folds them into the totals.
+type tickProfile struct {
+	mu      sync.Mutex
+	current [numTickPhases]time.Duration
+	totals  [numTickPhases]phaseTotals
+}
+
+func newTickProfile() *tickProfile {
+	p := &tickProfile{}
+	for i := range p.totals {
+		p.totals[i].counts = make([]uint64, len(tickBuckets))
+	}
+	return p
+}
+
+// add records d against phase in the current wall tick
+func (p *tickProfile) add(phase tickPhase, d time.Duration) {
+	p.mu.Lock()
+	p.current[phase] += d
+	p.mu.Unlock()
+}
+
+// endTick closes the current wall tick
+func (p *tickProfile) endTick() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for phase, d := range p.current {
+		t := &p.totals[phase]
+		t.count++
+		t.sum += d
+		t.max = max(t.max, d)
+		t.last = d
+		for i, le := range tickBuckets {
+			if d.Seconds() <= le {
+				t.counts[i]++
+				break
+			}
+		}
+		p.current[phase] = 0
+	}
+}
+
+// stats returns every phase's totals in tick order
+func (p *tickProfile) stats() []PhaseStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]PhaseStats, 0, numTickPhases)
+	for phase, t := range p.totals {
+		s := PhaseStats{Phase: tickPhaseNames[phase], Ticks: t.count, Max: t.max, Last: t.last}
+		if t.count > 0 {
+			s.Mean = t.sum / time.Duration(t.count)
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// writeMetrics writes the per-phase histograms in the Prometheus text format
+func (p *tickProfile) writeMetrics(w io.Writer) {
+	p.mu.Lock()
+	totals := p.totals
+	for i := range totals {
+		totals[i].counts = append([]uint64(nil), p.totals[i].counts...)
+	}
+	p.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP sim_tick_phase_duration_seconds Time spent per wall tick in each tick loop phase.")
+	fmt.Fprintln(w, "# TYPE sim_tick_phase_duration_seconds histogram")
+	for phase, t := range totals {
+		name := tickPhaseNames[phase]
+		var cumulative uint64
+		for i, le := range tickBuckets {
+			cumulative += t.counts[i]
+			fmt.Fprintf(w, "sim_tick_phase_duration_seconds_bucket{phase=%q,le=\"%g\"} %d\n", name, le, cumulative)
+		}
+		fmt.Fprintf(w, "sim_tick_phase_duration_seconds_bucket{phase=%q,le=\"+Inf\"} %d\n", name, t.count)
+		fmt.Fprintf(w, "sim_tick_phase_duration_seconds_sum{phase=%q} %g\n", name, t.sum.Seconds())
+		fmt.Fprintf(w, "sim_tick_phase_duration_seconds_count{phase=%q} %d\n", name, t.count)
+	}
+}
+
+// timePhase runs fn, timing it when profiling. Caller must hold mu.
+func (s *State) timePhase(phase tickPhase, fn func()) {
+	if s.profile == nil {
+		fn()
+		return
+	}
+	start := time.Now()
+	fn()
+	s.profile.add(phase, time.Since(start))
+}
+
+// profilePhase records the time since start against phase when profiling
+func (e *Engine) profilePhase(phase tickPhase, start time.Time) {
+	if e.profile != nil {
+		e.profile.add(phase, time.Since(start))
+	}
+}
+
+// EngineStats describes where the tick budget goes
+type EngineStats struct {
+	TickBudget time.Duration // the wall tick interval
+	Ticks      uint64        // wall ticks computed
+	TickMean   time.Duration // mean time computing a wall tick's steps
+	Nodes      int
+	Services   int
+	Detail     int // snapshot detail level, DetailFull unless ticks overrun
+
+	Profiling bool         // whether WithTickProfiling is set
+	Phases    []PhaseStats // per-phase times, only when profiling
+}
+
+// Stats returns tick timing and, when profiling, its breakdown by phase
+func (e *Engine) Stats() EngineStats {
+	m := e.metrics
+	m.mu.Lock()
+	count, sum := m.tickCount, m.tickSum
+	m.mu.Unlock()
+
+	stats := EngineStats{
+		TickBudget: e.tickInterval,
+		Ticks:      count,
+		Detail:     e.DetailLevel(),
+		Profiling:  e.profile != nil,
+	}
+	if count > 0 {
+		stats.TickMean = time.Duration(sum / float64(count) * float64(time.Second))
+	}
+	stats.Nodes, stats.Services = e.state.EntityCounts()
+	if e.profile != nil {
+		stats.Phases = e.profile.stats()
+	}
+	return stats
+}
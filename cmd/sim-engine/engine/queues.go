@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"time"
+)
+
+// Queue model
+const (
+	// ReplicaThroughputRPS is how many requests one replica processes per second
+	ReplicaThroughputRPS = 300
+
+	// MaxQueueDepth bounds a service queue; arrivals beyond it are rejected
+	MaxQueueDepth = 100000
+
+	// backpressurePull is how far each tick moves latency toward the
+	// queueing delay while a backlog exists
+	backpressurePull = 0.2
+
+	// minBacklogLagMs is the lag below which the queue is considered drained
+	minBacklogLagMs = 1
+)
+
+// updateQueues accumulates arrivals a service cannot process into its queue
+// and derives consumer lag from the backlog. Latency rises with the lag and
+// rejections once the queue is full show up as errors. Caller must hold mu.
+func (s *State) updateQueues(elapsed time.Duration) {
+	dt := elapsed.Seconds()
+	if dt <= 0 {
+		return
+	}
+
+	for _, svc := range s.services {
+		throughput := float64(svc.ReplicaCount) * ReplicaThroughputRPS
+		if s.oomKilled[svc.Id.GetValue()] || s.regionFailed(svc.Region) {
+			throughput = 0
+		}
+
+		backlog := svc.QueueDepth + svc.RequestsPerSecond*dt
+		processed := min(backlog, throughput*dt)
+		backlog -= processed
+
+		if backlog > MaxQueueDepth {
+			rejected := backlog - MaxQueueDepth
+			backlog = MaxQueueDepth
+			if arrivals := svc.RequestsPerSecond * dt; arrivals > 0 {
+				svc.ErrorRatePercent = clamp(max(svc.ErrorRatePercent, rejected/arrivals*100), 0, 100)
+			}
+		}
+		svc.QueueDepth = backlog
+
+		if throughput > 0 {
+			svc.ConsumerLagMs = backlog / throughput * 1000
+		} else if backlog > 0 {
+			svc.ConsumerLagMs = float64(MaxQueueDepth) // stalled; report a large lag
+		} else {
+			svc.ConsumerLagMs = 0
+		}
+
+		if svc.ConsumerLagMs < minBacklogLagMs {
+			continue
+		}
+		// Queued requests wait out the lag before being served
+		svc.LatencyP50Ms = clamp(lerp(svc.LatencyP50Ms, 5+svc.ConsumerLagMs/2, backpressurePull), 1, 1000)
+		svc.LatencyP99Ms = clamp(lerp(svc.LatencyP99Ms, 20+svc.ConsumerLagMs, backpressurePull), svc.LatencyP50Ms, 5000)
+	}
+}
@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"sort"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+)
+
+// RebalanceTicks is how long displaced load takes to settle on the
+// replicas or nodes that absorb it
+const RebalanceTicks = 10
+
+// LabelDrained marks nodes whose services have been moved off
+const LabelDrained = "drained"
+
+// scheduleScaleDown removes a replica immediately and lets the remaining
+// replicas take over its share of the load, raising latency in proportion
+// to the lost capacity. Returns the new per-replica request rate.
+// Caller must hold mu.
+func (s *State) scheduleScaleDown(serviceID string) float64 {
+	svc, ok := s.services[serviceID]
+	if !ok || svc.ReplicaCount <= 1 {
+		return 0
+	}
+	ratio := float64(svc.ReplicaCount) / float64(svc.ReplicaCount-1)
+	svc.ReplicaCount--
+	svc.DesiredReplicas = svc.ReplicaCount
+	p50Start, p99Start := svc.LatencyP50Ms, svc.LatencyP99Ms
+
+	s.scheduleEffect(serviceID, RebalanceTicks, func(s *State, p float64) {
+		svc, ok := s.services[serviceID]
+		if !ok {
+			return
+		}
+		svc.LatencyP50Ms = clamp(lerp(p50Start, p50Start*ratio, p), 1, 1000)
+		svc.LatencyP99Ms = clamp(lerp(p99Start, p99Start*ratio, p), svc.LatencyP50Ms, 5000)
+	})
+	return svc.RequestsPerSecond / float64(svc.ReplicaCount)
+}
+
+// migration records a service moved off a drained node
+type migration struct {
+	service *simv1.Service
+	from    string
+	to      *simv1.Node
+}
+
+// drainNode takes a node offline and reschedules its replicas onto other
+// nodes, placing the largest services first so they get the most room.
+// Services with no replica placed elsewhere stay stranded on the drained
+// node, where enforceDrains shifts their traffic to other instances.
+// Returns the migrations and the request rate left stranded. Caller must
+// hold mu.
+func (s *State) drainNode(nodeID string) ([]migration, float64) {
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return nil, 0
+	}
+	if node.Labels == nil {
+		node.Labels = make(map[string]string)
+	}
+	node.Labels[LabelDrained] = "true"
+	node.Status = commonv1.NodeStatus_NODE_STATUS_OFFLINE
+	if s.drains == nil {
+		s.drains = make(map[string]int64)
+	}
+	if _, ok := s.drains[nodeID]; !ok {
+		s.drains[nodeID] = s.tickID
+	}
+	if s.placements == nil {
+		s.initPlacements()
+	}
+
+	var resident []*simv1.Service
+	for id, p := range s.placements {
+		if _, ok := p[nodeID]; ok {
+			resident = append(resident, s.services[id])
+		}
+	}
+	sort.Slice(resident, func(i, j int) bool {
+		a := resident[i].CpuRequestMillicores * resident[i].ReplicaCount
+		b := resident[j].CpuRequestMillicores * resident[j].ReplicaCount
+		return a > b || (a == b && resident[i].Id.GetValue() < resident[j].Id.GetValue())
+	})
+
+	reserved := s.reservations()
+	var moved []migration
+	var stranded float64
+	for _, svc := range resident {
+		from := svc.NodeId.GetValue()
+		next := make(placement)
+		for id, n := range s.placements[svc.Id.GetValue()] {
+			if id != nodeID {
+				next[id] = n
+			}
+		}
+		s.reconcile(svc, next, reserved)
+		if len(next) == 0 {
+			stranded += svc.RequestsPerSecond
+			continue
+		}
+		if from == nodeID {
+			moved = append(moved, migration{service: svc, from: nodeID, to: s.nodes[svc.NodeId.GetValue()]})
+		}
+	}
+	return moved, stranded
+}
+
+// enforceDrains keeps drained nodes offline and shifts the traffic of their
+// services onto surviving instances, ramping up over RebalanceTicks. Services
+// with no instance elsewhere fail the traffic they can no longer serve.
+// Caller must hold mu.
+func (s *State) enforceDrains() {
+	if len(s.drains) == 0 {
+		return
+	}
+
+	survivors := make(map[string][]*simv1.Service)
+	for _, svc := range s.services {
+		if _, drained := s.drains[svc.NodeId.GetValue()]; !drained {
+			survivors[svc.Name] = append(survivors[svc.Name], svc)
+		}
+	}
+	for id := range s.drains {
+		if node, ok := s.nodes[id]; ok {
+			node.Status = commonv1.NodeStatus_NODE_STATUS_OFFLINE
+		}
+	}
+
+	lost := make(map[string]float64) // service name -> RPS displaced
+	for _, svc := range s.services {
+		start, drained := s.drains[svc.NodeId.GetValue()]
+		if !drained {
+			continue
+		}
+		shift := min(float64(s.tickID-start)/RebalanceTicks, 1)
+		if len(survivors[svc.Name]) == 0 {
+			svc.ErrorRatePercent = clamp(max(svc.ErrorRatePercent, shift*100), 0, 100)
+			svc.Health = commonv1.ServiceHealth_SERVICE_HEALTH_CRITICAL
+			continue
+		}
+		lost[svc.Name] += svc.RequestsPerSecond * shift
+		svc.RequestsPerSecond *= 1 - shift
+	}
+
+	for name, rps := range lost {
+		targets := survivors[name]
+		share := rps / float64(len(targets))
+		for _, svc := range targets {
+			svc.RequestsPerSecond = clamp(svc.RequestsPerSecond+share, 0, 10000)
+		}
+	}
+}
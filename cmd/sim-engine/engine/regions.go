@@ -0,0 +1,216 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+)
+
+// PrimaryRegion is the region every other region replicates from
+const PrimaryRegion = "us-east-1"
+
+// Replication lag model, in milliseconds
+const (
+	baseReplicationLagMs = 30
+	lagPerCPUPercentMs   = 2      // primary load slows replication
+	failedLagGrowthMs    = 1000   // lag added per tick while replication is down
+	maxReplicationLagMs  = 600000 // 10 minutes
+)
+
+// region tracks failure and replication state for one region
+type region struct {
+	name   string
+	failed bool
+	lagMs  float64
+}
+
+// regionForZone derives the region from an availability zone, e.g.
+// "us-east-1a" -> "us-east-1"
+func regionForZone(zone string) string {
+	return strings.TrimRight(zone, "abcdefghijklmnopqrstuvwxyz")
+}
+
+// initRegions tags nodes and services with their region and creates the
+// region table. Caller must hold mu.
+func (s *State) initRegions() {
+	s.regions = make(map[string]*region)
+	for _, node := range s.nodes {
+		node.Region = regionForZone(node.AvailabilityZone)
+		if _, ok := s.regions[node.Region]; !ok {
+			s.regions[node.Region] = &region{name: node.Region, lagMs: baseReplicationLagMs}
+		}
+	}
+	if r, ok := s.regions[PrimaryRegion]; ok {
+		r.lagMs = 0
+	}
+	for _, svc := range s.services {
+		if node, ok := s.nodes[svc.NodeId.GetValue()]; ok {
+			svc.Region = node.Region
+		}
+	}
+}
+
+// FailRegion takes every node in the region offline and fails its services
+// until RecoverRegion is called
+func (s *State) FailRegion(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.setRegionFailed(name, true)
+}
+
+// RecoverRegion brings a failed region back. Replication lag then drains
+// gradually as the region catches up.
+func (s *State) RecoverRegion(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.setRegionFailed(name, false)
+}
+
+// setRegionFailed flips the failure state of a region. Caller must hold mu.
+func (s *State) setRegionFailed(name string, failed bool) error {
+	r, ok := s.regions[name]
+	if !ok {
+		return fmt.Errorf("unknown region: %s", name)
+	}
+	r.failed = failed
+	if failed {
+		s.enforceRegionFailures()
+		return nil
+	}
+
+	for _, node := range s.nodes {
+		if node.Region == name {
+			node.Status = commonv1.NodeStatus_NODE_STATUS_HEALTHY
+		}
+	}
+	for _, svc := range s.services {
+		if svc.Region == name {
+			svc.ErrorRatePercent = 0.1
+			svc.Health = commonv1.ServiceHealth_SERVICE_HEALTH_HEALTHY
+		}
+	}
+	return nil
+}
+
+// enforceRegionFailures keeps failed regions down after the regular updates
+// and shifts their traffic onto instances of the same service elsewhere.
+// Caller must hold mu.
+func (s *State) enforceRegionFailures() {
+	lost := make(map[string]float64) // service name -> RPS lost
+	survivors := make(map[string][]*simv1.Service)
+
+	for _, node := range s.nodes {
+		if s.regionFailed(node.Region) {
+			node.Status = commonv1.NodeStatus_NODE_STATUS_OFFLINE
+		}
+	}
+	for _, svc := range s.services {
+		if !s.regionFailed(svc.Region) {
+			survivors[svc.Name] = append(survivors[svc.Name], svc)
+			continue
+		}
+		lost[svc.Name] += svc.RequestsPerSecond
+		svc.RequestsPerSecond = 0
+		svc.ErrorRatePercent = 100
+		svc.Health = commonv1.ServiceHealth_SERVICE_HEALTH_CRITICAL
+	}
+
+	for name, rps := range lost {
+		targets := survivors[name]
+		if len(targets) == 0 {
+			continue
+		}
+		share := rps / float64(len(targets))
+		for _, svc := range targets {
+			svc.RequestsPerSecond = clamp(svc.RequestsPerSecond+share, 0, 10000)
+		}
+	}
+}
+
+// regionFailed reports whether a region is down. Caller must hold mu.
+func (s *State) regionFailed(name string) bool {
+	r, ok := s.regions[name]
+	return ok && r.failed
+}
+
+// updateRegions advances replication lag for every secondary region.
+// Caller must hold mu.
+func (s *State) updateRegions() {
+	primary, ok := s.regions[PrimaryRegion]
+	if !ok {
+		return
+	}
+
+	var cpu float64
+	var count int
+	for _, node := range s.nodes {
+		if node.Region == PrimaryRegion {
+			cpu += node.CpuUsagePercent
+			count++
+		}
+	}
+	if count > 0 {
+		cpu /= float64(count)
+	}
+	target := baseReplicationLagMs + lagPerCPUPercentMs*cpu
+
+	for _, name := range sortedIDs(s.regions) {
+		r := s.regions[name]
+		if r == primary {
+			continue
+		}
+		if r.failed || primary.failed {
+			r.lagMs = clamp(r.lagMs+failedLagGrowthMs, 0, maxReplicationLagMs)
+			continue
+		}
+		// Converge toward the target so a recovered region catches up over time
+		r.lagMs = clamp(r.lagMs*0.8+target*0.2+randDelta(s.rng, 5), 0, maxReplicationLagMs)
+	}
+}
+
+// regionStats summarizes each region for a snapshot. Caller must hold mu.
+func (s *State) regionStats() []*simv1.RegionStats {
+	byName := make(map[string]*simv1.RegionStats, len(s.regions))
+	stats := make([]*simv1.RegionStats, 0, len(s.regions))
+	for name, r := range s.regions {
+		rs := &simv1.RegionStats{
+			Name:             name,
+			Primary:          name == PrimaryRegion,
+			Failed:           r.failed,
+			ReplicationLagMs: r.lagMs,
+		}
+		byName[name] = rs
+		stats = append(stats, rs)
+	}
+
+	for _, node := range s.nodes {
+		rs, ok := byName[node.Region]
+		if !ok {
+			continue
+		}
+		rs.TotalNodes++
+		if node.Status == commonv1.NodeStatus_NODE_STATUS_HEALTHY {
+			rs.HealthyNodes++
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, svc := range s.services {
+		rs, ok := byName[svc.Region]
+		if !ok {
+			continue
+		}
+		rs.TotalRps += svc.RequestsPerSecond
+		rs.AvgErrorRate += svc.ErrorRatePercent
+		counts[svc.Region]++
+	}
+	for name, n := range counts {
+		byName[name].AvgErrorRate /= float64(n)
+	}
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
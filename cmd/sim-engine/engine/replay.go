@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protodelim"
+
+	"github.com/microcloud/bus"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+)
+
+// Recorder writes published snapshots as length-delimited protobuf records
+type Recorder struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+// NewRecorder creates a recorder writing to w
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: bufio.NewWriter(w)}
+}
+
+// Record appends a snapshot to the recording
+func (r *Recorder) Record(snapshot *simv1.MetricSnapshot) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := protodelim.MarshalTo(r.w, snapshot); err != nil {
+		return fmt.Errorf("record snapshot: %w", err)
+	}
+	return nil
+}
+
+// Flush writes any buffered records to the underlying writer
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.w.Flush()
+}
+
+// Replay re-publishes a recording made by Recorder. Snapshots are spaced by
+// their original wall-clock gaps divided by speed (2.0 replays twice as fast).
+func Replay(ctx context.Context, r io.Reader, publisher *bus.Publisher, speed float64, log *slog.Logger) error {
+	if speed <= 0 {
+		speed = 1
+	}
+
+	br := bufio.NewReader(r)
+	var prevWallMs int64
+	count := 0
+
+	log.Info("replay started", "speed", speed)
+
+	for {
+		var snapshot simv1.MetricSnapshot
+		if err := protodelim.UnmarshalFrom(br, &snapshot); err != nil {
+			if errors.Is(err, io.EOF) {
+				log.Info("replay finished", "snapshots", count)
+				return nil
+			}
+			return fmt.Errorf("read snapshot %d: %w", count, err)
+		}
+
+		wallMs := snapshot.GetTimestamp().GetWallTimeUnixMs()
+		if prevWallMs > 0 && wallMs > prevWallMs {
+			delay := time.Duration(float64(wallMs-prevWallMs)/speed) * time.Millisecond
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+		prevWallMs = wallMs
+
+		if err := publisher.PublishMetricSnapshot(ctx, &snapshot); err != nil {
+			log.Error("failed to publish replayed snapshot", "error", err)
+		}
+		count++
+	}
+}
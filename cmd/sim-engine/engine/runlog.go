@@ -0,0 +1,337 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+)
+
+// Run log entry kinds
+const (
+	RunLogStart    = "start"
+	RunLogScenario = "scenario"
+	RunLogFault    = "fault"
+	RunLogAction   = "action"
+	RunLogDigest   = "digest"
+)
+
+// runLogDigestEvery is how many ticks pass between logged state digests
+const runLogDigestEvery = 100
+
+// RunLogEntry is one line of a run log. The start entry holds everything
+// the initial state is derived from; every later entry records a command
+// and the tick it was applied at, i.e. the number of ticks run before it.
+type RunLogEntry struct {
+	Kind   string `json:"kind"`
+	TickID int64  `json:"tick_id"`
+
+	// start
+	RunID          string        `json:"run_id,omitempty"`
+	Seed           int64         `json:"seed,omitempty"`
+	SimStartUnixMs int64         `json:"sim_start_unix_ms,omitempty"`
+	Dynamics       string        `json:"dynamics,omitempty"`
+	Topology       *TopologySpec `json:"topology,omitempty"`
+	TopologySize   *TopologySize `json:"topology_size,omitempty"`
+
+	// scenario
+	Scenario string            `json:"scenario,omitempty"`
+	Params   map[string]string `json:"params,omitempty"` // also action
+
+	// fault
+	TargetID   string  `json:"target_id,omitempty"` // also action
+	FaultType  string  `json:"fault_type,omitempty"`
+	Magnitude  float64 `json:"magnitude,omitempty"`
+	DurationMs int64   `json:"duration_ms,omitempty"`
+
+	// action
+	ActionType string `json:"action_type,omitempty"`
+
+	// digest
+	Digest string `json:"digest,omitempty"`
+}
+
+// runLog appends entries to a run log file
+type runLog struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// runLogPath returns the file a run is logged to
+func runLogPath(dir, runID string) (string, error) {
+	if runID == "" || filepath.Base(runID) != runID {
+		return "", fmt.Errorf("invalid run id %q", runID)
+	}
+	return filepath.Join(dir, runID+".jsonl"), nil
+}
+
+func createRunLog(dir, runID string) (*runLog, error) {
+	path, err := runLogPath(dir, runID)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create run log dir: %w", err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create run log: %w", err)
+	}
+	return &runLog{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// write appends an entry unbuffered, so the log survives a crash
+func (l *runLog) write(entry RunLogEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(entry); err != nil {
+		return fmt.Errorf("write run log: %w", err)
+	}
+	return nil
+}
+
+// readRunLog reads every entry of a run log, checking it starts with a
+// start entry
+func readRunLog(dir, runID string) ([]RunLogEntry, error) {
+	path, err := runLogPath(dir, runID)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open run log: %w", err)
+	}
+	defer f.Close()
+
+	var entries []RunLogEntry
+	dec := json.NewDecoder(f)
+	for {
+		var entry RunLogEntry
+		err := dec.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read run log entry %d: %w", len(entries)+1, err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 || entries[0].Kind != RunLogStart {
+		return nil, fmt.Errorf("run log %s has no start entry", path)
+	}
+	return entries, nil
+}
+
+// RunID returns the ID the run is logged under, or "" without WithRunLog
+func (e *Engine) RunID() string {
+	if e.replay != nil {
+		return e.replay.runID
+	}
+	return e.runID
+}
+
+// logRun appends an entry to the run log, starting the log on first use.
+// Failures are logged; the command has already been applied. Caller must
+// hold cmdMu.
+func (e *Engine) logRun(entry RunLogEntry) {
+	if e.runLogDir == "" || e.replay != nil {
+		return
+	}
+	e.runLogOnce.Do(e.startRunLog)
+	if e.runLog == nil {
+		return
+	}
+	if err := e.runLog.write(entry); err != nil {
+		e.log.Error("failed to log run entry", "run_id", e.runID, "kind", entry.Kind, "error", err)
+	}
+}
+
+// startRunLog creates the run log and writes its start entry
+func (e *Engine) startRunLog() {
+	l, err := createRunLog(e.runLogDir, e.runID)
+	if err != nil {
+		e.log.Error("run log disabled", "error", err)
+		return
+	}
+	start := RunLogEntry{
+		Kind:           RunLogStart,
+		RunID:          e.runID,
+		Seed:           e.runSeed,
+		SimStartUnixMs: e.simStartMs,
+		Dynamics:       e.state.DynamicsName(),
+		Topology:       e.topology,
+		TopologySize:   e.topologySize,
+	}
+	if err := l.write(start); err != nil {
+		e.log.Error("run log disabled", "error", err)
+		l.f.Close()
+		return
+	}
+	e.runLog = l
+	e.log.Info("logging run", "run_id", e.runID, "seed", e.runSeed, "file", l.f.Name())
+}
+
+// step runs one simulation tick. Live runs log a state digest every
+// runLogDigestEvery ticks; replays reapply the commands due first and check
+// the digest against the logged one.
+func (e *Engine) step(ctx context.Context) {
+	if e.replay != nil {
+		e.replayDue(ctx)
+	}
+
+	e.cmdMu.Lock()
+	defer e.cmdMu.Unlock()
+
+	e.state.Tick(e.tickInterval)
+	tick := e.state.GetTickID()
+	if e.runLogDir == "" || tick%runLogDigestEvery != 0 {
+		return
+	}
+	if e.replay != nil {
+		e.replay.check(tick, e.state.digest(), e.log)
+		return
+	}
+	e.logRun(RunLogEntry{Kind: RunLogDigest, TickID: tick, Digest: e.state.digest()})
+}
+
+// ReplayRun switches the engine to re-deriving a logged run, for forensic
+// analysis of past incidents. It rebuilds the run's initial state from its
+// seed and starts the simulation; Run then reapplies each logged command at
+// the tick it was applied live, publishing snapshots as the original run
+// did. Speed, pause and further commands work as in a live run but are not
+// logged. A digest mismatch means the replay no longer matches the run,
+// usually because the simulation code changed since. Call it before Run.
+func (e *Engine) ReplayRun(runID string) error {
+	if e.runLogDir == "" {
+		return errors.New("replaying a run requires a run log directory")
+	}
+	entries, err := readRunLog(e.runLogDir, runID)
+	if err != nil {
+		return err
+	}
+	start := entries[0]
+
+	state := newSeededState(start.Seed)
+	if start.TopologySize != nil {
+		if err := state.GenerateTopology(*start.TopologySize); err != nil {
+			return fmt.Errorf("run %s: %w", runID, err)
+		}
+	}
+	if start.Topology != nil {
+		state.LoadTopology(start.Topology)
+	}
+	if start.Dynamics != "" {
+		dyn, err := NewDynamics(start.Dynamics)
+		if err != nil {
+			return fmt.Errorf("run %s: %w", runID, err)
+		}
+		state.SetDynamics(dyn)
+	}
+	state.setSimStart(start.SimStartUnixMs)
+	state.profile = e.profile
+
+	e.state = state
+	e.replay = &runReplay{runID: runID, entries: entries[1:]}
+	e.preroll.Store(0)
+	e.state.SetSimState(commonv1.SimulationState_SIMULATION_STATE_RUNNING)
+	e.log.Info("replaying run", "run_id", runID, "seed", start.Seed, "entries", len(entries)-1)
+	return nil
+}
+
+// runReplay tracks progress through a logged run. Only the tick loop
+// touches it.
+type runReplay struct {
+	runID    string
+	entries  []RunLogEntry
+	next     int
+	diverged bool
+	done     bool
+}
+
+// replayDue reapplies the logged commands applied before the next tick
+func (e *Engine) replayDue(ctx context.Context) {
+	r := e.replay
+	tick := e.state.GetTickID()
+	for r.next < len(r.entries) && r.entries[r.next].TickID <= tick {
+		entry := r.entries[r.next]
+		r.next++
+		if entry.Kind == RunLogDigest {
+			continue
+		}
+		if err := e.applyRunEntry(ctx, entry); err != nil {
+			e.log.Warn("replayed command failed", "run_id", r.runID, "tick_id", tick, "kind", entry.Kind, "error", err)
+		}
+	}
+	if r.next == len(r.entries) && !r.done {
+		r.done = true
+		e.log.Info("run replay complete; simulation continues live", "run_id", r.runID, "tick_id", tick)
+	}
+}
+
+// applyRunEntry applies a logged command
+func (e *Engine) applyRunEntry(ctx context.Context, entry RunLogEntry) error {
+	switch entry.Kind {
+	case RunLogScenario:
+		return e.LoadScenario(ctx, entry.Scenario, entry.Params)
+	case RunLogFault:
+		_, err := e.InjectFault(ctx, entry.TargetID, entry.FaultType, entry.Magnitude, time.Duration(entry.DurationMs)*time.Millisecond)
+		return err
+	case RunLogAction:
+		actionType, ok := commonv1.ActionType_value[entry.ActionType]
+		if !ok {
+			return fmt.Errorf("unknown action type %q", entry.ActionType)
+		}
+		_, err := e.ApplyCommand(ctx, commonv1.ActionType(actionType), entry.TargetID, entry.Params)
+		return err
+	default:
+		return fmt.Errorf("unknown run log entry kind %q", entry.Kind)
+	}
+}
+
+// check compares the state digest after tick with the logged one, warning
+// on the first divergence only since every later digest differs too
+func (r *runReplay) check(tick int64, digest string, log *slog.Logger) {
+	if r.next >= len(r.entries) {
+		return
+	}
+	entry := r.entries[r.next]
+	if entry.Kind != RunLogDigest || entry.TickID != tick {
+		return
+	}
+	r.next++
+	if entry.Digest != digest && !r.diverged {
+		r.diverged = true
+		log.Warn("replay diverged from the logged run", "run_id", r.runID, "tick_id", tick, "logged", entry.Digest, "replayed", digest)
+	}
+}
+
+// digest fingerprints every node and service, to check a replay against
+// its run
+func (s *State) digest() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	h := fnv.New64a()
+	opts := proto.MarshalOptions{Deterministic: true}
+	for _, id := range sortedIDs(s.nodes) {
+		b, _ := opts.Marshal(s.nodes[id])
+		h.Write(b)
+	}
+	for _, id := range sortedIDs(s.services) {
+		b, _ := opts.Marshal(s.services[id])
+		h.Write(b)
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
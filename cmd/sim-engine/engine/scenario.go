@@ -0,0 +1,148 @@
+package engine
+
+import "sort"
+
+// Scenario describes a named simulation scenario
+type Scenario struct {
+	Name        string
+	Description string
+
+	// TrafficProfiles maps service names to traffic profile names.
+	// The "*" key applies to any service without an explicit entry.
+	TrafficProfiles map[string]string
+
+	// Script is an optional timeline of mutations applied as ticks advance
+	Script []ScriptStep
+
+	// Chaos enables random failures at these default rates. LoadScenario
+	// parameters override them.
+	Chaos *ChaosConfig
+
+	// CustomMetrics registers extra metric channels on services
+	CustomMetrics []CustomMetric
+}
+
+// profileFor returns the traffic profile name configured for a service
+func (sc Scenario) profileFor(serviceName string) string {
+	if name, ok := sc.TrafficProfiles[serviceName]; ok {
+		return name
+	}
+	return sc.TrafficProfiles["*"]
+}
+
+var defaultChaos = DefaultChaosConfig()
+
+var scenarios = map[string]Scenario{
+	"normal": {
+		Name:        "normal",
+		Description: "Steady traffic with small random fluctuations",
+		TrafficProfiles: map[string]string{
+			"*":           ProfileSteady,
+			"api-gateway": ProfileDiurnal,
+		},
+	},
+	"high_load": {
+		Name:        "high_load",
+		Description: "Traffic ramps up across the fleet while node CPU climbs",
+		TrafficProfiles: map[string]string{
+			"*":              ProfileRamp,
+			"search-service": ProfileFlashCrowd,
+		},
+	},
+	"cascade_failure": {
+		Name:        "cascade_failure",
+		Description: "Random services fail with large error-rate jumps",
+		TrafficProfiles: map[string]string{
+			"*":               ProfileSteady,
+			"order-service":   ProfileSpike,
+			"payment-service": ProfileSpike,
+		},
+		Chaos: &ChaosConfig{
+			ErrorSpikeProbability: 0.05,
+			ErrorSpikeMin:         20,
+			ErrorSpikeMax:         20,
+		},
+	},
+	"random_chaos": {
+		Name:        "random_chaos",
+		Description: "Nodes crash and services spike errors and latency at random, with tunable rates",
+		TrafficProfiles: map[string]string{
+			"*": ProfileSteady,
+		},
+		Chaos: &defaultChaos,
+	},
+	"node_failure_drill": {
+		Name:        "node_failure_drill",
+		Description: "Scripted CPU spike on node-alpha and error burst on payment-service, both recovering later",
+		TrafficProfiles: map[string]string{
+			"*": ProfileSteady,
+		},
+		Script: []ScriptStep{
+			{AtTick: 500, Target: "node-alpha", Op: OpSet, Metric: MetricCPU, Value: 97},
+			{AtTick: 600, Target: "payment-service", Op: OpAdd, Metric: MetricErrorRate, Value: 15},
+			{AtTick: 900, Target: "node-alpha", Op: OpRecover},
+			{AtTick: 1000, Target: "payment-service", Op: OpRecover},
+		},
+	},
+	"memory_leak": {
+		Name:        "memory_leak",
+		Description: "order-service leaks memory until it is restarted or OOM-killed",
+		TrafficProfiles: map[string]string{
+			"*": ProfileSteady,
+		},
+		Script: []ScriptStep{
+			{AtTick: 100, Target: "order-service", Op: OpLeak, Value: DefaultLeakRate},
+		},
+	},
+	"region_outage": {
+		Name:        "region_outage",
+		Description: "us-west-2 fails and shifts its traffic to the remaining regions, then recovers and catches up on replication",
+		TrafficProfiles: map[string]string{
+			"*":           ProfileSteady,
+			"api-gateway": ProfileDiurnal,
+		},
+		Script: []ScriptStep{
+			{AtTick: 300, Target: "us-west-2", Op: OpFailRegion},
+			{AtTick: 900, Target: "us-west-2", Op: OpRecoverRegion},
+		},
+	},
+	"gc_pressure": {
+		Name:        "gc_pressure",
+		Description: "order-service GC pauses grow and the fleet's cache hit ratio drops, then both recover",
+		TrafficProfiles: map[string]string{
+			"*": ProfileSteady,
+		},
+		CustomMetrics: []CustomMetric{
+			{Name: "gc_pause_ms", Services: []string{"order-service", "payment-service"}, Baseline: 15, Volatility: 3, Min: 0, Max: 2000},
+			{Name: "cache_hit_ratio", Baseline: 0.95, Volatility: 0.01, Min: 0, Max: 1},
+		},
+		Script: []ScriptStep{
+			{AtTick: 200, Target: "order-service", Op: OpSet, Metric: "gc_pause_ms", Value: 450},
+			{AtTick: 250, Target: "search-service", Op: OpAdd, Metric: "cache_hit_ratio", Value: -0.5},
+			{AtTick: 700, Target: "order-service", Op: OpRecover},
+			{AtTick: 700, Target: "search-service", Op: OpRecover},
+		},
+	},
+}
+
+// RegisterScenario adds or replaces a scenario in the registry.
+// It is not safe for concurrent use and should be called before the engine starts.
+func RegisterScenario(sc Scenario) {
+	scenarios[sc.Name] = sc
+}
+
+// LookupScenario returns the scenario registered under name
+func LookupScenario(name string) (Scenario, bool) {
+	sc, ok := scenarios[name]
+	return sc, ok
+}
+
+// Scenarios returns the registered scenarios sorted by name
+func Scenarios() []Scenario {
+	list := make([]Scenario, 0, len(scenarios))
+	for _, sc := range scenarios {
+		list = append(list, sc)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
@@ -0,0 +1,315 @@
+package engine
+
+import (
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+)
+
+// CrashEvictionTicks is how long a crashed node keeps its replicas before
+// the scheduler places them elsewhere. Drained nodes are evicted at once.
+const CrashEvictionTicks = 5
+
+// placement counts a service's replicas per node ID
+type placement map[string]int32
+
+func (p placement) total() int32 {
+	var n int32
+	for _, count := range p {
+		n += count
+	}
+	return n
+}
+
+// primary returns the node holding the most replicas, keeping current on a
+// tie so the service's node only changes when its replicas do
+func (p placement) primary(current string) string {
+	best := ""
+	for id, count := range p {
+		switch {
+		case best == "",
+			count > p[best],
+			count == p[best] && (id == current || (best != current && id < best)):
+			best = id
+		}
+	}
+	return best
+}
+
+// reservation is the CPU and memory requested on a node
+type reservation struct {
+	cpu float64 // millicores
+	mem float64 // MB
+}
+
+func (r reservation) add(o reservation, n int32) reservation {
+	return reservation{cpu: r.cpu + o.cpu*float64(n), mem: r.mem + o.mem*float64(n)}
+}
+
+// request is a single replica's reservation
+func request(svc *simv1.Service) reservation {
+	return reservation{cpu: float64(svc.CpuRequestMillicores), mem: float64(svc.MemoryRequestMb)}
+}
+
+// initPlacements puts the first replica of every service on its node, as
+// the topology laid it out, and schedules the rest. Caller must hold mu.
+func (s *State) initPlacements() {
+	s.placements = make(map[string]placement, len(s.services))
+	for _, id := range sortedIDs(s.services) {
+		if nodeID := s.services[id].NodeId.GetValue(); s.nodes[nodeID] != nil {
+			s.placements[id] = placement{nodeID: 1}
+		}
+	}
+	for _, node := range s.nodes {
+		node.RunningServices = 0
+	}
+	for _, p := range s.placements {
+		for nodeID := range p {
+			s.nodes[nodeID].RunningServices++
+		}
+	}
+}
+
+// reservations sums the requests of the replicas placed on each node.
+// Caller must hold mu.
+func (s *State) reservations() map[string]reservation {
+	reserved := make(map[string]reservation, len(s.nodes))
+	for id, p := range s.placements {
+		svc, ok := s.services[id]
+		if !ok {
+			continue
+		}
+		req := request(svc)
+		for nodeID, n := range p {
+			reserved[nodeID] = reserved[nodeID].add(req, n)
+		}
+	}
+	return reserved
+}
+
+// canPlace reports whether the scheduler may put replicas on a node.
+// Caller must hold mu.
+func (s *State) canPlace(node *simv1.Node) bool {
+	if !isSchedulable(node) || s.regionFailed(node.Region) {
+		return false
+	}
+	_, crashed := s.crashes[node.Id.GetValue()]
+	return !crashed
+}
+
+// evicting returns the nodes whose replicas must move: drained nodes and
+// nodes crashed for CrashEvictionTicks. Caller must hold mu.
+func (s *State) evicting() map[string]bool {
+	for id := range s.crashedSince {
+		if _, ok := s.crashes[id]; !ok {
+			delete(s.crashedSince, id)
+		}
+	}
+	var out map[string]bool
+	for id := range s.crashes {
+		if s.crashedSince == nil {
+			s.crashedSince = make(map[string]int64)
+		}
+		since, ok := s.crashedSince[id]
+		if !ok {
+			s.crashedSince[id] = s.tickID
+			since = s.tickID
+		}
+		if s.tickID-since >= CrashEvictionTicks {
+			if out == nil {
+				out = make(map[string]bool)
+			}
+			out[id] = true
+		}
+	}
+	for id := range s.drains {
+		if out == nil {
+			out = make(map[string]bool)
+		}
+		out[id] = true
+	}
+	return out
+}
+
+// schedule reconciles every service's placement with its replica count:
+// replicas on evicted nodes move, scale-downs remove replicas and new or
+// displaced replicas are placed by pickNode. Replicas that fit nowhere stay
+// pending and are retried each tick. Caller must hold mu.
+func (s *State) schedule() {
+	if s.placements == nil {
+		s.initPlacements()
+	}
+	evict := s.evicting()
+
+	var reserved map[string]reservation // built on first change
+	for _, id := range sortedIDs(s.services) {
+		svc := s.services[id]
+		current := s.placements[id]
+		moving := false
+		for nodeID := range current {
+			if evict[nodeID] || s.nodes[nodeID] == nil {
+				moving = true
+				break
+			}
+		}
+		if !moving && current.total() == svc.ReplicaCount {
+			continue
+		}
+		if reserved == nil {
+			reserved = s.reservations()
+		}
+		next := make(placement, len(current))
+		for nodeID, n := range current {
+			if !evict[nodeID] && s.nodes[nodeID] != nil {
+				next[nodeID] = n
+			}
+		}
+		s.reconcile(svc, next, reserved)
+	}
+}
+
+// reconcile adds or removes replicas from next until it matches the
+// service's replica count, or no node fits another, and applies it.
+// reserved must not include the replicas in the old placement that next
+// dropped; they are released here. Caller must hold mu.
+func (s *State) reconcile(svc *simv1.Service, next placement, reserved map[string]reservation) {
+	req := request(svc)
+	for nodeID, n := range s.placements[svc.Id.GetValue()] {
+		if dropped := n - next[nodeID]; dropped > 0 {
+			reserved[nodeID] = reserved[nodeID].add(req, -dropped)
+		}
+	}
+
+	for next.total() > svc.ReplicaCount {
+		nodeID := s.pickRemoval(next, reserved)
+		if next[nodeID]--; next[nodeID] == 0 {
+			delete(next, nodeID)
+		}
+		reserved[nodeID] = reserved[nodeID].add(req, -1)
+	}
+	for next.total() < svc.ReplicaCount {
+		node := s.pickNode(next, req, reserved)
+		if node == nil {
+			break
+		}
+		next[node.Id.Value]++
+		reserved[node.Id.Value] = reserved[node.Id.Value].add(req, 1)
+	}
+	s.setPlacement(svc, next)
+}
+
+// zoneCounts returns a placement's replicas per zone. Caller must hold mu.
+func (s *State) zoneCounts(p placement) map[string]int32 {
+	zones := make(map[string]int32)
+	for nodeID, n := range p {
+		if node, ok := s.nodes[nodeID]; ok {
+			zones[node.AvailabilityZone] += n
+		}
+	}
+	return zones
+}
+
+// pickNode returns the node for a service's next replica: among the
+// placeable nodes with room for req, the one in the zone with the fewest of
+// the service's replicas, then the node with the fewest, then the one left
+// with the least free CPU (best fit, packing nodes before spilling onto
+// empty ones). Returns nil if no node has room. Caller must hold mu.
+func (s *State) pickNode(p placement, req reservation, reserved map[string]reservation) *simv1.Node {
+	zones := s.zoneCounts(p)
+
+	var best *simv1.Node
+	var bestZone, bestCount int32
+	var bestFree float64
+	for id, node := range s.nodes {
+		if !s.canPlace(node) {
+			continue
+		}
+		after := reserved[id].add(req, 1)
+		if after.cpu > float64(node.CpuCapacityMillicores) || after.mem > float64(node.MemoryCapacityMb) {
+			continue
+		}
+		zone, count := zones[node.AvailabilityZone], p[id]
+		free := float64(node.CpuCapacityMillicores) - after.cpu
+		switch {
+		case best == nil,
+			zone < bestZone,
+			zone == bestZone && count < bestCount,
+			zone == bestZone && count == bestCount && free < bestFree,
+			zone == bestZone && count == bestCount && free == bestFree && id < best.Id.Value:
+			best, bestZone, bestCount, bestFree = node, zone, count, free
+		}
+	}
+	return best
+}
+
+// pickRemoval returns the node to take a replica off when scaling down:
+// the one in the zone with the most of the service's replicas, then the node
+// with the most, then the one with the most CPU reserved. Caller must hold mu.
+func (s *State) pickRemoval(p placement, reserved map[string]reservation) string {
+	zones := s.zoneCounts(p)
+
+	best := ""
+	var bestZone, bestCount int32
+	var bestLoad float64
+	for id, count := range p {
+		zone := zones[s.nodes[id].GetAvailabilityZone()]
+		load := percentOf(reserved[id].cpu, float64(s.nodes[id].GetCpuCapacityMillicores()))
+		switch {
+		case best == "",
+			zone > bestZone,
+			zone == bestZone && count > bestCount,
+			zone == bestZone && count == bestCount && load > bestLoad,
+			zone == bestZone && count == bestCount && load == bestLoad && id < best:
+			best, bestZone, bestCount, bestLoad = id, zone, count, load
+		}
+	}
+	return best
+}
+
+// setPlacement applies a service's placement, updating node service counts
+// and moving the service to the node holding most of its replicas. A
+// service that lost every replica on its old node restarts on the new one.
+// A service with no replicas placed stays on its old node. Caller must
+// hold mu.
+func (s *State) setPlacement(svc *simv1.Service, next placement) {
+	id := svc.Id.GetValue()
+	prev := s.placements[id]
+	for nodeID := range prev {
+		if _, ok := next[nodeID]; !ok {
+			if node, ok := s.nodes[nodeID]; ok && node.RunningServices > 0 {
+				node.RunningServices--
+			}
+		}
+	}
+	for nodeID := range next {
+		if _, ok := prev[nodeID]; !ok {
+			s.nodes[nodeID].RunningServices++
+		}
+	}
+	s.placements[id] = next
+
+	from := svc.NodeId.GetValue()
+	to := next.primary(from)
+	if to == "" || to == from {
+		return
+	}
+	svc.NodeId = &commonv1.UUID{Value: to}
+	svc.Region = s.nodes[to].Region
+	if _, stayed := next[from]; stayed {
+		return
+	}
+	s.clearMemory(id)
+	if _, crashed := s.crashes[from]; crashed {
+		svc.ErrorRatePercent = s.rng.Float64() * 0.5
+		svc.Health = commonv1.ServiceHealth_SERVICE_HEALTH_HEALTHY
+	}
+}
+
+// pendingReplicas returns how many of a service's replicas no node has room
+// for. Caller must hold mu.
+func (s *State) pendingReplicas(serviceID string) int32 {
+	svc, ok := s.services[serviceID]
+	if !ok {
+		return 0
+	}
+	return max(svc.ReplicaCount-s.placements[serviceID].total(), 0)
+}
@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"sort"
+)
+
+// Script step operations
+const (
+	OpSet     = "set"     // set Metric to Value
+	OpAdd     = "add"     // add Value to Metric
+	OpRecover = "recover" // reset the target to a healthy baseline
+	OpLeak    = "leak"    // start a memory leak of Value percent per tick on a service
+
+	// Region operations; Target is a region name and Metric is ignored
+	OpFailRegion    = "fail_region"
+	OpRecoverRegion = "recover_region"
+)
+
+// Script step metrics
+const (
+	MetricCPU       = "cpu"
+	MetricMemory    = "memory"
+	MetricErrorRate = "error_rate"
+	MetricLatency   = "latency" // p99 latency
+
+	// Any other Metric names a custom metric registered by the scenario
+)
+
+// ScriptStep is a single mutation in a timed scenario script
+type ScriptStep struct {
+	AtTick int64  // ticks after the scenario was loaded
+	Target string // node or service name (or ID), or region for region ops; services match every instance
+	Op     string
+	Metric string
+	Value  float64
+}
+
+// scriptRun tracks progress through a scenario script
+type scriptRun struct {
+	steps     []ScriptStep
+	startTick int64
+	next      int
+}
+
+func newScriptRun(steps []ScriptStep, startTick int64) *scriptRun {
+	sorted := append([]ScriptStep(nil), steps...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].AtTick < sorted[j].AtTick })
+	return &scriptRun{steps: sorted, startTick: startTick}
+}
+
+// done reports whether every step has been applied
+func (r *scriptRun) done() bool {
+	return r.next >= len(r.steps)
+}
+
+// runScript applies all script steps that are due at the current tick.
+// Caller must hold mu.
+func (s *State) runScript() {
+	if s.script == nil {
+		return
+	}
+	elapsed := s.tickID - s.script.startTick
+	for !s.script.done() && s.script.steps[s.script.next].AtTick <= elapsed {
+		s.applyStep(s.script.steps[s.script.next])
+		s.script.next++
+	}
+	if s.script.done() {
+		s.script = nil
+	}
+}
+
+// applyStep mutates every node and service matching the step target.
+// Caller must hold mu.
+func (s *State) applyStep(step ScriptStep) {
+	switch step.Op {
+	case OpFailRegion, OpRecoverRegion:
+		// Unknown regions are ignored like unknown node and service names
+		_ = s.setRegionFailed(step.Target, step.Op == OpFailRegion)
+		return
+	}
+
+	for id, node := range s.nodes {
+		if id != step.Target && node.Name != step.Target {
+			continue
+		}
+		switch {
+		case step.Op == OpRecover:
+			node.CpuUsagePercent = 20
+			node.MemoryUsagePercent = 30
+		case step.Metric == MetricCPU:
+			node.CpuUsagePercent = clamp(stepValue(step, node.CpuUsagePercent), 0, 100)
+		case step.Metric == MetricMemory:
+			node.MemoryUsagePercent = clamp(stepValue(step, node.MemoryUsagePercent), 0, 100)
+		}
+	}
+
+	for id, svc := range s.services {
+		if id != step.Target && svc.Name != step.Target {
+			continue
+		}
+		switch {
+		case step.Op == OpLeak:
+			s.setLeak(id, step.Value)
+		case step.Op == OpRecover:
+			s.clearMemory(id)
+			svc.ErrorRatePercent = 0.1
+			svc.LatencyP50Ms = 5
+			svc.LatencyP99Ms = 20
+			for name := range svc.CustomMetrics {
+				if m, ok := s.customMetric(name); ok {
+					svc.CustomMetrics[name] = m.Baseline
+				}
+			}
+		case step.Metric == MetricErrorRate:
+			svc.ErrorRatePercent = clamp(stepValue(step, svc.ErrorRatePercent), 0, 100)
+		case step.Metric == MetricLatency:
+			svc.LatencyP99Ms = clamp(stepValue(step, svc.LatencyP99Ms), 1, 5000)
+			svc.LatencyP50Ms = clamp(svc.LatencyP50Ms, 1, svc.LatencyP99Ms)
+		default:
+			if m, ok := s.customMetric(step.Metric); ok {
+				if current, ok := svc.CustomMetrics[m.Name]; ok {
+					svc.CustomMetrics[m.Name] = clamp(stepValue(step, current), m.Min, m.Max)
+				}
+			}
+		}
+	}
+}
+
+func stepValue(step ScriptStep, current float64) float64 {
+	if step.Op == OpAdd {
+		return current + step.Value
+	}
+	return step.Value
+}
@@ -0,0 +1,44 @@
+package engine
+
+import (
+	"sort"
+
+	"github.com/microcloud/id"
+)
+
+// newSeededState returns a fresh default state whose random draws, entity
+// IDs and clock depend only on seed and the ticks run
+func newSeededState(seed int64) *State {
+	return newState(seed, true)
+}
+
+// setSimStart sets the sim time a deterministic state starts from
+func (s *State) setSimStart(ms int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.simTimeUnixMs = ms
+	s.simBaseMs = ms
+}
+
+// newID returns an ID for a new entity, drawn from the state's source in
+// deterministic states so replays recreate the same IDs the run's commands
+// refer to
+func (s *State) newID() string {
+	if !s.deterministic {
+		return id.New()
+	}
+	var b [16]byte
+	s.rng.Read(b[:])
+	return id.FromBytes(b)
+}
+
+// sortedIDs returns the keys of m in order, for loops that draw random
+// numbers per entity and must consume them in the same order every run
+func sortedIDs[V any](m map[string]V) []string {
+	ids := make([]string, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -1,10 +1,13 @@
 package engine
 
 import (
+	"fmt"
 	"math/rand"
 	"sync"
 	"time"
 
+	"google.golang.org/protobuf/proto"
+
 	commonv1 "github.com/microcloud/gen/go/common/v1"
 	simv1 "github.com/microcloud/gen/go/sim/v1"
 )
@@ -16,9 +19,47 @@ type State struct {
 	nodes    map[string]*simv1.Node
 	services map[string]*simv1.Service
 
+	// baseRPS is the random-walk request rate before traffic profiles apply
+	baseRPS map[string]float64
+	traffic map[string]trafficAssignment
+	deps    map[string][]string // service ID -> dependency service IDs
+	regions map[string]*region
+	faults  []Fault
+	effects []*effect
+	script  *scriptRun
+
+	leaks     map[string]float64 // service ID -> memory growth per tick
+	oomKilled map[string]bool
+	breakers  map[string]*breaker // "caller>dependency" -> open breaker
+	drains    map[string]int64    // drained node ID -> tick the drain began
+	crashes   map[string]int64    // crashed node ID -> tick it comes back
+	chaos     *ChaosConfig
+
+	placements   map[string]placement // service ID -> replicas per node, nil until the first schedule
+	crashedSince map[string]int64     // crashed node ID -> tick the scheduler saw it crash
+
+	latencyBudgets map[string]float64 // service ID -> p99 above which callers slow down
+
+	customMetrics []CustomMetric // channels registered by the active scenario
+
+	// dependencyNames overrides serviceDependencies for file-loaded topologies
+	dependencyNames map[string][]string
+	topologySource  string
+	size            TopologySize // shape of the generated cluster
+
+	dynamics Dynamics
+	workers  int // goroutines for per-entity updates, 0 = GOMAXPROCS
+
+	rng           *rand.Rand   // source of every random draw, forked per tick shard
+	deterministic bool         // seeded run that a replay must reproduce exactly
+	profile       *tickProfile // nil unless the engine profiles ticks
+
 	tickID        int64
-	simTimeUnixMs int64
-	startWallTime time.Time
+	simTimeUnixMs int64     // sim time as of the last tick
+	simBaseMs     int64     // sim time when the current running segment began
+	startWallTime time.Time // wall time when the current running segment began
+	pausedSince   time.Time // zero while running or before the first run
+	pausedTotal   time.Duration
 	speedMult     float64
 	simState      commonv1.SimulationState
 	scenario      string
@@ -26,53 +67,80 @@ type State struct {
 
 // NewState creates a new simulation state with default nodes and services
 func NewState() *State {
+	return newState(time.Now().UnixNano(), false)
+}
+
+// newState creates the default state drawing from a source seeded with
+// seed. Deterministic states draw IDs from it, tick serially and advance sim
+// time by tick, not wall clock.
+func newState(seed int64, deterministic bool) *State {
 	s := &State{
+		rng:           rand.New(rand.NewSource(seed)),
+		deterministic: deterministic,
 		nodes:         make(map[string]*simv1.Node),
 		services:      make(map[string]*simv1.Service),
+		baseRPS:       make(map[string]float64),
+		traffic:       make(map[string]trafficAssignment),
 		tickID:        0,
 		simTimeUnixMs: time.Now().UnixMilli(),
+		simBaseMs:     time.Now().UnixMilli(),
 		startWallTime: time.Now(),
 		speedMult:     1.0,
 		simState:      commonv1.SimulationState_SIMULATION_STATE_STOPPED,
 		scenario:      "normal",
+		dynamics:      RandomWalkDynamics{},
+		size:          DefaultTopologySize(),
 	}
+	s.topologySource = TopologySourceGenerated
 	s.initializeDefaultState()
+	s.initRegions()
+	s.initCapacity()
+	s.initMemory()
+	s.linkDependencies()
+	s.applyTrafficProfiles()
 	return s
 }
 
+// initializeDefaultState generates nodes and services of s.size. Caller
+// must hold mu or own s.
 func (s *State) initializeDefaultState() {
-	zones := []string{"us-east-1a", "us-east-1b", "us-west-2a"}
+	zones := s.size.Zones
 
-	for i := 0; i < 6; i++ {
-		nodeID := randomUUID()
+	for i := 0; i < s.size.Nodes; i++ {
+		nodeID := s.newID()
 		node := &simv1.Node{
 			Id:                 &commonv1.UUID{Value: nodeID},
-			Name:               nodeNames[i%len(nodeNames)],
+			Name:               nodeName(i),
 			Status:             commonv1.NodeStatus_NODE_STATUS_HEALTHY,
-			CpuUsagePercent:    rand.Float64() * 30,
-			MemoryUsagePercent: rand.Float64() * 40,
-			DiskUsagePercent:   rand.Float64() * 20,
-			RunningServices:    int32(rand.Intn(3) + 1),
+			CpuUsagePercent:    s.rng.Float64() * 30,
+			MemoryUsagePercent: s.rng.Float64() * 40,
+			DiskUsagePercent:   s.rng.Float64() * 20,
+			RunningServices:    int32(s.rng.Intn(3) + 1),
 			AvailabilityZone:   zones[i%len(zones)],
 			Labels:             map[string]string{"tier": "compute"},
 		}
+		if s.size.ServicesPerNode > 0 {
+			node.RunningServices = int32(s.size.ServicesPerNode)
+		}
 		s.nodes[nodeID] = node
 
 		for j := 0; j < int(node.RunningServices); j++ {
-			svcID := randomUUID()
+			svcID := s.newID()
 			svc := &simv1.Service{
-				Id:               &commonv1.UUID{Value: svcID},
-				Name:             serviceNames[(i+j)%len(serviceNames)],
-				NodeId:           &commonv1.UUID{Value: nodeID},
-				Health:           commonv1.ServiceHealth_SERVICE_HEALTH_HEALTHY,
-				RequestsPerSecond: rand.Float64() * 500,
-				ErrorRatePercent:  rand.Float64() * 0.5,
-				LatencyP50Ms:      rand.Float64()*10 + 5,
-				LatencyP99Ms:      rand.Float64()*50 + 20,
-				ReplicaCount:      int32(rand.Intn(3) + 1),
+				Id:                &commonv1.UUID{Value: svcID},
+				Name:              serviceNames[(i+j)%len(serviceNames)],
+				NodeId:            &commonv1.UUID{Value: nodeID},
+				Health:            commonv1.ServiceHealth_SERVICE_HEALTH_HEALTHY,
+				RequestsPerSecond: s.rng.Float64() * 500,
+				ErrorRatePercent:  s.rng.Float64() * 0.5,
+				LatencyP50Ms:      s.rng.Float64()*10 + 5,
+				LatencyP99Ms:      s.rng.Float64()*50 + 20,
+				ReplicaCount:      int32(s.rng.Intn(3) + 1),
 				DesiredReplicas:   3,
 			}
+			svc.Labels = serviceLabels(svc.Name, nil)
 			s.services[svcID] = svc
+			s.baseRPS[svcID] = svc.RequestsPerSecond
 		}
 	}
 }
@@ -80,28 +148,6 @@ func (s *State) initializeDefaultState() {
 var nodeNames = []string{"node-alpha", "node-beta", "node-gamma", "node-delta", "node-epsilon", "node-zeta"}
 var serviceNames = []string{"api-gateway", "user-service", "order-service", "payment-service", "inventory-service", "notification-service", "analytics-service", "search-service"}
 
-func randomUUID() string {
-	b := make([]byte, 16)
-	rand.Read(b)
-	return string([]byte{
-		hexChar(b[0]>>4), hexChar(b[0]&0xf), hexChar(b[1]>>4), hexChar(b[1]&0xf),
-		hexChar(b[2]>>4), hexChar(b[2]&0xf), hexChar(b[3]>>4), hexChar(b[3]&0xf), '-',
-		hexChar(b[4]>>4), hexChar(b[4]&0xf), hexChar(b[5]>>4), hexChar(b[5]&0xf), '-',
-		hexChar(b[6]>>4), hexChar(b[6]&0xf), hexChar(b[7]>>4), hexChar(b[7]&0xf), '-',
-		hexChar(b[8]>>4), hexChar(b[8]&0xf), hexChar(b[9]>>4), hexChar(b[9]&0xf), '-',
-		hexChar(b[10]>>4), hexChar(b[10]&0xf), hexChar(b[11]>>4), hexChar(b[11]&0xf),
-		hexChar(b[12]>>4), hexChar(b[12]&0xf), hexChar(b[13]>>4), hexChar(b[13]&0xf),
-		hexChar(b[14]>>4), hexChar(b[14]&0xf), hexChar(b[15]>>4), hexChar(b[15]&0xf),
-	})
-}
-
-func hexChar(b byte) byte {
-	if b < 10 {
-		return '0' + b
-	}
-	return 'a' + b - 10
-}
-
 // GetSimState returns the current simulation state
 func (s *State) GetSimState() commonv1.SimulationState {
 	s.mu.RLock()
@@ -113,10 +159,7 @@ func (s *State) GetSimState() commonv1.SimulationState {
 func (s *State) SetSimState(state commonv1.SimulationState) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.simState = state
-	if state == commonv1.SimulationState_SIMULATION_STATE_RUNNING {
-		s.startWallTime = time.Now()
-	}
+	s.transition(state, time.Now())
 }
 
 // GetSpeedMultiplier returns the speed multiplier
@@ -136,6 +179,8 @@ func (s *State) SetSpeedMultiplier(mult float64) {
 	if mult > 10.0 {
 		mult = 10.0
 	}
+	// Time so far ran at the old speed
+	s.foldSegment(time.Now())
 	s.speedMult = mult
 }
 
@@ -153,68 +198,206 @@ func (s *State) GetScenario() string {
 	return s.scenario
 }
 
-// SetScenario sets the active scenario
-func (s *State) SetScenario(scenario string) {
+// SetScenario sets the active scenario and applies its traffic profiles.
+// params tune the scenario's chaos rates; scenarios without chaos take none.
+func (s *State) SetScenario(scenario string, params map[string]string) error {
+	sc, _ := LookupScenario(scenario)
+	chaos, err := scenarioChaos(scenario, params)
+	if err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.scenario = scenario
+	s.chaos = chaos
+	s.applyTrafficProfiles()
+	s.resetCustomMetrics(sc.CustomMetrics)
+	s.script = nil
+	if len(sc.Script) > 0 {
+		s.script = newScriptRun(sc.Script, s.tickID)
+	}
+	return nil
+}
+
+// scenarioChaos returns the scenario's chaos rates with params applied, or
+// nil for scenarios without chaos
+func scenarioChaos(scenario string, params map[string]string) (*ChaosConfig, error) {
+	sc, _ := LookupScenario(scenario)
+	if sc.Chaos == nil {
+		if len(params) > 0 {
+			return nil, fmt.Errorf("scenario %s takes no parameters", scenario)
+		}
+		return nil, nil
+	}
+	c, err := sc.Chaos.WithParams(params)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// SetDynamics swaps the metric dynamics model
+func (s *State) SetDynamics(d Dynamics) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dynamics = d
 }
 
-// Tick advances the simulation by one tick
+// DynamicsName returns the name of the active dynamics model
+func (s *State) DynamicsName() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.dynamics.Name()
+}
+
+// SetTrafficProfile overrides the traffic profile for a single service
+func (s *State) SetTrafficProfile(serviceID, profileName string) error {
+	profile, err := NewTrafficProfile(profileName)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.services[serviceID]; !ok {
+		return fmt.Errorf("unknown service: %s", serviceID)
+	}
+	s.traffic[serviceID] = trafficAssignment{profile: profile, startTick: s.tickID}
+	return nil
+}
+
+// applyTrafficProfiles assigns profiles from the active scenario. Caller must hold mu.
+func (s *State) applyTrafficProfiles() {
+	sc, ok := LookupScenario(s.scenario)
+	if !ok {
+		return
+	}
+	for id, svc := range s.services {
+		profile, err := NewTrafficProfile(sc.profileFor(svc.Name))
+		if err != nil {
+			delete(s.traffic, id)
+			continue
+		}
+		s.traffic[id] = trafficAssignment{profile: profile, startTick: s.tickID}
+	}
+}
+
+// trafficMultiplier returns the profile factor for a service. Caller must hold mu.
+func (s *State) trafficMultiplier(serviceID string) float64 {
+	a, ok := s.traffic[serviceID]
+	if !ok {
+		return 1
+	}
+	return a.profile.Multiplier(s.tickID - a.startTick)
+}
+
+// Tick advances the simulation by one step of tickDuration sim time. The
+// engine runs one step per wall tick per unit of speed multiplier.
 func (s *State) Tick(tickDuration time.Duration) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	s.tickID++
-	s.simTimeUnixMs += int64(float64(tickDuration.Milliseconds()) * s.speedMult)
+	if s.deterministic {
+		s.simTimeUnixMs += tickDuration.Milliseconds()
+	} else {
+		s.advanceClock(time.Now())
+	}
 
-	s.updateNodes()
-	s.updateServices()
+	s.timePhase(phaseUpdateNodes, s.updateNodes)
+	s.timePhase(phaseUpdateServices, s.updateServices)
+	s.timePhase(phaseEffects, func() {
+		s.updateCustomMetrics()
+		s.injectChaos()
+		s.runEffects()
+		s.propagateDependencies()
+		s.propagateLatency()
+		s.schedule()
+		s.applyCapacity()
+		s.updateMemory()
+		s.updateQueues(tickDuration)
+		s.expireFaults()
+		s.runScript()
+		s.updateRegions()
+		s.enforceRegionFailures()
+		s.enforceDrains()
+		s.enforceCrashes()
+	})
+}
+
+// tickInfo describes the current tick for Dynamics. Caller must hold mu.
+func (s *State) tickInfo() TickInfo {
+	return TickInfo{TickID: s.tickID, Scenario: s.scenario, rng: s.rng}
 }
 
+// updateNodes advances every node, sharded across tick workers. Caller must hold mu.
 func (s *State) updateNodes() {
-	for _, node := range s.nodes {
-		node.CpuUsagePercent = clamp(node.CpuUsagePercent+randDelta(5), 0, 100)
-		node.MemoryUsagePercent = clamp(node.MemoryUsagePercent+randDelta(2), 0, 100)
-		node.DiskUsagePercent = clamp(node.DiskUsagePercent+randDelta(0.5), 0, 100)
-
-		if node.CpuUsagePercent > 90 || node.MemoryUsagePercent > 95 {
-			node.Status = commonv1.NodeStatus_NODE_STATUS_DEGRADED
-		} else if node.CpuUsagePercent > 80 || node.MemoryUsagePercent > 85 {
-			node.Status = commonv1.NodeStatus_NODE_STATUS_DEGRADED
-		} else {
-			node.Status = commonv1.NodeStatus_NODE_STATUS_HEALTHY
+	t := s.tickInfo()
+	nodes := make([]*simv1.Node, 0, len(s.nodes))
+	for _, id := range sortedIDs(s.nodes) {
+		nodes = append(nodes, s.nodes[id])
+	}
+	s.forEachShard(len(nodes), func(lo, hi int, r *rand.Rand) {
+		t := t
+		t.rng = r
+		for _, node := range nodes[lo:hi] {
+			s.updateNode(t, node)
 		}
+	})
+}
 
-		if s.scenario == "high_load" {
-			node.CpuUsagePercent = clamp(node.CpuUsagePercent+rand.Float64()*10, 0, 100)
-		}
+func (s *State) updateNode(t TickInfo, node *simv1.Node) {
+	s.dynamics.UpdateNode(t, node)
+
+	if node.CpuUsagePercent > 90 || node.MemoryUsagePercent > 95 {
+		node.Status = commonv1.NodeStatus_NODE_STATUS_DEGRADED
+	} else if node.CpuUsagePercent > 80 || node.MemoryUsagePercent > 85 {
+		node.Status = commonv1.NodeStatus_NODE_STATUS_DEGRADED
+	} else {
+		node.Status = commonv1.NodeStatus_NODE_STATUS_HEALTHY
+	}
+
+	if s.scenario == "high_load" {
+		node.CpuUsagePercent = clamp(node.CpuUsagePercent+t.rng.Float64()*10, 0, 100)
 	}
 }
 
+// updateServices advances every service, sharded across tick workers. Base
+// rates are written back after the shards finish since maps are not safe
+// for concurrent writes. Caller must hold mu.
 func (s *State) updateServices() {
-	for _, svc := range s.services {
-		svc.RequestsPerSecond = clamp(svc.RequestsPerSecond+randDelta(50), 0, 10000)
-		svc.ErrorRatePercent = clamp(svc.ErrorRatePercent+randDelta(0.5), 0, 100)
-		svc.LatencyP50Ms = clamp(svc.LatencyP50Ms+randDelta(2), 1, 1000)
-		svc.LatencyP99Ms = clamp(svc.LatencyP99Ms+randDelta(10), svc.LatencyP50Ms, 5000)
-
-		if svc.ErrorRatePercent > 10 {
-			svc.Health = commonv1.ServiceHealth_SERVICE_HEALTH_CRITICAL
-		} else if svc.ErrorRatePercent > 5 {
-			svc.Health = commonv1.ServiceHealth_SERVICE_HEALTH_DEGRADED
-		} else {
-			svc.Health = commonv1.ServiceHealth_SERVICE_HEALTH_HEALTHY
+	t := s.tickInfo()
+	ids := sortedIDs(s.services)
+	bases := make([]float64, len(ids))
+	s.forEachShard(len(ids), func(lo, hi int, r *rand.Rand) {
+		t := t
+		t.rng = r
+		for i := lo; i < hi; i++ {
+			bases[i] = s.updateService(t, ids[i], s.services[ids[i]])
 		}
+	})
+	for i, id := range ids {
+		s.baseRPS[id] = bases[i]
+	}
+}
 
-		if s.scenario == "cascade_failure" && rand.Float64() < 0.05 {
-			svc.ErrorRatePercent = clamp(svc.ErrorRatePercent+20, 0, 100)
-		}
+func (s *State) updateService(t TickInfo, id string, svc *simv1.Service) float64 {
+	base := s.dynamics.UpdateService(t, svc, s.baseRPS[id])
+	svc.RequestsPerSecond = clamp(base*s.trafficMultiplier(id), 0, 10000)
+
+	if svc.ErrorRatePercent > 10 {
+		svc.Health = commonv1.ServiceHealth_SERVICE_HEALTH_CRITICAL
+	} else if svc.ErrorRatePercent > 5 {
+		svc.Health = commonv1.ServiceHealth_SERVICE_HEALTH_DEGRADED
+	} else {
+		svc.Health = commonv1.ServiceHealth_SERVICE_HEALTH_HEALTHY
 	}
+	return base
 }
 
-func randDelta(maxDelta float64) float64 {
-	return (rand.Float64() - 0.5) * 2 * maxDelta
+func randDelta(r *rand.Rand, maxDelta float64) float64 {
+	return (r.Float64() - 0.5) * 2 * maxDelta
 }
 
 func clamp(v, min, max float64) float64 {
@@ -231,17 +414,31 @@ func clamp(v, min, max float64) float64 {
 func (s *State) Snapshot() *simv1.MetricSnapshot {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	return s.snapshot()
+}
+
+// SnapshotCopy returns a deep copy of the current snapshot, safe to use
+// while the simulation keeps ticking
+func (s *State) SnapshotCopy() *simv1.MetricSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return proto.Clone(s.snapshot()).(*simv1.MetricSnapshot)
+}
 
+// snapshot builds the current snapshot, sharing entities with the state.
+// Caller must hold mu.
+func (s *State) snapshot() *simv1.MetricSnapshot {
 	nodes := make([]*simv1.Node, 0, len(s.nodes))
 	for _, n := range s.nodes {
 		nodes = append(nodes, n)
 	}
 
 	services := make([]*simv1.Service, 0, len(s.services))
-	var totalRPS, totalErrors, totalLatency float64
+	var totalRPS, totalErrors, totalLatency, totalQueue float64
 	for _, svc := range s.services {
 		services = append(services, svc)
 		totalRPS += svc.RequestsPerSecond
+		totalQueue += svc.QueueDepth
 		totalErrors += svc.ErrorRatePercent
 		totalLatency += svc.LatencyP50Ms
 	}
@@ -255,7 +452,7 @@ func (s *State) Snapshot() *simv1.MetricSnapshot {
 
 	return &simv1.MetricSnapshot{
 		Timestamp: &commonv1.SimulationTimestamp{
-			TickId:        s.tickID,
+			TickId:         s.tickID,
 			WallTimeUnixMs: time.Now().UnixMilli(),
 			SimTimeUnixMs:  s.simTimeUnixMs,
 		},
@@ -266,6 +463,8 @@ func (s *State) Snapshot() *simv1.MetricSnapshot {
 			TotalErrorRate:    avgErrorRate,
 			AvgLatencyMs:      avgLatency,
 			ActiveConnections: int64(rand.Intn(1000) + 500),
+			TotalQueueDepth:   totalQueue,
 		},
+		Regions: s.regionStats(),
 	}
 }
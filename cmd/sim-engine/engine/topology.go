@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"sort"
+
+	"google.golang.org/protobuf/proto"
+
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+)
+
+// Topology edge kinds
+const (
+	EdgePlacement  = "placement"  // service -> node it runs on
+	EdgeDependency = "dependency" // service -> service it calls
+)
+
+// serviceDependencies lists the services each service calls, by name
+var serviceDependencies = map[string][]string{
+	"api-gateway":       {"user-service", "order-service", "search-service"},
+	"order-service":     {"payment-service", "inventory-service", "notification-service"},
+	"payment-service":   {"notification-service"},
+	"search-service":    {"inventory-service"},
+	"user-service":      {"notification-service"},
+	"analytics-service": {"order-service", "user-service"},
+}
+
+// Topology is a point-in-time copy of the cluster graph
+type Topology struct {
+	TickID   int64
+	Source   string // TopologySourceGenerated or "file:<path>"
+	Nodes    []*simv1.Node
+	Services []*simv1.Service
+	Edges    []*simv1.TopologyEdge
+}
+
+// linkDependencies resolves name-based dependencies to service IDs.
+// Every instance of a service depends on every instance of its dependencies.
+// Caller must hold mu.
+func (s *State) linkDependencies() {
+	byName := make(map[string][]string)
+	for id, svc := range s.services {
+		byName[svc.Name] = append(byName[svc.Name], id)
+	}
+
+	names := serviceDependencies
+	if s.dependencyNames != nil {
+		names = s.dependencyNames
+	}
+
+	s.deps = make(map[string][]string, len(s.services))
+	for id, svc := range s.services {
+		for _, depName := range names[svc.Name] {
+			s.deps[id] = append(s.deps[id], byName[depName]...)
+		}
+		sort.Strings(s.deps[id])
+	}
+}
+
+// Topology returns the nodes, services and placement/dependency edges
+func (s *State) Topology() Topology {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	topo := Topology{
+		TickID:   s.tickID,
+		Source:   s.topologySource,
+		Nodes:    make([]*simv1.Node, 0, len(s.nodes)),
+		Services: make([]*simv1.Service, 0, len(s.services)),
+	}
+
+	for _, n := range s.nodes {
+		topo.Nodes = append(topo.Nodes, proto.Clone(n).(*simv1.Node))
+	}
+
+	for id, svc := range s.services {
+		topo.Services = append(topo.Services, proto.Clone(svc).(*simv1.Service))
+		topo.Edges = append(topo.Edges, &simv1.TopologyEdge{
+			FromId: id,
+			ToId:   svc.NodeId.GetValue(),
+			Kind:   EdgePlacement,
+		})
+		for _, depID := range s.deps[id] {
+			topo.Edges = append(topo.Edges, &simv1.TopologyEdge{
+				FromId: id,
+				ToId:   depID,
+				Kind:   EdgeDependency,
+			})
+		}
+	}
+
+	sort.Slice(topo.Nodes, func(i, j int) bool { return topo.Nodes[i].Name < topo.Nodes[j].Name })
+	sort.Slice(topo.Services, func(i, j int) bool { return topo.Services[i].Name < topo.Services[j].Name })
+
+	return topo
+}
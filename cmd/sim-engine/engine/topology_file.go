@@ -0,0 +1,226 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+)
+
+// TopologySourceGenerated is reported when no topology file was loaded
+const TopologySourceGenerated = "generated"
+
+// TopologySpec describes the initial cluster in a topology file (JSON).
+// Zero values fall back to the engine defaults.
+//
+//	{
+//	  "nodes": [{
+//	    "name": "node-alpha", "zone": "us-east-1a",
+//	    "cpu_millicores": 8000, "memory_mb": 16384, "labels": {"tier": "compute"},
+//	    "services": [{"name": "api-gateway", "replicas": 2, "rps": 300, "labels": {"team": "edge"}}]
+//	  }],
+//	  "dependencies": {"api-gateway": ["user-service"]}
+//	}
+type TopologySpec struct {
+	Nodes []NodeSpec `json:"nodes"`
+
+	// Dependencies maps service names to the services they call. When
+	// omitted the built-in dependency graph is used.
+	Dependencies map[string][]string `json:"dependencies,omitempty"`
+
+	source string
+}
+
+// NodeSpec describes one node and the services placed on it
+type NodeSpec struct {
+	Name          string            `json:"name"`
+	Zone          string            `json:"zone"`
+	CPUMillicores int32             `json:"cpu_millicores,omitempty"`
+	MemoryMB      int32             `json:"memory_mb,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Services      []ServiceSpec     `json:"services,omitempty"`
+}
+
+// ServiceSpec describes one service instance and its baseline metrics
+type ServiceSpec struct {
+	Name                 string  `json:"name"`
+	Replicas             int32   `json:"replicas,omitempty"`
+	DesiredReplicas      int32   `json:"desired_replicas,omitempty"`
+	RPS                  float64 `json:"rps,omitempty"`
+	ErrorRatePercent     float64 `json:"error_rate_percent,omitempty"`
+	LatencyP50Ms         float64 `json:"latency_p50_ms,omitempty"`
+	LatencyP99Ms         float64 `json:"latency_p99_ms,omitempty"`
+	CPURequestMillicores int32   `json:"cpu_request_millicores,omitempty"`
+	MemoryRequestMB      int32   `json:"memory_request_mb,omitempty"`
+
+	// Labels are matched by detection rule selectors; LabelApp is always
+	// set to the service name
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// LoadTopologyFile reads and validates a topology file
+func LoadTopologyFile(path string) (*TopologySpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read topology file: %w", err)
+	}
+
+	var spec TopologySpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("parse topology file: %w", err)
+	}
+	if err := spec.validate(); err != nil {
+		return nil, fmt.Errorf("topology file %s: %w", path, err)
+	}
+	spec.source = "file:" + path
+	return &spec, nil
+}
+
+func (spec *TopologySpec) validate() error {
+	if len(spec.Nodes) == 0 {
+		return fmt.Errorf("at least one node is required")
+	}
+
+	names := make(map[string]bool)
+	services := make(map[string]bool)
+	for i, n := range spec.Nodes {
+		if n.Name == "" || n.Zone == "" {
+			return fmt.Errorf("node %d: name and zone are required", i)
+		}
+		if names[n.Name] {
+			return fmt.Errorf("duplicate node name %q", n.Name)
+		}
+		names[n.Name] = true
+		if n.CPUMillicores < 0 || n.MemoryMB < 0 {
+			return fmt.Errorf("node %s: capacities must not be negative", n.Name)
+		}
+		for j, svc := range n.Services {
+			if svc.Name == "" {
+				return fmt.Errorf("node %s service %d: name is required", n.Name, j)
+			}
+			if svc.Replicas < 0 || svc.Replicas > MaxReplicas {
+				return fmt.Errorf("service %s: replicas must be between 0 and %d", svc.Name, MaxReplicas)
+			}
+			if svc.ErrorRatePercent < 0 || svc.ErrorRatePercent > 100 {
+				return fmt.Errorf("service %s: error_rate_percent must be between 0 and 100", svc.Name)
+			}
+			services[svc.Name] = true
+		}
+	}
+
+	for from, deps := range spec.Dependencies {
+		if !services[from] {
+			return fmt.Errorf("dependencies: unknown service %q", from)
+		}
+		for _, to := range deps {
+			if !services[to] {
+				return fmt.Errorf("dependencies: %s depends on unknown service %q", from, to)
+			}
+		}
+	}
+	return nil
+}
+
+// LoadTopology replaces the cluster with the one described by spec and
+// resets all per-entity state. Caller must not hold mu.
+func (s *State) LoadTopology(spec *TopologySpec) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.resetEntities()
+	for _, n := range spec.Nodes {
+		nodeID := s.newID()
+		labels := map[string]string{"tier": "compute"}
+		if n.Labels != nil {
+			labels = make(map[string]string, len(n.Labels))
+			for k, v := range n.Labels {
+				labels[k] = v
+			}
+		}
+		s.nodes[nodeID] = &simv1.Node{
+			Id:                    &commonv1.UUID{Value: nodeID},
+			Name:                  n.Name,
+			Status:                commonv1.NodeStatus_NODE_STATUS_HEALTHY,
+			CpuUsagePercent:       10,
+			MemoryUsagePercent:    20,
+			DiskUsagePercent:      10,
+			RunningServices:       int32(len(n.Services)),
+			AvailabilityZone:      n.Zone,
+			Labels:                labels,
+			CpuCapacityMillicores: n.CPUMillicores,
+			MemoryCapacityMb:      n.MemoryMB,
+		}
+
+		for _, spec := range n.Services {
+			svcID := s.newID()
+			svc := &simv1.Service{
+				Id:                   &commonv1.UUID{Value: svcID},
+				Name:                 spec.Name,
+				NodeId:               &commonv1.UUID{Value: nodeID},
+				Health:               commonv1.ServiceHealth_SERVICE_HEALTH_HEALTHY,
+				RequestsPerSecond:    spec.RPS,
+				ErrorRatePercent:     spec.ErrorRatePercent,
+				LatencyP50Ms:         orDefault(spec.LatencyP50Ms, 10),
+				LatencyP99Ms:         orDefault(spec.LatencyP99Ms, 40),
+				ReplicaCount:         max(spec.Replicas, 1),
+				DesiredReplicas:      max(spec.DesiredReplicas, spec.Replicas, 1),
+				CpuRequestMillicores: spec.CPURequestMillicores,
+				MemoryRequestMb:      spec.MemoryRequestMB,
+				Labels:               serviceLabels(spec.Name, spec.Labels),
+			}
+			s.services[svcID] = svc
+			s.baseRPS[svcID] = svc.RequestsPerSecond
+		}
+	}
+
+	s.dependencyNames = spec.Dependencies
+	s.topologySource = spec.source
+	s.initRegions()
+	s.initCapacity()
+	s.initMemory()
+	s.linkDependencies()
+	s.applyTrafficProfiles()
+}
+
+// resetEntities clears the cluster and all per-entity state. Caller must hold mu.
+func (s *State) resetEntities() {
+	s.nodes = make(map[string]*simv1.Node)
+	s.services = make(map[string]*simv1.Service)
+	s.baseRPS = make(map[string]float64)
+	s.traffic = make(map[string]trafficAssignment)
+	s.faults = nil
+	s.effects = nil
+	s.breakers = nil
+	s.drains = nil
+	s.crashes = nil
+	s.placements = nil
+	s.crashedSince = nil
+	s.latencyBudgets = nil
+	s.script = nil
+}
+
+// TopologySource returns where the cluster came from: "generated" or "file:<path>"
+func (s *State) TopologySource() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.topologySource
+}
+
+func orDefault(v, fallback float64) float64 {
+	if v == 0 {
+		return fallback
+	}
+	return v
+}
+
+// serviceLabels returns a copy of labels with LabelApp set to name
+func serviceLabels(name string, labels map[string]string) map[string]string {
+	out := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		out[k] = v
+	}
+	out[LabelApp] = name
+	return out
+}
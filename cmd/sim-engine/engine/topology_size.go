@@ -0,0 +1,158 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Topology size parameters accepted by LoadScenario alongside the scenario's
+// own. Any of them regenerates the cluster at the new size before the
+// scenario loads.
+const (
+	ParamTopologyNodes           = "topology.nodes"
+	ParamTopologyServicesPerNode = "topology.services_per_node"
+	ParamTopologyZones           = "topology.zones" // comma-separated
+
+	// MaxTopologyNodes and MaxServicesPerNode bound generated clusters
+	MaxTopologyNodes   = 5000
+	MaxServicesPerNode = 50
+)
+
+// TopologySize shapes the generated cluster, used when no topology file is
+// loaded
+type TopologySize struct {
+	Nodes           int      `json:"nodes"`
+	ServicesPerNode int      `json:"services_per_node,omitempty"` // 0 picks 1-3 per node at random
+	Zones           []string `json:"zones"`                       // nodes are spread round-robin
+}
+
+// DefaultTopologySize returns the six-node, three-zone cluster
+func DefaultTopologySize() TopologySize {
+	return TopologySize{
+		Nodes: 6,
+		Zones: []string{"us-east-1a", "us-east-1b", "us-west-2a"},
+	}
+}
+
+// Validate checks the size is within bounds
+func (z TopologySize) Validate() error {
+	if z.Nodes < 1 || z.Nodes > MaxTopologyNodes {
+		return fmt.Errorf("topology nodes must be between 1 and %d, got %d", MaxTopologyNodes, z.Nodes)
+	}
+	if z.ServicesPerNode < 0 || z.ServicesPerNode > MaxServicesPerNode {
+		return fmt.Errorf("topology services per node must be between 0 and %d, got %d", MaxServicesPerNode, z.ServicesPerNode)
+	}
+	if len(z.Zones) == 0 {
+		return fmt.Errorf("topology needs at least one zone")
+	}
+	for _, zone := range z.Zones {
+		if zone == "" {
+			return fmt.Errorf("topology zones must not be empty")
+		}
+	}
+	return nil
+}
+
+// Params describes the topology.* parameters accepted by WithParams, with
+// z's values as defaults
+func (z TopologySize) Params() []ChaosParam {
+	return []ChaosParam{
+		{ParamTopologyNodes, fmt.Sprintf("Nodes in the generated cluster (1-%d)", MaxTopologyNodes), strconv.Itoa(z.Nodes)},
+		{ParamTopologyServicesPerNode, fmt.Sprintf("Services per node (0-%d, 0 for 1-3 at random)", MaxServicesPerNode), strconv.Itoa(z.ServicesPerNode)},
+		{ParamTopologyZones, "Comma-separated availability zones, spread round-robin", strings.Join(z.Zones, ",")},
+	}
+}
+
+// WithParams returns a copy of z with the topology.* params applied and
+// validated. Other params are ignored.
+func (z TopologySize) WithParams(params map[string]string) (TopologySize, error) {
+	z.Zones = append([]string(nil), z.Zones...)
+	if v, ok := params[ParamTopologyNodes]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return z, fmt.Errorf("invalid %q parameter: %w", ParamTopologyNodes, err)
+		}
+		z.Nodes = n
+	}
+	if v, ok := params[ParamTopologyServicesPerNode]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return z, fmt.Errorf("invalid %q parameter: %w", ParamTopologyServicesPerNode, err)
+		}
+		z.ServicesPerNode = n
+	}
+	if v, ok := params[ParamTopologyZones]; ok {
+		z.Zones = splitZones(v)
+	}
+	return z, z.Validate()
+}
+
+// splitZones parses a comma-separated zone list
+func splitZones(v string) []string {
+	var zones []string
+	for _, zone := range strings.Split(v, ",") {
+		if zone = strings.TrimSpace(zone); zone != "" {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+// splitTopologyParams separates the topology.* params from the scenario's
+func splitTopologyParams(params map[string]string) (topology, rest map[string]string) {
+	for k, v := range params {
+		if !strings.HasPrefix(k, "topology.") {
+			if rest == nil {
+				rest = make(map[string]string)
+			}
+			rest[k] = v
+			continue
+		}
+		if topology == nil {
+			topology = make(map[string]string)
+		}
+		topology[k] = v
+	}
+	return topology, rest
+}
+
+// nodeName names the i-th generated node, numbering repeats once the names
+// run out
+func nodeName(i int) string {
+	name := nodeNames[i%len(nodeNames)]
+	if round := i / len(nodeNames); round > 0 {
+		name = fmt.Sprintf("%s-%d", name, round+1)
+	}
+	return name
+}
+
+// TopologySize returns the size of the generated cluster
+func (s *State) TopologySize() TopologySize {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.size
+}
+
+// GenerateTopology replaces the cluster with a generated one of the given
+// size and resets all per-entity state. Caller must not hold mu.
+func (s *State) GenerateTopology(size TopologySize) error {
+	if err := size.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.resetEntities()
+	s.size = size
+	s.dependencyNames = nil
+	s.topologySource = TopologySourceGenerated
+	s.initializeDefaultState()
+	s.initRegions()
+	s.initCapacity()
+	s.initMemory()
+	s.linkDependencies()
+	s.applyTrafficProfiles()
+	return nil
+}
@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// TrafficProfile modulates a service's base request rate over time.
+// Multiplier receives the number of ticks elapsed since the profile was
+// assigned and returns the factor applied to the service's base RPS.
+type TrafficProfile interface {
+	Name() string
+	Multiplier(elapsedTicks int64) float64
+}
+
+// Built-in traffic profile names
+const (
+	ProfileSteady     = "steady"
+	ProfileDiurnal    = "diurnal"
+	ProfileSpike      = "spike"
+	ProfileRamp       = "ramp"
+	ProfileFlashCrowd = "flash_crowd"
+)
+
+var trafficProfiles = map[string]func() TrafficProfile{
+	ProfileSteady:     func() TrafficProfile { return SteadyProfile{} },
+	ProfileDiurnal:    func() TrafficProfile { return DiurnalProfile{PeriodTicks: 3000, Amplitude: 0.6} },
+	ProfileSpike:      func() TrafficProfile { return SpikeProfile{StartTick: 100, DurationTicks: 150, Factor: 4} },
+	ProfileRamp:       func() TrafficProfile { return RampProfile{DurationTicks: 1200, Target: 3} },
+	ProfileFlashCrowd: func() TrafficProfile { return FlashCrowdProfile{StartTick: 50, Peak: 8, DecayTicks: 300} },
+}
+
+// NewTrafficProfile returns a built-in traffic profile with default parameters
+func NewTrafficProfile(name string) (TrafficProfile, error) {
+	factory, ok := trafficProfiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown traffic profile: %s", name)
+	}
+	return factory(), nil
+}
+
+// TrafficProfileNames returns the names of all built-in traffic profiles
+func TrafficProfileNames() []string {
+	names := make([]string, 0, len(trafficProfiles))
+	for name := range trafficProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SteadyProfile keeps traffic at its base rate
+type SteadyProfile struct{}
+
+func (SteadyProfile) Name() string             { return ProfileSteady }
+func (SteadyProfile) Multiplier(int64) float64 { return 1 }
+
+// DiurnalProfile follows a sine wave to mimic day/night traffic
+type DiurnalProfile struct {
+	PeriodTicks int64
+	Amplitude   float64 // 0-1, fraction of base rate
+}
+
+func (p DiurnalProfile) Name() string { return ProfileDiurnal }
+
+func (p DiurnalProfile) Multiplier(elapsed int64) float64 {
+	if p.PeriodTicks <= 0 {
+		return 1
+	}
+	phase := 2 * math.Pi * float64(elapsed%p.PeriodTicks) / float64(p.PeriodTicks)
+	return 1 + p.Amplitude*math.Sin(phase)
+}
+
+// SpikeProfile multiplies traffic by Factor for a fixed window of ticks
+type SpikeProfile struct {
+	StartTick     int64
+	DurationTicks int64
+	Factor        float64
+}
+
+func (p SpikeProfile) Name() string { return ProfileSpike }
+
+func (p SpikeProfile) Multiplier(elapsed int64) float64 {
+	if elapsed >= p.StartTick && elapsed < p.StartTick+p.DurationTicks {
+		return p.Factor
+	}
+	return 1
+}
+
+// RampProfile grows traffic linearly to Target times the base rate, then holds
+type RampProfile struct {
+	DurationTicks int64
+	Target        float64
+}
+
+func (p RampProfile) Name() string { return ProfileRamp }
+
+func (p RampProfile) Multiplier(elapsed int64) float64 {
+	if p.DurationTicks <= 0 || elapsed >= p.DurationTicks {
+		return p.Target
+	}
+	return 1 + (p.Target-1)*float64(elapsed)/float64(p.DurationTicks)
+}
+
+// FlashCrowdProfile jumps to Peak at StartTick and decays exponentially back to base
+type FlashCrowdProfile struct {
+	StartTick  int64
+	Peak       float64
+	DecayTicks int64
+}
+
+func (p FlashCrowdProfile) Name() string { return ProfileFlashCrowd }
+
+func (p FlashCrowdProfile) Multiplier(elapsed int64) float64 {
+	if elapsed < p.StartTick || p.DecayTicks <= 0 {
+		return 1
+	}
+	since := float64(elapsed - p.StartTick)
+	return 1 + (p.Peak-1)*math.Exp(-since/float64(p.DecayTicks))
+}
+
+// trafficAssignment binds a profile to a service from a given tick
+type trafficAssignment struct {
+	profile   TrafficProfile
+	startTick int64
+}
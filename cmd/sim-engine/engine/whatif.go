@@ -0,0 +1,250 @@
+package engine
+
+import (
+	"fmt"
+	"maps"
+	"math/rand"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+)
+
+// What-if projection length in ticks
+const (
+	DefaultWhatIfTicks = 50
+	MaxWhatIfTicks     = 600
+)
+
+// Projected is a metric at the end of a what-if run, without and with the
+// action
+type Projected struct {
+	Baseline  float64
+	Projected float64
+}
+
+// Delta is the action's effect on the metric
+func (p Projected) Delta() float64 {
+	return p.Projected - p.Baseline
+}
+
+// ServiceProjection is a service's projected metrics
+type ServiceProjection struct {
+	ServiceID        string
+	Name             string
+	ErrorRatePercent Projected
+	LatencyP99Ms     Projected
+	RPS              Projected
+	BaselineHealth   commonv1.ServiceHealth
+	ProjectedHealth  commonv1.ServiceHealth
+}
+
+// NodeProjection is a node's projected utilization
+type NodeProjection struct {
+	NodeID             string
+	Name               string
+	CPUUsagePercent    Projected
+	MemoryUsagePercent Projected
+}
+
+// WhatIfResult is the projected effect of an action
+type WhatIfResult struct {
+	Event      *simv1.SimulationEvent // what applying the action would report
+	FromTickID int64
+	Ticks      int
+	Services   []ServiceProjection // by ID
+	Nodes      []NodeProjection    // by ID
+
+	MeanErrorRatePercent   Projected
+	MeanLatencyP99Ms       Projected
+	UnhealthyServicesDelta int
+}
+
+// WhatIf projects an action's effect without touching the live simulation.
+// It clones the state twice, applies the action to one clone and runs both
+// for ticks, comparing the two at the end. Both runs start from the same
+// seed, so most of their random drift cancels out and the difference is
+// down to the action. Live ticks and commands wait only while the state is
+// copied; the shadows run on their own.
+func (e *Engine) WhatIf(actionType commonv1.ActionType, targetID string, params map[string]string, ticks int) (*WhatIfResult, error) {
+	if ticks == 0 {
+		ticks = DefaultWhatIfTicks
+	}
+	if ticks < 0 || ticks > MaxWhatIfTicks {
+		return nil, fmt.Errorf("ticks must be between 1 and %d, got %d", MaxWhatIfTicks, ticks)
+	}
+
+	// Copied between steps so a command is either in both shadows or neither
+	e.cmdMu.Lock()
+	baseline := e.state.shadow()
+	projected := e.state.shadow()
+	e.cmdMu.Unlock()
+	from := baseline.tickID
+
+	event, _, err := projected.applyAction(actionType, targetID, params)
+	if err != nil {
+		return nil, err
+	}
+	if event.EventType == "" {
+		return nil, fmt.Errorf("%s does not apply to %s", actionType, targetID)
+	}
+	projected.dynamics.OnAction(projected.tickInfo(), actionType, targetID)
+
+	for _, s := range []*State{baseline, projected} {
+		s.rng = rand.New(rand.NewSource(from))
+		for i := 0; i < ticks; i++ {
+			s.Tick(e.tickInterval)
+		}
+	}
+
+	result := compareShadows(baseline, projected)
+	result.Event = event
+	result.FromTickID = from
+	result.Ticks = ticks
+	return result, nil
+}
+
+// compareShadows compares the end states of a what-if's baseline and action
+// runs
+func compareShadows(baseline, projected *State) *WhatIfResult {
+	result := &WhatIfResult{}
+	var unhealthy int
+	for _, id := range sortedIDs(baseline.services) {
+		b, p := baseline.services[id], projected.services[id]
+		if p == nil {
+			continue
+		}
+		result.Services = append(result.Services, ServiceProjection{
+			ServiceID:        id,
+			Name:             b.Name,
+			ErrorRatePercent: Projected{b.ErrorRatePercent, p.ErrorRatePercent},
+			LatencyP99Ms:     Projected{b.LatencyP99Ms, p.LatencyP99Ms},
+			RPS:              Projected{b.RequestsPerSecond, p.RequestsPerSecond},
+			BaselineHealth:   b.Health,
+			ProjectedHealth:  p.Health,
+		})
+		result.MeanErrorRatePercent.Baseline += b.ErrorRatePercent
+		result.MeanErrorRatePercent.Projected += p.ErrorRatePercent
+		result.MeanLatencyP99Ms.Baseline += b.LatencyP99Ms
+		result.MeanLatencyP99Ms.Projected += p.LatencyP99Ms
+		if b.Health != commonv1.ServiceHealth_SERVICE_HEALTH_HEALTHY {
+			unhealthy--
+		}
+		if p.Health != commonv1.ServiceHealth_SERVICE_HEALTH_HEALTHY {
+			unhealthy++
+		}
+	}
+	if n := float64(len(result.Services)); n > 0 {
+		result.MeanErrorRatePercent = Projected{result.MeanErrorRatePercent.Baseline / n, result.MeanErrorRatePercent.Projected / n}
+		result.MeanLatencyP99Ms = Projected{result.MeanLatencyP99Ms.Baseline / n, result.MeanLatencyP99Ms.Projected / n}
+	}
+	result.UnhealthyServicesDelta = unhealthy
+
+	for _, id := range sortedIDs(baseline.nodes) {
+		b, p := baseline.nodes[id], projected.nodes[id]
+		if p == nil {
+			continue
+		}
+		result.Nodes = append(result.Nodes, NodeProjection{
+			NodeID:             id,
+			Name:               b.Name,
+			CPUUsagePercent:    Projected{b.CpuUsagePercent, p.CpuUsagePercent},
+			MemoryUsagePercent: Projected{b.MemoryUsagePercent, p.MemoryUsagePercent},
+		})
+	}
+	return result
+}
+
+// shadow returns a deep copy of the state for a what-if run. The copy is
+// deterministic, so its clock advances by tick, draws from its own source,
+// leaving the live run's random sequence and a seeded run's replay as they
+// were, and does not profile.
+// Dynamics with per-entity memory are copied; custom dynamics registered
+// with RegisterDynamics are shared with the live state.
+func (s *State) shadow() *State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c := &State{
+		nodes:           make(map[string]*simv1.Node, len(s.nodes)),
+		services:        make(map[string]*simv1.Service, len(s.services)),
+		baseRPS:         maps.Clone(s.baseRPS),
+		traffic:         maps.Clone(s.traffic),
+		deps:            make(map[string][]string, len(s.deps)),
+		regions:         make(map[string]*region, len(s.regions)),
+		faults:          append([]Fault(nil), s.faults...),
+		leaks:           maps.Clone(s.leaks),
+		oomKilled:       maps.Clone(s.oomKilled),
+		drains:          maps.Clone(s.drains),
+		crashes:         maps.Clone(s.crashes),
+		crashedSince:    maps.Clone(s.crashedSince),
+		latencyBudgets:  maps.Clone(s.latencyBudgets),
+		customMetrics:   s.customMetrics,
+		dependencyNames: s.dependencyNames,
+		topologySource:  s.topologySource,
+		size:            s.size,
+		dynamics:        cloneDynamics(s.dynamics),
+		workers:         s.workers,
+		rng:             rand.New(rand.NewSource(s.tickID)),
+		deterministic:   true,
+		tickID:          s.tickID,
+		simTimeUnixMs:   s.simTimeUnixMs,
+		simBaseMs:       s.simTimeUnixMs,
+		startWallTime:   time.Now(),
+		speedMult:       s.speedMult,
+		simState:        s.simState,
+		scenario:        s.scenario,
+	}
+	for id, n := range s.nodes {
+		c.nodes[id] = proto.Clone(n).(*simv1.Node)
+	}
+	for id, svc := range s.services {
+		c.services[id] = proto.Clone(svc).(*simv1.Service)
+	}
+	for id, deps := range s.deps {
+		c.deps[id] = append([]string(nil), deps...)
+	}
+	for name, r := range s.regions {
+		copied := *r
+		c.regions[name] = &copied
+	}
+	for _, e := range s.effects {
+		copied := *e
+		c.effects = append(c.effects, &copied)
+	}
+	if s.script != nil {
+		copied := *s.script
+		c.script = &copied
+	}
+	if s.breakers != nil {
+		c.breakers = make(map[string]*breaker, len(s.breakers))
+		for key, b := range s.breakers {
+			copied := *b
+			c.breakers[key] = &copied
+		}
+	}
+	if s.chaos != nil {
+		copied := *s.chaos
+		c.chaos = &copied
+	}
+	if s.placements != nil {
+		c.placements = make(map[string]placement, len(s.placements))
+		for id, p := range s.placements {
+			c.placements[id] = maps.Clone(p)
+		}
+	}
+	return c
+}
+
+// cloneDynamics copies dynamics that keep per-entity state
+func cloneDynamics(d Dynamics) Dynamics {
+	m, ok := d.(*MeanRevertingDynamics)
+	if !ok {
+		return d
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return &MeanRevertingDynamics{Reversion: m.Reversion, anchors: maps.Clone(m.anchors)}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/microcloud/bus"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+	"github.com/microcloud/sim-engine/engine"
+)
+
+const (
+	leaseBucket      = "sim-engine-leader"
+	leaseKey         = "tick-loop"
+	checkpointBucket = "sim-engine-state"
+	checkpointKey    = "latest"
+)
+
+// failover hands the tick loop between sim-engine instances. The lease
+// holder ticks and checkpoints its state every interval; standbys poll the
+// lease and restore the latest checkpoint when the holder stops renewing.
+type failover struct {
+	eng        *engine.Engine
+	lease      *bus.Lease
+	store      *bus.Store
+	instanceID string
+	interval   time.Duration
+	log        *slog.Logger
+}
+
+func newFailover(ctx context.Context, b *bus.Bus, eng *engine.Engine, instanceID string, leaseTTL, interval time.Duration, log *slog.Logger) (*failover, error) {
+	lease, err := b.NewLease(ctx, leaseBucket, leaseKey, instanceID, leaseTTL)
+	if err != nil {
+		return nil, err
+	}
+	store, err := b.NewStore(ctx, checkpointBucket)
+	if err != nil {
+		return nil, err
+	}
+	return &failover{
+		eng:        eng,
+		lease:      lease,
+		store:      store,
+		instanceID: instanceID,
+		interval:   interval,
+		log:        log,
+	}, nil
+}
+
+// run campaigns for the lease until ctx is done, releasing it on shutdown
+// so a standby can take over without waiting for the TTL
+func (f *failover) run(ctx context.Context) error {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	f.step(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			if f.eng.Active() {
+				f.shutdown()
+			}
+			return ctx.Err()
+		case <-ticker.C:
+			f.step(ctx)
+		}
+	}
+}
+
+func (f *failover) step(ctx context.Context) {
+	if !f.eng.Active() {
+		f.campaign(ctx)
+		return
+	}
+	if err := f.lease.Renew(ctx); err != nil {
+		f.eng.SetActive(false)
+		f.log.Warn("lost tick loop lease; standing by", "error", err)
+		return
+	}
+	f.checkpoint(ctx)
+}
+
+// campaign takes the lease if it is free and resumes from the latest checkpoint
+func (f *failover) campaign(ctx context.Context) {
+	acquired, err := f.lease.TryAcquire(ctx)
+	if err != nil {
+		f.log.Warn("failed to acquire tick loop lease", "error", err)
+		return
+	}
+	if !acquired {
+		return
+	}
+
+	var cp simv1.StateCheckpoint
+	found, err := f.store.Get(ctx, checkpointKey, &cp)
+	if err != nil {
+		// Taking over without the checkpoint would reset the simulation
+		f.log.Error("failed to load checkpoint; releasing lease", "error", err)
+		if err := f.lease.Release(ctx); err != nil {
+			f.log.Warn("failed to release lease", "error", err)
+		}
+		return
+	}
+	if found {
+		f.eng.State().Restore(&cp)
+		f.log.Info("restored checkpoint",
+			"tick_id", cp.TickId,
+			"from_instance", cp.InstanceId,
+			"age", time.Since(time.UnixMilli(cp.WrittenUnixMs)).Round(time.Millisecond),
+		)
+	}
+	f.eng.SetActive(true)
+	f.log.Info("took over tick loop", "instance_id", f.instanceID)
+}
+
+func (f *failover) checkpoint(ctx context.Context) {
+	if err := f.store.Put(ctx, checkpointKey, f.eng.State().Checkpoint(f.instanceID)); err != nil {
+		f.log.Error("failed to write checkpoint", "error", err)
+	}
+}
+
+// shutdown writes a final checkpoint and releases the lease
+func (f *failover) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	f.eng.SetActive(false)
+	f.checkpoint(ctx)
+	if err := f.lease.Release(ctx); err != nil {
+		f.log.Warn("failed to release lease", "error", err)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+	"github.com/microcloud/sim-engine/engine"
+)
+
+// idlePauser pauses a running simulation after a spell with no stream
+// clients or control calls and resumes it on the next one. It only undoes
+// pauses it made, so a simulation an operator paused stays paused.
+type idlePauser struct {
+	eng   *engine.Engine
+	after time.Duration
+	log   *slog.Logger
+
+	mu     sync.Mutex
+	last   time.Time
+	paused bool // paused by us and not resumed since
+}
+
+func newIdlePauser(eng *engine.Engine, after time.Duration, log *slog.Logger) *idlePauser {
+	return &idlePauser{eng: eng, after: after, log: log, last: time.Now()}
+}
+
+// touch records activity, resuming the simulation if it was idle-paused
+func (p *idlePauser) touch(ctx context.Context) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.last = time.Now()
+	if !p.paused {
+		return
+	}
+	p.paused = false
+	if p.eng.Active() && p.eng.State().GetSimState() == commonv1.SimulationState_SIMULATION_STATE_PAUSED {
+		p.eng.SetSimState(ctx, commonv1.SimulationState_SIMULATION_STATE_RUNNING)
+		p.log.Info("simulation resumed on activity")
+	}
+}
+
+// handleActivity is touch for sim.activity notices
+func (p *idlePauser) handleActivity(ctx context.Context, _ *simv1.ClientActivity) error {
+	p.touch(ctx)
+	return nil
+}
+
+// run checks for idleness until ctx is done
+func (p *idlePauser) run(ctx context.Context) error {
+	ticker := time.NewTicker(min(p.after/4, 15*time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		idle := time.Since(p.last)
+		if !p.paused && idle >= p.after && p.eng.Active() &&
+			p.eng.State().GetSimState() == commonv1.SimulationState_SIMULATION_STATE_RUNNING {
+			p.eng.SetSimState(ctx, commonv1.SimulationState_SIMULATION_STATE_PAUSED)
+			p.paused = true
+			p.log.Info("simulation paused while idle", "idle", idle.Round(time.Second))
+		}
+		p.mu.Unlock()
+	}
+}
+
+// interceptor counts every SimulationControl RPC as activity
+func (p *idlePauser) interceptor() connect.UnaryInterceptorFunc {
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			p.touch(ctx)
+			return next(ctx, req)
+		}
+	}
+}
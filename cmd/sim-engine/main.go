@@ -2,91 +2,340 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
-	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
+	"strconv"
+	"strings"
+	"time"
 
 	"connectrpc.com/connect"
-	"golang.org/x/net/http2"
-	"golang.org/x/net/http2/h2c"
-	"golang.org/x/sync/errgroup"
 
+	"github.com/microcloud/app"
 	"github.com/microcloud/bus"
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
 	"github.com/microcloud/gen/go/sim/v1/simv1connect"
-	"github.com/microcloud/logger"
 	"github.com/microcloud/sim-engine/engine"
 	"github.com/microcloud/sim-engine/server"
 )
 
 func main() {
-	log := logger.NewFromEnv("sim-engine")
-
-	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer cancel()
+	app.Main("sim-engine", build)
+}
 
-	if err := run(ctx, log); err != nil && err != context.Canceled {
-		log.Error("fatal error", "error", err)
-		os.Exit(1)
-	}
+// configEnv are the environment variables the service reports in its
+// configuration, besides the shared ones. Credentials are left out.
+var configEnv = []string{
+	"CHECKPOINT_INTERVAL", "DEMO_MODE", "DYNAMICS", "IDLE_PAUSE_AFTER",
+	"INSTANCE_ID", "LEADER_LEASE_TTL", "METRICS_MAX_MESSAGE_BYTES",
+	"PREROLL_TICKS", "RECORD_FILE", "REPLAY_FILE", "REPLAY_RUN",
+	"REPLAY_SPEED", "REPLICATION_ENABLED", "RUN_LOG_DIR", "RUN_SEED",
+	"SNAPSHOT_DEGRADATION", "SNAPSHOT_KEYFRAME_INTERVAL", "TICK_PROFILING",
+	"TICK_WORKERS", "TOPOLOGY_FILE", "TOPOLOGY_NODES",
+	"TOPOLOGY_SERVICES_PER_NODE", "TOPOLOGY_ZONES",
 }
 
-func run(ctx context.Context, log *slog.Logger) error {
-	busCfg := bus.DefaultConfig()
-	if url := os.Getenv("NATS_URL"); url != "" {
-		busCfg.URL = url
+func build(ctx context.Context, a *app.App) error {
+	log := a.Log()
+	a.ConfigEnv(configEnv...)
+
+	busCfg := bus.ConfigFromEnv()
+	if v := os.Getenv("METRICS_MAX_MESSAGE_BYTES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid METRICS_MAX_MESSAGE_BYTES: %q", v)
+		}
+		// Snapshots above this size are published in chunks
+		busCfg.SubjectMaxBytes = map[string]int{bus.SubjectSimMetrics: n}
 	}
 
+	info := app.Build()
 	eventBus, err := bus.New(ctx, busCfg,
-		bus.WithDisconnectHandler(func(err error) {
-			log.Warn("NATS disconnected", "error", err)
-		}),
-		bus.WithReconnectHandler(func() {
-			log.Info("NATS reconnected")
-		}),
+		bus.WithLogger(log),
+		bus.WithIdentity(a.Name(), info.Version, info.Commit),
+		bus.WithConfig(a.Config),
 	)
 	if err != nil {
 		return err
 	}
-	defer eventBus.Close()
+	a.OnStop("nats", func(context.Context) error {
+		return eventBus.Close()
+	})
+	a.HealthCheck("nats", eventBus.Check)
 
 	log.Info("connected to NATS", "url", busCfg.URL)
 
 	publisher := bus.NewPublisher(eventBus)
-	eng := engine.New(publisher, log)
+	subscriber := bus.NewSubscriber(eventBus)
+	var engineOpts []engine.Option
+	runLogDir := os.Getenv("RUN_LOG_DIR")
+	if runLogDir != "" {
+		seed := time.Now().UnixNano()
+		if v := os.Getenv("RUN_SEED"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid RUN_SEED: %q", v)
+			}
+			seed = n
+		}
+		// Must come first: it replaces the state the other options configure
+		engineOpts = append(engineOpts, engine.WithRunLog(runLogDir, seed))
+	}
+	replayRun := os.Getenv("REPLAY_RUN")
+	if replayRun != "" && runLogDir == "" {
+		return fmt.Errorf("REPLAY_RUN requires RUN_LOG_DIR")
+	}
+
+	if path := os.Getenv("RECORD_FILE"); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("create record file: %w", err)
+		}
+		a.OnStop("record file", func(context.Context) error {
+			return f.Close()
+		})
+		engineOpts = append(engineOpts, engine.WithRecorder(engine.NewRecorder(f)))
+		log.Info("recording snapshots", "file", path)
+	}
+
+	if name := os.Getenv("DYNAMICS"); name != "" {
+		dyn, err := engine.NewDynamics(name)
+		if err != nil {
+			return fmt.Errorf("%w (available: %s)", err, strings.Join(engine.DynamicsNames(), ", "))
+		}
+		engineOpts = append(engineOpts, engine.WithDynamics(dyn))
+		log.Info("using metric dynamics", "dynamics", name)
+	}
+
+	size, sized, err := topologySizeFromEnv()
+	if err != nil {
+		return err
+	}
+	if sized {
+		if os.Getenv("TOPOLOGY_FILE") != "" {
+			return fmt.Errorf("TOPOLOGY_FILE cannot be combined with TOPOLOGY_NODES, TOPOLOGY_SERVICES_PER_NODE or TOPOLOGY_ZONES")
+		}
+		engineOpts = append(engineOpts, engine.WithTopologySize(size))
+		log.Info("generating topology", "nodes", size.Nodes, "services_per_node", size.ServicesPerNode, "zones", size.Zones)
+	}
+
+	if path := os.Getenv("TOPOLOGY_FILE"); path != "" {
+		spec, err := engine.LoadTopologyFile(path)
+		if err != nil {
+			return err
+		}
+		engineOpts = append(engineOpts, engine.WithTopology(spec))
+		log.Info("loaded initial topology", "file", path, "nodes", len(spec.Nodes))
+	}
+
+	if v := os.Getenv("TICK_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid TICK_WORKERS: %q", v)
+		}
+		engineOpts = append(engineOpts, engine.WithTickWorkers(n))
+	}
+
+	if os.Getenv("SNAPSHOT_DEGRADATION") == "false" {
+		engineOpts = append(engineOpts, engine.WithFixedDetail())
+	}
+
+	if os.Getenv("TICK_PROFILING") == "true" {
+		engineOpts = append(engineOpts, engine.WithTickProfiling())
+		log.Info("tick phase profiling enabled")
+	}
+
+	if v := os.Getenv("PREROLL_TICKS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid PREROLL_TICKS: %q", v)
+		}
+		engineOpts = append(engineOpts, engine.WithPreroll(n))
+	}
+
+	if v := os.Getenv("SNAPSHOT_KEYFRAME_INTERVAL"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 2 {
+			return fmt.Errorf("invalid SNAPSHOT_KEYFRAME_INTERVAL %q: must be at least 2", v)
+		}
+		engineOpts = append(engineOpts, engine.WithDeltaSnapshots(n))
+		log.Info("publishing delta snapshots", "keyframe_interval", n)
+	}
+
+	// With replication on, every instance starts as a standby and the one
+	// that wins the lease runs the tick loop
+	replication := os.Getenv("REPLICATION_ENABLED") == "true" && os.Getenv("REPLAY_FILE") == "" && replayRun == ""
+	if replication {
+		engineOpts = append(engineOpts, engine.WithStandby())
+	}
+
+	eng := engine.New(publisher, log, engineOpts...)
+	if replayRun != "" {
+		if err := eng.ReplayRun(replayRun); err != nil {
+			return fmt.Errorf("replay run: %w", err)
+		}
+	}
 	controlServer := server.NewControlServer(eng, log)
 
-	mux := http.NewServeMux()
-	path, handler := simv1connect.NewSimulationControlHandler(controlServer,
-		connect.WithInterceptors(loggingInterceptor(log)),
-	)
-	mux.Handle(path, handler)
+	var fo *failover
+	if replication {
+		instanceID := os.Getenv("INSTANCE_ID")
+		if instanceID == "" {
+			instanceID, _ = os.Hostname()
+		}
+		leaseTTL, err := getDuration("LEADER_LEASE_TTL", 5*time.Second)
+		if err != nil {
+			return err
+		}
+		interval, err := getDuration("CHECKPOINT_INTERVAL", time.Second)
+		if err != nil {
+			return err
+		}
+		if interval >= leaseTTL {
+			return fmt.Errorf("CHECKPOINT_INTERVAL (%s) must be shorter than LEADER_LEASE_TTL (%s)", interval, leaseTTL)
+		}
+		fo, err = newFailover(ctx, eventBus, eng, instanceID, leaseTTL, interval, log)
+		if err != nil {
+			return err
+		}
+		log.Info("state replication enabled", "instance_id", instanceID, "lease_ttl", leaseTTL, "checkpoint_interval", interval)
+	}
 
-	addr := getEnv("ADDR", ":8080")
-	httpServer := &http.Server{
-		Addr:    addr,
-		Handler: h2c.NewHandler(mux, &http2.Server{}),
+	demoMode := os.Getenv("DEMO_MODE") == "true"
+	interceptors := []connect.Interceptor{loggingInterceptor(log)}
+	if demoMode {
+		log.Info("read-only demo mode enabled")
+		interceptors = append(interceptors, app.ReadOnlyInterceptor())
+	}
+	if replication {
+		interceptors = append(interceptors, standbyInterceptor(eng))
 	}
 
-	g, ctx := errgroup.WithContext(ctx)
+	// Pauses a running simulation nobody watches or drives; the
+	// orchestrator announces stream clients on sim.activity
+	idleAfter, err := getDuration("IDLE_PAUSE_AFTER", 0)
+	if err != nil {
+		return err
+	}
+	var idle *idlePauser
+	if idleAfter > 0 {
+		idle = newIdlePauser(eng, idleAfter, log)
+		interceptors = append(interceptors, idle.interceptor())
+		log.Info("idle auto-pause enabled", "after", idleAfter)
+	}
+
+	path, handler := simv1connect.NewSimulationControlHandler(controlServer,
+		connect.WithInterceptors(interceptors...),
+	)
+	a.Handle(a.Mux(), path, handler, app.InterceptorNames(interceptors...)...)
+	a.Handle(a.Mux(), "/metrics", eng.MetricsHandler())
+	a.Serve(getEnv("ADDR", ":8080"), nil)
 
-	g.Go(func() error {
+	a.Go("engine", func(ctx context.Context) error {
+		if path := os.Getenv("REPLAY_FILE"); path != "" {
+			return replay(ctx, path, publisher, log)
+		}
 		return eng.Run(ctx)
 	})
 
-	g.Go(func() error {
-		log.Info("gRPC server started", "addr", addr)
-		return httpServer.ListenAndServe()
-	})
+	if fo != nil {
+		a.Go("failover", fo.run)
+	}
+	if idle != nil {
+		a.Go("idle-pause", idle.run)
+		a.Consume(bus.SubjectSimActivity, func(ctx context.Context) (app.Stopper, error) {
+			return subscriber.SubscribeClientActivity(ctx, idle.handleActivity)
+		})
+	}
+
+	a.Go("heartbeat", publisher.RunHeartbeats)
+
+	// Bus control would bypass the read-only interceptor
+	if demoMode {
+		return nil
+	}
 
-	g.Go(func() error {
-		<-ctx.Done()
-		log.Info("shutting down...")
-		return httpServer.Close()
+	handleControl := controlServer.HandleControlCommand
+	handleAction := controlServer.HandleActionCommand
+	if replication {
+		// Nak so the command is redelivered, eventually to the leader
+		handleControl = func(ctx context.Context, cmd *simv1.ControlCommand) error {
+			if !eng.Active() {
+				return errStandby
+			}
+			return controlServer.HandleControlCommand(ctx, cmd)
+		}
+		handleAction = func(ctx context.Context, cmd *opsv1.ApplyActionCommand) error {
+			if !eng.Active() {
+				return errStandby
+			}
+			return controlServer.HandleActionCommand(ctx, cmd)
+		}
+	}
+	if idle != nil {
+		next := handleControl
+		handleControl = func(ctx context.Context, cmd *simv1.ControlCommand) error {
+			idle.touch(ctx)
+			return next(ctx, cmd)
+		}
+	}
+	a.Consume("sim-engine-control", func(ctx context.Context) (app.Stopper, error) {
+		return subscriber.SubscribeControl(ctx, "sim-engine-control", handleControl)
+	})
+	a.Consume("sim-engine-commands", func(ctx context.Context) (app.Stopper, error) {
+		return subscriber.SubscribeCommands(ctx, "sim-engine-commands", handleAction)
 	})
+	return nil
+}
+
+// replay re-publishes a recorded snapshot file instead of running the simulation.
+// REPLAY_SPEED scales the original cadence (default 1.0).
+func replay(ctx context.Context, path string, publisher *bus.Publisher, log *slog.Logger) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open replay file: %w", err)
+	}
+	defer f.Close()
 
-	return g.Wait()
+	speed := 1.0
+	if v := os.Getenv("REPLAY_SPEED"); v != "" {
+		if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+			speed = parsed
+		}
+	}
+
+	if err := engine.Replay(ctx, f, publisher, speed, log); err != nil {
+		return err
+	}
+
+	// Keep serving the control API after the recording ends
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// topologySizeFromEnv reads TOPOLOGY_NODES, TOPOLOGY_SERVICES_PER_NODE and
+// TOPOLOGY_ZONES over the default size. ok is false when none is set.
+func topologySizeFromEnv() (size engine.TopologySize, ok bool, err error) {
+	params := map[string]string{}
+	for env, param := range map[string]string{
+		"TOPOLOGY_NODES":             engine.ParamTopologyNodes,
+		"TOPOLOGY_SERVICES_PER_NODE": engine.ParamTopologyServicesPerNode,
+		"TOPOLOGY_ZONES":             engine.ParamTopologyZones,
+	} {
+		if v := os.Getenv(env); v != "" {
+			params[param] = v
+		}
+	}
+	if len(params) == 0 {
+		return size, false, nil
+	}
+	size, err = engine.DefaultTopologySize().WithParams(params)
+	if err != nil {
+		return size, false, fmt.Errorf("invalid topology size: %w", err)
+	}
+	return size, true, nil
 }
 
 func getEnv(key, fallback string) string {
@@ -96,6 +345,18 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+func getDuration(key string, fallback time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return d, nil
+}
+
 func loggingInterceptor(log *slog.Logger) connect.UnaryInterceptorFunc {
 	return func(next connect.UnaryFunc) connect.UnaryFunc {
 		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
@@ -108,3 +369,18 @@ func loggingInterceptor(log *slog.Logger) connect.UnaryInterceptorFunc {
 		}
 	}
 }
+
+var errStandby = errors.New("this sim-engine instance is a standby; the leader applies changes")
+
+// standbyInterceptor rejects RPCs with side effects while another instance
+// owns the tick loop, since its next checkpoint would overwrite them
+func standbyInterceptor(eng *engine.Engine) connect.UnaryInterceptorFunc {
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			if !eng.Active() && req.Spec().IdempotencyLevel != connect.IdempotencyNoSideEffects {
+				return nil, connect.NewError(connect.CodeUnavailable, errStandby)
+			}
+			return next(ctx, req)
+		}
+	}
+}
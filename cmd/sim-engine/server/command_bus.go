@@ -0,0 +1,30 @@
+package server
+
+import (
+	"context"
+
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+)
+
+// HandleActionCommand applies an approved action received on ops.commands.
+// Rejected commands are acked, not redelivered; their failure is reported
+// on ops.results. Redelivered commands get the result of their first run.
+func (s *ControlServer) HandleActionCommand(ctx context.Context, cmd *opsv1.ApplyActionCommand) error {
+	result := s.engine.ExecuteCommand(ctx, cmd)
+	if !result.Success {
+		s.log.Warn("action command failed",
+			"action_id", cmd.ActionId.GetValue(),
+			"action_type", cmd.ActionType,
+			"target", cmd.TargetId,
+			"message", result.Message,
+		)
+		return nil
+	}
+	s.log.Info("action command applied",
+		"action_id", cmd.ActionId.GetValue(),
+		"action_type", cmd.ActionType,
+		"target", cmd.TargetId,
+		"tick", result.AppliedAtTick,
+	)
+	return nil
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"connectrpc.com/connect"
+
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+)
+
+// HandleControlCommand applies a control command received on sim.control by
+// dispatching it to the matching SimulationControl handler
+func (s *ControlServer) HandleControlCommand(ctx context.Context, cmd *simv1.ControlCommand) error {
+	commandID := cmd.GetCommandId().GetValue()
+	s.log.Debug("control command received", "command_id", commandID)
+
+	var err error
+	switch c := cmd.Command.(type) {
+	case *simv1.ControlCommand_SetState:
+		_, err = s.SetState(ctx, connect.NewRequest(c.SetState))
+	case *simv1.ControlCommand_SetSpeed:
+		_, err = s.SetSpeed(ctx, connect.NewRequest(c.SetSpeed))
+	case *simv1.ControlCommand_LoadScenario:
+		var resp *connect.Response[simv1.LoadScenarioResponse]
+		resp, err = s.LoadScenario(ctx, connect.NewRequest(c.LoadScenario))
+		if err == nil && !resp.Msg.Success {
+			s.log.Warn("control command rejected", "command_id", commandID, "message", resp.Msg.Message)
+		}
+	case *simv1.ControlCommand_InjectFault:
+		var resp *connect.Response[simv1.InjectFaultResponse]
+		resp, err = s.InjectFault(ctx, connect.NewRequest(c.InjectFault))
+		if err == nil && !resp.Msg.Success {
+			s.log.Warn("control command rejected", "command_id", commandID, "message", resp.Msg.Message)
+		}
+	default:
+		// Unknown commands are acked so they are not redelivered forever
+		s.log.Warn("unknown control command", "command_id", commandID)
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("control command %s: %w", commandID, err)
+	}
+	return nil
+}
@@ -3,9 +3,11 @@ package server
 import (
 	"context"
 	"log/slog"
+	"time"
 
 	"connectrpc.com/connect"
 
+	commonv1 "github.com/microcloud/gen/go/common/v1"
 	simv1 "github.com/microcloud/gen/go/sim/v1"
 	"github.com/microcloud/gen/go/sim/v1/simv1connect"
 	"github.com/microcloud/sim-engine/engine"
@@ -35,6 +37,9 @@ func (s *ControlServer) GetState(ctx context.Context, req *connect.Request[simv1
 		SpeedMultiplier: state.GetSpeedMultiplier(),
 		CurrentTick:     state.GetTickID(),
 		ActiveScenario:  state.GetScenario(),
+		SimTimeUnixMs:   state.SimTimeUnixMs(),
+		PausedMs:        state.PausedDuration().Milliseconds(),
+		RunId:           s.engine.RunID(),
 	}), nil
 }
 
@@ -44,7 +49,12 @@ func (s *ControlServer) SetState(ctx context.Context, req *connect.Request[simv1
 	newState := req.Msg.State
 
 	oldState := state.GetSimState()
-	state.SetSimState(newState)
+	if newState == commonv1.SimulationState_SIMULATION_STATE_RUNNING && oldState != newState {
+		if n := s.engine.Preroll(); n > 0 {
+			s.log.Info("pre-rolled simulation", "ticks", n, "tick_id", state.GetTickID())
+		}
+	}
+	s.engine.SetSimState(ctx, newState)
 
 	s.log.Info("simulation state changed", "from", oldState, "to", newState)
 
@@ -55,39 +65,187 @@ func (s *ControlServer) SetState(ctx context.Context, req *connect.Request[simv1
 
 // SetSpeed sets the simulation speed multiplier
 func (s *ControlServer) SetSpeed(ctx context.Context, req *connect.Request[simv1.SetSpeedRequest]) (*connect.Response[simv1.SetSpeedResponse], error) {
-	state := s.engine.State()
-	state.SetSpeedMultiplier(req.Msg.SpeedMultiplier)
+	mult := s.engine.SetSpeed(ctx, req.Msg.SpeedMultiplier)
 
-	s.log.Info("simulation speed changed", "multiplier", req.Msg.SpeedMultiplier)
+	s.log.Info("simulation speed changed", "multiplier", mult)
 
 	return connect.NewResponse(&simv1.SetSpeedResponse{
-		SpeedMultiplier: state.GetSpeedMultiplier(),
+		SpeedMultiplier: mult,
 	}), nil
 }
 
 // LoadScenario loads a simulation scenario
 func (s *ControlServer) LoadScenario(ctx context.Context, req *connect.Request[simv1.LoadScenarioRequest]) (*connect.Response[simv1.LoadScenarioResponse], error) {
-	state := s.engine.State()
 	scenario := req.Msg.ScenarioName
 
-	validScenarios := map[string]bool{
-		"normal":          true,
-		"high_load":       true,
-		"cascade_failure": true,
-	}
-
-	if !validScenarios[scenario] {
+	if _, ok := engine.LookupScenario(scenario); !ok {
 		return connect.NewResponse(&simv1.LoadScenarioResponse{
 			Success: false,
 			Message: "unknown scenario: " + scenario,
 		}), nil
 	}
 
-	state.SetScenario(scenario)
-	s.log.Info("scenario loaded", "scenario", scenario)
+	if err := s.engine.LoadScenario(ctx, scenario, req.Msg.Parameters); err != nil {
+		return connect.NewResponse(&simv1.LoadScenarioResponse{
+			Success: false,
+			Message: err.Error(),
+		}), nil
+	}
+	s.log.Info("scenario loaded", "scenario", scenario, "parameters", req.Msg.Parameters)
 
 	return connect.NewResponse(&simv1.LoadScenarioResponse{
 		Success: true,
 		Message: "scenario loaded: " + scenario,
 	}), nil
 }
+
+// InjectFault injects a temporary fault into a node or service
+func (s *ControlServer) InjectFault(ctx context.Context, req *connect.Request[simv1.InjectFaultRequest]) (*connect.Response[simv1.InjectFaultResponse], error) {
+	msg := req.Msg
+	duration := time.Duration(msg.DurationSeconds) * time.Second
+
+	fault, err := s.engine.InjectFault(ctx, msg.TargetId, msg.FaultType, msg.Magnitude, duration)
+	if err != nil {
+		return connect.NewResponse(&simv1.InjectFaultResponse{
+			Success: false,
+			Message: err.Error(),
+		}), nil
+	}
+
+	s.log.Info("fault injected", "fault_id", fault.ID, "target", msg.TargetId, "type", msg.FaultType, "magnitude", msg.Magnitude, "duration", duration)
+
+	return connect.NewResponse(&simv1.InjectFaultResponse{
+		Success:                true,
+		Message:                "fault injected: " + msg.FaultType,
+		FaultId:                fault.ID,
+		ExpiresAtSimTimeUnixMs: fault.ExpiresAtSimMs,
+	}), nil
+}
+
+// GetTopology returns all nodes, services and their placement/dependency edges
+func (s *ControlServer) GetTopology(ctx context.Context, req *connect.Request[simv1.GetTopologyRequest]) (*connect.Response[simv1.GetTopologyResponse], error) {
+	topo := s.engine.State().Topology()
+	return connect.NewResponse(&simv1.GetTopologyResponse{
+		TickId:   topo.TickID,
+		Nodes:    topo.Nodes,
+		Services: topo.Services,
+		Edges:    topo.Edges,
+		Source:   topo.Source,
+	}), nil
+}
+
+// GetSnapshot returns the current metric snapshot for clients that poll
+// instead of subscribing to sim.metrics
+func (s *ControlServer) GetSnapshot(ctx context.Context, req *connect.Request[simv1.GetSnapshotRequest]) (*connect.Response[simv1.GetSnapshotResponse], error) {
+	return connect.NewResponse(&simv1.GetSnapshotResponse{
+		Snapshot: s.engine.State().SnapshotCopy(),
+	}), nil
+}
+
+// GetEngineStats reports tick timing and, with TICK_PROFILING, where the
+// tick budget goes by phase
+func (s *ControlServer) GetEngineStats(ctx context.Context, req *connect.Request[simv1.GetEngineStatsRequest]) (*connect.Response[simv1.GetEngineStatsResponse], error) {
+	stats := s.engine.Stats()
+	resp := &simv1.GetEngineStatsResponse{
+		TickBudgetMs:     msec(stats.TickBudget),
+		Ticks:            int64(stats.Ticks),
+		TickMeanMs:       msec(stats.TickMean),
+		Nodes:            int32(stats.Nodes),
+		Services:         int32(stats.Services),
+		ProfilingEnabled: stats.Profiling,
+		DetailLevel:      int32(stats.Detail),
+	}
+	for _, p := range stats.Phases {
+		resp.Phases = append(resp.Phases, &simv1.TickPhaseStats{
+			Phase:         p.Phase,
+			Ticks:         int64(p.Ticks),
+			MeanMs:        msec(p.Mean),
+			MaxMs:         msec(p.Max),
+			LastMs:        msec(p.Last),
+			BudgetPercent: 100 * p.Mean.Seconds() / stats.TickBudget.Seconds(),
+		})
+	}
+	return connect.NewResponse(resp), nil
+}
+
+// EvaluateAction projects an action's effect on a clone of the simulation.
+// Actions that fail or do not fit the target are invalid arguments.
+func (s *ControlServer) EvaluateAction(ctx context.Context, req *connect.Request[simv1.EvaluateActionRequest]) (*connect.Response[simv1.EvaluateActionResponse], error) {
+	msg := req.Msg
+	result, err := s.engine.WhatIf(msg.ActionType, msg.TargetId, msg.Parameters, int(msg.Ticks))
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	resp := &simv1.EvaluateActionResponse{
+		Event:                  result.Event,
+		FromTickId:             result.FromTickID,
+		Ticks:                  int32(result.Ticks),
+		Services:               make([]*simv1.ServiceProjection, 0, len(result.Services)),
+		Nodes:                  make([]*simv1.NodeProjection, 0, len(result.Nodes)),
+		MeanErrorRatePercent:   projectedMetric(result.MeanErrorRatePercent),
+		MeanLatencyP99Ms:       projectedMetric(result.MeanLatencyP99Ms),
+		UnhealthyServicesDelta: int32(result.UnhealthyServicesDelta),
+	}
+	for _, p := range result.Services {
+		resp.Services = append(resp.Services, &simv1.ServiceProjection{
+			ServiceId:         p.ServiceID,
+			ServiceName:       p.Name,
+			ErrorRatePercent:  projectedMetric(p.ErrorRatePercent),
+			LatencyP99Ms:      projectedMetric(p.LatencyP99Ms),
+			RequestsPerSecond: projectedMetric(p.RPS),
+			BaselineHealth:    p.BaselineHealth,
+			ProjectedHealth:   p.ProjectedHealth,
+		})
+	}
+	for _, p := range result.Nodes {
+		resp.Nodes = append(resp.Nodes, &simv1.NodeProjection{
+			NodeId:             p.NodeID,
+			NodeName:           p.Name,
+			CpuUsagePercent:    projectedMetric(p.CPUUsagePercent),
+			MemoryUsagePercent: projectedMetric(p.MemoryUsagePercent),
+		})
+	}
+	return connect.NewResponse(resp), nil
+}
+
+func projectedMetric(p engine.Projected) *simv1.ProjectedMetric {
+	return &simv1.ProjectedMetric{Baseline: p.Baseline, Projected: p.Projected, Delta: p.Delta()}
+}
+
+// msec converts d to fractional milliseconds
+func msec(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// ListScenarios returns the registered scenarios and the parameters each
+// accepts in LoadScenario
+func (s *ControlServer) ListScenarios(ctx context.Context, req *connect.Request[simv1.ListScenariosRequest]) (*connect.Response[simv1.ListScenariosResponse], error) {
+	// Every scenario accepts the topology size, defaulting to the current one
+	topology := s.engine.State().TopologySize().Params()
+
+	var infos []*simv1.ScenarioInfo
+	for _, sc := range engine.Scenarios() {
+		info := &simv1.ScenarioInfo{
+			Name:        sc.Name,
+			Description: sc.Description,
+			Scripted:    len(sc.Script) > 0,
+		}
+		params := topology
+		if sc.Chaos != nil {
+			params = append(sc.Chaos.Params(), topology...)
+		}
+		for _, p := range params {
+			info.Parameters = append(info.Parameters, &simv1.ScenarioParameter{
+				Name:         p.Name,
+				Description:  p.Description,
+				DefaultValue: p.Default,
+			})
+		}
+		for _, m := range sc.CustomMetrics {
+			info.CustomMetrics = append(info.CustomMetrics, m.Name)
+		}
+		infos = append(infos, info)
+	}
+	return connect.NewResponse(&simv1.ListScenariosResponse{Scenarios: infos}), nil
+}
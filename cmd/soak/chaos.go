@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/microcloud/app"
+	"github.com/microcloud/bus"
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+	"github.com/microcloud/storage"
+)
+
+// chaosCheckMargin is left between the consistency check and the end of
+// the run, so the check finishes before the deadline cancels it
+const chaosCheckMargin = 30 * time.Second
+
+// consumerChaos stops and recreates bus consumers at random intervals
+// until a deadline, so the run exercises redelivery of unacked messages and
+// the handlers' dedup. The durable consumers resume where they stopped.
+type consumerChaos struct {
+	mean     time.Duration
+	until    time.Time
+	restarts atomic.Int64
+	log      *slog.Logger
+}
+
+// consume is a drop-in for app.Consume that keeps restarting the consumer
+// until the chaos deadline and then leaves it running
+func (c *consumerChaos) consume(a *app.App) func(string, func(context.Context) (app.Stopper, error)) {
+	return func(name string, subscribe func(context.Context) (app.Stopper, error)) {
+		a.Go(name, func(ctx context.Context) error {
+			for {
+				cc, err := subscribe(ctx)
+				if err != nil {
+					return fmt.Errorf("subscribe %s: %w", name, err)
+				}
+				if !c.pause(ctx, time.Until(c.until), c.jitter(c.mean)) {
+					// Past the deadline, or shutting down
+					defer cc.Stop()
+					<-ctx.Done()
+					return ctx.Err()
+				}
+				cc.Stop()
+				n := c.restarts.Add(1)
+				c.log.Debug("consumer stopped", "consumer", name, "restarts", n)
+
+				// Stay down for a moment so messages pile up behind it
+				if !c.pause(ctx, time.Until(c.until), c.jitter(c.mean/4)) && ctx.Err() != nil {
+					return ctx.Err()
+				}
+			}
+		})
+	}
+}
+
+// jitter returns a random duration in [0, 2*mean)
+func (c *consumerChaos) jitter(mean time.Duration) time.Duration {
+	if mean <= 0 {
+		return 0
+	}
+	return rand.N(2 * mean)
+}
+
+// pause waits d, and reports false without waiting it out if ctx is done or
+// left runs out first
+func (c *consumerChaos) pause(ctx context.Context, left, d time.Duration) bool {
+	if left <= d {
+		return false
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// eventTap records what the pipeline published, on its own consumers that
+// are never restarted, to check the database against at the end of a run
+type eventTap struct {
+	mu        sync.Mutex
+	incidents []string
+	actions   map[string]string // action ID -> incident ID
+	results   map[string]bool   // action ID -> success of the latest result
+}
+
+func newEventTap() *eventTap {
+	return &eventTap{
+		actions: make(map[string]string),
+		results: make(map[string]bool),
+	}
+}
+
+// register adds the tap's consumers to the app
+func (t *eventTap) register(a *app.App, subscriber *bus.Subscriber) {
+	a.Consume("soak-tap-incidents", func(ctx context.Context) (app.Stopper, error) {
+		return subscriber.SubscribeIncidents(ctx, "soak-tap-incidents", func(_ context.Context, incident *opsv1.Incident) error {
+			if incident.Resolved {
+				return nil
+			}
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.incidents = append(t.incidents, incident.Id.GetValue())
+			return nil
+		})
+	})
+	a.Consume("soak-tap-actions", func(ctx context.Context) (app.Stopper, error) {
+		return subscriber.SubscribeActions(ctx, "soak-tap-actions", func(_ context.Context, action *opsv1.Action) error {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.actions[action.Id.GetValue()] = action.IncidentId.GetValue()
+			return nil
+		})
+	})
+	a.Consume("soak-tap-results", func(ctx context.Context) (app.Stopper, error) {
+		return subscriber.SubscribeActionResults(ctx, "soak-tap-results", func(_ context.Context, result *opsv1.ActionResult) error {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.results[result.ActionId.GetValue()] = result.Success
+			return nil
+		})
+	})
+}
+
+// snapshot copies what the tap has recorded so far
+func (t *eventTap) snapshot() (incidents []string, actions map[string]string, results map[string]bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	incidents = append([]string(nil), t.incidents...)
+	actions = make(map[string]string, len(t.actions))
+	for k, v := range t.actions {
+		actions[k] = v
+	}
+	results = make(map[string]bool, len(t.results))
+	for k, v := range t.results {
+		results[k] = v
+	}
+	return incidents, actions, results
+}
+
+// verifyConsistency waits settle past the chaos deadline, for redeliveries
+// to drain, and then checks the events published before the deadline
+// against the database: every incident and action is stored, no incident
+// got more than one action, and each action's status matches its latest
+// result, so a stale redelivered result did not overwrite a newer one
+func verifyConsistency(ctx context.Context, db *storage.DB, tap *eventTap, chaos *consumerChaos, settle time.Duration, log *slog.Logger) error {
+	deadline := time.NewTimer(time.Until(chaos.until))
+	defer deadline.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-deadline.C:
+	}
+	incidents, actions, results := tap.snapshot()
+	log.Info("consumer chaos over, settling", "restarts", chaos.restarts.Load(), "settle", settle)
+
+	wait := time.NewTimer(settle)
+	defer wait.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-wait.C:
+	}
+
+	incidentsRepo := storage.NewIncidentsRepository(db)
+	actionsRepo := storage.NewActionsRepository(db)
+	var errs []error
+
+	for _, id := range incidents {
+		if _, err := incidentsRepo.GetByID(ctx, id); err != nil {
+			errs = append(errs, fmt.Errorf("published incident %s: %w", id, err))
+		}
+	}
+
+	perIncident := make(map[string]bool)
+	for id, incidentID := range actions {
+		row, err := actionsRepo.GetByID(ctx, id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("published action %s: %w", id, err))
+			continue
+		}
+		if row.IncidentID != incidentID {
+			errs = append(errs, fmt.Errorf("action %s: stored for incident %s, published for %s", id, row.IncidentID, incidentID))
+		}
+		if perIncident[incidentID] {
+			continue
+		}
+		perIncident[incidentID] = true
+		rows, err := actionsRepo.ListByIncident(ctx, incidentID)
+		if err != nil {
+			return fmt.Errorf("list actions for incident %s: %w", incidentID, err)
+		}
+		if len(rows) > 1 {
+			errs = append(errs, fmt.Errorf("incident %s: %d actions stored, want at most 1", incidentID, len(rows)))
+		}
+	}
+
+	for id, success := range results {
+		if _, ok := actions[id]; !ok {
+			continue
+		}
+		row, err := actionsRepo.GetByID(ctx, id)
+		if err != nil {
+			continue // Already reported above
+		}
+		want := commonv1.ActionStatus_ACTION_STATUS_FAILED
+		if success {
+			want = commonv1.ActionStatus_ACTION_STATUS_COMPLETED
+		}
+		if got := commonv1.ActionStatus(row.Status); got != want {
+			errs = append(errs, fmt.Errorf("action %s: status %s, latest result says %s", id, got, want))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("db and published events diverged after %d consumer restarts: %w", chaos.restarts.Load(), errors.Join(errs...))
+	}
+	log.Info("db consistent with published events",
+		"incidents", len(incidents),
+		"actions", len(actions),
+		"results", len(results),
+		"restarts", chaos.restarts.Load(),
+	)
+	return nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"connectrpc.com/connect"
+
+	"github.com/microcloud/agent-service/decider"
+	"github.com/microcloud/app"
+	"github.com/microcloud/bus"
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+	orchserver "github.com/microcloud/orchestrator/server"
+	"github.com/microcloud/signal-service/detector"
+	"github.com/microcloud/sim-engine/engine"
+	simserver "github.com/microcloud/sim-engine/server"
+	"github.com/microcloud/storage"
+)
+
+// build wires every service into one app, run until ctx is done or a
+// sample breaks the limits
+func build(ctx context.Context, a *app.App, cfg config) error {
+	log := a.Log()
+
+	db, err := storage.New(ctx, storage.ConfigFromEnv())
+	if err != nil {
+		return err
+	}
+	a.OnStop("database", func(context.Context) error {
+		db.Close()
+		return nil
+	})
+	if err := db.Migrate(ctx); err != nil {
+		log.Warn("migration error (may be expected if tables exist)", "error", err)
+	}
+
+	eventBus, err := bus.New(ctx, bus.ConfigFromEnv())
+	if err != nil {
+		return err
+	}
+	a.OnStop("nats", func(context.Context) error {
+		return eventBus.Close()
+	})
+
+	publisher := bus.NewPublisher(eventBus)
+	subscriber := bus.NewSubscriber(eventBus)
+	metricsRepo := storage.NewMetricsRepository(db)
+
+	eng := engine.New(publisher, log.With("component", "sim-engine"))
+	control := simserver.NewControlServer(eng, log.With("component", "sim-engine"))
+	det := detector.New(publisher, metricsRepo, log.With("component", "detector"),
+		detector.WithIncidents(storage.NewIncidentsRepository(db)),
+	)
+	stormDet := detector.NewStormDetector(publisher, detector.DefaultStormConfig(), log.With("component", "storm-detector"))
+	catalog := decider.NewCatalog()
+	dec := decider.New(publisher, db, catalog, log.With("component", "decider"))
+	hub := orchserver.NewStreamHub(subscriber, log.With("component", "stream-hub"))
+	actions := orchserver.NewActionServer(db, publisher, nil, log.With("component", "actions"))
+
+	if err := eng.LoadScenario(ctx, cfg.scenario, nil); err != nil {
+		return fmt.Errorf("load scenario: %w", err)
+	}
+	eng.SetSpeed(ctx, cfg.speed)
+	eng.SetSimState(ctx, commonv1.SimulationState_SIMULATION_STATE_RUNNING)
+
+	// The stream hub listens on a random port, so it is served here rather
+	// than by the app
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	httpServer := &http.Server{Handler: hub}
+	streamURL := "http://" + ln.Addr().String()
+
+	out, err := newSampleWriter(cfg.csvPath)
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	a.OnStop("sample writer", func(context.Context) error {
+		return out.Close()
+	})
+
+	consume := a.Consume
+	var chaos *consumerChaos
+	if cfg.consumerChaos > 0 {
+		deadline, _ := ctx.Deadline()
+		chaos = &consumerChaos{
+			mean:  cfg.consumerChaos,
+			until: deadline.Add(-cfg.settle - chaosCheckMargin),
+			log:   log.With("component", "consumer-chaos"),
+		}
+		consume = chaos.consume(a)
+	}
+
+	a.Go("sim-engine", eng.Run)
+	a.Go("stream-hub", hub.Start)
+	a.Go("decider-batches", dec.RunBatches)
+	consume("signal-service", func(ctx context.Context) (app.Stopper, error) {
+		return subscriber.SubscribeMetrics(ctx, "signal-service", det.ProcessSnapshot)
+	})
+	consume("signal-service-storm", func(ctx context.Context) (app.Stopper, error) {
+		return subscriber.SubscribeIncidents(ctx, "signal-service-storm", stormDet.ProcessIncident)
+	})
+	consume("agent-service", func(ctx context.Context) (app.Stopper, error) {
+		return subscriber.SubscribeIncidents(ctx, "agent-service", dec.ProcessIncident)
+	})
+	consume("agent-service-catalog", func(ctx context.Context) (app.Stopper, error) {
+		return subscriber.SubscribeMetrics(ctx, "agent-service-catalog", func(ctx context.Context, snapshot *simv1.MetricSnapshot) error {
+			catalog.Update(snapshot)
+			return nil
+		})
+	})
+	consume("sim-engine-commands", func(ctx context.Context) (app.Stopper, error) {
+		return subscriber.SubscribeCommands(ctx, "sim-engine-commands", control.HandleActionCommand)
+	})
+	consume("orchestrator-results", func(ctx context.Context) (app.Stopper, error) {
+		return subscriber.SubscribeActionResults(ctx, "orchestrator-results", actions.HandleActionResult)
+	})
+	consume("agent-service-results", func(ctx context.Context) (app.Stopper, error) {
+		return subscriber.SubscribeActionResults(ctx, "agent-service-results", dec.HandleActionResult)
+	})
+
+	a.Go("stream server", func(ctx context.Context) error {
+		stop := context.AfterFunc(ctx, func() { httpServer.Close() })
+		defer stop()
+		if err := httpServer.Serve(ln); err != http.ErrServerClosed {
+			return err
+		}
+		return nil
+	})
+	for i := 0; i < cfg.sseClients; i++ {
+		a.Go("sse client", func(ctx context.Context) error { return churnSSE(ctx, streamURL, cfg.sseChurn) })
+	}
+	if cfg.approveEvery > 0 {
+		a.Go("approver", func(ctx context.Context) error { return approvePending(ctx, actions, cfg.approveEvery, log) })
+	}
+
+	if chaos != nil {
+		tap := newEventTap()
+		tap.register(a, subscriber)
+		a.Go("consistency check", func(ctx context.Context) error {
+			return verifyConsistency(ctx, db, tap, chaos, cfg.settle, log)
+		})
+	}
+
+	a.Go("probe", func(ctx context.Context) error {
+		take := func() sample {
+			s := takeSample()
+			s.sseClients = hub.ClientCount()
+			s.windows = det.WindowCount()
+			s.cooldowns, s.proposals = dec.TrackedCount()
+			return s
+		}
+		return probe(ctx, cfg, take, out, log)
+	})
+	return nil
+}
+
+// churnSSE keeps one SSE client connected, reconnecting every churn so the
+// hub keeps adding and removing clients
+func churnSSE(ctx context.Context, url string, churn time.Duration) error {
+	for ctx.Err() == nil {
+		connCtx, cancel := context.WithTimeout(ctx, churn)
+		req, err := http.NewRequestWithContext(connCtx, http.MethodGet, url, nil)
+		if err != nil {
+			cancel()
+			return err
+		}
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		// Wait out the period on failed connects rather than spinning
+		<-connCtx.Done()
+		cancel()
+	}
+	return ctx.Err()
+}
+
+// approvePending approves every pending action each interval, so commands
+// and results flow like they do with an operator at the console
+func approvePending(ctx context.Context, actions *orchserver.ActionServer, interval time.Duration, log *slog.Logger) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		resp, err := actions.ListPendingActions(ctx, connect.NewRequest(&opsv1.ListPendingActionsRequest{}))
+		if err != nil {
+			log.Warn("failed to list pending actions", "error", err)
+			continue
+		}
+		for _, action := range resp.Msg.Actions {
+			_, err := actions.ApproveAction(ctx, connect.NewRequest(&opsv1.ApproveActionRequest{ActionId: action.Id}))
+			if err != nil {
+				log.Warn("failed to approve action", "action_id", action.Id.GetValue(), "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// sample is one reading of the process and the pipeline's tracked state
+type sample struct {
+	at         time.Time
+	heapMB     float64 // live heap after a forced GC
+	goroutines int
+	sseClients int
+	windows    int // detector metric windows
+	cooldowns  int // decider cooldown keys
+	proposals  int // decider proposals awaiting a result
+}
+
+// takeSample reads heap and goroutine counts after a GC, so the heap
+// figure is live data rather than garbage awaiting collection
+func takeSample() sample {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return sample{
+		at:         time.Now(),
+		heapMB:     float64(m.HeapAlloc) / (1 << 20),
+		goroutines: runtime.NumGoroutine(),
+	}
+}
+
+// limits bounds growth over the baseline sample
+type limits struct {
+	heapGrowthMB    float64
+	goroutineGrowth int
+	sseClients      int // connected clients never exceed the clients started
+}
+
+// check returns an error describing the first bound cur breaks
+func (l limits) check(base, cur sample) error {
+	if growth := cur.heapMB - base.heapMB; growth > l.heapGrowthMB {
+		return fmt.Errorf("heap grew %.1f MiB over baseline (%.1f -> %.1f MiB), limit %.1f MiB",
+			growth, base.heapMB, cur.heapMB, l.heapGrowthMB)
+	}
+	if growth := cur.goroutines - base.goroutines; growth > l.goroutineGrowth {
+		return fmt.Errorf("goroutines grew by %d over baseline (%d -> %d), limit %d",
+			growth, base.goroutines, cur.goroutines, l.goroutineGrowth)
+	}
+	if cur.sseClients > l.sseClients {
+		return fmt.Errorf("%d SSE clients registered but only %d connected", cur.sseClients, l.sseClients)
+	}
+	return nil
+}
+
+// probe samples every cfg.sampleEvery, takes the baseline once warmup has
+// passed and checks every later sample against it
+func probe(ctx context.Context, cfg config, take func() sample, out *sampleWriter, log *slog.Logger) error {
+	start := time.Now()
+	ticker := time.NewTicker(cfg.sampleEvery)
+	defer ticker.Stop()
+
+	var base, last sample
+	baselined := false
+	for {
+		select {
+		case <-ctx.Done():
+			if baselined {
+				log.Info("soak summary",
+					"heap_mb", fmt.Sprintf("%.1f -> %.1f", base.heapMB, last.heapMB),
+					"goroutines", fmt.Sprintf("%d -> %d", base.goroutines, last.goroutines),
+					"windows", fmt.Sprintf("%d -> %d", base.windows, last.windows),
+					"cooldowns", fmt.Sprintf("%d -> %d", base.cooldowns, last.cooldowns),
+				)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		last = take()
+		if err := out.Write(last); err != nil {
+			return fmt.Errorf("write sample: %w", err)
+		}
+		log.Info("soak sample",
+			"elapsed", time.Since(start).Round(time.Second),
+			"heap_mb", fmt.Sprintf("%.1f", last.heapMB),
+			"goroutines", last.goroutines,
+			"sse_clients", last.sseClients,
+			"windows", last.windows,
+			"cooldowns", last.cooldowns,
+			"proposals", last.proposals,
+		)
+
+		if !baselined {
+			if time.Since(start) >= cfg.warmup {
+				base, baselined = last, true
+				log.Info("baseline taken", "heap_mb", fmt.Sprintf("%.1f", base.heapMB), "goroutines", base.goroutines)
+			}
+			continue
+		}
+		if err := cfg.limits.check(base, last); err != nil {
+			return err
+		}
+	}
+}
+
+// sampleWriter writes samples as CSV. The zero path discards them.
+type sampleWriter struct {
+	f *os.File
+	w *csv.Writer
+}
+
+func newSampleWriter(path string) (*sampleWriter, error) {
+	if path == "" {
+		return &sampleWriter{w: csv.NewWriter(io.Discard)}, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create sample file: %w", err)
+	}
+	sw := &sampleWriter{f: f, w: csv.NewWriter(f)}
+	sw.w.Write([]string{"time", "heap_mb", "goroutines", "sse_clients", "windows", "cooldowns", "proposals"})
+	return sw, nil
+}
+
+// Write appends a sample and flushes, so the file is complete even if the
+// run is killed
+func (sw *sampleWriter) Write(s sample) error {
+	sw.w.Write([]string{
+		s.at.UTC().Format(time.RFC3339),
+		strconv.FormatFloat(s.heapMB, 'f', 2, 64),
+		strconv.Itoa(s.goroutines),
+		strconv.Itoa(s.sseClients),
+		strconv.Itoa(s.windows),
+		strconv.Itoa(s.cooldowns),
+		strconv.Itoa(s.proposals),
+	})
+	sw.w.Flush()
+	return sw.w.Error()
+}
+
+func (sw *sampleWriter) Close() error {
+	if sw.f == nil {
+		return nil
+	}
+	return sw.f.Close()
+}
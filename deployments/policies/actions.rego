@@ -0,0 +1,38 @@
+# Example action policies for the orchestrator. Load them at start with
+# POLICY_BUNDLE=deployments/policies, or at runtime by building a bundle
+# (opa build -b deployments/policies) and passing bundle.tar.gz to
+# AdminService.LoadPolicyBundle.
+package parallax.actions
+
+# Actions that only touch one service and are cheap to undo
+routine := {"ACTION_TYPE_RESTART_SERVICE", "ACTION_TYPE_REBALANCE_TRAFFIC"}
+
+# Statuses of actions that were let through
+taken := {"ACTION_STATUS_APPROVED", "ACTION_STATUS_EXECUTING", "ACTION_STATUS_COMPLETED", "ACTION_STATUS_FAILED"}
+
+# Same-type actions on the target in the last hour
+repeats := [a |
+	some a in input.recent_actions
+	a.action_type == input.action.action_type
+	a.status in taken
+]
+
+# Routine fixes for non-critical incidents go ahead without an operator,
+# unless the last one on the target did not help
+auto_approve if {
+	input.action.action_type in routine
+	input.incident.severity != "INCIDENT_SEVERITY_CRITICAL"
+	count(repeats) == 0
+}
+
+deny contains msg if {
+	count(repeats) >= 3
+	msg := sprintf("%s already ran %d times on %s in the last hour", [input.action.action_type, count(repeats), input.action.target_id])
+}
+
+deny contains msg if {
+	input.action.action_type == "ACTION_TYPE_REBOOT_NODE"
+	input.kind == "approve"
+	not startswith(input.actor, "oncall")
+	msg := "node reboots need an on-call key"
+}
@@ -0,0 +1,268 @@
+// Package app wires a microcloud binary together. An App owns the logger
+// and the signal context, starts its components in the order they were
+// added and stops them in reverse, runs long-lived tasks and bus consumers
+// until shutdown, and serves one HTTP server with /health and /debug/vars
+// registered the same way in every service.
+//
+//	func main() {
+//		app.Main("agent-service", func(ctx context.Context, a *app.App) error {
+//			db, err := storage.New(ctx, storage.ConfigFromEnv())
+//			if err != nil {
+//				return err
+//			}
+//			a.OnStop("database", func(context.Context) error { db.Close(); return nil })
+//			...
+//			a.Go("batches", dec.RunBatches)
+//			return nil
+//		})
+//	}
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/microcloud/logger"
+)
+
+// DefaultShutdownTimeout bounds how long stop hooks and the HTTP server's
+// drain may take once the app is stopping
+const DefaultShutdownTimeout = 10 * time.Second
+
+// Hook is a component with a lifecycle. OnStart runs during Run, after the
+// hooks added before it have started; OnStop runs on shutdown, before the
+// hooks added before it stop. Either may be nil.
+type Hook struct {
+	Name    string
+	OnStart func(ctx context.Context) error
+	OnStop  func(ctx context.Context) error
+}
+
+// Stopper is a running bus consumer
+type Stopper interface {
+	Stop()
+}
+
+// BuildFunc builds the binary's components on a. Resources it opens are
+// registered with OnStop so they are closed even if a later step fails.
+type BuildFunc func(ctx context.Context, a *App) error
+
+type component struct {
+	hook    Hook
+	started bool
+}
+
+type task struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// App is a binary's set of components, tasks and HTTP handlers
+type App struct {
+	name            string
+	log             *slog.Logger
+	shutdownTimeout time.Duration
+
+	components []*component
+	tasks      []task
+
+	mux     *http.ServeMux
+	addr    string
+	handler http.Handler
+
+	mu       sync.Mutex // guards checks, stopping, routes, env and configs
+	checks   []healthCheck
+	stopping bool
+	routes   []Route
+	env      []string
+	configs  []configSource
+
+	adminToken string
+	adminVars  bool // /debug/vars takes the admin token
+}
+
+// Option configures the App
+type Option func(*App)
+
+// WithLogger replaces the logger built from the environment
+func WithLogger(log *slog.Logger) Option {
+	return func(a *App) {
+		a.log = log
+	}
+}
+
+// WithShutdownTimeout overrides DefaultShutdownTimeout
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(a *App) {
+		a.shutdownTimeout = d
+	}
+}
+
+// WithAdminVars serves /debug/vars, like /api/config, only to requests
+// carrying the admin token, for services whose counters are not public
+func WithAdminVars() Option {
+	return func(a *App) {
+		a.adminVars = true
+	}
+}
+
+// New creates an app for the named binary, logging through
+// logger.NewFromEnv(name) unless WithLogger is given
+func New(name string, opts ...Option) *App {
+	a := &App{
+		name:            name,
+		shutdownTimeout: DefaultShutdownTimeout,
+		adminToken:      os.Getenv(AdminTokenEnv),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if a.log == nil {
+		a.log = logger.NewFromEnv(name)
+	}
+	return a
+}
+
+// Main builds and runs the app until SIGINT or SIGTERM, exiting with
+// status 1 if either step fails
+func Main(name string, build BuildFunc, opts ...Option) {
+	a := New(name, opts...)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if err := a.BuildAndRun(ctx, build); err != nil && !errors.Is(err, context.Canceled) {
+		a.log.Error("fatal error", "error", err)
+		os.Exit(1)
+	}
+}
+
+// BuildAndRun builds the app and runs it until ctx is done. If the build
+// fails, whatever it registered with OnStop is stopped.
+func (a *App) BuildAndRun(ctx context.Context, build BuildFunc) error {
+	b := Build()
+	a.log.Info("starting", "version", b.Version, "commit", b.Commit, "build_date", b.BuildDate)
+	if err := build(ctx, a); err != nil {
+		return errors.Join(err, a.stop())
+	}
+	return a.Run(ctx)
+}
+
+// Name returns the binary's name
+func (a *App) Name() string {
+	return a.name
+}
+
+// Log returns the app's logger
+func (a *App) Log() *slog.Logger {
+	return a.log
+}
+
+// Append adds a component, started by Run after the ones added before it
+func (a *App) Append(h Hook) {
+	a.components = append(a.components, &component{hook: h})
+}
+
+// OnStop registers fn to release a resource that is already open, such as
+// a database pool. It is stopped after the components added after it.
+func (a *App) OnStop(name string, fn func(ctx context.Context) error) {
+	a.components = append(a.components, &component{hook: Hook{Name: name, OnStop: fn}, started: true})
+}
+
+// Go adds a task run once every component has started. The app stops when
+// any task returns, so tasks run until ctx is done.
+func (a *App) Go(name string, fn func(ctx context.Context) error) {
+	a.tasks = append(a.tasks, task{name: name, fn: fn})
+}
+
+// Consume adds a task that starts a bus consumer with subscribe and keeps
+// it running until shutdown
+func (a *App) Consume(name string, subscribe func(ctx context.Context) (Stopper, error)) {
+	a.Go(name, func(ctx context.Context) error {
+		a.log.Info("subscribing", "consumer", name)
+		cc, err := subscribe(ctx)
+		if err != nil {
+			return fmt.Errorf("subscribe %s: %w", name, err)
+		}
+		defer cc.Stop()
+
+		<-ctx.Done()
+		return ctx.Err()
+	})
+}
+
+// Run starts every component in order, runs the tasks and the HTTP server
+// until ctx is done or one of them fails, and then stops the started
+// components in reverse order. A component failing to start stops the ones
+// started before it.
+func (a *App) Run(ctx context.Context) error {
+	for _, c := range a.components {
+		if c.started || c.hook.OnStart == nil {
+			c.started = true
+			continue
+		}
+		if err := c.hook.OnStart(ctx); err != nil {
+			return errors.Join(fmt.Errorf("start %s: %w", c.hook.Name, err), a.stop())
+		}
+		c.started = true
+		a.log.Debug("component started", "component", c.hook.Name)
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	for _, t := range a.tasks {
+		g.Go(func() error {
+			return t.fn(gctx)
+		})
+	}
+	if a.addr != "" {
+		a.serve(gctx, g)
+	}
+	err := g.Wait()
+
+	a.log.Info("shutting down...")
+	return errors.Join(err, a.stop())
+}
+
+// stop stops the started components in reverse order, each within what is
+// left of the shutdown timeout
+func (a *App) stop() error {
+	a.setStopping()
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.shutdownTimeout)
+	defer cancel()
+
+	var errs []error
+	for i := len(a.components) - 1; i >= 0; i-- {
+		c := a.components[i]
+		if !c.started {
+			continue
+		}
+		c.started = false
+		if c.hook.OnStop == nil {
+			continue
+		}
+		if err := c.hook.OnStop(ctx); err != nil {
+			a.log.Warn("component failed to stop", "component", c.hook.Name, "error", err)
+			errs = append(errs, fmt.Errorf("stop %s: %w", c.hook.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// setStopping fails /health from now on, so load balancers stop routing to
+// the app while it drains
+func (a *App) setStopping() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stopping = true
+}
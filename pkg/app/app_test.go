@@ -0,0 +1,269 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestApp() *App {
+	return New("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+}
+
+// record returns a hook appending its start and stop to events
+func record(events *[]string, name string) Hook {
+	return Hook{
+		Name: name,
+		OnStart: func(context.Context) error {
+			*events = append(*events, "start "+name)
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			*events = append(*events, "stop "+name)
+			return nil
+		},
+	}
+}
+
+func TestRunOrder(t *testing.T) {
+	a := newTestApp()
+	var events []string
+	a.OnStop("db", func(context.Context) error {
+		events = append(events, "stop db")
+		return nil
+	})
+	a.Append(record(&events, "cache"))
+	a.Append(record(&events, "server"))
+	a.Go("task", func(ctx context.Context) error {
+		events = append(events, "task")
+		return errors.New("done")
+	})
+
+	if err := a.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "done") {
+		t.Fatalf("expected the task's error, got %v", err)
+	}
+	want := []string{"start cache", "start server", "task", "stop server", "stop cache", "stop db"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+func TestStartFailureStopsStarted(t *testing.T) {
+	a := newTestApp()
+	var events []string
+	a.Append(record(&events, "first"))
+	a.Append(Hook{Name: "broken", OnStart: func(context.Context) error {
+		return errors.New("no route")
+	}})
+	a.Append(record(&events, "never"))
+	a.Go("task", func(context.Context) error {
+		t.Error("task ran although startup failed")
+		return nil
+	})
+
+	err := a.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "start broken: no route") {
+		t.Fatalf("expected start error, got %v", err)
+	}
+	want := []string{"start first", "stop first"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+func TestBuildFailureReleasesResources(t *testing.T) {
+	a := newTestApp()
+	closed := false
+	err := a.BuildAndRun(context.Background(), func(ctx context.Context, a *App) error {
+		a.OnStop("db", func(context.Context) error {
+			closed = true
+			return nil
+		})
+		return errors.New("bad config")
+	})
+	if err == nil || !strings.Contains(err.Error(), "bad config") {
+		t.Fatalf("expected build error, got %v", err)
+	}
+	if !closed {
+		t.Error("resource opened during build was not released")
+	}
+}
+
+func TestConsume(t *testing.T) {
+	a := newTestApp()
+	ctx, cancel := context.WithCancel(context.Background())
+	cc := &fakeConsumer{}
+	a.Consume("metrics", func(context.Context) (Stopper, error) {
+		cancel()
+		return cc, nil
+	})
+	if err := a.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if !cc.stopped {
+		t.Error("consumer not stopped on shutdown")
+	}
+}
+
+type fakeConsumer struct{ stopped bool }
+
+func (c *fakeConsumer) Stop() { c.stopped = true }
+
+func TestHealth(t *testing.T) {
+	a := newTestApp()
+	var down error
+	a.HealthCheck("nats", func(context.Context) error { return down })
+
+	get := func() (int, string) {
+		rec := httptest.NewRecorder()
+		a.Mux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, HealthPath, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	if code, body := get(); code != http.StatusOK || body != "ok" {
+		t.Errorf("healthy: got %d %q", code, body)
+	}
+	down = errors.New("disconnected")
+	if code, body := get(); code != http.StatusServiceUnavailable || !strings.Contains(body, "nats: disconnected") {
+		t.Errorf("failing check: got %d %q", code, body)
+	}
+	down = nil
+	a.setStopping()
+	if code, body := get(); code != http.StatusServiceUnavailable || !strings.Contains(body, "stopping") {
+		t.Errorf("stopping: got %d %q", code, body)
+	}
+
+	rec := httptest.NewRecorder()
+	a.Mux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, VarsPath, nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("%s: got %d", VarsPath, rec.Code)
+	}
+}
+
+func TestAdminVars(t *testing.T) {
+	t.Setenv(AdminTokenEnv, "admin")
+	a := New("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))), WithAdminVars())
+
+	get := func(token string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, VarsPath, nil)
+		if token != "" {
+			req.Header.Set(AdminTokenHeader, token)
+		}
+		a.Mux().ServeHTTP(rec, req)
+		return rec.Code
+	}
+	for _, token := range []string{"", "wrong"} {
+		if code := get(token); code != http.StatusForbidden {
+			t.Errorf("token %q: got %d, want 403", token, code)
+		}
+	}
+	if code := get("admin"); code != http.StatusOK {
+		t.Errorf("admin token: got %d, want 200", code)
+	}
+}
+
+// loggingInterceptor stands in for an interceptor constructor
+func loggingInterceptor() func(context.Context) error {
+	return func(context.Context) error { return nil }
+}
+
+type tracingInterceptor struct{}
+
+func TestMeta(t *testing.T) {
+	a := newTestApp()
+	mux := a.Mux()
+	a.Handle(mux, "/api/stream", http.NotFoundHandler())
+	// A second mux with the shared handlers lists them once
+	a.RegisterHandlers(http.NewServeMux())
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, MetaPath, nil))
+	var got meta
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Service != "test" || got.Build.Version != Version || got.Build.GoVersion == "" {
+		t.Errorf("service and build: got %+v", got)
+	}
+	var patterns []string
+	for _, r := range got.Routes {
+		patterns = append(patterns, r.Pattern)
+		if r.Kind != "http" || r.Interceptors != nil {
+			t.Errorf("route %s: got kind %q, interceptors %v", r.Pattern, r.Kind, r.Interceptors)
+		}
+	}
+	want := []string{ConfigPath, MetaPath, "/api/stream", VarsPath, HealthPath}
+	if !reflect.DeepEqual(patterns, want) {
+		t.Errorf("routes: got %v, want %v", patterns, want)
+	}
+
+	names := InterceptorNames[any](loggingInterceptor(), tracingInterceptor{})
+	if want := []string{"app.loggingInterceptor", "app.tracingInterceptor"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("interceptor names: got %v, want %v", names, want)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	t.Setenv("APP_TEST_WINDOW", "30s")
+	t.Setenv("APP_TEST_DB_PASS", "hunter2")
+	t.Setenv("APP_TEST_SIGNING_KEY", "k")
+	t.Setenv("APP_TEST_URL", "nats://user:pw@nats:4222")
+	t.Setenv("APP_TEST_UNLISTED", "x")
+	t.Setenv(AdminTokenEnv, "admin")
+	a := newTestApp()
+	a.ConfigEnv("APP_TEST_WINDOW", "APP_TEST_DB_PASS", "APP_TEST_SIGNING_KEY", "APP_TEST_URL")
+	a.AddConfig("rule", func() map[string]string {
+		return map[string]string{"high_cpu.threshold": "90", "webhook_token": "abc"}
+	})
+
+	got := a.Config()
+	for key, want := range map[string]string{
+		"env.APP_TEST_WINDOW":      "30s",
+		"env.APP_TEST_DB_PASS":     Redacted,
+		"env.APP_TEST_SIGNING_KEY": Redacted,
+		"env.APP_TEST_URL":         "nats://" + Redacted + "@nats:4222",
+		"rule.high_cpu.threshold":  "90",
+		"rule.webhook_token":       Redacted,
+	} {
+		if got[key] != want {
+			t.Errorf("%s: got %q, want %q", key, got[key], want)
+		}
+	}
+	if _, ok := got["env.APP_TEST_UNLISTED"]; ok {
+		t.Error("environment variables not added with ConfigEnv must not be reported")
+	}
+
+	get := func(token string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, ConfigPath, nil)
+		if token != "" {
+			req.Header.Set(AdminTokenHeader, token)
+		}
+		a.Mux().ServeHTTP(rec, req)
+		return rec
+	}
+	for _, token := range []string{"", "wrong"} {
+		if rec := get(token); rec.Code != http.StatusForbidden {
+			t.Errorf("config endpoint with token %q: got %d", token, rec.Code)
+		}
+	}
+
+	var body struct {
+		Service string            `json:"service"`
+		Config  map[string]string `json:"config"`
+	}
+	if err := json.NewDecoder(get("admin").Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Service != "test" || body.Config["rule.high_cpu.threshold"] != "90" {
+		t.Errorf("config endpoint: got %+v", body)
+	}
+}
@@ -0,0 +1,136 @@
+package app
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+)
+
+// ConfigPath dumps the service's effective configuration. It answers only
+// requests carrying the admin token (see AdminTokenEnv).
+const ConfigPath = "/api/config"
+
+// AdminTokenEnv names the environment variable holding the token that
+// admin endpoints require, sent in AdminTokenHeader
+const (
+	AdminTokenEnv    = "ADMIN_TOKEN"
+	AdminTokenHeader = "X-Admin-Token"
+)
+
+// Redacted replaces the values of secret settings in the configuration
+const Redacted = "[redacted]"
+
+// secretName matches settings holding credentials
+var secretName = regexp.MustCompile(`(?i)(pass|secret|token|credential|private|(^|[._])(api_)?keys?($|[._]))`)
+
+// urlUserinfo matches the credentials of URLs in a value
+var urlUserinfo = regexp.MustCompile(`(\w+://)[^/@\s,;]+@`)
+
+// sharedEnv are the environment variables the shared packages read, reported
+// by every service along with those it adds with ConfigEnv
+var sharedEnv = []string{
+	"ADDR", "LOG_LEVEL", "LOG_FORMAT",
+	"NATS_URL", "ENVIRONMENT", "MAX_CLOCK_SKEW",
+	"DB_HOST", "DB_PORT", "DB_NAME", "DB_USER", "DB_SSLMODE",
+}
+
+// ConfigSource returns settings a service holds at runtime, such as rules
+// loaded from the database, keyed by setting
+type ConfigSource func() map[string]string
+
+type configSource struct {
+	name   string
+	source ConfigSource
+}
+
+// ConfigEnv adds environment variables the service reads to the
+// configuration. Only these and the shared settings are reported, so the
+// rest of the host's environment stays private.
+func (a *App) ConfigEnv(names ...string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.env = append(a.env, names...)
+}
+
+// AddConfig adds the settings source returns to the configuration, keyed
+// name.<setting>
+func (a *App) AddConfig(name string, source ConfigSource) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.configs = append(a.configs, configSource{name: name, source: source})
+}
+
+// Config returns the service's effective configuration: the environment
+// variables added with ConfigEnv and the shared ones that are set, as
+// env.<name>, command-line flags as flag.<name> and the sources added with
+// AddConfig. Values of settings named like credentials are Redacted, as
+// are the credentials of URLs.
+func (a *App) Config() map[string]string {
+	a.mu.Lock()
+	env := append(slices.Clone(sharedEnv), a.env...)
+	sources := append([]configSource(nil), a.configs...)
+	a.mu.Unlock()
+
+	config := make(map[string]string)
+	for _, name := range env {
+		if value, ok := os.LookupEnv(name); ok {
+			config["env."+name] = redact(name, value)
+		}
+	}
+	if flag.Parsed() {
+		flag.Visit(func(f *flag.Flag) {
+			config["flag."+f.Name] = redact(f.Name, f.Value.String())
+		})
+	}
+	for _, s := range sources {
+		for key, value := range s.source() {
+			config[s.name+"."+key] = redact(key, value)
+		}
+	}
+	return config
+}
+
+// redact hides value if name looks like it holds a credential, and the
+// credentials of URLs in value otherwise
+func redact(name, value string) string {
+	if value != "" && secretName.MatchString(name) {
+		return Redacted
+	}
+	return urlUserinfo.ReplaceAllString(value, "${1}"+Redacted+"@")
+}
+
+// serveConfig answers with the service name and effective configuration
+func (a *App) serveConfig(w http.ResponseWriter, r *http.Request) {
+	if !a.admin(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Service string            `json:"service"`
+		Config  map[string]string `json:"config"`
+	}{a.name, a.Config()})
+}
+
+// adminOnly passes requests carrying the admin token on to next
+func (a *App) adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.admin(w, r) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// admin reports whether r carries the admin token in X-Admin-Token, and
+// otherwise answers 403. Without an admin token nothing is admitted.
+func (a *App) admin(w http.ResponseWriter, r *http.Request) bool {
+	token := r.Header.Get(AdminTokenHeader)
+	if a.adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.adminToken)) != 1 {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,15 @@
+module github.com/microcloud/app
+
+go 1.23
+
+require (
+	connectrpc.com/connect v1.18.1
+	github.com/microcloud/logger v0.0.0
+	golang.org/x/net v0.34.0
+	golang.org/x/sync v0.10.0
+	google.golang.org/protobuf v1.36.5
+)
+
+require golang.org/x/text v0.21.0 // indirect
+
+replace github.com/microcloud/logger => ../logger
@@ -0,0 +1,128 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/sync/errgroup"
+)
+
+// Paths of the handlers every service registers, along with MetaPath and
+// ConfigPath
+const (
+	HealthPath = "/health"
+	VarsPath   = "/debug/vars"
+)
+
+// healthCheckTimeout bounds each health check
+const healthCheckTimeout = 2 * time.Second
+
+type healthCheck struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// Mux returns the mux the app serves by default, with the shared handlers
+// registered on it
+func (a *App) Mux() *http.ServeMux {
+	if a.mux == nil {
+		a.mux = http.NewServeMux()
+		a.RegisterHandlers(a.mux)
+	}
+	return a.mux
+}
+
+// RegisterHandlers registers /health, /debug/vars, /api/meta and /api/config
+// on mux, for binaries that serve a mux of their own
+func (a *App) RegisterHandlers(mux *http.ServeMux) {
+	a.Handle(mux, HealthPath, http.HandlerFunc(a.serveHealth))
+	vars := expvar.Handler()
+	if a.adminVars {
+		vars = a.adminOnly(vars)
+	}
+	a.Handle(mux, VarsPath, vars)
+	a.Handle(mux, MetaPath, http.HandlerFunc(a.serveMeta))
+	a.Handle(mux, ConfigPath, http.HandlerFunc(a.serveConfig))
+}
+
+// HealthCheck adds a check to /health, which fails while any check does
+func (a *App) HealthCheck(name string, fn func(ctx context.Context) error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.checks = append(a.checks, healthCheck{name: name, fn: fn})
+}
+
+// Serve serves h over HTTP/1.1 and cleartext HTTP/2 on addr once every
+// component has started, draining it on shutdown. A nil h serves Mux.
+func (a *App) Serve(addr string, h http.Handler) {
+	a.addr = addr
+	a.handler = h
+}
+
+// serve adds the HTTP server to g
+func (a *App) serve(ctx context.Context, g *errgroup.Group) {
+	h := a.handler
+	if h == nil {
+		h = a.Mux()
+	}
+	srv := &http.Server{
+		Addr:    a.addr,
+		Handler: h2c.NewHandler(h, &http2.Server{}),
+	}
+
+	g.Go(func() error {
+		a.log.Info("HTTP server started", "addr", a.addr)
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	})
+	g.Go(func() error {
+		<-ctx.Done()
+		a.setStopping()
+		// Streams never finish by themselves, so drain only for the
+		// shutdown timeout before closing what is left
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), a.shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			srv.Close()
+		}
+		return ctx.Err()
+	})
+}
+
+// serveHealth answers 200 "ok" while every check passes, and 503 listing
+// the failures otherwise or once the app is stopping
+func (a *App) serveHealth(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	checks := append([]healthCheck(nil), a.checks...)
+	stopping := a.stopping
+	a.mu.Unlock()
+
+	var failures []string
+	if stopping {
+		failures = append(failures, "stopping")
+	}
+	for _, c := range checks {
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		err := c.fn(ctx)
+		cancel()
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", c.name, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		http.Error(w, strings.Join(failures, "\n"), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
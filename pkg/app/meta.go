@@ -0,0 +1,163 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// MetaPath lists the service's build, routes and RPCs
+const MetaPath = "/api/meta"
+
+// Build info, set at link time:
+//
+//	go build -ldflags "-X github.com/microcloud/app.Version=v1.2.0 \
+//		-X github.com/microcloud/app.Commit=$(git rev-parse HEAD) \
+//		-X github.com/microcloud/app.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Commit and BuildDate fall back to the VCS stamp go build records.
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// BuildInfo identifies the running binary
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// Build returns the build info of the running binary
+func Build() BuildInfo {
+	info := BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = s.Value
+			}
+		}
+	}
+	return info
+}
+
+// Route is an endpoint listed on the meta endpoint. Connect services list
+// their procedures and the interceptors their handler was built with.
+type Route struct {
+	Pattern      string   `json:"pattern"`
+	Kind         string   `json:"kind"` // "connect" or "http"
+	Procedures   []string `json:"procedures,omitempty"`
+	Interceptors []string `json:"interceptors,omitempty"`
+}
+
+// meta is the body of the meta endpoint
+type meta struct {
+	Service string    `json:"service"`
+	Build   BuildInfo `json:"build"`
+	Routes  []Route   `json:"routes"`
+}
+
+// Handle registers h on mux at pattern and lists it on the meta endpoint.
+// Patterns naming a registered proto service, as Connect handlers' do, are
+// listed with its procedures; interceptors names the interceptors h was
+// built with, see InterceptorNames.
+func (a *App) Handle(mux *http.ServeMux, pattern string, h http.Handler, interceptors ...string) {
+	mux.Handle(pattern, h)
+	a.addRoute(pattern, interceptors)
+}
+
+func (a *App) addRoute(pattern string, interceptors []string) {
+	r := Route{Pattern: pattern, Kind: "http"}
+	if procs := procedures(pattern); procs != nil {
+		r.Kind = "connect"
+		r.Procedures = procs
+		r.Interceptors = interceptors
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.routes = append(a.routes, r)
+}
+
+// Routes returns the routes registered with Handle, by pattern
+func (a *App) Routes() []Route {
+	a.mu.Lock()
+	routes := slices.Clone(a.routes)
+	a.mu.Unlock()
+
+	slices.SortFunc(routes, func(x, y Route) int { return strings.Compare(x.Pattern, y.Pattern) })
+	return slices.CompactFunc(routes, func(x, y Route) bool { return x.Pattern == y.Pattern })
+}
+
+// procedures returns the procedures of the proto service a Connect handler
+// pattern such as /ops.v1.ActionService/ names, or nil if it names none
+func procedures(pattern string) []string {
+	name := strings.Trim(pattern, "/")
+	if name == "" || strings.Contains(name, "/") {
+		return nil
+	}
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil
+	}
+	svc, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil
+	}
+	methods := svc.Methods()
+	procs := make([]string, methods.Len())
+	for i := range procs {
+		procs[i] = "/" + name + "/" + string(methods.Get(i).Name())
+	}
+	return procs
+}
+
+// closureSuffix matches the names the compiler gives closures
+var closureSuffix = regexp.MustCompile(`(\.func\d+)+(\.\d+)*$`)
+
+// InterceptorNames names interceptors for Handle. Interceptors made from
+// closures are named for the function that returned them, such as
+// server.ETagInterceptor, and others for their type.
+func InterceptorNames[T any](interceptors ...T) []string {
+	names := make([]string, len(interceptors))
+	for i, ic := range interceptors {
+		v := reflect.ValueOf(ic)
+		if v.Kind() != reflect.Func {
+			names[i] = fmt.Sprintf("%T", ic)
+			continue
+		}
+		name := runtime.FuncForPC(v.Pointer()).Name()
+		name = name[strings.LastIndex(name, "/")+1:]
+		names[i] = closureSuffix.ReplaceAllString(name, "")
+	}
+	return names
+}
+
+// serveMeta answers with the service name, build info and routes
+func (a *App) serveMeta(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(meta{
+		Service: a.name,
+		Build:   Build(),
+		Routes:  a.Routes(),
+	})
+}
@@ -0,0 +1,24 @@
+package app
+
+import (
+	"context"
+	"errors"
+
+	"connectrpc.com/connect"
+)
+
+// DemoNotice is returned for every mutating call in read-only demo mode
+const DemoNotice = "this is a read-only demo instance; changes are disabled"
+
+// ReadOnlyInterceptor rejects RPCs not marked NO_SIDE_EFFECTS, for
+// binaries running as a read-only demo
+func ReadOnlyInterceptor() connect.UnaryInterceptorFunc {
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			if req.Spec().IdempotencyLevel != connect.IdempotencyNoSideEffects {
+				return nil, connect.NewError(connect.CodePermissionDenied, errors.New(DemoNotice))
+			}
+			return next(ctx, req)
+		}
+	}
+}
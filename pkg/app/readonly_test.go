@@ -0,0 +1,41 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestReadOnlyInterceptor(t *testing.T) {
+	mux := http.NewServeMux()
+	for _, path := range []string{"/test.v1.Test/Get", "/test.v1.Test/Set"} {
+		level := connect.IdempotencyUnknown
+		if path == "/test.v1.Test/Get" {
+			level = connect.IdempotencyNoSideEffects
+		}
+		mux.Handle(path, connect.NewUnaryHandler(path,
+			func(context.Context, *connect.Request[emptypb.Empty]) (*connect.Response[emptypb.Empty], error) {
+				return connect.NewResponse(&emptypb.Empty{}), nil
+			},
+			connect.WithIdempotency(level), connect.WithInterceptors(ReadOnlyInterceptor()),
+		))
+	}
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	call := func(path string) error {
+		c := connect.NewClient[emptypb.Empty, emptypb.Empty](srv.Client(), srv.URL+path)
+		_, err := c.CallUnary(context.Background(), connect.NewRequest(&emptypb.Empty{}))
+		return err
+	}
+	if err := call("/test.v1.Test/Get"); err != nil {
+		t.Errorf("read rejected: %v", err)
+	}
+	if err := call("/test.v1.Test/Set"); connect.CodeOf(err) != connect.CodePermissionDenied {
+		t.Errorf("write: got %v, want permission denied", err)
+	}
+}
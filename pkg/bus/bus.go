@@ -4,20 +4,50 @@ package bus
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/microcloud/environment"
 )
 
 // Subjects for the event bus
 const (
 	SubjectSimMetrics   = "sim.metrics"
 	SubjectSimEvents    = "sim.events"
+	SubjectSimControl   = "sim.control"
 	SubjectOpsIncidents = "ops.incidents"
 	SubjectOpsActions   = "ops.actions"
 	SubjectOpsCommands  = "ops.commands"
+	SubjectOpsResults   = "ops.results"
+)
+
+// Core NATS subjects. Messages are not stored and reach every subscriber,
+// so each replica of a service sees them.
+const (
+	SubjectRulesUpdated = "rules.updated"
+	SubjectHeartbeat    = "services.heartbeat"
+	SubjectSimActivity  = "sim.activity"
+
+	// SubjectConsumersMigrated carries ConsumerMigration notices;
+	// subscribers that miss one bind the newest version when they start
+	SubjectConsumersMigrated = "consumers.migrated"
+)
+
+// Key-value buckets shared between services
+const (
+	BucketStormStatus = "agent-storm-status" // ops.v1.StormStatus under KeyStormStatus
+	KeyStormStatus    = "decider"
+
+	BucketDetectorState = "signal-detector-state" // ops.v1.DetectorState under KeyDetectorState
+	KeyDetectorState    = "detector"
+
+	BucketPolicyBundle = "orchestrator-policies" // ops.v1.PolicyBundle under KeyPolicyBundle
+	KeyPolicyBundle    = "actions"
 )
 
 // Config holds NATS connection configuration
@@ -27,6 +57,20 @@ type Config struct {
 	ReconnectWait   time.Duration
 	StreamName      string
 	RetentionPolicy string
+
+	// SubjectMaxBytes caps the message size per subject. Larger messages are
+	// chunked. Subjects without an entry use the server's max payload.
+	SubjectMaxBytes map[string]int
+
+	// Environment isolates a named sandbox (dev, staging, prod-sim) on a
+	// shared server: it prefixes every subject and bucket and suffixes the
+	// stream name. Empty is the unprefixed default.
+	Environment string
+
+	// MaxClockSkew is how far the clocks of this and other services may be
+	// from the NATS server's before the bus warns. Zero uses
+	// DefaultMaxClockSkew.
+	MaxClockSkew time.Duration
 }
 
 // DefaultConfig returns sensible defaults
@@ -40,6 +84,23 @@ func DefaultConfig() Config {
 	}
 }
 
+// ConfigFromEnv returns DefaultConfig with the URL taken from NATS_URL, the
+// environment from ENVIRONMENT and the clock skew limit from MAX_CLOCK_SKEW
+// if set
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+	if url := os.Getenv("NATS_URL"); url != "" {
+		cfg.URL = url
+	}
+	cfg.Environment = os.Getenv("ENVIRONMENT")
+	if v := os.Getenv("MAX_CLOCK_SKEW"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			cfg.MaxClockSkew = d
+		}
+	}
+	return cfg
+}
+
 // Bus wraps NATS JetStream with typed publishing and subscribing
 type Bus struct {
 	nc     *nats.Conn
@@ -51,6 +112,18 @@ type Bus struct {
 
 	onDisconnect func(error)
 	onReconnect  func()
+
+	log         *slog.Logger
+	identity    Identity
+	config      func() map[string]string // reported in heartbeats
+	clock       *Clock
+	peersMu     sync.Mutex
+	warnedPeers map[string]bool // service@version already warned about
+	skewedPeers map[string]bool // services whose clocks are past MaxClockSkew
+
+	consumersMu sync.Mutex
+	consumers   map[*managedConsumer]bool // followed across migrations
+	migrations  *nats.Subscription        // consumers.migrated, once any consumer is managed
 }
 
 // Option configures the Bus
@@ -70,9 +143,26 @@ func WithReconnectHandler(fn func()) Option {
 	}
 }
 
+// WithLogger logs disconnects and reconnects to log
+func WithLogger(log *slog.Logger) Option {
+	return func(b *Bus) {
+		b.log = log
+		b.onDisconnect = func(err error) {
+			log.Warn("NATS disconnected", "error", err)
+		}
+		b.onReconnect = func() {
+			log.Info("NATS reconnected")
+		}
+	}
+}
+
 // New creates a new Bus with automatic reconnection handling
 func New(ctx context.Context, cfg Config, opts ...Option) (*Bus, error) {
-	b := &Bus{cfg: cfg}
+	if err := environment.Check(cfg.Environment); err != nil {
+		return nil, err
+	}
+	cfg.StreamName = environmentStream(cfg.StreamName, cfg.Environment)
+	b := &Bus{cfg: cfg, clock: NewClock(cfg.MaxClockSkew)}
 	for _, opt := range opts {
 		opt(b)
 	}
@@ -105,7 +195,7 @@ func New(ctx context.Context, cfg Config, opts ...Option) (*Bus, error) {
 
 	streamCfg := jetstream.StreamConfig{
 		Name:      cfg.StreamName,
-		Subjects:  []string{"sim.>", "ops.>"},
+		Subjects:  []string{b.subject("sim.>"), b.subject("ops.>")},
 		Retention: jetstream.LimitsPolicy,
 		MaxAge:    24 * time.Hour,
 		Storage:   jetstream.FileStorage,
@@ -136,6 +226,14 @@ func (b *Bus) Close() error {
 	return nil
 }
 
+// Check fails while the connection to NATS is down, for health checks
+func (b *Bus) Check(ctx context.Context) error {
+	if !b.IsConnected() {
+		return fmt.Errorf("nats %s", b.nc.Status())
+	}
+	return nil
+}
+
 // IsConnected returns true if connected to NATS
 func (b *Bus) IsConnected() bool {
 	return b.nc.IsConnected()
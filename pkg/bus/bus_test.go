@@ -1,7 +1,15 @@
 package bus
 
 import (
+	"bytes"
+	"errors"
 	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+	simv1 "github.com/microcloud/gen/go/sim/v1"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -25,9 +33,11 @@ func TestSubjectConstants(t *testing.T) {
 	}{
 		{"SimMetrics", SubjectSimMetrics, "sim.metrics"},
 		{"SimEvents", SubjectSimEvents, "sim.events"},
+		{"SimControl", SubjectSimControl, "sim.control"},
 		{"OpsIncidents", SubjectOpsIncidents, "ops.incidents"},
 		{"OpsActions", SubjectOpsActions, "ops.actions"},
 		{"OpsCommands", SubjectOpsCommands, "ops.commands"},
+		{"OpsResults", SubjectOpsResults, "ops.results"},
 	}
 
 	for _, tt := range tests {
@@ -36,3 +46,278 @@ func TestSubjectConstants(t *testing.T) {
 		}
 	}
 }
+
+func TestSplitChunks(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 25)
+
+	chunks := splitChunks(data, 10)
+	if len(chunks) != 3 {
+		t.Fatalf("got %d chunks, want 3", len(chunks))
+	}
+	if len(chunks[0]) != 10 || len(chunks[1]) != 10 || len(chunks[2]) != 5 {
+		t.Errorf("unexpected chunk sizes: %d, %d, %d", len(chunks[0]), len(chunks[1]), len(chunks[2]))
+	}
+	if got := splitChunks(data[:20], 10); len(got) != 2 {
+		t.Errorf("exact multiple: got %d chunks, want 2", len(got))
+	}
+}
+
+func TestAssemblerReassemblesOutOfOrder(t *testing.T) {
+	a := newAssembler()
+	chunks := splitChunks([]byte("hello, chunked world"), 6)
+
+	order := []int{2, 0, 3, 1}
+	for i, idx := range order {
+		full, complete, err := a.add(chunkHeaders("msg-1", idx, len(chunks)), chunks[idx], nil)
+		if err != nil {
+			t.Fatalf("add chunk %d: %v", idx, err)
+		}
+		if last := i == len(order)-1; complete != last {
+			t.Fatalf("chunk %d: complete = %v, want %v", idx, complete, last)
+		}
+		if complete && string(full) != "hello, chunked world" {
+			t.Errorf("reassembled %q", full)
+		}
+	}
+
+	// A redelivered final chunk reassembles again until take is called
+	if _, complete, _ := a.add(chunkHeaders("msg-1", 1, len(chunks)), chunks[1], nil); !complete {
+		t.Error("redelivered chunk should complete the buffered message")
+	}
+	a.take("msg-1")
+	if _, complete, _ := a.add(chunkHeaders("msg-1", 1, len(chunks)), chunks[1], nil); complete {
+		t.Error("message should be forgotten after take")
+	}
+}
+
+func TestAssemblerRejectsBadHeaders(t *testing.T) {
+	a := newAssembler()
+	if _, _, err := a.add(chunkHeaders("m", 3, 3), []byte("x"), nil); err == nil {
+		t.Error("expected error for index out of range")
+	}
+	h := chunkHeaders("m", 0, 2)
+	h.Set(HeaderChunkCount, "two")
+	if _, _, err := a.add(h, []byte("x"), nil); err == nil {
+		t.Error("expected error for non-numeric count")
+	}
+}
+
+func TestAssemblerExpiresPartials(t *testing.T) {
+	a := newAssembler()
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	stale := &fakeMsg{}
+	a.add(chunkHeaders("stale", 0, 2), []byte("a"), stale)
+	now = now.Add(chunkTimeout + time.Second)
+	a.add(chunkHeaders("fresh", 0, 2), []byte("b"), &fakeMsg{})
+
+	if _, ok := a.partials["stale"]; ok {
+		t.Error("stale partial should have been dropped")
+	}
+	if !stale.termed {
+		t.Error("stale chunk should have been terminated")
+	}
+	if _, ok := a.partials["fresh"]; !ok {
+		t.Error("fresh partial should be kept")
+	}
+}
+
+func TestAssemblerRestartMidMessage(t *testing.T) {
+	chunks := splitChunks([]byte("hello, chunked world"), 6)
+
+	// The first process buffers half the message and stops
+	a := newAssembler()
+	var first []*fakeMsg
+	for idx := range chunks[:2] {
+		m := &fakeMsg{}
+		first = append(first, m)
+		if _, complete, err := a.add(chunkHeaders("msg-1", idx, len(chunks)), chunks[idx], m); err != nil || complete {
+			t.Fatalf("chunk %d: complete = %v, err = %v", idx, complete, err)
+		}
+	}
+	for idx, m := range first {
+		if m.acked || m.naked || m.termed {
+			t.Fatalf("chunk %d settled before its message was handled", idx)
+		}
+	}
+
+	// Unacked, every chunk is redelivered to the next one
+	a = newAssembler()
+	var second []*fakeMsg
+	var full []byte
+	for idx := range chunks {
+		m := &fakeMsg{}
+		second = append(second, m)
+		var complete bool
+		var err error
+		full, complete, err = a.add(chunkHeaders("msg-1", idx, len(chunks)), chunks[idx], m)
+		if err != nil {
+			t.Fatalf("add chunk %d: %v", idx, err)
+		}
+		if last := idx == len(chunks)-1; complete != last {
+			t.Fatalf("chunk %d: complete = %v, want %v", idx, complete, last)
+		}
+	}
+	if string(full) != "hello, chunked world" {
+		t.Errorf("reassembled %q", full)
+	}
+
+	delivered := a.take("msg-1")
+	if len(delivered) != len(chunks) {
+		t.Fatalf("take returned %d deliveries, want %d", len(delivered), len(chunks))
+	}
+	for _, m := range delivered {
+		m.Ack()
+	}
+	for idx, m := range second {
+		if !m.acked {
+			t.Errorf("chunk %d not acked with its message", idx)
+		}
+	}
+}
+
+// fakeMsg records how a chunk delivery was settled
+type fakeMsg struct {
+	jetstream.Msg
+	acked, naked, termed bool
+}
+
+func (m *fakeMsg) Ack() error  { m.acked = true; return nil }
+func (m *fakeMsg) Nak() error  { m.naked = true; return nil }
+func (m *fakeMsg) Term() error { m.termed = true; return nil }
+
+func TestSnapshotMergerAppliesDeltas(t *testing.T) {
+	node := func(id string, cpu float64) *simv1.Node {
+		return &simv1.Node{Id: &commonv1.UUID{Value: id}, CpuUsagePercent: cpu}
+	}
+	m := NewSnapshotMerger()
+
+	if _, err := m.Apply(&simv1.MetricSnapshot{Sequence: 1, Delta: true}); !errors.Is(err, ErrSnapshotGap) {
+		t.Fatalf("delta before keyframe: got %v, want ErrSnapshotGap", err)
+	}
+
+	m.Apply(&simv1.MetricSnapshot{Sequence: 2, Nodes: []*simv1.Node{node("a", 10), node("b", 20)}})
+	full, err := m.Apply(&simv1.MetricSnapshot{
+		Sequence:   3,
+		Delta:      true,
+		Nodes:      []*simv1.Node{node("a", 50)},
+		RemovedIds: []string{"b"},
+	})
+	if err != nil {
+		t.Fatalf("apply delta: %v", err)
+	}
+	if len(full.Nodes) != 1 || full.Nodes[0].CpuUsagePercent != 50 {
+		t.Errorf("unexpected merged nodes: %v", full.Nodes)
+	}
+
+	if _, err := m.Apply(&simv1.MetricSnapshot{Sequence: 5, Delta: true}); !errors.Is(err, ErrSnapshotGap) {
+		t.Errorf("skipped sequence: got %v, want ErrSnapshotGap", err)
+	}
+	if _, err := m.Apply(&simv1.MetricSnapshot{Sequence: 6, Delta: true}); !errors.Is(err, ErrSnapshotGap) {
+		t.Errorf("delta after gap should wait for a keyframe, got %v", err)
+	}
+	if _, err := m.Apply(&simv1.MetricSnapshot{Sequence: 7}); err != nil {
+		t.Errorf("keyframe after gap: %v", err)
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"v1.2.0", "v1.9.3", true},
+		{"v1.2.0", "v2.0.0", false},
+		{"0.3.1", "v0.3.0-rc.1", true},
+		{"v0.3.1", "v0.4.0", false},
+		{"dev", "v2.0.0", true},
+	}
+	for _, tt := range tests {
+		if got, reason := Compatible(tt.a, tt.b); got != tt.want {
+			t.Errorf("Compatible(%q, %q) = %v (%s), want %v", tt.a, tt.b, got, reason, tt.want)
+		}
+	}
+}
+
+func TestEnvironmentNames(t *testing.T) {
+	b := &Bus{cfg: Config{Environment: "prod-sim"}}
+	if got := b.subject(SubjectOpsIncidents); got != "prod-sim.ops.incidents" {
+		t.Errorf("subject = %q", got)
+	}
+	if got := b.bucket(BucketStormStatus); got != "prod-sim-agent-storm-status" {
+		t.Errorf("bucket = %q", got)
+	}
+	if got := environmentStream("MICROCLOUD", "prod-sim"); got != "MICROCLOUD_PROD_SIM" {
+		t.Errorf("stream = %q", got)
+	}
+
+	def := &Bus{}
+	if def.subject(SubjectOpsIncidents) != SubjectOpsIncidents || def.bucket(BucketStormStatus) != BucketStormStatus {
+		t.Error("the default environment must keep unprefixed names")
+	}
+}
+
+func TestClockOrdersAfterReceived(t *testing.T) {
+	wall := time.UnixMilli(1_000)
+	c := &Clock{wall: func() time.Time { return wall }, maxSkew: 10 * time.Second}
+
+	first := c.Now()
+	second := c.Now()
+	if !first.Before(second) || second.WallMs != 1_000 {
+		t.Fatalf("readings within a millisecond must count up: %v, %v", first, second)
+	}
+
+	// A sender whose clock runs ahead pulls the clock forward
+	ahead := Timestamp{WallMs: 5_000, Logical: 2}
+	received := c.Update(ahead)
+	if !ahead.Before(received) {
+		t.Errorf("receipt %v must order after %v", received, ahead)
+	}
+	if next := c.Now(); !received.Before(next) {
+		t.Errorf("local reading %v went backwards from %v", next, received)
+	}
+
+	// A reading past the skew limit only advances the clock to the limit
+	far := Timestamp{WallMs: 3_600_000}
+	if clamped := c.Update(far); clamped.WallMs != 11_000 {
+		t.Errorf("receipt of %v = %v, want clamped to 11000", far, clamped)
+	}
+
+	parsed, ok := parseTimestamp(received.String())
+	if !ok || parsed != received {
+		t.Errorf("round trip of %v gave %v, %v", received, parsed, ok)
+	}
+	for _, bad := range []string{"", "12", "x.1", "12.y"} {
+		if _, ok := parseTimestamp(bad); ok {
+			t.Errorf("parseTimestamp(%q) should fail", bad)
+		}
+	}
+}
+
+func TestConsumerVersions(t *testing.T) {
+	for v, want := range map[int]string{1: "signal-metrics", 2: "signal-metrics-v2", 10: "signal-metrics-v10"} {
+		name := consumerName("signal-metrics", v)
+		if name != want {
+			t.Errorf("consumerName(%d) = %q, want %q", v, name, want)
+		}
+		if got, ok := consumerVersion("signal-metrics", name); !ok || got != v {
+			t.Errorf("consumerVersion(%q) = %d, %v", name, got, ok)
+		}
+	}
+	for _, other := range []string{"signal-metrics-v1", "signal-metrics-vx", "signal-metrics-backup", "signal"} {
+		if _, ok := consumerVersion("signal-metrics", other); ok {
+			t.Errorf("%q is not a version of signal-metrics", other)
+		}
+	}
+
+	have := jetstream.ConsumerConfig{FilterSubject: "sim.metrics", AckPolicy: jetstream.AckExplicitPolicy, DeliverPolicy: jetstream.DeliverByStartSequencePolicy}
+	want := jetstream.ConsumerConfig{FilterSubject: "sim.metrics", AckPolicy: jetstream.AckExplicitPolicy, DeliverPolicy: jetstream.DeliverNewPolicy}
+	if consumerChanged(have, want) {
+		t.Error("a migrated start position is not a config change")
+	}
+	want.FilterSubjects, want.FilterSubject = []string{"sim.metrics", "sim.events"}, ""
+	if !consumerChanged(have, want) {
+		t.Error("new filter subjects need a migration")
+	}
+}
@@ -0,0 +1,178 @@
+package bus
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Chunking headers. A message larger than its subject's size limit is
+// published as numbered chunks sharing an ID, and the subscriber hands the
+// reassembled payload to the handler once every chunk has arrived.
+const (
+	HeaderChunkID    = "Chunk-Id"
+	HeaderChunkIndex = "Chunk-Index"
+	HeaderChunkCount = "Chunk-Count"
+)
+
+const (
+	// headerReserve keeps chunk bodies far enough under the server's max
+	// payload to leave room for headers
+	headerReserve = 1024
+
+	// chunkTimeout drops partial messages whose remaining chunks never arrive
+	chunkTimeout = time.Minute
+)
+
+// maxPayload returns the largest body published as a single message on subject
+func (b *Bus) maxPayload(subject string) int {
+	limit := int(b.nc.MaxPayload()) - headerReserve
+	if n, ok := b.cfg.SubjectMaxBytes[subject]; ok && n > 0 && n < limit {
+		limit = n
+	}
+	return limit
+}
+
+// splitChunks cuts data into pieces of at most size bytes
+func splitChunks(data []byte, size int) [][]byte {
+	chunks := make([][]byte, 0, (len(data)+size-1)/size)
+	for len(data) > size {
+		chunks = append(chunks, data[:size])
+		data = data[size:]
+	}
+	return append(chunks, data)
+}
+
+// chunkHeaders returns the headers for chunk index of count
+func chunkHeaders(id string, index, count int) nats.Header {
+	h := nats.Header{}
+	h.Set(HeaderChunkID, id)
+	h.Set(HeaderChunkIndex, strconv.Itoa(index))
+	h.Set(HeaderChunkCount, strconv.Itoa(count))
+	return h
+}
+
+func newID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// assembler collects chunks per message ID until the message is complete
+type assembler struct {
+	mu       sync.Mutex
+	partials map[string]*partial
+	now      func() time.Time
+}
+
+type partial struct {
+	chunks   [][]byte
+	msgs     []jetstream.Msg // latest delivery of each chunk, nil when not acked
+	received int
+	started  time.Time
+}
+
+func newAssembler() *assembler {
+	return &assembler{partials: make(map[string]*partial), now: time.Now}
+}
+
+// add stores the chunk described by h, delivered as msg, and returns the
+// full payload once every chunk of its message has arrived. The message
+// stays buffered until take is called, so a redelivered final chunk
+// reassembles it again. Chunks are left unacked while buffered, so a
+// restart mid-message gets them all redelivered; partials that time out
+// are terminated. msg may be nil for consumers that do not ack.
+func (a *assembler) add(h nats.Header, data []byte, msg jetstream.Msg) ([]byte, bool, error) {
+	id := h.Get(HeaderChunkID)
+	index, err := strconv.Atoi(h.Get(HeaderChunkIndex))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid %s header: %w", HeaderChunkIndex, err)
+	}
+	count, err := strconv.Atoi(h.Get(HeaderChunkCount))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid %s header: %w", HeaderChunkCount, err)
+	}
+	if count < 1 || index < 0 || index >= count {
+		return nil, false, fmt.Errorf("chunk %d of %d out of range", index, count)
+	}
+
+	full, complete, stale, err := a.store(id, index, count, data, msg)
+	// Their remaining chunks never arrived, so redelivery cannot complete them
+	for _, m := range stale {
+		m.Term()
+	}
+	return full, complete, err
+}
+
+// store buffers a chunk, returning the full payload once complete and the
+// deliveries of partials that timed out
+func (a *assembler) store(id string, index, count int, data []byte, msg jetstream.Msg) ([]byte, bool, []jetstream.Msg, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	var stale []jetstream.Msg
+	for key, p := range a.partials {
+		if now.Sub(p.started) > chunkTimeout {
+			stale = append(stale, p.delivered()...)
+			delete(a.partials, key)
+		}
+	}
+
+	p, ok := a.partials[id]
+	if !ok {
+		p = &partial{chunks: make([][]byte, count), msgs: make([]jetstream.Msg, count), started: now}
+		a.partials[id] = p
+	}
+	if len(p.chunks) != count {
+		return nil, false, stale, fmt.Errorf("chunk count changed from %d to %d", len(p.chunks), count)
+	}
+	if p.chunks[index] == nil {
+		p.chunks[index] = append([]byte(nil), data...)
+		p.received++
+	}
+	p.msgs[index] = msg
+	if p.received < count {
+		return nil, false, stale, nil
+	}
+
+	size := 0
+	for _, c := range p.chunks {
+		size += len(c)
+	}
+	full := make([]byte, 0, size)
+	for _, c := range p.chunks {
+		full = append(full, c...)
+	}
+	return full, true, stale, nil
+}
+
+// take forgets a reassembled message and returns the deliveries of its
+// chunks, for the caller to ack or nak together once it has been handled
+func (a *assembler) take(id string) []jetstream.Msg {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p, ok := a.partials[id]
+	if !ok {
+		return nil
+	}
+	delete(a.partials, id)
+	return p.delivered()
+}
+
+// delivered returns the chunk deliveries that need settling
+func (p *partial) delivered() []jetstream.Msg {
+	msgs := make([]jetstream.Msg, 0, len(p.msgs))
+	for _, m := range p.msgs {
+		if m != nil {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
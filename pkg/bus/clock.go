@@ -0,0 +1,262 @@
+package bus
+
+import (
+	"encoding/json"
+	"expvar"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// HeaderHLC carries the publisher's hybrid logical clock reading
+const HeaderHLC = "Bus-Hlc"
+
+// DefaultMaxClockSkew is how far a clock may be from the NATS server's
+// before the bus warns about it
+const DefaultMaxClockSkew = 500 * time.Millisecond
+
+// Timestamp is a hybrid logical clock reading: wall time in milliseconds,
+// and a counter ordering readings within the same millisecond
+type Timestamp struct {
+	WallMs  int64
+	Logical uint32
+}
+
+// Time returns the wall time of the reading
+func (t Timestamp) Time() time.Time {
+	return time.UnixMilli(t.WallMs)
+}
+
+// Before reports whether t orders before u
+func (t Timestamp) Before(u Timestamp) bool {
+	return t.WallMs < u.WallMs || t.WallMs == u.WallMs && t.Logical < u.Logical
+}
+
+// String formats t as <wall ms>.<logical>, as HeaderHLC carries it
+func (t Timestamp) String() string {
+	return strconv.FormatInt(t.WallMs, 10) + "." + strconv.FormatUint(uint64(t.Logical), 10)
+}
+
+// parseTimestamp parses a HeaderHLC value
+func parseTimestamp(s string) (Timestamp, bool) {
+	wall, logical, ok := strings.Cut(s, ".")
+	if !ok {
+		return Timestamp{}, false
+	}
+	w, err := strconv.ParseInt(wall, 10, 64)
+	if err != nil {
+		return Timestamp{}, false
+	}
+	l, err := strconv.ParseUint(logical, 10, 32)
+	if err != nil {
+		return Timestamp{}, false
+	}
+	return Timestamp{WallMs: w, Logical: uint32(l)}, true
+}
+
+// Clock is a hybrid logical clock. Its readings follow the wall clock but
+// never go backwards and always order after every reading received, so
+// events stamped with it keep causal order across hosts whose clocks
+// disagree. A received reading further ahead of the local clock than
+// maxSkew is clamped to it, so one bad clock cannot drag every service's
+// stamps into the future. Readings have no monotonic component; measure
+// durations with time.Now.
+type Clock struct {
+	mu      sync.Mutex
+	last    Timestamp
+	wall    func() time.Time
+	maxSkew time.Duration
+}
+
+// NewClock creates a clock following the local wall clock, accepting
+// readings up to maxSkew ahead of it (DefaultMaxClockSkew if not positive)
+func NewClock(maxSkew time.Duration) *Clock {
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxClockSkew
+	}
+	return &Clock{wall: time.Now, maxSkew: maxSkew}
+}
+
+// Now returns a reading for a local event, such as publishing a message
+func (c *Clock) Now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if wall := c.wall().UnixMilli(); wall > c.last.WallMs {
+		c.last = Timestamp{WallMs: wall}
+	} else {
+		c.last.Logical++
+	}
+	return c.last
+}
+
+// Update advances the clock past a reading received from another host and
+// returns the reading for the receipt. A reading beyond the skew limit only
+// advances the clock to the limit.
+func (c *Clock) Update(remote Timestamp) Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wall := c.wall().UnixMilli()
+	if limit := wall + c.maxSkew.Milliseconds(); remote.WallMs > limit {
+		remote = Timestamp{WallMs: limit}
+	}
+	switch {
+	case wall > c.last.WallMs && wall > remote.WallMs:
+		c.last = Timestamp{WallMs: wall}
+	case remote.WallMs > c.last.WallMs:
+		c.last = Timestamp{WallMs: remote.WallMs, Logical: remote.Logical + 1}
+	case remote.WallMs == c.last.WallMs:
+		c.last.Logical = max(c.last.Logical, remote.Logical) + 1
+	default:
+		c.last.Logical++
+	}
+	return c.last
+}
+
+// Clock returns the bus's hybrid logical clock, for stamping events that
+// must order after the messages the service has received
+func (b *Bus) Clock() *Clock {
+	return b.clock
+}
+
+// Clock returns the hybrid logical clock of the publisher's bus
+func (p *Publisher) Clock() *Clock {
+	return p.bus.clock
+}
+
+// Pipeline latency and clock skew, published at /debug/vars.
+// bus_pipeline_latency is keyed by subject; bus_clock_skew_ms holds the
+// last skew measured against the NATS server, by service ("self" for the
+// local clock).
+var (
+	pipelineLatency = expvar.NewMap("bus_pipeline_latency")
+	clockSkew       = expvar.NewMap("bus_clock_skew_ms")
+	latencyMu       sync.Mutex // serializes creating pipelineLatency entries
+)
+
+// latencyStats summarizes the delivery latency of one subject. Latency is
+// measured on the receiver's hybrid clock, so a sender whose clock runs
+// ahead yields 0 instead of a negative latency; such deliveries are counted
+// as skewed.
+type latencyStats struct {
+	mu     sync.Mutex
+	count  int64
+	skewed int64
+	sumMs  float64
+	maxMs  float64
+}
+
+func (l *latencyStats) record(latency time.Duration, skewed bool) {
+	ms := float64(latency) / float64(time.Millisecond)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.count++
+	l.sumMs += ms
+	l.maxMs = max(l.maxMs, ms)
+	if skewed {
+		l.skewed++
+	}
+}
+
+// String renders the stats as JSON for expvar
+func (l *latencyStats) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var mean float64
+	if l.count > 0 {
+		mean = l.sumMs / float64(l.count)
+	}
+	data, _ := json.Marshal(map[string]any{
+		"count":   l.count,
+		"mean_ms": mean,
+		"max_ms":  l.maxMs,
+		"skewed":  l.skewed,
+	})
+	return string(data)
+}
+
+func latencyFor(subject string) *latencyStats {
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+	if l, ok := pipelineLatency.Get(subject).(*latencyStats); ok {
+		return l
+	}
+	l := &latencyStats{}
+	pipelineLatency.Set(subject, l)
+	return l
+}
+
+// stamp adds the clock's reading to the headers of a message being
+// published
+func (b *Bus) stamp(h nats.Header) nats.Header {
+	if h == nil {
+		h = nats.Header{}
+	}
+	h.Set(HeaderHLC, b.clock.Now().String())
+	return h
+}
+
+// observeClock advances the clock past a received message's reading and
+// records its delivery latency. stored is when the NATS server stored the
+// message, zero for core NATS messages; against it the sender's clock and
+// the local one are checked for skew.
+func (b *Bus) observeClock(subject string, h nats.Header, stored time.Time) {
+	remote, ok := parseTimestamp(h.Get(HeaderHLC))
+	if !ok {
+		return
+	}
+	now := time.Now()
+	received := b.clock.Update(remote)
+	raw := now.Sub(remote.Time())
+	latencyFor(subject).record(received.Time().Sub(remote.Time()), raw < 0)
+
+	if stored.IsZero() {
+		return
+	}
+	// Publishing reaches the server within milliseconds, so a sender stamp
+	// far from the stored time is the sender's clock being off; a receipt
+	// before the stored time is the local clock running behind
+	if service := h.Get(HeaderService); service != "" {
+		skew := remote.Time().Sub(stored)
+		clockSkew.Set(service, expvarInt(skew.Milliseconds()))
+		b.checkSkew(service, skew)
+	}
+	behind := max(stored.Sub(now), 0)
+	clockSkew.Set("self", expvarInt(-behind.Milliseconds()))
+	b.checkSkew("self", -behind)
+}
+
+// checkSkew warns when a clock's skew first exceeds the limit, and when it
+// is back within it
+func (b *Bus) checkSkew(service string, skew time.Duration) {
+	limit := b.cfg.MaxClockSkew
+	if limit <= 0 {
+		limit = DefaultMaxClockSkew
+	}
+	over := skew > limit || skew < -limit
+
+	b.peersMu.Lock()
+	if b.skewedPeers == nil {
+		b.skewedPeers = make(map[string]bool)
+	}
+	was := b.skewedPeers[service]
+	b.skewedPeers[service] = over
+	b.peersMu.Unlock()
+
+	if b.log == nil || over == was {
+		return
+	}
+	if over {
+		b.log.Warn("clock skew against NATS server", "service", service, "skew", skew, "limit", limit)
+	} else {
+		b.log.Info("clock skew back within limit", "service", service, "skew", skew)
+	}
+}
+
+func expvarInt(v int64) *expvar.Int {
+	i := new(expvar.Int)
+	i.Set(v)
+	return i
+}
@@ -0,0 +1,339 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// RetiredConsumerTTL is how long a migrated-from consumer lingers once
+// nothing pulls from it before the server deletes it
+const RetiredConsumerTTL = time.Minute
+
+// cutoverDrainWait bounds how long a cutover waits for in-flight messages
+// on the old consumer before binding the new one
+const cutoverDrainWait = 30 * time.Second
+
+// ErrConsumerNotFound is returned by MigrateConsumer for a consumer that
+// has never been created
+var ErrConsumerNotFound = errors.New("consumer not found")
+
+// ConsumerMigration moves a durable consumer to its next version. The new
+// version starts after the old one's ack floor, so nothing acked is
+// redelivered and nothing unacked is lost; messages in flight during the
+// cutover may be handled twice.
+type ConsumerMigration struct {
+	Base     string `json:"base"` // name passed to Subscribe*
+	From     string `json:"from"`
+	To       string `json:"to"`
+	StartSeq uint64 `json:"start_seq"`
+}
+
+// Durable consumers are versioned by name: version 1 is the base name the
+// service subscribes with, later versions are <base>-v<N>
+func consumerName(base string, version int) string {
+	if version <= 1 {
+		return base
+	}
+	return base + "-v" + strconv.Itoa(version)
+}
+
+func consumerVersion(base, name string) (int, bool) {
+	if name == base {
+		return 1, true
+	}
+	v, ok := strings.CutPrefix(name, base+"-v")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 2 {
+		return 0, false
+	}
+	return n, true
+}
+
+// currentConsumer returns the newest version of a durable consumer, or nil
+// if there is none
+func (b *Bus) currentConsumer(ctx context.Context, base string) (*jetstream.ConsumerInfo, int, error) {
+	var current *jetstream.ConsumerInfo
+	var version int
+	list := b.stream.ListConsumers(ctx)
+	for info := range list.Info() {
+		if v, ok := consumerVersion(base, info.Name); ok && v > version {
+			current, version = info, v
+		}
+	}
+	if err := list.Err(); err != nil {
+		return nil, 0, fmt.Errorf("list consumers: %w", err)
+	}
+	return current, version, nil
+}
+
+// MigrateConsumer creates the next version of a durable consumer and tells
+// its subscribers to cut over. With cfg nil the new version keeps the
+// current configuration, which rebuilds a consumer stuck in a bad state;
+// otherwise cfg replaces it, apart from the name and start position. The
+// old version is left for its subscribers to drain and is deleted by the
+// server RetiredConsumerTTL after the last of them stops pulling.
+func (b *Bus) MigrateConsumer(ctx context.Context, base string, cfg *jetstream.ConsumerConfig) (ConsumerMigration, error) {
+	current, version, err := b.currentConsumer(ctx, base)
+	if err != nil {
+		return ConsumerMigration{}, err
+	}
+	if current == nil {
+		return ConsumerMigration{}, fmt.Errorf("%w: %s", ErrConsumerNotFound, base)
+	}
+
+	next := current.Config
+	if cfg != nil {
+		next = *cfg
+	}
+	m := ConsumerMigration{
+		Base:     base,
+		From:     current.Name,
+		To:       consumerName(base, version+1),
+		StartSeq: current.AckFloor.Stream + 1,
+	}
+	next.Name = ""
+	next.Durable = m.To
+	next.DeliverPolicy = jetstream.DeliverByStartSequencePolicy
+	next.OptStartSeq = m.StartSeq
+	next.OptStartTime = nil
+	next.InactiveThreshold = 0
+	if _, err := b.js.CreateConsumer(ctx, b.cfg.StreamName, next); err != nil {
+		return m, fmt.Errorf("create consumer %s: %w", m.To, err)
+	}
+
+	retired := current.Config
+	retired.InactiveThreshold = RetiredConsumerTTL
+	if _, err := b.js.UpdateConsumer(ctx, b.cfg.StreamName, retired); err != nil {
+		return m, fmt.Errorf("retire consumer %s: %w", m.From, err)
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return m, fmt.Errorf("marshal migration: %w", err)
+	}
+	notice := &nats.Msg{Subject: b.subject(SubjectConsumersMigrated), Header: b.stamp(b.identity.headers()), Data: data}
+	if err := b.nc.PublishMsg(notice); err != nil {
+		return m, fmt.Errorf("publish %s: %w", SubjectConsumersMigrated, err)
+	}
+	if b.log != nil {
+		b.log.Info("consumer migrated", "from", m.From, "to", m.To, "start_seq", m.StartSeq)
+	}
+	return m, nil
+}
+
+// consumerChanged reports whether an existing consumer was created with
+// settings that cannot be updated in place to want's
+func consumerChanged(have, want jetstream.ConsumerConfig) bool {
+	filters := func(c jetstream.ConsumerConfig) []string {
+		if c.FilterSubject != "" {
+			return []string{c.FilterSubject}
+		}
+		return c.FilterSubjects
+	}
+	return !slices.Equal(filters(have), filters(want)) ||
+		have.AckPolicy != want.AckPolicy ||
+		have.ReplayPolicy != want.ReplayPolicy
+}
+
+// bindConsumer returns the newest version of a durable consumer, creating
+// it if there is none. A consumer whose settings changed incompatibly is
+// migrated to a new version with cfg; others are updated in place.
+func (b *Bus) bindConsumer(ctx context.Context, base string, cfg jetstream.ConsumerConfig) (jetstream.Consumer, error) {
+	current, _, err := b.currentConsumer(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		cfg.Durable = base
+		return b.js.CreateOrUpdateConsumer(ctx, b.cfg.StreamName, cfg)
+	}
+
+	if consumerChanged(current.Config, cfg) {
+		m, err := b.MigrateConsumer(ctx, base, &cfg)
+		if err != nil {
+			return nil, err
+		}
+		return b.js.Consumer(ctx, b.cfg.StreamName, m.To)
+	}
+
+	// Where a migrated version starts is fixed at creation
+	cfg.Durable = current.Name
+	cfg.DeliverPolicy = current.Config.DeliverPolicy
+	cfg.OptStartSeq = current.Config.OptStartSeq
+	cfg.OptStartTime = current.Config.OptStartTime
+	return b.js.CreateOrUpdateConsumer(ctx, b.cfg.StreamName, cfg)
+}
+
+// managedConsumer is the ConsumeContext returned by Subscribe* methods.
+// It outlives migrations: on a cutover the old consume context is drained
+// and the same handler starts on the new version.
+type managedConsumer struct {
+	bus     *Bus
+	base    string
+	handler jetstream.MessageHandler
+
+	mu      sync.Mutex
+	name    string
+	cc      jetstream.ConsumeContext
+	stopped bool
+	closed  chan struct{}
+}
+
+var _ jetstream.ConsumeContext = (*managedConsumer)(nil)
+
+// Stop stops consuming without waiting for in-flight messages
+func (m *managedConsumer) Stop() {
+	m.close(jetstream.ConsumeContext.Stop)
+}
+
+// Drain stops consuming once in-flight messages are handled
+func (m *managedConsumer) Drain() {
+	m.close(jetstream.ConsumeContext.Drain)
+}
+
+// Closed is closed once the consumer is stopped or drained
+func (m *managedConsumer) Closed() <-chan struct{} {
+	return m.closed
+}
+
+func (m *managedConsumer) close(stop func(jetstream.ConsumeContext)) {
+	m.mu.Lock()
+	if m.stopped {
+		m.mu.Unlock()
+		return
+	}
+	m.stopped = true
+	cc := m.cc
+	m.mu.Unlock()
+
+	m.bus.forgetConsumer(m)
+	stop(cc)
+	go func() {
+		<-cc.Closed()
+		close(m.closed)
+	}()
+}
+
+// cutover drains the current consume context and starts consuming the
+// given version. In-flight messages finish first so handlers never see the
+// two versions at once.
+func (m *managedConsumer) cutover(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped || m.name == name {
+		return nil
+	}
+	have, _ := consumerVersion(m.base, m.name)
+	if v, ok := consumerVersion(m.base, name); !ok || v <= have {
+		return nil
+	}
+
+	consumer, err := m.bus.js.Consumer(ctx, m.bus.cfg.StreamName, name)
+	if err != nil {
+		return fmt.Errorf("bind consumer %s: %w", name, err)
+	}
+	m.cc.Drain()
+	select {
+	case <-m.cc.Closed():
+	case <-time.After(cutoverDrainWait):
+		m.cc.Stop()
+	}
+	cc, err := consumer.Consume(m.handler)
+	if err != nil {
+		return fmt.Errorf("consume %s: %w", name, err)
+	}
+	m.name, m.cc = name, cc
+	return nil
+}
+
+// consume starts a managed consumer on the newest version of a durable
+// consumer, following later migrations of it
+func (b *Bus) consume(ctx context.Context, base string, cfg jetstream.ConsumerConfig, handler jetstream.MessageHandler) (*managedConsumer, error) {
+	consumer, err := b.bindConsumer(ctx, base, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("create consumer %s: %w", base, err)
+	}
+	cc, err := consumer.Consume(handler)
+	if err != nil {
+		return nil, err
+	}
+	m := &managedConsumer{
+		bus:     b,
+		base:    base,
+		handler: handler,
+		name:    consumer.CachedInfo().Name,
+		cc:      cc,
+		closed:  make(chan struct{}),
+	}
+	if err := b.trackConsumer(ctx, m); err != nil {
+		cc.Stop()
+		return nil, err
+	}
+	return m, nil
+}
+
+// trackConsumer registers a managed consumer for cutovers, subscribing to
+// consumers.migrated with the first one
+func (b *Bus) trackConsumer(ctx context.Context, m *managedConsumer) error {
+	b.consumersMu.Lock()
+	defer b.consumersMu.Unlock()
+	if b.migrations == nil {
+		sub, err := b.nc.Subscribe(b.subject(SubjectConsumersMigrated), func(msg *nats.Msg) {
+			var mig ConsumerMigration
+			if err := json.Unmarshal(msg.Data, &mig); err != nil {
+				return
+			}
+			b.handleMigration(ctx, mig)
+		})
+		if err != nil {
+			return fmt.Errorf("subscribe %s: %w", SubjectConsumersMigrated, err)
+		}
+		b.migrations = sub
+		b.consumers = make(map[*managedConsumer]bool)
+	}
+	b.consumers[m] = true
+	return nil
+}
+
+func (b *Bus) forgetConsumer(m *managedConsumer) {
+	b.consumersMu.Lock()
+	defer b.consumersMu.Unlock()
+	delete(b.consumers, m)
+}
+
+// handleMigration cuts every managed consumer of the migrated base over
+func (b *Bus) handleMigration(ctx context.Context, mig ConsumerMigration) {
+	b.consumersMu.Lock()
+	var owned []*managedConsumer
+	for m := range b.consumers {
+		if m.base == mig.Base {
+			owned = append(owned, m)
+		}
+	}
+	b.consumersMu.Unlock()
+
+	for _, m := range owned {
+		err := m.cutover(ctx, mig.To)
+		if b.log == nil {
+			continue
+		}
+		if err != nil {
+			b.log.Error("consumer cutover failed", "consumer", mig.Base, "to", mig.To, "error", err)
+			continue
+		}
+		b.log.Info("consumer cut over", "from", mig.From, "to", mig.To)
+	}
+}
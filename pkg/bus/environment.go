@@ -0,0 +1,33 @@
+package bus
+
+import "strings"
+
+// Environment returns the name of the bus's environment, "" for the default
+func (b *Bus) Environment() string {
+	return b.cfg.Environment
+}
+
+// subject returns subject as published within the bus's environment
+func (b *Bus) subject(subject string) string {
+	if b.cfg.Environment == "" {
+		return subject
+	}
+	return b.cfg.Environment + "." + subject
+}
+
+// bucket returns a key-value or object store bucket name within the bus's
+// environment
+func (b *Bus) bucket(name string) string {
+	if b.cfg.Environment == "" {
+		return name
+	}
+	return b.cfg.Environment + "-" + name
+}
+
+// environmentStream returns the stream name for an environment
+func environmentStream(stream, environment string) string {
+	if environment == "" {
+		return stream
+	}
+	return stream + "_" + strings.ToUpper(strings.ReplaceAll(environment, "-", "_"))
+}
@@ -3,6 +3,7 @@ module github.com/microcloud/bus
 go 1.23
 
 require (
+	github.com/microcloud/environment v0.0.0
 	github.com/microcloud/gen/go v0.0.0
 	github.com/nats-io/nats.go v1.39.1
 	google.golang.org/protobuf v1.36.5
@@ -17,4 +18,7 @@ require (
 	golang.org/x/text v0.21.0 // indirect
 )
 
-replace github.com/microcloud/gen/go => ../../gen/go
+replace (
+	github.com/microcloud/environment => ../environment
+	github.com/microcloud/gen/go => ../../gen/go
+)
@@ -0,0 +1,124 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"google.golang.org/protobuf/proto"
+
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+)
+
+// DefaultHistoryPageSize is how many items ReplayHistory passes per page
+// when no size is given
+const DefaultHistoryPageSize = 100
+
+// historyFetchWait bounds how long a fetch waits for a page to fill. The
+// stream holds everything replayed already, so only an empty range waits
+// this long.
+const historyFetchWait = 2 * time.Second
+
+// HistoryItem is an incident or action replayed from the stream. Exactly
+// one of Incident and Action is set.
+type HistoryItem struct {
+	Sequence uint64    // stream sequence, increasing across pages
+	StoredAt time.Time // when the NATS server stored the message
+	Incident *opsv1.Incident
+	Action   *opsv1.Action
+}
+
+// HistoryPage is one page of replayed items, oldest first. The last page
+// may be empty.
+type HistoryPage struct {
+	Number int // from 1
+	Items  []HistoryItem
+	Last   bool
+}
+
+// HistoryHandler handles one page of replayed history. Returning an error
+// stops the replay.
+type HistoryHandler func(ctx context.Context, page HistoryPage) error
+
+// ReplayHistory replays the incidents and actions stored since the given
+// time to handler, in pages of at most pageSize items, through an
+// ephemeral ordered consumer. It returns once the last page, holding the
+// newest message stored when the replay reached it, has been handled.
+// Messages published during the replay may or may not be included, so
+// callers switching to a live subscription afterwards should expect
+// duplicates.
+func (s *Subscriber) ReplayHistory(ctx context.Context, since time.Time, pageSize int, handler HistoryHandler) error {
+	if pageSize <= 0 {
+		pageSize = DefaultHistoryPageSize
+	}
+	incidents, actions := s.bus.subject(SubjectOpsIncidents), s.bus.subject(SubjectOpsActions)
+	consumer, err := s.bus.js.OrderedConsumer(ctx, s.bus.cfg.StreamName, jetstream.OrderedConsumerConfig{
+		FilterSubjects: []string{incidents, actions},
+		DeliverPolicy:  jetstream.DeliverByStartTimePolicy,
+		OptStartTime:   &since,
+	})
+	if err != nil {
+		return fmt.Errorf("create history consumer: %w", err)
+	}
+
+	chunks := newAssembler()
+	for number := 1; ; number++ {
+		batch, err := consumer.Fetch(pageSize, jetstream.FetchMaxWait(historyFetchWait))
+		if err != nil {
+			return fmt.Errorf("fetch history: %w", err)
+		}
+		page := HistoryPage{Number: number}
+		var received int
+		pending := uint64(1)
+		for msg := range batch.Messages() {
+			received++
+			meta, err := msg.Metadata()
+			if err != nil {
+				return fmt.Errorf("history metadata: %w", err)
+			}
+			pending = meta.NumPending
+
+			data := msg.Data()
+			if chunkID := msg.Headers().Get(HeaderChunkID); chunkID != "" {
+				full, complete, err := chunks.add(msg.Headers(), data, nil)
+				if err != nil || !complete {
+					continue
+				}
+				chunks.take(chunkID)
+				data = full
+			}
+			item := HistoryItem{Sequence: meta.Sequence.Stream, StoredAt: meta.Timestamp}
+			switch msg.Subject() {
+			case incidents:
+				item.Incident = &opsv1.Incident{}
+				err = proto.Unmarshal(data, item.Incident)
+			case actions:
+				item.Action = &opsv1.Action{}
+				err = proto.Unmarshal(data, item.Action)
+			default:
+				continue
+			}
+			if err != nil {
+				// Skipped like a consumer would term it
+				continue
+			}
+			page.Items = append(page.Items, item)
+		}
+		if err := batch.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) {
+			return fmt.Errorf("fetch history: %w", err)
+		}
+
+		// An empty fetch means nothing matched at all, or the rest of
+		// the range was pruned by retention while replaying
+		page.Last = pending == 0 || received == 0
+		if err := handler(ctx, page); err != nil {
+			return err
+		}
+		if page.Last {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"google.golang.org/protobuf/proto"
+
+	opsv1 "github.com/microcloud/gen/go/ops/v1"
+)
+
+// Headers naming the service that published a message
+const (
+	HeaderService = "Service"
+	HeaderVersion = "Service-Version"
+	HeaderCommit  = "Service-Commit"
+)
+
+// DefaultHeartbeatInterval is how often RunHeartbeats announces the service
+const DefaultHeartbeatInterval = 10 * time.Second
+
+// Identity names the service on the bus. Its version and commit go out in
+// the headers of every message published and in heartbeats.
+type Identity struct {
+	Service  string
+	Instance string // unique per process
+	Version  string // semantic version, or "dev"
+	Commit   string
+}
+
+// WithIdentity sets the service, version and commit the bus publishes as.
+// Subscribers warn about messages from incompatible versions.
+func WithIdentity(service, version, commit string) Option {
+	return func(b *Bus) {
+		b.identity = Identity{
+			Service:  service,
+			Instance: newID(),
+			Version:  version,
+			Commit:   commit,
+		}
+	}
+}
+
+// WithConfig reports the service's effective configuration, as config
+// returns it at the time, in every heartbeat
+func WithConfig(config func() map[string]string) Option {
+	return func(b *Bus) {
+		b.config = config
+	}
+}
+
+// Identity returns what the bus publishes as; it is zero without
+// WithIdentity
+func (b *Bus) Identity() Identity {
+	return b.identity
+}
+
+// headers returns the identity headers for a published message, or nil
+// without an identity
+func (id Identity) headers() nats.Header {
+	if id.Service == "" {
+		return nil
+	}
+	h := nats.Header{}
+	h.Set(HeaderService, id.Service)
+	h.Set(HeaderVersion, id.Version)
+	if id.Commit != "" {
+		h.Set(HeaderCommit, id.Commit)
+	}
+	return h
+}
+
+// Heartbeat returns the identity as a heartbeat
+func (id Identity) Heartbeat() *opsv1.ServiceHeartbeat {
+	return &opsv1.ServiceHeartbeat{
+		Service:      id.Service,
+		Instance:     id.Instance,
+		Version:      id.Version,
+		Commit:       id.Commit,
+		SentAtUnixMs: time.Now().UnixMilli(),
+	}
+}
+
+// heartbeat returns the bus's identity as a heartbeat, with the service's
+// configuration if WithConfig gave one
+func (b *Bus) heartbeat() *opsv1.ServiceHeartbeat {
+	hb := b.identity.Heartbeat()
+	if b.config != nil {
+		hb.Config = b.config()
+	}
+	return hb
+}
+
+// Compatible reports whether services at versions a and b can talk to each
+// other: the same major version from v1 on, and the same minor version
+// before. Versions that are not semantic, such as dev builds, cannot be
+// judged; they count as compatible and reason says so.
+func Compatible(a, b string) (ok bool, reason string) {
+	amaj, amin, aok := parseVersion(a)
+	bmaj, bmin, bok := parseVersion(b)
+	switch {
+	case !aok || !bok:
+		return true, fmt.Sprintf("cannot compare %q and %q", a, b)
+	case amaj != bmaj:
+		return false, fmt.Sprintf("major version %d differs from %d", amaj, bmaj)
+	case amaj == 0 && amin != bmin:
+		return false, fmt.Sprintf("pre-1.0 minor version 0.%d differs from 0.%d", amin, bmin)
+	}
+	return true, ""
+}
+
+// parseVersion returns the major and minor of a version such as v1.4.2 or
+// 1.4.2-rc.1
+func parseVersion(v string) (major, minor int, ok bool) {
+	parts := strings.SplitN(strings.TrimPrefix(v, "v"), ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	minor, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+// checkPeer warns once per service and version when a message comes from
+// a version incompatible with the bus's own
+func (b *Bus) checkPeer(h nats.Header) {
+	if b.log == nil || b.identity.Service == "" || h == nil {
+		return
+	}
+	service, version := h.Get(HeaderService), h.Get(HeaderVersion)
+	if service == "" || version == b.identity.Version {
+		return
+	}
+	ok, reason := Compatible(b.identity.Version, version)
+	if ok {
+		return
+	}
+
+	key := service + "@" + version
+	b.peersMu.Lock()
+	warned := b.warnedPeers[key]
+	if b.warnedPeers == nil {
+		b.warnedPeers = make(map[string]bool)
+	}
+	b.warnedPeers[key] = true
+	b.peersMu.Unlock()
+	if warned {
+		return
+	}
+	b.log.Warn("message from incompatible service version",
+		"service", service,
+		"version", version,
+		"commit", h.Get(HeaderCommit),
+		"own_version", b.identity.Version,
+		"reason", reason,
+	)
+}
+
+// HeartbeatHandler handles service heartbeats
+type HeartbeatHandler func(ctx context.Context, hb *opsv1.ServiceHeartbeat) error
+
+// PublishHeartbeat announces the bus's identity, and configuration if it
+// has one, on services.heartbeat
+func (p *Publisher) PublishHeartbeat(ctx context.Context) error {
+	data, err := proto.Marshal(p.bus.heartbeat())
+	if err != nil {
+		return fmt.Errorf("marshal proto: %w", err)
+	}
+	msg := &nats.Msg{Subject: p.bus.subject(SubjectHeartbeat), Header: p.bus.stamp(p.bus.identity.headers()), Data: data}
+	if err := p.bus.nc.PublishMsg(msg); err != nil {
+		return fmt.Errorf("publish %s: %w", SubjectHeartbeat, err)
+	}
+	return nil
+}
+
+// RunHeartbeats publishes a heartbeat every DefaultHeartbeatInterval until
+// ctx is done. Failed heartbeats are retried on the next tick.
+func (p *Publisher) RunHeartbeats(ctx context.Context) error {
+	ticker := time.NewTicker(DefaultHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		p.PublishHeartbeat(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// SubscribeHeartbeats subscribes to services.heartbeat. Every subscriber
+// gets every heartbeat, including its own service's.
+func (s *Subscriber) SubscribeHeartbeats(ctx context.Context, handler HeartbeatHandler) (*Subscription, error) {
+	sub, err := s.bus.nc.Subscribe(s.bus.subject(SubjectHeartbeat), func(m *nats.Msg) {
+		s.bus.checkPeer(m.Header)
+		s.bus.observeClock(SubjectHeartbeat, m.Header, time.Time{})
+		var msg opsv1.ServiceHeartbeat
+		if err := proto.Unmarshal(m.Data, &msg); err != nil {
+			return
+		}
+		handler(ctx, &msg)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("subscribe %s: %w", SubjectHeartbeat, err)
+	}
+	return &Subscription{sub: sub}, nil
+}
@@ -0,0 +1,133 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrLeaseLost is returned when a lease was taken over or expired before renewal
+var ErrLeaseLost = errors.New("lease lost")
+
+// Lease is a leader lease held in a JetStream key-value bucket. The bucket
+// TTL bounds how long a dead holder keeps the lease; a live holder must
+// Renew well within it.
+type Lease struct {
+	kv       jetstream.KeyValue
+	key      string
+	owner    string
+	revision uint64
+}
+
+// NewLease opens (creating if needed) a lease bucket with the given TTL
+func (b *Bus) NewLease(ctx context.Context, bucket, key, owner string, ttl time.Duration) (*Lease, error) {
+	kv, err := b.js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:  b.bucket(bucket),
+		TTL:     ttl,
+		History: 1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create lease bucket %s: %w", bucket, err)
+	}
+	return &Lease{kv: kv, key: key, owner: owner}, nil
+}
+
+// TryAcquire takes the lease if nobody holds it. It reports false without
+// error when another owner holds it.
+func (l *Lease) TryAcquire(ctx context.Context) (bool, error) {
+	rev, err := l.kv.Create(ctx, l.key, []byte(l.owner))
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("acquire lease: %w", err)
+	}
+	l.revision = rev
+	return true, nil
+}
+
+// Renew extends a held lease. It returns ErrLeaseLost if the lease changed
+// hands or expired since the last renewal.
+func (l *Lease) Renew(ctx context.Context) error {
+	rev, err := l.kv.Update(ctx, l.key, []byte(l.owner), l.revision)
+	if err != nil {
+		l.revision = 0
+		return fmt.Errorf("%w: %v", ErrLeaseLost, err)
+	}
+	l.revision = rev
+	return nil
+}
+
+// Release gives up a held lease so a standby can take over immediately
+func (l *Lease) Release(ctx context.Context) error {
+	if l.revision == 0 {
+		return nil
+	}
+	err := l.kv.Delete(ctx, l.key, jetstream.LastRevision(l.revision))
+	l.revision = 0
+	if err != nil {
+		return fmt.Errorf("release lease: %w", err)
+	}
+	return nil
+}
+
+// Holder returns the current lease owner, or "" if the lease is free
+func (l *Lease) Holder(ctx context.Context) (string, error) {
+	entry, err := l.kv.Get(ctx, l.key)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("get lease holder: %w", err)
+	}
+	return string(entry.Value()), nil
+}
+
+// Store keeps the latest protobuf value per key in a JetStream key-value bucket
+type Store struct {
+	kv jetstream.KeyValue
+}
+
+// NewStore opens (creating if needed) a key-value bucket
+func (b *Bus) NewStore(ctx context.Context, bucket string) (*Store, error) {
+	kv, err := b.js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:  b.bucket(bucket),
+		History: 1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create store bucket %s: %w", bucket, err)
+	}
+	return &Store{kv: kv}, nil
+}
+
+// Put replaces the value stored under key
+func (s *Store) Put(ctx context.Context, key string, msg proto.Message) error {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshal %s: %w", key, err)
+	}
+	if _, err := s.kv.Put(ctx, key, data); err != nil {
+		return fmt.Errorf("put %s: %w", key, err)
+	}
+	return nil
+}
+
+// Get loads the value stored under key into msg. It reports false without
+// error if the key does not exist.
+func (s *Store) Get(ctx context.Context, key string, msg proto.Message) (bool, error) {
+	entry, err := s.kv.Get(ctx, key)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("get %s: %w", key, err)
+	}
+	if err := proto.Unmarshal(entry.Value(), msg); err != nil {
+		return false, fmt.Errorf("unmarshal %s: %w", key, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,164 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	commonv1 "github.com/microcloud/gen/go/common/v1"
+)
+
+// ErrObjectNotFound is returned when an object ID does not exist or has expired
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectStore keeps large blobs (scenario bundles, postmortem reports,
+// replay captures) in a JetStream object store bucket. Bus messages carry
+// an ObjectRef instead of the blob.
+type ObjectStore struct {
+	bucket string
+	store  jetstream.ObjectStore
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	ID          string
+	Size        uint64
+	ContentType string
+	Digest      string
+	Modified    time.Time
+	Metadata    map[string]string
+}
+
+// Ref returns a reference to the object for embedding in bus messages
+func (o ObjectInfo) Ref(bucket string) *commonv1.ObjectRef {
+	return &commonv1.ObjectRef{
+		Bucket:      bucket,
+		Id:          o.ID,
+		Size:        o.Size,
+		ContentType: o.ContentType,
+		Digest:      o.Digest,
+	}
+}
+
+// NewObjectStore opens (creating if needed) an object store bucket. Objects
+// expire ttl after they were written; zero keeps them until deleted.
+func (b *Bus) NewObjectStore(ctx context.Context, bucket string, ttl time.Duration) (*ObjectStore, error) {
+	bucket = b.bucket(bucket)
+	store, err := b.js.CreateOrUpdateObjectStore(ctx, jetstream.ObjectStoreConfig{
+		Bucket:  bucket,
+		TTL:     ttl,
+		Storage: jetstream.FileStorage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create object store %s: %w", bucket, err)
+	}
+	return &ObjectStore{bucket: bucket, store: store}, nil
+}
+
+// OpenRef opens the object a reference points to, in whichever bucket holds it.
+// The caller must close the reader.
+func (b *Bus) OpenRef(ctx context.Context, ref *commonv1.ObjectRef) (io.ReadCloser, ObjectInfo, error) {
+	store, err := b.js.ObjectStore(ctx, ref.GetBucket())
+	if err != nil {
+		return nil, ObjectInfo{}, fmt.Errorf("open object store %s: %w", ref.GetBucket(), err)
+	}
+	return (&ObjectStore{bucket: ref.GetBucket(), store: store}).Open(ctx, ref.GetId())
+}
+
+// Bucket returns the bucket name
+func (s *ObjectStore) Bucket() string {
+	return s.bucket
+}
+
+// Write streams r into a new object and returns its info, including the
+// generated ID
+func (s *ObjectStore) Write(ctx context.Context, contentType string, metadata map[string]string, r io.Reader) (ObjectInfo, error) {
+	meta := jetstream.ObjectMeta{
+		Name:     newID(),
+		Metadata: metadata,
+	}
+	if contentType != "" {
+		meta.Headers = nats.Header{}
+		meta.Headers.Set("Content-Type", contentType)
+	}
+
+	info, err := s.store.Put(ctx, meta, r)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("write object: %w", err)
+	}
+	return objectInfo(info), nil
+}
+
+// Open returns a streaming reader for an object. The caller must close it;
+// Close reports a digest mismatch if the object was corrupted.
+func (s *ObjectStore) Open(ctx context.Context, id string) (io.ReadCloser, ObjectInfo, error) {
+	res, err := s.store.Get(ctx, id)
+	if errors.Is(err, jetstream.ErrObjectNotFound) {
+		return nil, ObjectInfo{}, fmt.Errorf("%w: %s", ErrObjectNotFound, id)
+	}
+	if err != nil {
+		return nil, ObjectInfo{}, fmt.Errorf("open object %s: %w", id, err)
+	}
+	info, err := res.Info()
+	if err != nil {
+		res.Close()
+		return nil, ObjectInfo{}, fmt.Errorf("object info %s: %w", id, err)
+	}
+	return res, objectInfo(info), nil
+}
+
+// Stat returns an object's info without reading it
+func (s *ObjectStore) Stat(ctx context.Context, id string) (ObjectInfo, error) {
+	info, err := s.store.GetInfo(ctx, id)
+	if errors.Is(err, jetstream.ErrObjectNotFound) {
+		return ObjectInfo{}, fmt.Errorf("%w: %s", ErrObjectNotFound, id)
+	}
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("stat object %s: %w", id, err)
+	}
+	return objectInfo(info), nil
+}
+
+// Delete removes an object
+func (s *ObjectStore) Delete(ctx context.Context, id string) error {
+	err := s.store.Delete(ctx, id)
+	if errors.Is(err, jetstream.ErrObjectNotFound) {
+		return fmt.Errorf("%w: %s", ErrObjectNotFound, id)
+	}
+	if err != nil {
+		return fmt.Errorf("delete object %s: %w", id, err)
+	}
+	return nil
+}
+
+// List returns every object in the bucket
+func (s *ObjectStore) List(ctx context.Context) ([]ObjectInfo, error) {
+	infos, err := s.store.List(ctx)
+	if errors.Is(err, jetstream.ErrNoObjectsFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("list objects: %w", err)
+	}
+	objects := make([]ObjectInfo, 0, len(infos))
+	for _, info := range infos {
+		objects = append(objects, objectInfo(info))
+	}
+	return objects, nil
+}
+
+func objectInfo(info *jetstream.ObjectInfo) ObjectInfo {
+	return ObjectInfo{
+		ID:          info.Name,
+		Size:        info.Size,
+		ContentType: info.Headers.Get("Content-Type"),
+		Digest:      info.Digest,
+		Modified:    info.ModTime,
+		Metadata:    info.Metadata,
+	}
+}
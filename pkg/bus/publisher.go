@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/nats-io/nats.go"
 	"google.golang.org/protobuf/proto"
 
 	opsv1 "github.com/microcloud/gen/go/ops/v1"
@@ -30,6 +31,11 @@ func (p *Publisher) PublishSimulationEvent(ctx context.Context, event *simv1.Sim
 	return p.publish(ctx, SubjectSimEvents, event)
 }
 
+// PublishControlCommand publishes a simulation control command to sim.control
+func (p *Publisher) PublishControlCommand(ctx context.Context, cmd *simv1.ControlCommand) error {
+	return p.publish(ctx, SubjectSimControl, cmd)
+}
+
 // PublishIncident publishes an incident to ops.incidents
 func (p *Publisher) PublishIncident(ctx context.Context, incident *opsv1.Incident) error {
 	return p.publish(ctx, SubjectOpsIncidents, incident)
@@ -45,15 +51,80 @@ func (p *Publisher) PublishCommand(ctx context.Context, cmd *opsv1.ApplyActionCo
 	return p.publish(ctx, SubjectOpsCommands, cmd)
 }
 
+// PublishActionResult publishes the outcome of an action command to ops.results
+func (p *Publisher) PublishActionResult(ctx context.Context, result *opsv1.ActionResult) error {
+	return p.publish(ctx, SubjectOpsResults, result)
+}
+
+// PublishRulesUpdated announces changed detection rules on rules.updated.
+// It bypasses JetStream: replicas that are down reload on start anyway.
+func (p *Publisher) PublishRulesUpdated(ctx context.Context, msg *opsv1.RulesUpdated) error {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshal proto: %w", err)
+	}
+	notice := &nats.Msg{Subject: p.bus.subject(SubjectRulesUpdated), Header: p.bus.stamp(p.bus.identity.headers()), Data: data}
+	if err := p.bus.nc.PublishMsg(notice); err != nil {
+		return fmt.Errorf("publish %s: %w", SubjectRulesUpdated, err)
+	}
+	return nil
+}
+
+// PublishClientActivity announces stream clients on sim.activity. It
+// bypasses JetStream: only current activity matters.
+func (p *Publisher) PublishClientActivity(ctx context.Context, msg *simv1.ClientActivity) error {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshal proto: %w", err)
+	}
+	notice := &nats.Msg{Subject: p.bus.subject(SubjectSimActivity), Header: p.bus.stamp(p.bus.identity.headers()), Data: data}
+	if err := p.bus.nc.PublishMsg(notice); err != nil {
+		return fmt.Errorf("publish %s: %w", SubjectSimActivity, err)
+	}
+	return nil
+}
+
 func (p *Publisher) publish(ctx context.Context, subject string, msg proto.Message) error {
 	data, err := proto.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("marshal proto: %w", err)
 	}
 
-	_, err = p.bus.js.Publish(ctx, subject, data)
+	limit := p.bus.maxPayload(subject)
+	subject = p.bus.subject(subject)
+	if len(data) > limit {
+		return p.publishChunked(ctx, subject, data, limit)
+	}
+
+	_, err = p.bus.js.PublishMsg(ctx, &nats.Msg{
+		Subject: subject,
+		Header:  p.bus.stamp(p.bus.identity.headers()),
+		Data:    data,
+	})
 	if err != nil {
 		return fmt.Errorf("publish to %s: %w", subject, err)
 	}
 	return nil
 }
+
+// publishChunked splits an oversized payload into chunks the subscriber
+// reassembles. Chunks of one message go to the same subject, in order.
+func (p *Publisher) publishChunked(ctx context.Context, subject string, data []byte, limit int) error {
+	chunks := splitChunks(data, limit)
+	id := newID()
+	for i, chunk := range chunks {
+		header := chunkHeaders(id, i, len(chunks))
+		for k, v := range p.bus.stamp(p.bus.identity.headers()) {
+			header[k] = v
+		}
+		_, err := p.bus.js.PublishMsg(ctx, &nats.Msg{
+			Subject: subject,
+			Header:  header,
+			Data:    chunk,
+		})
+		if err != nil {
+			return fmt.Errorf("publish chunk %d/%d to %s: %w", i+1, len(chunks), subject, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package bus
+
+import (
+	"errors"
+	"fmt"
+
+	simv1 "github.com/microcloud/gen/go/sim/v1"
+)
+
+// ErrSnapshotGap is returned for a delta snapshot that does not follow the
+// last applied sequence. Deltas are dropped until the next keyframe.
+var ErrSnapshotGap = errors.New("snapshot sequence gap")
+
+// SnapshotMerger rebuilds full metric snapshots from keyframes and deltas.
+// It is not safe for concurrent use.
+type SnapshotMerger struct {
+	synced   bool
+	lastSeq  uint64
+	nodes    map[string]*simv1.Node
+	services map[string]*simv1.Service
+}
+
+// NewSnapshotMerger creates a merger waiting for its first keyframe
+func NewSnapshotMerger() *SnapshotMerger {
+	return &SnapshotMerger{}
+}
+
+// Apply returns the full snapshot as of s. Keyframes are returned as is.
+// A delta must follow the previous sequence (or repeat it, for redelivery);
+// otherwise Apply returns ErrSnapshotGap.
+func (m *SnapshotMerger) Apply(s *simv1.MetricSnapshot) (*simv1.MetricSnapshot, error) {
+	if !s.Delta {
+		m.nodes = make(map[string]*simv1.Node, len(s.Nodes))
+		for _, n := range s.Nodes {
+			m.nodes[n.Id.GetValue()] = n
+		}
+		m.services = make(map[string]*simv1.Service, len(s.Services))
+		for _, svc := range s.Services {
+			m.services[svc.Id.GetValue()] = svc
+		}
+		m.lastSeq = s.Sequence
+		m.synced = true
+		return s, nil
+	}
+
+	if !m.synced {
+		return nil, fmt.Errorf("%w: waiting for a keyframe, got delta %d", ErrSnapshotGap, s.Sequence)
+	}
+	if s.Sequence != m.lastSeq+1 && s.Sequence != m.lastSeq {
+		m.synced = false
+		return nil, fmt.Errorf("%w: expected %d, got %d", ErrSnapshotGap, m.lastSeq+1, s.Sequence)
+	}
+
+	for _, n := range s.Nodes {
+		m.nodes[n.Id.GetValue()] = n
+	}
+	for _, svc := range s.Services {
+		m.services[svc.Id.GetValue()] = svc
+	}
+	for _, id := range s.RemovedIds {
+		delete(m.nodes, id)
+		delete(m.services, id)
+	}
+	m.lastSeq = s.Sequence
+
+	// Removals are passed on so handlers can tell a removed entity from
+	// one that stopped reporting
+	full := &simv1.MetricSnapshot{
+		Timestamp:   s.Timestamp,
+		Traffic:     s.Traffic,
+		Regions:     s.Regions,
+		Sequence:    s.Sequence,
+		RemovedIds:  s.RemovedIds,
+		DetailLevel: s.DetailLevel,
+		Nodes:       make([]*simv1.Node, 0, len(m.nodes)),
+		Services:    make([]*simv1.Service, 0, len(m.services)),
+	}
+	for _, n := range m.nodes {
+		full.Nodes = append(full.Nodes, n)
+	}
+	for _, svc := range m.services {
+		full.Services = append(full.Services, svc)
+	}
+	return full, nil
+}
@@ -2,8 +2,11 @@ package bus
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 	"google.golang.org/protobuf/proto"
 
@@ -17,6 +20,9 @@ type MetricHandler func(ctx context.Context, snapshot *simv1.MetricSnapshot) err
 // SimEventHandler handles incoming simulation events
 type SimEventHandler func(ctx context.Context, event *simv1.SimulationEvent) error
 
+// ControlHandler handles incoming simulation control commands
+type ControlHandler func(ctx context.Context, cmd *simv1.ControlCommand) error
+
 // IncidentHandler handles incoming incidents
 type IncidentHandler func(ctx context.Context, incident *opsv1.Incident) error
 
@@ -26,6 +32,26 @@ type ActionHandler func(ctx context.Context, action *opsv1.Action) error
 // CommandHandler handles incoming action commands
 type CommandHandler func(ctx context.Context, cmd *opsv1.ApplyActionCommand) error
 
+// ActionResultHandler handles incoming action results
+type ActionResultHandler func(ctx context.Context, result *opsv1.ActionResult) error
+
+// RulesUpdatedHandler handles detection rule and suppression window change
+// notices
+type RulesUpdatedHandler func(ctx context.Context, msg *opsv1.RulesUpdated) error
+
+// ClientActivityHandler handles stream client activity notices
+type ClientActivityHandler func(ctx context.Context, msg *simv1.ClientActivity) error
+
+// Subscription is a core NATS subscription
+type Subscription struct {
+	sub *nats.Subscription
+}
+
+// Stop unsubscribes
+func (s *Subscription) Stop() {
+	s.sub.Unsubscribe()
+}
+
 // Subscriber provides typed subscription methods
 type Subscriber struct {
 	bus *Bus
@@ -36,14 +62,24 @@ func NewSubscriber(bus *Bus) *Subscriber {
 	return &Subscriber{bus: bus}
 }
 
-// SubscribeMetrics subscribes to sim.metrics with a durable consumer
+// SubscribeMetrics subscribes to sim.metrics with a durable consumer.
+// Delta snapshots are merged so the handler always sees full snapshots;
+// deltas after a sequence gap are skipped until the next keyframe.
 func (s *Subscriber) SubscribeMetrics(ctx context.Context, consumerName string, handler MetricHandler) (jetstream.ConsumeContext, error) {
+	merger := NewSnapshotMerger()
 	return s.subscribe(ctx, SubjectSimMetrics, consumerName, func(ctx context.Context, data []byte) error {
 		var msg simv1.MetricSnapshot
 		if err := proto.Unmarshal(data, &msg); err != nil {
 			return fmt.Errorf("unmarshal metric: %w", err)
 		}
-		return handler(ctx, &msg)
+		full, err := merger.Apply(&msg)
+		if errors.Is(err, ErrSnapshotGap) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return handler(ctx, full)
 	})
 }
 
@@ -58,6 +94,17 @@ func (s *Subscriber) SubscribeSimEvents(ctx context.Context, consumerName string
 	})
 }
 
+// SubscribeControl subscribes to sim.control with a durable consumer
+func (s *Subscriber) SubscribeControl(ctx context.Context, consumerName string, handler ControlHandler) (jetstream.ConsumeContext, error) {
+	return s.subscribe(ctx, SubjectSimControl, consumerName, func(ctx context.Context, data []byte) error {
+		var msg simv1.ControlCommand
+		if err := proto.Unmarshal(data, &msg); err != nil {
+			return fmt.Errorf("unmarshal control command: %w", err)
+		}
+		return handler(ctx, &msg)
+	})
+}
+
 // SubscribeIncidents subscribes to ops.incidents with a durable consumer
 func (s *Subscriber) SubscribeIncidents(ctx context.Context, consumerName string, handler IncidentHandler) (jetstream.ConsumeContext, error) {
 	return s.subscribe(ctx, SubjectOpsIncidents, consumerName, func(ctx context.Context, data []byte) error {
@@ -91,23 +138,95 @@ func (s *Subscriber) SubscribeCommands(ctx context.Context, consumerName string,
 	})
 }
 
-func (s *Subscriber) subscribe(ctx context.Context, subject, consumerName string, handler func(context.Context, []byte) error) (jetstream.ConsumeContext, error) {
-	consumer, err := s.bus.js.CreateOrUpdateConsumer(ctx, s.bus.cfg.StreamName, jetstream.ConsumerConfig{
-		Durable:       consumerName,
-		FilterSubject: subject,
-		AckPolicy:     jetstream.AckExplicitPolicy,
-		DeliverPolicy: jetstream.DeliverNewPolicy,
+// SubscribeActionResults subscribes to ops.results with a durable consumer
+func (s *Subscriber) SubscribeActionResults(ctx context.Context, consumerName string, handler ActionResultHandler) (jetstream.ConsumeContext, error) {
+	return s.subscribe(ctx, SubjectOpsResults, consumerName, func(ctx context.Context, data []byte) error {
+		var msg opsv1.ActionResult
+		if err := proto.Unmarshal(data, &msg); err != nil {
+			return fmt.Errorf("unmarshal action result: %w", err)
+		}
+		return handler(ctx, &msg)
+	})
+}
+
+// SubscribeRulesUpdated subscribes to rules.updated. Every subscriber gets
+// every notice; ones sent while disconnected are lost.
+func (s *Subscriber) SubscribeRulesUpdated(ctx context.Context, handler RulesUpdatedHandler) (*Subscription, error) {
+	sub, err := s.bus.nc.Subscribe(s.bus.subject(SubjectRulesUpdated), func(m *nats.Msg) {
+		s.bus.checkPeer(m.Header)
+		s.bus.observeClock(SubjectRulesUpdated, m.Header, time.Time{})
+		var msg opsv1.RulesUpdated
+		if err := proto.Unmarshal(m.Data, &msg); err != nil {
+			return
+		}
+		handler(ctx, &msg)
 	})
 	if err != nil {
-		return nil, fmt.Errorf("create consumer %s: %w", consumerName, err)
+		return nil, fmt.Errorf("subscribe %s: %w", SubjectRulesUpdated, err)
 	}
+	return &Subscription{sub: sub}, nil
+}
 
-	cc, err := consumer.Consume(func(msg jetstream.Msg) {
-		if err := handler(ctx, msg.Data()); err != nil {
-			msg.Nak()
+// SubscribeClientActivity subscribes to sim.activity. Notices sent while
+// disconnected are lost; senders repeat them while clients stay.
+func (s *Subscriber) SubscribeClientActivity(ctx context.Context, handler ClientActivityHandler) (*Subscription, error) {
+	sub, err := s.bus.nc.Subscribe(s.bus.subject(SubjectSimActivity), func(m *nats.Msg) {
+		s.bus.checkPeer(m.Header)
+		s.bus.observeClock(SubjectSimActivity, m.Header, time.Time{})
+		var msg simv1.ClientActivity
+		if err := proto.Unmarshal(m.Data, &msg); err != nil {
 			return
 		}
-		msg.Ack()
+		handler(ctx, &msg)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("subscribe %s: %w", SubjectSimActivity, err)
+	}
+	return &Subscription{sub: sub}, nil
+}
+
+// subscribe consumes subject through the newest version of the named
+// durable consumer. The returned context keeps consuming across
+// migrations of it.
+func (s *Subscriber) subscribe(ctx context.Context, subject, consumerName string, handler func(context.Context, []byte) error) (jetstream.ConsumeContext, error) {
+	chunks := newAssembler()
+	cc, err := s.bus.consume(ctx, consumerName, jetstream.ConsumerConfig{
+		FilterSubject: s.bus.subject(subject),
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		DeliverPolicy: jetstream.DeliverNewPolicy,
+	}, func(msg jetstream.Msg) {
+		s.bus.checkPeer(msg.Headers())
+		data := msg.Data()
+		delivered := []jetstream.Msg{msg}
+		if chunkID := msg.Headers().Get(HeaderChunkID); chunkID != "" {
+			full, complete, err := chunks.add(msg.Headers(), data, msg)
+			if err != nil {
+				// Malformed chunk; redelivery cannot fix it
+				msg.Term()
+				return
+			}
+			if !complete {
+				// Acked with the rest once the message is handled
+				return
+			}
+			data = full
+			delivered = chunks.take(chunkID)
+		}
+		var stored time.Time
+		if meta, err := msg.Metadata(); err == nil {
+			stored = meta.Timestamp
+		}
+		s.bus.observeClock(subject, msg.Headers(), stored)
+
+		if err := handler(ctx, data); err != nil {
+			for _, m := range delivered {
+				m.Nak()
+			}
+			return
+		}
+		for _, m := range delivered {
+			m.Ack()
+		}
 	})
 	if err != nil {
 		return nil, fmt.Errorf("consume %s: %w", subject, err)
@@ -0,0 +1,220 @@
+// Package client provides the standard client-side behavior for connect
+// clients of microcloud services: per-attempt timeouts, retries with jitter
+// of idempotent calls while a server is unavailable, and hedged reads for
+// side-effect-free calls, so scripts and tools ride out orchestrator
+// restarts.
+package client
+
+import (
+	"context"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"time"
+
+	"connectrpc.com/connect"
+)
+
+// Config controls timeouts, retries and hedging
+type Config struct {
+	// Timeout bounds each attempt. The caller's deadline still bounds the
+	// call as a whole.
+	Timeout time.Duration
+
+	// MaxAttempts is the number of attempts for idempotent calls failing
+	// with Unavailable, including the first. Calls with side effects are
+	// attempted once, since a failed attempt may still have taken effect.
+	MaxAttempts int
+
+	// BaseBackoff and MaxBackoff bound the exponential backoff between
+	// attempts. Each wait is drawn uniformly from zero to the bound.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+
+	// HedgeDelay starts a second request for side-effect-free calls when
+	// the first has not answered within it. Zero disables hedging.
+	HedgeDelay time.Duration
+}
+
+// DefaultConfig returns the default client configuration
+func DefaultConfig() Config {
+	return Config{
+		Timeout:     10 * time.Second,
+		MaxAttempts: 5,
+		BaseBackoff: 100 * time.Millisecond,
+		MaxBackoff:  5 * time.Second,
+		HedgeDelay:  500 * time.Millisecond,
+	}
+}
+
+// Options returns the client options applying cfg, for passing to the
+// generated New...ServiceClient constructors
+func Options(cfg Config) []connect.ClientOption {
+	return []connect.ClientOption{connect.WithInterceptors(NewInterceptor(cfg))}
+}
+
+// NewInterceptor returns a client interceptor applying cfg to unary calls.
+// Streaming calls pass through unchanged.
+func NewInterceptor(cfg Config) connect.Interceptor {
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+	return &interceptor{cfg: cfg}
+}
+
+type interceptor struct {
+	cfg Config
+}
+
+func (i *interceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if !req.Spec().IsClient {
+			return next(ctx, req)
+		}
+		level := req.Spec().IdempotencyLevel
+		hedge := i.cfg.HedgeDelay > 0 && level == connect.IdempotencyNoSideEffects
+		attempts := 1
+		if level == connect.IdempotencyNoSideEffects || level == connect.IdempotencyIdempotent {
+			attempts = i.cfg.MaxAttempts
+		}
+
+		var resp connect.AnyResponse
+		var err error
+		for attempt := 0; attempt < attempts; attempt++ {
+			if attempt > 0 {
+				if err := sleep(ctx, i.cfg.backoff(attempt)); err != nil {
+					return nil, err
+				}
+			}
+			if hedge {
+				resp, err = i.hedged(ctx, req, next)
+			} else {
+				resp, err = i.attempt(ctx, req, next)
+			}
+			if connect.CodeOf(err) != connect.CodeUnavailable {
+				return resp, err
+			}
+		}
+		return resp, err
+	}
+}
+
+func (i *interceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (i *interceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return next
+}
+
+// attempt makes one call bounded by the per-attempt timeout
+func (i *interceptor) attempt(ctx context.Context, req connect.AnyRequest, next connect.UnaryFunc) (connect.AnyResponse, error) {
+	if i.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, i.cfg.Timeout)
+		defer cancel()
+	}
+	return next(ctx, req)
+}
+
+// hedged makes a call and, if it has not answered within HedgeDelay, a
+// second identical one, returning the first success. The loser is canceled.
+// Requests that cannot be copied are sent once.
+func (i *interceptor) hedged(ctx context.Context, req connect.AnyRequest, next connect.UnaryFunc) (connect.AnyResponse, error) {
+	// Copied before the first call writes its headers
+	hedge, ok := cloneRequest(req)
+	if !ok {
+		return i.attempt(ctx, req, next)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		resp connect.AnyResponse
+		err  error
+	}
+	results := make(chan result, 2)
+	call := func(req connect.AnyRequest) {
+		resp, err := i.attempt(ctx, req, next)
+		results <- result{resp, err}
+	}
+
+	timer := time.NewTimer(i.cfg.HedgeDelay)
+	defer timer.Stop()
+
+	go call(req)
+	pending, hedgeSent := 1, false
+	var last result
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			hedgeSent = true
+			pending++
+			go call(hedge)
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				return r.resp, nil
+			}
+			if !hedgeSent {
+				// Failed fast; leave retrying to the caller
+				return r.resp, r.err
+			}
+			last = r
+		}
+	}
+	return last.resp, last.err
+}
+
+// cloneRequest returns a copy of req with its own headers. Connect writes
+// per-call headers such as the timeout into the request's header map, so
+// concurrent calls cannot share one. The copy carries the message and
+// headers only; its Spec and Peer are zero, which only interceptors running
+// inside this one would see.
+func cloneRequest(req connect.AnyRequest) (connect.AnyRequest, bool) {
+	v := reflect.ValueOf(req)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return nil, false
+	}
+	msg := v.Elem().FieldByName("Msg")
+	if !msg.IsValid() {
+		return nil, false
+	}
+	clone := reflect.New(v.Elem().Type())
+	clone.Elem().FieldByName("Msg").Set(msg)
+	out, ok := clone.Interface().(connect.AnyRequest)
+	if !ok {
+		return nil, false
+	}
+	for key, values := range req.Header() {
+		out.Header()[key] = slices.Clone(values)
+	}
+	return out, true
+}
+
+// backoff returns the wait before the given retry (1 for the first retry)
+func (c Config) backoff(retry int) time.Duration {
+	bound := c.BaseBackoff << (retry - 1)
+	if bound <= 0 || bound > c.MaxBackoff {
+		bound = c.MaxBackoff
+	}
+	if bound <= 0 {
+		return 0
+	}
+	return rand.N(bound)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
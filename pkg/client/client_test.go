@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestBackoffBounds(t *testing.T) {
+	cfg := Config{BaseBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for retry, bound := range map[int]time.Duration{
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		4:  800 * time.Millisecond,
+		5:  time.Second,
+		70: time.Second, // shift overflow falls back to MaxBackoff
+	} {
+		for i := 0; i < 100; i++ {
+			if d := cfg.backoff(retry); d < 0 || d >= bound {
+				t.Fatalf("retry %d: backoff %s outside [0, %s)", retry, d, bound)
+			}
+		}
+	}
+}
+
+func TestBackoffDisabled(t *testing.T) {
+	if d := (Config{}).backoff(3); d != 0 {
+		t.Errorf("zero config backoff = %s, want 0", d)
+	}
+}
+
+func TestRetriesOnlyIdempotentCalls(t *testing.T) {
+	for level, want := range map[connect.IdempotencyLevel]int{
+		connect.IdempotencyUnknown:       1,
+		connect.IdempotencyIdempotent:    3,
+		connect.IdempotencyNoSideEffects: 3,
+	} {
+		var calls atomic.Int32
+		mux := http.NewServeMux()
+		mux.Handle("/test.v1.Test/Call", connect.NewUnaryHandler("/test.v1.Test/Call",
+			func(context.Context, *connect.Request[emptypb.Empty]) (*connect.Response[emptypb.Empty], error) {
+				calls.Add(1)
+				return nil, connect.NewError(connect.CodeUnavailable, errors.New("restarting"))
+			},
+		))
+		srv := httptest.NewServer(mux)
+
+		cfg := Config{MaxAttempts: 3}
+		c := connect.NewClient[emptypb.Empty, emptypb.Empty](srv.Client(), srv.URL+"/test.v1.Test/Call",
+			connect.WithIdempotency(level), connect.WithInterceptors(NewInterceptor(cfg)),
+		)
+		_, err := c.CallUnary(context.Background(), connect.NewRequest(&emptypb.Empty{}))
+		srv.Close()
+
+		if connect.CodeOf(err) != connect.CodeUnavailable {
+			t.Errorf("%s: got %v, want Unavailable", level, err)
+		}
+		if got := int(calls.Load()); got != want {
+			t.Errorf("%s: %d attempts, want %d", level, got, want)
+		}
+	}
+}
+
+func TestHedgedCallsDoNotShareRequests(t *testing.T) {
+	var calls atomic.Int32
+	mux := http.NewServeMux()
+	mux.Handle("/test.v1.Test/List", connect.NewUnaryHandler("/test.v1.Test/List",
+		func(ctx context.Context, req *connect.Request[emptypb.Empty]) (*connect.Response[emptypb.Empty], error) {
+			if req.Header().Get("X-Caller") != "script" {
+				return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("caller header lost"))
+			}
+			// The first attempt stalls until the hedge wins
+			if calls.Add(1) == 1 {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			return connect.NewResponse(&emptypb.Empty{}), nil
+		},
+		connect.WithIdempotency(connect.IdempotencyNoSideEffects),
+	))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cfg := Config{Timeout: 5 * time.Second, MaxAttempts: 1, HedgeDelay: 20 * time.Millisecond}
+	c := connect.NewClient[emptypb.Empty, emptypb.Empty](srv.Client(), srv.URL+"/test.v1.Test/List",
+		connect.WithIdempotency(connect.IdempotencyNoSideEffects), connect.WithInterceptors(NewInterceptor(cfg)),
+	)
+	req := connect.NewRequest(&emptypb.Empty{})
+	req.Header().Set("X-Caller", "script")
+	if _, err := c.CallUnary(context.Background(), req); err != nil {
+		t.Fatalf("hedged call: %v", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("%d attempts, want the call and its hedge", got)
+	}
+}
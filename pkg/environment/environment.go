@@ -0,0 +1,22 @@
+// Package environment validates the names of environments, which name NATS
+// subjects, streams and buckets and Postgres schemas, so every service
+// accepts the same names.
+package environment
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// name restricts environment names to what is safe in subject, stream,
+// bucket and schema names
+var name = regexp.MustCompile(`^[a-z][a-z0-9-]{0,30}$`)
+
+// Check rejects names that cannot name an environment. The empty name is
+// the default environment.
+func Check(environment string) error {
+	if environment != "" && !name.MatchString(environment) {
+		return fmt.Errorf("invalid environment %q: want lower-case letters, digits and dashes, starting with a letter", environment)
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package environment
+
+import "testing"
+
+func TestCheck(t *testing.T) {
+	for _, name := range []string{"", "dev", "prod-sim", "a1"} {
+		if err := Check(name); err != nil {
+			t.Errorf("environment %q: unexpected error: %v", name, err)
+		}
+	}
+	for _, name := range []string{"Dev", "1dev", "dev.x", "dev>", "dev;drop", "a-very-long-environment-name-indeed"} {
+		if Check(name) == nil {
+			t.Errorf("environment %q should be rejected", name)
+		}
+	}
+}
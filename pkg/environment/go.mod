@@ -0,0 +1,3 @@
+module github.com/microcloud/environment
+
+go 1.23
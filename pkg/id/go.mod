@@ -0,0 +1,3 @@
+module github.com/microcloud/id
+
+go 1.23
@@ -0,0 +1,63 @@
+// Package id mints UUIDs for microcloud entities from crypto/rand.
+package id
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+)
+
+// New returns a random (version 4) UUID
+func New() string {
+	var b [16]byte
+	mustRead(b[:])
+	return format(b, 4)
+}
+
+// NewV7 returns a time-ordered (version 7) UUID. Its first 48 bits are the
+// Unix time in milliseconds so rows keyed by it index in creation order.
+func NewV7() string {
+	return NewV7At(time.Now())
+}
+
+// NewV7At returns a version 7 UUID for a row created at t, for backfilled
+// history that should index among rows of its own time
+func NewV7At(t time.Time) string {
+	var b [16]byte
+	mustRead(b[6:])
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(t.UnixMilli()))
+	copy(b[:6], ms[2:])
+	return format(b, 7)
+}
+
+// FromBytes returns the version 4 UUID made from b, for callers that draw
+// IDs from their own (e.g. seeded) source instead of crypto/rand
+func FromBytes(b [16]byte) string {
+	return format(b, 4)
+}
+
+// format sets the version and RFC 4122 variant bits and renders b
+func format(b [16]byte, version byte) string {
+	b[6] = b[6]&0x0f | version<<4
+	b[8] = b[8]&0x3f | 0x80
+
+	var s [36]byte
+	hex.Encode(s[0:8], b[0:4])
+	s[8] = '-'
+	hex.Encode(s[9:13], b[4:6])
+	s[13] = '-'
+	hex.Encode(s[14:18], b[6:8])
+	s[18] = '-'
+	hex.Encode(s[19:23], b[8:10])
+	s[23] = '-'
+	hex.Encode(s[24:], b[10:])
+	return string(s[:])
+}
+
+func mustRead(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic("id: crypto/rand failed: " + err.Error())
+	}
+}
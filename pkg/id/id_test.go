@@ -0,0 +1,79 @@
+package id
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-([47])[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestFormat(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		gen     func() string
+		version string
+	}{
+		{"v4", New, "4"},
+		{"v7", NewV7, "7"},
+	} {
+		got := tc.gen()
+		m := uuidPattern.FindStringSubmatch(got)
+		if m == nil {
+			t.Fatalf("%s: malformed UUID %q", tc.name, got)
+		}
+		if m[1] != tc.version {
+			t.Errorf("%s: version %s in %q", tc.name, m[1], got)
+		}
+	}
+}
+
+func TestUnique(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 10000; i++ {
+		for _, u := range []string{New(), NewV7()} {
+			if seen[u] {
+				t.Fatalf("duplicate UUID %q after %d rounds", u, i)
+			}
+			seen[u] = true
+		}
+	}
+}
+
+func TestV7Ordered(t *testing.T) {
+	var ids []string
+	for i := 0; i < 3; i++ {
+		ids = append(ids, NewV7())
+		time.Sleep(2 * time.Millisecond)
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Errorf("v7 UUIDs not in creation order: %v", ids)
+	}
+}
+
+func TestV7At(t *testing.T) {
+	past := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	old, now := NewV7At(past), NewV7()
+	if old >= now {
+		t.Errorf("v7 UUID for %v sorts after one minted now: %s >= %s", past, old, now)
+	}
+	if !strings.HasPrefix(old, "018cc251-f400-7") {
+		t.Errorf("unexpected timestamp bits: %s", old)
+	}
+}
+
+func TestFromBytes(t *testing.T) {
+	var b [16]byte
+	for i := range b {
+		b[i] = 0xff
+	}
+	got := FromBytes(b)
+	if want := "ffffffff-ffff-4fff-bfff-ffffffffffff"; got != want {
+		t.Errorf("FromBytes = %q, want %q", got, want)
+	}
+	if FromBytes(b) != got {
+		t.Error("FromBytes is not deterministic")
+	}
+}
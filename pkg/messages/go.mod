@@ -0,0 +1,3 @@
+module github.com/microcloud/messages
+
+go 1.23
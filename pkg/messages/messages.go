@@ -0,0 +1,125 @@
+// Package messages is the catalog of user-facing strings for incidents and
+// actions. Services store a key and its arguments alongside the rendered
+// English text so frontends can localize and queries can match on the key.
+package messages
+
+import (
+	"sort"
+	"strings"
+)
+
+// Message is a catalog key with the arguments for its placeholders
+type Message struct {
+	Key  string
+	Args map[string]string
+}
+
+// Incident messages
+const (
+	IncidentThresholdTitle         = "incident.threshold.title"
+	IncidentThresholdDescription   = "incident.threshold.description"
+	IncidentMergedDescription      = "incident.merged.description"
+	IncidentStormTitle             = "incident.storm.title"
+	IncidentStormDescription       = "incident.storm.description"
+	IncidentAnomalyTitle           = "incident.anomaly.title"
+	IncidentAnomalyDescription     = "incident.anomaly.description"
+	IncidentBaselineDescription    = "incident.baseline.description"
+	IncidentRateDescription        = "incident.rate.description"
+	IncidentCorrelatedTitle        = "incident.correlated.title"
+	IncidentCorrelatedDescription  = "incident.correlated.description"
+	IncidentConfigDriftTitle       = "incident.config_drift.title"
+	IncidentConfigDriftDescription = "incident.config_drift.description"
+	IncidentNoDataTitle            = "incident.no_data.title"
+	IncidentNoDataDescription      = "incident.no_data.description"
+)
+
+// Action reason messages
+const (
+	ActionTemplate       = "action.template"
+	ActionCircuitBreaker = "action.circuit_breaker.dependency_errors"
+	ActionRestartErrors  = "action.restart.error_rate"
+	ActionRestartMemory  = "action.restart.memory"
+	ActionScaleUpCPU     = "action.scale_up.cpu"
+	ActionScaleUpLatency = "action.scale_up.latency"
+	ActionRebalanceCPU   = "action.rebalance.cpu"
+	ActionRebootMemory   = "action.reboot.memory"
+	ActionScaleNodePool  = "action.scale_node_pool.memory"
+	ActionCordonDisk     = "action.cordon.disk"
+)
+
+// English is the default catalog. Placeholders are written {name} and
+// filled from Message.Args.
+var English = map[string]string{
+	IncidentThresholdTitle:         "{rule}: {metric} on {entity_type} {entity}",
+	IncidentThresholdDescription:   "{metric} breached threshold {threshold} (current: {value}) for {window} seconds in {region}",
+	IncidentMergedDescription:      "Merged from {count} incidents",
+	IncidentStormTitle:             "Incident storm: {count} incidents in {window}",
+	IncidentStormDescription:       "{count} incidents were raised within {window} (threshold {threshold}) across {entities} entities",
+	IncidentAnomalyTitle:           "Anomaly: {metric} on {entity_type} {entity}",
+	IncidentAnomalyDescription:     "{metric} at {value} is {sigma} standard deviations from its rolling mean {mean} (stddev {stddev}) in {region}",
+	IncidentBaselineDescription:    "{metric} at {value} is {deviation} standard deviations from its baseline {expected} for this time of day, past {threshold} for {window} seconds in {region}",
+	IncidentRateDescription:        "{metric} is changing by {rate} per minute (now {value}), past {threshold} per minute over {window} seconds in {region}",
+	IncidentCorrelatedTitle:        "{count} related incidents around {entity_type} {entity}",
+	IncidentCorrelatedDescription:  "{count} incidents on {entities} entities sharing a node or dependency chain with {entity_type} {entity}, starting with {rule}",
+	IncidentConfigDriftTitle:       "Configuration drift on {service} {instance}",
+	IncidentConfigDriftDescription: "{count} settings differ from the desired state: {keys}",
+	IncidentNoDataTitle:            "No data from {entity_type} {entity}",
+	IncidentNoDataDescription:      "{entity_type} {entity} has been missing from metric snapshots for {seconds} seconds, last seen {last_seen} in {region}",
+
+	ActionTemplate:       "{action_type} for {rule} with {params}",
+	ActionCircuitBreaker: "Enable circuit breaker due to {rule} from a failing dependency (error rate: {error_rate}%, dependency: {dependency_error_rate}%)",
+	ActionRestartErrors:  "Auto-restart due to {rule} (error rate: {error_rate}%)",
+	ActionRestartMemory:  "Restart due to high memory usage ({memory}%)",
+	ActionScaleUpCPU:     "Scale up due to critical CPU ({cpu}%)",
+	ActionScaleUpLatency: "Scale up due to high latency ({latency}ms)",
+	ActionRebalanceCPU:   "Rebalance traffic due to high CPU ({cpu}%)",
+	ActionRebootMemory:   "Reboot node due to high memory usage ({memory}%)",
+	ActionScaleNodePool:  "Add a node to the pool: memory {requested}% reserved by replicas ({memory}% in use)",
+	ActionCordonDisk:     "Cordon node due to high disk usage ({disk}%)",
+}
+
+// New creates a message from key and alternating name, value arguments
+func New(key string, args ...string) Message {
+	m := Message{Key: key, Args: make(map[string]string, len(args)/2)}
+	for i := 0; i+1 < len(args); i += 2 {
+		m.Args[args[i]] = args[i+1]
+	}
+	return m
+}
+
+// String renders the message with the English catalog
+func (m Message) String() string {
+	return Render(English, m)
+}
+
+// Render fills the template for m.Key from catalog. Unknown keys fall back
+// to the key followed by its arguments so nothing is silently dropped, and
+// placeholders without an argument are left as is.
+func Render(catalog map[string]string, m Message) string {
+	tmpl, ok := catalog[m.Key]
+	if !ok {
+		return fallback(m)
+	}
+
+	pairs := make([]string, 0, 2*len(m.Args))
+	for name, value := range m.Args {
+		pairs = append(pairs, "{"+name+"}", value)
+	}
+	return strings.NewReplacer(pairs...).Replace(tmpl)
+}
+
+func fallback(m Message) string {
+	if len(m.Args) == 0 {
+		return m.Key
+	}
+	names := make([]string, 0, len(m.Args))
+	for name := range m.Args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+m.Args[name])
+	}
+	return m.Key + " (" + strings.Join(pairs, ", ") + ")"
+}
@@ -0,0 +1,37 @@
+package messages
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderEnglish(t *testing.T) {
+	m := New(ActionRestartErrors, "rule", "high_error_rate", "error_rate", "12.50")
+	want := "Auto-restart due to high_error_rate (error rate: 12.50%)"
+	if got := m.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRenderOtherCatalog(t *testing.T) {
+	catalog := map[string]string{ActionScaleUpCPU: "Hochskalieren wegen CPU ({cpu}%)"}
+	got := Render(catalog, New(ActionScaleUpCPU, "cpu", "97.00"))
+	if got != "Hochskalieren wegen CPU (97.00%)" {
+		t.Errorf("unexpected rendering: %q", got)
+	}
+}
+
+func TestRenderUnknownKey(t *testing.T) {
+	got := Render(English, New("action.unknown", "b", "2", "a", "1"))
+	if got != "action.unknown (a=1, b=2)" {
+		t.Errorf("unexpected fallback: %q", got)
+	}
+}
+
+func TestEnglishPlaceholdersBalanced(t *testing.T) {
+	for key, tmpl := range English {
+		if strings.Count(tmpl, "{") != strings.Count(tmpl, "}") {
+			t.Errorf("%s: unbalanced placeholders in %q", key, tmpl)
+		}
+	}
+}
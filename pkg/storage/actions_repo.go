@@ -2,10 +2,13 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+
+	"github.com/microcloud/storage/queries"
 )
 
 // ActionRow represents an action in the database
@@ -21,29 +24,45 @@ type ActionRow struct {
 	CreatedAt      time.Time
 	ExecutedAt     *time.Time
 	ResultMessage  string
+	ReasonKey      string            // message catalog key for Reason
+	ReasonArgs     map[string]string // arguments for ReasonKey
 }
 
 // ActionsRepository handles action persistence
 type ActionsRepository struct {
-	db *DB
+	conn conn
 }
 
 // NewActionsRepository creates a new actions repository
 func NewActionsRepository(db *DB) *ActionsRepository {
-	return &ActionsRepository{db: db}
+	return &ActionsRepository{conn: db.pool}
+}
+
+// WithTx returns a copy of the repository that runs in tx
+func (r *ActionsRepository) WithTx(tx pgx.Tx) *ActionsRepository {
+	return &ActionsRepository{conn: tx}
 }
 
 // Create inserts a new action
 func (r *ActionsRepository) Create(ctx context.Context, action ActionRow) error {
+	if err := checkEnum("action_type", action.ActionType, maxActionType); err != nil {
+		return fmt.Errorf("create action: %w", err)
+	}
+	if err := checkEnum("status", action.Status, maxActionStatus); err != nil {
+		return fmt.Errorf("create action: %w", err)
+	}
+
 	query := `
 		INSERT INTO actions (id, incident_id, proposed_at_tick, action_type, target_id,
-							status, reason, parameters, created_at, executed_at, result_message)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+							status, reason, parameters, created_at, executed_at, result_message,
+							reason_key, reason_args)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 	`
-	_, err := r.db.pool.Exec(ctx, query,
+	_, err := r.conn.Exec(ctx, query,
 		action.ID, action.IncidentID, action.ProposedAtTick, action.ActionType,
 		action.TargetID, action.Status, action.Reason, action.Parameters,
 		action.CreatedAt, action.ExecutedAt, action.ResultMessage,
+		action.ReasonKey, action.ReasonArgs,
 	)
 	if err != nil {
 		return fmt.Errorf("create action: %w", err)
@@ -51,24 +70,37 @@ func (r *ActionsRepository) Create(ctx context.Context, action ActionRow) error
 	return nil
 }
 
-// GetByID retrieves an action by ID
+// GetByID retrieves an action by ID. It returns ErrNotFound if there is no
+// such action.
 func (r *ActionsRepository) GetByID(ctx context.Context, id string) (*ActionRow, error) {
+	return r.getByID(ctx, id, "")
+}
+
+// GetByIDForUpdate is GetByID, also locking the row until the transaction
+// ends. Use it on a repository from DB.WithTx.
+func (r *ActionsRepository) GetByIDForUpdate(ctx context.Context, id string) (*ActionRow, error) {
+	return r.getByID(ctx, id, " FOR UPDATE")
+}
+
+func (r *ActionsRepository) getByID(ctx context.Context, id, lock string) (*ActionRow, error) {
 	query := `
 		SELECT id, incident_id, proposed_at_tick, action_type, target_id,
-			   status, reason, parameters, created_at, executed_at, result_message
-		FROM actions WHERE id = $1
-	`
+			   status, reason, parameters, created_at, executed_at, result_message,
+			   reason_key, reason_args
+		FROM actions WHERE id = $1` + lock
 	var a ActionRow
-	err := r.db.pool.QueryRow(ctx, query, id).Scan(
+	err := r.conn.QueryRow(ctx, query, id).Scan(
 		&a.ID, &a.IncidentID, &a.ProposedAtTick, &a.ActionType, &a.TargetID,
 		&a.Status, &a.Reason, &a.Parameters, &a.CreatedAt, &a.ExecutedAt, &a.ResultMessage,
+		&a.ReasonKey, &a.ReasonArgs,
 	)
-	if err == pgx.ErrNoRows {
-		return nil, nil
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("get action %s: %w", id, ErrNotFound)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("get action: %w", err)
 	}
+	coerceAction(&a)
 	return &a, nil
 }
 
@@ -76,7 +108,8 @@ func (r *ActionsRepository) GetByID(ctx context.Context, id string) (*ActionRow,
 func (r *ActionsRepository) ListPending(ctx context.Context, limit int) ([]ActionRow, error) {
 	query := `
 		SELECT id, incident_id, proposed_at_tick, action_type, target_id,
-			   status, reason, parameters, created_at, executed_at, result_message
+			   status, reason, parameters, created_at, executed_at, result_message,
+			   reason_key, reason_args
 		FROM actions
 		WHERE status = 1
 		ORDER BY created_at ASC
@@ -89,7 +122,8 @@ func (r *ActionsRepository) ListPending(ctx context.Context, limit int) ([]Actio
 func (r *ActionsRepository) ListByStatus(ctx context.Context, status int, limit int) ([]ActionRow, error) {
 	query := `
 		SELECT id, incident_id, proposed_at_tick, action_type, target_id,
-			   status, reason, parameters, created_at, executed_at, result_message
+			   status, reason, parameters, created_at, executed_at, result_message,
+			   reason_key, reason_args
 		FROM actions
 		WHERE status = $1
 		ORDER BY created_at DESC
@@ -102,7 +136,8 @@ func (r *ActionsRepository) ListByStatus(ctx context.Context, status int, limit
 func (r *ActionsRepository) ListByIncident(ctx context.Context, incidentID string) ([]ActionRow, error) {
 	query := `
 		SELECT id, incident_id, proposed_at_tick, action_type, target_id,
-			   status, reason, parameters, created_at, executed_at, result_message
+			   status, reason, parameters, created_at, executed_at, result_message,
+			   reason_key, reason_args
 		FROM actions
 		WHERE incident_id = $1
 		ORDER BY created_at ASC
@@ -110,11 +145,27 @@ func (r *ActionsRepository) ListByIncident(ctx context.Context, incidentID strin
 	return r.queryActions(ctx, query, incidentID)
 }
 
+// ListByTarget returns the actions on a node or service created since the
+// given time, newest first
+func (r *ActionsRepository) ListByTarget(ctx context.Context, targetID string, since time.Time, limit int) ([]ActionRow, error) {
+	query := `
+		SELECT id, incident_id, proposed_at_tick, action_type, target_id,
+			   status, reason, parameters, created_at, executed_at, result_message,
+			   reason_key, reason_args
+		FROM actions
+		WHERE target_id = $1 AND created_at >= $2
+		ORDER BY created_at DESC
+		LIMIT $3
+	`
+	return r.queryActions(ctx, query, targetID, since, limit)
+}
+
 // ListRecent returns recent actions
 func (r *ActionsRepository) ListRecent(ctx context.Context, limit int) ([]ActionRow, error) {
 	query := `
 		SELECT id, incident_id, proposed_at_tick, action_type, target_id,
-			   status, reason, parameters, created_at, executed_at, result_message
+			   status, reason, parameters, created_at, executed_at, result_message,
+			   reason_key, reason_args
 		FROM actions
 		ORDER BY created_at DESC
 		LIMIT $1
@@ -122,13 +173,24 @@ func (r *ActionsRepository) ListRecent(ctx context.Context, limit int) ([]Action
 	return r.queryActions(ctx, query, limit)
 }
 
-// UpdateStatus updates an action's status
+// UpdateStatus updates an action's status. It returns ErrNotFound if there
+// is no such action.
 func (r *ActionsRepository) UpdateStatus(ctx context.Context, id string, status int, resultMessage string) error {
-	query := `UPDATE actions SET status = $2, result_message = $3, executed_at = $4 WHERE id = $1`
-	_, err := r.db.pool.Exec(ctx, query, id, status, resultMessage, time.Now())
+	if err := checkEnum("status", status, maxActionStatus); err != nil {
+		return fmt.Errorf("update action status: %w", err)
+	}
+	n, err := queries.New(r.conn).UpdateActionStatus(ctx, queries.UpdateActionStatusParams{
+		ID:            id,
+		Status:        int32(status),
+		ResultMessage: resultMessage,
+		ExecutedAt:    time.Now(),
+	})
 	if err != nil {
 		return fmt.Errorf("update action status: %w", err)
 	}
+	if n == 0 {
+		return fmt.Errorf("update action %s status: %w", id, ErrNotFound)
+	}
 	return nil
 }
 
@@ -157,8 +219,16 @@ func (r *ActionsRepository) MarkFailed(ctx context.Context, id string, errorMess
 	return r.UpdateStatus(ctx, id, 6, errorMessage)
 }
 
+// queryActions runs a list query. If iteration fails part way (for example
+// when ctx expires), the rows scanned so far are returned with the error.
+// Version returns a counter that changes whenever the actions table is
+// written, for conditional reads of action lists
+func (r *ActionsRepository) Version(ctx context.Context) (int64, error) {
+	return tableVersion(ctx, r.conn, "actions")
+}
+
 func (r *ActionsRepository) queryActions(ctx context.Context, query string, args ...any) ([]ActionRow, error) {
-	rows, err := r.db.pool.Query(ctx, query, args...)
+	rows, err := r.conn.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("query actions: %w", err)
 	}
@@ -170,9 +240,11 @@ func (r *ActionsRepository) queryActions(ctx context.Context, query string, args
 		if err := rows.Scan(
 			&a.ID, &a.IncidentID, &a.ProposedAtTick, &a.ActionType, &a.TargetID,
 			&a.Status, &a.Reason, &a.Parameters, &a.CreatedAt, &a.ExecutedAt, &a.ResultMessage,
+			&a.ReasonKey, &a.ReasonArgs,
 		); err != nil {
-			return nil, fmt.Errorf("scan action: %w", err)
+			return results, fmt.Errorf("scan action: %w", err)
 		}
+		coerceAction(&a)
 		results = append(results, a)
 	}
 	return results, rows.Err()
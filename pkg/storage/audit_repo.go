@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/microcloud/storage/queries"
+)
+
+// Audit operations recorded for operator changes to incidents
+const (
+	AuditMerge      = "merge"       // incident was created by merging RelatedIDs
+	AuditMergedInto = "merged_into" // incident was folded into RelatedIDs[0]
+	AuditSplit      = "split"       // incident was split into RelatedIDs
+	AuditSplitFrom  = "split_from"  // incident was carved out of RelatedIDs[0]
+
+	AuditFalsePositive = "false_positive" // operator reported the incident should not have fired
+	AuditLabel         = "label"          // operator labeled the incident; Reason holds the label
+)
+
+// AuditRow represents an incident audit entry in the database
+type AuditRow struct {
+	ID         int64
+	IncidentID string
+	Operation  string
+	Actor      string
+	Reason     string
+	RelatedIDs []string
+	CreatedAt  time.Time
+}
+
+// ListAudit returns the audit trail for an incident, oldest first
+func (r *IncidentsRepository) ListAudit(ctx context.Context, incidentID string) ([]AuditRow, error) {
+	rows, err := queries.New(r.conn).ListIncidentAudit(ctx, incidentID)
+	if err != nil {
+		return nil, fmt.Errorf("query audit: %w", err)
+	}
+
+	results := make([]AuditRow, 0, len(rows))
+	for _, a := range rows {
+		results = append(results, AuditRow{
+			ID:         a.ID,
+			IncidentID: a.IncidentID,
+			Operation:  a.Operation,
+			Actor:      a.Actor,
+			Reason:     a.Reason,
+			RelatedIDs: a.RelatedIds,
+			CreatedAt:  a.CreatedAt,
+		})
+	}
+	return results, nil
+}
+
+func insertAudit(ctx context.Context, q *queries.Queries, a AuditRow) error {
+	err := q.InsertIncidentAudit(ctx, queries.InsertIncidentAuditParams{
+		IncidentID: a.IncidentID,
+		Operation:  a.Operation,
+		Actor:      a.Actor,
+		Reason:     a.Reason,
+		RelatedIds: a.RelatedIDs,
+		CreatedAt:  time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("insert audit %s: %w", a.Operation, err)
+	}
+	return nil
+}
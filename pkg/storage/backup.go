@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// LastTickID returns the highest tick recorded in the metrics table, or 0
+// if it is empty
+func (db *DB) LastTickID(ctx context.Context) (int64, error) {
+	var tick int64
+	err := db.pool.QueryRow(ctx, `SELECT COALESCE(MAX(tick_id), 0) FROM metrics`).Scan(&tick)
+	if err != nil {
+		return 0, fmt.Errorf("query last tick: %w", err)
+	}
+	return tick, nil
+}
+
+// Dump writes a pg_dump archive (custom format) of the whole database to
+// path, with every environment's schema. TimescaleDB keeps hypertable chunks
+// and its catalog in schemas of its own, and restores only whole databases,
+// so an environment cannot be dumped alone. pg_dump must be on PATH.
+func Dump(ctx context.Context, cfg Config, path string) error {
+	return runPGTool(ctx, "pg_dump", cfg, dumpArgs(cfg, path))
+}
+
+// Restore replaces the database contents, of every environment, with a
+// Dump archive. TimescaleDB requires restore mode around pg_restore, so db
+// must be connected to the same database. pg_restore must be on PATH.
+func (db *DB) Restore(ctx context.Context, cfg Config, path string) error {
+	if _, err := db.pool.Exec(ctx, `SELECT timescaledb_pre_restore()`); err != nil {
+		return fmt.Errorf("enter restore mode: %w", err)
+	}
+	restoreErr := runPGTool(ctx, "pg_restore", cfg, restoreArgs(cfg, path))
+
+	// Leave restore mode even if pg_restore failed, or writes stay disabled
+	if _, err := db.pool.Exec(ctx, `SELECT timescaledb_post_restore()`); err != nil {
+		return fmt.Errorf("leave restore mode: %w", err)
+	}
+	return restoreErr
+}
+
+func dumpArgs(cfg Config, path string) []string {
+	return []string{"--format=custom", "--file=" + path, "--dbname=" + toolDSN(cfg)}
+}
+
+func restoreArgs(cfg Config, path string) []string {
+	return []string{"--clean", "--if-exists", "--no-owner", "--dbname=" + toolDSN(cfg), path}
+}
+
+// toolDSN is the DSN without the password, which the tools get from
+// PGPASSWORD instead so it does not show in the process list
+func toolDSN(cfg Config) string {
+	return fmt.Sprintf(
+		"postgres://%s@%s:%d/%s?sslmode=%s",
+		cfg.User, cfg.Host, cfg.Port, cfg.Database, cfg.SSLMode,
+	)
+}
+
+func runPGTool(ctx context.Context, name string, cfg Config, args []string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), "PGPASSWORD="+cfg.Password)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -18,6 +19,10 @@ type Config struct {
 	MaxConns        int32
 	MinConns        int32
 	MaxConnLifetime time.Duration
+
+	// Environment keeps a named sandbox (dev, staging, prod-sim) in its own
+	// schema of the database. Empty uses the public schema.
+	Environment string
 }
 
 // DefaultConfig returns sensible defaults
@@ -58,9 +63,19 @@ func ConfigFromEnv() Config {
 	if v := os.Getenv("DB_SSLMODE"); v != "" {
 		cfg.SSLMode = v
 	}
+	cfg.Environment = os.Getenv("ENVIRONMENT")
 	return cfg
 }
 
+// Schema returns the schema holding the environment's tables, or "" for the
+// default environment
+func (c Config) Schema() string {
+	if c.Environment == "" {
+		return ""
+	}
+	return "env_" + strings.ReplaceAll(c.Environment, "-", "_")
+}
+
 // DSN returns the connection string
 func (c Config) DSN() string {
 	return fmt.Sprintf(
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// DanglingRef is a row whose reference column points at an incident that
+// no longer exists
+type DanglingRef struct {
+	Table    string
+	Column   string
+	RowID    string
+	TargetID string
+}
+
+// ConsistencyReport lists the dangling references found by CheckConsistency
+// and how many rows were repaired (always 0 on a dry run)
+type ConsistencyReport struct {
+	Dangling []DanglingRef
+	Repaired int64
+}
+
+// refCheck finds one kind of dangling reference. find returns (row ID,
+// target ID) pairs; repair clears or removes the same rows.
+type refCheck struct {
+	table  string
+	column string
+	find   string
+	repair string
+}
+
+// refChecks covers every column that references incidents. actions has a
+// foreign key, but it is checked too since restores and manual fixes can
+// bypass it.
+var refChecks = []refCheck{
+	{
+		table:  "actions",
+		column: "incident_id",
+		find: `SELECT a.id::text, a.incident_id::text FROM actions a
+			WHERE a.incident_id IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM incidents i WHERE i.id = a.incident_id)`,
+		repair: `UPDATE actions a SET incident_id = NULL
+			WHERE a.incident_id IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM incidents i WHERE i.id = a.incident_id)`,
+	},
+	{
+		table:  "incidents",
+		column: "merged_into",
+		find: `SELECT c.id::text, c.merged_into::text FROM incidents c
+			WHERE c.merged_into IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM incidents i WHERE i.id = c.merged_into)`,
+		repair: `UPDATE incidents c SET merged_into = NULL
+			WHERE c.merged_into IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM incidents i WHERE i.id = c.merged_into)`,
+	},
+	{
+		table:  "incidents",
+		column: "split_from",
+		find: `SELECT c.id::text, c.split_from::text FROM incidents c
+			WHERE c.split_from IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM incidents i WHERE i.id = c.split_from)`,
+		repair: `UPDATE incidents c SET split_from = NULL
+			WHERE c.split_from IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM incidents i WHERE i.id = c.split_from)`,
+	},
+	{
+		table:  "incidents",
+		column: "parent_id",
+		find: `SELECT c.id::text, c.parent_id::text FROM incidents c
+			WHERE c.parent_id IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM incidents i WHERE i.id = c.parent_id)`,
+		repair: `UPDATE incidents c SET parent_id = NULL
+			WHERE c.parent_id IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM incidents i WHERE i.id = c.parent_id)`,
+	},
+	{
+		table:  "incident_audit",
+		column: "incident_id",
+		find: `SELECT a.id::text, a.incident_id::text FROM incident_audit a
+			WHERE NOT EXISTS (SELECT 1 FROM incidents i WHERE i.id = a.incident_id)`,
+		repair: `DELETE FROM incident_audit a
+			WHERE NOT EXISTS (SELECT 1 FROM incidents i WHERE i.id = a.incident_id)`,
+	},
+	{
+		table:  "incident_labels",
+		column: "incident_id",
+		find: `SELECT l.incident_id::text, l.incident_id::text FROM incident_labels l
+			WHERE NOT EXISTS (SELECT 1 FROM incidents i WHERE i.id = l.incident_id)`,
+		repair: `DELETE FROM incident_labels l
+			WHERE NOT EXISTS (SELECT 1 FROM incidents i WHERE i.id = l.incident_id)`,
+	},
+}
+
+// CheckConsistency reports rows referencing incidents that no longer exist.
+// With repair set, dangling references are cleared (audit entries and labels
+// of missing incidents are deleted) in the same transaction as the check.
+func (db *DB) CheckConsistency(ctx context.Context, repair bool) (ConsistencyReport, error) {
+	var report ConsistencyReport
+
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return report, fmt.Errorf("begin consistency check: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, c := range refChecks {
+		rows, err := tx.Query(ctx, c.find)
+		if err != nil {
+			return report, fmt.Errorf("check %s.%s: %w", c.table, c.column, err)
+		}
+		for rows.Next() {
+			ref := DanglingRef{Table: c.table, Column: c.column}
+			if err := rows.Scan(&ref.RowID, &ref.TargetID); err != nil {
+				rows.Close()
+				return report, fmt.Errorf("scan %s.%s: %w", c.table, c.column, err)
+			}
+			report.Dangling = append(report.Dangling, ref)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return report, fmt.Errorf("check %s.%s: %w", c.table, c.column, err)
+		}
+
+		if !repair {
+			continue
+		}
+		tag, err := tx.Exec(ctx, c.repair)
+		if err != nil {
+			return report, fmt.Errorf("repair %s.%s: %w", c.table, c.column, err)
+		}
+		report.Repaired += tag.RowsAffected()
+	}
+
+	if repair {
+		if err := tx.Commit(ctx); err != nil {
+			return report, fmt.Errorf("commit repair: %w", err)
+		}
+	}
+	return report, nil
+}
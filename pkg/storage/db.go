@@ -5,16 +5,24 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/microcloud/environment"
 )
 
 // DB wraps a pgx connection pool
 type DB struct {
-	pool *pgxpool.Pool
+	pool   *pgxpool.Pool
+	schema string // the environment's schema, "" for public
 }
 
-// New creates a new database connection pool
+// New creates a new database connection pool. Connections of a named
+// environment resolve tables in its schema first.
 func New(ctx context.Context, cfg Config) (*DB, error) {
+	if err := environment.Check(cfg.Environment); err != nil {
+		return nil, err
+	}
 	poolCfg, err := pgxpool.ParseConfig(cfg.DSN())
 	if err != nil {
 		return nil, fmt.Errorf("parse config: %w", err)
@@ -23,6 +31,11 @@ func New(ctx context.Context, cfg Config) (*DB, error) {
 	poolCfg.MaxConns = cfg.MaxConns
 	poolCfg.MinConns = cfg.MinConns
 	poolCfg.MaxConnLifetime = cfg.MaxConnLifetime
+	schema := cfg.Schema()
+	if schema != "" {
+		// public stays on the path for the TimescaleDB functions
+		poolCfg.ConnConfig.RuntimeParams["search_path"] = schema + ", public"
+	}
 
 	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
 	if err != nil {
@@ -34,7 +47,7 @@ func New(ctx context.Context, cfg Config) (*DB, error) {
 		return nil, fmt.Errorf("ping database: %w", err)
 	}
 
-	return &DB{pool: pool}, nil
+	return &DB{pool: pool, schema: schema}, nil
 }
 
 // Close closes the connection pool
@@ -42,6 +55,11 @@ func (db *DB) Close() {
 	db.pool.Close()
 }
 
+// Ping checks the database is reachable, for health checks
+func (db *DB) Ping(ctx context.Context) error {
+	return db.pool.Ping(ctx)
+}
+
 // Pool returns the underlying pgx pool for advanced usage
 func (db *DB) Pool() *pgxpool.Pool {
 	return db.pool
@@ -50,8 +68,9 @@ func (db *DB) Pool() *pgxpool.Pool {
 // Migrate runs database migrations
 func (db *DB) Migrate(ctx context.Context) error {
 	migrations := []string{
-		// Enable TimescaleDB extension
-		`CREATE EXTENSION IF NOT EXISTS timescaledb CASCADE`,
+		// Enable TimescaleDB extension. It is shared by every environment,
+		// so it must not land in the first one's schema.
+		`CREATE EXTENSION IF NOT EXISTS timescaledb SCHEMA public CASCADE`,
 
 		// Metrics hypertable
 		`CREATE TABLE IF NOT EXISTS metrics (
@@ -96,11 +115,148 @@ func (db *DB) Migrate(ctx context.Context) error {
 			result_message TEXT
 		)`,
 
+		// Incident merge/split references
+		`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS merged_into UUID`,
+		`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS split_from UUID`,
+
+		// Message catalog keys for user-facing strings
+		`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS title_key TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS title_args JSONB`,
+		`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS description_key TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS description_args JSONB`,
+		`ALTER TABLE actions ADD COLUMN IF NOT EXISTS reason_key TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE actions ADD COLUMN IF NOT EXISTS reason_args JSONB`,
+
+		// Incident deduplication
+		`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS fingerprint TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS occurrences INT NOT NULL DEFAULT 1`,
+
+		// Incident correlation
+		`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS parent_id UUID`,
+
+		// Severity escalation
+		`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS escalated_at TIMESTAMPTZ`,
+
+		// Incident audit trail
+		`CREATE TABLE IF NOT EXISTS incident_audit (
+			id BIGSERIAL PRIMARY KEY,
+			incident_id UUID NOT NULL,
+			operation TEXT NOT NULL,
+			actor TEXT NOT NULL DEFAULT '',
+			reason TEXT NOT NULL DEFAULT '',
+			related_ids TEXT[],
+			created_at TIMESTAMPTZ NOT NULL
+		)`,
+
+		// Detection rules managed at runtime through RuleService
+		`CREATE TABLE IF NOT EXISTS detection_rules (
+			name TEXT PRIMARY KEY,
+			metric_name TEXT NOT NULL,
+			operator TEXT NOT NULL,
+			threshold DOUBLE PRECISION NOT NULL,
+			window_seconds INT NOT NULL,
+			severity INT NOT NULL,
+			region TEXT NOT NULL DEFAULT '',
+			enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at TIMESTAMPTZ NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL
+		)`,
+		`ALTER TABLE detection_rules ADD COLUMN IF NOT EXISTS type TEXT NOT NULL DEFAULT 'threshold'`,
+		`ALTER TABLE detection_rules ADD COLUMN IF NOT EXISTS trigger_ratio DOUBLE PRECISION NOT NULL DEFAULT 0`,
+		`ALTER TABLE detection_rules ADD COLUMN IF NOT EXISTS clear_ratio DOUBLE PRECISION NOT NULL DEFAULT 0`,
+		`ALTER TABLE detection_rules ADD COLUMN IF NOT EXISTS clear_seconds INT NOT NULL DEFAULT 0`,
+		`ALTER TABLE detection_rules ADD COLUMN IF NOT EXISTS selector JSONB NOT NULL DEFAULT '{}'`,
+		`ALTER TABLE detection_rules ADD COLUMN IF NOT EXISTS overrides JSONB NOT NULL DEFAULT '[]'`,
+		`ALTER TABLE detection_rules ADD COLUMN IF NOT EXISTS min_samples INT NOT NULL DEFAULT 0`,
+		`ALTER TABLE detection_rules ADD COLUMN IF NOT EXISTS evaluate_every_seconds INT NOT NULL DEFAULT 0`,
+
+		// Maintenance windows during which matching incidents are not
+		// published
+		`CREATE TABLE IF NOT EXISTS suppression_windows (
+			id UUID PRIMARY KEY,
+			entity_id TEXT NOT NULL DEFAULT '',
+			rule_name TEXT NOT NULL DEFAULT '',
+			starts_at TIMESTAMPTZ NOT NULL,
+			ends_at TIMESTAMPTZ NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			created_by TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL
+		)`,
+
+		// Per-rule counters summed across replicas, for judging rule quality
+		`CREATE TABLE IF NOT EXISTS rule_stats (
+			rule_name TEXT PRIMARY KEY,
+			evaluations BIGINT NOT NULL DEFAULT 0,
+			breaches BIGINT NOT NULL DEFAULT 0,
+			incidents_fired BIGINT NOT NULL DEFAULT 0,
+			false_positives BIGINT NOT NULL DEFAULT 0,
+			updated_at TIMESTAMPTZ NOT NULL
+		)`,
+
+		// Operator verdicts on incidents, exported as training data
+		`CREATE TABLE IF NOT EXISTS incident_labels (
+			incident_id UUID PRIMARY KEY,
+			label TEXT NOT NULL,
+			operator TEXT NOT NULL DEFAULT '',
+			note TEXT NOT NULL DEFAULT '',
+			labeled_at TIMESTAMPTZ NOT NULL
+		)`,
+
+		// Decisions of the orchestrator's action policies, kept for audit
+		`CREATE TABLE IF NOT EXISTS policy_decisions (
+			id UUID PRIMARY KEY,
+			action_id UUID NOT NULL,
+			kind TEXT NOT NULL,
+			allowed BOOLEAN NOT NULL,
+			reasons TEXT[],
+			actor TEXT NOT NULL DEFAULT '',
+			bundle_revision TEXT NOT NULL DEFAULT '',
+			input JSONB NOT NULL,
+			result JSONB,
+			decided_at TIMESTAMPTZ NOT NULL
+		)`,
+
+		// Write counters behind conditional reads (see Version). Bumped in
+		// the writing transaction, so a reader never sees a version ahead
+		// of the rows it describes.
+		`CREATE TABLE IF NOT EXISTS table_versions (
+			name TEXT PRIMARY KEY,
+			version BIGINT NOT NULL
+		)`,
+		`CREATE OR REPLACE FUNCTION bump_table_version() RETURNS trigger AS $$
+		BEGIN
+			INSERT INTO table_versions (name, version) VALUES (TG_TABLE_NAME, 1)
+			ON CONFLICT (name) DO UPDATE SET version = table_versions.version + 1;
+			RETURN NULL;
+		END
+		$$ LANGUAGE plpgsql`,
+		`CREATE OR REPLACE TRIGGER incidents_version AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON incidents
+			FOR EACH STATEMENT EXECUTE FUNCTION bump_table_version()`,
+		`CREATE OR REPLACE TRIGGER actions_version AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON actions
+			FOR EACH STATEMENT EXECUTE FUNCTION bump_table_version()`,
+
 		// Indexes
 		`CREATE INDEX IF NOT EXISTS idx_metrics_node ON metrics (node_id, time DESC)`,
 		`CREATE INDEX IF NOT EXISTS idx_metrics_service ON metrics (service_id, time DESC)`,
 		`CREATE INDEX IF NOT EXISTS idx_incidents_severity ON incidents (severity, detected_at DESC)`,
 		`CREATE INDEX IF NOT EXISTS idx_actions_status ON actions (status, created_at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_incident_audit_incident ON incident_audit (incident_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_incidents_title_key ON incidents (title_key, detected_at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_actions_reason_key ON actions (reason_key, created_at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_incidents_affected_ids ON incidents USING GIN (affected_ids)`,
+		`CREATE INDEX IF NOT EXISTS idx_actions_target ON actions (target_id, created_at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_incidents_active_fingerprint ON incidents (fingerprint) WHERE resolved = FALSE`,
+		`CREATE INDEX IF NOT EXISTS idx_incidents_parent ON incidents (parent_id) WHERE parent_id IS NOT NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_suppression_windows_ends ON suppression_windows (ends_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_incident_labels_labeled_at ON incident_labels (labeled_at, incident_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_policy_decisions_decided_at ON policy_decisions (decided_at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_policy_decisions_action ON policy_decisions (action_id, decided_at DESC)`,
+	}
+
+	if db.schema != "" {
+		migrations = append([]string{
+			`CREATE SCHEMA IF NOT EXISTS ` + pgx.Identifier{db.schema}.Sanitize(),
+		}, migrations...)
 	}
 
 	for _, migration := range migrations {
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+)
+
+// ErrInvalidEnum is returned when a row would store a severity, action type
+// or status outside its proto enum
+var ErrInvalidEnum = errors.New("invalid enum value")
+
+// Highest valid values of the common.v1 enums stored as ints. Keep in sync
+// with proto/common/v1/enums.proto.
+const (
+	maxIncidentSeverity = 4  // INCIDENT_SEVERITY_FATAL
+	maxActionType       = 16 // ACTION_TYPE_SCALE_NODE_POOL
+	maxActionStatus     = 7  // ACTION_STATUS_QUARANTINED
+)
+
+// ActionStatusQuarantined marks actions read back with an unknown type or
+// status. They are kept for inspection but never executed.
+const ActionStatusQuarantined = 7
+
+// enumCoercions counts unknown values replaced on read, keyed by
+// "<table>.<column>", and is published at /debug/vars
+var enumCoercions = expvar.NewMap("storage_enum_coercions")
+
+func validEnum(v, max int) bool {
+	return v >= 0 && v <= max
+}
+
+// checkEnum returns ErrInvalidEnum if v is outside [0, max]
+func checkEnum(column string, v, max int) error {
+	if !validEnum(v, max) {
+		return fmt.Errorf("%w: %s = %d", ErrInvalidEnum, column, v)
+	}
+	return nil
+}
+
+// coerceIncident resets an unknown severity to unspecified
+func coerceIncident(i *IncidentRow) {
+	if !validEnum(i.Severity, maxIncidentSeverity) {
+		enumCoercions.Add("incidents.severity", 1)
+		i.Severity = 0
+	}
+}
+
+// coerceAction quarantines an action with an unknown type or status
+func coerceAction(a *ActionRow) {
+	if !validEnum(a.ActionType, maxActionType) {
+		enumCoercions.Add("actions.action_type", 1)
+		a.ActionType = 0
+		a.Status = ActionStatusQuarantined
+	}
+	if !validEnum(a.Status, maxActionStatus) {
+		enumCoercions.Add("actions.status", 1)
+		a.Status = ActionStatusQuarantined
+	}
+}
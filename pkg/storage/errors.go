@@ -0,0 +1,36 @@
+package storage
+
+import "errors"
+
+// ErrNotFound is returned when a lookup or update targets a row that does
+// not exist. Repositories wrap it with the table and ID.
+var ErrNotFound = errors.New("not found")
+
+// ErrExists is returned when an insert targets a key that is already taken.
+// Repositories wrap it with the table and key.
+var ErrExists = errors.New("already exists")
+
+// ErrConflict is returned when an update targets a row whose state rules it
+// out, such as merging an incident that is already merged. Repositories wrap
+// it with the table and ID.
+var ErrConflict = errors.New("conflicts with current state")
+
+// IsNotFound reports whether err is or wraps ErrNotFound
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// IsInvalidEnum reports whether err is or wraps ErrInvalidEnum
+func IsInvalidEnum(err error) bool {
+	return errors.Is(err, ErrInvalidEnum)
+}
+
+// IsExists reports whether err is or wraps ErrExists
+func IsExists(err error) bool {
+	return errors.Is(err, ErrExists)
+}
+
+// IsConflict reports whether err is or wraps ErrConflict
+func IsConflict(err error) bool {
+	return errors.Is(err, ErrConflict)
+}
@@ -2,10 +2,14 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/microcloud/storage/queries"
 )
 
 // IncidentRow represents an incident in the database
@@ -22,55 +26,70 @@ type IncidentRow struct {
 	Metrics       map[string]float64
 	Resolved      bool
 	ResolvedAt    *time.Time
+	MergedInto    *string // set on incidents folded into a merged incident
+	SplitFrom     *string // set on incidents carved out of a split incident
+
+	// Message catalog keys and arguments for Title and Description
+	TitleKey        string
+	TitleArgs       map[string]string
+	DescriptionKey  string
+	DescriptionArgs map[string]string
+
+	// Fingerprint identifies repeats of one incident (rule, entity and
+	// dimension); Occurrences counts its detections while unresolved
+	Fingerprint string
+	Occurrences int
+
+	// ParentID is set on incidents grouped under a correlated parent
+	ParentID *string
+	// EscalatedAt is set once a lasting incident was raised in severity
+	EscalatedAt *time.Time
 }
 
 // IncidentsRepository handles incident persistence
 type IncidentsRepository struct {
-	db *DB
+	conn conn
 }
 
 // NewIncidentsRepository creates a new incidents repository
 func NewIncidentsRepository(db *DB) *IncidentsRepository {
-	return &IncidentsRepository{db: db}
+	return &IncidentsRepository{conn: db.pool}
+}
+
+// WithTx returns a copy of the repository that runs in tx
+func (r *IncidentsRepository) WithTx(tx pgx.Tx) *IncidentsRepository {
+	return &IncidentsRepository{conn: tx}
 }
 
 // Create inserts a new incident
 func (r *IncidentsRepository) Create(ctx context.Context, incident IncidentRow) error {
-	query := `
-		INSERT INTO incidents (id, detected_at, tick_id, severity, title, description,
-							   source_service, affected_ids, rule_name, metrics, resolved, resolved_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
-	`
-	_, err := r.db.pool.Exec(ctx, query,
-		incident.ID, incident.DetectedAt, incident.TickID, incident.Severity,
-		incident.Title, incident.Description, incident.SourceService,
-		incident.AffectedIDs, incident.RuleName, incident.Metrics,
-		incident.Resolved, incident.ResolvedAt,
-	)
-	if err != nil {
-		return fmt.Errorf("create incident: %w", err)
-	}
-	return nil
+	return insertIncident(ctx, r.conn, incident)
 }
 
-// GetByID retrieves an incident by ID
+// GetByID retrieves an incident by ID. It returns ErrNotFound if there is no
+// such incident.
 func (r *IncidentsRepository) GetByID(ctx context.Context, id string) (*IncidentRow, error) {
 	query := `
 		SELECT id, detected_at, tick_id, severity, title, description,
-			   source_service, affected_ids, rule_name, metrics, resolved, resolved_at
+			   source_service, affected_ids, rule_name, metrics, resolved, resolved_at,
+			   merged_into, split_from, title_key, title_args, description_key, description_args,
+			   fingerprint, occurrences, parent_id, escalated_at
 		FROM incidents WHERE id = $1
 	`
 	var i IncidentRow
-	err := r.db.pool.QueryRow(ctx, query, id).Scan(
+	err := r.conn.QueryRow(ctx, query, id).Scan(
 		&i.ID, &i.DetectedAt, &i.TickID, &i.Severity, &i.Title, &i.Description,
 		&i.SourceService, &i.AffectedIDs, &i.RuleName, &i.Metrics, &i.Resolved, &i.ResolvedAt,
+		&i.MergedInto, &i.SplitFrom, &i.TitleKey, &i.TitleArgs, &i.DescriptionKey, &i.DescriptionArgs,
+		&i.Fingerprint, &i.Occurrences, &i.ParentID, &i.EscalatedAt,
 	)
-	if err == pgx.ErrNoRows {
-		return nil, nil
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("get incident %s: %w", id, ErrNotFound)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("get incident: %w", err)
 	}
+	coerceIncident(&i)
 	return &i, nil
 }
 
@@ -78,7 +97,9 @@ func (r *IncidentsRepository) GetByID(ctx context.Context, id string) (*Incident
 func (r *IncidentsRepository) ListUnresolved(ctx context.Context, limit int) ([]IncidentRow, error) {
 	query := `
 		SELECT id, detected_at, tick_id, severity, title, description,
-			   source_service, affected_ids, rule_name, metrics, resolved, resolved_at
+			   source_service, affected_ids, rule_name, metrics, resolved, resolved_at,
+			   merged_into, split_from, title_key, title_args, description_key, description_args,
+			   fingerprint, occurrences, parent_id, escalated_at
 		FROM incidents
 		WHERE resolved = FALSE
 		ORDER BY severity DESC, detected_at DESC
@@ -91,7 +112,9 @@ func (r *IncidentsRepository) ListUnresolved(ctx context.Context, limit int) ([]
 func (r *IncidentsRepository) ListRecent(ctx context.Context, limit int) ([]IncidentRow, error) {
 	query := `
 		SELECT id, detected_at, tick_id, severity, title, description,
-			   source_service, affected_ids, rule_name, metrics, resolved, resolved_at
+			   source_service, affected_ids, rule_name, metrics, resolved, resolved_at,
+			   merged_into, split_from, title_key, title_args, description_key, description_args,
+			   fingerprint, occurrences, parent_id, escalated_at
 		FROM incidents
 		ORDER BY detected_at DESC
 		LIMIT $1
@@ -103,7 +126,9 @@ func (r *IncidentsRepository) ListRecent(ctx context.Context, limit int) ([]Inci
 func (r *IncidentsRepository) ListBySeverity(ctx context.Context, minSeverity int, limit int) ([]IncidentRow, error) {
 	query := `
 		SELECT id, detected_at, tick_id, severity, title, description,
-			   source_service, affected_ids, rule_name, metrics, resolved, resolved_at
+			   source_service, affected_ids, rule_name, metrics, resolved, resolved_at,
+			   merged_into, split_from, title_key, title_args, description_key, description_args,
+			   fingerprint, occurrences, parent_id, escalated_at
 		FROM incidents
 		WHERE severity >= $1
 		ORDER BY severity DESC, detected_at DESC
@@ -112,20 +137,116 @@ func (r *IncidentsRepository) ListBySeverity(ctx context.Context, minSeverity in
 	return r.queryIncidents(ctx, query, minSeverity, limit)
 }
 
-// MarkResolved marks an incident as resolved
+// ListByEntity returns the incidents affecting a node or service detected
+// since the given time, newest first
+func (r *IncidentsRepository) ListByEntity(ctx context.Context, entityID string, since time.Time, limit int) ([]IncidentRow, error) {
+	query := `
+		SELECT id, detected_at, tick_id, severity, title, description,
+			   source_service, affected_ids, rule_name, metrics, resolved, resolved_at,
+			   merged_into, split_from, title_key, title_args, description_key, description_args,
+			   fingerprint, occurrences, parent_id, escalated_at
+		FROM incidents
+		WHERE $1 = ANY(affected_ids) AND detected_at >= $2
+		ORDER BY detected_at DESC
+		LIMIT $3
+	`
+	return r.queryIncidents(ctx, query, entityID, since, limit)
+}
+
+// GetActiveByFingerprint returns the unresolved incident with the given
+// fingerprint, the oldest if there are several. It returns ErrNotFound if
+// there is none.
+func (r *IncidentsRepository) GetActiveByFingerprint(ctx context.Context, fingerprint string) (*IncidentRow, error) {
+	query := `
+		SELECT id, detected_at, tick_id, severity, title, description,
+			   source_service, affected_ids, rule_name, metrics, resolved, resolved_at,
+			   merged_into, split_from, title_key, title_args, description_key, description_args,
+			   fingerprint, occurrences, parent_id, escalated_at
+		FROM incidents
+		WHERE fingerprint = $1 AND resolved = FALSE
+		ORDER BY detected_at
+		LIMIT 1
+	`
+	rows, err := r.queryIncidents(ctx, query, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("get incident by fingerprint %s: %w", fingerprint, ErrNotFound)
+	}
+	return &rows[0], nil
+}
+
+// ListChildren returns the incidents grouped under a correlated parent,
+// oldest first
+func (r *IncidentsRepository) ListChildren(ctx context.Context, parentID string) ([]IncidentRow, error) {
+	query := `
+		SELECT id, detected_at, tick_id, severity, title, description,
+			   source_service, affected_ids, rule_name, metrics, resolved, resolved_at,
+			   merged_into, split_from, title_key, title_args, description_key, description_args,
+			   fingerprint, occurrences, parent_id, escalated_at
+		FROM incidents
+		WHERE parent_id = $1
+		ORDER BY detected_at
+	`
+	return r.queryIncidents(ctx, query, parentID)
+}
+
+// RecordOccurrence counts another detection of an incident and returns its
+// new occurrence count. It returns ErrNotFound if there is no such incident.
+func (r *IncidentsRepository) RecordOccurrence(ctx context.Context, id string) (int, error) {
+	var occurrences int
+	err := r.conn.QueryRow(ctx, `UPDATE incidents SET occurrences = occurrences + 1 WHERE id = $1 RETURNING occurrences`, id).Scan(&occurrences)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("record occurrence of incident %s: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("record occurrence: %w", err)
+	}
+	return occurrences, nil
+}
+
+// MarkResolved marks an incident as resolved. It returns ErrNotFound if
+// there is no such incident.
 func (r *IncidentsRepository) MarkResolved(ctx context.Context, id string, resolvedAt time.Time) error {
 	query := `UPDATE incidents SET resolved = TRUE, resolved_at = $2 WHERE id = $1`
-	_, err := r.db.pool.Exec(ctx, query, id, resolvedAt)
+	tag, err := r.conn.Exec(ctx, query, id, resolvedAt)
 	if err != nil {
 		return fmt.Errorf("mark resolved: %w", err)
 	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("mark incident %s resolved: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// Escalate raises an unresolved incident's severity, recording when. It
+// returns ErrNotFound if there is no such unresolved incident.
+func (r *IncidentsRepository) Escalate(ctx context.Context, id string, severity int, escalatedAt time.Time) error {
+	if err := checkEnum("severity", severity, maxIncidentSeverity); err != nil {
+		return fmt.Errorf("escalate incident %s: %w", id, err)
+	}
+	query := `UPDATE incidents SET severity = $2, escalated_at = $3 WHERE id = $1 AND resolved = FALSE`
+	tag, err := r.conn.Exec(ctx, query, id, severity, escalatedAt)
+	if err != nil {
+		return fmt.Errorf("escalate incident: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("escalate incident %s: %w", id, ErrNotFound)
+	}
 	return nil
 }
 
+// Version returns a counter that changes whenever the incidents table is
+// written, for conditional reads of incident lists
+func (r *IncidentsRepository) Version(ctx context.Context) (int64, error) {
+	return tableVersion(ctx, r.conn, "incidents")
+}
+
 // CountUnresolved returns the count of unresolved incidents
 func (r *IncidentsRepository) CountUnresolved(ctx context.Context) (int64, error) {
 	var count int64
-	err := r.db.pool.QueryRow(ctx, `SELECT COUNT(*) FROM incidents WHERE resolved = FALSE`).Scan(&count)
+	err := r.conn.QueryRow(ctx, `SELECT COUNT(*) FROM incidents WHERE resolved = FALSE`).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("count unresolved: %w", err)
 	}
@@ -133,7 +254,7 @@ func (r *IncidentsRepository) CountUnresolved(ctx context.Context) (int64, error
 }
 
 func (r *IncidentsRepository) queryIncidents(ctx context.Context, query string, args ...any) ([]IncidentRow, error) {
-	rows, err := r.db.pool.Query(ctx, query, args...)
+	rows, err := r.conn.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("query incidents: %w", err)
 	}
@@ -145,10 +266,140 @@ func (r *IncidentsRepository) queryIncidents(ctx context.Context, query string,
 		if err := rows.Scan(
 			&i.ID, &i.DetectedAt, &i.TickID, &i.Severity, &i.Title, &i.Description,
 			&i.SourceService, &i.AffectedIDs, &i.RuleName, &i.Metrics, &i.Resolved, &i.ResolvedAt,
+			&i.MergedInto, &i.SplitFrom, &i.TitleKey, &i.TitleArgs, &i.DescriptionKey, &i.DescriptionArgs,
+			&i.Fingerprint, &i.Occurrences, &i.ParentID, &i.EscalatedAt,
 		); err != nil {
 			return nil, fmt.Errorf("scan incident: %w", err)
 		}
+		coerceIncident(&i)
 		results = append(results, i)
 	}
 	return results, rows.Err()
 }
+
+// Merge inserts merged as a new incident, folds the source incidents into it
+// (resolving them and recording merged_into) and re-links their actions, all
+// in one transaction with an audit entry per affected incident. It returns
+// ErrNotFound if a source does not exist and ErrConflict if one is already
+// merged.
+func (r *IncidentsRepository) Merge(ctx context.Context, merged IncidentRow, sourceIDs []string, actor, reason string) error {
+	err := inTx(ctx, r.conn, func(tx pgx.Tx) error {
+		q := queries.New(tx)
+		if err := insertIncident(ctx, tx, merged); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, id := range sourceIDs {
+			n, err := q.MergeIncidentInto(ctx, queries.MergeIncidentIntoParams{ID: id, MergedInto: merged.ID, ResolvedAt: now})
+			if err != nil {
+				return fmt.Errorf("merge incident %s: %w", id, err)
+			}
+			if n == 0 {
+				return unmatchedIncident(ctx, tx, "merge", id, "already merged")
+			}
+			if err := q.RelinkIncidentActions(ctx, queries.RelinkIncidentActionsParams{FromIncidentID: id, ToIncidentID: merged.ID}); err != nil {
+				return fmt.Errorf("relink actions of %s: %w", id, err)
+			}
+			if err := insertAudit(ctx, q, AuditRow{IncidentID: id, Operation: AuditMergedInto, Actor: actor, Reason: reason, RelatedIDs: []string{merged.ID}}); err != nil {
+				return err
+			}
+		}
+
+		return insertAudit(ctx, q, AuditRow{IncidentID: merged.ID, Operation: AuditMerge, Actor: actor, Reason: reason, RelatedIDs: sourceIDs})
+	})
+	if err != nil {
+		return fmt.Errorf("merge: %w", err)
+	}
+	return nil
+}
+
+// unmatchedIncident explains why an update of incident id matched no row:
+// either it does not exist or its state, described by state, rules op out
+func unmatchedIncident(ctx context.Context, tx pgx.Tx, op, id, state string) error {
+	var exists bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM incidents WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return fmt.Errorf("%s incident %s: %w", op, id, err)
+	}
+	if !exists {
+		return fmt.Errorf("%s incident %s: %w", op, id, ErrNotFound)
+	}
+	return fmt.Errorf("%s incident %s: %s: %w", op, id, state, ErrConflict)
+}
+
+// Split resolves the original incident and inserts parts as new incidents
+// with split_from set. Each action of the original moves to the part whose
+// AffectedIDs contain the action's target; unmatched actions stay put. It
+// returns ErrNotFound if there is no such incident and ErrConflict if it is
+// merged or resolved, which includes having been split already.
+func (r *IncidentsRepository) Split(ctx context.Context, originalID string, parts []IncidentRow, actor, reason string) error {
+	err := inTx(ctx, r.conn, func(tx pgx.Tx) error {
+		q := queries.New(tx)
+		n, err := q.ResolveUnmergedIncident(ctx, queries.ResolveUnmergedIncidentParams{ID: originalID, ResolvedAt: time.Now()})
+		if err != nil {
+			return fmt.Errorf("split incident %s: %w", originalID, err)
+		}
+		if n == 0 {
+			return unmatchedIncident(ctx, tx, "split", originalID, "merged or resolved")
+		}
+
+		partIDs := make([]string, 0, len(parts))
+		for _, part := range parts {
+			part.SplitFrom = &originalID
+			if err := insertIncident(ctx, tx, part); err != nil {
+				return err
+			}
+			err := q.RelinkIncidentActionsForTargets(ctx, queries.RelinkIncidentActionsForTargetsParams{
+				FromIncidentID: originalID,
+				ToIncidentID:   part.ID,
+				TargetIds:      part.AffectedIDs,
+			})
+			if err != nil {
+				return fmt.Errorf("relink actions to %s: %w", part.ID, err)
+			}
+			if err := insertAudit(ctx, q, AuditRow{IncidentID: part.ID, Operation: AuditSplitFrom, Actor: actor, Reason: reason, RelatedIDs: []string{originalID}}); err != nil {
+				return err
+			}
+			partIDs = append(partIDs, part.ID)
+		}
+
+		return insertAudit(ctx, q, AuditRow{IncidentID: originalID, Operation: AuditSplit, Actor: actor, Reason: reason, RelatedIDs: partIDs})
+	})
+	if err != nil {
+		return fmt.Errorf("split: %w", err)
+	}
+	return nil
+}
+
+// execer is satisfied by both the pool and a transaction
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+func insertIncident(ctx context.Context, db execer, incident IncidentRow) error {
+	if err := checkEnum("severity", incident.Severity, maxIncidentSeverity); err != nil {
+		return fmt.Errorf("insert incident %s: %w", incident.ID, err)
+	}
+
+	query := `
+		INSERT INTO incidents (id, detected_at, tick_id, severity, title, description,
+							   source_service, affected_ids, rule_name, metrics, resolved, resolved_at,
+							   merged_into, split_from, title_key, title_args, description_key, description_args,
+							   fingerprint, occurrences, parent_id, escalated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)
+	`
+	// Callers that leave Occurrences unset are recording a first detection
+	occurrences := max(incident.Occurrences, 1)
+	_, err := db.Exec(ctx, query,
+		incident.ID, incident.DetectedAt, incident.TickID, incident.Severity,
+		incident.Title, incident.Description, incident.SourceService,
+		incident.AffectedIDs, incident.RuleName, incident.Metrics,
+		incident.Resolved, incident.ResolvedAt, incident.MergedInto, incident.SplitFrom,
+		incident.TitleKey, incident.TitleArgs, incident.DescriptionKey, incident.DescriptionArgs,
+		incident.Fingerprint, occurrences, incident.ParentID, incident.EscalatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("insert incident %s: %w", incident.ID, err)
+	}
+	return nil
+}
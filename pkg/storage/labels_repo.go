@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/microcloud/storage/queries"
+)
+
+// Incident labels operators can give, for training detectors. Keep in sync
+// with ops.v1.IncidentLabel.
+const (
+	LabelTruePositive        = "true_positive"
+	LabelFalsePositive       = "false_positive"
+	LabelDuplicate           = "duplicate"
+	LabelExpectedMaintenance = "expected_maintenance"
+)
+
+var incidentLabels = []string{LabelTruePositive, LabelFalsePositive, LabelDuplicate, LabelExpectedMaintenance}
+
+// IncidentLabelRow is an operator's current label on an incident
+type IncidentLabelRow struct {
+	IncidentID string
+	Label      string
+	Operator   string
+	Note       string
+	LabeledAt  time.Time
+}
+
+// LabeledIncident is an incident with its label
+type LabeledIncident struct {
+	Incident IncidentRow
+	Label    IncidentLabelRow
+}
+
+// checkLabel returns ErrInvalidEnum if label is not one of the Label*
+// constants
+func checkLabel(label string) error {
+	if !slices.Contains(incidentLabels, label) {
+		return fmt.Errorf("%w: incident_labels.label = %q", ErrInvalidEnum, label)
+	}
+	return nil
+}
+
+// SetLabel labels an incident, replacing any label it had, and records the
+// change in its audit trail. A false positive label also counts as a false
+// positive report, once per incident; relabeling does not take the count
+// back. It returns the incident, or ErrNotFound if there is no such incident.
+func (r *IncidentsRepository) SetLabel(ctx context.Context, label IncidentLabelRow) (*IncidentRow, error) {
+	if err := checkLabel(label.Label); err != nil {
+		return nil, err
+	}
+	if label.LabeledAt.IsZero() {
+		label.LabeledAt = time.Now()
+	}
+
+	var incident *IncidentRow
+	err := inTx(ctx, r.conn, func(tx pgx.Tx) error {
+		q := queries.New(tx)
+		var err error
+		if incident, err = (&IncidentsRepository{conn: tx}).GetByID(ctx, label.IncidentID); err != nil {
+			return err
+		}
+		err = q.UpsertIncidentLabel(ctx, queries.UpsertIncidentLabelParams{
+			IncidentID: label.IncidentID,
+			Label:      label.Label,
+			Operator:   label.Operator,
+			Note:       label.Note,
+			LabeledAt:  label.LabeledAt,
+		})
+		if err != nil {
+			return fmt.Errorf("upsert label: %w", err)
+		}
+		reason := label.Label
+		if label.Note != "" {
+			reason += ": " + label.Note
+		}
+		if err := insertAudit(ctx, q, AuditRow{IncidentID: label.IncidentID, Operation: AuditLabel, Actor: label.Operator, Reason: reason}); err != nil {
+			return err
+		}
+		if label.Label != LabelFalsePositive {
+			return nil
+		}
+		if err := recordFalsePositive(ctx, q, incident, label.Operator, label.Note); err != nil && !IsExists(err) {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("label incident: %w", err)
+	}
+	return incident, nil
+}
+
+// ListLabeled returns up to limit incidents labeled at or after since, in
+// the order they were labeled, with their labels. An empty label matches
+// every label.
+func (r *IncidentsRepository) ListLabeled(ctx context.Context, label string, since time.Time, limit int) ([]LabeledIncident, error) {
+	if label != "" {
+		if err := checkLabel(label); err != nil {
+			return nil, err
+		}
+	}
+	labels, err := queries.New(r.conn).ListIncidentLabels(ctx, queries.ListIncidentLabelsParams{
+		Since:   since,
+		Label:   label,
+		MaxRows: int32(limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list labels: %w", err)
+	}
+	if len(labels) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, 0, len(labels))
+	for _, l := range labels {
+		ids = append(ids, l.IncidentID)
+	}
+	query := `
+		SELECT id, detected_at, tick_id, severity, title, description,
+			   source_service, affected_ids, rule_name, metrics, resolved, resolved_at,
+			   merged_into, split_from, title_key, title_args, description_key, description_args,
+			   fingerprint, occurrences, parent_id, escalated_at
+		FROM incidents WHERE id = ANY($1::uuid[])
+	`
+	rows, err := r.queryIncidents(ctx, query, ids)
+	if err != nil {
+		return nil, err
+	}
+	incidents := make(map[string]IncidentRow, len(rows))
+	for _, row := range rows {
+		incidents[row.ID] = row
+	}
+
+	// Labels of incidents deleted since are left for CheckConsistency
+	results := make([]LabeledIncident, 0, len(labels))
+	for _, l := range labels {
+		incident, ok := incidents[l.IncidentID]
+		if !ok {
+			continue
+		}
+		results = append(results, LabeledIncident{
+			Incident: incident,
+			Label: IncidentLabelRow{
+				IncidentID: l.IncidentID,
+				Label:      l.Label,
+				Operator:   l.Operator,
+				Note:       l.Note,
+				LabeledAt:  l.LabeledAt,
+			},
+		})
+	}
+	return results, nil
+}
@@ -21,12 +21,17 @@ type MetricRow struct {
 
 // MetricsRepository handles metric persistence
 type MetricsRepository struct {
-	db *DB
+	conn conn
 }
 
 // NewMetricsRepository creates a new metrics repository
 func NewMetricsRepository(db *DB) *MetricsRepository {
-	return &MetricsRepository{db: db}
+	return &MetricsRepository{conn: db.pool}
+}
+
+// WithTx returns a copy of the repository that runs in tx
+func (r *MetricsRepository) WithTx(tx pgx.Tx) *MetricsRepository {
+	return &MetricsRepository{conn: tx}
 }
 
 // BatchInsert efficiently inserts multiple metrics
@@ -44,7 +49,7 @@ func (r *MetricsRepository) BatchInsert(ctx context.Context, metrics []MetricRow
 		)
 	}
 
-	br := r.db.pool.SendBatch(ctx, batch)
+	br := r.conn.SendBatch(ctx, batch)
 	defer br.Close()
 
 	for i := 0; i < len(metrics); i++ {
@@ -55,6 +60,24 @@ func (r *MetricsRepository) BatchInsert(ctx context.Context, metrics []MetricRow
 	return nil
 }
 
+// BulkInsert loads metrics with COPY, which is much faster than BatchInsert
+// for large volumes such as backfilled history. It returns the number of
+// rows written.
+func (r *MetricsRepository) BulkInsert(ctx context.Context, metrics []MetricRow) (int64, error) {
+	n, err := r.conn.CopyFrom(ctx,
+		pgx.Identifier{"metrics"},
+		[]string{"time", "tick_id", "node_id", "service_id", "metric_name", "metric_value", "labels"},
+		pgx.CopyFromSlice(len(metrics), func(i int) ([]any, error) {
+			m := metrics[i]
+			return []any{m.Time, m.TickID, m.NodeID, m.ServiceID, m.MetricName, m.MetricValue, m.Labels}, nil
+		}),
+	)
+	if err != nil {
+		return n, fmt.Errorf("copy metrics: %w", err)
+	}
+	return n, nil
+}
+
 // QueryByTimeRange retrieves metrics within a time range
 func (r *MetricsRepository) QueryByTimeRange(ctx context.Context, start, end time.Time, metricName string, limit int) ([]MetricRow, error) {
 	query := `
@@ -65,7 +88,7 @@ func (r *MetricsRepository) QueryByTimeRange(ctx context.Context, start, end tim
 		LIMIT $4
 	`
 
-	rows, err := r.db.pool.Query(ctx, query, start, end, metricName, limit)
+	rows, err := r.conn.Query(ctx, query, start, end, metricName, limit)
 	if err != nil {
 		return nil, fmt.Errorf("query metrics: %w", err)
 	}
@@ -92,7 +115,7 @@ func (r *MetricsRepository) GetLatestByNode(ctx context.Context, nodeID string,
 		LIMIT $2
 	`
 
-	rows, err := r.db.pool.Query(ctx, query, nodeID, limit)
+	rows, err := r.conn.Query(ctx, query, nodeID, limit)
 	if err != nil {
 		return nil, fmt.Errorf("query node metrics: %w", err)
 	}
@@ -119,7 +142,7 @@ func (r *MetricsRepository) GetLatestByService(ctx context.Context, serviceID st
 		LIMIT $2
 	`
 
-	rows, err := r.db.pool.Query(ctx, query, serviceID, limit)
+	rows, err := r.conn.Query(ctx, query, serviceID, limit)
 	if err != nil {
 		return nil, fmt.Errorf("query service metrics: %w", err)
 	}
@@ -136,6 +159,34 @@ func (r *MetricsRepository) GetLatestByService(ctx context.Context, serviceID st
 	return results, rows.Err()
 }
 
+// LatestPerEntity returns the newest value of each metric of every node and
+// service that reported since the given time
+func (r *MetricsRepository) LatestPerEntity(ctx context.Context, since time.Time) ([]MetricRow, error) {
+	query := `
+		SELECT DISTINCT ON (node_id, service_id, metric_name)
+			time, tick_id, node_id, service_id, metric_name, metric_value, labels
+		FROM metrics
+		WHERE time >= $1
+		ORDER BY node_id, service_id, metric_name, time DESC
+	`
+
+	rows, err := r.conn.Query(ctx, query, since)
+	if err != nil {
+		return nil, fmt.Errorf("query latest metrics: %w", err)
+	}
+	defer rows.Close()
+
+	var results []MetricRow
+	for rows.Next() {
+		var m MetricRow
+		if err := rows.Scan(&m.Time, &m.TickID, &m.NodeID, &m.ServiceID, &m.MetricName, &m.MetricValue, &m.Labels); err != nil {
+			return nil, fmt.Errorf("scan metric: %w", err)
+		}
+		results = append(results, m)
+	}
+	return results, rows.Err()
+}
+
 // AggregatedMetric represents a time-bucketed aggregation
 type AggregatedMetric struct {
 	Bucket      time.Time
@@ -159,7 +210,7 @@ func (r *MetricsRepository) Aggregate(ctx context.Context, metricName string, in
 		ORDER BY bucket DESC
 	`
 
-	rows, err := r.db.pool.Query(ctx, query, interval, metricName, start, end)
+	rows, err := r.conn.Query(ctx, query, interval, metricName, start, end)
 	if err != nil {
 		return nil, fmt.Errorf("aggregate metrics: %w", err)
 	}
@@ -175,3 +226,89 @@ func (r *MetricsRepository) Aggregate(ctx context.Context, metricName string, in
 	}
 	return results, rows.Err()
 }
+
+// SeasonalBucket is the spread of one node or service metric within one
+// slot of the day
+type SeasonalBucket struct {
+	NodeID      *string
+	ServiceID   *string
+	Slot        int
+	Mean        float64
+	Stddev      float64
+	SampleCount int64
+}
+
+// SeasonalProfile returns the mean and standard deviation of a metric per
+// node or service and slot of the day since the given time. Slots are slot
+// long and counted from midnight UTC, so the same slot of every day falls in
+// one bucket.
+func (r *MetricsRepository) SeasonalProfile(ctx context.Context, metricName string, slot time.Duration, since time.Time) ([]SeasonalBucket, error) {
+	query := `
+		SELECT node_id, service_id,
+			   FLOOR(EXTRACT(EPOCH FROM (time AT TIME ZONE 'UTC')::time) / $2)::int AS slot,
+			   AVG(metric_value) AS mean,
+			   COALESCE(STDDEV_POP(metric_value), 0) AS stddev,
+			   COUNT(*) AS sample_count
+		FROM metrics
+		WHERE metric_name = $1 AND time >= $3
+		GROUP BY node_id, service_id, slot
+		ORDER BY node_id, service_id, slot
+	`
+
+	rows, err := r.conn.Query(ctx, query, metricName, slot.Seconds(), since)
+	if err != nil {
+		return nil, fmt.Errorf("query seasonal profile: %w", err)
+	}
+	defer rows.Close()
+
+	var results []SeasonalBucket
+	for rows.Next() {
+		var b SeasonalBucket
+		if err := rows.Scan(&b.NodeID, &b.ServiceID, &b.Slot, &b.Mean, &b.Stddev, &b.SampleCount); err != nil {
+			return nil, fmt.Errorf("scan seasonal bucket: %w", err)
+		}
+		results = append(results, b)
+	}
+	return results, rows.Err()
+}
+
+// EntityMetricBucket is one metric of one node or service averaged over a
+// time bucket
+type EntityMetricBucket struct {
+	MetricName string
+	Bucket     time.Time
+	AvgValue   float64
+	MaxValue   float64
+}
+
+// EntitySeries downsamples the given metrics of a node or service between
+// start and end into buckets of the given width, by metric and then time
+func (r *MetricsRepository) EntitySeries(ctx context.Context, entityID string, metricNames []string, start, end time.Time, bucket time.Duration) ([]EntityMetricBucket, error) {
+	query := `
+		SELECT metric_name,
+			   time_bucket(make_interval(secs => $5), time) AS bucket,
+			   AVG(metric_value) AS avg_value,
+			   MAX(metric_value) AS max_value
+		FROM metrics
+		WHERE (node_id = $1 OR service_id = $1) AND metric_name = ANY($2)
+			AND time >= $3 AND time < $4
+		GROUP BY metric_name, bucket
+		ORDER BY metric_name, bucket
+	`
+
+	rows, err := r.conn.Query(ctx, query, entityID, metricNames, start, end, bucket.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("query entity series: %w", err)
+	}
+	defer rows.Close()
+
+	var results []EntityMetricBucket
+	for rows.Next() {
+		var b EntityMetricBucket
+		if err := rows.Scan(&b.MetricName, &b.Bucket, &b.AvgValue, &b.MaxValue); err != nil {
+			return nil, fmt.Errorf("scan entity series: %w", err)
+		}
+		results = append(results, b)
+	}
+	return results, rows.Err()
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/microcloud/storage/queries"
+)
+
+// Kinds of policy decision, by the point in an action's life they gate
+const (
+	PolicyApprove     = "approve"      // an operator asked to approve the action
+	PolicyAutoApprove = "auto_approve" // the action was proposed; allowed means approved without an operator
+)
+
+var policyKinds = []string{PolicyApprove, PolicyAutoApprove}
+
+// PolicyDecisionRow is one evaluation of the action policies, with the
+// input they saw and what they returned, so a decision can be replayed
+// against another bundle
+type PolicyDecisionRow struct {
+	ID             string
+	ActionID       string
+	Kind           string
+	Allowed        bool
+	Reasons        []string // why the action was denied or approved
+	Actor          string   // API key of the approver; empty for auto_approve
+	BundleRevision string
+	Input          json.RawMessage
+	Result         json.RawMessage
+	DecidedAt      time.Time
+}
+
+// PolicyDecisionsRepository handles the policy decision log
+type PolicyDecisionsRepository struct {
+	conn conn
+}
+
+// NewPolicyDecisionsRepository creates a new policy decisions repository
+func NewPolicyDecisionsRepository(db *DB) *PolicyDecisionsRepository {
+	return &PolicyDecisionsRepository{conn: db.pool}
+}
+
+// WithTx returns a copy of the repository that runs in tx
+func (r *PolicyDecisionsRepository) WithTx(tx pgx.Tx) *PolicyDecisionsRepository {
+	return &PolicyDecisionsRepository{conn: tx}
+}
+
+// Create records a decision. It returns ErrInvalidEnum if the kind is not
+// one of the Policy* constants.
+func (r *PolicyDecisionsRepository) Create(ctx context.Context, decision PolicyDecisionRow) error {
+	if !slices.Contains(policyKinds, decision.Kind) {
+		return fmt.Errorf("%w: policy_decisions.kind = %q", ErrInvalidEnum, decision.Kind)
+	}
+	if decision.DecidedAt.IsZero() {
+		decision.DecidedAt = time.Now()
+	}
+	err := queries.New(r.conn).InsertPolicyDecision(ctx, queries.InsertPolicyDecisionParams{
+		ID:             decision.ID,
+		ActionID:       decision.ActionID,
+		Kind:           decision.Kind,
+		Allowed:        decision.Allowed,
+		Reasons:        decision.Reasons,
+		Actor:          decision.Actor,
+		BundleRevision: decision.BundleRevision,
+		Input:          decision.Input,
+		Result:         decision.Result,
+		DecidedAt:      decision.DecidedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("insert policy decision: %w", err)
+	}
+	return nil
+}
+
+// List returns up to limit decisions made before before, newest first. An
+// empty actionID matches every action; the zero before matches every time.
+func (r *PolicyDecisionsRepository) List(ctx context.Context, actionID string, before time.Time, limit int) ([]PolicyDecisionRow, error) {
+	params := queries.ListPolicyDecisionsParams{MaxRows: int32(limit)}
+	if actionID != "" {
+		params.ActionID = &actionID
+	}
+	if !before.IsZero() {
+		params.Before = &before
+	}
+	rows, err := queries.New(r.conn).ListPolicyDecisions(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("list policy decisions: %w", err)
+	}
+
+	results := make([]PolicyDecisionRow, 0, len(rows))
+	for _, row := range rows {
+		results = append(results, PolicyDecisionRow{
+			ID:             row.ID,
+			ActionID:       row.ActionID,
+			Kind:           row.Kind,
+			Allowed:        row.Allowed,
+			Reasons:        row.Reasons,
+			Actor:          row.Actor,
+			BundleRevision: row.BundleRevision,
+			Input:          row.Input,
+			Result:         row.Result,
+			DecidedAt:      row.DecidedAt,
+		})
+	}
+	return results, nil
+}
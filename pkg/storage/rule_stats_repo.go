@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/microcloud/storage/queries"
+)
+
+// RuleStatsRow holds a rule's counters, summed over every replica since
+// the rule was first counted
+type RuleStatsRow struct {
+	RuleName       string
+	Evaluations    int64 // values checked against the rule
+	Breaches       int64 // values that breached it
+	IncidentsFired int64
+	FalsePositives int64 // incidents operators reported as false positives
+	UpdatedAt      time.Time
+}
+
+// AddStats adds counts to the stored counters of each rule, creating the
+// rows of rules not counted yet
+func (r *RulesRepository) AddStats(ctx context.Context, stats []RuleStatsRow) error {
+	return inTx(ctx, r.conn, func(tx pgx.Tx) error {
+		q := queries.New(tx)
+		now := time.Now()
+		for _, s := range stats {
+			if err := addRuleStats(ctx, q, s, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListStats returns the counters of every rule counted so far, by name
+func (r *RulesRepository) ListStats(ctx context.Context) ([]RuleStatsRow, error) {
+	rows, err := queries.New(r.conn).ListRuleStats(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list rule stats: %w", err)
+	}
+	results := make([]RuleStatsRow, 0, len(rows))
+	for _, s := range rows {
+		results = append(results, RuleStatsRow{
+			RuleName:       s.RuleName,
+			Evaluations:    s.Evaluations,
+			Breaches:       s.Breaches,
+			IncidentsFired: s.IncidentsFired,
+			FalsePositives: s.FalsePositives,
+			UpdatedAt:      s.UpdatedAt,
+		})
+	}
+	return results, nil
+}
+
+// MarkFalsePositive records an operator's report that an incident should
+// not have fired, in its audit trail and its rule's false positive count,
+// and returns the incident. It returns ErrNotFound if there is no such
+// incident and ErrExists if it was already reported.
+func (r *IncidentsRepository) MarkFalsePositive(ctx context.Context, id, actor, reason string) (*IncidentRow, error) {
+	var incident *IncidentRow
+	err := inTx(ctx, r.conn, func(tx pgx.Tx) error {
+		q := queries.New(tx)
+		var err error
+		if incident, err = (&IncidentsRepository{conn: tx}).GetByID(ctx, id); err != nil {
+			return err
+		}
+		return recordFalsePositive(ctx, q, incident, actor, reason)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("mark false positive: %w", err)
+	}
+	return incident, nil
+}
+
+// recordFalsePositive adds a false positive report to an incident's audit
+// trail and its rule's count. It returns ErrExists if the incident was
+// already reported.
+func recordFalsePositive(ctx context.Context, q *queries.Queries, incident *IncidentRow, actor, reason string) error {
+	reported, err := q.HasIncidentAudit(ctx, queries.HasIncidentAuditParams{IncidentID: incident.ID, Operation: AuditFalsePositive})
+	if err != nil {
+		return fmt.Errorf("check audit: %w", err)
+	}
+	if reported {
+		return fmt.Errorf("false positive report on incident %s: %w", incident.ID, ErrExists)
+	}
+	if err := insertAudit(ctx, q, AuditRow{IncidentID: incident.ID, Operation: AuditFalsePositive, Actor: actor, Reason: reason}); err != nil {
+		return err
+	}
+	if incident.RuleName == "" {
+		return nil
+	}
+	return addRuleStats(ctx, q, RuleStatsRow{RuleName: incident.RuleName, FalsePositives: 1}, time.Now())
+}
+
+func addRuleStats(ctx context.Context, q *queries.Queries, s RuleStatsRow, now time.Time) error {
+	err := q.AddRuleStats(ctx, queries.AddRuleStatsParams{
+		RuleName:       s.RuleName,
+		Evaluations:    s.Evaluations,
+		Breaches:       s.Breaches,
+		IncidentsFired: s.IncidentsFired,
+		FalsePositives: s.FalsePositives,
+		UpdatedAt:      now,
+	})
+	if err != nil {
+		return fmt.Errorf("add stats of rule %s: %w", s.RuleName, err)
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/microcloud/storage/queries"
+)
+
+// RuleTypeThreshold is the type of rules that compare values to a fixed
+// threshold, the default
+const RuleTypeThreshold = "threshold"
+
+// RuleRow represents a detection rule in the database
+type RuleRow struct {
+	Name                 string
+	MetricName           string
+	Operator             string
+	Threshold            float64
+	WindowSeconds        int
+	Severity             int
+	Region               string  // empty matches every region
+	Type                 string  // threshold, baseline or rate; empty is threshold
+	TriggerRatio         float64 // 0 uses the detector default
+	ClearRatio           float64 // 0 uses the detector default
+	ClearSeconds         int
+	Selector             map[string]string // labels an entity must carry; empty matches every entity
+	Overrides            []RuleOverrideRow
+	MinSamples           int // 0 uses the detector default
+	EvaluateEverySeconds int // 0 evaluates on every snapshot
+	Enabled              bool
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+}
+
+// RuleOverrideRow replaces a rule's threshold for the entities whose labels
+// match its selector. It is stored as JSON in the rule's overrides column.
+type RuleOverrideRow struct {
+	Selector  map[string]string `json:"selector"`
+	Threshold float64           `json:"threshold"`
+}
+
+// RulesRepository handles detection rule persistence
+type RulesRepository struct {
+	conn conn
+}
+
+// NewRulesRepository creates a new rules repository
+func NewRulesRepository(db *DB) *RulesRepository {
+	return &RulesRepository{conn: db.pool}
+}
+
+// WithTx returns a copy of the repository that runs in tx
+func (r *RulesRepository) WithTx(tx pgx.Tx) *RulesRepository {
+	return &RulesRepository{conn: tx}
+}
+
+// List returns every rule, enabled or not, by name
+func (r *RulesRepository) List(ctx context.Context) ([]RuleRow, error) {
+	rows, err := queries.New(r.conn).ListDetectionRules(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list rules: %w", err)
+	}
+	results := make([]RuleRow, 0, len(rows))
+	for _, row := range rows {
+		results = append(results, ruleRow(row))
+	}
+	return results, nil
+}
+
+// Get retrieves a rule by name. It returns ErrNotFound if there is no such
+// rule.
+func (r *RulesRepository) Get(ctx context.Context, name string) (*RuleRow, error) {
+	row, err := queries.New(r.conn).GetDetectionRule(ctx, name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("get rule %s: %w", name, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get rule: %w", err)
+	}
+	rule := ruleRow(row)
+	return &rule, nil
+}
+
+// Create inserts a new rule. It returns ErrExists if a rule with the same
+// name exists.
+func (r *RulesRepository) Create(ctx context.Context, rule RuleRow) error {
+	if err := checkEnum("severity", rule.Severity, maxIncidentSeverity); err != nil {
+		return fmt.Errorf("create rule: %w", err)
+	}
+	n, err := insertRule(ctx, queries.New(r.conn), rule)
+	if err != nil {
+		return fmt.Errorf("create rule: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("create rule %s: %w", rule.Name, ErrExists)
+	}
+	return nil
+}
+
+// Update replaces a rule's condition and severity, keeping whether it is
+// enabled. It returns ErrNotFound if there is no such rule.
+func (r *RulesRepository) Update(ctx context.Context, rule RuleRow) error {
+	if err := checkEnum("severity", rule.Severity, maxIncidentSeverity); err != nil {
+		return fmt.Errorf("update rule: %w", err)
+	}
+	selector, overrides, err := ruleLabels(rule)
+	if err != nil {
+		return fmt.Errorf("update rule: %w", err)
+	}
+	n, err := queries.New(r.conn).UpdateDetectionRule(ctx, queries.UpdateDetectionRuleParams{
+		Name:                 rule.Name,
+		MetricName:           rule.MetricName,
+		Operator:             rule.Operator,
+		Threshold:            rule.Threshold,
+		WindowSeconds:        int32(rule.WindowSeconds),
+		Severity:             int32(rule.Severity),
+		Region:               rule.Region,
+		UpdatedAt:            time.Now(),
+		Type:                 ruleType(rule.Type),
+		TriggerRatio:         rule.TriggerRatio,
+		ClearRatio:           rule.ClearRatio,
+		ClearSeconds:         int32(rule.ClearSeconds),
+		Selector:             selector,
+		Overrides:            overrides,
+		MinSamples:           int32(rule.MinSamples),
+		EvaluateEverySeconds: int32(rule.EvaluateEverySeconds),
+	})
+	if err != nil {
+		return fmt.Errorf("update rule: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("update rule %s: %w", rule.Name, ErrNotFound)
+	}
+	return nil
+}
+
+// SetEnabled enables or disables a rule. It returns ErrNotFound if there is
+// no such rule.
+func (r *RulesRepository) SetEnabled(ctx context.Context, name string, enabled bool) error {
+	n, err := queries.New(r.conn).SetDetectionRuleEnabled(ctx, queries.SetDetectionRuleEnabledParams{
+		Name:      name,
+		Enabled:   enabled,
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("set rule enabled: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("set rule %s enabled: %w", name, ErrNotFound)
+	}
+	return nil
+}
+
+// Seed inserts the rules that do not exist yet, leaving stored ones as
+// operators last changed them. It returns how many were inserted.
+func (r *RulesRepository) Seed(ctx context.Context, rules []RuleRow) (int, error) {
+	var inserted int
+	err := inTx(ctx, r.conn, func(tx pgx.Tx) error {
+		q := queries.New(tx)
+		for _, rule := range rules {
+			if err := checkEnum("severity", rule.Severity, maxIncidentSeverity); err != nil {
+				return fmt.Errorf("seed rule %s: %w", rule.Name, err)
+			}
+			n, err := insertRule(ctx, q, rule)
+			if err != nil {
+				return fmt.Errorf("seed rule %s: %w", rule.Name, err)
+			}
+			inserted += int(n)
+		}
+		return nil
+	})
+	return inserted, err
+}
+
+func insertRule(ctx context.Context, q *queries.Queries, rule RuleRow) (int64, error) {
+	selector, overrides, err := ruleLabels(rule)
+	if err != nil {
+		return 0, err
+	}
+	return q.InsertDetectionRule(ctx, queries.InsertDetectionRuleParams{
+		Name:                 rule.Name,
+		MetricName:           rule.MetricName,
+		Operator:             rule.Operator,
+		Threshold:            rule.Threshold,
+		WindowSeconds:        int32(rule.WindowSeconds),
+		Severity:             int32(rule.Severity),
+		Region:               rule.Region,
+		Enabled:              rule.Enabled,
+		CreatedAt:            time.Now(),
+		Type:                 ruleType(rule.Type),
+		TriggerRatio:         rule.TriggerRatio,
+		ClearRatio:           rule.ClearRatio,
+		ClearSeconds:         int32(rule.ClearSeconds),
+		Selector:             selector,
+		Overrides:            overrides,
+		MinSamples:           int32(rule.MinSamples),
+		EvaluateEverySeconds: int32(rule.EvaluateEverySeconds),
+	})
+}
+
+// ruleLabels encodes a rule's selector and overrides for their JSONB
+// columns, as {} and [] when unset
+func ruleLabels(rule RuleRow) (selector, overrides []byte, err error) {
+	sel := rule.Selector
+	if sel == nil {
+		sel = map[string]string{}
+	}
+	if selector, err = json.Marshal(sel); err != nil {
+		return nil, nil, fmt.Errorf("encode selector: %w", err)
+	}
+	ovr := rule.Overrides
+	if ovr == nil {
+		ovr = []RuleOverrideRow{}
+	}
+	if overrides, err = json.Marshal(ovr); err != nil {
+		return nil, nil, fmt.Errorf("encode overrides: %w", err)
+	}
+	return selector, overrides, nil
+}
+
+// ruleType stores rules without a type as threshold rules
+func ruleType(t string) string {
+	if t == "" {
+		return RuleTypeThreshold
+	}
+	return t
+}
+
+func ruleRow(r queries.DetectionRule) RuleRow {
+	row := RuleRow{
+		Name:                 r.Name,
+		MetricName:           r.MetricName,
+		Operator:             r.Operator,
+		Threshold:            r.Threshold,
+		WindowSeconds:        int(r.WindowSeconds),
+		Severity:             int(r.Severity),
+		Region:               r.Region,
+		Type:                 r.Type,
+		TriggerRatio:         r.TriggerRatio,
+		ClearRatio:           r.ClearRatio,
+		ClearSeconds:         int(r.ClearSeconds),
+		MinSamples:           int(r.MinSamples),
+		EvaluateEverySeconds: int(r.EvaluateEverySeconds),
+		Enabled:              r.Enabled,
+		CreatedAt:            r.CreatedAt,
+		UpdatedAt:            r.UpdatedAt,
+	}
+	// Both columns are written by ruleLabels, so a value that does not
+	// decode is left empty rather than failing the whole list
+	if err := json.Unmarshal(r.Selector, &row.Selector); err != nil || len(row.Selector) == 0 {
+		row.Selector = nil
+	}
+	if err := json.Unmarshal(r.Overrides, &row.Overrides); err != nil || len(row.Overrides) == 0 {
+		row.Overrides = nil
+	}
+	return row
+}
@@ -0,0 +1,12 @@
+-- name: UpdateActionStatus :execrows
+UPDATE actions
+SET status = @status, result_message = @result_message::text, executed_at = @executed_at::timestamptz
+WHERE id = @id;
+
+-- name: RelinkIncidentActions :exec
+UPDATE actions SET incident_id = @to_incident_id::uuid
+WHERE incident_id = @from_incident_id::uuid;
+
+-- name: RelinkIncidentActionsForTargets :exec
+UPDATE actions SET incident_id = @to_incident_id::uuid
+WHERE incident_id = @from_incident_id::uuid AND target_id = ANY(@target_ids::text[]);
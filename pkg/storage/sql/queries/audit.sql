@@ -0,0 +1,15 @@
+-- name: InsertIncidentAudit :exec
+INSERT INTO incident_audit (incident_id, operation, actor, reason, related_ids, created_at)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: ListIncidentAudit :many
+SELECT id, incident_id, operation, actor, reason, related_ids, created_at
+FROM incident_audit
+WHERE incident_id = $1
+ORDER BY created_at ASC, id ASC;
+
+-- name: HasIncidentAudit :one
+SELECT EXISTS (
+    SELECT 1 FROM incident_audit
+    WHERE incident_id = $1 AND operation = $2
+);
@@ -0,0 +1,16 @@
+-- name: UpsertIncidentLabel :exec
+INSERT INTO incident_labels (incident_id, label, operator, note, labeled_at)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (incident_id) DO UPDATE SET
+    label = EXCLUDED.label,
+    operator = EXCLUDED.operator,
+    note = EXCLUDED.note,
+    labeled_at = EXCLUDED.labeled_at;
+
+-- name: ListIncidentLabels :many
+SELECT incident_id, label, operator, note, labeled_at
+FROM incident_labels
+WHERE labeled_at >= sqlc.arg(since)
+    AND (sqlc.arg(label)::text = '' OR label = sqlc.arg(label)::text)
+ORDER BY labeled_at, incident_id
+LIMIT sqlc.arg(max_rows);
@@ -0,0 +1,7 @@
+-- name: MergeIncidentInto :execrows
+UPDATE incidents SET merged_into = @merged_into::uuid, resolved = TRUE, resolved_at = @resolved_at::timestamptz
+WHERE id = @id AND merged_into IS NULL;
+
+-- name: ResolveUnmergedIncident :execrows
+UPDATE incidents SET resolved = TRUE, resolved_at = @resolved_at::timestamptz
+WHERE id = @id AND merged_into IS NULL AND resolved = FALSE;
@@ -0,0 +1,11 @@
+-- name: InsertPolicyDecision :exec
+INSERT INTO policy_decisions (id, action_id, kind, allowed, reasons, actor, bundle_revision, input, result, decided_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
+
+-- name: ListPolicyDecisions :many
+SELECT id, action_id, kind, allowed, reasons, actor, bundle_revision, input, result, decided_at
+FROM policy_decisions
+WHERE (sqlc.narg(action_id)::uuid IS NULL OR action_id = sqlc.narg(action_id)::uuid)
+    AND (sqlc.narg(before)::timestamptz IS NULL OR decided_at < sqlc.narg(before)::timestamptz)
+ORDER BY decided_at DESC, id
+LIMIT sqlc.arg(max_rows);
@@ -0,0 +1,14 @@
+-- name: AddRuleStats :exec
+INSERT INTO rule_stats (rule_name, evaluations, breaches, incidents_fired, false_positives, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (rule_name) DO UPDATE SET
+    evaluations = rule_stats.evaluations + EXCLUDED.evaluations,
+    breaches = rule_stats.breaches + EXCLUDED.breaches,
+    incidents_fired = rule_stats.incidents_fired + EXCLUDED.incidents_fired,
+    false_positives = rule_stats.false_positives + EXCLUDED.false_positives,
+    updated_at = EXCLUDED.updated_at;
+
+-- name: ListRuleStats :many
+SELECT rule_name, evaluations, breaches, incidents_fired, false_positives, updated_at
+FROM rule_stats
+ORDER BY rule_name;
@@ -0,0 +1,25 @@
+-- name: ListDetectionRules :many
+SELECT name, metric_name, operator, threshold, window_seconds, severity, region, enabled, created_at, updated_at, type, trigger_ratio, clear_ratio, clear_seconds, selector, overrides, min_samples, evaluate_every_seconds
+FROM detection_rules
+ORDER BY name;
+
+-- name: GetDetectionRule :one
+SELECT name, metric_name, operator, threshold, window_seconds, severity, region, enabled, created_at, updated_at, type, trigger_ratio, clear_ratio, clear_seconds, selector, overrides, min_samples, evaluate_every_seconds
+FROM detection_rules
+WHERE name = $1;
+
+-- name: InsertDetectionRule :execrows
+INSERT INTO detection_rules (name, metric_name, operator, threshold, window_seconds, severity, region, enabled, created_at, updated_at, type, trigger_ratio, clear_ratio, clear_seconds, selector, overrides, min_samples, evaluate_every_seconds)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+ON CONFLICT (name) DO NOTHING;
+
+-- name: UpdateDetectionRule :execrows
+UPDATE detection_rules
+SET metric_name = $2, operator = $3, threshold = $4, window_seconds = $5, severity = $6, region = $7, updated_at = $8, type = $9,
+    trigger_ratio = $10, clear_ratio = $11, clear_seconds = $12, selector = $13, overrides = $14,
+    min_samples = $15, evaluate_every_seconds = $16
+WHERE name = $1;
+
+-- name: SetDetectionRuleEnabled :execrows
+UPDATE detection_rules SET enabled = $2, updated_at = $3
+WHERE name = $1;
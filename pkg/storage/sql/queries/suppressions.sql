@@ -0,0 +1,18 @@
+-- name: ListSuppressionWindows :many
+SELECT id, entity_id, rule_name, starts_at, ends_at, reason, created_by, created_at
+FROM suppression_windows
+WHERE ends_at > $1
+ORDER BY starts_at, id;
+
+-- name: GetSuppressionWindow :one
+SELECT id, entity_id, rule_name, starts_at, ends_at, reason, created_by, created_at
+FROM suppression_windows
+WHERE id = $1;
+
+-- name: InsertSuppressionWindow :exec
+INSERT INTO suppression_windows (id, entity_id, rule_name, starts_at, ends_at, reason, created_by, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
+
+-- name: DeleteSuppressionWindow :execrows
+DELETE FROM suppression_windows
+WHERE id = $1;
@@ -0,0 +1,130 @@
+-- Schema as left by the migrations in db.go, for sqlc. Keep it in step
+-- with Migrate: add new tables here and as migrations there.
+
+CREATE TABLE metrics (
+    time TIMESTAMPTZ NOT NULL,
+    tick_id BIGINT NOT NULL,
+    node_id TEXT,
+    service_id TEXT,
+    metric_name TEXT NOT NULL,
+    metric_value DOUBLE PRECISION NOT NULL,
+    labels JSONB DEFAULT '{}'
+);
+
+CREATE TABLE incidents (
+    id UUID PRIMARY KEY,
+    detected_at TIMESTAMPTZ NOT NULL,
+    tick_id BIGINT NOT NULL,
+    severity INT NOT NULL,
+    title TEXT NOT NULL,
+    description TEXT,
+    source_service TEXT,
+    affected_ids TEXT[],
+    rule_name TEXT,
+    metrics JSONB,
+    resolved BOOLEAN DEFAULT FALSE,
+    resolved_at TIMESTAMPTZ,
+    merged_into UUID,
+    split_from UUID,
+    title_key TEXT NOT NULL DEFAULT '',
+    title_args JSONB,
+    description_key TEXT NOT NULL DEFAULT '',
+    description_args JSONB,
+    fingerprint TEXT NOT NULL DEFAULT '',
+    occurrences INT NOT NULL DEFAULT 1,
+    parent_id UUID,
+    escalated_at TIMESTAMPTZ
+);
+
+CREATE TABLE actions (
+    id UUID PRIMARY KEY,
+    incident_id UUID REFERENCES incidents(id),
+    proposed_at_tick BIGINT NOT NULL,
+    action_type INT NOT NULL,
+    target_id TEXT NOT NULL,
+    status INT NOT NULL,
+    reason TEXT,
+    parameters JSONB,
+    created_at TIMESTAMPTZ NOT NULL,
+    executed_at TIMESTAMPTZ,
+    result_message TEXT,
+    reason_key TEXT NOT NULL DEFAULT '',
+    reason_args JSONB
+);
+
+CREATE TABLE incident_audit (
+    id BIGSERIAL PRIMARY KEY,
+    incident_id UUID NOT NULL,
+    operation TEXT NOT NULL,
+    actor TEXT NOT NULL DEFAULT '',
+    reason TEXT NOT NULL DEFAULT '',
+    related_ids TEXT[],
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE detection_rules (
+    name TEXT PRIMARY KEY,
+    metric_name TEXT NOT NULL,
+    operator TEXT NOT NULL,
+    threshold DOUBLE PRECISION NOT NULL,
+    window_seconds INT NOT NULL,
+    severity INT NOT NULL,
+    region TEXT NOT NULL DEFAULT '',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL,
+    type TEXT NOT NULL DEFAULT 'threshold',
+    trigger_ratio DOUBLE PRECISION NOT NULL DEFAULT 0,
+    clear_ratio DOUBLE PRECISION NOT NULL DEFAULT 0,
+    clear_seconds INT NOT NULL DEFAULT 0,
+    selector JSONB NOT NULL DEFAULT '{}',
+    overrides JSONB NOT NULL DEFAULT '[]',
+    min_samples INT NOT NULL DEFAULT 0,
+    evaluate_every_seconds INT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE suppression_windows (
+    id UUID PRIMARY KEY,
+    entity_id TEXT NOT NULL DEFAULT '',
+    rule_name TEXT NOT NULL DEFAULT '',
+    starts_at TIMESTAMPTZ NOT NULL,
+    ends_at TIMESTAMPTZ NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_by TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE rule_stats (
+    rule_name TEXT PRIMARY KEY,
+    evaluations BIGINT NOT NULL DEFAULT 0,
+    breaches BIGINT NOT NULL DEFAULT 0,
+    incidents_fired BIGINT NOT NULL DEFAULT 0,
+    false_positives BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE incident_labels (
+    incident_id UUID PRIMARY KEY,
+    label TEXT NOT NULL,
+    operator TEXT NOT NULL DEFAULT '',
+    note TEXT NOT NULL DEFAULT '',
+    labeled_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE policy_decisions (
+    id UUID PRIMARY KEY,
+    action_id UUID NOT NULL,
+    kind TEXT NOT NULL,
+    allowed BOOLEAN NOT NULL,
+    reasons TEXT[],
+    actor TEXT NOT NULL DEFAULT '',
+    bundle_revision TEXT NOT NULL DEFAULT '',
+    input JSONB NOT NULL,
+    result JSONB,
+    decided_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE table_versions (
+    name TEXT PRIMARY KEY,
+    version BIGINT NOT NULL
+);
@@ -1,7 +1,12 @@
 package storage
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -45,3 +50,167 @@ func TestConfigFromEnv(t *testing.T) {
 		t.Errorf("unexpected database: %s", cfg.Database)
 	}
 }
+
+func TestConfigEnvironment(t *testing.T) {
+	cfg := DefaultConfig()
+	if cfg.Schema() != "" {
+		t.Errorf("default environment should use the public schema, got %q", cfg.Schema())
+	}
+
+	cfg.Environment = "prod-sim"
+	if cfg.Schema() != "env_prod_sim" {
+		t.Errorf("unexpected schema: %s", cfg.Schema())
+	}
+	// TimescaleDB's own schemas hold the environment's chunks
+	for _, arg := range dumpArgs(cfg, "/tmp/db.dump") {
+		if strings.HasPrefix(arg, "--schema") {
+			t.Errorf("pg_dump must dump the whole database, got %s", arg)
+		}
+	}
+
+	cfg.Environment = "dev;drop"
+	if _, err := New(context.Background(), cfg); err == nil {
+		t.Error("an invalid environment must be rejected before connecting")
+	}
+}
+
+func TestBackupArgs(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Password = "s3cret"
+	dsn := "--dbname=postgres://" + cfg.User + "@" + cfg.Host + ":5432/" + cfg.Database + "?sslmode=" + cfg.SSLMode
+
+	dump := dumpArgs(cfg, "/tmp/db.dump")
+	if dump[0] != "--format=custom" || dump[1] != "--file=/tmp/db.dump" || dump[2] != dsn {
+		t.Errorf("unexpected pg_dump args: %v", dump)
+	}
+	// The password goes in PGPASSWORD, out of the process list
+	for _, arg := range append(dump, restoreArgs(cfg, "/tmp/db.dump")...) {
+		if strings.Contains(arg, cfg.Password) {
+			t.Errorf("backup args must not carry the password: %s", arg)
+		}
+	}
+
+	restore := restoreArgs(cfg, "/tmp/db.dump")
+	if restore[len(restore)-1] != "/tmp/db.dump" {
+		t.Errorf("archive must be the last pg_restore arg: %v", restore)
+	}
+	found := false
+	for _, arg := range restore {
+		if arg == "--clean" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("pg_restore must drop existing objects: %v", restore)
+	}
+}
+
+func TestRefChecks(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, c := range refChecks {
+		key := c.table + "." + c.column
+		if seen[key] {
+			t.Errorf("duplicate check for %s", key)
+		}
+		seen[key] = true
+		if c.find == "" || c.repair == "" {
+			t.Errorf("%s needs both find and repair queries", key)
+		}
+	}
+	for _, key := range []string{"actions.incident_id", "incidents.merged_into", "incidents.split_from", "incidents.parent_id", "incident_audit.incident_id", "incident_labels.incident_id"} {
+		if !seen[key] {
+			t.Errorf("missing check for %s", key)
+		}
+	}
+}
+
+func TestCoerceEnums(t *testing.T) {
+	incident := IncidentRow{Severity: 9}
+	coerceIncident(&incident)
+	if incident.Severity != 0 {
+		t.Errorf("unknown severity not reset: %d", incident.Severity)
+	}
+
+	action := ActionRow{ActionType: 2, Status: 42}
+	coerceAction(&action)
+	if action.Status != ActionStatusQuarantined || action.ActionType != 2 {
+		t.Errorf("unknown status not quarantined: %+v", action)
+	}
+
+	action = ActionRow{ActionType: -1, Status: 1}
+	coerceAction(&action)
+	if action.Status != ActionStatusQuarantined || action.ActionType != 0 {
+		t.Errorf("unknown type not quarantined: %+v", action)
+	}
+
+	valid := ActionRow{ActionType: 14, Status: 5}
+	coerceAction(&valid)
+	if valid.ActionType != 14 || valid.Status != 5 {
+		t.Errorf("valid action changed: %+v", valid)
+	}
+
+	if err := checkEnum("status", 8, maxActionStatus); !errors.Is(err, ErrInvalidEnum) {
+		t.Errorf("expected ErrInvalidEnum, got %v", err)
+	}
+}
+
+func TestErrorPredicates(t *testing.T) {
+	notFound := fmt.Errorf("get action %s: %w", "a1", ErrNotFound)
+	if !IsNotFound(notFound) {
+		t.Error("wrapped ErrNotFound not recognised")
+	}
+	if IsNotFound(errors.New("get action: connection reset")) {
+		t.Error("unrelated error reported as not found")
+	}
+	if IsNotFound(nil) {
+		t.Error("nil reported as not found")
+	}
+	if !IsInvalidEnum(checkEnum("status", 99, maxActionStatus)) {
+		t.Error("out-of-range enum not reported as invalid")
+	}
+	exists := fmt.Errorf("create rule %s: %w", "high_cpu", ErrExists)
+	if !IsExists(exists) || IsNotFound(exists) {
+		t.Error("wrapped ErrExists not told apart from ErrNotFound")
+	}
+	conflict := fmt.Errorf("merge incident %s: already merged: %w", "i1", ErrConflict)
+	if !IsConflict(conflict) || IsNotFound(conflict) {
+		t.Error("wrapped ErrConflict not told apart from ErrNotFound")
+	}
+}
+
+func TestRuleSeverityChecked(t *testing.T) {
+	// Severity is checked before the database is touched
+	r := &RulesRepository{}
+	err := r.Create(context.Background(), RuleRow{Name: "bad", Severity: maxIncidentSeverity + 1})
+	if !IsInvalidEnum(err) {
+		t.Errorf("expected ErrInvalidEnum from Create, got %v", err)
+	}
+	err = r.Update(context.Background(), RuleRow{Name: "bad", Severity: -1})
+	if !IsInvalidEnum(err) {
+		t.Errorf("expected ErrInvalidEnum from Update, got %v", err)
+	}
+}
+
+func TestIncidentLabelChecked(t *testing.T) {
+	// Labels are checked before the database is touched
+	r := &IncidentsRepository{}
+	if _, err := r.SetLabel(context.Background(), IncidentLabelRow{IncidentID: "i1", Label: "wrong"}); !IsInvalidEnum(err) {
+		t.Errorf("expected ErrInvalidEnum from SetLabel, got %v", err)
+	}
+	if _, err := r.ListLabeled(context.Background(), "wrong", time.Time{}, 10); !IsInvalidEnum(err) {
+		t.Errorf("expected ErrInvalidEnum from ListLabeled, got %v", err)
+	}
+	for _, label := range incidentLabels {
+		if err := checkLabel(label); err != nil {
+			t.Errorf("label %s rejected: %v", label, err)
+		}
+	}
+}
+
+func TestPolicyDecisionKindChecked(t *testing.T) {
+	// Kinds are checked before the database is touched
+	r := &PolicyDecisionsRepository{}
+	if err := r.Create(context.Background(), PolicyDecisionRow{ActionID: "a1", Kind: "wrong"}); !IsInvalidEnum(err) {
+		t.Errorf("expected ErrInvalidEnum from Create, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/microcloud/storage/queries"
+)
+
+// SuppressionRow represents a suppression window in the database: a time
+// range during which incidents matching its entity and rule are not
+// published
+type SuppressionRow struct {
+	ID        string
+	EntityID  string // empty matches every entity
+	RuleName  string // empty matches every rule
+	StartsAt  time.Time
+	EndsAt    time.Time
+	Reason    string
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+// SuppressionsRepository handles suppression window persistence
+type SuppressionsRepository struct {
+	conn conn
+}
+
+// NewSuppressionsRepository creates a new suppressions repository
+func NewSuppressionsRepository(db *DB) *SuppressionsRepository {
+	return &SuppressionsRepository{conn: db.pool}
+}
+
+// WithTx returns a copy of the repository that runs in tx
+func (r *SuppressionsRepository) WithTx(tx pgx.Tx) *SuppressionsRepository {
+	return &SuppressionsRepository{conn: tx}
+}
+
+// List returns the windows that end after endsAfter, by start. Pass the
+// zero time to include expired windows.
+func (r *SuppressionsRepository) List(ctx context.Context, endsAfter time.Time) ([]SuppressionRow, error) {
+	rows, err := queries.New(r.conn).ListSuppressionWindows(ctx, endsAfter)
+	if err != nil {
+		return nil, fmt.Errorf("list suppression windows: %w", err)
+	}
+	results := make([]SuppressionRow, 0, len(rows))
+	for _, row := range rows {
+		results = append(results, suppressionRow(row))
+	}
+	return results, nil
+}
+
+// Get retrieves a window by ID. It returns ErrNotFound if there is no such
+// window.
+func (r *SuppressionsRepository) Get(ctx context.Context, id string) (*SuppressionRow, error) {
+	row, err := queries.New(r.conn).GetSuppressionWindow(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("get suppression window %s: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get suppression window: %w", err)
+	}
+	window := suppressionRow(row)
+	return &window, nil
+}
+
+// Create inserts a new window
+func (r *SuppressionsRepository) Create(ctx context.Context, window SuppressionRow) error {
+	err := queries.New(r.conn).InsertSuppressionWindow(ctx, queries.InsertSuppressionWindowParams{
+		ID:        window.ID,
+		EntityID:  window.EntityID,
+		RuleName:  window.RuleName,
+		StartsAt:  window.StartsAt,
+		EndsAt:    window.EndsAt,
+		Reason:    window.Reason,
+		CreatedBy: window.CreatedBy,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("create suppression window: %w", err)
+	}
+	return nil
+}
+
+// Delete removes a window. It returns ErrNotFound if there is no such
+// window.
+func (r *SuppressionsRepository) Delete(ctx context.Context, id string) error {
+	n, err := queries.New(r.conn).DeleteSuppressionWindow(ctx, id)
+	if err != nil {
+		return fmt.Errorf("delete suppression window: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("delete suppression window %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+func suppressionRow(w queries.SuppressionWindow) SuppressionRow {
+	return SuppressionRow{
+		ID:        w.ID,
+		EntityID:  w.EntityID,
+		RuleName:  w.RuleName,
+		StartsAt:  w.StartsAt,
+		EndsAt:    w.EndsAt,
+		Reason:    w.Reason,
+		CreatedBy: w.CreatedBy,
+		CreatedAt: w.CreatedAt,
+	}
+}
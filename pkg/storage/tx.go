@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/microcloud/storage/queries"
+)
+
+// Queries in sql/queries are compiled to the queries package by sqlc. New
+// tables go in sql/schema.sql as well as Migrate.
+//go:generate sqlc generate
+
+// conn is satisfied by both the pool and a transaction. Begin on a
+// transaction starts a savepoint, so repository methods that need their own
+// transaction nest inside DB.WithTx.
+type conn interface {
+	queries.DBTX
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// Queries bundles the repositories and generated queries, all running on
+// the same connection or transaction
+type Queries struct {
+	Metrics      *MetricsRepository
+	Incidents    *IncidentsRepository
+	Actions      *ActionsRepository
+	Rules        *RulesRepository
+	Suppressions *SuppressionsRepository
+	SQL          *queries.Queries // sqlc-generated queries for the remaining tables
+}
+
+func newQueries(c conn) Queries {
+	return Queries{
+		Metrics:      &MetricsRepository{conn: c},
+		Incidents:    &IncidentsRepository{conn: c},
+		Actions:      &ActionsRepository{conn: c},
+		Rules:        &RulesRepository{conn: c},
+		Suppressions: &SuppressionsRepository{conn: c},
+		SQL:          queries.New(c),
+	}
+}
+
+// Queries returns the repositories and generated queries bound to the pool
+func (db *DB) Queries() Queries {
+	return newQueries(db.pool)
+}
+
+// WithTx runs fn in a transaction, committing if it returns nil and rolling
+// back otherwise. Everything done through q is part of the transaction.
+func (db *DB) WithTx(ctx context.Context, fn func(q Queries) error) error {
+	return inTx(ctx, db.pool, func(tx pgx.Tx) error {
+		return fn(newQueries(tx))
+	})
+}
+
+// inTx runs fn in a transaction on c, or a savepoint if c is one
+func inTx(ctx context.Context, c conn, fn func(tx pgx.Tx) error) error {
+	tx, err := c.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// tableVersion returns the write counter of table, 0 before its first write.
+// It is a primary key lookup, far cheaper than the reads it guards.
+func tableVersion(ctx context.Context, c conn, table string) (int64, error) {
+	var version int64
+	err := c.QueryRow(ctx, `SELECT COALESCE((SELECT version FROM table_versions WHERE name = $1), 0)`, table).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("get %s version: %w", table, err)
+	}
+	return version, nil
+}
@@ -39,12 +39,18 @@ export async function rejectAction(actionId: string, reason: string): Promise<{
   return response.json()
 }
 
+// Last response per list RPC, reused when the server reports Not-Modified
+const etagCache = new Map<string, { etag: string; body: unknown }>()
+
 export async function listPendingActions(limit: number = 50) {
+  const key = `ListPendingActions:${limit}`
+  const cached = etagCache.get(key)
   const response = await fetch(`${API_BASE}/ops.v1.ActionService/ListPendingActions`, {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json',
       'Connect-Protocol-Version': '1',
+      ...(cached ? { 'If-None-Match': cached.etag } : {}),
     },
     body: JSON.stringify({ limit }),
   })
@@ -53,7 +59,16 @@ export async function listPendingActions(limit: number = 50) {
     throw new Error(`Failed to list actions: ${response.statusText}`)
   }
 
-  return response.json()
+  if (cached && response.headers.get('Not-Modified') === 'true') {
+    return cached.body
+  }
+
+  const body = await response.json()
+  const etag = response.headers.get('ETag')
+  if (etag) {
+    etagCache.set(key, { etag, body })
+  }
+  return body
 }
 
 export async function getActionHistory(limit: number = 100) {
@@ -72,3 +87,63 @@ export async function getActionHistory(limit: number = 100) {
 
   return response.json()
 }
+
+// SimulationControl RPCs are served through the orchestrator gateway,
+// so the dashboard never talks to the sim-engine directly.
+export async function getSimulationState() {
+  const response = await fetch(`${API_BASE}/sim.v1.SimulationControl/GetState`, {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+      'Connect-Protocol-Version': '1',
+    },
+    body: JSON.stringify({}),
+  })
+
+  if (!response.ok) {
+    throw new Error(`Failed to get simulation state: ${response.statusText}`)
+  }
+
+  return response.json()
+}
+
+export async function setSimulationState(state: string) {
+  const response = await fetch(`${API_BASE}/sim.v1.SimulationControl/SetState`, {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+      'Connect-Protocol-Version': '1',
+    },
+    body: JSON.stringify({ state }),
+  })
+
+  if (!response.ok) {
+    throw new Error(`Failed to set simulation state: ${response.statusText}`)
+  }
+
+  return response.json()
+}
+
+// Set NEXT_PUBLIC_STREAM_CREDENTIALS when the orchestrator lists this origin
+// in CORS_ALLOWED_ORIGINS, so the stream token cookie is stored and sent
+export const STREAM_CREDENTIALS = process.env.NEXT_PUBLIC_STREAM_CREDENTIALS === 'true'
+
+// createStreamToken mints a short-lived token for opening the SSE stream,
+// which cannot carry auth headers
+export async function createStreamToken(): Promise<{ token: string; expiresAtUnixMs: string }> {
+  const response = await fetch(`${API_BASE}/ops.v1.AuthService/CreateStreamToken`, {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+      'Connect-Protocol-Version': '1',
+    },
+    credentials: STREAM_CREDENTIALS ? 'include' : 'same-origin',
+    body: JSON.stringify({}),
+  })
+
+  if (!response.ok) {
+    throw new Error(`Failed to create stream token: ${response.statusText}`)
+  }
+
+  return response.json()
+}
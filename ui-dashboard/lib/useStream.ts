@@ -1,6 +1,7 @@
 'use client'
 
 import { useEffect, useState, useCallback } from 'react'
+import { createStreamToken, STREAM_CREDENTIALS } from './api'
 
 export interface MetricSnapshot {
   timestamp: {
@@ -22,6 +23,7 @@ export interface Node {
   diskUsagePercent: number
   runningServices: number
   availabilityZone: string
+  region: string
 }
 
 export interface Service {
@@ -35,6 +37,9 @@ export interface Service {
   latencyP99Ms: number
   replicaCount: number
   desiredReplicas: number
+  region: string
+  queueDepth: number
+  consumerLagMs: number
 }
 
 export interface TrafficStats {
@@ -42,6 +47,7 @@ export interface TrafficStats {
   totalErrorRate: number
   avgLatencyMs: number
   activeConnections: string
+  totalQueueDepth: number
 }
 
 export interface Incident {
@@ -71,10 +77,43 @@ export interface Action {
 }
 
 interface StreamEvent {
-  type: 'metrics' | 'incident' | 'action'
-  payload: MetricSnapshot | Incident | Action
+  type: 'metrics' | 'incident' | 'action' | 'history'
+  payload: MetricSnapshot | Incident | Action | HistoryPage
 }
 
+// HistoryPage is a page of incidents and actions replayed from before the
+// stream connected, oldest first
+interface HistoryPage {
+  page: number
+  last: boolean
+  items: StreamEvent[]
+  error?: string
+}
+
+interface PollResponse {
+  cursor: string
+  reset?: boolean
+  events: StreamEvent[]
+}
+
+// SSE connects that fail before ever opening, in a row, after which the
+// hook falls back to long polling; some proxies break SSE outright
+const SSE_ATTEMPTS = 3
+
+// How far back a new SSE connection asks to have incidents and actions
+// replayed, so the lists are not empty after a reload
+const STREAM_HISTORY = '15m'
+
+// pollURL returns the long-poll endpoint next to an SSE stream URL
+export function pollURL(streamUrl: string): string {
+  return streamUrl.replace(/\/api\/stream(?=$|\?)/, '/api/poll')
+}
+
+const withParam = (url: string, name: string, value: string) =>
+  url + (url.includes('?') ? '&' : '?') + name + '=' + encodeURIComponent(value)
+
+const sleep = (ms: number) => new Promise((resolve) => setTimeout(resolve, ms))
+
 export function useStream(url: string) {
   const [connected, setConnected] = useState(false)
   const [metrics, setMetrics] = useState<MetricSnapshot | null>(null)
@@ -82,52 +121,139 @@ export function useStream(url: string) {
   const [actions, setActions] = useState<Action[]>([])
 
   useEffect(() => {
-    const eventSource = new EventSource(url)
+    let eventSource: EventSource | null = null
+    let retry: ReturnType<typeof setTimeout> | undefined
+    let closed = false
+    let opened = false
+    let failures = 0
+    const abort = new AbortController()
 
-    eventSource.onopen = () => {
-      setConnected(true)
+    const streamToken = async () => {
+      try {
+        const { token } = await createStreamToken()
+        return token
+      } catch (e) {
+        // Auth may be disabled; try without a token
+        console.warn('Failed to create stream token:', e)
+        return ''
+      }
     }
 
-    eventSource.onmessage = (event) => {
-      try {
-        const data: StreamEvent = JSON.parse(event.data)
-
-        switch (data.type) {
-          case 'metrics':
-            setMetrics(data.payload as MetricSnapshot)
-            break
-          case 'incident':
-            setIncidents((prev) => {
-              const incident = data.payload as Incident
-              const exists = prev.some((i) => i.id.value === incident.id.value)
-              if (exists) return prev
-              return [incident, ...prev].slice(0, 50)
-            })
-            break
-          case 'action':
-            setActions((prev) => {
-              const action = data.payload as Action
-              const idx = prev.findIndex((a) => a.id.value === action.id.value)
-              if (idx >= 0) {
-                const updated = [...prev]
-                updated[idx] = action
-                return updated
-              }
-              return [action, ...prev].slice(0, 50)
-            })
-            break
+    // A token only has to be valid when the stream opens, so mint a fresh
+    // one for every connect rather than letting EventSource reuse the URL
+    const connect = async () => {
+      const token = await streamToken()
+      if (closed) return
+
+      let streamUrl = withParam(url, 'history', STREAM_HISTORY)
+      if (token) streamUrl = withParam(streamUrl, 'token', token)
+      eventSource = new EventSource(streamUrl, { withCredentials: STREAM_CREDENTIALS })
+      listen(eventSource)
+    }
+
+    const handle = (data: StreamEvent) => {
+      switch (data.type) {
+        case 'metrics':
+          setMetrics(data.payload as MetricSnapshot)
+          break
+        case 'incident':
+          setIncidents((prev) => {
+            // Resolutions arrive as the same incident with resolved set
+            const incident = data.payload as Incident
+            const idx = prev.findIndex((i) => i.id.value === incident.id.value)
+            if (idx >= 0) {
+              const updated = [...prev]
+              updated[idx] = incident
+              return updated
+            }
+            return [incident, ...prev].slice(0, 50)
+          })
+          break
+        case 'action':
+          setActions((prev) => {
+            const action = data.payload as Action
+            const idx = prev.findIndex((a) => a.id.value === action.id.value)
+            if (idx >= 0) {
+              const updated = [...prev]
+              updated[idx] = action
+              return updated
+            }
+            return [action, ...prev].slice(0, 50)
+          })
+          break
+        case 'history':
+          ;(data.payload as HistoryPage).items.forEach(handle)
+          break
+      }
+    }
+
+    const listen = (eventSource: EventSource) => {
+      eventSource.onopen = () => {
+        opened = true
+        setConnected(true)
+      }
+
+      eventSource.onmessage = (event) => {
+        try {
+          handle(JSON.parse(event.data))
+        } catch (e) {
+          console.error('Failed to parse event:', e)
         }
-      } catch (e) {
-        console.error('Failed to parse event:', e)
+      }
+
+      eventSource.onerror = () => {
+        setConnected(false)
+        if (eventSource.readyState !== EventSource.CLOSED || closed) return
+        if (!opened && ++failures >= SSE_ATTEMPTS) {
+          console.warn('SSE unavailable, falling back to long polling')
+          poll()
+          return
+        }
+        retry = setTimeout(connect, 2000)
       }
     }
 
-    eventSource.onerror = () => {
-      setConnected(false)
+    // poll long-polls for events after the last cursor until unmounted.
+    // The token is only minted again when the server rejects it.
+    const poll = async () => {
+      let cursor = ''
+      let token = await streamToken()
+      while (!closed) {
+        try {
+          let pollUrl = pollURL(url)
+          if (cursor) pollUrl = withParam(pollUrl, 'cursor', cursor)
+          if (token) pollUrl = withParam(pollUrl, 'token', token)
+          const response = await fetch(pollUrl, {
+            credentials: STREAM_CREDENTIALS ? 'include' : 'same-origin',
+            signal: abort.signal,
+          })
+          if (response.status === 401) {
+            token = await streamToken()
+            throw new Error('poll unauthorized')
+          }
+          if (response.status === 304) continue
+          if (!response.ok) throw new Error(`poll failed: ${response.statusText}`)
+
+          const body: PollResponse = await response.json()
+          setConnected(true)
+          body.events.forEach(handle)
+          cursor = body.cursor
+        } catch (e) {
+          if (closed) return
+          console.warn('Poll failed:', e)
+          setConnected(false)
+          await sleep(2000)
+        }
+      }
     }
 
+    connect()
+
     return () => {
-      eventSource.close()
+      closed = true
+      clearTimeout(retry)
+      abort.abort()
+      eventSource?.close()
     }
   }, [url])
 